@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -196,7 +197,7 @@ func main() {
 			Flags: []cli.Flag{
 				&cli.StringFlag{
 					Name:        "server",
-					Usage:       "datastream server address to connect (IP:port)",
+					Usage:       "datastream server address to connect (IP:port), comma separated to fail over to the next ones",
 					Value:       streamServerURL,
 					DefaultText: streamServerURL,
 				},
@@ -233,6 +234,46 @@ func main() {
 			},
 			Action: runRelay,
 		},
+		{
+			Name:      "fsck",
+			Aliases:   []string{},
+			Usage:     "Check the consistency of a datastream file and its bookmarks",
+			ArgsUsage: "file.bin",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "skip",
+					Usage: "checks to skip (magic-numbers|header|page-checksums|entry-numbers|bookmarks|totals)",
+				},
+				&cli.StringFlag{
+					Name:        "log",
+					Usage:       logLevelInfo,
+					Value:       "error",
+					DefaultText: "error",
+				},
+			},
+			Action: runFsck,
+		},
+		{
+			Name:      "split",
+			Aliases:   []string{},
+			Usage:     "Split a datastream file into one file per entry type",
+			ArgsUsage: "file.bin",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "out",
+					Usage:       "output directory for the split files",
+					Value:       "split",
+					DefaultText: "split",
+				},
+				&cli.StringFlag{
+					Name:        "log",
+					Usage:       logLevelInfo,
+					Value:       "info",
+					DefaultText: "info",
+				},
+			},
+			Action: runSplit,
+		},
 	}
 
 	err := app.Run(os.Args)
@@ -1063,13 +1104,15 @@ func runRelay(ctx *cli.Context) error {
 	writeTimeout := ctx.Uint64("writetimeout")
 	inactivityTimeout := ctx.Uint64("inactivitytimeout")
 
-	// Create relay server
-	r, err := datastreamer.NewRelay(server, uint16(port), streamerVersion, streamerSystemID, StSequencer, file,
+	// Create relay server, failing over to the next upstream servers
+	servers := strings.Split(server, ",")
+	r, err := datastreamer.NewRelay(servers[0], uint16(port), streamerVersion, streamerSystemID, StSequencer, file,
 		time.Duration(writeTimeout)*time.Millisecond, time.Duration(inactivityTimeout)*time.Second,
 		5*time.Second, nil) //nolint:mnd
 	if err != nil {
 		return err
 	}
+	r.SetFailoverUpstreams(servers[1:]...)
 
 	// Start relay server
 	err = r.Start()
@@ -1086,3 +1129,72 @@ func runRelay(ctx *cli.Context) error {
 	log.Info(">> App end")
 	return nil
 }
+
+// runFsck checks a datastream file and exits with error if it's not consistent
+func runFsck(ctx *cli.Context) error {
+	// Set log level
+	logLevel := ctx.String("log")
+	log.Init(log.Config{
+		Environment: "development",
+		Level:       logLevel,
+		Outputs:     []string{"stdout"},
+	})
+
+	// Parameters
+	file := ctx.Args().First()
+	if file == "" {
+		return errors.New("missing datastream file parameter")
+	}
+
+	checker := datastreamer.NewConsistencyChecker(file)
+	for _, name := range ctx.StringSlice("skip") {
+		known := false
+		for _, check := range datastreamer.AllChecks {
+			if string(check) == name {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown check: %s", name)
+		}
+		checker.SetCheck(datastreamer.CheckName(name), false)
+	}
+
+	// Run the checks and print the report
+	report, err := checker.Check()
+	if err != nil {
+		return err
+	}
+	report.Print(os.Stdout)
+
+	if !report.OK() {
+		return errors.New("datastream file is not consistent")
+	}
+	return nil
+}
+
+// runSplit splits a datastream file into one file per entry type
+func runSplit(ctx *cli.Context) error {
+	// Set log level
+	logLevel := ctx.String("log")
+	log.Init(log.Config{
+		Environment: "development",
+		Level:       logLevel,
+		Outputs:     []string{"stdout"},
+	})
+
+	// Parameters
+	file := ctx.Args().First()
+	if file == "" {
+		return errors.New("missing datastream file parameter")
+	}
+
+	fileNames, err := datastreamer.SplitByType(file, ctx.String("out"))
+	if err != nil {
+		return err
+	}
+	for etype, fileName := range fileNames {
+		fmt.Printf("Entry type %d: %s (mapping %s)\n", etype, fileName, datastreamer.SplitMappingName(fileName))
+	}
+	return nil
+}
@@ -21,4 +21,17 @@ type Config struct {
 	InactivityCheckInterval time.Duration
 	// Log
 	Log log.Config `mapstructure:"Log"`
+	// Streams served by a server group on Port, each one with its own stream file settings (see
+	// NewServerGroupFromConfig)
+	Streams []StreamConfig `mapstructure:"Streams"`
+}
+
+// StreamConfig type for the settings of each stream of a multi-stream server
+type StreamConfig struct {
+	// StreamType of the stream
+	StreamType StreamType `mapstructure:"StreamType"`
+	// Filename of the binary data file of the stream
+	Filename string `mapstructure:"Filename"`
+	// PageSize of the data pages of a new stream file (0: PageDataSize), existing files keep their page size
+	PageSize uint32 `mapstructure:"PageSize"`
 }
@@ -105,6 +105,7 @@ var (
 		WriteTimeout: 3 * time.Second,
 	}
 	leveldb      = config.Filename[0:strings.IndexRune(config.Filename, '.')] + ".db"
+	journaldb    = config.Filename[0:strings.IndexRune(config.Filename, '.')] + ".journal"
 	streamServer *datastreamer.StreamServer
 	streamType   = datastreamer.StreamType(1)
 	entryType1   = datastreamer.EntryType(1)
@@ -193,6 +194,12 @@ func deleteFiles() error {
 		return err
 	}
 
+	// Delete commit journal folder from filesystem
+	err = os.RemoveAll(journaldb)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	return nil
 }
 
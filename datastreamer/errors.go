@@ -1,6 +1,10 @@
 package datastreamer
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
 
 var (
 	// ErrInvalidCommand is returned when the command is invalid
@@ -67,6 +71,8 @@ var (
 	ErrUpdateNotAllowed = fmt.Errorf("update not allowed, it's in current atomic operation")
 	// ErrClientAlreadyStarted is returned when the client is already started
 	ErrClientAlreadyStarted = fmt.Errorf("client already started")
+	// ErrAlreadyStreaming is returned when a client already streaming sends a start command
+	ErrAlreadyStreaming = fmt.Errorf("client already streaming")
 	// ErrClientAlreadyStopped is returned when the client is already stopped
 	ErrClientAlreadyStopped = fmt.Errorf("client already stopped")
 	// ErrHeaderCommandNotAllowed is returned when the header command is not allowed
@@ -95,4 +101,138 @@ var (
 	ErrBookmarkMaxLength = fmt.Errorf("bookmark max length")
 	// ErrInvalidBookmarkRange is returned when the bookmark range is invalid
 	ErrInvalidBookmarkRange = fmt.Errorf("invalid bookmark range")
+	// ErrEntryNumberNotContiguous is returned when a caller-provided entry number is not contiguous with the tail
+	ErrEntryNumberNotContiguous = fmt.Errorf("entry number not contiguous with the tail")
+	// ErrNumberingModeMismatch is returned when mixing caller-provided and automatic entry numbers in a file
+	ErrNumberingModeMismatch = fmt.Errorf("mixing caller-provided and automatic entry numbers not allowed")
+	// ErrUnknownMetadataCodec is returned when the metadata codec is not registered
+	ErrUnknownMetadataCodec = fmt.Errorf("unknown metadata codec")
+	// ErrMetadataCodecRegistered is returned when registering a metadata codec with an ID already in use
+	ErrMetadataCodecRegistered = fmt.Errorf("metadata codec already registered")
+	// ErrMetadataCodecMismatch is returned when the metadata codec doesn't match the one recorded in the file
+	ErrMetadataCodecMismatch = fmt.Errorf("metadata codec doesn't match the file header")
+	// ErrDecodingMetadata is returned when there is an error decoding the metadata section
+	ErrDecodingMetadata = fmt.Errorf("error decoding metadata")
+	// ErrEntryTypeRegistered is returned when registering an entry type already registered
+	ErrEntryTypeRegistered = fmt.Errorf("entry type already registered")
+	// ErrEntryTypeNotRegistered is returned when the entry type is not registered
+	ErrEntryTypeNotRegistered = fmt.Errorf("entry type not registered")
+	// ErrStartBeyondTail is returned when the iterator start entry is greater than the total entries
+	ErrStartBeyondTail = fmt.Errorf("iterator start entry beyond the tail")
+	// ErrDeadLetterHandlerMissing is returned when setting the dead-letter failure action without a handler
+	ErrDeadLetterHandlerMissing = fmt.Errorf("dead-letter failure action without handler")
+	// ErrCommitNotFound is returned when there is no commit record in the journal for the entry
+	ErrCommitNotFound = fmt.Errorf("commit record not found")
+	// ErrCommitJournalDisabled is returned when using the commit journal without enabling it
+	ErrCommitJournalDisabled = fmt.Errorf("commit journal not enabled")
+	// ErrDecodingCommitRecord is returned when there is an error decoding a commit record of the journal
+	ErrDecodingCommitRecord = fmt.Errorf("error decoding commit record")
+	// ErrOutputFileExists is returned when an output file to create already exists
+	ErrOutputFileExists = fmt.Errorf("output file already exists")
+	// ErrEntryTombstoned is returned when the entry is logically deleted
+	ErrEntryTombstoned = fmt.Errorf("entry tombstoned")
+	// ErrInvalidEntryType is returned when adding an entry with the reserved highest bit of the entry type set
+	ErrInvalidEntryType = fmt.Errorf("invalid entry type, highest bit reserved")
+	// ErrSlowClient is returned when the bytes pending to be sent to a client reach the maximum allowed
+	ErrSlowClient = fmt.Errorf("slow client, max in-flight bytes reached")
+	// ErrTransformingEntry is returned when the read transform of an entry streamed to a client fails
+	ErrTransformingEntry = fmt.Errorf("error transforming entry data")
+	// ErrVersionMigrationRegistered is returned when registering a migration from a version already registered
+	ErrVersionMigrationRegistered = fmt.Errorf("version migration already registered")
+	// ErrInvalidTargetVersion is returned when migrating a stream file to a version not newer than its own
+	ErrInvalidTargetVersion = fmt.Errorf("invalid target version, must be newer than the file version")
+	// ErrServerClosed is returned when a commit can't be flushed because the server is closed
+	ErrServerClosed = fmt.Errorf("server closed")
+	// ErrDecryptionFailed is returned when the data of an encrypted file can't be decrypted (wrong key or corrupted)
+	ErrDecryptionFailed = fmt.Errorf("decryption failed")
+	// ErrEncryptionKeyMissing is returned when opening an encrypted file without its encryption key
+	ErrEncryptionKeyMissing = fmt.Errorf("encryption key missing")
+	// ErrFileNotEncrypted is returned when opening with an encryption key a file with entries stored in plaintext
+	ErrFileNotEncrypted = fmt.Errorf("file not encrypted")
+	// ErrFileEncrypted is returned when an offline operation that copies the stored entries gets an encrypted file
+	ErrFileEncrypted = fmt.Errorf("operation not supported on an encrypted file")
+	// ErrStreamFileReadOnly is returned when writing to a stream file opened just for reading
+	ErrStreamFileReadOnly = fmt.Errorf("stream file opened read-only")
+	// ErrServerGroupStarted is returned when adding to a server group once started, or a server already started
+	ErrServerGroupStarted = fmt.Errorf("server group or server already started")
+	// ErrStreamTypeInGroup is returned when adding to a server group a server of a stream type already in it
+	ErrStreamTypeInGroup = fmt.Errorf("stream type already in the server group")
+	// ErrCapabilitiesCommandNotAllowed is returned when a capabilities command is not allowed
+	ErrCapabilitiesCommandNotAllowed = fmt.Errorf("capabilities command not allowed")
+	// ErrDecodingCapabilities is returned when the server capabilities received can't be decoded
+	ErrDecodingCapabilities = fmt.Errorf("error decoding server capabilities")
+	// ErrFileLocked is returned when opening for writing a stream file locked by another writer
+	ErrFileLocked = fmt.Errorf("stream file locked by another writer")
+	// ErrOffsetOutOfFile is returned when describing an offset beyond the stream file size
+	ErrOffsetOutOfFile = fmt.Errorf("offset out of the stream file")
+	// ErrBookmarkTargetPruned is returned when a bookmark points to an entry removed by a truncate or a rollback
+	ErrBookmarkTargetPruned = fmt.Errorf("bookmark target entry pruned")
+	// ErrWritesPaused is returned when starting an atomic operation while the writes are paused
+	ErrWritesPaused = fmt.Errorf("writes paused")
+	// ErrDecodingResyncSummary is returned when the client summary of a resync command is invalid
+	ErrDecodingResyncSummary = fmt.Errorf("invalid resync summary")
+	// ErrStreamDivergence is returned when the last entry of a client resyncing is not the same in the stream
+	ErrStreamDivergence = fmt.Errorf("client state diverged from the stream")
+	// ErrInvalidPageSize is returned when creating a stream file with a data page size below the minimum
+	ErrInvalidPageSize = fmt.Errorf("invalid data page size")
+	// ErrPageSizeMismatch is returned when opening a stream file with a data page size different from its header
+	ErrPageSizeMismatch = fmt.Errorf("data page size doesn't match the file header")
+	// ErrEntryTooLarge is returned when adding an entry that doesn't fit in a data page
+	ErrEntryTooLarge = fmt.Errorf("entry larger than the data page size")
+	// ErrDecodingProtoEntry is returned when a message of a proto stream is invalid
+	ErrDecodingProtoEntry = fmt.Errorf("invalid proto stream entry")
+	// ErrWriteVerificationFailed is returned when the entries read back before a commit differ from the ones written
+	ErrWriteVerificationFailed = fmt.Errorf("write verification failed")
+	// ErrInvalidProjection is returned when the fields of a projection subscription or a projected entry are invalid
+	ErrInvalidProjection = fmt.Errorf("invalid projection")
+	// ErrInvalidPageLimit is returned when the limit of the bookmarks listed in a page is not positive
+	ErrInvalidPageLimit = fmt.Errorf("invalid page limit, must be positive")
+	// ErrInvalidBookmarkCursor is returned when a bookmarks cursor was not returned by ListBookmarks
+	ErrInvalidBookmarkCursor = fmt.Errorf("invalid bookmarks cursor")
+	// ErrTLSConfigMissing is returned when creating a TLS server without TLS configuration
+	ErrTLSConfigMissing = fmt.Errorf("TLS configuration missing")
+	// ErrPageChecksumMismatch is returned when the entries of a data page don't match the page checksum
+	ErrPageChecksumMismatch = fmt.Errorf("data page checksum mismatch")
+	// ErrInvalidEntryRange is returned when the start entry of a range is greater than its end entry
+	ErrInvalidEntryRange = fmt.Errorf("invalid entry range, from greater than to")
+	// ErrCompressionMismatch is returned when the compression requested doesn't match the one in the file header
+	ErrCompressionMismatch = fmt.Errorf("compression doesn't match the file header")
+	// ErrDecompressionFailed is returned when the compressed data of an entry can't be decompressed
+	ErrDecompressionFailed = fmt.Errorf("entry data decompression failed")
+	// ErrInvalidEntryTypeFilter is returned when the entry type filter of a start command is empty or too large
+	ErrInvalidEntryTypeFilter = fmt.Errorf("invalid entry type filter, empty or too many types")
+	// ErrEntryLengthMismatch is returned when the new data of an entry updated doesn't have its current length
+	ErrEntryLengthMismatch = ErrUpdateEntryDifferentSize
+	// ErrDisconnected is returned when the client is disconnected from the server with the reconnection disabled
+	ErrDisconnected = fmt.Errorf("disconnected from server, reconnection disabled")
+	// ErrReadOnlyStore is returned when writing to a read-only stream store
+	ErrReadOnlyStore = fmt.Errorf("stream store is read-only")
+	// ErrRangeRequestFailed is returned when a range request of a remote stream file doesn't return the bytes requested
+	ErrRangeRequestFailed = fmt.Errorf("range request failed")
+	// ErrEntryDecodeFailed is returned when the data of an entry doesn't decode into the message of its entry type
+	ErrEntryDecodeFailed = fmt.Errorf("entry data decode failed")
+	// ErrEntryConflict is returned when an entry number to add already exists with a different type or data
+	ErrEntryConflict = fmt.Errorf("entry already exists with different data")
+	// ErrUnsupportedCodec is returned when the codec of the wire compression chosen by the server is not supported
+	ErrUnsupportedCodec = fmt.Errorf("unsupported wire compression codec")
+	// ErrStoreKeyNotFound is returned by a bookmark store when a key doesn't exist, the embedded database's error
+	ErrStoreKeyNotFound = leveldb.ErrNotFound
+	// ErrDeleteBookmarkNotAllowed is returned when deleting a bookmark without an atomic operation in progress
+	ErrDeleteBookmarkNotAllowed = fmt.Errorf("delete bookmark not allowed, atomicop is not started")
+	// ErrMaxClientsReached is returned when the server rejects the connection, maximum number of clients reached
+	ErrMaxClientsReached = fmt.Errorf("connection rejected, maximum number of clients reached")
+	// ErrUnsupportedFileFormat is returned when migrating a stream file to an unknown file format version
+	ErrUnsupportedFileFormat = fmt.Errorf("unsupported file format version")
+	// ErrSavepointNotAllowed is returned when setting or using a savepoint without an atomic operation in progress
+	ErrSavepointNotAllowed = fmt.Errorf("savepoint not allowed, atomicop is not started")
+	// ErrSavepointNotFound is returned when rolling back to or releasing a savepoint that doesn't exist
+	ErrSavepointNotFound = fmt.Errorf("savepoint not found")
+	// ErrAtomicOpTimedOut is returned by the next operation of an atomic operation rolled back on timeout
+	ErrAtomicOpTimedOut = fmt.Errorf("atomic operation rolled back, timeout reached")
+	// ErrInvalidTimestamp is returned when adding a time bookmark with a timestamp before the Unix epoch
+	ErrInvalidTimestamp = fmt.Errorf("invalid timestamp, before the unix epoch")
+	// ErrTimeNotMonotonic is returned when adding a time bookmark earlier than the latest one, with monotonic time set
+	ErrTimeNotMonotonic = fmt.Errorf("time bookmark earlier than the latest one")
+	// ErrEntryChecksumMismatch is returned when the checksum of an entry received doesn't match its content
+	ErrEntryChecksumMismatch = fmt.Errorf("entry checksum mismatch")
 )
@@ -0,0 +1,57 @@
+package datastreamer
+
+import (
+	"net"
+	"slices"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// SetAllowedCIDRs sets the networks allowed to connect, before Start: the connections from other addresses are
+// closed as they are accepted, before reading anything. With none (default) all the addresses are allowed.
+func (s *StreamServer) SetAllowedCIDRs(cidrs []*net.IPNet) {
+	s.allowedCIDRs = slices.Clone(cidrs)
+}
+
+// SetDeniedCIDRs sets the networks not allowed to connect, before Start: the connections from them are closed as
+// they are accepted, before reading anything, even if they are in the allowed networks too.
+func (s *StreamServer) SetDeniedCIDRs(cidrs []*net.IPNet) {
+	s.deniedCIDRs = slices.Clone(cidrs)
+}
+
+// isAddrAllowed returns if a remote address can connect to the server. An address without IP is only allowed if
+// there are no allowed networks.
+func (s *StreamServer) isAddrAllowed(addr net.Addr) bool {
+	if len(s.allowedCIDRs) == 0 && len(s.deniedCIDRs) == 0 {
+		return true
+	}
+
+	ip := addrIP(addr)
+	if ip != nil && slices.ContainsFunc(s.deniedCIDRs, func(n *net.IPNet) bool { return n.Contains(ip) }) {
+		log.Debugf("Connection from %s rejected, denied network", ip)
+		return false
+	}
+	if len(s.allowedCIDRs) > 0 &&
+		(ip == nil || !slices.ContainsFunc(s.allowedCIDRs, func(n *net.IPNet) bool { return n.Contains(ip) })) {
+		log.Debugf("Connection from %s rejected, not in the allowed networks", addr)
+		return false
+	}
+	return true
+}
+
+// addrIP returns the IP of a network address, nil if it has none
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
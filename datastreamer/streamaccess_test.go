@@ -0,0 +1,81 @@
+package datastreamer
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAddr is a network address that is not an IP address
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "fake" }
+func (a fakeAddr) String() string  { return string(a) }
+
+// mustParseCIDRs parses networks in CIDR notation
+func mustParseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func TestIsAddrAllowed(t *testing.T) {
+	s := &StreamServer{}
+	tcp := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234} } //nolint:mnd
+
+	// All allowed by default
+	assert.True(t, s.isAddrAllowed(tcp("203.0.113.7")))
+	assert.True(t, s.isAddrAllowed(fakeAddr("pipe")))
+
+	// Denied networks only
+	s.SetDeniedCIDRs(mustParseCIDRs(t, "203.0.113.0/24", "2001:db8::/32"))
+	assert.False(t, s.isAddrAllowed(tcp("203.0.113.7")))
+	assert.False(t, s.isAddrAllowed(tcp("::ffff:203.0.113.7")))
+	assert.False(t, s.isAddrAllowed(tcp("2001:db8::1")))
+	assert.False(t, s.isAddrAllowed(fakeAddr("203.0.113.8:5000")))
+	assert.True(t, s.isAddrAllowed(tcp("198.51.100.1")))
+	assert.True(t, s.isAddrAllowed(fakeAddr("pipe")))
+
+	// Allowed networks, the denied ones taking precedence
+	s.SetAllowedCIDRs(mustParseCIDRs(t, "203.0.113.0/24", "10.0.0.0/8"))
+	assert.True(t, s.isAddrAllowed(tcp("10.1.2.3")))
+	assert.True(t, s.isAddrAllowed(fakeAddr("10.1.2.3:5000")))
+	assert.False(t, s.isAddrAllowed(tcp("203.0.113.7")))
+	assert.False(t, s.isAddrAllowed(tcp("198.51.100.1")))
+	assert.False(t, s.isAddrAllowed(fakeAddr("pipe")))
+}
+
+func TestDeniedConnection(t *testing.T) {
+	dial := func(s *StreamServer) net.Conn {
+		conn, err := net.Dial("tcp", testServerAddr(s))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second))) //nolint:mnd
+		return conn
+	}
+
+	// Loopback denied, closed before reading anything
+	loopback := mustParseCIDRs(t, "127.0.0.0/8", "::1/128")
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) { s.SetDeniedCIDRs(loopback) })
+	_, err := dial(s).Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 0, s.getSafeClientsLen())
+
+	// Loopback allowed
+	s = newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) { s.SetAllowedCIDRs(loopback) })
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	startClientUntilCleanup(t, c)
+	_, err = c.ExecCommandGetHeader()
+	require.NoError(t, err)
+}
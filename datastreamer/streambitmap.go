@@ -0,0 +1,87 @@
+package datastreamer
+
+import (
+	"sync"
+
+	"github.com/RoaringBitmap/roaring/v2/roaring64"
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// EntryBitmap type for a compact set of entry numbers (e.g. the entries processed by a consumer), backed by a
+// roaring bitmap. It's safe for concurrent use.
+type EntryBitmap struct {
+	bitmap *roaring64.Bitmap
+	mutex  sync.RWMutex
+}
+
+// NewEntryBitmap creates an empty entry bitmap
+func NewEntryBitmap() *EntryBitmap {
+	return &EntryBitmap{bitmap: roaring64.New()}
+}
+
+// DeserializeEntryBitmap creates an entry bitmap from its serialized bytes
+func DeserializeEntryBitmap(b []byte) (*EntryBitmap, error) {
+	bitmap := roaring64.New()
+	err := bitmap.UnmarshalBinary(b)
+	if err != nil {
+		log.Errorf("Error deserializing entry bitmap: %v", err)
+		return nil, err
+	}
+	return &EntryBitmap{bitmap: bitmap}, nil
+}
+
+// Add adds an entry number
+func (b *EntryBitmap) Add(entryNum uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.bitmap.Add(entryNum)
+}
+
+// AddRange adds the entry numbers from an entry number until another one (excluding)
+func (b *EntryBitmap) AddRange(from, to uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.bitmap.AddRange(from, to)
+}
+
+// Contains returns if an entry number has been added
+func (b *EntryBitmap) Contains(entryNum uint64) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.bitmap.Contains(entryNum)
+}
+
+// Count returns the number of entry numbers added
+func (b *EntryBitmap) Count() uint64 {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.bitmap.GetCardinality()
+}
+
+// Missing returns the entry numbers from an entry number until another one (excluding) not added
+func (b *EntryBitmap) Missing(from, to uint64) *EntryBitmap {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	missing := roaring64.New()
+	if from < to {
+		missing.AddRange(from, to)
+		missing.AndNot(b.bitmap)
+	}
+	return &EntryBitmap{bitmap: missing}
+}
+
+// ToSlice returns the entry numbers added in ascending order
+func (b *EntryBitmap) ToSlice() []uint64 {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.bitmap.ToArray()
+}
+
+// Serialize returns the entry bitmap in the portable roaring format
+func (b *EntryBitmap) Serialize() ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.bitmap.RunOptimize()
+	return b.bitmap.MarshalBinary()
+}
@@ -0,0 +1,39 @@
+package datastreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryBitmap(t *testing.T) {
+	b := NewEntryBitmap()
+	sparse := []uint64{0, 7, 1000, 1 << 33, 1<<40 + 5} //nolint:mnd
+	for _, entryNum := range sparse {
+		b.Add(entryNum)
+	}
+	b.AddRange(100, 200) //nolint:mnd
+
+	assert.True(t, b.Contains(7))           //nolint:mnd
+	assert.True(t, b.Contains(150))         //nolint:mnd
+	assert.False(t, b.Contains(8))          //nolint:mnd
+	assert.False(t, b.Contains(200))        //nolint:mnd
+	assert.Equal(t, uint64(105), b.Count()) //nolint:mnd
+
+	// Serialization round-trip
+	data, err := b.Serialize()
+	require.NoError(t, err)
+	b2, err := DeserializeEntryBitmap(data)
+	require.NoError(t, err)
+	assert.Equal(t, b.ToSlice(), b2.ToSlice())
+	assert.True(t, b2.Contains(1<<40+5)) //nolint:mnd
+
+	_, err = DeserializeEntryBitmap([]byte{1, 2, 3})
+	assert.Error(t, err)
+
+	// Expected range not processed
+	assert.Equal(t, []uint64{5, 6, 8, 9}, b2.Missing(5, 10).ToSlice()) //nolint:mnd
+	assert.Zero(t, b2.Missing(100, 200).Count())                       //nolint:mnd
+	assert.Zero(t, b2.Missing(10, 5).Count())                          //nolint:mnd
+}
@@ -1,17 +1,22 @@
 package datastreamer
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"path/filepath"
 
 	"github.com/gateway-fm/zkevm-data-streamer/log"
-	"github.com/syndtr/goleveldb/leveldb"
 )
 
+// bookmarkEntriesDir is the directory, inside the bookmarks database, of the index of the bookmarks by entry number
+const bookmarkEntriesDir = "entries"
+
 // StreamBookmark type to manage index of bookmarks
 type StreamBookmark struct {
-	dbName string
-	db     *leveldb.DB
+	dbName  string
+	db      BookmarkStore
+	entries BookmarkStore // Bookmarks by entry number: entry number (u64) followed by the bookmark, without value
 }
 
 // NewBookmark creates bookmark struct and opens or creates the bookmark database
@@ -23,29 +28,126 @@ func NewBookmark(fn string) (*StreamBookmark, error) {
 
 	// Open (or create) the bookmarks database
 	log.Infof("Opening/creating bookmarks DB for datastream: %s", fn)
-	db, err := leveldb.OpenFile(fn, nil)
+	db, err := openLevelDBBookmarkStore(fn)
 	if err != nil {
 		log.Errorf("Error opening or creating bookmarks DB %s: %v", fn, err)
 		return nil, err
 	}
 	b.db = db
 
+	// Open (or create) the index by entry number, built from the bookmarks if it's new
+	entries, err := openLevelDBBookmarkStore(filepath.Join(fn, bookmarkEntriesDir))
+	if err != nil {
+		log.Errorf("Error opening or creating bookmarks index by entry %s: %v", fn, err)
+		db.Close()
+		return nil, err
+	}
+	b.entries = entries
+	err = b.buildEntriesIndex()
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// NewBookmarkWithStores creates bookmark struct on a bookmark store, and optionally the store of its index by entry
+// number (nil: kept in memory, built from the bookmarks)
+func NewBookmarkWithStores(db, entries BookmarkStore) (*StreamBookmark, error) {
+	if entries == nil {
+		entries = NewMemoryBookmarkStore()
+	}
+	b := StreamBookmark{
+		db:      db,
+		entries: entries,
+	}
+	err := b.buildEntriesIndex()
+	if err != nil {
+		return nil, err
+	}
 	return &b, nil
 }
 
+// entryIndexKey returns the key of a bookmark in the index by entry number
+func entryIndexKey(entryNum uint64, bookmark []byte) []byte {
+	return append(binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(bookmark)), entryNum), bookmark...) //nolint:mnd
+}
+
+// buildEntriesIndex adds all the bookmarks to the index by entry number if it's empty, e.g. a database created before
+// the index existed
+func (b *StreamBookmark) buildEntriesIndex() error {
+	empty := true
+	err := b.entries.Iterate(nil, func(_, _ []byte) bool {
+		empty = false
+		return false
+	})
+	if err != nil {
+		log.Errorf("Error reading bookmarks index by entry: %v", err)
+		return err
+	}
+	if !empty {
+		return nil
+	}
+
+	batch := new(BookmarkBatch)
+	err = b.db.Iterate(nil, func(key, value []byte) bool {
+		batch.Put(entryIndexKey(binary.BigEndian.Uint64(value), key), nil)
+		return true
+	})
+	if err != nil {
+		log.Errorf("Error iterating bookmarks to index by entry: %v", err)
+		return err
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	err = b.entries.Write(batch)
+	if err != nil {
+		log.Errorf("Error indexing bookmarks by entry: %v", err)
+		return err
+	}
+	log.Infof("Indexed %d bookmarks by entry number", batch.Len())
+	return nil
+}
+
 // AddBookmark inserts or updates a bookmark
 func (b *StreamBookmark) AddBookmark(bookmark []byte, entryNum uint64) error {
 	// Convert entry number to bytes slice
 	var entry []byte
 	entry = binary.BigEndian.AppendUint64(entry, entryNum)
 
+	// Index it by entry number first, the index entries not matching the bookmarks are ignored
+	err := b.entries.Put(entryIndexKey(entryNum, bookmark), nil)
+	if err != nil {
+		log.Errorf("Error indexing bookmark [%v] by entry %d: %v", bookmark, entryNum, err)
+		return err
+	}
+
 	// Insert or update the bookmark into DB
-	err := b.db.Put(bookmark, entry, nil)
+	previous, err := b.db.Get(bookmark)
+	if errors.Is(err, ErrStoreKeyNotFound) {
+		previous = nil
+	} else if err != nil {
+		log.Errorf("Error getting bookmark [%v] to update: %v", bookmark, err)
+		return err
+	}
+	err = b.db.Put(bookmark, entry)
 	if err != nil {
 		log.Errorf("Error inserting or updating bookmark [%v] value [%d]", bookmark, entryNum)
 		return err
 	}
 
+	// Remove the index entry of the previous value
+	if previous != nil && binary.BigEndian.Uint64(previous) != entryNum {
+		err = b.entries.Delete(entryIndexKey(binary.BigEndian.Uint64(previous), bookmark))
+		if err != nil {
+			log.Errorf("Error removing bookmark [%v] from the index by entry: %v", bookmark, err)
+			return err
+		}
+	}
+
 	// Log
 	log.Debugf("Bookmark added[%v] value[%d]", bookmark, entryNum)
 
@@ -55,8 +157,8 @@ func (b *StreamBookmark) AddBookmark(bookmark []byte, entryNum uint64) error {
 // GetBookmark gets a bookmark value
 func (b *StreamBookmark) GetBookmark(bookmark []byte) (uint64, error) {
 	// Get the bookmark from DB
-	entry, err := b.db.Get(bookmark, nil)
-	if errors.Is(err, leveldb.ErrNotFound) {
+	entry, err := b.db.Get(bookmark)
+	if errors.Is(err, ErrStoreKeyNotFound) {
 		return 0, err
 	} else if err != nil {
 		log.Errorf("Error getting bookmark [%v]: %w", bookmark, err)
@@ -72,32 +174,250 @@ func (b *StreamBookmark) GetBookmark(bookmark []byte) (uint64, error) {
 	return entryNum, nil
 }
 
+// DeleteBookmark deletes a bookmark, returns the entry number it pointed to and false if it didn't exist
+func (b *StreamBookmark) DeleteBookmark(bookmark []byte) (uint64, bool, error) {
+	value, err := b.db.Get(bookmark)
+	if errors.Is(err, ErrStoreKeyNotFound) {
+		return 0, false, nil
+	} else if err != nil {
+		log.Errorf("Error getting bookmark [%v] to delete: %v", bookmark, err)
+		return 0, false, err
+	}
+	entryNum := binary.BigEndian.Uint64(value)
+
+	// Delete it from DB first, the index entries not matching the bookmarks are ignored
+	err = b.db.Delete(bookmark)
+	if err != nil {
+		log.Errorf("Error deleting bookmark [%v]: %v", bookmark, err)
+		return 0, false, err
+	}
+	err = b.entries.Delete(entryIndexKey(entryNum, bookmark))
+	if err != nil {
+		log.Errorf("Error removing bookmark [%v] from the index by entry: %v", bookmark, err)
+		return 0, false, err
+	}
+
+	log.Debugf("Bookmark deleted[%v] value[%d]", bookmark, entryNum)
+	return entryNum, true, nil
+}
+
+// deleteFrom deletes the bookmarks pointing to entries from an entry number onwards, just the given keys if any or
+// all of them otherwise, returns the number of bookmarks deleted
+func (b *StreamBookmark) deleteFrom(entryNum uint64, keys [][]byte) (uint64, error) {
+	batch := new(BookmarkBatch)
+	indexBatch := new(BookmarkBatch)
+	if keys != nil {
+		for _, key := range keys {
+			value, err := b.db.Get(key)
+			if errors.Is(err, ErrStoreKeyNotFound) {
+				continue
+			} else if err != nil {
+				log.Errorf("Error getting bookmark [%v] to delete: %v", key, err)
+				return 0, err
+			}
+			if num := binary.BigEndian.Uint64(value); num >= entryNum {
+				batch.Delete(key)
+				indexBatch.Delete(entryIndexKey(num, key))
+			}
+		}
+	} else {
+		err := b.db.Iterate(nil, func(key, value []byte) bool {
+			if num := binary.BigEndian.Uint64(value); num >= entryNum {
+				batch.Delete(key)
+				indexBatch.Delete(entryIndexKey(num, key))
+			}
+			return true
+		})
+		if err != nil {
+			log.Errorf("Error iterating bookmarks to delete from entry %d: %v", entryNum, err)
+			return 0, err
+		}
+	}
+
+	err := b.db.Write(batch)
+	if err != nil {
+		log.Errorf("Error deleting bookmarks from entry %d: %v", entryNum, err)
+		return 0, err
+	}
+	err = b.entries.Write(indexBatch)
+	if err != nil {
+		log.Errorf("Error deleting bookmarks from entry %d from the index by entry: %v", entryNum, err)
+		return 0, err
+	}
+	return uint64(batch.Len()), nil
+}
+
+// seekBookmark gets the first bookmark (in key order) equal or greater than a key, or just greater if after is set,
+// and its value. Found is false if there is none.
+func (b *StreamBookmark) seekBookmark(key []byte, after bool) ([]byte, uint64, bool, error) {
+	var found []byte
+	var entryNum uint64
+	err := b.db.Iterate(key, func(k, value []byte) bool {
+		if after && bytes.Equal(k, key) {
+			return true
+		}
+		found = bytes.Clone(k)
+		entryNum = binary.BigEndian.Uint64(value)
+		return false
+	})
+	if err != nil {
+		log.Errorf("Error seeking bookmark [%v]: %v", key, err)
+		return nil, 0, false, err
+	}
+	return found, entryNum, found != nil, nil
+}
+
+// BookmarkResult type for a bookmark listed: its key and the entry number it points to
+type BookmarkResult struct {
+	Key      []byte
+	EntryNum uint64
+}
+
+// bookmarkCursorVersion is the first byte of the cursors returned by ListBookmarks, followed by the last key listed
+const bookmarkCursorVersion = 1
+
+// ListBookmarks returns up to limit bookmarks in key order from a cursor (nil: from the first one) and the cursor to
+// list the next ones (nil: no more bookmarks). Each page is read from a snapshot of the database. The cursor is the
+// position after the last key listed, so the bookmarks added or removed between pages don't cause duplicates nor
+// skip the others: the bookmarks added after the cursor are listed in the next pages.
+func (b *StreamBookmark) ListBookmarks(cursor []byte, limit int) ([]BookmarkResult, []byte, error) {
+	if limit <= 0 {
+		log.Errorf("Invalid bookmarks page limit %d", limit)
+		return nil, nil, ErrInvalidPageLimit
+	}
+	if cursor != nil && (len(cursor) == 0 || cursor[0] != bookmarkCursorVersion) {
+		log.Errorf("Invalid bookmarks cursor [%v]", cursor)
+		return nil, nil, ErrInvalidBookmarkCursor
+	}
+
+	var last []byte
+	if cursor != nil {
+		last = cursor[1:]
+	}
+
+	var results []BookmarkResult
+	more := false
+	err := b.db.Iterate(last, func(key, value []byte) bool {
+		if cursor != nil && bytes.Equal(key, last) {
+			return true
+		}
+		if len(results) == limit {
+			more = true
+			return false
+		}
+		results = append(results, BookmarkResult{
+			Key:      bytes.Clone(key),
+			EntryNum: binary.BigEndian.Uint64(value),
+		})
+		return true
+	})
+	if err != nil {
+		log.Errorf("Error listing bookmarks: %v", err)
+		return nil, nil, err
+	}
+
+	// More bookmarks after the page
+	var nextCursor []byte
+	if more {
+		nextCursor = append([]byte{bookmarkCursorVersion}, results[len(results)-1].Key...)
+	}
+	return results, nextCursor, nil
+}
+
+// GetBookmarksByPrefix returns the bookmarks whose key starts with a prefix, in key order (bytewise, so the last one
+// is the latest with the block number encoded in big endian after the prefix). An empty prefix returns all of them.
+func (b *StreamBookmark) GetBookmarksByPrefix(prefix []byte) ([]BookmarkResult, error) {
+	var results []BookmarkResult
+	err := b.db.Iterate(prefix, func(key, value []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		results = append(results, BookmarkResult{
+			Key:      bytes.Clone(key),
+			EntryNum: binary.BigEndian.Uint64(value),
+		})
+		return true
+	})
+	if err != nil {
+		log.Errorf("Error getting bookmarks by prefix [%v]: %v", prefix, err)
+		return nil, err
+	}
+	return results, nil
+}
+
+// IterateBookmarks calls f with each bookmark in key order, from a snapshot of the database, until it returns false.
+// The key is only valid during the call. Unlike ListBookmarks the bookmarks are not loaded in memory.
+func (b *StreamBookmark) IterateBookmarks(f func(key []byte, entryNum uint64) bool) error {
+	err := b.db.Iterate(nil, func(key, value []byte) bool {
+		return f(key, binary.BigEndian.Uint64(value))
+	})
+	if err != nil {
+		log.Errorf("Error iterating bookmarks: %v", err)
+		return err
+	}
+	return nil
+}
+
+// bookmarksByEntry returns the bookmarks pointing to an entry number, in key order
+func (b *StreamBookmark) bookmarksByEntry(entryNum uint64) ([][]byte, error) {
+	prefix := binary.BigEndian.AppendUint64(nil, entryNum)
+	var candidates [][]byte
+	err := b.entries.Iterate(prefix, func(key, _ []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		candidates = append(candidates, bytes.Clone(key[len(prefix):]))
+		return true
+	})
+	if err != nil {
+		log.Errorf("Error getting bookmarks of entry %d: %v", entryNum, err)
+		return nil, err
+	}
+
+	var bookmarks [][]byte
+	for _, bookmark := range candidates {
+		value, err := b.db.Get(bookmark)
+		if errors.Is(err, ErrStoreKeyNotFound) {
+			continue
+		} else if err != nil {
+			log.Errorf("Error getting bookmark [%v] of entry %d: %v", bookmark, entryNum, err)
+			return nil, err
+		}
+		if binary.BigEndian.Uint64(value) == entryNum {
+			bookmarks = append(bookmarks, bookmark)
+		}
+	}
+	return bookmarks, nil
+}
+
+// GetBookmarkByEntry returns the bookmark pointing to an entry number (the first one in key order if there are
+// several), found is false if there is none
+func (b *StreamBookmark) GetBookmarkByEntry(entryNum uint64) ([]byte, bool, error) {
+	bookmarks, err := b.bookmarksByEntry(entryNum)
+	if err != nil || len(bookmarks) == 0 {
+		return nil, false, err
+	}
+	return bookmarks[0], true, nil
+}
+
 // PrintDump prints all bookmarks stored in the database
 func (b *StreamBookmark) PrintDump() error {
 	// Counter
 	var count uint64 = 0
 
-	// Initialize iterator
-	iter := b.db.NewIterator(nil, nil)
-
 	// Iterator loop
-	for iter.Next() {
+	err := b.db.Iterate(nil, func(bookmark, entry []byte) bool {
 		count++
-		bookmark := iter.Key()
-		entry := iter.Value()
 		entryNum := binary.BigEndian.Uint64(entry)
 		log.Debugf("Bookmark[%v] value[%d]", bookmark, entryNum)
-	}
+		return true
+	})
 
 	// Check if error
-	err := iter.Error()
 	if err != nil {
 		log.Errorf("Iterator error in PrintDump: %v", err)
 	}
 
-	// Finalize iterator
-	iter.Release()
-
 	// Log total
 	log.Debugf("Number of bookmarks: [%d]", count)
 
@@ -105,10 +425,14 @@ func (b *StreamBookmark) PrintDump() error {
 }
 
 func (b *StreamBookmark) Close() error {
-	if b.db == nil {
-		return nil
+	var err error
+	if b.entries != nil {
+		err = b.entries.Close()
+		b.entries = nil
+	}
+	if b.db != nil {
+		err = errors.Join(b.db.Close(), err)
+		b.db = nil
 	}
-	err := b.db.Close()
-	b.db = nil
 	return err
 }
@@ -1,10 +1,13 @@
 package datastreamer
 
 import (
+	"encoding/binary"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func createTempDB(t *testing.T) *StreamBookmark {
@@ -22,7 +25,7 @@ func createTempDB(t *testing.T) *StreamBookmark {
 func cleanUpDB(t *testing.T, b *StreamBookmark) {
 	t.Helper()
 
-	err := b.db.Close()
+	err := b.Close()
 	if err != nil {
 		t.Fatalf("Failed to close bookmark database: %v", err)
 	}
@@ -70,3 +73,223 @@ func TestGetBookmarkNotFound(t *testing.T) {
 	_, err := b.GetBookmark(nonExistentBookmark)
 	assert.Error(t, err, "Expected error when getting a non-existent bookmark")
 }
+
+func TestListBookmarks(t *testing.T) {
+	b := createTempDB(t)
+	defer cleanUpDB(t, b)
+
+	const total = 10000
+	for i := range total {
+		require.NoError(t, b.AddBookmark(binary.BigEndian.AppendUint32(nil, uint32(i*2)), uint64(i))) //nolint:mnd
+	}
+
+	// Page through them while bookmarks are added before and after the cursor
+	seen := make(map[uint32]uint64)
+	var cursor []byte
+	var last uint32
+	for page := 0; ; page++ {
+		results, next, err := b.ListBookmarks(cursor, 333) //nolint:mnd
+		require.NoError(t, err)
+		for _, r := range results {
+			key := binary.BigEndian.Uint32(r.Key)
+			_, dup := seen[key]
+			require.False(t, dup, "bookmark %d listed twice", key)
+			require.True(t, len(seen) == 0 || key > last, "bookmarks out of order")
+			seen[key] = r.EntryNum
+			last = key
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+
+		require.NoError(t, b.AddBookmark(binary.BigEndian.AppendUint32(nil, uint32(page*2+1)), 0)) //nolint:mnd
+		require.NoError(t, b.AddBookmark(binary.BigEndian.AppendUint32(nil, uint32(total*2+page)), 0))
+	}
+
+	for i := range total {
+		entryNum, ok := seen[uint32(i*2)]                  //nolint:mnd
+		require.True(t, ok, "bookmark %d not listed", i*2) //nolint:mnd
+		assert.Equal(t, uint64(i), entryNum)
+	}
+	// The ones added after the cursor are listed
+	_, ok := seen[total*2]
+	assert.True(t, ok)
+
+	_, _, err := b.ListBookmarks(nil, 0)
+	require.ErrorIs(t, err, ErrInvalidPageLimit)
+	_, _, err = b.ListBookmarks([]byte{}, 1)
+	require.ErrorIs(t, err, ErrInvalidBookmarkCursor)
+}
+
+func TestGetBookmarksByPrefix(t *testing.T) {
+	b := createTempDB(t)
+	defer cleanUpDB(t, b)
+
+	// Type byte followed by the block number in big endian, added out of order
+	key := func(btype byte, block uint64) []byte {
+		return binary.BigEndian.AppendUint64([]byte{btype}, block)
+	}
+	for _, block := range []uint64{300, 2, 256, 1} {
+		require.NoError(t, b.AddBookmark(key(1, block), block*10)) //nolint:mnd
+		require.NoError(t, b.AddBookmark(key(2, block), block))    //nolint:mnd
+	}
+	require.NoError(t, b.AddBookmark([]byte{0xff}, 0))
+
+	results, err := b.GetBookmarksByPrefix([]byte{1})
+	require.NoError(t, err)
+	require.Len(t, results, 4) //nolint:mnd
+	for i, block := range []uint64{1, 2, 256, 300} {
+		assert.Equal(t, key(1, block), results[i].Key)
+		assert.Equal(t, block*10, results[i].EntryNum) //nolint:mnd
+	}
+
+	// Prefix with the last byte 0xff, and none matching
+	results, err = b.GetBookmarksByPrefix([]byte{0xff})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	results, err = b.GetBookmarksByPrefix([]byte{3})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	results, err = b.GetBookmarksByPrefix(nil)
+	require.NoError(t, err)
+	assert.Len(t, results, 9) //nolint:mnd
+}
+
+func TestIterateBookmarks(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	// Bookmarks added across atomic operations, not in key order
+	expected := make(map[string]uint64)
+	for _, keys := range [][]string{{"block-3", "block-1"}, {"batch-2"}, {"block-2", "batch-1"}} {
+		require.NoError(t, s.StartAtomicOp())
+		for _, key := range keys {
+			entryNum, err := s.AddStreamBookmark([]byte(key))
+			require.NoError(t, err)
+			expected[key] = entryNum
+			_, err = s.AddStreamEntry(1, []byte(key))
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+	}
+
+	var keys []string
+	require.NoError(t, s.IterateBookmarks(func(key []byte, entryNum uint64) bool {
+		keys = append(keys, string(key))
+		assert.Equal(t, expected[string(key)], entryNum)
+		return true
+	}))
+	assert.Equal(t, []string{"batch-1", "batch-2", "block-1", "block-2", "block-3"}, keys)
+
+	// Same listing by pages
+	results, next, err := s.ListBookmarks(nil, 10) //nolint:mnd
+	require.NoError(t, err)
+	assert.Nil(t, next)
+	require.Len(t, results, len(keys))
+	for i, r := range results {
+		assert.Equal(t, keys[i], string(r.Key))
+		assert.Equal(t, expected[keys[i]], r.EntryNum)
+	}
+
+	// Stopped by the callback
+	count := 0
+	require.NoError(t, s.IterateBookmarks(func([]byte, uint64) bool {
+		count++
+		return count < 2 //nolint:mnd
+	}))
+	assert.Equal(t, 2, count)
+}
+
+func TestGetBookmarkByEntry(t *testing.T) {
+	b := createTempDB(t)
+	defer cleanUpDB(t, b)
+
+	require.NoError(t, b.AddBookmark([]byte("a"), 1))
+	require.NoError(t, b.AddBookmark([]byte("b"), 5)) //nolint:mnd
+	require.NoError(t, b.AddBookmark([]byte("c"), 5)) //nolint:mnd
+	bookmark, found, err := b.GetBookmarkByEntry(1)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("a"), bookmark)
+	bookmark, found, err = b.GetBookmarkByEntry(5) //nolint:mnd
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("b"), bookmark)
+	_, found, err = b.GetBookmarkByEntry(2) //nolint:mnd
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// Bookmark updated to another entry
+	require.NoError(t, b.AddBookmark([]byte("a"), 2)) //nolint:mnd
+	_, found, err = b.GetBookmarkByEntry(1)
+	require.NoError(t, err)
+	assert.False(t, found)
+	bookmark, found, err = b.GetBookmarkByEntry(2) //nolint:mnd
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("a"), bookmark)
+
+	// Deleted bookmarks
+	_, err = b.deleteFrom(5, [][]byte{[]byte("b")}) //nolint:mnd
+	require.NoError(t, err)
+	bookmark, found, err = b.GetBookmarkByEntry(5) //nolint:mnd
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("c"), bookmark)
+
+	// Index rebuilt for a database without it
+	require.NoError(t, b.Close())
+	require.NoError(t, os.RemoveAll(filepath.Join(b.dbName, bookmarkEntriesDir)))
+	b, err = NewBookmark(b.dbName)
+	require.NoError(t, err)
+	bookmark, found, err = b.GetBookmarkByEntry(2) //nolint:mnd
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("a"), bookmark)
+}
+
+func TestServerGetBookmarkByEntry(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	// Committed bookmark, and the entry after it without bookmark
+	require.NoError(t, s.StartAtomicOp())
+	entryNum, err := s.AddStreamBookmark([]byte{1})
+	require.NoError(t, err)
+	_, err = s.AddStreamEntry(1, []byte{2})
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	bookmark, found, err := s.GetBookmarkByEntry(entryNum)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte{1}, bookmark)
+	_, found, err = s.GetBookmarkByEntry(entryNum + 1)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// Bookmarks rolled back, removed or kept
+	for _, policy := range []BookmarkPruning{BookmarkPruneRemove, BookmarkPruneKeep} {
+		s.SetBookmarkPruning(policy)
+		require.NoError(t, s.StartAtomicOp())
+		entryNum, err = s.AddStreamBookmark([]byte{3, byte(policy)})
+		require.NoError(t, err)
+		bookmark, found, err = s.GetBookmarkByEntry(entryNum)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte{3, byte(policy)}, bookmark)
+		require.NoError(t, s.RollbackAtomicOp())
+		_, found, err = s.GetBookmarkByEntry(entryNum)
+		require.NoError(t, err)
+		assert.False(t, found, "policy %d", policy)
+	}
+
+	// Entry number reused by a new bookmark after the rollback keeping the previous one
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamBookmark([]byte{4})
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	bookmark, found, err = s.GetBookmarkByEntry(entryNum)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte{4}, bookmark)
+}
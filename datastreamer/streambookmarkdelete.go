@@ -0,0 +1,48 @@
+package datastreamer
+
+import (
+	"slices"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// DeleteStreamBookmark deletes a bookmark in the current atomic operation: it's no longer found from then on, and
+// restored if the atomic operation is rolled back. Deleting a bookmark that doesn't exist does nothing. The bookmark
+// entry remains in the stream file.
+func (s *StreamServer) DeleteStreamBookmark(bookmark []byte) error {
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Check atomic operation status
+	if s.atomicOp.status != aoStarted {
+		log.Errorf("Delete bookmark not allowed, AtomicOp is not started")
+		return ErrDeleteBookmarkNotAllowed
+	}
+
+	entryNum, found, err := s.bookmark.DeleteBookmark(bookmark)
+	if err != nil || !found {
+		return err
+	}
+
+	// Record it to restore it on rollback
+	s.atomicOp.deleted = append(s.atomicOp.deleted, BookmarkResult{Key: slices.Clone(bookmark), EntryNum: entryNum})
+	return nil
+}
+
+// restoreDeletedBookmarks adds back the bookmarks deleted in an atomic operation rolled back, the latest deleted
+// first so each bookmark gets the value it had before the atomic operation
+func (s *StreamServer) restoreDeletedBookmarks(deleted []BookmarkResult) error {
+	for _, b := range slices.Backward(deleted) {
+		err := s.bookmark.AddBookmark(b.Key, b.EntryNum)
+		if err != nil {
+			return err
+		}
+	}
+	if len(deleted) > 0 {
+		log.Infof("Restored %d bookmarks deleted in the atomic operation", len(deleted))
+	}
+	return nil
+}
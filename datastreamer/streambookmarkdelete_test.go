@@ -0,0 +1,89 @@
+package datastreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addTestBookmarks adds and commits some bookmarks, returning their entry numbers
+func addTestBookmarks(t *testing.T, s *StreamServer, bookmarks ...string) []uint64 {
+	t.Helper()
+
+	require.NoError(t, s.StartAtomicOp())
+	entryNums := make([]uint64, 0, len(bookmarks))
+	for _, bookmark := range bookmarks {
+		entryNum, err := s.AddStreamBookmark([]byte(bookmark))
+		require.NoError(t, err)
+		entryNums = append(entryNums, entryNum)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	return entryNums
+}
+
+func TestDeleteStreamBookmark(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	entryNums := addTestBookmarks(t, s, "b1", "b2")
+	require.ErrorIs(t, s.DeleteStreamBookmark([]byte("b1")), ErrDeleteBookmarkNotAllowed)
+
+	// Delete then commit
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b1")))
+	_, err := s.GetBookmark([]byte("b1"))
+	require.ErrorIs(t, err, ErrStoreKeyNotFound)
+	require.NoError(t, s.CommitAtomicOp())
+	_, err = s.GetBookmark([]byte("b1"))
+	require.ErrorIs(t, err, ErrStoreKeyNotFound)
+	_, found, err := s.GetBookmarkByEntry(entryNums[0])
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// Double delete, no-op
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b1")))
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b2")))
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b2")))
+	require.NoError(t, s.CommitAtomicOp())
+	results, err := s.GetBookmarksByPrefix(nil)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestDeleteStreamBookmarkRollback(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	entryNums := addTestBookmarks(t, s, "b1", "b2")
+
+	// Delete then rollback, with a bookmark deleted, added again and deleted again
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b1")))
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b1")))
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b2")))
+	_, err := s.AddStreamBookmark([]byte("b2"))
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b2")))
+	_, err = s.AddStreamBookmark([]byte("b3"))
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b3")))
+	require.NoError(t, s.RollbackAtomicOp())
+
+	// Bookmarks as before the atomic operation, index by entry included
+	results, err := s.GetBookmarksByPrefix(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []BookmarkResult{{[]byte("b1"), entryNums[0]}, {[]byte("b2"), entryNums[1]}}, results)
+	for i, key := range []string{"b1", "b2"} {
+		bookmark, found, err := s.GetBookmarkByEntry(entryNums[i])
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte(key), bookmark)
+	}
+
+	// Nothing left to restore on the next rollback
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b2")))
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.RollbackAtomicOp())
+	_, err = s.GetBookmark([]byte("b2"))
+	require.ErrorIs(t, err, ErrStoreKeyNotFound)
+}
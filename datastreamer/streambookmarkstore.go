@@ -0,0 +1,202 @@
+package datastreamer
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// BookmarkStore is the key-value backend of the bookmarks database. The embedded leveldb database is used by
+// default, other implementations can be given to the server with StreamFileOptions.
+type BookmarkStore interface {
+	// Get returns the value of a key, or ErrStoreKeyNotFound
+	Get(key []byte) ([]byte, error)
+	// Put inserts or updates a key
+	Put(key, value []byte) error
+	// Delete removes a key, doing nothing if it doesn't exist
+	Delete(key []byte) error
+	// Iterate calls f with each key equal or greater than from in key order (bytewise), from a snapshot of the
+	// store, until it returns false. The key and value are only valid during the call.
+	Iterate(from []byte, f func(key, value []byte) bool) error
+	// Write applies all the operations of a batch atomically
+	Write(batch *BookmarkBatch) error
+	// Close releases the store
+	Close() error
+}
+
+// bookmarkOp type for an operation of a bookmarks batch, a delete if value is nil
+type bookmarkOp struct {
+	key   []byte
+	value []byte
+}
+
+// BookmarkBatch type for a set of puts and deletes written atomically to a BookmarkStore, in order
+type BookmarkBatch struct {
+	ops []bookmarkOp
+}
+
+// Put adds the insert or update of a key to the batch
+func (b *BookmarkBatch) Put(key, value []byte) {
+	if value == nil {
+		value = []byte{}
+	}
+	b.ops = append(b.ops, bookmarkOp{key: bytes.Clone(key), value: bytes.Clone(value)})
+}
+
+// Delete adds the removal of a key to the batch
+func (b *BookmarkBatch) Delete(key []byte) {
+	b.ops = append(b.ops, bookmarkOp{key: bytes.Clone(key)})
+}
+
+// Len returns the number of operations of the batch
+func (b *BookmarkBatch) Len() int {
+	return len(b.ops)
+}
+
+// levelDBBookmarkStore is the BookmarkStore on a leveldb database
+type levelDBBookmarkStore struct {
+	db *leveldb.DB
+}
+
+// openLevelDBBookmarkStore opens or creates a leveldb bookmark store
+func openLevelDBBookmarkStore(fn string) (*levelDBBookmarkStore, error) {
+	db, err := leveldb.OpenFile(fn, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &levelDBBookmarkStore{db: db}, nil
+}
+
+// openLevelDBBookmarkStoreReadOnly opens an existing leveldb bookmark store for reading, the writes fail
+func openLevelDBBookmarkStoreReadOnly(fn string) (*levelDBBookmarkStore, error) {
+	db, err := leveldb.OpenFile(fn, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+	return &levelDBBookmarkStore{db: db}, nil
+}
+
+func (l *levelDBBookmarkStore) Get(key []byte) ([]byte, error) {
+	value, err := l.db.Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (l *levelDBBookmarkStore) Put(key, value []byte) error {
+	return l.db.Put(key, value, nil)
+}
+
+func (l *levelDBBookmarkStore) Delete(key []byte) error {
+	return l.db.Delete(key, nil)
+}
+
+func (l *levelDBBookmarkStore) Iterate(from []byte, f func(key, value []byte) bool) error {
+	iter := l.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for ok := iter.Seek(from); ok; ok = iter.Next() {
+		if !f(iter.Key(), iter.Value()) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+func (l *levelDBBookmarkStore) Write(batch *BookmarkBatch) error {
+	lb := new(leveldb.Batch)
+	for _, op := range batch.ops {
+		if op.value == nil {
+			lb.Delete(op.key)
+		} else {
+			lb.Put(op.key, op.value)
+		}
+	}
+	return l.db.Write(lb, nil)
+}
+
+func (l *levelDBBookmarkStore) Close() error {
+	return l.db.Close()
+}
+
+// MemoryBookmarkStore is a BookmarkStore kept in memory, lost on close (e.g. for tests or ephemeral streams)
+type MemoryBookmarkStore struct {
+	mutex sync.RWMutex
+	data  map[string][]byte
+}
+
+// NewMemoryBookmarkStore creates an empty bookmark store in memory
+func NewMemoryBookmarkStore() *MemoryBookmarkStore {
+	return &MemoryBookmarkStore{data: make(map[string][]byte)}
+}
+
+func (m *MemoryBookmarkStore) Get(key []byte) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	value, ok := m.data[string(key)]
+	if !ok {
+		return nil, ErrStoreKeyNotFound
+	}
+	return bytes.Clone(value), nil
+}
+
+func (m *MemoryBookmarkStore) Put(key, value []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (m *MemoryBookmarkStore) Delete(key []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *MemoryBookmarkStore) Iterate(from []byte, f func(key, value []byte) bool) error {
+	// Snapshot of the keys from the first one, the values are never modified in place
+	m.mutex.RLock()
+	keys := make([]string, 0, len(m.data))
+	values := make(map[string][]byte, len(m.data))
+	for key, value := range m.data {
+		if key >= string(from) {
+			keys = append(keys, key)
+			values[key] = value
+		}
+	}
+	m.mutex.RUnlock()
+
+	slices.Sort(keys)
+	for _, key := range keys {
+		if !f([]byte(key), values[key]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBookmarkStore) Write(batch *BookmarkBatch) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, op := range batch.ops {
+		if op.value == nil {
+			delete(m.data, string(op.key))
+		} else {
+			m.data[string(op.key)] = op.value
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBookmarkStore) Close() error {
+	return nil
+}
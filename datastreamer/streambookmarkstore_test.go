@@ -0,0 +1,151 @@
+package datastreamer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBookmarkSuite checks the bookmarks on a backend
+func testBookmarkSuite(t *testing.T, b *StreamBookmark) {
+	t.Helper()
+
+	// Insert, update and get
+	require.NoError(t, b.AddBookmark([]byte("b1"), 10))
+	require.NoError(t, b.AddBookmark([]byte("b3"), 30))
+	require.NoError(t, b.AddBookmark([]byte("b2"), 20))
+	require.NoError(t, b.AddBookmark([]byte("b2"), 25))
+	require.NoError(t, b.AddBookmark([]byte("a1"), 25))
+	entryNum, err := b.GetBookmark([]byte("b2"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(25), entryNum)
+	_, err = b.GetBookmark([]byte("b4"))
+	require.ErrorIs(t, err, ErrStoreKeyNotFound)
+
+	// Listing in key order
+	results, cursor, err := b.ListBookmarks(nil, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []BookmarkResult{{[]byte("a1"), 25}, {[]byte("b1"), 10}}, results)
+	results, cursor, err = b.ListBookmarks(cursor, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []BookmarkResult{{[]byte("b2"), 25}, {[]byte("b3"), 30}}, results)
+	assert.Nil(t, cursor)
+	results, err = b.GetBookmarksByPrefix([]byte("b"))
+	require.NoError(t, err)
+	assert.Len(t, results, 3)
+	var keys []string
+	require.NoError(t, b.IterateBookmarks(func(key []byte, _ uint64) bool {
+		keys = append(keys, string(key))
+		return len(keys) < 2
+	}))
+	assert.Equal(t, []string{"a1", "b1"}, keys)
+	key, entryNum, found, err := b.seekBookmark([]byte("b2"), true)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("b3"), key)
+	assert.Equal(t, uint64(30), entryNum)
+
+	// Index by entry number following the updates
+	bookmarks, err := b.bookmarksByEntry(25) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a1"), []byte("b2")}, bookmarks)
+	_, found, err = b.GetBookmarkByEntry(20) //nolint:mnd
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// Deleted from an entry number at once
+	deleted, err := b.deleteFrom(25, nil) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), deleted)
+	results, err = b.GetBookmarksByPrefix(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []BookmarkResult{{[]byte("b1"), 10}}, results)
+	_, found, err = b.GetBookmarkByEntry(30) //nolint:mnd
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestBookmarkStores(t *testing.T) {
+	t.Run("leveldb", func(t *testing.T) {
+		b, err := NewBookmark(filepath.Join(t.TempDir(), "bookmarks.db"))
+		require.NoError(t, err)
+		defer b.Close()
+		testBookmarkSuite(t, b)
+	})
+	t.Run("memory", func(t *testing.T) {
+		b, err := NewBookmarkWithStores(NewMemoryBookmarkStore(), nil)
+		require.NoError(t, err)
+		defer b.Close()
+		testBookmarkSuite(t, b)
+	})
+}
+
+func TestMemoryBookmarkStore(t *testing.T) {
+	m := NewMemoryBookmarkStore()
+
+	// Batch applied in order
+	batch := new(BookmarkBatch)
+	batch.Put([]byte("k1"), []byte{1})
+	batch.Put([]byte("k2"), nil)
+	batch.Delete([]byte("k1"))
+	batch.Put([]byte("k3"), []byte{3})
+	assert.Equal(t, 4, batch.Len())
+	require.NoError(t, m.Write(batch))
+	_, err := m.Get([]byte("k1"))
+	require.ErrorIs(t, err, ErrStoreKeyNotFound)
+	value, err := m.Get([]byte("k2"))
+	require.NoError(t, err)
+	assert.Empty(t, value)
+
+	// Iteration from a snapshot
+	var keys []string
+	require.NoError(t, m.Iterate([]byte("k"), func(key, _ []byte) bool {
+		keys = append(keys, string(key))
+		require.NoError(t, m.Put([]byte("k0"), nil))
+		return true
+	}))
+	assert.Equal(t, []string{"k2", "k3"}, keys)
+
+	// Index built from the existing bookmarks
+	m = NewMemoryBookmarkStore()
+	require.NoError(t, m.Put([]byte("b"), []byte{0, 0, 0, 0, 0, 0, 0, 7}))
+	b, err := NewBookmarkWithStores(m, nil)
+	require.NoError(t, err)
+	bookmark, found, err := b.GetBookmarkByEntry(7) //nolint:mnd
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("b"), bookmark)
+}
+
+func TestServerBookmarkStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewMemoryBookmarkStore()
+	s, err := NewServerWithFileOptions(0, 1, 12345, 1, filepath.Join(dir, "stream.bin"), time.Second, time.Minute,
+		time.Minute, nil, StreamFileOptions{BookmarkStore: store})
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamBookmark([]byte("kept"))
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamBookmark([]byte("rolled back"))
+	require.NoError(t, err)
+	require.NoError(t, s.RollbackAtomicOp())
+
+	// Bookmarks in the store given, none in the embedded database
+	value, err := store.Get([]byte("kept"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 0}, value)
+	_, err = store.Get([]byte("rolled back"))
+	require.ErrorIs(t, err, ErrStoreKeyNotFound)
+	entryNum, err := s.GetBookmark([]byte("kept"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), entryNum)
+	assert.NoDirExists(t, bookmarkDBName(s.fileName))
+}
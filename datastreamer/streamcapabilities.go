@@ -0,0 +1,148 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"slices"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// ProtocolVersion is the version of the TCP protocol between the server and the clients
+const ProtocolVersion = 1
+
+const (
+	capAuthRequired = 1 << iota // Flag of the capabilities for the authentication required
+	capTimestamps               // Flag of the capabilities for the commit timestamps available
+	capChecksums                // Flag of the capabilities for the entries checksums present
+	capEncrypted                // Flag of the capabilities for the entries data encrypted at rest
+)
+
+// ServerCapabilities type for what a server supports and the state of its stream, returned by CmdCapabilities
+type ServerCapabilities struct {
+	ProtocolVersions  []uint8    // TCP protocol versions supported
+	CompressionCodecs []string   // Compression codecs available for the entries streamed (none: uncompressed)
+	AuthRequired      bool       // Clients must authenticate before sending commands
+	Timestamps        bool       // Commit timestamps of the entries available (commit journal enabled)
+	Checksums         bool       // Entries streamed with a checksum of their data
+	Encrypted         bool       // Entries data encrypted at rest (streamed decrypted)
+	StreamVersion     uint8      // Stream file version
+	SystemID          uint64     // System identifier (e.g. ChainID)
+	StreamType        StreamType // Stream type served
+	FirstEntry        uint64     // First entry number of the stream
+	TotalEntries      uint64     // Total number of entries (next entry number)
+}
+
+// Capabilities returns the capabilities of the server and the current state of its stream
+func (s *StreamServer) Capabilities() ServerCapabilities {
+	header := s.streamFile.getHeaderEntry()
+	return ServerCapabilities{
+		ProtocolVersions: []uint8{ProtocolVersion},
+		Timestamps:       s.journal != nil,
+		Encrypted:        header.encryption != encryptionNone,
+		StreamVersion:    header.Version,
+		SystemID:         header.SystemID,
+		StreamType:       s.streamType,
+		FirstEntry:       header.firstEntry,
+		TotalEntries:     header.TotalEntries,
+	}
+}
+
+// Capabilities executes client TCP command to get the capabilities of the server, to adapt to them before streaming
+func (c *StreamClient) Capabilities() (ServerCapabilities, error) {
+	_, entry, err := c.execCommand(CmdCapabilities, false, 0, nil)
+	if err != nil {
+		return ServerCapabilities{}, err
+	}
+	return decodeCapabilities(entry.Data)
+}
+
+// encodeCapabilities encodes the server capabilities to binary: flags (u8), protocol versions (u8 count + u8 each),
+// stream version (u8), system ID, stream type, first entry and total entries (u64 each), and compression codecs
+// (u8 count + u8 length and name each)
+func encodeCapabilities(caps ServerCapabilities) []byte {
+	var flags uint8
+	if caps.AuthRequired {
+		flags |= capAuthRequired
+	}
+	if caps.Timestamps {
+		flags |= capTimestamps
+	}
+	if caps.Checksums {
+		flags |= capChecksums
+	}
+	if caps.Encrypted {
+		flags |= capEncrypted
+	}
+
+	be := []byte{flags, uint8(len(caps.ProtocolVersions))}
+	be = append(be, caps.ProtocolVersions...)
+	be = append(be, caps.StreamVersion)
+	be = binary.BigEndian.AppendUint64(be, caps.SystemID)
+	be = binary.BigEndian.AppendUint64(be, uint64(caps.StreamType))
+	be = binary.BigEndian.AppendUint64(be, caps.FirstEntry)
+	be = binary.BigEndian.AppendUint64(be, caps.TotalEntries)
+	be = append(be, uint8(len(caps.CompressionCodecs)))
+	for _, codec := range caps.CompressionCodecs {
+		be = append(be, uint8(len(codec)))
+		be = append(be, codec...)
+	}
+	return be
+}
+
+// decodeCapabilities decodes the server capabilities from binary
+func decodeCapabilities(b []byte) (ServerCapabilities, error) {
+	const fixedSize = 1 + 8 + 8 + 8 + 8 + 1 // Stream version, system ID, stream type, entries and codecs count
+
+	if len(b) < 2 || len(b) < 2+int(b[1])+fixedSize {
+		log.Error("Invalid binary server capabilities")
+		return ServerCapabilities{}, ErrDecodingCapabilities
+	}
+
+	caps := ServerCapabilities{
+		AuthRequired: b[0]&capAuthRequired != 0,
+		Timestamps:   b[0]&capTimestamps != 0,
+		Checksums:    b[0]&capChecksums != 0,
+		Encrypted:    b[0]&capEncrypted != 0,
+	}
+	pos := 2 + int(b[1])
+	caps.ProtocolVersions = slices.Clone(b[2:pos])
+	caps.StreamVersion = b[pos]
+	caps.SystemID = binary.BigEndian.Uint64(b[pos+1 : pos+9])
+	caps.StreamType = StreamType(binary.BigEndian.Uint64(b[pos+9 : pos+17]))
+	caps.FirstEntry = binary.BigEndian.Uint64(b[pos+17 : pos+25])
+	caps.TotalEntries = binary.BigEndian.Uint64(b[pos+25 : pos+33])
+	codecs := int(b[pos+33])
+	pos += fixedSize
+
+	for range codecs {
+		if pos >= len(b) || pos+1+int(b[pos]) > len(b) {
+			log.Error("Invalid binary server capabilities codecs")
+			return ServerCapabilities{}, ErrDecodingCapabilities
+		}
+		caps.CompressionCodecs = append(caps.CompressionCodecs, string(b[pos+1:pos+1+int(b[pos])]))
+		pos += 1 + int(b[pos])
+	}
+	return caps, nil
+}
+
+// processCmdCapabilities processes the TCP Capabilities command from the clients
+func (s *StreamServer) processCmdCapabilities(client *client) error {
+	// Log
+	log.Debugf("Client %s command Capabilities", client.clientID)
+
+	// Send a command result entry OK
+	err := s.sendResultEntry(0, "OK", client)
+	if err != nil {
+		return err
+	}
+
+	// Send the capabilities as a command response with data
+	entry := FileEntry{packetType: PtDataRsp, Data: encodeCapabilities(s.Capabilities())}
+	entry.Length = FixedSizeFileEntry + uint32(len(entry.Data))
+	err = s.sendPacket(client, encodeFileEntryToBinary(entry))
+	if err != nil {
+		log.Errorf("Error sending capabilities to %s: %v", client.clientID, err)
+		return err
+	}
+	return nil
+}
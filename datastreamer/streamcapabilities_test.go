@@ -0,0 +1,72 @@
+package datastreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilities(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	startClientUntilCleanup(t, c)
+
+	// Empty stream without commit journal
+	caps, err := c.Capabilities()
+	require.NoError(t, err)
+	assert.Equal(t, ServerCapabilities{
+		ProtocolVersions: []uint8{ProtocolVersion},
+		StreamVersion:    1,
+		SystemID:         12345, //nolint:mnd
+		StreamType:       1,
+	}, caps)
+
+	// Entries imported at a base number, commit journal enabled
+	require.NoError(t, s.EnableCommitJournal())
+	require.NoError(t, s.StartAtomicOp())
+	for num := uint64(500); num < 510; num++ {
+		require.NoError(t, s.AddStreamEntryWithNumber(num, 1, []byte{byte(num)}))
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	caps, err = c.Capabilities()
+	require.NoError(t, err)
+	assert.True(t, caps.Timestamps)
+	assert.False(t, caps.AuthRequired)
+	assert.False(t, caps.Encrypted)
+	assert.Empty(t, caps.CompressionCodecs)
+	assert.Equal(t, uint64(500), caps.FirstEntry)
+	assert.Equal(t, uint64(510), caps.TotalEntries)
+	assert.Equal(t, s.Capabilities(), caps)
+
+	// Not allowed while streaming
+	require.NoError(t, c.ExecCommandStart(caps.FirstEntry))
+	_, err = c.Capabilities()
+	assert.ErrorIs(t, err, ErrResultCommandError)
+}
+
+func TestCapabilitiesEncoding(t *testing.T) {
+	caps := ServerCapabilities{
+		ProtocolVersions:  []uint8{1, 2},
+		CompressionCodecs: []string{"zstd", "snappy"},
+		AuthRequired:      true,
+		Checksums:         true,
+		StreamVersion:     3,
+		SystemID:          1101,
+		StreamType:        2,
+		FirstEntry:        7,
+		TotalEntries:      42,
+	}
+	b := encodeCapabilities(caps)
+	decoded, err := decodeCapabilities(b)
+	require.NoError(t, err)
+	assert.Equal(t, caps, decoded)
+
+	// Truncated anywhere
+	for i := range b {
+		_, err = decodeCapabilities(b[:i])
+		assert.ErrorIs(t, err, ErrDecodingCapabilities, "length %d", i)
+	}
+}
@@ -0,0 +1,120 @@
+package datastreamer
+
+import (
+	"bytes"
+	"sync"
+)
+
+// CatchUpCacheStats type for the counters of the catch-up cache
+type CatchUpCacheStats struct {
+	Hits    uint64 // Catch-ups starting within the cache, served from memory
+	Misses  uint64 // Catch-ups starting before the cache, read from the file
+	Entries uint64 // Current number of entries cached
+}
+
+// catchUpCache type for the ring buffer of the latest committed entries, streamed to the clients catching up from a
+// recent entry number without reading the file
+type catchUpCache struct {
+	entries []FileEntry       // Ring buffer, the entry number modulo its size is the position of an entry
+	first   uint64            // Entry number of the oldest entry cached
+	count   uint64            // Number of entries cached, consecutive from first
+	stats   CatchUpCacheStats // Counters
+	mutex   sync.Mutex        // Protects the fields above
+}
+
+// SetCatchUpCache keeps the latest committed entries, up to size, in a ring buffer in memory, disabled by default.
+// The clients starting the streaming from an entry number within it catch up from memory, the ones starting before
+// the oldest entry cached from the file. It's filled with the entries committed since it's enabled, and cleared by
+// TruncateFile, UpdateEntryData and the rollback of updates. With size 0 it's disabled. Set before Start.
+func (s *StreamServer) SetCatchUpCache(size int) {
+	if size <= 0 {
+		s.catchUpCache = nil
+		return
+	}
+	s.catchUpCache = &catchUpCache{entries: make([]FileEntry, size)}
+}
+
+// GetCatchUpCacheStats returns the counters of the catch-up cache (zero if disabled)
+func (s *StreamServer) GetCatchUpCacheStats() CatchUpCacheStats {
+	if s.catchUpCache == nil {
+		return CatchUpCacheStats{}
+	}
+	c := s.catchUpCache
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := c.stats
+	stats.Entries = c.count
+	return stats
+}
+
+// SetCatchUpCacheSize keeps the latest entries relayed, up to size, in memory for the relay clients catching up from
+// a recent entry number (see StreamServer.SetCatchUpCache). Set before Start.
+func (r *StreamRelay) SetCatchUpCacheSize(size int) {
+	r.server.SetCatchUpCache(size)
+}
+
+// GetCatchUpCacheStats returns the counters of the catch-up cache of the relay (zero if disabled)
+func (r *StreamRelay) GetCatchUpCacheStats() CatchUpCacheStats {
+	return r.server.GetCatchUpCacheStats()
+}
+
+// add adds the entries of a committed atomic operation, evicting the oldest ones. Entries not following the ones
+// cached restart the cache.
+func (c *catchUpCache) add(entries []FileEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	size := uint64(len(c.entries))
+	for _, e := range entries {
+		if c.count > 0 && e.Number != c.first+c.count {
+			c.count = 0
+		}
+		if c.count == 0 {
+			c.first = e.Number
+		}
+		e.Data = bytes.Clone(e.Data)
+		c.entries[e.Number%size] = e
+		if c.count < size {
+			c.count++
+		} else {
+			c.first++
+		}
+	}
+}
+
+// from returns the entries cached from an entry number and records the catch-up, false if it's not cached
+func (c *catchUpCache) from(entryNum uint64) ([]FileEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.count == 0 || entryNum < c.first || entryNum >= c.first+c.count {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+
+	size := uint64(len(c.entries))
+	entries := make([]FileEntry, 0, c.first+c.count-entryNum)
+	for number := entryNum; number < c.first+c.count; number++ {
+		entries = append(entries, c.entries[number%size])
+	}
+	return entries, true
+}
+
+// tombstone flags a cached entry as logically deleted
+func (c *catchUpCache) tombstone(entryNum uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.count > 0 && entryNum >= c.first && entryNum < c.first+c.count {
+		c.entries[entryNum%uint64(len(c.entries))].Tombstoned = true
+	}
+}
+
+// invalidate clears the cache
+func (c *catchUpCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	clear(c.entries)
+	c.count = 0
+}
@@ -0,0 +1,97 @@
+package datastreamer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cachedNumbers returns the entry numbers cached from an entry number, nil if it's not cached
+func cachedNumbers(c *catchUpCache, entryNum uint64) []uint64 {
+	entries, ok := c.from(entryNum)
+	if !ok {
+		return nil
+	}
+	numbers := make([]uint64, 0, len(entries))
+	for _, e := range entries {
+		numbers = append(numbers, e.Number)
+	}
+	return numbers
+}
+
+func TestCatchUpCacheRing(t *testing.T) {
+	entries := func(from uint64, to uint64) []FileEntry {
+		var entries []FileEntry
+		for number := from; number <= to; number++ {
+			entries = append(entries, FileEntry{Number: number, Data: []byte{byte(number)}})
+		}
+		return entries
+	}
+
+	// Oldest entries evicted
+	c := &catchUpCache{entries: make([]FileEntry, 5)} //nolint:mnd
+	c.add(entries(0, 2))                              //nolint:mnd
+	c.add(entries(3, 7))                              //nolint:mnd
+	assert.Nil(t, cachedNumbers(c, 2))                //nolint:mnd
+	assert.Equal(t, []uint64{3, 4, 5, 6, 7}, cachedNumbers(c, 3))
+	assert.Equal(t, []uint64{6, 7}, cachedNumbers(c, 6))
+	assert.Nil(t, cachedNumbers(c, 8)) //nolint:mnd
+
+	// Entries not following the ones cached restart it
+	c.add(entries(10, 11))             //nolint:mnd
+	assert.Nil(t, cachedNumbers(c, 7)) //nolint:mnd
+	assert.Equal(t, []uint64{10, 11}, cachedNumbers(c, 10))
+
+	// Tombstoned and cleared
+	c.tombstone(11) //nolint:mnd
+	cached, ok := c.from(10)
+	require.True(t, ok)
+	assert.False(t, cached[0].Tombstoned)
+	assert.True(t, cached[1].Tombstoned)
+	assert.Equal(t, []byte{11}, cached[1].Data)
+	c.invalidate()
+	assert.Nil(t, cachedNumbers(c, 10)) //nolint:mnd
+	assert.Equal(t, CatchUpCacheStats{Hits: 4, Misses: 4}, c.stats)
+}
+
+func TestRelayCatchUpCache(t *testing.T) {
+	upstream := newTestServer(t, t.TempDir())
+	r, err := NewRelay(testServerAddr(upstream), 0, 1, 12345, 1, filepath.Join(t.TempDir(), "relay.bin"),
+		time.Second, time.Minute, time.Minute, nil)
+	require.NoError(t, err)
+	r.SetCatchUpCacheSize(5) //nolint:mnd
+	require.NoError(t, r.Start())
+	t.Cleanup(func() { _ = r.Stop() })
+
+	commitUpstreamEntries(t, 10, upstream) //nolint:mnd
+	require.Eventually(t, func() bool { return r.server.GetHeader().TotalEntries == 10 },
+		5*time.Second, 10*time.Millisecond) //nolint:mnd
+	assert.Equal(t, CatchUpCacheStats{Entries: 5}, r.GetCatchUpCacheStats())
+
+	catchUp := func(fromEntry uint64, count int) []uint64 {
+		c, err := NewClient(testServerAddr(r.server), 1)
+		require.NoError(t, err)
+		entries := c.Entries()
+		startClientUntilCleanup(t, c)
+		require.NoError(t, c.ExecCommandStart(fromEntry))
+		return receiveEntries(t, entries, count)
+	}
+
+	// Within the cache, served from memory
+	assert.Equal(t, []uint64{7, 8, 9}, catchUp(7, 3)) //nolint:mnd
+	assert.Equal(t, CatchUpCacheStats{Hits: 1, Entries: 5}, r.GetCatchUpCacheStats())
+
+	// Older than the cache, read from the file
+	assert.Equal(t, []uint64{2, 3, 4, 5, 6, 7, 8, 9}, catchUp(2, 8)) //nolint:mnd
+	assert.Equal(t, CatchUpCacheStats{Hits: 1, Misses: 1, Entries: 5}, r.GetCatchUpCacheStats())
+
+	// Entries relayed later evict the oldest ones
+	commitUpstreamEntries(t, 2, upstream) //nolint:mnd
+	require.Eventually(t, func() bool { return r.server.GetHeader().TotalEntries == 12 },
+		5*time.Second, 10*time.Millisecond) //nolint:mnd
+	assert.Equal(t, []uint64{6, 7, 8, 9, 10, 11}, catchUp(6, 6)) //nolint:mnd
+	assert.Equal(t, CatchUpCacheStats{Hits: 1, Misses: 2, Entries: 5}, r.GetCatchUpCacheStats())
+}
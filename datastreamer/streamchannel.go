@@ -0,0 +1,53 @@
+package datastreamer
+
+const defaultEntriesChanSize = 256 // Default buffer size of the Entries channel
+
+// SetEntriesBufferSize sets the buffer size of the Entries channel, before calling Entries (default 256)
+func (c *StreamClient) SetEntriesBufferSize(size int) {
+	c.entriesChanSize = size
+}
+
+// Entries returns a channel delivering the entries streamed, instead of the callback function, to pull them from
+// another goroutine. It must be called before Start. A full channel applies backpressure up to the server: the client
+// stops reading from the connection, and the server buffers the entries as for any slow client, up to its limits
+// (see SetMaxInFlightBytes and SetClientQueueSize). The channel is closed when the streaming ends: the client is
+// stopped by the context of StartWithContext, the reading ends (see Errors) or processing an entry fails.
+func (c *StreamClient) Entries() <-chan FileEntry {
+	if c.entriesChan == nil {
+		size := c.entriesChanSize
+		if size <= 0 {
+			size = defaultEntriesChanSize
+		}
+		c.entriesChan = make(chan FileEntry, size)
+		c.errorsChan = make(chan error, 1)
+		c.setProcessEntryFunc(c.sendEntry, c.relayServer)
+	}
+	return c.entriesChan
+}
+
+// Errors returns a channel delivering the error ending the streaming of the Entries channel (e.g. ErrDisconnected
+// with the reconnection disabled), closed after it. It must be called after Entries.
+func (c *StreamClient) Errors() <-chan error {
+	return c.errorsChan
+}
+
+// sendEntry is the callback function delivering the entries to the Entries channel, waiting while it's full
+func (c *StreamClient) sendEntry(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+	select {
+	case c.entriesChan <- *e:
+	case <-c.stopped:
+	}
+	return nil
+}
+
+// closeEntriesChannel closes the Entries channel when the streaming ends, delivering its error
+func (c *StreamClient) closeEntriesChannel(err error) {
+	if c.entriesChan == nil {
+		return
+	}
+	if err != nil {
+		c.errorsChan <- err
+	}
+	close(c.entriesChan)
+	close(c.errorsChan)
+}
@@ -0,0 +1,90 @@
+package datastreamer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiveEntries receives a number of entries from a channel and returns their entry numbers
+func receiveEntries(t *testing.T, entries <-chan FileEntry, count int) []uint64 {
+	t.Helper()
+
+	numbers := make([]uint64, 0, count)
+	for range count {
+		select {
+		case e, ok := <-entries:
+			require.True(t, ok, "entries channel closed")
+			numbers = append(numbers, e.Number)
+		case <-time.After(2 * time.Second): //nolint:mnd
+			t.Fatalf("entry not received after %v", numbers)
+		}
+	}
+	return numbers
+}
+
+func TestEntriesChannel(t *testing.T) {
+	const numEntries = 300
+
+	s := newTestServer(t, t.TempDir())
+	commitTestEntry(t, s)
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	c.SetEntriesBufferSize(1)
+	entries := c.Entries()
+	errs := c.Errors()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, c.StartWithContext(ctx))
+	require.NoError(t, c.ExecCommandStart(0))
+	assert.Equal(t, []uint64{0}, receiveEntries(t, entries, 1))
+
+	// Not consumed while more entries than the buffers are committed, then all received in order
+	for range numEntries {
+		commitTestEntry(t, s)
+	}
+	time.Sleep(100 * time.Millisecond) //nolint:mnd
+	numbers := receiveEntries(t, entries, numEntries)
+	for i, num := range numbers {
+		require.Equal(t, uint64(i+1), num)
+	}
+
+	// Closed when the client is stopped, without error
+	cancel()
+	select {
+	case _, ok := <-entries:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("entries channel not closed")
+	}
+	_, ok := <-errs
+	assert.False(t, ok)
+}
+
+func TestEntriesChannelDisconnected(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	commitTestEntry(t, s)
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	c.SetReconnect(false, 0)
+	entries := c.Entries()
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.ExecCommandStart(0))
+	assert.Equal(t, []uint64{0}, receiveEntries(t, entries, 1))
+
+	// The streaming ends with the disconnection
+	require.NoError(t, s.Close())
+	select {
+	case err := <-c.Errors():
+		require.ErrorIs(t, err, ErrDisconnected)
+	case <-time.After(time.Second):
+		t.Fatal("error not received")
+	}
+	_, ok := <-entries
+	assert.False(t, ok)
+}
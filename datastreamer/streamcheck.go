@@ -0,0 +1,512 @@
+package datastreamer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// CheckName type for the name of a consistency check
+type CheckName string
+
+const (
+	CheckMagicNumbers  CheckName = "magic-numbers"  // CheckMagicNumbers checks the file magic numbers
+	CheckHeader        CheckName = "header"         // CheckHeader checks the header entry and the file size
+	CheckPageChecksums CheckName = "page-checksums" // CheckPageChecksums checks the data pages CRC32C (format 2)
+	CheckEntryNumbers  CheckName = "entry-numbers"  // CheckEntryNumbers checks entry numbers are contiguous
+	CheckBookmarks     CheckName = "bookmarks"      // CheckBookmarks checks bookmarks point to their bookmark entry
+	CheckTotals        CheckName = "totals"         // CheckTotals checks header totals match the stored entries
+
+	maxCheckDetails = 10 // Maximum number of detail lines stored per check
+)
+
+// AllChecks is the list of consistency checks in the order they are run
+var AllChecks = []CheckName{CheckMagicNumbers, CheckHeader, CheckPageChecksums, CheckEntryNumbers, CheckBookmarks,
+	CheckTotals}
+
+// CheckResult type for the outcome of a consistency check
+type CheckResult struct {
+	Name    CheckName
+	Passed  bool
+	Skipped bool
+	Details []string
+}
+
+// ConsistencyReport type for the outcome of all the consistency checks of a stream file
+type ConsistencyReport struct {
+	FileName string
+	Header   HeaderEntry
+	Entries  uint64 // Number of data entries found scanning the file
+	Gaps     []GapRange
+	Results  []CheckResult
+}
+
+// GapRange type for a range of missing entry numbers (both included)
+type GapRange struct {
+	From uint64
+	To   uint64
+}
+
+// ConsistencyChecker type to run consistency checks over a stream file and its bookmarks database
+type ConsistencyChecker struct {
+	fileName      string
+	bookmarkDB    string
+	bookmarkStore BookmarkStore // Bookmarks checked instead of the bookmarks DB, if set
+	enabled       map[CheckName]bool
+}
+
+// scanState holds what is learnt from the stream file while checking it
+type scanState struct {
+	file      *os.File
+	fileSize  uint64
+	header    HeaderEntry
+	headerOK  bool
+	entries   uint64
+	next      uint64
+	endPos    uint64
+	scanErr   error
+	numbers   []string
+	gaps      []GapRange
+	bookmarks map[uint64][]byte
+}
+
+// NewConsistencyChecker creates a checker for a stream file with all the checks enabled
+func NewConsistencyChecker(fileName string) *ConsistencyChecker {
+	c := ConsistencyChecker{
+		fileName:   fileName,
+		bookmarkDB: bookmarkDBName(fileName),
+		enabled:    make(map[CheckName]bool),
+	}
+	for _, name := range AllChecks {
+		c.enabled[name] = true
+	}
+	return &c
+}
+
+// SetCheck enables or disables one of the consistency checks
+func (c *ConsistencyChecker) SetCheck(name CheckName, enabled bool) {
+	c.enabled[name] = enabled
+}
+
+// SetBookmarkStore checks the bookmarks of a store instead of the leveldb bookmarks DB next to the file, for the
+// streams using another BookmarkStore (StreamFileOptions). The store is not closed by the checker.
+func (c *ConsistencyChecker) SetBookmarkStore(store BookmarkStore) {
+	c.bookmarkStore = store
+}
+
+// Check runs the enabled consistency checks and returns the report, error only if the file can't be read
+func (c *ConsistencyChecker) Check() (*ConsistencyReport, error) {
+	file, err := os.Open(c.fileName)
+	if err != nil {
+		log.Errorf("Error opening file %s to check: %v", c.fileName, err)
+		return nil, err
+	}
+	defer file.Close()
+
+	// Read the header and scan the entries once, checks work over the results
+	st, headerDetails, err := newScanState(file)
+	if err != nil {
+		return nil, err
+	}
+	report := ConsistencyReport{
+		FileName: c.fileName,
+		Header:   st.header,
+		Entries:  st.entries,
+		Gaps:     st.gaps,
+	}
+
+	for _, name := range AllChecks {
+		if !c.enabled[name] {
+			report.Results = append(report.Results, CheckResult{Name: name, Passed: true, Skipped: true})
+			continue
+		}
+
+		var details []string
+		switch name {
+		case CheckMagicNumbers:
+			details = st.checkMagic()
+		case CheckHeader:
+			details = headerDetails
+		case CheckPageChecksums:
+			if st.headerOK && st.header.FileFormat() == FileFormatV1 {
+				report.Results = append(report.Results, CheckResult{Name: name, Passed: true, Skipped: true,
+					Details: []string{"not checked, file format 1 without page checksums"}})
+				continue
+			}
+			details = st.checkPageChecksums()
+		case CheckEntryNumbers:
+			details = st.checkNumbers()
+		case CheckBookmarks:
+			details = c.checkBookmarks(st)
+		case CheckTotals:
+			details = st.checkTotals()
+		}
+		report.Results = append(report.Results, newCheckResult(name, details, !st.headerOK && name != CheckMagicNumbers &&
+			name != CheckHeader))
+	}
+
+	return &report, nil
+}
+
+// FindEntryNumberGaps scans the file and returns the ranges of missing entry numbers, between the entries stored
+// and after the last one until the header total entries
+func (c *ConsistencyChecker) FindEntryNumberGaps() ([]GapRange, error) {
+	file, err := os.Open(c.fileName)
+	if err != nil {
+		log.Errorf("Error opening file %s to find gaps: %v", c.fileName, err)
+		return nil, err
+	}
+	defer file.Close()
+
+	st, headerDetails, err := newScanState(file)
+	if err != nil {
+		return nil, err
+	}
+	if !st.headerOK {
+		log.Errorf("Error reading header of file %s: %v", c.fileName, headerDetails)
+		return nil, ErrBadFileFormat
+	}
+	if st.scanErr != nil {
+		log.Errorf("Error scanning file %s at offset %d: %v", c.fileName, st.endPos, st.scanErr)
+		return nil, st.scanErr
+	}
+	return st.gaps, nil
+}
+
+// newScanState reads the header and scans the entries of a file, returns the header check failures
+func newScanState(file *os.File) (*scanState, []string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st := &scanState{
+		file:      file,
+		fileSize:  uint64(info.Size()),
+		bookmarks: make(map[uint64][]byte),
+	}
+	headerDetails := st.readHeader()
+	if st.headerOK {
+		st.scanEntries()
+	}
+	return st, headerDetails, nil
+}
+
+// newCheckResult builds a check result from the failure details found
+func newCheckResult(name CheckName, details []string, skipped bool) CheckResult {
+	if skipped {
+		return CheckResult{Name: name, Skipped: true, Details: []string{"not checked, unreadable header"}}
+	}
+	if len(details) > maxCheckDetails {
+		more := len(details) - maxCheckDetails
+		details = append(details[:maxCheckDetails], fmt.Sprintf("... and %d more", more))
+	}
+	return CheckResult{Name: name, Passed: len(details) == 0, Details: details}
+}
+
+// OK returns true if none of the run checks failed
+func (r *ConsistencyReport) OK() bool {
+	for _, res := range r.Results {
+		if !res.Passed && !res.Skipped {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes the report in a human readable format
+func (r *ConsistencyReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "File: %s\n", r.FileName)
+	fmt.Fprintf(w, "Header: version=%d systemID=%d streamType=%d totalLength=%d totalEntries=%d\n",
+		r.Header.Version, r.Header.SystemID, r.Header.streamType, r.Header.TotalLength, r.Header.TotalEntries)
+	fmt.Fprintf(w, "Entries scanned: %d\n", r.Entries)
+	for _, gap := range r.Gaps {
+		fmt.Fprintf(w, "Gap: entries %d to %d missing\n", gap.From, gap.To)
+	}
+	for _, res := range r.Results {
+		status := "OK"
+		switch {
+		case res.Skipped:
+			status = "SKIPPED"
+		case !res.Passed:
+			status = "FAILED"
+		}
+		fmt.Fprintf(w, "[%s] %s\n", status, res.Name)
+		for _, d := range res.Details {
+			fmt.Fprintf(w, "    %s\n", d)
+		}
+	}
+	if r.OK() {
+		fmt.Fprintln(w, "Result: consistent")
+	} else {
+		fmt.Fprintln(w, "Result: INCONSISTENT")
+	}
+}
+
+// readHeader reads the header entry and returns the header check failures
+func (st *scanState) readHeader() []string {
+	binaryHeader := make([]byte, headerSize)
+	_, err := st.file.ReadAt(binaryHeader, magicNumSize)
+	if err != nil {
+		return []string{fmt.Sprintf("can't read header: %v", err)}
+	}
+	st.header, err = decodeBinaryToHeaderEntry(binaryHeader)
+	if err != nil {
+		return []string{fmt.Sprintf("can't decode header: %v", err)}
+	}
+	binaryExt := make([]byte, headerExtSize)
+	_, err = st.file.ReadAt(binaryExt, headerExtPos)
+	if err != nil {
+		return []string{fmt.Sprintf("can't read header extension: %v", err)}
+	}
+	decodeBinaryToHeaderExt(binaryExt, &st.header)
+	st.next = st.header.firstEntry
+
+	var details []string
+	if st.header.packetType != PtHeader {
+		details = append(details, fmt.Sprintf("bad packet type %d", st.header.packetType))
+	}
+	if st.header.headLength != headerSize {
+		details = append(details, fmt.Sprintf("bad header length %d", st.header.headLength))
+	}
+	if st.fileSize < PageHeaderSize || (st.fileSize-PageHeaderSize)%uint64(st.header.PageSize()) != 0 {
+		details = append(details, fmt.Sprintf("file size %d is not a whole number of pages", st.fileSize))
+	}
+	if st.header.TotalLength < PageHeaderSize || st.header.TotalLength > st.fileSize {
+		details = append(details, fmt.Sprintf("total length %d outside the file (size %d)", st.header.TotalLength,
+			st.fileSize))
+	}
+
+	if st.header.firstEntry > st.header.TotalEntries {
+		details = append(details, fmt.Sprintf("first entry %d beyond total entries %d", st.header.firstEntry,
+			st.header.TotalEntries))
+	}
+
+	// Header extension settings
+	if st.header.pageSize != 0 && st.header.pageSize < MinPageSize {
+		details = append(details, fmt.Sprintf("page size %d below the minimum %d", st.header.pageSize, MinPageSize))
+	}
+	if st.header.numbering > numberingCaller {
+		details = append(details, fmt.Sprintf("unknown numbering mode %d", st.header.numbering))
+	}
+	if st.header.encryption > encryptionAESGCM {
+		details = append(details, fmt.Sprintf("unknown encryption mode %d", st.header.encryption))
+	}
+	if st.header.compression > CompressionZstd {
+		details = append(details, fmt.Sprintf("unknown compression mode %d", st.header.compression))
+	}
+	switch {
+	case st.header.checksums > checksumsCRC32C:
+		details = append(details, fmt.Sprintf("unknown page checksums mode %d", st.header.checksums))
+	case st.header.checksums == checksumsNone && st.header.tailCRC != 0:
+		details = append(details, fmt.Sprintf("last page checksum %08x without page checksums", st.header.tailCRC))
+	}
+
+	st.headerOK = len(details) == 0
+	return details
+}
+
+// scanEntries walks all the committed data entries of the file
+func (st *scanState) scanEntries() {
+	st.endPos, st.scanErr = walkEntries(st.file, st.header.TotalLength, st.header.PageSize(), func(pos uint64, e FileEntry) error {
+		if e.Number != st.next {
+			st.numbers = append(st.numbers, fmt.Sprintf("entry at offset %d has number %d, expected %d",
+				pos, e.Number, st.next))
+			if e.Number > st.next {
+				st.gaps = append(st.gaps, GapRange{From: st.next, To: e.Number - 1})
+			}
+		}
+		st.next = e.Number + 1
+		if e.Type == EtBookmark {
+			st.bookmarks[e.Number] = bytes.Clone(e.Data)
+		}
+		st.entries++
+		return nil
+	})
+
+	// Entries missing at the end of the file
+	if st.scanErr == nil && st.next < st.header.TotalEntries {
+		st.gaps = append(st.gaps, GapRange{From: st.next, To: st.header.TotalEntries - 1})
+	}
+}
+
+// checkMagic returns the magic numbers check failures
+func (st *scanState) checkMagic() []string {
+	magic := make([]byte, magicNumSize)
+	_, err := st.file.ReadAt(magic, 0)
+	if err != nil {
+		return []string{fmt.Sprintf("can't read magic numbers: %v", err)}
+	}
+	if !bytes.Equal(magic, magicNumbers) {
+		return []string{fmt.Sprintf("bad magic numbers %q", magic)}
+	}
+	return nil
+}
+
+// checkPageChecksums returns the data pages checksum check failures: each complete page against the checksum in its
+// footer, and the last one against the checksum in the header
+func (st *scanState) checkPageChecksums() []string {
+	var details []string
+	pageSize := uint64(st.header.PageSize())
+	tailPage := (st.header.TotalLength - PageHeaderSize) / pageSize
+	for page := uint64(0); page <= tailPage; page++ {
+		pageStart := PageHeaderSize + page*pageSize
+		checksum, length := st.header.tailCRC, st.header.TotalLength-pageStart
+		if page < tailPage {
+			footer := make([]byte, pageFooterSize-1)
+			_, err := st.file.ReadAt(footer, int64(pageStart+pageSize-pageFooterSize+1))
+			if err != nil {
+				return append(details, fmt.Sprintf("can't read footer of data page %d: %v", page, err))
+			}
+			checksum = binary.BigEndian.Uint32(footer[0:4])
+			length = min(uint64(binary.BigEndian.Uint32(footer[4:8])), pageSize-pageFooterSize)
+		}
+
+		data := make([]byte, length)
+		_, err := st.file.ReadAt(data, int64(pageStart))
+		if err != nil {
+			return append(details, fmt.Sprintf("can't read data page %d: %v", page, err))
+		}
+		if crc32.Checksum(data, crcTable) != checksum {
+			details = append(details, pageChecksumError(page, data).Error())
+		}
+	}
+	return details
+}
+
+// checkNumbers returns the entry number contiguity check failures
+func (st *scanState) checkNumbers() []string {
+	if st.scanErr != nil {
+		return append(st.numbers, fmt.Sprintf("scan stopped: %v", st.scanErr))
+	}
+	return st.numbers
+}
+
+// checkBookmarks returns the bookmarks check failures of the bookmark store, opening the bookmarks DB read-only if
+// none is set
+func (c *ConsistencyChecker) checkBookmarks(st *scanState) []string {
+	store := c.bookmarkStore
+	if store == nil {
+		if _, err := os.Stat(c.bookmarkDB); err != nil {
+			return []string{fmt.Sprintf("can't access bookmarks DB %s: %v", c.bookmarkDB, err)}
+		}
+		db, err := openLevelDBBookmarkStoreReadOnly(c.bookmarkDB)
+		if err != nil {
+			return []string{fmt.Sprintf("can't open bookmarks DB %s: %v", c.bookmarkDB, err)}
+		}
+		defer db.Close()
+		store = db
+	}
+	return st.checkBookmarks(store)
+}
+
+// checkBookmarks returns the check failures of the bookmarks of a store pointing to their bookmark entry
+func (st *scanState) checkBookmarks(store BookmarkStore) []string {
+	var details []string
+	err := store.Iterate(nil, func(key, value []byte) bool {
+		if len(value) != 8 { //nolint:mnd
+			details = append(details, fmt.Sprintf("bookmark %x has an invalid value %x", key, value))
+			return true
+		}
+		entryNum := binary.BigEndian.Uint64(value)
+		data, ok := st.bookmarks[entryNum]
+		switch {
+		case entryNum >= st.header.TotalEntries:
+			details = append(details, fmt.Sprintf("bookmark %x points to entry %d beyond the last entry", key, entryNum))
+		case !ok:
+			details = append(details, fmt.Sprintf("bookmark %x points to entry %d which is not a bookmark entry",
+				key, entryNum))
+		case !bytes.Equal(data, key):
+			details = append(details, fmt.Sprintf("bookmark %x points to entry %d holding bookmark %x", key, entryNum, data))
+		}
+		return true
+	})
+	if err != nil {
+		details = append(details, fmt.Sprintf("error iterating bookmarks: %v", err))
+	}
+	return details
+}
+
+// checkTotals returns the header totals check failures
+func (st *scanState) checkTotals() []string {
+	var details []string
+	if st.scanErr != nil {
+		details = append(details, fmt.Sprintf("scan stopped at offset %d: %v", st.endPos, st.scanErr))
+	}
+	if st.entries != st.header.TotalEntries-st.header.firstEntry {
+		details = append(details, fmt.Sprintf("header total entries %d (first %d) but %d entries found",
+			st.header.TotalEntries, st.header.firstEntry, st.entries))
+	}
+	if st.scanErr == nil && st.endPos != st.header.TotalLength {
+		details = append(details, fmt.Sprintf("header total length %d but entries end at %d", st.header.TotalLength,
+			st.endPos))
+	}
+	return details
+}
+
+// walkEntries reads the data entries stored in pages of pageSize until totalLength calling fn for each one, returns
+// where it stopped
+func walkEntries(r io.ReaderAt, totalLength uint64, pageSize uint32,
+	fn func(pos uint64, e FileEntry) error) (uint64, error) {
+	return walkEntriesFrom(r, PageHeaderSize, totalLength, pageSize, fn)
+}
+
+// walkEntriesFrom reads the data entries as walkEntries starting at a position of an entry (or page pad)
+func walkEntriesFrom(r io.ReaderAt, pos uint64, totalLength uint64, pageSize uint32,
+	fn func(pos uint64, e FileEntry) error) (uint64, error) {
+	pageDataSize := uint64(pageSize)
+	var (
+		page      []byte
+		pageStart uint64
+	)
+
+	for pos < totalLength {
+		// Load the data page containing the position
+		start := PageHeaderSize + ((pos-PageHeaderSize)/pageDataSize)*pageDataSize
+		if page == nil || start != pageStart {
+			pageStart = start
+			size := min(pageDataSize, totalLength-pageStart)
+			page = make([]byte, size)
+			_, err := r.ReadAt(page, int64(pageStart))
+			if err != nil {
+				return pos, err
+			}
+		}
+		off := pos - pageStart
+
+		// Pad goes until the end of the page
+		if page[off] == PtPadding {
+			pos = pageStart + pageDataSize
+			continue
+		}
+		if page[off] != PtData {
+			return pos, fmt.Errorf("%w: packet type %d", ErrExpectingPacketTypeData, page[off])
+		}
+
+		// Entry must be fully inside the page and the committed data
+		if off+FixedSizeFileEntry > uint64(len(page)) {
+			return pos, ErrDecodingLengthDataEntry
+		}
+		length := uint64(binary.BigEndian.Uint32(page[off+1 : off+5]))
+		if length < FixedSizeFileEntry || off+length > uint64(len(page)) {
+			return pos, fmt.Errorf("%w: length %d", ErrDecodingLengthDataEntry, length)
+		}
+
+		e, err := DecodeBinaryToFileEntry(page[off : off+length])
+		if err != nil {
+			return pos, err
+		}
+		err = fn(pos, e)
+		if err != nil {
+			return pos, err
+		}
+		pos += length
+	}
+
+	return pos, nil
+}
@@ -0,0 +1,271 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestStream creates a stream file and its bookmarks DB with a bookmark every 10 entries
+func writeTestStream(t *testing.T, fileName string, numEntries uint64) {
+	t.Helper()
+
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	bm, err := NewBookmark(bookmarkDBName(fileName))
+	require.NoError(t, err)
+
+	for i := uint64(0); i < numEntries; i++ {
+		e := FileEntry{packetType: PtData, Type: 1, Number: i, Data: make([]byte, 1000)} //nolint:mnd
+		if i%10 == 0 {
+			e.Type = EtBookmark
+			e.Data = binary.BigEndian.AppendUint64(nil, i)
+			require.NoError(t, bm.AddBookmark(e.Data, i))
+		}
+		e.Length = uint32(FixedSizeFileEntry + len(e.Data))
+		require.NoError(t, sf.AddFileEntry(e))
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	require.NoError(t, sf.Close())
+	require.NoError(t, bm.Close())
+}
+
+// patchFile overwrites bytes of a file at an offset
+func patchFile(t *testing.T, fileName string, offset int64, data []byte) {
+	t.Helper()
+
+	f, err := os.OpenFile(fileName, os.O_RDWR, fileMode)
+	require.NoError(t, err)
+	_, err = f.WriteAt(data, offset)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func checkResult(t *testing.T, report *ConsistencyReport, name CheckName) CheckResult {
+	t.Helper()
+
+	for _, res := range report.Results {
+		if res.Name == name {
+			return res
+		}
+	}
+	t.Fatalf("check %s not in report", name)
+	return CheckResult{}
+}
+
+func TestCheckHealthyFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "healthy.bin")
+	writeTestStream(t, fileName, 2500) //nolint:mnd
+
+	report, err := NewConsistencyChecker(fileName).Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+	assert.Equal(t, uint64(2500), report.Entries)
+	assert.Len(t, report.Results, len(AllChecks))
+}
+
+func TestCheckCorruptions(t *testing.T) {
+	firstEntry := int64(PageHeaderSize)
+	entryLength := int64(FixedSizeFileEntry + 8) // bookmark entry 0
+
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, fileName string)
+		failed  CheckName
+	}{
+		{
+			name: "bad magic numbers",
+			corrupt: func(t *testing.T, fileName string) {
+				t.Helper()
+				patchFile(t, fileName, 0, []byte("X"))
+			},
+			failed: CheckMagicNumbers,
+		},
+		{
+			name: "header total entries",
+			corrupt: func(t *testing.T, fileName string) {
+				t.Helper()
+				patchFile(t, fileName, magicNumSize+30, binary.BigEndian.AppendUint64(nil, 99)) //nolint:mnd
+			},
+			failed: CheckTotals,
+		},
+		{
+			name: "header packet type",
+			corrupt: func(t *testing.T, fileName string) {
+				t.Helper()
+				patchFile(t, fileName, magicNumSize, []byte{PtData})
+			},
+			failed: CheckHeader,
+		},
+		{
+			name: "header compression mode",
+			corrupt: func(t *testing.T, fileName string) {
+				t.Helper()
+				patchFile(t, fileName, headerExtPos+48, []byte{9}) //nolint:mnd
+			},
+			failed: CheckHeader,
+		},
+		{
+			name: "header page size",
+			corrupt: func(t *testing.T, fileName string) {
+				t.Helper()
+				patchFile(t, fileName, headerExtPos+39, binary.BigEndian.AppendUint32(nil, 4096)) //nolint:mnd
+			},
+			failed: CheckHeader,
+		},
+		{
+			name: "entry number gap",
+			corrupt: func(t *testing.T, fileName string) {
+				t.Helper()
+				patchFile(t, fileName, firstEntry+entryLength+9, binary.BigEndian.AppendUint64(nil, 7)) //nolint:mnd
+			},
+			failed: CheckEntryNumbers,
+		},
+		{
+			name: "flipped data byte",
+			corrupt: func(t *testing.T, fileName string) {
+				t.Helper()
+				patchFile(t, fileName, firstEntry+entryLength+FixedSizeFileEntry, []byte{0xff})
+			},
+			failed: CheckPageChecksums,
+		},
+		{
+			name: "broken entry packet",
+			corrupt: func(t *testing.T, fileName string) {
+				t.Helper()
+				patchFile(t, fileName, firstEntry+entryLength, []byte{0x33})
+			},
+			failed: CheckTotals,
+		},
+		{
+			name: "dangling bookmark",
+			corrupt: func(t *testing.T, fileName string) {
+				t.Helper()
+				bm, err := NewBookmark(bookmarkDBName(fileName))
+				require.NoError(t, err)
+				require.NoError(t, bm.AddBookmark([]byte("dangling"), 5000)) //nolint:mnd
+				require.NoError(t, bm.AddBookmark([]byte("wrong"), 3))       //nolint:mnd
+				require.NoError(t, bm.Close())
+			},
+			failed: CheckBookmarks,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "corrupt.bin")
+			writeTestStream(t, fileName, 100) //nolint:mnd
+			tc.corrupt(t, fileName)
+
+			checker := NewConsistencyChecker(fileName)
+			report, err := checker.Check()
+			require.NoError(t, err)
+			assert.False(t, report.OK())
+			res := checkResult(t, report, tc.failed)
+			assert.False(t, res.Passed)
+			assert.NotEmpty(t, res.Details)
+
+			// Disabling the failing checks makes the rest of the report pass
+			for _, r := range report.Results {
+				if !r.Passed && !r.Skipped {
+					checker.SetCheck(r.Name, false)
+				}
+			}
+			report, err = checker.Check()
+			require.NoError(t, err)
+			assert.True(t, report.OK())
+			assert.True(t, checkResult(t, report, tc.failed).Skipped)
+		})
+	}
+}
+
+func TestCheckPageChecksums(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "checksums.bin")
+	writeTestStream(t, fileName, 2500) //nolint:mnd
+
+	// A byte flipped in a complete page (footer checksum) and in the last page (header checksum)
+	patchFile(t, fileName, PageHeaderSize+500, []byte{0xff})                //nolint:mnd
+	patchFile(t, fileName, PageHeaderSize+2*PageDataSize+500, []byte{0xff}) //nolint:mnd
+	report, err := NewConsistencyChecker(fileName).Check()
+	require.NoError(t, err)
+	res := checkResult(t, report, CheckPageChecksums)
+	assert.False(t, res.Passed)
+	require.Len(t, res.Details, 2)
+	assert.Contains(t, res.Details[0], "page 0,")
+	assert.Contains(t, res.Details[1], "page 2,")
+	assert.True(t, checkResult(t, report, CheckEntryNumbers).Passed)
+
+	// Files without page checksums skip it
+	fileName = filepath.Join(t.TempDir(), "v1.bin")
+	writeTestStream(t, fileName, 100)                                                //nolint:mnd
+	patchFile(t, fileName, headerExtPos+43, []byte{byte(checksumsNone), 0, 0, 0, 0}) //nolint:mnd
+	patchFile(t, fileName, PageHeaderSize+500, []byte{0xff})                         //nolint:mnd
+	report, err = NewConsistencyChecker(fileName).Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+	assert.True(t, checkResult(t, report, CheckPageChecksums).Skipped)
+}
+
+func TestCheckBookmarkStore(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "store.bin")
+	writeTestStream(t, fileName, 100) //nolint:mnd
+
+	// Bookmarks of another store, instead of the bookmarks DB
+	store := NewMemoryBookmarkStore()
+	bookmark := binary.BigEndian.AppendUint64(nil, 10) //nolint:mnd
+	require.NoError(t, store.Put(bookmark, bookmark))
+	checker := NewConsistencyChecker(fileName)
+	checker.SetBookmarkStore(store)
+	report, err := checker.Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+
+	require.NoError(t, store.Put([]byte("dangling"), binary.BigEndian.AppendUint64(nil, 5000))) //nolint:mnd
+	report, err = checker.Check()
+	require.NoError(t, err)
+	res := checkResult(t, report, CheckBookmarks)
+	assert.False(t, res.Passed)
+	assert.Len(t, res.Details, 1)
+}
+
+func TestCheckMissingFile(t *testing.T) {
+	_, err := NewConsistencyChecker(filepath.Join(t.TempDir(), "missing.bin")).Check()
+	assert.Error(t, err)
+}
+
+func TestFindEntryNumberGaps(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "gaps.bin")
+	writeTestStream(t, fileName, 100) //nolint:mnd
+
+	gaps, err := NewConsistencyChecker(fileName).FindEntryNumberGaps()
+	require.NoError(t, err)
+	assert.Empty(t, gaps)
+
+	// Inject a gap writing entries 0-4 and 8-9, with 12 total entries in the header
+	fileName = filepath.Join(t.TempDir(), "injected.bin")
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	for _, num := range []uint64{0, 1, 2, 3, 4, 8, 9} {
+		e := FileEntry{packetType: PtData, Type: 1, Number: num, Data: []byte{byte(num)}}
+		e.Length = uint32(FixedSizeFileEntry + len(e.Data))
+		require.NoError(t, sf.AddFileEntry(e))
+	}
+	sf.header.TotalEntries = 12
+	require.NoError(t, sf.writeHeaderEntry())
+	require.NoError(t, sf.Close())
+
+	checker := NewConsistencyChecker(fileName)
+	gaps, err = checker.FindEntryNumberGaps()
+	require.NoError(t, err)
+	assert.Equal(t, []GapRange{{From: 5, To: 7}, {From: 10, To: 11}}, gaps)
+
+	// The fsck report includes them
+	report, err := checker.Check()
+	require.NoError(t, err)
+	assert.Equal(t, gaps, report.Gaps)
+	assert.False(t, checkResult(t, report, CheckEntryNumbers).Passed)
+}
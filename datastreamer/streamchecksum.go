@@ -0,0 +1,269 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// checksumMode type for the checksums of the data pages of a stream file
+type checksumMode uint8
+
+const (
+	checksumsNone   checksumMode = iota // No checksums (files created before them)
+	checksumsCRC32C                     // CRC32 (Castagnoli) of the entries of each data page
+
+	pageFooterSize = 9 // Size of the data page footer (1+4+4): pad, checksum and length of the entries
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// PageChecksumError type for the error of a data page whose entries don't match its checksum, it matches
+// ErrPageChecksumMismatch with errors.Is
+type PageChecksumError struct {
+	Page       uint64 // Data page number (0: the first one after the header page)
+	FirstEntry uint64 // First entry number found in the page
+	LastEntry  uint64 // Last entry number found in the page
+}
+
+// Error returns the error description with the page and its entries
+func (e *PageChecksumError) Error() string {
+	return fmt.Sprintf("%v: page %d, entries %d to %d", ErrPageChecksumMismatch, e.Page, e.FirstEntry, e.LastEntry)
+}
+
+// Unwrap returns ErrPageChecksumMismatch
+func (e *PageChecksumError) Unwrap() error {
+	return ErrPageChecksumMismatch
+}
+
+// pageChecksums type for the data pages already verified by the readers of a stream file
+type pageChecksums struct {
+	mutex      sync.Mutex
+	pages      map[uint64]struct{} // Complete data pages verified
+	tailPage   uint64              // Last data page with a prefix verified
+	tailLength uint64              // Length of the prefix of the last data page verified
+	tailCRC    uint32              // Checksum of the prefix of the last data page verified
+}
+
+// reset forgets the data pages verified from a page onwards (rewritten)
+func (c *pageChecksums) reset(page uint64) {
+	for p := range c.pages {
+		if p >= page {
+			delete(c.pages, p)
+		}
+	}
+	if c.tailPage >= page {
+		c.tailPage, c.tailLength, c.tailCRC = 0, 0, 0
+	}
+}
+
+// pageCapacity returns the bytes of a data page available for the entries
+func (f *StreamFile) pageCapacity() uint64 {
+	if f.header.checksums == checksumsNone {
+		return uint64(f.pageSize)
+	}
+	return uint64(f.pageSize) - pageFooterSize
+}
+
+// pageStart returns the data page of a file position and the position where it starts
+func (f *StreamFile) pageStart(pos uint64) (uint64, uint64) {
+	page := (pos - PageHeaderSize) / uint64(f.pageSize)
+	return page, PageHeaderSize + page*uint64(f.pageSize)
+}
+
+// readRange reads the bytes of the file between two positions
+func (f *StreamFile) readRange(from, to uint64) ([]byte, error) {
+	b := make([]byte, to-from)
+	_, err := f.file.ReadAt(b, int64(from))
+	if err != nil {
+		log.Errorf("Error reading data page bytes %d to %d: %v", from, to, err)
+		return nil, err
+	}
+	return b, nil
+}
+
+// writePageFooter writes the checksum and length of the entries at the end of the current data page, once complete
+func (f *StreamFile) writePageFooter(length uint64) error {
+	_, pageStart := f.pageStart(f.header.TotalLength)
+	footer := binary.BigEndian.AppendUint32(nil, f.header.tailCRC)
+	footer = binary.BigEndian.AppendUint32(footer, uint32(length))
+	_, err := f.file.WriteAt(footer, int64(pageStart+uint64(f.pageSize)-pageFooterSize+1))
+	if err != nil {
+		log.Errorf("Error writing data page footer: %v", err)
+	}
+	return err
+}
+
+// readPageFooter reads the checksum and length of the entries of a complete data page
+func (f *StreamFile) readPageFooter(pageStart uint64) (uint32, uint64, error) {
+	footer, err := f.readRange(pageStart+uint64(f.pageSize)-pageFooterSize+1, pageStart+uint64(f.pageSize))
+	if err != nil {
+		return 0, 0, err
+	}
+	length := min(uint64(binary.BigEndian.Uint32(footer[4:8])), f.pageCapacity())
+	return binary.BigEndian.Uint32(footer[0:4]), length, nil
+}
+
+// verifyPage checks the checksum of the data page of a file position with the committed entries of a header.
+// Complete pages are verified just once, the last page as its committed entries grow.
+func (f *StreamFile) verifyPage(header HeaderEntry, pos uint64) error {
+	if header.checksums == checksumsNone {
+		return nil
+	}
+	page, pageStart := f.pageStart(pos)
+	tailPage, _ := f.pageStart(header.TotalLength)
+
+	c := &f.verified
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch {
+	case page < tailPage:
+		if _, ok := c.pages[page]; ok {
+			return nil
+		}
+		checksum, length, err := f.readPageFooter(pageStart)
+		if err != nil {
+			return err
+		}
+		data, err := f.readRange(pageStart, pageStart+length)
+		if err != nil {
+			return err
+		}
+		if crc32.Checksum(data, crcTable) != checksum {
+			return pageChecksumError(page, data)
+		}
+		if c.pages == nil {
+			c.pages = make(map[uint64]struct{})
+		}
+		c.pages[page] = struct{}{}
+
+	case page == tailPage:
+		// Verify just the entries committed since the previous verification
+		length := header.TotalLength - pageStart
+		if c.tailPage != page || c.tailLength > length {
+			c.tailPage, c.tailLength, c.tailCRC = page, 0, 0
+		}
+		if c.tailLength < length {
+			data, err := f.readRange(pageStart+c.tailLength, pageStart+length)
+			if err != nil {
+				return err
+			}
+			c.tailCRC = crc32.Update(c.tailCRC, crcTable, data)
+			c.tailLength = length
+		}
+		if c.tailCRC != header.tailCRC {
+			c.reset(page)
+			data, err := f.readRange(pageStart, pageStart+length)
+			if err != nil {
+				return err
+			}
+			return pageChecksumError(page, data)
+		}
+	}
+
+	return nil
+}
+
+// updatePageChecksum updates the checksums of the data page of a file position after an entry is rewritten in place
+func (f *StreamFile) updatePageChecksum(pos uint64) error {
+	f.mutexHeader.Lock()
+	header, written := f.header, f.writtenHead
+	f.mutexHeader.Unlock()
+	if header.checksums == checksumsNone {
+		return nil
+	}
+	page, pageStart := f.pageStart(pos)
+	tailPage, _ := f.pageStart(header.TotalLength)
+	writtenTailPage, _ := f.pageStart(written.TotalLength)
+
+	f.verified.mutex.Lock()
+	defer f.verified.mutex.Unlock()
+	f.verified.reset(page)
+
+	// Complete page: checksum in its footer
+	if page < tailPage {
+		_, length, err := f.readPageFooter(pageStart)
+		if err != nil {
+			return err
+		}
+		data, err := f.readRange(pageStart, pageStart+length)
+		if err != nil {
+			return err
+		}
+		_, err = f.file.WriteAt(binary.BigEndian.AppendUint32(nil, crc32.Checksum(data, crcTable)),
+			int64(pageStart+uint64(f.pageSize)-pageFooterSize+1))
+		if err != nil {
+			log.Errorf("Error writing data page checksum: %v", err)
+			return err
+		}
+	}
+
+	// Last page of the header in memory (atomic operation in progress)
+	if page == tailPage {
+		data, err := f.readRange(pageStart, header.TotalLength)
+		if err != nil {
+			return err
+		}
+		f.mutexHeader.Lock()
+		f.header.tailCRC = crc32.Checksum(data, crcTable)
+		f.mutexHeader.Unlock()
+	}
+
+	// Last page of the committed header, written again with the new checksum
+	if page == writtenTailPage {
+		data, err := f.readRange(pageStart, written.TotalLength)
+		if err != nil {
+			return err
+		}
+		written.tailCRC = crc32.Checksum(data, crcTable)
+		err = f.writeHeader(written)
+		if err != nil {
+			return err
+		}
+		f.mutexHeader.Lock()
+		f.writtenHead.tailCRC = written.tailCRC
+		f.mutexHeader.Unlock()
+	}
+
+	return nil
+}
+
+// truncatePageChecksums returns the checksum of the entries of the last data page for a file truncated at a position
+func (f *StreamFile) truncatePageChecksums(pos uint64) (uint32, error) {
+	if f.header.checksums == checksumsNone {
+		return 0, nil
+	}
+	page, pageStart := f.pageStart(pos)
+
+	f.verified.mutex.Lock()
+	f.verified.reset(page)
+	f.verified.mutex.Unlock()
+
+	data, err := f.readRange(pageStart, pos)
+	if err != nil {
+		return 0, err
+	}
+	return crc32.Checksum(data, crcTable), nil
+}
+
+// pageChecksumError returns the checksum mismatch error of a data page with the range of the entries found in it
+func pageChecksumError(page uint64, data []byte) error {
+	e := &PageChecksumError{Page: page}
+	for off, found := uint64(0), false; off+FixedSizeFileEntry <= uint64(len(data)) && data[off] == PtData; {
+		length := uint64(binary.BigEndian.Uint32(data[off+1 : off+5]))
+		if length < FixedSizeFileEntry || off+length > uint64(len(data)) {
+			break
+		}
+		e.LastEntry = binary.BigEndian.Uint64(data[off+9 : off+17])
+		if !found {
+			e.FirstEntry, found = e.LastEntry, true
+		}
+		off += length
+	}
+	log.Errorf("Checksum mismatch of data page %d, entries %d to %d", page, e.FirstEntry, e.LastEntry)
+	return e
+}
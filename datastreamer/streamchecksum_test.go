@@ -0,0 +1,109 @@
+package datastreamer
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readTestEntry reads an entry of a stream file with an iterator
+func readTestEntry(sf *StreamFile, num uint64) (FileEntry, error) {
+	iterator, err := sf.iteratorFrom(num, true)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	defer sf.iteratorEnd(iterator)
+	_, err = sf.iteratorNext(iterator)
+	return iterator.Entry, err
+}
+
+func TestPageChecksums(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	open := func() *StreamFile {
+		sf, err := NewStreamFileWithOptions(fileName, 1, 12345, 1, StreamFileOptions{PageSize: MinPageSize})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = sf.Close() })
+		return sf
+	}
+
+	// 64 entries in the first data page, the rest in the second one
+	sf := open()
+	for range 100 {
+		addTestEntry(t, sf, 1000) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	for num := range uint64(100) {
+		e, err := readTestEntry(sf, num)
+		require.NoError(t, err)
+		assert.Equal(t, num, e.Number)
+	}
+
+	// Entries updated in place in a complete page and in the last one
+	require.NoError(t, sf.updateEntryData(10, 1, make([]byte, 1000))) //nolint:mnd
+	require.NoError(t, sf.tombstoneEntry(90))                         //nolint:mnd
+	require.NoError(t, sf.updateEntryData(95, 1, make([]byte, 1000))) //nolint:mnd
+	require.NoError(t, sf.Close())
+	sf = open()
+	for _, num := range []uint64{10, 63, 64, 90, 99} {
+		_, err := readTestEntry(sf, num)
+		require.NoError(t, err)
+	}
+	require.NoError(t, sf.Close())
+
+	// Corrupted byte in the first data page
+	patchFile(t, fileName, PageHeaderSize+5*1017+100, []byte{0xff}) //nolint:mnd
+	sf = open()
+	_, err := readTestEntry(sf, 20) //nolint:mnd
+	require.ErrorIs(t, err, ErrPageChecksumMismatch)
+	var checksumErr *PageChecksumError
+	require.True(t, errors.As(err, &checksumErr))
+	assert.Equal(t, PageChecksumError{Page: 0, FirstEntry: 0, LastEntry: 63}, *checksumErr)
+	_, err = readTestEntry(sf, 80) //nolint:mnd
+	require.NoError(t, err)
+	require.NoError(t, sf.Close())
+
+	// Corrupted byte in the last data page
+	patchFile(t, fileName, PageHeaderSize+MinPageSize+100, []byte{0xff}) //nolint:mnd
+	sf = open()
+	_, err = readTestEntry(sf, 80) //nolint:mnd
+	require.True(t, errors.As(err, &checksumErr))
+	assert.Equal(t, PageChecksumError{Page: 1, FirstEntry: 64, LastEntry: 99}, *checksumErr)
+	require.NoError(t, sf.Close())
+
+	// Files without checksums are not verified
+	patchFile(t, fileName, headerExtPos+43, []byte{byte(checksumsNone)}) //nolint:mnd
+	sf = open()
+	for _, num := range []uint64{20, 80} {
+		e, err := readTestEntry(sf, num)
+		require.NoError(t, err)
+		assert.Equal(t, num, e.Number)
+	}
+}
+
+func TestPageChecksumsTruncate(t *testing.T) {
+	sf, err := NewStreamFileWithOptions(filepath.Join(t.TempDir(), "stream.bin"), 1, 12345, 1,
+		StreamFileOptions{PageSize: MinPageSize})
+	require.NoError(t, err)
+	defer sf.Close()
+	for range 100 {
+		addTestEntry(t, sf, 1000) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	_, err = readTestEntry(sf, 70) //nolint:mnd
+	require.NoError(t, err)
+
+	// The entries written again after a truncate are checked with the new checksum
+	require.NoError(t, sf.truncateFile(70)) //nolint:mnd
+	for range 10 {
+		addTestEntry(t, sf, 500) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	for num := uint64(60); num < 80; num++ {
+		e, err := readTestEntry(sf, num)
+		require.NoError(t, err)
+		assert.Equal(t, num, e.Number)
+	}
+}
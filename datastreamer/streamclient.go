@@ -1,13 +1,18 @@
 package datastreamer
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -22,6 +27,29 @@ const (
 // ProcessEntryFunc type of the callback function to process the received entry
 type ProcessEntryFunc func(*FileEntry, *StreamClient, *StreamServer) error
 
+// ProcessCheckpointFunc type of the callback function to process the received download checkpoint
+// (all the entries before the checkpoint have been processed)
+type ProcessCheckpointFunc func(DownloadCheckpoint, *StreamClient) error
+
+// DeadLetterFunc type of the callback function receiving the entries that failed to be processed
+type DeadLetterFunc func(e *FileEntry, err error)
+
+// FailureAction type for the action when an entry can't be processed
+type FailureAction uint8
+
+const (
+	FailureStop       FailureAction = iota // FailureStop stops processing the streaming (default)
+	FailureDeadLetter                      // FailureDeadLetter routes the entry to the dead-letter handler and continues
+)
+
+// ProcessFailurePolicy type for the handling of the entries that fail to be processed
+type ProcessFailurePolicy struct {
+	Retries       int            // Number of retries of the failed entry before applying the action
+	RetryInterval time.Duration  // Time to wait between retries
+	Action        FailureAction  // Action once the retries are exhausted
+	OnDeadLetter  DeadLetterFunc // Dead-letter handler for the FailureDeadLetter action
+}
+
 // StreamClient type to manage a data stream client
 type StreamClient struct {
 	server       string // Server address to connect IP:port
@@ -33,15 +61,60 @@ type StreamClient struct {
 	streaming    bool   // Flag client streaming started
 	fromStream   uint64 // Start entry number from latest start command
 	totalEntries uint64 // Total entries from latest header command
+	downloading  bool   // Flag client download in progress
+
+	bookmarkPrefix   []byte          // Bookmark prefix filtering the streaming (nil: all the entries)
+	prefixResume     uint64          // Entry number to resume the filtered streaming from (latest bookmark received)
+	startBookmark    []byte          // Bookmark of the streaming started from a bookmark until its first entry is delivered
+	resyncSummary    ResyncSummary   // Summary of the client state sent with the resync command
+	maxLatency       time.Duration   // Latency target sent with the start command
+	entryTypes       []EntryType     // Entry types requested by the start command (nil: all the entries)
+	projection       ProjectionField // Fields of the entries requested by the projection subscription (0: full entries)
+	staleResults     atomic.Int32    // Results of the commands abandoned by their context, discarded when received
+	tlsConfig        *tls.Config     // TLS configuration to connect to the server (nil: plain TCP)
+	noReconnect      bool            // Stop at the first disconnection instead of reconnecting
+	maxBackoff       time.Duration   // Max delay between the reconnection attempts (0: fixed delay)
+	reconnectAttempt int             // Failed connection attempts since the latest connection
+	connState        atomic.Uint32   // Connection state (ConnectionState)
+	heartbeat        bool            // Heartbeats negotiated with the server on the connection
+	wireCodecs       []Codec         // Codecs advertised to receive the entries compressed (nil: none)
+	wireCodec        Codec           // Codec of the entries data negotiated with the server on the connection
+	verifyChecksums  bool            // Verify the checksum of the entries received
+	entryChecksums   bool            // Checksum of the entries negotiated with the server on the connection
+	mutexWrite       sync.Mutex      // Mutex for the commands written from several goroutines (heartbeats acks)
+	readErr          error           // Reason the reading from the server ended (nil: stopped by the context)
+	stopped          chan struct{}   // Closed when the context of StartWithContext ends, stopping the client
+	mutexConn        sync.Mutex      // Mutex for the connection closed by the context from another goroutine
+	running          sync.WaitGroup  // Goroutines started by StartWithContext
+
+	tracer         trace.Tracer      // Tracer of the processing of the entries (nil: no tracing)
+	traceContext   bool              // Trace context of the entries negotiated with the server on the connection
+	remoteSpan     trace.SpanContext // Span context of the broadcast of the latest entries received
+	remoteSpanLast uint64            // Last entry number of the broadcast of remoteSpan
+
+	servers     []string      // Server addresses to fail over to in turn, starting with server (nil: just server)
+	serverIndex atomic.Int32  // Index in servers of the server address to connect
+	failovers   atomic.Uint64 // Switches to the next server address after a disconnection or a failed attempt
 
 	results  chan ResultEntry // Channel to read command results
 	headers  chan HeaderEntry // Channel to read header entries from the command Header
 	entries  chan FileEntry   // Channel to read data entries from the streaming
 	entryRsp chan FileEntry   // Channel to read data entries from the commands response
 
-	nextEntry    uint64           // Next entry number to receive from streaming
-	processEntry ProcessEntryFunc // Callback function to process the entry
-	relayServer  *StreamServer    // Only used by the client on the stream relay server
+	nextEntry         uint64                // Next entry number to deliver from streaming (after the latest delivered)
+	processEntry      ProcessEntryFunc      // Callback function to process the entry
+	processProjection ProcessProjectionFunc // Callback function to process the projected entry
+	failurePolicy     ProcessFailurePolicy  // Handling of the entries that fail to be processed
+	relayServer       *StreamServer         // Only used by the client on the stream relay server
+	entriesChan       chan FileEntry        // Entries delivered by the Entries channel (nil: not used)
+	errorsChan        chan error            // Error ending the streaming delivered by the Errors channel
+	entriesChanSize   int                   // Buffer size of the Entries channel
+	delivered         *EntryBitmap          // Entries processed successfully (nil: not tracked)
+
+	checkpoint         DownloadCheckpoint    // Latest download checkpoint processed
+	checkpointInterval uint64                // Number of entries between checkpoints requested for the download
+	processCheckpoint  ProcessCheckpointFunc // Callback function to process the download checkpoint
+	mutexDownload      sync.Mutex            // Mutex for the download and bookmark prefix state, and nextEntry
 }
 
 // NewClient creates a new data stream client
@@ -80,18 +153,44 @@ func NewClientWithLogsConfig(server string, streamType StreamType, logsConfig lo
 
 // Start connects to the data stream server and starts getting data from the server
 func (c *StreamClient) Start() error {
+	return c.StartWithContext(context.Background())
+}
+
+// StartWithContext connects to the data stream server and starts getting data from the server as Start, until the
+// context ends: then the connection is closed and the goroutines reading and processing the entries return, without
+// reconnecting. It returns the context error if it ends before the client is connected.
+func (c *StreamClient) StartWithContext(ctx context.Context) error {
+	c.stopped = make(chan struct{})
+	if ctx.Done() != nil {
+		c.running.Add(1)
+		go func() {
+			defer c.running.Done()
+			<-ctx.Done()
+			c.stop()
+		}()
+	}
+
 	// Connect to server
 	c.connectServer()
+	if c.isStopped() {
+		return ctx.Err()
+	}
 
 	// Goroutine to read from the server all entry types
-	go c.readEntries()
+	c.running.Add(2) //nolint:mnd
+	go func() {
+		defer c.running.Done()
+		c.readEntries()
+	}()
 
 	// Goroutine to consume streaming entries
 	go func() {
+		defer c.running.Done()
 		err := c.getStreaming()
 		if err != nil {
 			log.Errorf("%s Error while getting streaming: %v", c.ID, err)
 		}
+		c.closeEntriesChannel(err)
 	}()
 
 	// Flag stared
@@ -102,59 +201,196 @@ func (c *StreamClient) Start() error {
 
 // connectServer waits until the server connection is established and returns if a command result is pending
 func (c *StreamClient) connectServer() bool {
-	var err error
-
 	// Connect to server
-	for !c.connected {
-		c.conn, err = net.Dial("tcp", c.server)
+	for attempt := 0; !c.connected && !c.isStopped(); attempt++ {
+		if c.ConnectionState() != ConnReconnecting {
+			c.setConnectionState(ConnConnecting)
+		}
+		if attempt > 0 || c.ConnectionState() == ConnReconnecting {
+			c.failover()
+		}
+		conn, err := c.dial()
 		if err != nil {
 			log.Errorf("Error connecting to server %s: %v", c.server, err)
-			time.Sleep(defaultTimeout)
+			c.waitReconnect()
+			continue
+		}
+
+		// Connected, unless the client was stopped meanwhile
+		c.mutexConn.Lock()
+		if c.isStopped() {
+			c.mutexConn.Unlock()
+			conn.Close()
+			return false
+		}
+		c.conn = conn
+		c.mutexConn.Unlock()
+		c.connected = true
+		c.ID = c.conn.LocalAddr().String()
+		log.Infof("%s Connected to server: %s", c.ID, c.server)
+
+		// Negotiate the heartbeats, the wire compression, the trace context and the entry checksums, and restore
+		// streaming
+		err = c.negotiateHeartbeat()
+		if err == nil {
+			err = c.negotiateWireCompression()
+		}
+		if err == nil {
+			err = c.negotiateTraceContext()
+		}
+		if err == nil {
+			err = c.negotiateEntryChecksums()
+		}
+		if err != nil {
+			c.closeConnection()
+			c.waitReconnect()
+			continue
+		}
+		pending, err := c.restoreStreaming()
+		if err != nil {
+			c.closeConnection()
+			c.waitReconnect()
 			continue
-		} else {
-			// Connected
-			c.connected = true
-			c.ID = c.conn.LocalAddr().String()
-			log.Infof("%s Connected to server: %s", c.ID, c.server)
-
-			// Restore streaming
-			if c.streaming {
-				_, _, err = c.execCommand(CmdStart, true, c.nextEntry, nil)
-				if err != nil {
-					c.closeConnection()
-					time.Sleep(defaultTimeout)
-					continue
-				}
-				return true
-			} else {
-				return false
-			}
 		}
+		c.reconnectAttempt = 0
+		c.setConnectionState(ConnConnected)
+		return pending
 	}
 	return false
 }
 
+// restoreStreaming re-issues the latest streaming or download command after connecting, returns if its result is
+// pending
+func (c *StreamClient) restoreStreaming() (bool, error) {
+	c.mutexDownload.Lock()
+	nextEntry, downloading := c.nextEntry, c.downloading
+	prefix, prefixResume := c.bookmarkPrefix, c.prefixResume
+	startBookmark := c.startBookmark
+	if downloading && c.checkpoint.Entry != nextEntry {
+		// Resume the download from the next entry, using the checkpoint offset if it's the same entry
+		c.checkpoint = DownloadCheckpoint{Entry: nextEntry, ToEntry: c.checkpoint.ToEntry}
+	}
+	c.mutexDownload.Unlock()
+
+	var err error
+	switch {
+	case c.streaming && startBookmark != nil:
+		// Nothing delivered yet, the bookmark is resolved again
+		_, _, err = c.execCommand(CmdStartBookmark, true, 0, startBookmark)
+	case c.streaming && prefix != nil:
+		// Resume from the latest bookmark received to get the entries it marks, the ones already received are
+		// skipped
+		_, _, err = c.execCommand(CmdStartBookmarkPrefix, true, prefixResume, prefix)
+	case c.streaming && c.projection != 0:
+		_, _, err = c.execCommand(CmdStartProjection, true, nextEntry, nil)
+	case c.streaming:
+		_, _, err = c.execCommand(c.startCommand(), true, nextEntry, nil)
+	case downloading:
+		_, _, err = c.execCommand(CmdDownload, true, nextEntry, nil)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // closeConnection closes connection to the server
 func (c *StreamClient) closeConnection() {
+	c.mutexConn.Lock()
 	if c.conn != nil {
 		log.Infof("%s Close connection", c.ID)
 		c.conn.Close()
 	}
+	c.mutexConn.Unlock()
 	c.connected = false
+	c.staleResults.Store(0)
+	if c.noReconnect || c.isStopped() {
+		c.setConnectionState(ConnDisconnected)
+	} else {
+		c.setConnectionState(ConnReconnecting)
+	}
 }
 
 // ExecCommandStart executes client TCP command to start streaming from entry
 func (c *StreamClient) ExecCommandStart(fromEntry uint64) error {
-	_, _, err := c.execCommand(CmdStart, false, fromEntry, nil)
+	c.maxLatency = 0
+	_, _, err := c.execCommand(c.startCommand(), false, fromEntry, nil)
 	return err
 }
 
-// ExecCommandStartBookmark executes client TCP command to start streaming from bookmark
+// ExecCommandStartMaxLatency executes client TCP command to start streaming from entry with a latency target: the
+// server writes each entry committed to this client within maxLatency of its commit, serving it before the clients
+// without a target and writing it in small batches. Entries written later are counted as misses (see ListClients).
+func (c *StreamClient) ExecCommandStartMaxLatency(fromEntry uint64, maxLatency time.Duration) error {
+	c.maxLatency = maxLatency
+	_, _, err := c.execCommand(c.startCommand()|CmdOptMaxLatency, false, fromEntry, nil)
+	return err
+}
+
+// ExecCommandStartBookmark executes client TCP command to start streaming from bookmark. On reconnection the
+// streaming is resumed after the latest entry delivered (from the bookmark again if none was delivered).
 func (c *StreamClient) ExecCommandStartBookmark(fromBookmark []byte) error {
 	_, _, err := c.execCommand(CmdStartBookmark, false, 0, fromBookmark)
 	return err
 }
 
+// FromBookmark starts streaming from a bookmark, resolved once by the server: the progress is then tracked by the
+// number of the entries delivered to the callback function, so a reconnection resumes with a start command after the
+// latest one delivered, without skipping nor repeating entries
+func (c *StreamClient) FromBookmark(bookmark []byte) error {
+	return c.ExecCommandStartBookmark(bookmark)
+}
+
+// ExecCommandStartBookmarkPrefix executes client TCP command to start streaming from entry just the entries marked
+// by the bookmarks with a key prefix (and those bookmarks). An entry is marked by the bookmarks just before it, and
+// it's received if any of them matches. The entries are marked until the next bookmark, so the entries from fromEntry
+// until the first bookmark are not received.
+func (c *StreamClient) ExecCommandStartBookmarkPrefix(fromEntry uint64, prefix []byte) error {
+	// Set before sending the command, the entries can be received before getting its result
+	c.mutexDownload.Lock()
+	c.nextEntry = fromEntry
+	c.bookmarkPrefix = prefix
+	c.prefixResume = fromEntry
+	c.mutexDownload.Unlock()
+
+	_, _, err := c.execCommand(CmdStartBookmarkPrefix, false, fromEntry, prefix)
+	if err != nil {
+		c.setBookmarkPrefix(nil)
+	}
+	return err
+}
+
+// setBookmarkPrefix sets the bookmark prefix filtering the streaming
+func (c *StreamClient) setBookmarkPrefix(prefix []byte) {
+	c.mutexDownload.Lock()
+	c.bookmarkPrefix = prefix
+	c.mutexDownload.Unlock()
+}
+
+// ExecCommandDownload executes client TCP command to download the entries from a checkpoint until the tail.
+// Use DownloadCheckpoint{Entry: fromEntry} to start a new download. The server sends a checkpoint every
+// checkpointInterval entries (0: default interval) processed by the function set with SetProcessCheckpointFunc.
+// If the connection is lost the download is resumed automatically.
+func (c *StreamClient) ExecCommandDownload(from DownloadCheckpoint, checkpointInterval uint64) error {
+	// Flagged before sending the command, the final checkpoint can be processed before getting its result
+	c.mutexDownload.Lock()
+	c.checkpoint = from
+	c.checkpointInterval = checkpointInterval
+	c.nextEntry = from.Entry
+	c.downloading = true
+	c.mutexDownload.Unlock()
+
+	_, _, err := c.execCommand(CmdDownload, false, from.Entry, nil)
+	if err != nil {
+		c.mutexDownload.Lock()
+		c.downloading = false
+		c.mutexDownload.Unlock()
+	}
+	return err
+}
+
 // ExecCommandStop executes client TCP command to stop streaming
 func (c *StreamClient) ExecCommandStop() error {
 	_, _, err := c.execCommand(CmdStop, false, 0, nil)
@@ -198,95 +434,202 @@ func (c *StreamClient) execCommand(cmd Command, deferredResult bool,
 		return header, entry, ErrInvalidCommand
 	}
 
+	// Set the position of the streaming before sending the command, the entries can be received before its result
+	c.setStreamingPosition(cmd, fromEntry, fromBookmark)
+
+	// Send command with its parameters
+	err := c.writeCommand(cmd, fromEntry, fromBookmark)
+	if err != nil {
+		return header, entry, err
+	}
+
+	// Get the command result
+	if !deferredResult {
+		r := c.getResult(cmd)
+		if r.errorNum == uint32(CmdErrDivergence) {
+			return header, entry, ErrStreamDivergence
+		}
+		if r.errorNum == uint32(CmdErrInvalidFilter) {
+			return header, entry, ErrInvalidEntryTypeFilter
+		}
+		if r.errorNum != uint32(CmdErrOK) {
+			return header, entry, ErrResultCommandError
+		}
+	}
+
+	// Get the data response and update streaming flag
+	switch cmd {
+	case CmdStart, CmdStart | CmdOptMaxLatency, CmdStart | CmdOptEntryTypes,
+		CmdStart | CmdOptMaxLatency | CmdOptEntryTypes:
+		c.streaming = true
+		c.fromStream = fromEntry
+		c.projection = 0
+		c.setBookmarkPrefix(nil)
+	case CmdStartBookmark:
+		c.streaming = true
+		c.projection = 0
+		c.setBookmarkPrefix(nil)
+	case CmdStartBookmarkPrefix:
+		c.streaming = true
+		c.fromStream = fromEntry
+		c.projection = 0
+	case CmdResync:
+		c.streaming = true
+		c.fromStream = fromEntry
+		c.projection = 0
+		c.setBookmarkPrefix(nil)
+	case CmdStartProjection:
+		c.streaming = true
+		c.fromStream = fromEntry
+		c.setBookmarkPrefix(nil)
+	case CmdStop:
+		c.streaming = false
+		c.maxLatency = 0
+		c.projection = 0
+	case CmdHeader:
+		h := c.getHeader()
+		header = h
+		c.totalEntries = header.TotalEntries
+	case CmdEntry:
+		e := c.getEntry()
+		if e.Type == EntryTypeNotFound {
+			return header, entry, ErrEntryNotFound
+		}
+		entry = e
+	case CmdBookmark:
+		e := c.getEntry()
+		if e.Type == EntryTypeNotFound {
+			return header, entry, ErrBookmarkNotFound
+		}
+		entry = e
+	case CmdCapabilities:
+		entry = c.getEntry()
+	}
+
+	return header, entry, nil
+}
+
+// writeCommand sends a command with its parameters to the server
+func (c *StreamClient) writeCommand(cmd Command, fromEntry uint64, fromBookmark []byte) error {
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+
 	// Send command
 	err := writeFullUint64(uint64(cmd), c.conn)
 	if err != nil {
-		return header, entry, err
+		return err
 	}
 	// Send stream type
 	err = writeFullUint64(uint64(c.streamType), c.conn)
 	if err != nil {
-		return header, entry, err
+		return err
 	}
 
 	// Send the command parameters
 	switch cmd {
-	case CmdStart:
-		log.Debugf("%s ...from entry %d", c.ID, fromEntry)
-		// Send starting/from entry number
+	case CmdStart, CmdStart | CmdOptMaxLatency, CmdStart | CmdOptEntryTypes,
+		CmdStart | CmdOptMaxLatency | CmdOptEntryTypes:
+		log.Debugf("%s ...from entry %d max latency %v entry types %v", c.ID, fromEntry, c.maxLatency, c.entryTypes)
+		// Send starting/from entry number, max latency (nanoseconds) and entry types as requested by the options
+		err = writeFullUint64(fromEntry, c.conn)
+		if err == nil && cmd&CmdOptMaxLatency != 0 {
+			err = writeFullUint64(uint64(max(c.maxLatency, 0)), c.conn)
+		}
+		if err == nil && cmd&CmdOptEntryTypes != 0 {
+			err = writeEntryTypes(c.entryTypes, c.conn)
+		}
+		if err != nil {
+			return err
+		}
+	case CmdStartProjection:
+		log.Debugf("%s ...from entry %d fields %d", c.ID, fromEntry, c.projection)
+		// Send starting/from entry number and fields
 		err = writeFullUint64(fromEntry, c.conn)
 		if err != nil {
-			return header, entry, err
+			return err
+		}
+		err = writeFullUint32(uint32(c.projection), c.conn)
+		if err != nil {
+			return err
 		}
 	case CmdStartBookmark:
 		log.Debugf("%s ...from bookmark [%v]", c.ID, fromBookmark)
 		// Send starting/from bookmark length
 		err = writeFullUint32(uint32(len(fromBookmark)), c.conn)
 		if err != nil {
-			return header, entry, err
+			return err
 		}
 		// Send starting/from bookmark
 		err = writeFullBytes(fromBookmark, c.conn)
 		if err != nil {
-			return header, entry, err
+			return err
+		}
+	case CmdStartBookmarkPrefix:
+		log.Debugf("%s ...from entry %d bookmark prefix [%v]", c.ID, fromEntry, fromBookmark)
+		// Send starting/from entry number, bookmark prefix length and bookmark prefix
+		err = writeFullUint64(fromEntry, c.conn)
+		if err != nil {
+			return err
+		}
+		err = writeFullUint32(uint32(len(fromBookmark)), c.conn)
+		if err != nil {
+			return err
+		}
+		err = writeFullBytes(fromBookmark, c.conn)
+		if err != nil {
+			return err
+		}
+	case CmdDownload:
+		c.mutexDownload.Lock()
+		offset, interval := c.checkpoint.Offset, c.checkpointInterval
+		c.mutexDownload.Unlock()
+		log.Debugf("%s ...download from entry %d (offset %d)", c.ID, fromEntry, offset)
+		// Send starting/from entry number, offset hint and checkpoint interval
+		err = writeFullUint64(fromEntry, c.conn)
+		if err != nil {
+			return err
+		}
+		err = writeFullUint64(offset, c.conn)
+		if err != nil {
+			return err
+		}
+		err = writeFullUint64(interval, c.conn)
+		if err != nil {
+			return err
+		}
+	case CmdResync:
+		log.Debugf("%s ...resync after entry %d", c.ID, c.resyncSummary.LastEntry)
+		// Send the client summary length and summary
+		summary := encodeResyncSummary(c.resyncSummary)
+		err = writeFullUint32(uint32(len(summary)), c.conn)
+		if err != nil {
+			return err
+		}
+		err = writeFullBytes(summary, c.conn)
+		if err != nil {
+			return err
 		}
 	case CmdEntry:
 		log.Debugf("%s ...get entry %d", c.ID, fromEntry)
 		// Send entry to retrieve
 		err = writeFullUint64(fromEntry, c.conn)
 		if err != nil {
-			return header, entry, err
+			return err
 		}
 	case CmdBookmark:
 		log.Debugf("%s ...get bookmark [%v]", c.ID, fromBookmark)
 		// Send bookmark length
 		err = writeFullUint32(uint32(len(fromBookmark)), c.conn)
 		if err != nil {
-			return header, entry, err
+			return err
 		}
 		// Send bookmark to retrieve
 		err = writeFullBytes(fromBookmark, c.conn)
 		if err != nil {
-			return header, entry, err
-		}
-	}
-
-	// Get the command result
-	if !deferredResult {
-		r := c.getResult(cmd)
-		if r.errorNum != uint32(CmdErrOK) {
-			return header, entry, ErrResultCommandError
-		}
-	}
-
-	// Get the data response and update streaming flag
-	switch cmd {
-	case CmdStart:
-		c.streaming = true
-		c.fromStream = fromEntry
-	case CmdStartBookmark:
-		c.streaming = true
-	case CmdStop:
-		c.streaming = false
-	case CmdHeader:
-		h := c.getHeader()
-		header = h
-		c.totalEntries = header.TotalEntries
-	case CmdEntry:
-		e := c.getEntry()
-		if e.Type == EntryTypeNotFound {
-			return header, entry, ErrEntryNotFound
-		}
-		entry = e
-	case CmdBookmark:
-		e := c.getEntry()
-		if e.Type == EntryTypeNotFound {
-			return header, entry, ErrBookmarkNotFound
+			return err
 		}
-		entry = e
 	}
-
-	return header, entry, nil
+	return nil
 }
 
 // writeFullUint64 writes to connection a complete uint64
@@ -365,6 +708,25 @@ func (c *StreamClient) readDataEntry() (FileEntry, error) {
 	}
 	buffer = append(buffer, bufferAux...) //nolint:makezero
 
+	// Remove the checksum, decompress the data and verify the checksum as negotiated
+	var checksum uint32
+	if c.entryChecksums {
+		buffer, checksum, err = c.splitEntryChecksum(buffer)
+		if err != nil {
+			return FileEntry{}, err
+		}
+	}
+	buffer, err = c.decodeWirePacket(buffer)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	if c.entryChecksums {
+		err = c.verifyEntryChecksum(buffer, checksum)
+		if err != nil {
+			return FileEntry{}, err
+		}
+	}
+
 	// Decode binary data to data entry struct
 	d, err := DecodeBinaryToFileEntry(buffer)
 	if err != nil {
@@ -458,9 +820,22 @@ func (c *StreamClient) readContent(buffer []byte) error {
 
 // readEntries reads from the server all type of packets
 func (c *StreamClient) readEntries() {
+	defer close(c.entries)
 	defer c.closeConnection()
 
 	for {
+		// Stopped by the context
+		if c.isStopped() {
+			return
+		}
+
+		// Stop at the disconnection if the reconnection is disabled
+		if !c.connected && c.noReconnect {
+			log.Warnf("%s Disconnected from server %s, reconnection disabled", c.ID, c.server)
+			c.readErr = ErrDisconnected
+			return
+		}
+
 		// Wait for connection
 		deferredResult := c.connectServer()
 
@@ -482,13 +857,15 @@ func (c *StreamClient) readEntries() {
 				continue
 			}
 			// Send data to results channel
-			c.results <- r
+			if !sendUntilStopped(c, c.results, r) {
+				return
+			}
 			// Get the command deferred result
 			if deferredResult {
 				r := c.getResult(CmdStart)
 				if r.errorNum != uint32(CmdErrOK) {
 					c.closeConnection()
-					time.Sleep(defaultTimeout)
+					c.waitReconnect()
 					continue
 				}
 			}
@@ -496,11 +873,16 @@ func (c *StreamClient) readEntries() {
 		case PtDataRsp:
 			// Read result entry data
 			r, err := c.readDataEntry()
-			if err != nil {
+			if errors.Is(err, ErrEntryChecksumMismatch) {
+				c.readErr = err
+				return
+			} else if err != nil {
 				c.closeConnection()
 				continue
 			}
-			c.entryRsp <- r
+			if !sendUntilStopped(c, c.entryRsp, r) {
+				return
+			}
 
 		case PtHeader:
 			// Read header entry data
@@ -510,17 +892,72 @@ func (c *StreamClient) readEntries() {
 				continue
 			}
 			// Send data to headers channel
-			c.headers <- h
+			if !sendUntilStopped(c, c.headers, h) {
+				return
+			}
 
 		case PtData:
 			// Read file/stream entry data
 			e, err := c.readDataEntry()
-			if err != nil {
+			if errors.Is(err, ErrEntryChecksumMismatch) {
+				c.readErr = err
+				return
+			} else if err != nil {
 				c.closeConnection()
 				continue
 			}
 			// Send data to stream entries channel
-			c.entries <- e
+			if !sendUntilStopped(c, c.entries, e) {
+				return
+			}
+
+		case PtCheckpoint:
+			// Read download checkpoint
+			buffer := make([]byte, FixedSizeCheckpoint)
+			buffer[0] = PtCheckpoint
+			err := c.readContent(buffer[1:])
+			if err != nil {
+				c.closeConnection()
+				continue
+			}
+			// Send it to stream entries channel to process it after the previous entries
+			if !sendUntilStopped(c, c.entries, FileEntry{packetType: PtCheckpoint, Data: buffer}) {
+				return
+			}
+
+		case PtTraceContext:
+			// Read trace context of the next entries
+			buffer := make([]byte, FixedSizeTraceContext)
+			buffer[0] = PtTraceContext
+			err := c.readContent(buffer[1:])
+			if err != nil {
+				c.closeConnection()
+				continue
+			}
+			// Send it to stream entries channel to process it before the entries
+			if !sendUntilStopped(c, c.entries, FileEntry{packetType: PtTraceContext, Data: buffer}) {
+				return
+			}
+
+		case PtHeartbeat:
+			// Acknowledge it right away
+			err := c.ackHeartbeat()
+			if err != nil {
+				c.closeConnection()
+				continue
+			}
+
+		case PtProjection:
+			// Read projected entry
+			e, err := c.readProjectedEntry()
+			if err != nil {
+				c.closeConnection()
+				continue
+			}
+			// Send it to stream entries channel
+			if !sendUntilStopped(c, c.entries, e) {
+				return
+			}
 
 		default:
 			// Unknown type
@@ -530,14 +967,63 @@ func (c *StreamClient) readEntries() {
 	}
 }
 
+// stop stops the client when the context of StartWithContext ends, closing the connection
+func (c *StreamClient) stop() {
+	c.mutexConn.Lock()
+	close(c.stopped)
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.mutexConn.Unlock()
+	c.setConnectionState(ConnDisconnected)
+	log.Infof("%s Client stopped by its context", c.ID)
+}
+
+// isStopped returns if the client was stopped by the context of StartWithContext
+func (c *StreamClient) isStopped() bool {
+	select {
+	case <-c.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendUntilStopped sends a packet read from the server to the channel consuming it, returns false if the client is
+// stopped meanwhile
+func sendUntilStopped[T any](c *StreamClient, ch chan T, v T) bool {
+	select {
+	case ch <- v:
+		return true
+	case <-c.stopped:
+		return false
+	}
+}
+
 // getResult consumes a result entry
 func (c *StreamClient) getResult(cmd Command) ResultEntry {
-	// Get result entry
-	r := <-c.results
-	log.Debugf("%s Result %d[%s] received for command %d[%s]", c.ID, r.errorNum, r.errorStr, cmd, StrCommand[cmd])
+	r, _ := c.getResultContext(context.Background(), cmd)
 	return r
 }
 
+// getResultContext consumes a result entry until the context ends, discarding the results of the commands abandoned
+func (c *StreamClient) getResultContext(ctx context.Context, cmd Command) (ResultEntry, error) {
+	for {
+		select {
+		case r := <-c.results:
+			if c.staleResults.Load() > 0 {
+				c.staleResults.Add(-1)
+				continue
+			}
+			log.Debugf("%s Result %d[%s] received for command %d[%s]", c.ID, r.errorNum, r.errorStr, cmd, StrCommand[cmd])
+			return r, nil
+		case <-ctx.Done():
+			c.staleResults.Add(1)
+			return ResultEntry{}, ctx.Err()
+		}
+	}
+}
+
 // getHeader consumes a header entry
 func (c *StreamClient) getHeader() HeaderEntry {
 	h := <-c.headers
@@ -556,16 +1042,163 @@ func (c *StreamClient) getEntry() FileEntry {
 // getStreaming consumes streaming data entries
 func (c *StreamClient) getStreaming() error {
 	for {
-		e := <-c.entries
-		c.nextEntry = e.Number + 1
+		var e FileEntry
+		var ok bool
+		select {
+		case e, ok = <-c.entries:
+			if !ok {
+				// Reading ended
+				return c.readErr
+			}
+		case <-c.stopped:
+			return nil
+		}
+
+		// Process the download checkpoint
+		if e.packetType == PtCheckpoint {
+			err := c.processDownloadCheckpoint(e.Data)
+			if err != nil {
+				log.Errorf("%s Processing checkpoint: %s. Exiting getStream function", c.ID, err.Error())
+				return err
+			}
+			continue
+		}
+
+		// Set the trace context of the next entries
+		if e.packetType == PtTraceContext {
+			c.processTraceContext(e.Data)
+			continue
+		}
+
+		// Process the projected entry
+		if e.packetType == PtProjection {
+			err := c.processProjectedEntry(e.Data)
+			if err != nil {
+				log.Errorf("%s Processing projected entry %d: %s. Exiting getStream function", c.ID, e.Number, err.Error())
+				return err
+			}
+			continue
+		}
+
+		c.mutexDownload.Lock()
+		// Entries received again resuming the streaming
+		if e.Number < c.nextEntry {
+			c.mutexDownload.Unlock()
+			continue
+		}
+		if c.bookmarkPrefix != nil && e.Type == EtBookmark {
+			c.prefixResume = e.Number
+		}
+		c.mutexDownload.Unlock()
 
 		// Process the data entry
-		err := c.processEntry(&e, c, c.relayServer)
+		span := c.startProcessSpan(&e)
+		err := c.processEntryWithPolicy(&e)
+		endSpan(span, err)
 		if err != nil {
 			log.Errorf("%s Processing entry %d: %s. Exiting getStream function", c.ID, e.Number, err.Error())
 			return err
 		}
+		c.setDelivered(e.Number)
+	}
+}
+
+// setDelivered sets the latest entry delivered to the callback function, the streaming is resumed after it
+func (c *StreamClient) setDelivered(entryNum uint64) {
+	c.mutexDownload.Lock()
+	c.nextEntry = entryNum + 1
+	c.startBookmark = nil
+	c.mutexDownload.Unlock()
+}
+
+// setStreamingPosition sets the next entry to deliver for the command starting a streaming
+func (c *StreamClient) setStreamingPosition(cmd Command, fromEntry uint64, fromBookmark []byte) {
+	c.mutexDownload.Lock()
+	defer c.mutexDownload.Unlock()
+
+	switch cmd {
+	case CmdStart, CmdStart | CmdOptMaxLatency, CmdStart | CmdOptEntryTypes,
+		CmdStart | CmdOptMaxLatency | CmdOptEntryTypes, CmdStartProjection, CmdResync:
+		c.nextEntry = fromEntry
+		c.startBookmark = nil
+	case CmdStartBookmark:
+		// Unknown until the bookmark entry is received
+		c.nextEntry = 0
+		c.startBookmark = fromBookmark
+	case CmdStartBookmarkPrefix, CmdDownload:
+		c.startBookmark = nil
+	}
+}
+
+// processEntryWithPolicy processes an entry retrying and routing it to the dead-letter handler as configured
+func (c *StreamClient) processEntryWithPolicy(e *FileEntry) error {
+	err := c.processEntry(e, c, c.relayServer)
+	for retry := 1; err != nil && retry <= c.failurePolicy.Retries; retry++ {
+		log.Warnf("%s Processing entry %d: %v. Retry %d/%d", c.ID, e.Number, err, retry, c.failurePolicy.Retries)
+		time.Sleep(c.failurePolicy.RetryInterval)
+		err = c.processEntry(e, c, c.relayServer)
+	}
+	if err == nil {
+		if c.delivered != nil {
+			c.delivered.Add(e.Number)
+		}
+		return nil
+	}
+
+	if c.failurePolicy.Action == FailureDeadLetter {
+		log.Errorf("%s Processing entry %d: %v. Routed to dead-letter", c.ID, e.Number, err)
+		c.failurePolicy.OnDeadLetter(e, err)
+		return nil
+	}
+	return err
+}
+
+// SetDeliveredBitmap sets a bitmap where the client adds the number of each entry processed successfully by the
+// streaming, so it can be compared against the expected entries (e.g. with Missing). Nil stops the tracking.
+func (c *StreamClient) SetDeliveredBitmap(b *EntryBitmap) {
+	c.delivered = b
+}
+
+// SetProcessFailurePolicy sets the retries and the action for the entries that fail to be processed
+func (c *StreamClient) SetProcessFailurePolicy(p ProcessFailurePolicy) error {
+	if p.Action == FailureDeadLetter && p.OnDeadLetter == nil {
+		log.Errorf("%s Dead-letter failure action without handler", c.ID)
+		return ErrDeadLetterHandlerMissing
+	}
+	c.failurePolicy = p
+	return nil
+}
+
+// processDownloadCheckpoint decodes and processes a download checkpoint
+func (c *StreamClient) processDownloadCheckpoint(b []byte) error {
+	cp, err := decodeBinaryToCheckpoint(b)
+	if err != nil {
+		return err
 	}
+
+	c.mutexDownload.Lock()
+	c.checkpoint = cp
+	if cp.Done() {
+		c.downloading = false
+	}
+	c.mutexDownload.Unlock()
+
+	if c.processCheckpoint != nil {
+		return c.processCheckpoint(cp, c)
+	}
+	return nil
+}
+
+// GetDownloadCheckpoint returns the latest download checkpoint processed
+func (c *StreamClient) GetDownloadCheckpoint() DownloadCheckpoint {
+	c.mutexDownload.Lock()
+	defer c.mutexDownload.Unlock()
+	return c.checkpoint
+}
+
+// SetProcessCheckpointFunc sets the callback function to process the download checkpoints (e.g. to store them)
+func (c *StreamClient) SetProcessCheckpointFunc(f ProcessCheckpointFunc) {
+	c.processCheckpoint = f
 }
 
 // GetFromStream returns streaming start entry number from the latest start command executed
@@ -583,6 +1216,22 @@ func (c *StreamClient) SetProcessEntryFunc(f ProcessEntryFunc) {
 	c.setProcessEntryFunc(f, nil)
 }
 
+// SetProcessAnyEntryFunc sets the callback function to process entry wrapped in a protobuf Any.
+// The entry types received must be registered with RegisterEntryType, otherwise the streaming stops with an error.
+// The bookmarks are skipped unless EtBookmark is registered.
+func (c *StreamClient) SetProcessAnyEntryFunc(f ProcessAnyEntryFunc) {
+	c.setProcessEntryFunc(func(e *FileEntry, c *StreamClient, _ *StreamServer) error {
+		if e.Type == EtBookmark && !isEntryTypeRegistered(EtBookmark) {
+			return nil
+		}
+		a, err := EntryToAny(e)
+		if err != nil {
+			return err
+		}
+		return f(e.Number, a, c)
+	}, nil)
+}
+
 // ResetProcessEntryFunc resets the callback function to the default one
 func (c *StreamClient) ResetProcessEntryFunc() {
 	// Set default callback function to process entry
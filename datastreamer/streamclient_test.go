@@ -0,0 +1,172 @@
+package datastreamer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errPoisonEntry = errors.New("poison entry")
+
+func TestProcessFailurePolicy(t *testing.T) {
+	const numEntries = 10
+
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	for i := range numEntries {
+		_, err := s.AddStreamEntry(1, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	tests := []struct {
+		name       string
+		policy     ProcessFailurePolicy
+		processed  []uint64
+		deadLetter []uint64
+	}{
+		{
+			name:      "stop",
+			policy:    ProcessFailurePolicy{Retries: 2},
+			processed: []uint64{0, 1, 2, 3},
+		},
+		{
+			name:       "dead-letter",
+			policy:     ProcessFailurePolicy{Retries: 2, Action: FailureDeadLetter},
+			processed:  []uint64{0, 1, 2, 3, 5, 6, 7, 8, 9},
+			deadLetter: []uint64{4},
+		},
+	}
+
+	// The dead-letter action requires a handler
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	assert.ErrorIs(t, c.SetProcessFailurePolicy(ProcessFailurePolicy{Action: FailureDeadLetter}),
+		ErrDeadLetterHandlerMissing)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewClient(testServerAddr(s), 1)
+			require.NoError(t, err)
+
+			// Entry 4 always fails, entry 5 fails on its first attempt
+			var (
+				mutex      sync.Mutex
+				attempts   = map[uint64]int{}
+				deadLetter []uint64
+			)
+			processed := make(chan uint64, numEntries)
+			c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+				mutex.Lock()
+				attempts[e.Number]++
+				fail := e.Number == 4 || (e.Number == 5 && attempts[e.Number] == 1) //nolint:mnd
+				mutex.Unlock()
+				if fail {
+					return errPoisonEntry
+				}
+				processed <- e.Number
+				return nil
+			})
+			tc.policy.OnDeadLetter = func(e *FileEntry, err error) {
+				assert.ErrorIs(t, err, errPoisonEntry)
+				mutex.Lock()
+				deadLetter = append(deadLetter, e.Number)
+				mutex.Unlock()
+			}
+			require.NoError(t, c.SetProcessFailurePolicy(tc.policy))
+			delivered := NewEntryBitmap()
+			c.SetDeliveredBitmap(delivered)
+			startClientUntilCleanup(t, c)
+			require.NoError(t, c.ExecCommandStart(0))
+
+			var got []uint64
+			for len(got) < len(tc.processed) {
+				select {
+				case num := <-processed:
+					got = append(got, num)
+				case <-time.After(5 * time.Second): //nolint:mnd
+					t.Fatalf("processed %v, expected %v", got, tc.processed)
+				}
+			}
+			assert.Equal(t, tc.processed, got)
+			require.Eventually(t, func() bool { return delivered.Count() == uint64(len(got)) }, time.Second, time.Millisecond)
+			assert.Equal(t, tc.processed, delivered.ToSlice())
+
+			// Nothing else is processed once stopped
+			if tc.policy.Action == FailureStop {
+				select {
+				case num := <-processed:
+					t.Fatalf("entry %d processed after stopping", num)
+				case <-time.After(100 * time.Millisecond): //nolint:mnd
+				}
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			assert.Equal(t, tc.deadLetter, deadLetter)
+			assert.Equal(t, 3, attempts[4]) //nolint:mnd
+		})
+	}
+}
+
+func TestStartWithContext(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	baseline := runtime.NumGoroutine()
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	var processed atomic.Uint64
+	c.SetProcessEntryFunc(func(*FileEntry, *StreamClient, *StreamServer) error {
+		processed.Add(1)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, c.StartWithContext(ctx))
+	require.NoError(t, c.ExecCommandStart(0))
+
+	// Cancelled while the entries are streamed
+	for range 20 {
+		commitTestEntry(t, s)
+	}
+	require.Eventually(t, func() bool { return processed.Load() > 0 }, time.Second, time.Millisecond)
+	cancel()
+
+	// Connection closed and the goroutines of the client and of its server connection ended
+	require.Eventually(t, func() bool {
+		// Plus the goroutine checking the condition
+		return c.ConnectionState() == ConnDisconnected && s.getSafeClientsLen() == 0 &&
+			runtime.NumGoroutine() <= baseline+1
+	}, time.Second, 10*time.Millisecond) //nolint:mnd
+	received := processed.Load()
+	for range 5 {
+		commitTestEntry(t, s)
+	}
+	time.Sleep(100 * time.Millisecond) //nolint:mnd
+	assert.Equal(t, received, processed.Load())
+	assert.Equal(t, ConnDisconnected, c.ConnectionState())
+}
+
+func TestStartWithContextConnecting(t *testing.T) {
+	// Address with no server listening, the client waits to reconnect
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	c, err := NewClient(addr, 1)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond) //nolint:mnd
+	defer cancel()
+	start := time.Now()
+	require.ErrorIs(t, c.StartWithContext(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, ConnDisconnected, c.ConnectionState())
+}
@@ -0,0 +1,107 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionMode type for the at-rest compression of the entries data of a stream file
+type CompressionMode uint8
+
+const (
+	CompressionNone CompressionMode = iota // CompressionNone entries data stored as added
+	CompressionZstd                        // CompressionZstd entries data compressed with zstd
+
+	compressionPrefixSize = 4 // Original length of the data stored before the compressed data
+)
+
+var (
+	// Encoder and decoder for the whole entries data, safe for concurrent use
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// isCompressed returns if the data of an entry type is compressed in a file (bookmarks and version change markers
+// are stored as added, so they can be read without decompressing)
+func isCompressed(compression CompressionMode, etype EntryType) bool {
+	return compression != CompressionNone && etype != EtBookmark && etype != EtVersionChange
+}
+
+// compressData returns the original length of the data followed by the data compressed, or by the data as is if it
+// doesn't get smaller (the stored data is the original one when its length is the original length)
+func compressData(data []byte) []byte {
+	stored := binary.BigEndian.AppendUint32(make([]byte, 0, compressionPrefixSize+len(data)), uint32(len(data)))
+	compressed := zstdEncoder.EncodeAll(data, stored)
+	if len(compressed)-compressionPrefixSize >= len(data) {
+		return append(stored[:compressionPrefixSize], data...)
+	}
+	return compressed
+}
+
+// decompressData returns the original data of the stored data of an entry
+func decompressData(stored []byte) ([]byte, error) {
+	if len(stored) < compressionPrefixSize {
+		return nil, ErrDecompressionFailed
+	}
+	length := uint64(binary.BigEndian.Uint32(stored[:compressionPrefixSize]))
+	payload := stored[compressionPrefixSize:]
+	switch {
+	case uint64(len(payload)) == length:
+		return payload, nil
+	case uint64(len(payload)) > length:
+		return nil, ErrDecompressionFailed
+	}
+
+	data, err := zstdDecoder.DecodeAll(payload, make([]byte, 0, length))
+	if err != nil || uint64(len(data)) != length {
+		return nil, ErrDecompressionFailed
+	}
+	return data, nil
+}
+
+// compressEntry compresses the data of an entry to store it in a file
+func compressEntry(compression CompressionMode, e *FileEntry) {
+	if !isCompressed(compression, e.Type) {
+		return
+	}
+	e.Data = compressData(e.Data)
+	e.Length = FixedSizeFileEntry + uint32(len(e.Data))
+}
+
+// decompressEntry decompresses the data of an entry read from a file, fails with ErrDecompressionFailed if it's
+// not valid
+func decompressEntry(compression CompressionMode, e *FileEntry) error {
+	if !isCompressed(compression, e.Type) {
+		return nil
+	}
+	data, err := decompressData(e.Data)
+	if err != nil {
+		log.Errorf("Error decompressing entry %d: %v", e.Number, err)
+		return err
+	}
+	e.Data = data
+	e.Length = FixedSizeFileEntry + uint32(len(e.Data))
+	return nil
+}
+
+// storeEntry converts an entry to the one stored in the file: data compressed and then encrypted
+func (f *StreamFile) storeEntry(e *FileEntry) error {
+	compressEntry(f.compression, e)
+	if f.isEncrypted(e.Type) {
+		return f.encryptEntry(e)
+	}
+	return nil
+}
+
+// loadEntry converts an entry read from the file to the one added: data decrypted and then decompressed
+func (f *StreamFile) loadEntry(e *FileEntry) error {
+	if f.isEncrypted(e.Type) {
+		err := f.decryptEntry(e)
+		if err != nil {
+			return err
+		}
+	}
+	return decompressEntry(f.compression, e)
+}
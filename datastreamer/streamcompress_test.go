@@ -0,0 +1,161 @@
+package datastreamer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	compressible := bytes.Repeat([]byte("compressible protobuf blob "), 1000) //nolint:mnd
+	random := make([]byte, 4000)                                              //nolint:mnd
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	payloads := [][]byte{compressible, random, {}, []byte("x")}
+
+	s, err := openTestServer(t, fileName, StreamFileOptions{Compression: CompressionZstd}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamBookmark([]byte("block-1"))
+	require.NoError(t, err)
+	for _, data := range payloads {
+		_, err = s.AddStreamEntry(1, data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	// Read transparently with the original length
+	for i, data := range payloads {
+		entry, err := s.GetEntry(uint64(i + 1))
+		require.NoError(t, err)
+		assert.Equal(t, data, entry.Data)
+		assert.Equal(t, uint32(FixedSizeFileEntry+len(data)), entry.Length)
+	}
+	size, err := s.RangeDataSize(1, 5) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(compressible)+len(random)+1), size)
+
+	// Updated if the new data is stored with the same length
+	_, err = rand.Read(random)
+	require.NoError(t, err)
+	require.NoError(t, s.UpdateEntryData(2, 1, random))
+	require.ErrorIs(t, s.UpdateEntryData(1, 1, bytes.Repeat([]byte{1}, len(compressible))),
+		ErrUpdateEntryDifferentSize)
+	entry, err := s.GetEntry(2) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, random, entry.Data)
+	header := s.GetHeader()
+	require.NoError(t, s.Close())
+
+	// Compressed on disk, bookmarks as added
+	raw, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(raw, compressible))
+	assert.True(t, bytes.Contains(raw, []byte("block-1")))
+	assert.Less(t, header.TotalLength-PageHeaderSize, uint64(len(compressible)/10)+uint64(len(random))+100)
+
+	// Readers know from the header to decompress
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	var numbers []uint64
+	for entry, err := range sf.Entries(0, 5) { //nolint:mnd
+		require.NoError(t, err)
+		numbers = append(numbers, entry.Number)
+		if entry.Number == 1 {
+			assert.Equal(t, compressible, entry.Data)
+		}
+	}
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4}, numbers)
+	it, err := sf.GetReverseIterator(1)
+	require.NoError(t, err)
+	end, err := it.Next()
+	require.NoError(t, err)
+	require.False(t, end)
+	assert.Equal(t, compressible, it.GetEntry().Data)
+	require.NoError(t, sf.Close())
+}
+
+func TestCompressionMismatch(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	require.NoError(t, sf.Close())
+
+	_, err = NewStreamFileWithOptions(fileName, 1, 12345, 1, StreamFileOptions{Compression: CompressionZstd})
+	require.ErrorIs(t, err, ErrCompressionMismatch)
+}
+
+func TestCompressedWithEncryption(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	data := bytes.Repeat([]byte("secret "), 500) //nolint:mnd
+
+	s, err := openTestServer(t, fileName, StreamFileOptions{
+		Compression:   CompressionZstd,
+		EncryptionKey: StaticKey(bytes.Repeat([]byte{7}, 32)), //nolint:mnd
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, data)
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+
+	entry, err := s.GetEntry(0)
+	require.NoError(t, err)
+	assert.Equal(t, data, entry.Data)
+	size, err := s.RangeDataSize(0, 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), size)
+	assert.Less(t, s.GetHeader().TotalLength-PageHeaderSize, uint64(len(data)))
+}
+
+func TestDecompressData(t *testing.T) {
+	stored := compressData(bytes.Repeat([]byte{1}, 100)) //nolint:mnd
+	_, err := decompressData(stored[:len(stored)-1])
+	require.ErrorIs(t, err, ErrDecompressionFailed)
+	_, err = decompressData(stored[:2])
+	require.ErrorIs(t, err, ErrDecompressionFailed)
+
+	// Longer than the original length
+	_, err = decompressData([]byte{0, 0, 0, 1, 5, 5})
+	require.ErrorIs(t, err, ErrDecompressionFailed)
+}
+
+func TestCompressedReprocess(t *testing.T) {
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "stream.bin")
+	data := bytes.Repeat([]byte("entry "), 200) //nolint:mnd
+
+	s, err := openTestServer(t, srcFile, StreamFileOptions{Compression: CompressionZstd}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.StartAtomicOp())
+	for range 3 {
+		_, err := s.AddStreamEntry(1, data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.Close())
+
+	// The transform gets the original data, the output keeps the compression
+	dstFile := filepath.Join(dir, "reprocessed.bin")
+	require.NoError(t, Reprocess(srcFile, dstFile, func(e FileEntry) (FileEntry, error) {
+		assert.Equal(t, data, e.Data)
+		e.Data = bytes.ToUpper(e.Data)
+		return e, nil
+	}))
+	header, err := ReadHeader(dstFile)
+	require.NoError(t, err)
+	assert.Equal(t, CompressionZstd, header.compression)
+	sf, err := NewStreamFile(dstFile, 1, 12345, 1)
+	require.NoError(t, err)
+	for entry, err := range sf.Entries(0, 3) { //nolint:mnd
+		require.NoError(t, err)
+		assert.Equal(t, bytes.ToUpper(data), entry.Data)
+	}
+	require.NoError(t, sf.Close())
+}
@@ -0,0 +1,286 @@
+package datastreamer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// JSON-RPC error codes of the control interface
+const (
+	ControlErrParse          = -32700 // ControlErrParse for an invalid JSON request
+	ControlErrInvalidRequest = -32600 // ControlErrInvalidRequest for a request without method
+	ControlErrMethodNotFound = -32601 // ControlErrMethodNotFound for an unknown method
+	ControlErrInvalidParams  = -32602 // ControlErrInvalidParams for invalid method parameters
+	ControlErrFailed         = -32000 // ControlErrFailed for an operation that failed
+	ControlErrUnauthorized   = -32001 // ControlErrUnauthorized for a method called before authenticating
+)
+
+// ControlServer type to serve admin operations of a stream server as JSON-RPC 2.0 methods on its own port, separate
+// from the data streaming. Requests and responses are JSON objects, one per line. If a token is set each connection
+// must call the auth method with it before any other method.
+type ControlServer struct {
+	server *StreamServer
+	port   uint16
+	token  string
+	ln     net.Listener
+	wg     sync.WaitGroup
+	conns  map[net.Conn]struct{}
+	mutex  sync.Mutex
+}
+
+// ControlStats type for the result of the stats control method
+type ControlStats struct {
+	FirstEntry   uint64 `json:"firstEntry"`
+	TotalEntries uint64 `json:"totalEntries"`
+	TotalLength  uint64 `json:"totalLength"`
+	Clients      int    `json:"clients"`
+	WritesPaused bool   `json:"writesPaused"`
+}
+
+// ControlClient type for each client in the result of the listClients control method
+type ControlClient struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	BatchSize uint64 `json:"batchSize"`
+}
+
+// ControlError type for the error of a control response
+type ControlError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// controlRequest type for a JSON-RPC request received by the control server
+type controlRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// controlResponse type for a JSON-RPC response sent by the control server
+type controlResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *ControlError   `json:"error,omitempty"`
+}
+
+// NewControlServer creates the control server of a stream server listening on a port. Methods:
+//   - auth {"token"}: authenticates the connection (required first if the token is not empty)
+//   - stats: returns ControlStats
+//   - listClients: returns the connected clients ([]ControlClient)
+//   - pauseWrites / resumeWrites: pauses the writes, StartAtomicOp fails with ErrWritesPaused, or resumes them
+//   - truncate {"entry"}: truncates the stream file from the entry number (only with the writes paused)
+func NewControlServer(s *StreamServer, port uint16, token string) *ControlServer {
+	return &ControlServer{
+		server: s,
+		port:   port,
+		token:  token,
+		conns:  make(map[net.Conn]struct{}),
+	}
+}
+
+// Start listens for the control connections
+func (c *ControlServer) Start() error {
+	var err error
+	c.ln, err = net.Listen("tcp", ":"+strconv.Itoa(int(c.port)))
+	if err != nil {
+		log.Errorf("Error creating control server %d: %v", c.port, err)
+		return err
+	}
+
+	log.Infof("Control listening on port: %d", c.port)
+	c.wg.Add(1)
+	go c.waitConnections()
+	return nil
+}
+
+// Close closes the listener and the control connections
+func (c *ControlServer) Close() error {
+	var err error
+	if c.ln != nil {
+		err = c.ln.Close()
+	}
+	c.mutex.Lock()
+	for conn := range c.conns {
+		conn.Close()
+	}
+	c.mutex.Unlock()
+	c.wg.Wait()
+	return err
+}
+
+// waitConnections waits for new control connections and serves them
+func (c *ControlServer) waitConnections() {
+	defer c.wg.Done()
+
+	const timeout = 2 * time.Second
+
+	for {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			// Exit loop if listener is closed
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Errorf("Error accepting new control connection: %v", err)
+			time.Sleep(timeout)
+			continue
+		}
+
+		c.mutex.Lock()
+		c.conns[conn] = struct{}{}
+		c.mutex.Unlock()
+		c.wg.Add(1)
+		go c.handleConnection(conn)
+	}
+}
+
+// handleConnection reads the requests of a control connection and sends their responses
+func (c *ControlServer) handleConnection(conn net.Conn) {
+	defer c.wg.Done()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.conns, conn)
+		c.mutex.Unlock()
+		conn.Close()
+	}()
+
+	clientID := conn.RemoteAddr().String()
+	log.Infof("New control connection: %s", clientID)
+
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	authenticated := c.token == ""
+	for {
+		var req controlRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				_ = encoder.Encode(controlResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+					Error: &ControlError{Code: ControlErrParse, Message: err.Error()}})
+			}
+			log.Infof("Control connection %s closed: %v", clientID, err)
+			return
+		}
+
+		rsp := controlResponse{JSONRPC: "2.0", ID: req.ID}
+		if rsp.ID == nil {
+			rsp.ID = json.RawMessage("null")
+		}
+		switch {
+		case req.Method == "":
+			rsp.Error = &ControlError{Code: ControlErrInvalidRequest, Message: "method missing"}
+		case req.Method == "auth":
+			authenticated, rsp.Result, rsp.Error = c.auth(req.Params)
+		case !authenticated:
+			rsp.Error = &ControlError{Code: ControlErrUnauthorized, Message: "unauthorized"}
+		default:
+			log.Infof("Control method %s from %s", req.Method, clientID)
+			rsp.Result, rsp.Error = c.call(req.Method, req.Params)
+		}
+
+		err = encoder.Encode(rsp)
+		if err != nil {
+			log.Errorf("Error sending control response to %s: %v", clientID, err)
+			return
+		}
+	}
+}
+
+// auth checks the token of the auth method
+func (c *ControlServer) auth(params json.RawMessage) (bool, any, *ControlError) {
+	var p struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return false, nil, &ControlError{Code: ControlErrInvalidParams, Message: err.Error()}
+	}
+	if subtle.ConstantTimeCompare([]byte(p.Token), []byte(c.token)) != 1 {
+		log.Warnf("Control authentication failed")
+		return false, nil, &ControlError{Code: ControlErrUnauthorized, Message: "invalid token"}
+	}
+	return true, true, nil
+}
+
+// call runs a control method
+func (c *ControlServer) call(method string, params json.RawMessage) (any, *ControlError) {
+	s := c.server
+	switch method {
+	case "stats":
+		header := s.GetHeader()
+		return ControlStats{
+			FirstEntry:   header.firstEntry,
+			TotalEntries: header.TotalEntries,
+			TotalLength:  header.TotalLength,
+			Clients:      s.getSafeClientsLen(),
+			WritesPaused: s.WritesPaused(),
+		}, nil
+
+	case "listClients":
+		clients := make([]ControlClient, 0)
+		for _, info := range s.ListClients() {
+			clients = append(clients, ControlClient{
+				ID:        info.ID,
+				Status:    StrClientStatus[info.Status],
+				BatchSize: info.BatchSize,
+			})
+		}
+		return clients, nil
+
+	case "pauseWrites":
+		s.PauseWrites()
+		return true, nil
+
+	case "resumeWrites":
+		s.ResumeWrites()
+		return true, nil
+
+	case "truncate":
+		var p struct {
+			Entry *uint64 `json:"entry"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil || p.Entry == nil {
+			return nil, &ControlError{Code: ControlErrInvalidParams, Message: "entry number required"}
+		}
+		if !s.WritesPaused() {
+			return nil, &ControlError{Code: ControlErrFailed, Message: "truncate requires the writes paused"}
+		}
+		if err := s.TruncateFile(*p.Entry); err != nil {
+			return nil, &ControlError{Code: ControlErrFailed, Message: err.Error()}
+		}
+		return s.GetHeader().TotalEntries, nil
+
+	default:
+		return nil, &ControlError{Code: ControlErrMethodNotFound, Message: "method not found: " + method}
+	}
+}
+
+// PauseWrites pauses the writes to the stream, StartAtomicOp fails with ErrWritesPaused until ResumeWrites. The
+// atomic operation in progress (if any) is not affected.
+func (s *StreamServer) PauseWrites() {
+	if !s.writesPaused.Swap(true) {
+		log.Infof("Writes paused")
+	}
+}
+
+// ResumeWrites resumes the writes paused by PauseWrites
+func (s *StreamServer) ResumeWrites() {
+	if s.writesPaused.Swap(false) {
+		log.Infof("Writes resumed")
+	}
+}
+
+// WritesPaused returns if the writes are paused
+func (s *StreamServer) WritesPaused() bool {
+	return s.writesPaused.Load()
+}
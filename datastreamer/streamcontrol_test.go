@@ -0,0 +1,94 @@
+package datastreamer
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// controlCall sends a control request and decodes its response
+func controlCall(t *testing.T, conn net.Conn, reader *bufio.Reader, method string, params any,
+	result any) *ControlError {
+	t.Helper()
+
+	req := map[string]any{"jsonrpc": "2.0", "id": 1, "method": method}
+	if params != nil {
+		req["params"] = params
+	}
+	require.NoError(t, json.NewEncoder(conn).Encode(req))
+
+	line, err := reader.ReadBytes('\n')
+	require.NoError(t, err)
+	var rsp struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      int             `json:"id"`
+		Result  json.RawMessage `json:"result"`
+		Error   *ControlError   `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(line, &rsp))
+	assert.Equal(t, "2.0", rsp.JSONRPC)
+	assert.Equal(t, 1, rsp.ID)
+	if rsp.Error == nil && result != nil {
+		require.NoError(t, json.Unmarshal(rsp.Result, result))
+	}
+	return rsp.Error
+}
+
+func TestControlServer(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	for range 5 {
+		_, err := s.AddStreamEntry(1, []byte{1, 2, 3})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	c := NewControlServer(s, 0, "secret")
+	require.NoError(t, c.Start())
+	defer c.Close()
+
+	conn, err := net.Dial("tcp", c.ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Methods rejected until authenticated
+	rspErr := controlCall(t, conn, reader, "stats", nil, nil)
+	require.NotNil(t, rspErr)
+	assert.Equal(t, ControlErrUnauthorized, rspErr.Code)
+	rspErr = controlCall(t, conn, reader, "auth", map[string]string{"token": "wrong"}, nil)
+	require.NotNil(t, rspErr)
+	assert.Equal(t, ControlErrUnauthorized, rspErr.Code)
+	require.Nil(t, controlCall(t, conn, reader, "auth", map[string]string{"token": "secret"}, nil))
+
+	var stats ControlStats
+	require.Nil(t, controlCall(t, conn, reader, "stats", nil, &stats))
+	assert.Equal(t, uint64(5), stats.TotalEntries) //nolint:mnd
+	assert.False(t, stats.WritesPaused)
+
+	var clients []ControlClient
+	require.Nil(t, controlCall(t, conn, reader, "listClients", nil, &clients))
+	assert.Empty(t, clients)
+
+	// Truncate only allowed with the writes paused
+	rspErr = controlCall(t, conn, reader, "truncate", map[string]uint64{"entry": 3}, nil)
+	require.NotNil(t, rspErr)
+	assert.Equal(t, ControlErrFailed, rspErr.Code)
+
+	require.Nil(t, controlCall(t, conn, reader, "pauseWrites", nil, nil))
+	require.ErrorIs(t, s.StartAtomicOp(), ErrWritesPaused)
+	var total uint64
+	require.Nil(t, controlCall(t, conn, reader, "truncate", map[string]uint64{"entry": 3}, &total))
+	assert.Equal(t, uint64(3), total) //nolint:mnd
+	require.Nil(t, controlCall(t, conn, reader, "resumeWrites", nil, nil))
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.RollbackAtomicOp())
+
+	rspErr = controlCall(t, conn, reader, "unknown", nil, nil)
+	require.NotNil(t, rspErr)
+	assert.Equal(t, ControlErrMethodNotFound, rspErr.Code)
+}
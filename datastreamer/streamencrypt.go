@@ -0,0 +1,197 @@
+package datastreamer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// KeyProvider type for the function returning the encryption key of a stream file (e.g. fetched from a KMS)
+type KeyProvider func() ([]byte, error)
+
+// StaticKey returns a key provider with a fixed key
+func StaticKey(key []byte) KeyProvider {
+	return func() ([]byte, error) {
+		return key, nil
+	}
+}
+
+// encryptionMode type for the at-rest encryption of the entries data of a stream file
+type encryptionMode uint8
+
+const (
+	encryptionNone   encryptionMode = iota // Entries data stored in plaintext
+	encryptionAESGCM                       // Entries data encrypted with AES-GCM
+
+	nonceBaseSize      = 4         // Random part of the nonces, per file
+	nonceCounterSize   = 8         // Counter part of the nonces, per encrypted entry
+	keyCheckSize       = 16        // Tag to check the key when opening the file
+	encryptionOverhead = 8 + 16    // Bytes added to the encrypted data (nonce counter + tag)
+	nonceReserve       = 1024 * 64 // Nonce counters reserved in the header at once
+	nonceLimitPos      = 15        // Position of the nonce counters limit in the header extension
+	keyCheckCounter    = uint64(0) // Nonce counter used for the key check
+	firstEntryCounter  = uint64(1) // First nonce counter used for the entries
+)
+
+// keyCheckData is the additional data authenticated by the key check tag
+var keyCheckData = []byte("polygonDATSTREAM key check")
+
+// entryCipher type to encrypt the data of the entries of a stream file. The nonce of each entry is the random base of
+// the file followed by a counter stored with the encrypted data, and the entry number is authenticated with it.
+type entryCipher struct {
+	aead    cipher.AEAD
+	base    [nonceBaseSize]byte
+	counter uint64 // Next nonce counter
+}
+
+// setupEncryption enables the encryption of a file without entries, or checks the key of an encrypted file
+func (f *StreamFile) setupEncryption(provider KeyProvider) error {
+	if provider == nil {
+		if f.header.encryption != encryptionNone {
+			log.Errorf("File %s is encrypted, encryption key required", f.fileName)
+			return ErrEncryptionKeyMissing
+		}
+		return nil
+	}
+
+	key, err := provider()
+	if err != nil {
+		log.Errorf("Error getting the encryption key of file %s: %v", f.fileName, err)
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Errorf("Invalid encryption key for file %s: %v", f.fileName, err)
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	c := &entryCipher{aead: aead}
+
+	switch {
+	case f.header.encryption == encryptionAESGCM:
+		// The key must produce the key check tag of the file
+		c.base = f.header.nonceBase
+		if !c.checkKey(f.header.keyCheck) {
+			log.Errorf("Wrong encryption key for file %s", f.fileName)
+			return ErrDecryptionFailed
+		}
+		c.counter = f.header.nonceLimit
+
+	case f.header.TotalLength == PageHeaderSize && f.header.TotalEntries == f.header.firstEntry:
+		// New encryption with a random nonce base
+		_, err = rand.Read(c.base[:])
+		if err != nil {
+			return err
+		}
+		c.counter = firstEntryCounter
+		f.mutexHeader.Lock()
+		f.header.encryption = encryptionAESGCM
+		f.header.nonceBase = c.base
+		f.header.nonceLimit = firstEntryCounter
+		f.header.keyCheck = c.keyCheck()
+		f.mutexHeader.Unlock()
+		err = f.writeHeaderEntry()
+		if err != nil {
+			return err
+		}
+
+	default:
+		log.Errorf("File %s has entries stored without encryption", f.fileName)
+		return ErrFileNotEncrypted
+	}
+
+	f.cipher = c
+	return nil
+}
+
+// encryptEntry encrypts the data of an entry with the next nonce counter, reserving more counters when needed
+func (f *StreamFile) encryptEntry(e *FileEntry) error {
+	if f.cipher.counter >= f.header.nonceLimit {
+		err := f.reserveNonces(f.cipher.counter + nonceReserve)
+		if err != nil {
+			return err
+		}
+	}
+
+	e.Data = f.cipher.seal(f.cipher.counter, e.Number, e.Data)
+	e.Length = FixedSizeFileEntry + uint32(len(e.Data))
+	f.cipher.counter++
+	return nil
+}
+
+// decryptEntry decrypts the data of an entry, fails with ErrDecryptionFailed if it's not authentic
+func (f *StreamFile) decryptEntry(e *FileEntry) error {
+	data, err := f.cipher.open(e.Number, e.Data)
+	if err != nil {
+		log.Errorf("Error decrypting entry %d: %v", e.Number, err)
+		return ErrDecryptionFailed
+	}
+	e.Data = data
+	e.Length = FixedSizeFileEntry + uint32(len(e.Data))
+	return nil
+}
+
+// isEncrypted returns if the data of an entry type is encrypted in the file (bookmarks stay in plaintext)
+func (f *StreamFile) isEncrypted(etype EntryType) bool {
+	return f.cipher != nil && etype != EtBookmark
+}
+
+// reserveNonces writes the new limit of the nonce counters in the header on disk before using them, so they are
+// never reused after a restart even if the entries encrypted with them were not committed
+func (f *StreamFile) reserveNonces(limit uint64) error {
+	_, err := f.fileHeader.WriteAt(binary.BigEndian.AppendUint64(nil, limit), headerExtPos+nonceLimitPos)
+	if err != nil {
+		log.Errorf("Error writing the nonce counters limit: %v", err)
+		return err
+	}
+	err = f.fileHeader.Sync()
+	if err != nil {
+		log.Errorf("Error flushing the nonce counters limit: %v", err)
+		return err
+	}
+
+	f.mutexHeader.Lock()
+	f.header.nonceLimit = limit
+	f.writtenHead.nonceLimit = limit
+	f.mutexHeader.Unlock()
+	return nil
+}
+
+// nonce returns the nonce for a counter
+func (c *entryCipher) nonce(counter uint64) []byte {
+	return binary.BigEndian.AppendUint64(c.base[:], counter)
+}
+
+// seal encrypts data with a nonce counter and returns the counter followed by the encrypted data
+func (c *entryCipher) seal(counter uint64, entryNum uint64, data []byte) []byte {
+	out := binary.BigEndian.AppendUint64(make([]byte, 0, encryptionOverhead+len(data)), counter)
+	return c.aead.Seal(out, c.nonce(counter), data, binary.BigEndian.AppendUint64(nil, entryNum))
+}
+
+// open decrypts the data sealed for an entry
+func (c *entryCipher) open(entryNum uint64, sealed []byte) ([]byte, error) {
+	if len(sealed) < encryptionOverhead {
+		return nil, ErrDecryptionFailed
+	}
+	counter := binary.BigEndian.Uint64(sealed[:nonceCounterSize])
+	return c.aead.Open(nil, c.nonce(counter), sealed[nonceCounterSize:], binary.BigEndian.AppendUint64(nil, entryNum))
+}
+
+// keyCheck returns the key check tag of the key
+func (c *entryCipher) keyCheck() [keyCheckSize]byte {
+	var tag [keyCheckSize]byte
+	copy(tag[:], c.aead.Seal(nil, c.nonce(keyCheckCounter), nil, keyCheckData))
+	return tag
+}
+
+// checkKey returns if the key produces a key check tag
+func (c *entryCipher) checkKey(tag [keyCheckSize]byte) bool {
+	_, err := c.aead.Open(nil, c.nonce(keyCheckCounter), tag[:], keyCheckData)
+	return err == nil
+}
@@ -0,0 +1,116 @@
+package datastreamer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryption(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	key := StaticKey(bytes.Repeat([]byte{7}, 32)) //nolint:mnd
+	secret := []byte("confidential entry data")
+
+	s, err := openTestServer(t, fileName, StreamFileOptions{EncryptionKey: key}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamBookmark([]byte("block-1"))
+	require.NoError(t, err)
+	for range 3 {
+		_, err = s.AddStreamEntry(1, secret)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.UpdateEntryData(2, 1, bytes.ToUpper(secret)))
+	require.NoError(t, s.Tombstone(3))
+
+	// Read transparently
+	entry, err := s.GetEntry(1)
+	require.NoError(t, err)
+	assert.Equal(t, secret, entry.Data)
+	assert.Equal(t, uint32(FixedSizeFileEntry+len(secret)), entry.Length)
+	entry, err = s.GetEntry(2)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.ToUpper(secret), entry.Data)
+	size, err := s.RangeDataSize(1, 4)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3*len(secret)), size)
+	require.NoError(t, s.Close())
+
+	// Encrypted on disk, bookmarks in plaintext
+	raw, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(raw, secret))
+	assert.False(t, bytes.Contains(raw, bytes.ToUpper(secret)))
+	assert.True(t, bytes.Contains(raw, []byte("block-1")))
+
+	// Reopen with the right key and add more entries
+	s, err = openTestServer(t, fileName, StreamFileOptions{EncryptionKey: key}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, secret)
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	var count int
+	for entry, err := range s.Entries(0, s.GetHeader().TotalEntries) {
+		require.NoError(t, err)
+		if entry.Type == 1 {
+			assert.True(t, bytes.EqualFold(secret, entry.Data))
+		}
+		count++
+	}
+	assert.Equal(t, 4, count) //nolint:mnd
+	require.NoError(t, s.Close())
+
+	// Wrong or missing key
+	wrongKey := StaticKey(bytes.Repeat([]byte{8}, 32)) //nolint:mnd
+	_, err = openTestServer(t, fileName, StreamFileOptions{EncryptionKey: wrongKey}, nil)
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+	_, err = openTestServer(t, fileName, StreamFileOptions{EncryptionKey: nil}, nil)
+	assert.ErrorIs(t, err, ErrEncryptionKeyMissing)
+
+	// Offline copies not supported
+	_, err = SplitByType(fileName, filepath.Join(t.TempDir(), "split"))
+	assert.ErrorIs(t, err, ErrFileEncrypted)
+}
+
+func TestEncryptionCorruptedEntry(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	key := StaticKey(bytes.Repeat([]byte{7}, 16)) //nolint:mnd
+
+	s, err := openTestServer(t, fileName, StreamFileOptions{EncryptionKey: key}, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, []byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.Close())
+
+	// Flip a byte of the encrypted data
+	patchFile(t, fileName, PageHeaderSize+FixedSizeFileEntry+nonceCounterSize, []byte{0xff})
+	s, err = openTestServer(t, fileName, StreamFileOptions{EncryptionKey: key}, nil)
+	require.NoError(t, err)
+	_, err = s.GetEntry(0)
+	assert.ErrorIs(t, err, ErrPageChecksumMismatch)
+	require.NoError(t, s.Close())
+
+	// Without page checksums the decryption detects it
+	patchFile(t, fileName, headerExtPos+43, []byte{byte(checksumsNone)}) //nolint:mnd
+	s, err = openTestServer(t, fileName, StreamFileOptions{EncryptionKey: key}, nil)
+	require.NoError(t, err)
+	_, err = s.GetEntry(0)
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+}
+
+func TestEncryptionPlaintextFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	writeTestStream(t, fileName, 10) //nolint:mnd
+
+	key := StaticKey(bytes.Repeat([]byte{7}, 32)) //nolint:mnd
+	_, err := openTestServer(t, fileName, StreamFileOptions{EncryptionKey: key}, nil)
+	assert.ErrorIs(t, err, ErrFileNotEncrypted)
+}
@@ -0,0 +1,63 @@
+package datastreamer
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// AddStreamEntryAt adds a new entry in the current atomic operation as AddStreamEntry only if it gets the expected
+// entry number, so a writer retrying after a crash doesn't add the same entry twice. If the entry number already exists
+// with the same type and data (committed or added in the current atomic operation) nothing is added and it succeeds,
+// with different ones it fails with ErrEntryConflict. An entry number beyond the next one fails with
+// ErrEntryNumberNotContiguous.
+func (s *StreamServer) AddStreamEntryAt(expectedNum uint64, etype EntryType, data []byte) (uint64, error) {
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	switch {
+	case expectedNum == s.nextEntry:
+		return s.addStreamEntry(etype, data)
+	case expectedNum > s.nextEntry:
+		log.Errorf("Invalid entry number %d to add, expected %d", expectedNum, s.nextEntry)
+		return 0, ErrEntryNumberNotContiguous
+	}
+
+	// Entry already added, check it's the same one
+	match, err := s.entryMatches(expectedNum, etype, data)
+	if err != nil {
+		return 0, err
+	}
+	if !match {
+		log.Errorf("Entry %d already exists with a different type or data", expectedNum)
+		return 0, ErrEntryConflict
+	}
+	log.Debugf("Entry %d already exists, not added again", expectedNum)
+	return expectedNum, nil
+}
+
+// entryMatches returns if an existing entry has a type and data
+func (s *StreamServer) entryMatches(entryNum uint64, etype EntryType, data []byte) (bool, error) {
+	// Entry of the atomic operation in progress, as stored
+	if s.atomicOp.status == aoStarted && entryNum >= s.atomicOp.startEntry {
+		e, err := s.newStreamEntry("Data", etype, data, entryNum)
+		if err != nil {
+			return false, err
+		}
+		current := s.atomicOp.entries[entryNum-s.atomicOp.startEntry]
+		return current.Type == e.Type && bytes.Equal(current.Data, e.Data), nil
+	}
+
+	// Committed entry, as read
+	current, err := s.GetEntry(entryNum)
+	if errors.Is(err, ErrEntryTombstoned) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return current.Type == etype && bytes.Equal(current.Data, data), nil
+}
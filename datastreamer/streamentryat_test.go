@@ -0,0 +1,58 @@
+package datastreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddStreamEntryAt(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+
+	// Normal append
+	for num := range uint64(3) {
+		entryNum, err := s.AddStreamEntryAt(num, 1, []byte{byte(num)})
+		require.NoError(t, err)
+		assert.Equal(t, num, entryNum)
+	}
+	_, err := s.AddStreamEntryAt(4, 1, []byte{4}) //nolint:mnd
+	require.ErrorIs(t, err, ErrEntryNumberNotContiguous)
+
+	// Retry of an entry of the atomic operation in progress
+	entryNum, err := s.AddStreamEntryAt(1, 1, []byte{1})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), entryNum)
+	_, err = s.AddStreamEntryAt(1, 1, []byte{9}) //nolint:mnd
+	require.ErrorIs(t, err, ErrEntryConflict)
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Equal(t, uint64(3), s.GetHeader().TotalEntries)
+
+	// Retry of committed entries, no-op without an atomic operation
+	entryNum, err = s.AddStreamEntryAt(2, 1, []byte{2})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), entryNum)
+	_, err = s.AddStreamEntryAt(2, 2, []byte{2})
+	require.ErrorIs(t, err, ErrEntryConflict)
+	_, err = s.AddStreamEntryAt(0, 1, []byte{0, 0})
+	require.ErrorIs(t, err, ErrEntryConflict)
+
+	// Tombstoned entries are not the same entry
+	require.NoError(t, s.Tombstone(0))
+	_, err = s.AddStreamEntryAt(0, 1, []byte{0})
+	require.ErrorIs(t, err, ErrEntryConflict)
+
+	// Retries after new entries, appending the missing one
+	require.NoError(t, s.StartAtomicOp())
+	for num := range uint64(4) {
+		entryNum, err = s.AddStreamEntryAt(num+1, 1, []byte{byte(num + 1)})
+		require.NoError(t, err)
+		assert.Equal(t, num+1, entryNum)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Equal(t, uint64(5), s.GetHeader().TotalEntries)
+	e, err := s.GetEntry(4) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, []byte{4}, e.Data)
+}
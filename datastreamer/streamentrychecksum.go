@@ -0,0 +1,104 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+const entryChecksumSize = 4 // Size of the checksum appended to the entry packets
+
+// SetVerifyChecksums sets if the entries received are verified, before Start: the client negotiates a checksum of
+// each entry with the server on connection (CmdEntryChecksums) and checks it before delivering the entry, ending the
+// streaming with ErrEntryChecksumMismatch (see Errors) if the entry was corrupted on the way. Disabled by default, the
+// entries are not verified with servers not supporting it.
+func (c *StreamClient) SetVerifyChecksums(verify bool) {
+	c.verifyChecksums = verify
+}
+
+// entryChecksum returns the checksum of an entry packet, before the wire compression: the entry type, number and data
+func entryChecksum(packet []byte) uint32 {
+	return crc32.Checksum(packet[5:], crcTable)
+}
+
+// isEntryPacket returns if a packet is a data entry (PtData and PtDataRsp packets)
+func isEntryPacket(packet []byte) bool {
+	return len(packet) >= FixedSizeFileEntry && (packet[0] == PtData || packet[0] == PtDataRsp)
+}
+
+// processCmdEntryChecksums processes the TCP EntryChecksums command from the clients, negotiating the checksum of the
+// entries sent, answered with the OK result (old servers answer it with an invalid command result)
+func (s *StreamServer) processCmdEntryChecksums(client *client) error {
+	log.Debugf("Client %s command EntryChecksums, negotiated", client.clientID)
+	err := s.sendResultEntry(uint32(CmdErrOK), StrCommandErrors[CmdErrOK], client)
+	if err != nil {
+		return err
+	}
+	client.entryChecksums.Store(true)
+	return nil
+}
+
+// negotiateEntryChecksums asks the server for the checksum of the entries sent just after connecting, before any
+// other command, if verifying them
+func (c *StreamClient) negotiateEntryChecksums() error {
+	c.entryChecksums = false
+	if !c.verifyChecksums {
+		return nil
+	}
+	err := c.writeCommand(CmdEntryChecksums, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	// The server answers with the OK result, or with an invalid command result if it doesn't support it
+	err = c.conn.SetReadDeadline(time.Now().Add(defaultTimeout))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.conn.SetReadDeadline(time.Time{}) }()
+	packet := make([]byte, 1)
+	err = c.readContent(packet)
+	if err != nil {
+		return err
+	}
+	if packet[0] != PtResult {
+		log.Errorf("%s Unexpected packet type %d negotiating entry checksums", c.ID, packet[0])
+		return ErrInvalidCommand
+	}
+	r, err := c.readResultEntry()
+	if err != nil {
+		return err
+	}
+	if r.errorNum != uint32(CmdErrOK) {
+		log.Warnf("%s Entry checksums not supported by server %s, entries not verified: %s", c.ID, c.server,
+			r.errorStr)
+		return nil
+	}
+	c.entryChecksums = true
+	return nil
+}
+
+// splitEntryChecksum removes the checksum appended to an entry packet received, returning it
+func (c *StreamClient) splitEntryChecksum(packet []byte) ([]byte, uint32, error) {
+	if len(packet) < FixedSizeFileEntry+entryChecksumSize {
+		log.Errorf("%s Error reading data entry, missing checksum", c.ID)
+		return nil, 0, ErrReadingDataEntry
+	}
+	end := len(packet) - entryChecksumSize
+	checksum := binary.BigEndian.Uint32(packet[end:])
+	packet = packet[:end]
+	binary.BigEndian.PutUint32(packet[1:5], uint32(end))
+	return packet, checksum, nil
+}
+
+// verifyEntryChecksum checks the checksum received with an entry packet, once decompressed
+func (c *StreamClient) verifyEntryChecksum(packet []byte, checksum uint32) error {
+	if entryChecksum(packet) != checksum {
+		log.Errorf("%s Checksum mismatch of entry %d received from server %s", c.ID,
+			binary.BigEndian.Uint64(packet[9:17]), c.server)
+		return ErrEntryChecksumMismatch
+	}
+	return nil
+}
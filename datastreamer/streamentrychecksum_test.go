@@ -0,0 +1,157 @@
+package datastreamer
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// markerCorrupter flips the last byte of the first occurrence of a marker read through it
+type markerCorrupter struct {
+	r       io.Reader
+	marker  []byte // Without repeated bytes
+	matched int    // Bytes of the marker matched at the end of the last read (-1: already corrupted)
+}
+
+func (c *markerCorrupter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i := 0; i < n && c.matched >= 0; i++ {
+		if p[i] != c.marker[c.matched] {
+			c.matched = 0
+		}
+		if p[i] == c.marker[c.matched] {
+			c.matched++
+		}
+		if c.matched == len(c.marker) {
+			p[i] ^= 0xff
+			c.matched = -1
+		}
+	}
+	return n, err
+}
+
+// startCorruptingProxy forwards the connections to a server, corrupting the first occurrence of a marker sent by
+// the server, returning its address
+func startCorruptingProxy(t *testing.T, server string, marker []byte) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", server)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go func() {
+				_, _ = io.Copy(upstream, conn)
+				upstream.Close()
+			}()
+			go func() {
+				_, _ = io.Copy(conn, &markerCorrupter{r: upstream, marker: marker})
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestEntryChecksums(t *testing.T) {
+	entries := [][]byte{bytes.Repeat([]byte("entry checksums "), 100), {}, {1, 2, 3}} //nolint:mnd
+
+	for _, codec := range []Codec{CodecNone, CodecZstd} {
+		s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+			s.SetWireCompression([]Codec{codec})
+		})
+		c, err := NewClient(testServerAddr(s), 1)
+		require.NoError(t, err)
+		c.SetWireCompression([]Codec{codec})
+		c.SetVerifyChecksums(true)
+		received := make(chan FileEntry, 10) //nolint:mnd
+		c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+			received <- *e
+			return nil
+		})
+		startClientUntilCleanup(t, c)
+		assert.True(t, c.entryChecksums)
+
+		// Entries streamed and the response of the entry command verified
+		require.NoError(t, s.StartAtomicOp())
+		for _, data := range entries {
+			_, err = s.AddStreamEntry(1, data)
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+		require.NoError(t, c.ExecCommandStart(0))
+		for i, data := range entries {
+			select {
+			case e := <-received:
+				assert.Equal(t, uint64(i), e.Number, "codec %d", codec)
+				assert.Equal(t, data, e.Data, "codec %d", codec)
+				assert.Equal(t, FixedSizeFileEntry+uint32(len(data)), e.Length, "codec %d", codec)
+			case <-time.After(5 * time.Second): //nolint:mnd
+				t.Fatalf("entry %d not received with codec %d", i, codec)
+			}
+		}
+		require.NoError(t, c.ExecCommandStop())
+		e, err := c.ExecCommandGetEntry(0)
+		require.NoError(t, err)
+		assert.Equal(t, entries[0], e.Data, "codec %d", codec)
+	}
+}
+
+func TestEntryChecksumMismatch(t *testing.T) {
+	marker := []byte("0123456789abcdef")
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	for _, data := range [][]byte{{1, 2, 3}, marker, {4, 5, 6}} {
+		_, err := s.AddStreamEntry(1, data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	// Verified, the streaming ends at the corrupted entry
+	c, err := NewClient(startCorruptingProxy(t, testServerAddr(s), marker), 1)
+	require.NoError(t, err)
+	c.SetVerifyChecksums(true)
+	entries := c.Entries()
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.ExecCommandStart(0))
+	assert.Equal(t, []uint64{0}, receiveEntries(t, entries, 1))
+	select {
+	case err := <-c.Errors():
+		require.ErrorIs(t, err, ErrEntryChecksumMismatch)
+	case <-time.After(5 * time.Second): //nolint:mnd
+		t.Fatal("checksum mismatch not reported")
+	}
+	_, ok := <-entries
+	assert.False(t, ok)
+
+	// Not verified, the corrupted entry is delivered
+	c, err = NewClient(startCorruptingProxy(t, testServerAddr(s), marker), 1)
+	require.NoError(t, err)
+	entries = c.Entries()
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.ExecCommandStart(0))
+	var corrupted FileEntry
+	for range 2 {
+		select {
+		case corrupted = <-entries:
+		case <-time.After(5 * time.Second): //nolint:mnd
+			t.Fatal("entry not received")
+		}
+	}
+	assert.Equal(t, uint64(1), corrupted.Number)
+	assert.NotEqual(t, marker, corrupted.Data)
+}
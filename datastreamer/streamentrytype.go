@@ -0,0 +1,105 @@
+package datastreamer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// typeURLPrefix is the prefix of the type URLs for the protobuf Any messages
+const typeURLPrefix = "type.googleapis.com/"
+
+// ProcessAnyEntryFunc type of the callback function to process the received entry wrapped in a protobuf Any
+type ProcessAnyEntryFunc func(entryNum uint64, a *anypb.Any, c *StreamClient) error
+
+var (
+	entryTypeURLs     = map[EntryType]string{}               // Type URL of the protobuf message registered for each entry type
+	entryTypeMessages = map[EntryType]func() proto.Message{} // Constructor of the message registered for each entry type
+	mutexEntryTypes   sync.RWMutex
+)
+
+// RegisterEntryType registers the protobuf message stored in the data of an entry type, newMessage returns a new empty
+// message to decode the data into
+func RegisterEntryType(etype EntryType, newMessage func() proto.Message) error {
+	mutexEntryTypes.Lock()
+	defer mutexEntryTypes.Unlock()
+
+	if _, exists := entryTypeURLs[etype]; exists {
+		log.Errorf("Entry type %d already registered", etype)
+		return ErrEntryTypeRegistered
+	}
+	name := newMessage().ProtoReflect().Descriptor().FullName()
+	entryTypeURLs[etype] = typeURLPrefix + string(name)
+	entryTypeMessages[etype] = newMessage
+	return nil
+}
+
+// isEntryTypeRegistered returns if a protobuf message is registered for an entry type
+func isEntryTypeRegistered(etype EntryType) bool {
+	mutexEntryTypes.RLock()
+	defer mutexEntryTypes.RUnlock()
+
+	_, ok := entryTypeURLs[etype]
+	return ok
+}
+
+// EntryTypeURL returns the protobuf type URL of a registered entry type
+func EntryTypeURL(etype EntryType) (string, error) {
+	mutexEntryTypes.RLock()
+	defer mutexEntryTypes.RUnlock()
+
+	typeURL, ok := entryTypeURLs[etype]
+	if !ok {
+		return "", ErrEntryTypeNotRegistered
+	}
+	return typeURL, nil
+}
+
+// EntryToAny wraps the data of an entry in a protobuf Any with the type URL of its registered entry type
+func EntryToAny(e *FileEntry) (*anypb.Any, error) {
+	typeURL, err := EntryTypeURL(e.Type)
+	if err != nil {
+		log.Errorf("Entry %d has no registered type for entry type %d", e.Number, e.Type)
+		return nil, err
+	}
+	return &anypb.Any{TypeUrl: typeURL, Value: e.Data}, nil
+}
+
+// DecodeEntry decodes the data of an entry into the protobuf message registered for its entry type, failing with
+// ErrEntryTypeNotRegistered if there is none or ErrEntryDecodeFailed if the data is not a valid message
+func DecodeEntry(entry FileEntry) (proto.Message, error) {
+	mutexEntryTypes.RLock()
+	newMessage, ok := entryTypeMessages[entry.Type]
+	mutexEntryTypes.RUnlock()
+	if !ok {
+		log.Errorf("Entry %d has no registered type for entry type %d", entry.Number, entry.Type)
+		return nil, ErrEntryTypeNotRegistered
+	}
+
+	m := newMessage()
+	err := proto.Unmarshal(entry.Data, m)
+	if err != nil {
+		log.Errorf("Error decoding entry %d of type %d: %v", entry.Number, entry.Type, err)
+		return nil, fmt.Errorf("%w: %v", ErrEntryDecodeFailed, err)
+	}
+	return m, nil
+}
+
+// SetEntryValidation enables the validation of the data entries added, disabled by default. The data of an entry
+// type registered with RegisterEntryType must decode into its message, otherwise adding it fails with
+// ErrEntryDecodeFailed. The data of unregistered entry types is not checked.
+func (s *StreamServer) SetEntryValidation(enabled bool) {
+	s.validateEntries = enabled
+}
+
+// validateEntry checks the data of an entry to add decodes into the message registered for its entry type, if any
+func validateEntry(etype EntryType, data []byte) error {
+	if !isEntryTypeRegistered(etype) {
+		return nil
+	}
+	_, err := DecodeEntry(FileEntry{Type: etype, Data: data})
+	return err
+}
@@ -0,0 +1,135 @@
+package datastreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/datastream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestProcessAnyEntry(t *testing.T) {
+	const etypeL2Block = EntryType(0x1002)
+	require.NoError(t, RegisterEntryType(etypeL2Block, func() proto.Message { return &datastream.L2Block{} }))
+	t.Cleanup(func() { unregisterEntryType(etypeL2Block) })
+	assert.ErrorIs(t, RegisterEntryType(etypeL2Block, func() proto.Message { return &datastream.L2Block{} }),
+		ErrEntryTypeRegistered)
+
+	block := &datastream.L2Block{Number: 7, BatchNumber: 3, Hash: []byte{0xaa, 0xbb}} //nolint:mnd
+	data, err := proto.Marshal(block)
+	require.NoError(t, err)
+
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamBookmark([]byte{1})
+	require.NoError(t, err)
+	_, err = s.AddStreamEntry(etypeL2Block, data)
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	received := make(chan *anypb.Any, 1)
+	c.SetProcessAnyEntryFunc(func(entryNum uint64, a *anypb.Any, _ *StreamClient) error {
+		assert.Equal(t, uint64(1), entryNum)
+		received <- a
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.ExecCommandStart(0))
+
+	select {
+	case a := <-received:
+		assert.Equal(t, "type.googleapis.com/datastream.v1.L2Block", a.GetTypeUrl())
+		assert.Equal(t, data, a.GetValue())
+
+		decoded := &datastream.L2Block{}
+		require.NoError(t, a.UnmarshalTo(decoded))
+		assert.True(t, proto.Equal(block, decoded))
+	case <-time.After(5 * time.Second): //nolint:mnd
+		t.Fatal("entry not received")
+	}
+}
+
+func TestDecodeEntry(t *testing.T) {
+	const (
+		etypeBatchStart = EntryType(0x1003)
+		etypeL2Block    = EntryType(0x1004)
+	)
+	require.NoError(t, RegisterEntryType(etypeBatchStart, func() proto.Message { return &datastream.BatchStart{} }))
+	t.Cleanup(func() { unregisterEntryType(etypeBatchStart) })
+	require.NoError(t, RegisterEntryType(etypeL2Block, func() proto.Message { return &datastream.L2Block{} }))
+	t.Cleanup(func() { unregisterEntryType(etypeL2Block) })
+
+	batch := &datastream.BatchStart{Number: 3, ChainId: 1001} //nolint:mnd
+	batchData, err := proto.Marshal(batch)
+	require.NoError(t, err)
+	block := &datastream.L2Block{Number: 7, BatchNumber: 3} //nolint:mnd
+	blockData, err := proto.Marshal(block)
+	require.NoError(t, err)
+
+	// Each entry decoded into the message of its type
+	m, err := DecodeEntry(FileEntry{Type: etypeBatchStart, Data: batchData})
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(batch, m))
+	m, err = DecodeEntry(FileEntry{Type: etypeL2Block, Data: blockData})
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(block, m))
+
+	_, err = DecodeEntry(FileEntry{Type: etypeL2Block, Data: []byte{0xff, 0xff}})
+	assert.ErrorIs(t, err, ErrEntryDecodeFailed)
+	_, err = DecodeEntry(FileEntry{Type: EntryType(0x1fff), Data: blockData})
+	assert.ErrorIs(t, err, ErrEntryTypeNotRegistered)
+}
+
+func TestEntryValidation(t *testing.T) {
+	const etypeL2Block = EntryType(0x1005)
+	require.NoError(t, RegisterEntryType(etypeL2Block, func() proto.Message { return &datastream.L2Block{} }))
+	t.Cleanup(func() { unregisterEntryType(etypeL2Block) })
+
+	blockData, err := proto.Marshal(&datastream.L2Block{Number: 7}) //nolint:mnd
+	require.NoError(t, err)
+	malformed := []byte{0xff, 0xff}
+
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+
+	// Not validated by default
+	_, err = s.AddStreamEntry(etypeL2Block, malformed)
+	require.NoError(t, err)
+
+	// Registered types validated, the rest added as they are
+	s.SetEntryValidation(true)
+	_, err = s.AddStreamEntry(etypeL2Block, malformed)
+	require.ErrorIs(t, err, ErrEntryDecodeFailed)
+	_, err = s.AddStreamEntries([]StreamEntryInput{
+		{Type: etypeL2Block, Data: blockData},
+		{Type: etypeL2Block, Data: malformed},
+	})
+	require.ErrorIs(t, err, ErrEntryDecodeFailed)
+	entryNum, err := s.AddStreamEntry(etypeL2Block, blockData)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), entryNum)
+	_, err = s.AddStreamEntry(1, malformed)
+	require.NoError(t, err)
+	_, err = s.AddStreamBookmark([]byte{1})
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Equal(t, uint64(4), s.GetHeader().TotalEntries)
+}
+
+func TestEntryToAnyNotRegistered(t *testing.T) {
+	_, err := EntryToAny(&FileEntry{Type: EntryType(0x1fff), Data: []byte{1}})
+	assert.ErrorIs(t, err, ErrEntryTypeNotRegistered)
+}
+
+// unregisterEntryType removes an entry type registered by a test
+func unregisterEntryType(etype EntryType) {
+	mutexEntryTypes.Lock()
+	defer mutexEntryTypes.Unlock()
+	delete(entryTypeURLs, etype)
+	delete(entryTypeMessages, etype)
+}
@@ -0,0 +1,278 @@
+package datastreamer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the StreamEntry protobuf message of the proto stream export
+const (
+	protoFieldNumber     protowire.Number = 1 // uint64 number
+	protoFieldType       protowire.Number = 2 // uint32 type
+	protoFieldTimestamp  protowire.Number = 3 // int64 timestamp (unix nanoseconds of the commit, 0: unknown)
+	protoFieldData       protowire.Number = 4 // bytes data
+	protoFieldTombstoned protowire.Number = 5 // bool tombstoned
+
+	maxProtoMessageSize = 1 << 30 // Max size of a StreamEntry message read from a proto stream
+)
+
+// ProtoEntry type for an entry of a proto stream export
+type ProtoEntry struct {
+	FileEntry
+	Timestamp time.Time // Commit time from the commit journal (zero: unknown)
+}
+
+// ExportProtoStream writes the entries (bookmarks and tombstoned entries included) from an entry number until another
+// one (excluding) to a writer as length-delimited protobuf messages (varint size followed by the message), streaming
+// them as they are read from the file. The message is:
+//
+//	message StreamEntry {
+//	    uint64 number = 1;
+//	    uint32 type = 2;
+//	    int64 timestamp = 3; // Commit unix nanoseconds from the commit journal (0: journal disabled)
+//	    bytes data = 4;      // Data as returned by the query API (read transform applied)
+//	    bool tombstoned = 5;
+//	}
+func (s *StreamServer) ExportProtoStream(w io.Writer, from, to uint64) error {
+	header := s.streamFile.getHeaderEntry()
+	if from > to || from < header.firstEntry || to > header.TotalEntries {
+		log.Errorf("Invalid entry range [%d, %d) to export, entries [%d, %d)", from, to, header.firstEntry,
+			header.TotalEntries)
+		return ErrInvalidEntryNumber
+	}
+	if from == to {
+		return nil
+	}
+
+	iterator, err := s.streamFile.iteratorFrom(from, true)
+	if err != nil {
+		return err
+	}
+	defer s.streamFile.iteratorEnd(iterator)
+
+	bw := bufio.NewWriter(w)
+	for entryNum := from; entryNum < to; entryNum++ {
+		end, err := s.streamFile.iteratorNext(iterator)
+		if err != nil {
+			return err
+		}
+		if end {
+			log.Errorf("Entry %d to export not found", entryNum)
+			return ErrInvalidEntryNumber
+		}
+
+		entry := ProtoEntry{FileEntry: iterator.Entry}
+		err = transformEntry(&entry.FileEntry, s.readTransform)
+		if err != nil {
+			log.Errorf("Error transforming entry %d data: %v", entry.Number, err)
+			return err
+		}
+		if s.journal != nil {
+			r, err := s.journal.GetCommit(entry.Number)
+			if err != nil && !errors.Is(err, ErrCommitNotFound) {
+				return err
+			}
+			entry.Timestamp = r.Timestamp
+		}
+
+		msg := encodeProtoEntry(entry)
+		_, err = bw.Write(protowire.AppendVarint(nil, uint64(len(msg))))
+		if err == nil {
+			_, err = bw.Write(msg)
+		}
+		if err != nil {
+			log.Errorf("Error writing entry %d to the proto stream: %v", entry.Number, err)
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadProtoEntry reads the next length-delimited StreamEntry message of a proto stream (io.EOF at its end)
+func ReadProtoEntry(r *bufio.Reader) (ProtoEntry, error) {
+	size, err := binary.ReadUvarint(r)
+	if errors.Is(err, io.EOF) {
+		return ProtoEntry{}, io.EOF
+	}
+	if err != nil {
+		log.Errorf("Error reading proto stream message size: %v", err)
+		return ProtoEntry{}, ErrDecodingProtoEntry
+	}
+	if size > maxProtoMessageSize {
+		log.Errorf("Proto stream message size %d exceeds the maximum %d", size, maxProtoMessageSize)
+		return ProtoEntry{}, ErrDecodingProtoEntry
+	}
+	msg := make([]byte, size)
+	_, err = io.ReadFull(r, msg)
+	if err != nil {
+		log.Errorf("Error reading proto stream message: %v", err)
+		return ProtoEntry{}, ErrDecodingProtoEntry
+	}
+	return decodeProtoEntry(msg)
+}
+
+// ImportProtoStream reconstructs a stream file (and its bookmarks DB) from a proto stream written by
+// ExportProtoStream, keeping the entry numbers and tombstones. The entries must be contiguous, the first one sets the
+// first entry of the file. The data is stored as read (no write transform) and the timestamps are not imported. The
+// output must not exist, and is removed if the import fails.
+func ImportProtoStream(r io.Reader, fileName string, version uint8, systemID uint64, streamType StreamType) error {
+	out, err := NewStreamFileWithOptions(fileName, version, systemID, streamType, StreamFileOptions{CreateOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if out.fileHeader != nil {
+			_ = out.fileHeader.Close()
+		}
+	}()
+
+	// Bookmarks DB directory created here so an existing one is not reused
+	bookmarkName := bookmarkDBName(fileName)
+	err = os.Mkdir(bookmarkName, os.ModePerm)
+	if errors.Is(err, os.ErrExist) {
+		log.Errorf("Bookmarks DB %s already exists", bookmarkName)
+		err = ErrOutputFileExists
+	}
+	if err != nil {
+		out.closeFiles()
+		_ = os.Remove(fileName)
+		return err
+	}
+	bookmark, err := NewBookmark(bookmarkName)
+	if err != nil {
+		out.closeFiles()
+		removeReprocessed(fileName, bookmarkName)
+		return err
+	}
+
+	err = importProtoEntries(bufio.NewReader(r), out, bookmark)
+
+	// Closing the stream file writes the header
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.closeFiles()
+	}
+	err = errors.Join(err, bookmark.Close())
+	if err != nil {
+		log.Errorf("Error importing proto stream into %s: %v", fileName, err)
+		removeReprocessed(fileName, bookmarkName)
+		return err
+	}
+
+	log.Infof("Proto stream imported into %s, %d entries", fileName, out.header.TotalEntries-out.header.firstEntry)
+	return nil
+}
+
+// importProtoEntries adds the entries of a proto stream to a new stream file and its bookmarks DB
+func importProtoEntries(r *bufio.Reader, out *StreamFile, bookmark *StreamBookmark) error {
+	first := true
+	for {
+		pe, err := ReadProtoEntry(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := pe.FileEntry
+
+		// The first entry sets the first entry number of the file
+		if first {
+			out.mutexHeader.Lock()
+			out.header.firstEntry = e.Number
+			out.header.TotalEntries = e.Number
+			out.mutexHeader.Unlock()
+			first = false
+		}
+		if e.Number != out.header.TotalEntries {
+			log.Errorf("Proto stream entry %d, expected %d", e.Number, out.header.TotalEntries)
+			return ErrInvalidEntryNumber
+		}
+
+		if e.Type == EtBookmark && !e.Tombstoned {
+			err = bookmark.AddBookmark(e.Data, e.Number)
+			if err != nil {
+				return err
+			}
+		}
+		e.packetType = PtData
+		e.Length = FixedSizeFileEntry + uint32(len(e.Data))
+		err = out.AddFileEntry(e)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// encodeProtoEntry encodes an entry to a StreamEntry protobuf message
+func encodeProtoEntry(e ProtoEntry) []byte {
+	b := protowire.AppendTag(nil, protoFieldNumber, protowire.VarintType)
+	b = protowire.AppendVarint(b, e.Number)
+	b = protowire.AppendTag(b, protoFieldType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(e.Type))
+	if !e.Timestamp.IsZero() {
+		b = protowire.AppendTag(b, protoFieldTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.Timestamp.UnixNano()))
+	}
+	b = protowire.AppendTag(b, protoFieldData, protowire.BytesType)
+	b = protowire.AppendBytes(b, e.Data)
+	if e.Tombstoned {
+		b = protowire.AppendTag(b, protoFieldTombstoned, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(true))
+	}
+	return b
+}
+
+// decodeProtoEntry decodes an entry from a StreamEntry protobuf message, skipping unknown fields
+func decodeProtoEntry(b []byte) (ProtoEntry, error) {
+	e := ProtoEntry{FileEntry: FileEntry{Data: []byte{}}}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			log.Errorf("Error decoding proto entry tag: %v", protowire.ParseError(n))
+			return ProtoEntry{}, ErrDecodingProtoEntry
+		}
+		b = b[n:]
+
+		var v uint64
+		switch {
+		case num == protoFieldData && typ == protowire.BytesType:
+			var data []byte
+			data, n = protowire.ConsumeBytes(b)
+			e.Data = bytes.Clone(data)
+		case typ == protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			log.Errorf("Error decoding proto entry field %d: %v", num, protowire.ParseError(n))
+			return ProtoEntry{}, ErrDecodingProtoEntry
+		}
+		b = b[n:]
+
+		if typ != protowire.VarintType {
+			continue
+		}
+		switch num {
+		case protoFieldNumber:
+			e.Number = v
+		case protoFieldType:
+			e.Type = EntryType(v)
+		case protoFieldTimestamp:
+			e.Timestamp = time.Unix(0, int64(v))
+		case protoFieldTombstoned:
+			e.Tombstoned = protowire.DecodeBool(v)
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,102 @@
+package datastreamer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtoStreamRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	require.NoError(t, s.EnableCommitJournal())
+
+	// Entries over several pages, with bookmarks, a zero-length entry and a tombstone
+	require.NoError(t, s.StartAtomicOp())
+	for i := range 50 {
+		if i%10 == 0 {
+			_, err := s.AddStreamBookmark(binary.BigEndian.AppendUint64(nil, uint64(i)))
+			require.NoError(t, err)
+		}
+		_, err := s.AddStreamEntry(1, bytes.Repeat([]byte{byte(i)}, i*100)) //nolint:mnd
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.Tombstone(7)) //nolint:mnd
+	total := s.GetHeader().TotalEntries
+
+	// Each message carries the entry with its commit timestamp
+	var buf bytes.Buffer
+	require.NoError(t, s.ExportProtoStream(&buf, 0, total))
+	r := bufio.NewReader(bytes.NewReader(buf.Bytes()))
+	for num := range total {
+		pe, err := ReadProtoEntry(r)
+		require.NoError(t, err)
+		assert.Equal(t, num, pe.Number)
+		assert.Equal(t, num == 7, pe.Tombstoned) //nolint:mnd
+		ts, err := s.GetEntryTimestamp(num)
+		require.NoError(t, err)
+		assert.True(t, ts.Equal(pe.Timestamp))
+	}
+	_, err := ReadProtoEntry(r)
+	require.ErrorIs(t, err, io.EOF)
+
+	// The imported file has the same entries and bookmarks
+	dstFile := filepath.Join(dir, "imported.bin")
+	require.NoError(t, ImportProtoStream(bytes.NewReader(buf.Bytes()), dstFile, 1, 12345, 1)) //nolint:mnd
+	src, err := os.ReadFile(s.fileName)
+	require.NoError(t, err)
+	dst, err := os.ReadFile(dstFile)
+	require.NoError(t, err)
+	length := s.GetHeader().TotalLength
+	assert.True(t, bytes.Equal(src[PageHeaderSize:length], dst[PageHeaderSize:length]), "imported entries differ")
+	bm, err := NewBookmark(bookmarkDBName(dstFile))
+	require.NoError(t, err)
+	num, err := bm.GetBookmark(binary.BigEndian.AppendUint64(nil, 30)) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, uint64(33), num) //nolint:mnd
+	require.NoError(t, bm.Close())
+
+	// A partial range starts the imported file at its first entry
+	buf.Reset()
+	require.NoError(t, s.ExportProtoStream(&buf, 20, 30)) //nolint:mnd
+	dstFile = filepath.Join(dir, "partial.bin")
+	require.NoError(t, ImportProtoStream(&buf, dstFile, 1, 12345, 1)) //nolint:mnd
+	header, err := ReadHeader(dstFile)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), header.firstEntry)   //nolint:mnd
+	assert.Equal(t, uint64(30), header.TotalEntries) //nolint:mnd
+
+	// A gap in the entry numbers fails and the output is removed
+	buf.Reset()
+	require.NoError(t, s.ExportProtoStream(&buf, 0, 2))
+	require.NoError(t, s.ExportProtoStream(&buf, 3, 4)) //nolint:mnd
+	dstFile = filepath.Join(dir, "gap.bin")
+	require.ErrorIs(t, ImportProtoStream(&buf, dstFile, 1, 12345, 1), ErrInvalidEntryNumber) //nolint:mnd
+	_, err = os.Stat(dstFile)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	require.ErrorIs(t, s.ExportProtoStream(&buf, 0, total+1), ErrInvalidEntryNumber)
+}
+
+func TestDecodeProtoEntry(t *testing.T) {
+	e := ProtoEntry{FileEntry: FileEntry{Number: 300, Type: EtBookmark, Data: []byte{1, 2, 3}}} //nolint:mnd
+	b := encodeProtoEntry(e)
+
+	// Unknown fields are skipped
+	b = append(b, 0x32, 2, 9, 9) //nolint:mnd
+	decoded, err := decodeProtoEntry(b)
+	require.NoError(t, err)
+	assert.Equal(t, e, decoded)
+
+	_, err = decodeProtoEntry([]byte{0x22, 5, 1}) //nolint:mnd
+	require.ErrorIs(t, err, ErrDecodingProtoEntry)
+}
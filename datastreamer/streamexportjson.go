@@ -0,0 +1,106 @@
+package datastreamer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// JSONEntry type for an entry of a newline-delimited JSON export, the data base64 encoded or decoded with the
+// protobuf message registered for its entry type
+type JSONEntry struct {
+	Number     uint64          `json:"number"`
+	Type       EntryType       `json:"type"`
+	Data       []byte          `json:"data,omitempty"`
+	Decoded    json.RawMessage `json:"decoded,omitempty"`
+	Tombstoned bool            `json:"tombstoned,omitempty"`
+}
+
+// JSONExportOptions type for the settings of the JSON export
+type JSONExportOptions struct {
+	DecodeEntries bool // Decode the data of the entry types registered with RegisterEntryType instead of base64
+}
+
+// ExportJSON writes the entries (bookmarks and tombstoned entries included) from an entry number until another one
+// (excluding) to a writer as newline-delimited JSON, one JSONEntry object per line with the data base64 encoded,
+// streaming them as they are read from the file
+func (f *StreamFile) ExportJSON(w io.Writer, from, to uint64) error {
+	return f.ExportJSONWithOptions(w, from, to, JSONExportOptions{})
+}
+
+// ExportJSONWithOptions writes the entries as ExportJSON with options. Decoding the entries, the data of the entry
+// types registered is written as the JSON of their protobuf message (protojson) in the decoded field, the data of
+// the entry types not registered stays base64 encoded.
+func (f *StreamFile) ExportJSONWithOptions(w io.Writer, from, to uint64, opts JSONExportOptions) error {
+	header := f.getHeaderEntry()
+	if from > to || from < header.firstEntry || to > header.TotalEntries {
+		log.Errorf("Invalid entry range [%d, %d) to export, entries [%d, %d)", from, to, header.firstEntry,
+			header.TotalEntries)
+		return ErrInvalidEntryNumber
+	}
+	if from == to {
+		return nil
+	}
+
+	iterator, err := f.iteratorFrom(from, true)
+	if err != nil {
+		return err
+	}
+	defer f.iteratorEnd(iterator)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for entryNum := from; entryNum < to; entryNum++ {
+		end, err := f.iteratorNext(iterator)
+		if err != nil {
+			return err
+		}
+		if end {
+			log.Errorf("Entry %d to export not found", entryNum)
+			return ErrInvalidEntryNumber
+		}
+
+		entry, err := newJSONEntry(iterator.Entry, opts)
+		if err != nil {
+			return err
+		}
+		err = enc.Encode(entry)
+		if err != nil {
+			log.Errorf("Error writing entry %d to the JSON export: %v", entry.Number, err)
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// newJSONEntry returns the JSON export entry of a data entry
+func newJSONEntry(e FileEntry, opts JSONExportOptions) (JSONEntry, error) {
+	entry := JSONEntry{
+		Number:     e.Number,
+		Type:       e.Type,
+		Data:       e.Data,
+		Tombstoned: e.Tombstoned,
+	}
+	if !opts.DecodeEntries || !isEntryTypeRegistered(e.Type) {
+		return entry, nil
+	}
+
+	m, err := DecodeEntry(e)
+	if errors.Is(err, ErrEntryTypeNotRegistered) {
+		return entry, nil
+	}
+	if err != nil {
+		return entry, err
+	}
+	entry.Decoded, err = protojson.Marshal(m)
+	if err != nil {
+		log.Errorf("Error encoding entry %d of type %d to JSON: %v", e.Number, e.Type, err)
+		return entry, err
+	}
+	entry.Data = nil
+	return entry, nil
+}
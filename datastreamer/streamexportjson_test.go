@@ -0,0 +1,96 @@
+package datastreamer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/gateway-fm/zkevm-data-streamer/datastream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// readJSONEntries reads the entries of a newline-delimited JSON export, one per line
+func readJSONEntries(t *testing.T, b []byte) []JSONEntry {
+	t.Helper()
+
+	var entries []JSONEntry
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		var entry JSONEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestExportJSON(t *testing.T) {
+	sf := setupTestFile(t, filepath.Join(t.TempDir(), "stream.bin"))
+	defer sf.Close()
+	for i := range 6 {
+		addTestEntry(t, sf, 10*i) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	require.NoError(t, sf.tombstoneEntry(2)) //nolint:mnd
+
+	var out bytes.Buffer
+	require.NoError(t, sf.ExportJSON(&out, 1, 5)) //nolint:mnd
+	entries := readJSONEntries(t, out.Bytes())
+	require.Len(t, entries, 4) //nolint:mnd
+	assert.Contains(t, out.String(), `{"number":1,"type":1,"data":"AQEBAQEBAQEBAQ=="}`+"\n")
+
+	// Same entries as in the file
+	for i, entry := range entries {
+		expected, err := readTestEntry(sf, uint64(i+1))
+		require.NoError(t, err)
+		assert.Equal(t, expected.Number, entry.Number)
+		assert.Equal(t, expected.Type, entry.Type)
+		assert.Equal(t, expected.Tombstoned, entry.Tombstoned)
+		assert.Equal(t, expected.Data, entry.Data)
+		assert.Nil(t, entry.Decoded)
+	}
+	assert.True(t, entries[1].Tombstoned)
+
+	// Empty and invalid ranges
+	out.Reset()
+	require.NoError(t, sf.ExportJSON(&out, 3, 3)) //nolint:mnd
+	assert.Zero(t, out.Len())
+	assert.ErrorIs(t, sf.ExportJSON(&out, 4, 3), ErrInvalidEntryNumber) //nolint:mnd
+	assert.ErrorIs(t, sf.ExportJSON(&out, 0, 7), ErrInvalidEntryNumber) //nolint:mnd
+}
+
+func TestExportJSONDecoded(t *testing.T) {
+	const etypeL2Block = EntryType(0x1005)
+	require.NoError(t, RegisterEntryType(etypeL2Block, func() proto.Message { return &datastream.L2Block{} }))
+	t.Cleanup(func() { unregisterEntryType(etypeL2Block) })
+
+	block := &datastream.L2Block{Number: 7, BatchNumber: 3, Hash: []byte{0xaa, 0xbb}} //nolint:mnd
+	data, err := proto.Marshal(block)
+	require.NoError(t, err)
+
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(etypeL2Block, data)
+	require.NoError(t, err)
+	_, err = s.AddStreamEntry(1, []byte{1, 2})
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+
+	var out bytes.Buffer
+	require.NoError(t, s.streamFile.ExportJSONWithOptions(&out, 0, 2, JSONExportOptions{DecodeEntries: true}))
+	entries := readJSONEntries(t, out.Bytes())
+	require.Len(t, entries, 2) //nolint:mnd
+
+	// Registered entry type decoded, the other one base64
+	assert.Nil(t, entries[0].Data)
+	decoded := &datastream.L2Block{}
+	require.NoError(t, protojson.Unmarshal(entries[0].Decoded, decoded))
+	assert.True(t, proto.Equal(block, decoded))
+	assert.Equal(t, []byte{1, 2}, entries[1].Data)
+	assert.Nil(t, entries[1].Decoded)
+}
@@ -0,0 +1,60 @@
+package datastreamer
+
+import (
+	"slices"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// SetFailoverServers sets other server addresses (IP:port) of the same stream to fail over to, before Start. After a
+// disconnection or a failed connection attempt the client connects to the next address in turn, starting with the one
+// of NewClient, and re-issues its latest command there (e.g. the streaming from the next entry to receive).
+func (c *StreamClient) SetFailoverServers(servers ...string) {
+	c.servers = nil
+	c.serverIndex.Store(0)
+	if len(servers) > 0 {
+		c.servers = append([]string{c.server}, slices.Clone(servers)...)
+	}
+}
+
+// ActiveServer returns the server address the client connects to
+func (c *StreamClient) ActiveServer() string {
+	if c.servers == nil {
+		return c.server
+	}
+	return c.servers[c.serverIndex.Load()]
+}
+
+// Failovers returns the number of switches to the next server address
+func (c *StreamClient) Failovers() uint64 {
+	return c.failovers.Load()
+}
+
+// failover switches to the next server address to connect, if there are several
+func (c *StreamClient) failover() {
+	if len(c.servers) <= 1 {
+		return
+	}
+	index := (int(c.serverIndex.Load()) + 1) % len(c.servers)
+	c.server = c.servers[index]
+	c.serverIndex.Store(int32(index))
+	c.failovers.Add(1)
+	log.Warnf("Failing over to server %s", c.server)
+}
+
+// SetFailoverUpstreams sets other upstream server addresses (IP:port) of the same stream to fail over to, before
+// Start. When the connection to the active upstream drops the relay connects to the next one in turn, resuming from
+// the next entry to relay, so the relay clients don't see gaps.
+func (r *StreamRelay) SetFailoverUpstreams(upstreams ...string) {
+	r.client.SetFailoverServers(upstreams...)
+}
+
+// ActiveUpstream returns the upstream server address the relay connects to
+func (r *StreamRelay) ActiveUpstream() string {
+	return r.client.ActiveServer()
+}
+
+// Failovers returns the number of switches to the next upstream server address
+func (r *StreamRelay) Failovers() uint64 {
+	return r.client.Failovers()
+}
@@ -0,0 +1,79 @@
+package datastreamer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commitUpstreamEntries commits the same entries to some upstream servers, with their number as data
+func commitUpstreamEntries(t *testing.T, n int, servers ...*StreamServer) {
+	t.Helper()
+
+	for _, s := range servers {
+		first := s.GetHeader().TotalEntries
+		require.NoError(t, s.StartAtomicOp())
+		for i := range uint64(n) {
+			_, err := s.AddStreamEntry(1, []byte{byte(first + i)})
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+	}
+}
+
+func TestRelayFailover(t *testing.T) {
+	upstream1 := newTestServer(t, t.TempDir())
+	upstream2 := newTestServer(t, t.TempDir())
+	commitUpstreamEntries(t, 3, upstream1, upstream2) //nolint:mnd
+
+	r, err := NewRelay(testServerAddr(upstream1), 0, 1, 12345, 1, filepath.Join(t.TempDir(), "relay.bin"),
+		time.Second, time.Minute, time.Minute, nil)
+	require.NoError(t, err)
+	r.SetFailoverUpstreams(testServerAddr(upstream2))
+	require.NoError(t, r.Start())
+	t.Cleanup(func() { _ = r.Stop() })
+	waitRelayed := func(entries uint64) {
+		t.Helper()
+		require.Eventually(t, func() bool { return r.server.GetHeader().TotalEntries == entries },
+			5*time.Second, 10*time.Millisecond) //nolint:mnd
+	}
+	waitRelayed(3) //nolint:mnd
+	assert.Equal(t, testServerAddr(upstream1), r.ActiveUpstream())
+	assert.Zero(t, r.Failovers())
+
+	// Downstream client of the relay
+	received := make(chan uint64, 10) //nolint:mnd
+	c, err := NewClient(testServerAddr(r.server), 1)
+	require.NoError(t, err)
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- e.Number
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.ExecCommandStart(0))
+
+	// First upstream killed, the relay resumes from the second one
+	require.NoError(t, upstream1.Close())
+	commitUpstreamEntries(t, 2, upstream2) //nolint:mnd
+	waitRelayed(5)                         //nolint:mnd
+	assert.Equal(t, testServerAddr(upstream2), r.ActiveUpstream())
+	assert.Equal(t, uint64(1), r.Failovers())
+	for num := range uint64(5) {
+		entry, err := r.server.GetEntry(num)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{byte(num)}, entry.Data)
+	}
+
+	// No gaps downstream
+	for num := range uint64(5) {
+		select {
+		case entryNum := <-received:
+			assert.Equal(t, num, entryNum)
+		case <-time.After(5 * time.Second): //nolint:mnd
+			t.Fatalf("entry %d not received", num)
+		}
+	}
+}
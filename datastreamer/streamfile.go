@@ -3,11 +3,14 @@ package datastreamer
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"math"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/gateway-fm/zkevm-data-streamer/log"
 )
@@ -20,21 +23,35 @@ const (
 	fileMode       = 0666        // Open file mode
 	magicNumSize   = 16          // Magic numbers size
 	headerSize     = 38          // Header data size
+	headerExtPos   = 64          // Position of the header extension in the header page
+	headerExtSize  = 49          // Header extension data size
 	PageHeaderSize = 4096        // PageHeaderSize is the size of header page (4 KB)
-	PageDataSize   = 1024 * 1024 // PageDataSize is the size of one data page (1 MB)
+	PageDataSize   = 1024 * 1024 // PageDataSize is the default size of one data page (1 MB)
+	MinPageSize    = 64 * 1024   // MinPageSize is the minimum size of one data page (64 KB)
 	initPages      = 100         // Initial number of data pages
 	nextPages      = 10          // Number of data pages to add when file is full
 
-	PtPadding = 0    // PtPadding is packet type for pad
-	PtHeader  = 1    // PtHeader is packet type just for the header page
-	PtData    = 2    // PtData is packet type for data entry
-	PtDataRsp = 0xfe // PtDataRsp is packet type for command response with data
-	PtResult  = 0xff // PtResult is packet type not stored/present in file (just for client command result)
+	PtPadding      = 0    // PtPadding is packet type for pad
+	PtHeader       = 1    // PtHeader is packet type just for the header page
+	PtData         = 2    // PtData is packet type for data entry
+	PtTraceContext = 0xf8 // PtTraceContext is packet type for the trace context of the entries sent (not stored in file)
+	PtWireCodec    = 0xf9 // PtWireCodec is packet type for the codec of the wire compression chosen (not stored in file)
+	PtHeartbeat    = 0xfa // PtHeartbeat is packet type for the heartbeats sent to the idle clients (not stored in file)
+	PtProjection   = 0xfb // PtProjection is packet type for the entries of a projection subscription (not stored in file)
+	PtSkip         = 0xfc // PtSkip is packet type for the skip markers of the filtered streaming (not stored in file)
+	PtCheckpoint   = 0xfd // PtCheckpoint is packet type for the download checkpoints (not stored in file)
+	PtDataRsp      = 0xfe // PtDataRsp is packet type for command response with data
+	PtResult       = 0xff // PtResult is packet type not stored/present in file (just for client command result)
 
-	EtBookmark = 0xb0 // EtBookmark is entry type for bookmarks
+	EtBookmark      = 0xb0 // EtBookmark is entry type for bookmarks
+	EtVersionChange = 0xb1 // EtVersionChange is entry type for the stream version change markers
+
+	tombstoneFlag = 0x80000000 // Flag in the stored entry type of the logically deleted entries
 
 	FixedSizeFileEntry   = 17 // FixedSizeFileEntry is the fixed size in bytes for a data file entry (1+4+4+8)
 	FixedSizeResultEntry = 9  // FixedSizeResultEntry is the fixed size in bytes for a result entry (1+4+4)
+	FixedSizeCheckpoint  = 25 // FixedSizeCheckpoint is the size in bytes for a download checkpoint (1+8+8+8)
+	FixedSizeSkipMarker  = 17 // FixedSizeSkipMarker is the size in bytes for a skip marker (1+8+8)
 )
 
 // HeaderEntry type for a header entry
@@ -45,9 +62,39 @@ type HeaderEntry struct {
 	SystemID     uint64     // System identifier (e.g. ChainID)
 	streamType   StreamType // 1:Sequencer
 	TotalLength  uint64     // Total bytes used in the file
-	TotalEntries uint64     // Total number of data entries (packet type PtData)
+	TotalEntries uint64     // Total number of data entries (packet type PtData), next entry number if firstEntry > 0
+
+	// Header extension stored in the header page after the header entry (not sent to clients)
+	firstEntry  uint64          // First entry number stored in the file (0 unless imported at a base number)
+	numbering   numberingMode   // Entry numbering mode of the file
+	metaCodec   MetadataCodecID // Codec of the metadata section
+	encryption  encryptionMode  // At-rest encryption of the entries data
+	nonceBase   [nonceBaseSize]byte
+	nonceLimit  uint64 // Nonce counters reserved (not to be reused)
+	keyCheck    [keyCheckSize]byte
+	pageSize    uint32          // Data page size (0 in old files: PageDataSize)
+	checksums   checksumMode    // Checksums of the data pages (none in old files)
+	tailCRC     uint32          // Checksum of the entries in the last data page (not complete yet)
+	compression CompressionMode // At-rest compression of the entries data (none in old files)
+}
+
+// PageSize returns the data page size of the stream file
+func (e HeaderEntry) PageSize() uint32 {
+	if e.pageSize == 0 {
+		return PageDataSize
+	}
+	return e.pageSize
 }
 
+// numberingMode type for the way entry numbers are assigned in a stream file
+type numberingMode uint8
+
+const (
+	numberingUnset  numberingMode = iota // No entries added yet with the current format
+	numberingAuto                        // Entry numbers assigned by the server
+	numberingCaller                      // Entry numbers provided by the caller (import mode)
+)
+
 // FileEntry type for a data file entry
 type FileEntry struct {
 	packetType uint8     // 2:Data entry, 0:Padding
@@ -55,6 +102,7 @@ type FileEntry struct {
 	Type       EntryType // 0xb0:Bookmark, 1:Event1, 2:Event2,...
 	Number     uint64    // Entry number (sequential starting with 0)
 	Data       []byte
+	Tombstoned bool // Logically deleted entry (flag stored in the highest bit of the entry type)
 }
 
 // Encode encodes the file entry to binary bytes
@@ -74,16 +122,61 @@ type StreamFile struct {
 	header      HeaderEntry // Current header in memory (atomic operation in progress)
 	writtenHead HeaderEntry // Current header written in the file
 	mutexHeader sync.Mutex  // Mutex for update header data
+
+	metadataCodec MetadataCodec   // Codec for the metadata section (recorded in the header)
+	createOnly    bool            // Fail if the file already exists
+	cipher        *entryCipher    // Encryption of the entries data (nil: plaintext)
+	readOnly      bool            // Opened just for reading, the header in memory isn't written
+	locking       FileLocking     // Strategy against concurrent writers
+	verifyReader  io.ReaderAt     // Reader of the entries read back by the write verification (nil: the file)
+	verified      pageChecksums   // Data pages with the checksum already verified
+	compression   CompressionMode // Compression of the entries data (recorded in the header)
+	atomicUpdates bool            // Entries updated or tombstoned recorded to restore them on rollback (atomic op started)
+	undo          []entryUndo     // Stored bytes of the entries updated or tombstoned in the atomic operation in progress
+	undoLog       *os.File        // Undo log of the entries updated persisted for a crash (nil: none updated)
+
+	mmap *mmapReader // File mapped in memory for the entry views (nil: not enabled)
+}
+
+// StreamFileOptions type for the stream file settings, recorded in the header when the file is created
+type StreamFileOptions struct {
+	MetadataCodec MetadataCodec   // Codec for the metadata section (nil: the one in the header, JSON for new files)
+	CreateOnly    bool            // Fail with ErrOutputFileExists if the file already exists
+	EncryptionKey KeyProvider     // Key to encrypt the entries data (nil: plaintext), required for encrypted files
+	Locking       FileLocking     // Strategy against concurrent writers (default: FileLockExclusive)
+	PageSize      uint32          // Data page size recorded in the header at creation (0: PageDataSize)
+	Compression   CompressionMode // Entries data compression recorded in the header at creation (default: none)
+	// Backend of the server's bookmarks (nil: the embedded database next to the file) and of their index by entry
+	// number (nil with a custom backend: built in memory on start)
+	BookmarkStore      BookmarkStore
+	BookmarkIndexStore BookmarkStore
+	// Map the file in memory so GetEntryView returns the entries data without copying it (copied on platforms
+	// without memory mapped files)
+	MmapReads bool
+	// How the server flushes the commits to disk and the window of the group or interval flushes, as with
+	// StreamServer.SetCommitSync (default: CommitSyncNone, left to the OS). Only CommitSyncEach keeps every commit
+	// on a crash of the OS or a power loss, at the cost of a flush per commit.
+	CommitSync       CommitSyncMode
+	CommitSyncWindow time.Duration
 }
 
 type iteratorFile struct {
 	fromEntry uint64
 	file      *os.File
 	Entry     FileEntry
+	length    uint32 // Length of the entry stored in the file (encrypted)
 }
 
-// NewStreamFile creates stream file struct and opens or creates the stream binary data file
+// NewStreamFile creates stream file struct and opens or creates the stream binary data file, locked exclusively for
+// writing until it's closed (see FileLockExclusive)
 func NewStreamFile(fn string, version uint8, systemID uint64, st StreamType) (*StreamFile, error) {
+	return NewStreamFileWithOptions(fn, version, systemID, st, StreamFileOptions{})
+}
+
+// NewStreamFileWithOptions creates stream file struct and opens or creates the stream binary data file with options.
+// Unless opts.Locking is FileLockNone the file is locked exclusively for writing until it's closed.
+func NewStreamFileWithOptions(fn string, version uint8, systemID uint64, st StreamType,
+	opts StreamFileOptions) (*StreamFile, error) {
 	sf := StreamFile{
 		fileName:   fn,
 		pageSize:   PageDataSize,
@@ -101,14 +194,41 @@ func NewStreamFile(fn string, version uint8, systemID uint64, st StreamType) (*S
 			TotalLength:  0,
 			TotalEntries: 0,
 		},
+		createOnly: opts.CreateOnly,
+		locking:    opts.Locking,
 	}
+	if opts.MetadataCodec != nil {
+		sf.header.metaCodec = opts.MetadataCodec.ID()
+	}
+	if opts.PageSize != 0 {
+		if opts.PageSize < MinPageSize {
+			log.Errorf("Page size %d below the minimum %d", opts.PageSize, MinPageSize)
+			return nil, ErrInvalidPageSize
+		}
+		sf.pageSize = opts.PageSize
+	}
+	sf.header.pageSize = sf.pageSize
+	sf.header.checksums = checksumsCRC32C
+	sf.header.compression = opts.Compression
 
-	// Open (or create) the data stream file
+	// Open (or create) the data stream file, releasing the lock and the files opened if any check fails
 	err := sf.openCreateFile()
 	if err != nil {
+		sf.closeFiles()
+		return nil, err
+	}
+
+	// Settings recorded in the header must match the requested ones
+	err = sf.applyOptions(opts)
+	if err != nil {
+		sf.closeFiles()
 		return nil, err
 	}
 
+	if opts.MmapReads {
+		sf.enableMmapReads()
+	}
+
 	// Print file info
 	printStreamFile(&sf)
 
@@ -118,22 +238,42 @@ func NewStreamFile(fn string, version uint8, systemID uint64, st StreamType) (*S
 // openCreateFile opens or creates the stream file and performs multiple checks
 func (f *StreamFile) openCreateFile() error {
 	// Check if file exists (otherwise create it)
-	_, err := os.Stat(f.fileName)
+	err := os.ErrNotExist
+	if !f.createOnly {
+		_, err = os.Stat(f.fileName)
+	}
 
 	switch {
 	case os.IsNotExist(err):
-		// File does not exists so create it
+		// File does not exists so create it (atomically failing if it exists when create only)
 		log.Infof("Creating new file for datastream: %s", f.fileName)
-		f.file, err = os.Create(f.fileName)
+		flags := os.O_RDWR | os.O_CREATE
+		if f.createOnly {
+			flags = os.O_RDWR | os.O_CREATE | os.O_EXCL
+		}
+		f.file, err = os.OpenFile(f.fileName, flags, fileMode)
 
-		if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			log.Errorf("Datastream file %s already exists", f.fileName)
+			return ErrOutputFileExists
+		} else if err != nil {
 			log.Errorf("Error creating datastream file %s: %v", f.fileName, err)
 		} else {
+			// Locked before any write, it may have been created meanwhile by another writer: initialized only if
+			// it's still empty, otherwise it's used as an existing file
+			err = f.lock()
+			if err != nil {
+				return err
+			}
 			err = f.openFileForHeader()
 			if err != nil {
 				return err
 			}
-			err = f.initializeFile()
+			var info os.FileInfo
+			info, err = f.file.Stat()
+			if err == nil && info.Size() == 0 {
+				err = f.initializeFile()
+			}
 		}
 	case err == nil:
 		// File already exists
@@ -143,6 +283,10 @@ func (f *StreamFile) openCreateFile() error {
 			log.Errorf("Error opening datastream file %s: %v", f.fileName, err)
 			return err
 		}
+		err = f.lock()
+		if err != nil {
+			return err
+		}
 
 		err = f.openFileForHeader()
 	default:
@@ -166,18 +310,30 @@ func (f *StreamFile) openCreateFile() error {
 		return err
 	}
 
+	// Restore header from the file and check it
+	err = f.readHeaderEntry()
+	if err != nil {
+		return err
+	}
+	f.pageSize = f.header.PageSize()
+	f.compression = f.header.compression
+
 	// Check file consistency
 	err = f.checkFileConsistency()
 	if err != nil {
 		return err
 	}
+	err = f.checkHeaderConsistency()
+	if err != nil {
+		return err
+	}
 
-	// Restore header from the file and check it
-	err = f.readHeaderEntry()
+	// Restore the entries updated by an atomic operation and reconcile the header and the entries present after a crash
+	err = f.recoverUpdates()
 	if err != nil {
 		return err
 	}
-	err = f.checkHeaderConsistency()
+	err = f.recoverTail()
 	if err != nil {
 		return err
 	}
@@ -192,6 +348,68 @@ func (f *StreamFile) openCreateFile() error {
 	return nil
 }
 
+// applyOptions checks the options against the settings recorded in the header and applies them
+func (f *StreamFile) applyOptions(opts StreamFileOptions) error {
+	if opts.MetadataCodec != nil && opts.MetadataCodec.ID() != f.header.metaCodec {
+		log.Errorf("Metadata codec %d doesn't match codec %d in the file header", opts.MetadataCodec.ID(),
+			f.header.metaCodec)
+		return ErrMetadataCodecMismatch
+	}
+	if opts.PageSize != 0 && opts.PageSize != f.pageSize {
+		log.Errorf("Page size %d doesn't match page size %d in the file header", opts.PageSize, f.pageSize)
+		return ErrPageSizeMismatch
+	}
+	if opts.Compression != CompressionNone && opts.Compression != f.compression {
+		log.Errorf("Compression %d doesn't match compression %d in the file header", opts.Compression, f.compression)
+		return ErrCompressionMismatch
+	}
+
+	// The file can be opened with an unknown codec, only decoding its metadata fails
+	if opts.MetadataCodec != nil {
+		f.metadataCodec = opts.MetadataCodec
+	} else if codec, err := GetMetadataCodec(f.header.metaCodec); err == nil {
+		f.metadataCodec = codec
+	} else {
+		log.Warnf("Metadata codec %d of the file header not registered, metadata not available", f.header.metaCodec)
+	}
+
+	return f.setupEncryption(opts.EncryptionKey)
+}
+
+// MetadataCodec returns the codec of the metadata section recorded in the file header (nil: not registered)
+func (f *StreamFile) MetadataCodec() MetadataCodec {
+	return f.metadataCodec
+}
+
+// closeFiles closes the file descriptors without writing the header
+func (f *StreamFile) closeFiles() {
+	f.closeMmap()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	if f.fileHeader != nil {
+		f.fileHeader.Close()
+		f.fileHeader = nil
+	}
+	if f.undoLog != nil {
+		f.undoLog.Close()
+		f.undoLog = nil
+	}
+}
+
+// lock locks the open stream file for writing as configured, closing it if it's locked by another writer
+func (f *StreamFile) lock() error {
+	if f.locking == FileLockNone {
+		return nil
+	}
+	err := lockFile(f.file, f.fileName)
+	if err != nil {
+		f.closeFiles()
+	}
+	return err
+}
+
 // openFileForHeader opens stream file to perform header operations
 func (f *StreamFile) openFileForHeader() error {
 	// Get another file descriptor to use just for read/write the header
@@ -323,21 +541,22 @@ func (f *StreamFile) readHeaderEntry() error {
 		return err
 	}
 
-	// Read header stream bytes
-	binaryHeader := make([]byte, headerSize)
-	n, err := f.fileHeader.Read(binaryHeader)
+	// Read header stream bytes (header entry and extension)
+	binaryHeader := make([]byte, headerExtPos-magicNumSize+headerExtSize)
+	n, err := io.ReadFull(f.fileHeader, binaryHeader)
 	if err != nil {
 		log.Errorf("Error reading the header: %v", err)
 		return err
 	}
-	if n != headerSize {
+	if n != len(binaryHeader) {
 		log.Error("Error getting header info")
 		return ErrGettingHeaderInfo
 	}
 
 	// Convert to header struct
 	f.mutexHeader.Lock()
-	f.header, err = decodeBinaryToHeaderEntry(binaryHeader)
+	f.header, err = decodeBinaryToHeaderEntry(binaryHeader[:headerSize])
+	decodeBinaryToHeaderExt(binaryHeader[headerExtPos-magicNumSize:], &f.header)
 	f.writtenHead = f.header
 	f.mutexHeader.Unlock()
 	if err != nil {
@@ -348,6 +567,48 @@ func (f *StreamFile) readHeaderEntry() error {
 	return nil
 }
 
+// ReadHeader reads the header of a stream file opened read-only, without opening it for writing nor its bookmarks
+func ReadHeader(fileName string) (HeaderEntry, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		log.Errorf("Error opening file %s to read the header: %v", fileName, err)
+		return HeaderEntry{}, err
+	}
+	defer file.Close()
+
+	return readFileHeader(file)
+}
+
+// readFileHeader reads and checks the magic numbers and the header entry (with its extension) of a stream file
+func readFileHeader(r io.ReaderAt) (HeaderEntry, error) {
+	binaryHeader := make([]byte, headerExtPos+headerExtSize)
+	_, err := r.ReadAt(binaryHeader, 0)
+	if err != nil {
+		log.Errorf("Error reading the header: %v", err)
+		return HeaderEntry{}, err
+	}
+	if !bytes.Equal(binaryHeader[:magicNumSize], magicNumbers) {
+		log.Errorf("Invalid magic numbers. Bad file?")
+		return HeaderEntry{}, ErrBadFileFormat
+	}
+
+	header, err := decodeBinaryToHeaderEntry(binaryHeader[magicNumSize : magicNumSize+headerSize])
+	if err != nil {
+		return header, err
+	}
+	decodeBinaryToHeaderExt(binaryHeader[headerExtPos:], &header)
+
+	switch {
+	case header.packetType != PtHeader:
+		log.Error("Invalid header: bad packet type")
+		return header, ErrInvalidHeaderBadPacketType
+	case header.headLength != headerSize:
+		log.Error("Invalid header: bad header length")
+		return header, ErrInvalidHeaderBadHeaderLength
+	}
+	return header, nil
+}
+
 // rollbackHeader cancels current file written entries not committed
 func (f *StreamFile) rollbackHeader() error {
 	// Restore header
@@ -356,6 +617,12 @@ func (f *StreamFile) rollbackHeader() error {
 		return err
 	}
 
+	// Restore the entries updated
+	err = f.undoUpdates()
+	if err != nil {
+		return err
+	}
+
 	// Set file position to write
 	_, err = f.file.Seek(int64(f.header.TotalLength), io.SeekStart)
 	if err != nil {
@@ -368,9 +635,26 @@ func (f *StreamFile) rollbackHeader() error {
 
 // getHeaderEntry returns current committed header
 func (f *StreamFile) getHeaderEntry() HeaderEntry {
+	f.mutexHeader.Lock()
+	defer f.mutexHeader.Unlock()
 	return f.writtenHead
 }
 
+// setFirstEntry sets the first entry number of a file without entries
+func (f *StreamFile) setFirstEntry(entryNum uint64) {
+	f.mutexHeader.Lock()
+	f.header.firstEntry = entryNum
+	f.header.TotalEntries = entryNum
+	f.mutexHeader.Unlock()
+}
+
+// setNumbering sets the entry numbering mode of the file (written with the next header commit)
+func (f *StreamFile) setNumbering(mode numberingMode) {
+	f.mutexHeader.Lock()
+	f.header.numbering = mode
+	f.mutexHeader.Unlock()
+}
+
 // PrintHeaderEntry prints file header information
 func PrintHeaderEntry(e HeaderEntry, title string) {
 	log.Infof("--- HEADER ENTRY %s -------------------------", title)
@@ -382,13 +666,37 @@ func PrintHeaderEntry(e HeaderEntry, title string) {
 	log.Infof("totalLength: [%d]", e.TotalLength)
 	log.Infof("totalEntries: [%d]", e.TotalEntries)
 
-	numPage := (e.TotalLength - PageHeaderSize) / PageDataSize
-	offPage := (e.TotalLength - PageHeaderSize) % PageDataSize
+	numPage := (e.TotalLength - PageHeaderSize) / uint64(e.PageSize())
+	offPage := (e.TotalLength - PageHeaderSize) % uint64(e.PageSize())
 	log.Infof("DataPage num=[%d] off=[%d]", numPage, offPage)
 }
 
 // writeHeaderEntry writes the memory header struct into the file header
 func (f *StreamFile) writeHeaderEntry() error {
+	if f.readOnly {
+		log.Errorf("Error writing header of read-only file %s", f.fileName)
+		return ErrStreamFileReadOnly
+	}
+
+	err := f.commitUndoLog(f.header)
+	if err != nil {
+		return err
+	}
+	err = f.writeHeader(f.header)
+	if err != nil {
+		return err
+	}
+
+	// Update the written header, the entries updated are committed with it
+	f.mutexHeader.Lock()
+	f.writtenHead = f.header
+	f.mutexHeader.Unlock()
+	f.endAtomicUpdates()
+	return nil
+}
+
+// writeHeader writes a header struct into the file header
+func (f *StreamFile) writeHeader(header HeaderEntry) error {
 	// Position at the beginning of the file
 	_, err := f.fileHeader.Seek(magicNumSize, io.SeekStart)
 	if err != nil {
@@ -396,22 +704,28 @@ func (f *StreamFile) writeHeaderEntry() error {
 		return err
 	}
 
-	// Write after convert header struct to binary stream
-	binaryHeader := encodeHeaderEntryToBinary(f.header)
+	// Write after convert header struct (and its extension) to binary stream
+	binaryHeader := encodeHeaderEntryToBinary(header)
+	binaryHeader = append(binaryHeader, make([]byte, headerExtPos-magicNumSize-headerSize)...)
+	binaryHeader = append(binaryHeader, encodeHeaderExtToBinary(header)...)
 	log.Debugf("writing header entry: %v", binaryHeader)
 	_, err = f.fileHeader.Write(binaryHeader)
 	if err != nil {
 		log.Errorf("Error writing the header %v: %v", binaryHeader, err)
 		return err
 	}
-
-	// Update the written header
-	f.mutexHeader.Lock()
-	f.writtenHead = f.header
-	f.mutexHeader.Unlock()
 	return nil
 }
 
+// sync flushes the written data entries and header to disk
+func (f *StreamFile) sync() error {
+	err := f.file.Sync()
+	if err != nil {
+		log.Errorf("Error flushing stream file to disk: %v", err)
+	}
+	return err
+}
+
 // encodeHeaderEntryToBinary encodes from a header entry type to binary bytes slice
 func encodeHeaderEntryToBinary(e HeaderEntry) []byte {
 	be := make([]byte, 1)
@@ -445,12 +759,45 @@ func decodeBinaryToHeaderEntry(b []byte) (HeaderEntry, error) {
 	return e, nil
 }
 
+// encodeHeaderExtToBinary encodes the header extension fields to binary bytes slice
+func encodeHeaderExtToBinary(e HeaderEntry) []byte {
+	be := binary.BigEndian.AppendUint64(nil, e.firstEntry)
+	be = append(be, uint8(e.numbering), uint8(e.metaCodec), uint8(e.encryption))
+	be = append(be, e.nonceBase[:]...)
+	be = binary.BigEndian.AppendUint64(be, e.nonceLimit)
+	be = append(be, e.keyCheck[:]...)
+	be = binary.BigEndian.AppendUint32(be, e.pageSize)
+	be = append(be, uint8(e.checksums))
+	be = binary.BigEndian.AppendUint32(be, e.tailCRC)
+	be = append(be, uint8(e.compression))
+	return be
+}
+
+// decodeBinaryToHeaderExt decodes the header extension fields from binary bytes slice (zeros for old files)
+func decodeBinaryToHeaderExt(b []byte, e *HeaderEntry) {
+	e.firstEntry = binary.BigEndian.Uint64(b[0:8])
+	e.numbering = numberingMode(b[8])
+	e.metaCodec = MetadataCodecID(b[9])
+	e.encryption = encryptionMode(b[10])
+	copy(e.nonceBase[:], b[11:15])
+	e.nonceLimit = binary.BigEndian.Uint64(b[nonceLimitPos : nonceLimitPos+8])
+	copy(e.keyCheck[:], b[23:39])
+	e.pageSize = binary.BigEndian.Uint32(b[39:43])
+	e.checksums = checksumMode(b[43])
+	e.tailCRC = binary.BigEndian.Uint32(b[44:48])
+	e.compression = CompressionMode(b[48])
+}
+
 // encodeFileEntryToBinary encodes from a data file entry type to binary bytes
 func encodeFileEntryToBinary(e FileEntry) []byte {
 	be := make([]byte, 1)
 	be[0] = e.packetType
 	be = binary.BigEndian.AppendUint32(be, e.Length)
-	be = binary.BigEndian.AppendUint32(be, uint32(e.Type))
+	if e.Tombstoned {
+		be = binary.BigEndian.AppendUint32(be, uint32(e.Type)|tombstoneFlag)
+	} else {
+		be = binary.BigEndian.AppendUint32(be, uint32(e.Type))
+	}
 	be = binary.BigEndian.AppendUint64(be, e.Number)
 	be = append(be, e.Data...) //nolint:makezero
 	return be
@@ -528,58 +875,127 @@ func (f *StreamFile) checkHeaderConsistency() error {
 
 // AddFileEntry writes new data entry to the data stream file
 func (f *StreamFile) AddFileEntry(e FileEntry) error {
+	_, err := f.AddFileEntries([]FileEntry{e})
+	return err
+}
+
+// AddFileEntries writes new data entries to the data stream file as AddFileEntry, with a single write for the entries
+// of each data page. Returns the number of entries written, all of them unless an error.
+func (f *StreamFile) AddFileEntries(entries []FileEntry) (int, error) {
 	var err error
+	if f.readOnly {
+		log.Errorf("Error adding entry to read-only file %s", f.fileName)
+		return 0, ErrStreamFileReadOnly
+	}
 
-	// Convert from data struct to bytes stream
-	be := encodeFileEntryToBinary(e)
+	var (
+		buffer  []byte // Entries of the current page not written yet
+		pending int    // Number of entries in the buffer
+		written int    // Number of entries written
+	)
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
 
-	// Check if the entry fits on current page
-	var pageRemaining uint64
-	entryLength := uint64(len(be))
-	if (f.header.TotalLength-PageHeaderSize)%PageDataSize == 0 {
-		pageRemaining = 0
-	} else {
-		pageRemaining = PageDataSize - (f.header.TotalLength-PageHeaderSize)%PageDataSize
-	}
-	if entryLength > pageRemaining {
-		log.Debugf(">> Fill with pad entries. PageRemaining:%d, EntryLength:%d", pageRemaining, entryLength)
-		err = f.fillPagePadEntries()
+		// Write the data entries
+		_, err := f.file.Write(buffer)
 		if err != nil {
+			log.Errorf("Error writing the entry: %v", err)
 			return err
 		}
 
-		// Check if file is full
-		if f.header.TotalLength == f.maxLength {
-			// Add new data pages to the file
-			log.Infof(">> FULL FILE (TotalLength: %d) -> extending!", f.header.TotalLength)
-			err = f.extendFile()
-			if err != nil {
-				return err
+		// Update the current header in memory (on disk later when the commit arrives)
+		f.mutexHeader.Lock()
+		f.header.TotalLength += uint64(len(buffer))
+		f.header.TotalEntries += uint64(pending)
+		if f.header.checksums != checksumsNone {
+			f.header.tailCRC = crc32.Update(f.header.tailCRC, crcTable, buffer)
+		}
+		f.mutexHeader.Unlock()
+
+		written += pending
+		buffer = buffer[:0]
+		pending = 0
+		return nil
+	}
+
+	pageSize := uint64(f.pageSize)
+	pageCapacity := f.pageCapacity()
+	for _, e := range entries {
+		// Compress and encrypt the data
+		err = f.storeEntry(&e)
+		if err != nil {
+			if flushErr := flush(); flushErr != nil {
+				return written, flushErr
 			}
+			return written, err
+		}
 
-			log.Infof(">> New file max length: %d", f.maxLength)
+		// Convert from data struct to bytes stream
+		be := encodeFileEntryToBinary(e)
 
-			// Re-set the file position to write
-			_, err = f.file.Seek(int64(f.header.TotalLength), io.SeekStart)
+		// Check if the entry fits on current page
+		var pageRemaining uint64
+		entryLength := uint64(len(be))
+		if entryLength > pageCapacity {
+			log.Errorf("Entry length %d exceeds the data page capacity %d", entryLength, pageCapacity)
+			if flushErr := flush(); flushErr != nil {
+				return written, flushErr
+			}
+			return written, ErrEntryTooLarge
+		}
+		length := f.header.TotalLength + uint64(len(buffer))
+		if (length-PageHeaderSize)%pageSize == 0 {
+			pageRemaining = 0
+		} else {
+			pageRemaining = pageSize - (length-PageHeaderSize)%pageSize
+		}
+		if entryLength+pageSize-pageCapacity > pageRemaining {
+			err = flush()
 			if err != nil {
-				log.Errorf("Error seeking position to write after file extend: %v", err)
-				return err
+				return written, err
+			}
+			err = f.nextPage(pageRemaining, entryLength)
+			if err != nil {
+				return written, err
 			}
 		}
+
+		buffer = append(buffer, be...)
+		pending++
 	}
 
-	// Write the data entry
-	_, err = f.file.Write(be)
+	return written, flush()
+}
+
+// nextPage fills the current data page with pad to write an entry that doesn't fit in the next page, extending the
+// file when it's full
+func (f *StreamFile) nextPage(pageRemaining, entryLength uint64) error {
+	log.Debugf(">> Fill with pad entries. PageRemaining:%d, EntryLength:%d", pageRemaining, entryLength)
+	err := f.fillPagePadEntries()
 	if err != nil {
-		log.Errorf("Error writing the entry: %v", err)
 		return err
 	}
 
-	// Update the current header in memory (on disk later when the commit arrives)
-	f.mutexHeader.Lock()
-	f.header.TotalLength += entryLength
-	f.header.TotalEntries++
-	f.mutexHeader.Unlock()
+	// Check if file is full
+	if f.header.TotalLength == f.maxLength {
+		// Add new data pages to the file
+		log.Infof(">> FULL FILE (TotalLength: %d) -> extending!", f.header.TotalLength)
+		err = f.extendFile()
+		if err != nil {
+			return err
+		}
+
+		log.Infof(">> New file max length: %d", f.maxLength)
+
+		// Re-set the file position to write
+		_, err = f.file.Seek(int64(f.header.TotalLength), io.SeekStart)
+		if err != nil {
+			log.Errorf("Error seeking position to write after file extend: %v", err)
+			return err
+		}
+	}
 
 	return nil
 }
@@ -588,10 +1004,11 @@ func (f *StreamFile) AddFileEntry(e FileEntry) error {
 func (f *StreamFile) fillPagePadEntries() error {
 	// Page remaining free space
 	var pageRemaining uint64
-	if (f.header.TotalLength-PageHeaderSize)%PageDataSize == 0 {
+	pageSize := uint64(f.pageSize)
+	if (f.header.TotalLength-PageHeaderSize)%pageSize == 0 {
 		pageRemaining = 0
 	} else {
-		pageRemaining = PageDataSize - (f.header.TotalLength-PageHeaderSize)%PageDataSize
+		pageRemaining = pageSize - (f.header.TotalLength-PageHeaderSize)%pageSize
 	}
 
 	if pageRemaining > 0 {
@@ -602,6 +1019,14 @@ func (f *StreamFile) fillPagePadEntries() error {
 			return err
 		}
 
+		// Write the page footer with the checksum of its entries
+		if f.header.checksums != checksumsNone {
+			err = f.writePageFooter(pageSize - pageRemaining)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Set the file position to write
 		_, err = f.file.Seek(int64(pageRemaining-1), io.SeekCurrent)
 		if err != nil {
@@ -612,6 +1037,7 @@ func (f *StreamFile) fillPagePadEntries() error {
 		// Update the current header in memory (on disk later when the commit arrives)
 		f.mutexHeader.Lock()
 		f.header.TotalLength += pageRemaining
+		f.header.tailCRC = 0
 		f.mutexHeader.Unlock()
 	}
 
@@ -625,11 +1051,12 @@ func printStreamFile(f *StreamFile) {
 	log.Infof("pageSize: [%d]", f.pageSize)
 	log.Infof("streamType: [%d]", f.streamType)
 	log.Infof("maxLength: [%d]", f.maxLength)
-	log.Infof("numDataPages=[%d]", (f.maxLength-PageHeaderSize)/PageDataSize)
+	log.Infof("numDataPages=[%d]", (f.maxLength-PageHeaderSize)/uint64(f.pageSize))
 	PrintHeaderEntry(f.header, "")
 }
 
-// DecodeBinaryToFileEntry decodes from binary bytes slice to file entry type
+// DecodeBinaryToFileEntry decodes from binary bytes slice to file entry type, the data of a zero-length entry is an
+// empty (non-nil) slice
 func DecodeBinaryToFileEntry(b []byte) (FileEntry, error) {
 	d := FileEntry{}
 
@@ -641,6 +1068,10 @@ func DecodeBinaryToFileEntry(b []byte) (FileEntry, error) {
 	d.packetType = b[0]
 	d.Length = binary.BigEndian.Uint32(b[1:5])
 	d.Type = EntryType(binary.BigEndian.Uint32(b[5:9]))
+	if d.Type != EntryTypeNotFound && d.Type&tombstoneFlag != 0 {
+		d.Type &^= tombstoneFlag
+		d.Tombstoned = true
+	}
 	d.Number = binary.BigEndian.Uint64(b[9:17])
 	d.Data = b[17:]
 
@@ -655,7 +1086,8 @@ func DecodeBinaryToFileEntry(b []byte) (FileEntry, error) {
 // iteratorFrom initializes iterator to locate a data entry number in the stream file
 func (f *StreamFile) iteratorFrom(entryNum uint64, readOnly bool) (*iteratorFile, error) {
 	// Check starting entry number
-	if entryNum >= f.writtenHead.TotalEntries {
+	header := f.getHeaderEntry()
+	if entryNum >= header.TotalEntries || entryNum < header.firstEntry {
 		log.Error("Invalid starting entry number for iterator")
 		return nil, ErrInvalidEntryNumber
 	}
@@ -690,55 +1122,112 @@ func (f *StreamFile) iteratorFrom(entryNum uint64, readOnly bool) (*iteratorFile
 	return &iterator, err
 }
 
-// iteratorNext gets the next data entry in the file for the iterator, returns the end of entries condition
+// iteratorFromOffset initializes iterator to a data entry number using its file offset as a hint.
+// If the offset doesn't point to the entry (or the padding just before it) it falls back to iteratorFrom.
+func (f *StreamFile) iteratorFromOffset(entryNum uint64, offset uint64) (*iteratorFile, error) {
+	header := f.getHeaderEntry()
+	if offset < PageHeaderSize || offset+FixedSizeFileEntry > header.TotalLength || entryNum >= header.TotalEntries {
+		return f.iteratorFrom(entryNum, true)
+	}
+
+	file, err := os.OpenFile(f.fileName, os.O_RDONLY, os.ModePerm)
+	if err != nil {
+		log.Errorf("Error opening file for iterator: %v", err)
+		return nil, err
+	}
+
+	// Skip the padding until the next data page
+	pos := int64(offset)
+	pageSize := int64(f.pageSize)
+	buffer := make([]byte, FixedSizeFileEntry)
+	_, err = file.ReadAt(buffer[:1], pos)
+	if err == nil && buffer[0] == PtPadding && (pos-PageHeaderSize)%pageSize != 0 {
+		pos += pageSize - (pos-PageHeaderSize)%pageSize
+	}
+
+	// Check the entry is at the position
+	if err == nil && pos+FixedSizeFileEntry <= int64(header.TotalLength) {
+		_, err = file.ReadAt(buffer, pos)
+		if err == nil && buffer[0] == PtData && binary.BigEndian.Uint64(buffer[9:17]) == entryNum {
+			_, err = file.Seek(pos, io.SeekStart)
+			if err == nil {
+				return &iteratorFile{fromEntry: entryNum, file: file}, nil
+			}
+		}
+	}
+	file.Close()
+
+	log.Debugf("Offset %d is not the position of entry %d, searching it", offset, entryNum)
+	return f.iteratorFrom(entryNum, true)
+}
+
+// iteratorPos returns the current file position of the iterator (where the next entry is read from)
+func (f *StreamFile) iteratorPos(iterator *iteratorFile) (uint64, error) {
+	pos, err := iterator.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Errorf("Error seeking current pos for iterator: %v", err)
+		return 0, err
+	}
+	return uint64(pos), nil
+}
+
+// iteratorNext gets the next data entry in the file for the iterator, returns the end of entries condition.
+// Data beyond the committed header (being written or not fully flushed yet) is not available and returns end,
+// so the iterator can be called again once the writer commits more entries.
 func (f *StreamFile) iteratorNext(iterator *iteratorFile) (bool, error) {
+	header := f.getHeaderEntry()
+
 	// Check end of entries condition
-	if iterator.Entry.Number >= f.writtenHead.TotalEntries {
+	if iterator.Entry.Number >= header.TotalEntries {
+		return true, nil
+	}
+
+	// Current file position
+	pos, err := iterator.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		log.Errorf("Error seeking current pos for iterator: %v", err)
+		return true, err
+	}
+	if pos >= int64(header.TotalLength) {
 		return true, nil
 	}
 
 	// Read just the packet type
 	packet := make([]byte, 1)
-	_, err := iterator.file.Read(packet)
+	_, err = io.ReadFull(iterator.file, packet)
 	if err != nil {
 		log.Errorf("Error reading packet type for iterator: %v", err)
-		return true, err
+		return f.iteratorNotAvailable(iterator, pos, err)
 	}
 
 	// Check if it is of type pad, if so forward to next data page on the file
 	if packet[0] == PtPadding {
-		// Current file position
-		pos, err := iterator.file.Seek(0, io.SeekCurrent)
-		if err != nil {
-			log.Errorf("Error seeking current pos for iterator: %v", err)
-			return true, err
-		}
-
 		// Bytes to forward until next data page
 		var forward int64
-		if (pos-PageHeaderSize)%PageDataSize == 0 {
+		pageSize := int64(f.pageSize)
+		if (pos+1-PageHeaderSize)%pageSize == 0 {
 			forward = 0
 		} else {
-			forward = PageDataSize - ((pos - PageHeaderSize) % PageDataSize)
+			forward = pageSize - ((pos + 1 - PageHeaderSize) % pageSize)
 		}
 
 		// Check end of data pages condition
-		if pos+forward >= int64(f.writtenHead.TotalLength) {
-			return true, nil
+		if pos+1+forward >= int64(header.TotalLength) {
+			return f.iteratorNotAvailable(iterator, pos, nil)
 		}
 
 		// Seek for the start of next data page
-		_, err = iterator.file.Seek(forward, io.SeekCurrent)
+		pos, err = iterator.file.Seek(forward, io.SeekCurrent)
 		if err != nil {
 			log.Errorf("Error seeking next page for iterator: %v", err)
 			return true, err
 		}
 
 		// Read the new packet type
-		_, err = iterator.file.Read(packet)
+		_, err = io.ReadFull(iterator.file, packet)
 		if err != nil {
 			log.Errorf("Error reading new packet type for iterator: %v", err)
-			return true, err
+			return f.iteratorNotAvailable(iterator, pos, err)
 		}
 	}
 
@@ -750,10 +1239,10 @@ func (f *StreamFile) iteratorNext(iterator *iteratorFile) (bool, error) {
 
 	// Read the rest of fixed data entry bytes
 	buffer := make([]byte, FixedSizeFileEntry-1)
-	_, err = iterator.file.Read(buffer)
+	_, err = io.ReadFull(iterator.file, buffer)
 	if err != nil {
 		log.Errorf("Error reading entry for iterator: %v", err)
-		return true, err
+		return f.iteratorNotAvailable(iterator, pos, err)
 	}
 	buffer = append(packet, buffer...) //nolint:makezero
 
@@ -765,27 +1254,60 @@ func (f *StreamFile) iteratorNext(iterator *iteratorFile) (bool, error) {
 		return true, err
 	}
 
+	// Check the entry is fully committed
+	if pos+int64(length) > int64(header.TotalLength) {
+		return f.iteratorNotAvailable(iterator, pos, nil)
+	}
+
 	// Read variable data
 	if length > FixedSizeFileEntry {
 		bufferAux := make([]byte, length-FixedSizeFileEntry)
-		_, err = iterator.file.Read(bufferAux)
+		_, err = io.ReadFull(iterator.file, bufferAux)
 		if err != nil {
 			log.Errorf("Error reading data for iterator: %v", err)
-			return true, err
+			return f.iteratorNotAvailable(iterator, pos, err)
 		}
 		buffer = append(buffer, bufferAux...) //nolint:makezero
 	}
 
+	// Check the data page checksum
+	err = f.verifyPage(header, uint64(pos))
+	if err != nil {
+		return true, err
+	}
+
 	// Convert to data entry struct
 	iterator.Entry, err = DecodeBinaryToFileEntry(buffer)
 	if err != nil {
 		log.Errorf("Error decoding entry for iterator: %v", err)
 		return true, err
 	}
+	iterator.length = length
+
+	// Decrypt and decompress the data
+	err = f.loadEntry(&iterator.Entry)
+	if err != nil {
+		return true, err
+	}
 
 	return false, nil
 }
 
+// iteratorNotAvailable returns the end condition for an entry not fully available (short read or not committed)
+// leaving the iterator at the start of the entry to read it again later
+func (f *StreamFile) iteratorNotAvailable(iterator *iteratorFile, pos int64, readErr error) (bool, error) {
+	if readErr != nil && !errors.Is(readErr, io.EOF) && !errors.Is(readErr, io.ErrUnexpectedEOF) {
+		return true, readErr
+	}
+
+	_, err := iterator.file.Seek(pos, io.SeekStart)
+	if err != nil {
+		log.Errorf("Error seeking back entry position for iterator: %v", err)
+		return true, err
+	}
+	return true, nil
+}
+
 // iteratorEnd finalizes the file iterator
 func (f *StreamFile) iteratorEnd(iterator *iteratorFile) {
 	iterator.file.Close()
@@ -794,13 +1316,15 @@ func (f *StreamFile) iteratorEnd(iterator *iteratorFile) {
 // seekEntry uses a file iterator to locate a data entry number using a custom binary search
 func (f *StreamFile) seekEntry(iterator *iteratorFile) error {
 	// Start and end data pages
+	header := f.getHeaderEntry()
+	pageSize := uint64(f.pageSize)
 	var (
 		avg = 0
 		beg = 0
-		end = int((f.writtenHead.TotalLength - PageHeaderSize) / PageDataSize)
+		end = int((header.TotalLength - PageHeaderSize) / pageSize)
 	)
 
-	if (f.writtenHead.TotalLength-PageHeaderSize)%PageDataSize == 0 {
+	if (header.TotalLength-PageHeaderSize)%pageSize == 0 {
 		end--
 	}
 
@@ -809,7 +1333,7 @@ func (f *StreamFile) seekEntry(iterator *iteratorFile) error {
 		avg = beg + (end-beg)/2 //nolint:mnd
 
 		// Seek for the start of avg data page
-		newPos := (avg * PageDataSize) + PageHeaderSize
+		newPos := (uint64(avg) * pageSize) + PageHeaderSize
 		_, err := iterator.file.Seek(int64(newPos), io.SeekStart)
 		if err != nil {
 			log.Errorf("Error seeking page for iterator seek entry: %v", err)
@@ -888,20 +1412,22 @@ func (f *StreamFile) getFirstEntryOnNextPage(iterator *iteratorFile) (uint64, er
 	}
 
 	// Check if it is valid the current file position
-	if curpos < PageHeaderSize || curpos > int64(f.writtenHead.TotalLength) {
+	header := f.getHeaderEntry()
+	if curpos < PageHeaderSize || curpos > int64(header.TotalLength) {
 		log.Errorf("Error current file position outside a data page")
 		return 0, ErrCurrentPositionOutsideDataPage
 	}
 
 	// Check if exists another data page
 	var forward int64
-	if (curpos-PageHeaderSize)%PageDataSize == 0 {
+	pageSize := int64(f.pageSize)
+	if (curpos-PageHeaderSize)%pageSize == 0 {
 		forward = 0
 	} else {
-		forward = PageDataSize - (curpos-PageHeaderSize)%PageDataSize
+		forward = pageSize - (curpos-PageHeaderSize)%pageSize
 	}
 
-	if curpos+forward >= int64(f.writtenHead.TotalLength) {
+	if curpos+forward >= int64(header.TotalLength) {
 		return math.MaxUint64, nil
 	}
 
@@ -963,7 +1489,7 @@ func (f *StreamFile) locateEntry(iterator *iteratorFile) error {
 		// Found!
 		if iterator.Entry.Number == iterator.fromEntry {
 			// Seek backward to the end of fixed data
-			backward := iterator.Entry.Length - FixedSizeFileEntry
+			backward := iterator.length - FixedSizeFileEntry
 			_, err = iterator.file.Seek(-int64(backward), io.SeekCurrent)
 			if err != nil {
 				log.Errorf("Error in file seeking: %v", err)
@@ -975,10 +1501,111 @@ func (f *StreamFile) locateEntry(iterator *iteratorFile) error {
 	return nil
 }
 
+// RangeDataSize returns the total size of the data of the entries from an entry number until another one
+// (excluding), reading just the fixed part of the entries (and the original length of the compressed ones)
+func (f *StreamFile) RangeDataSize(from, to uint64) (uint64, error) {
+	header := f.getHeaderEntry()
+	if from > to || from < header.firstEntry || to > header.TotalEntries {
+		log.Errorf("Invalid entry range [%d, %d) for data size, entries [%d, %d)", from, to, header.firstEntry,
+			header.TotalEntries)
+		return 0, ErrInvalidEntryNumber
+	}
+	if from == to {
+		return 0, nil
+	}
+
+	// Locate the first entry
+	iterator, err := f.iteratorFrom(from, true)
+	if err != nil {
+		return 0, err
+	}
+	defer f.iteratorEnd(iterator)
+	pos, err := f.iteratorPos(iterator)
+	if err != nil {
+		return 0, err
+	}
+
+	// Sum the data length of the entries
+	var size uint64
+	pageSize := uint64(f.pageSize)
+	buffer := make([]byte, FixedSizeFileEntry)
+	for entryNum := from; entryNum < to; entryNum++ {
+		_, err = iterator.file.ReadAt(buffer[:1], int64(pos))
+		if err != nil {
+			log.Errorf("Error reading packet type for data size: %v", err)
+			return 0, err
+		}
+
+		// Forward to the next data page if it's a pad
+		if buffer[0] == PtPadding {
+			pos += pageSize - (pos-PageHeaderSize)%pageSize
+		}
+
+		// Read the fixed part of the entry
+		_, err = iterator.file.ReadAt(buffer, int64(pos))
+		if err != nil {
+			log.Errorf("Error reading entry for data size: %v", err)
+			return 0, err
+		}
+		if buffer[0] != PtData {
+			log.Errorf("Error expecting packet of type data(%d). Read: %d", PtData, buffer[0])
+			return 0, ErrExpectingPacketTypeData
+		}
+		length := binary.BigEndian.Uint32(buffer[1:5])
+		number := binary.BigEndian.Uint64(buffer[9:17])
+		if length < FixedSizeFileEntry || number != entryNum {
+			log.Errorf("Error decoding entry %d at position %d for data size", entryNum, pos)
+			return 0, ErrDecodingLengthDataEntry
+		}
+
+		var dataSize uint64
+		dataSize, err = f.entryDataSize(iterator.file, pos, buffer)
+		if err != nil {
+			return 0, err
+		}
+		size += dataSize
+		pos += uint64(length)
+	}
+
+	return size, nil
+}
+
+// entryDataSize returns the size of the data added of an entry from its fixed part read at a position of the file
+func (f *StreamFile) entryDataSize(r io.ReaderAt, pos uint64, fixed []byte) (uint64, error) {
+	length := binary.BigEndian.Uint32(fixed[1:5])
+	etype := EntryType(binary.BigEndian.Uint32(fixed[5:9]) &^ tombstoneFlag)
+	switch {
+	case isCompressed(f.compression, etype) && f.isEncrypted(etype):
+		// The original length is encrypted with the data
+		e := FileEntry{Type: etype, Number: binary.BigEndian.Uint64(fixed[9:17])}
+		e.Data = make([]byte, length-FixedSizeFileEntry)
+		_, err := r.ReadAt(e.Data, int64(pos+FixedSizeFileEntry))
+		if err != nil {
+			log.Errorf("Error reading entry data for data size: %v", err)
+			return 0, err
+		}
+		err = f.loadEntry(&e)
+		return uint64(len(e.Data)), err
+
+	case isCompressed(f.compression, etype):
+		prefix := make([]byte, compressionPrefixSize)
+		_, err := r.ReadAt(prefix, int64(pos+FixedSizeFileEntry))
+		if err != nil {
+			log.Errorf("Error reading entry original length for data size: %v", err)
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(prefix)), nil
+
+	case f.isEncrypted(etype):
+		return uint64(length - FixedSizeFileEntry - encryptionOverhead), nil
+	}
+	return uint64(length - FixedSizeFileEntry), nil
+}
+
 // updateEntryData updates the internal data of an entry in the file
 func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []byte) error {
 	// Check the entry number
-	if entryNum >= f.writtenHead.TotalEntries {
+	if entryNum >= f.getHeaderEntry().TotalEntries {
 		log.Infof("Invalid entry number [%d], not committed in the file", entryNum)
 		return ErrInvalidEntryNumberNotCommittedInFile
 	}
@@ -988,6 +1615,7 @@ func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []by
 	if err != nil {
 		return err
 	}
+	defer f.iteratorEnd(iterator)
 
 	// Get current entry data
 	_, err = f.iteratorNext(iterator)
@@ -1002,6 +1630,9 @@ func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []by
 	}
 
 	// Check entry type
+	if etype == etypeCurrent {
+		etype = iterator.Entry.Type
+	}
 	if iterator.Entry.Type != etype {
 		log.Infof("Updating entry to a different entry type not allowed. Current[%d] Update[%d]", iterator.Entry.Type, etype)
 		return ErrUpdateEntryTypeNotAllowed
@@ -1012,11 +1643,37 @@ func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []by
 	if dataLength != uint32(len(data)) {
 		log.Infof("Updating entry data to a different length not allowed. Current[%d] Update[%d]",
 			dataLength, uint32(len(data)))
-		return ErrUpdateEntryDifferentSize
+		return ErrEntryLengthMismatch
+	}
+
+	// Compress and encrypt the new data, it must be stored with the same length (compressed it may not)
+	e := FileEntry{Type: etype, Number: entryNum, Data: data, Length: FixedSizeFileEntry + uint32(len(data))}
+	err = f.storeEntry(&e)
+	if err != nil {
+		return err
+	}
+	if e.Length != iterator.length {
+		log.Infof("Updating entry data stored with a different length not allowed. Current[%d] Update[%d]",
+			iterator.length, e.Length)
+		return ErrEntryLengthMismatch
+	}
+	data = e.Data
+
+	// Keep the stored data to restore it on rollback
+	if f.atomicUpdates {
+		var end uint64
+		end, err = f.iteratorPos(iterator)
+		if err != nil {
+			return err
+		}
+		err = f.recordUndo(end-uint64(iterator.length)+FixedSizeFileEntry, end)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Back to the start of the data in the file
-	_, err = iterator.file.Seek(-int64(dataLength), io.SeekCurrent)
+	_, err = iterator.file.Seek(-int64(iterator.length-FixedSizeFileEntry), io.SeekCurrent)
 	if err != nil {
 		log.Errorf("Error file seeking for update entry data: %v", err)
 		return err
@@ -1029,6 +1686,16 @@ func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []by
 		return err
 	}
 
+	// Update the checksums of the data page
+	end, err := f.iteratorPos(iterator)
+	if err != nil {
+		return err
+	}
+	err = f.updatePageChecksum(end - uint64(iterator.length))
+	if err != nil {
+		return err
+	}
+
 	// Flush data to disk
 	err = iterator.file.Sync()
 	if err != nil {
@@ -1036,12 +1703,183 @@ func (f *StreamFile) updateEntryData(entryNum uint64, etype EntryType, data []by
 		return err
 	}
 
-	// Close iterator
-	f.iteratorEnd(iterator)
+	return nil
+}
+
+// tombstoneEntry flags an entry in the file as logically deleted
+func (f *StreamFile) tombstoneEntry(entryNum uint64) error {
+	// Check the entry number
+	if entryNum >= f.getHeaderEntry().TotalEntries {
+		log.Infof("Invalid entry number [%d], not committed in the file", entryNum)
+		return ErrInvalidEntryNumberNotCommittedInFile
+	}
+
+	// Create iterator and locate the entry in the file
+	iterator, err := f.iteratorFrom(entryNum, false)
+	if err != nil {
+		return err
+	}
+	defer f.iteratorEnd(iterator)
+
+	// Get current entry
+	_, err = f.iteratorNext(iterator)
+	if err != nil {
+		return err
+	}
+
+	// Sanity check
+	if iterator.Entry.Number != entryNum {
+		log.Errorf("Entry number to tombstone doesn't match. Current[%d] Tombstone[%d]", iterator.Entry.Number, entryNum)
+		return ErrEntryNumberMismatch
+	}
+	if iterator.Entry.Tombstoned {
+		return nil
+	}
+
+	// Keep the stored entry type to restore it on rollback
+	if f.atomicUpdates {
+		var end uint64
+		end, err = f.iteratorPos(iterator)
+		if err != nil {
+			return err
+		}
+		typePos := end - uint64(iterator.length) + 5 //nolint:mnd
+		err = f.recordUndo(typePos, typePos+4)       //nolint:mnd
+		if err != nil {
+			return err
+		}
+	}
+
+	// Back to the entry type in the file
+	_, err = iterator.file.Seek(-int64(iterator.length-5), io.SeekCurrent) //nolint:mnd
+	if err != nil {
+		log.Errorf("Error file seeking for tombstone entry: %v", err)
+		return err
+	}
+
+	// Write the flagged entry type
+	_, err = iterator.file.Write(binary.BigEndian.AppendUint32(nil, uint32(iterator.Entry.Type)|tombstoneFlag))
+	if err != nil {
+		log.Errorf("Error writing tombstone entry type: %v", err)
+		return err
+	}
+
+	// Update the checksums of the data page
+	pos, err := f.iteratorPos(iterator)
+	if err != nil {
+		return err
+	}
+	err = f.updatePageChecksum(pos - 9) //nolint:mnd
+	if err != nil {
+		return err
+	}
+
+	// Flush data to disk
+	err = iterator.file.Sync()
+	if err != nil {
+		log.Errorf("Error flushing tombstone entry to disk: %v", err)
+		return err
+	}
 
 	return nil
 }
 
+// TruncateFile removes the committed entries after an entry number (bookmark entries included), e.g. on a reorg.
+// It's not allowed with entries added not committed (ErrTruncateNotAllowed). The entries are removed by writing
+// the header with the new totals once the checksums of the last page are recomputed, so a crash keeps the file
+// either as it was or truncated. Nothing is done after the last entry. The bookmarks pointing to the entries removed
+// are deleted first from the default bookmarks DB next to the file, if any, failing without truncating if it can't be
+// opened (e.g. held by a running server). A custom BookmarkStore or the commit journal of a server are not pruned:
+// use StreamServer.TruncateFile with the server running.
+func (f *StreamFile) TruncateFile(afterEntry uint64) error {
+	header, err := f.truncateHeader()
+	if err != nil {
+		return err
+	}
+
+	// Check the entry number
+	if afterEntry < header.firstEntry || afterEntry >= header.TotalEntries {
+		log.Errorf("Invalid entry number [%d] to truncate after, entries [%d, %d)", afterEntry, header.firstEntry,
+			header.TotalEntries)
+		return ErrInvalidEntryNumber
+	}
+	if afterEntry+1 == header.TotalEntries {
+		return nil
+	}
+
+	return f.truncateFrom(afterEntry + 1)
+}
+
+// TruncateAll removes all the committed entries as TruncateFile, leaving just the header: the next entry added gets
+// the number of the first one removed. Nothing is done if there are no entries.
+func (f *StreamFile) TruncateAll() error {
+	header, err := f.truncateHeader()
+	if err != nil {
+		return err
+	}
+	if header.TotalEntries == header.firstEntry {
+		return nil
+	}
+
+	return f.truncateFrom(header.firstEntry)
+}
+
+// truncateHeader returns the committed header to truncate the file, failing if not allowed
+func (f *StreamFile) truncateHeader() (HeaderEntry, error) {
+	if f.readOnly {
+		log.Errorf("Error truncating read-only file %s", f.fileName)
+		return HeaderEntry{}, ErrStreamFileReadOnly
+	}
+
+	// Check atomic operation is not in progress
+	f.mutexHeader.Lock()
+	defer f.mutexHeader.Unlock()
+	if f.header.TotalEntries != f.writtenHead.TotalEntries || f.header.TotalLength != f.writtenHead.TotalLength {
+		log.Errorf("Truncate not allowed, atomic operation in progress")
+		return HeaderEntry{}, ErrTruncateNotAllowed
+	}
+	return f.writtenHead, nil
+}
+
+// truncateFrom removes the bookmarks of the committed entries from an entry number and then the entries, so the
+// bookmarks are not left pointing to removed entries once the truncated header is committed
+func (f *StreamFile) truncateFrom(entryNum uint64) error {
+	err := f.pruneFileBookmarks(entryNum)
+	if err != nil {
+		return err
+	}
+	err = f.truncateFile(entryNum)
+	if err != nil {
+		return err
+	}
+	return f.sync()
+}
+
+// pruneFileBookmarks deletes the bookmarks pointing to the entries from an entry number in the bookmarks DB next to
+// the file, if it exists
+func (f *StreamFile) pruneFileBookmarks(entryNum uint64) error {
+	dbName := bookmarkDBName(f.fileName)
+	_, err := os.Stat(dbName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Errorf("Unable to check bookmarks DB status %s: %v", dbName, err)
+		return err
+	}
+
+	bookmark, err := NewBookmark(dbName)
+	if err != nil {
+		return err
+	}
+	defer bookmark.Close()
+	count, err := bookmark.deleteFrom(entryNum, nil)
+	if err != nil {
+		return err
+	}
+	log.Infof("Removed %d bookmarks pointing to entries from %d", count, entryNum)
+	return nil
+}
+
 // truncateFile truncates file from an entry number onwards
 func (f *StreamFile) truncateFile(entryNum uint64) error {
 	// Create iterator and locate the entry in the file
@@ -1057,17 +1895,24 @@ func (f *StreamFile) truncateFile(entryNum uint64) error {
 		return err
 	}
 
+	// Checksum of the entries kept in the last data page
+	tailCRC, err := f.truncatePageChecksums(uint64(curpos))
+	if err != nil {
+		return err
+	}
+
 	// Update internal header
 	f.mutexHeader.Lock()
 	f.header.TotalEntries = entryNum
 	f.header.TotalLength = uint64(curpos)
+	f.header.tailCRC = tailCRC
 	f.writtenHead = f.header
 	f.mutexHeader.Unlock()
 
 	// Write the header into the file (commit changes)
 	err = f.writeHeaderEntry()
 	if err != nil {
-		return nil
+		return err
 	}
 
 	// Set new file position to write
@@ -1084,7 +1929,12 @@ func (f *StreamFile) Close() error {
 	if f.file == nil {
 		return nil
 	}
+	if f.readOnly {
+		f.closeFiles()
+		return nil
+	}
 
+	f.closeMmap()
 	writeErr := f.writeHeaderEntry()
 
 	var syncErr error
@@ -1098,6 +1948,9 @@ func (f *StreamFile) Close() error {
 		f.file = nil
 	}
 
+	// Header and undo log files
+	f.closeFiles()
+
 	if writeErr != nil || syncErr != nil || closeErr != nil {
 		return fmt.Errorf("write: %v, sync: %v, close: %v", writeErr, syncErr, closeErr)
 	}
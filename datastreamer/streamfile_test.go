@@ -1,10 +1,14 @@
 package datastreamer
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setupTestFile(t *testing.T, filename string) *StreamFile {
@@ -54,3 +58,299 @@ func TestWriteAndReadHeader(t *testing.T) {
 	assert.Equal(t, uint64(10), sf.header.TotalEntries)
 	assert.Equal(t, uint64(4096), sf.header.TotalLength)
 }
+
+// addTestEntry adds an entry whose data is filled with the entry number
+func addTestEntry(t *testing.T, sf *StreamFile, size int) {
+	t.Helper()
+
+	num := sf.header.TotalEntries
+	data := bytes.Repeat([]byte{byte(num)}, size)
+	err := sf.AddFileEntry(FileEntry{
+		packetType: PtData,
+		Length:     uint32(FixedSizeFileEntry + size),
+		Type:       1,
+		Number:     num,
+		Data:       data,
+	})
+	require.NoError(t, err)
+}
+
+func TestIteratorUncommittedEntries(t *testing.T) {
+	sf := setupTestFile(t, filepath.Join(t.TempDir(), "partial.bin"))
+
+	addTestEntry(t, sf, 100) //nolint:mnd
+	require.NoError(t, sf.writeHeaderEntry())
+
+	iterator, err := sf.iteratorFrom(0, true)
+	require.NoError(t, err)
+	defer sf.iteratorEnd(iterator)
+
+	end, err := sf.iteratorNext(iterator)
+	require.NoError(t, err)
+	require.False(t, end)
+	assert.Equal(t, uint64(0), iterator.Entry.Number)
+
+	// Entries written but not committed are not available yet (one of them fills a new page)
+	addTestEntry(t, sf, 100)              //nolint:mnd
+	addTestEntry(t, sf, PageDataSize-100) //nolint:mnd
+	for range 2 {
+		end, err = sf.iteratorNext(iterator)
+		require.NoError(t, err)
+		assert.True(t, end)
+	}
+
+	// Once committed the iterator continues from where it stopped
+	require.NoError(t, sf.writeHeaderEntry())
+	for num := uint64(1); num <= 2; num++ {
+		end, err = sf.iteratorNext(iterator)
+		require.NoError(t, err)
+		require.False(t, end)
+		assert.Equal(t, num, iterator.Entry.Number)
+		assert.Equal(t, byte(num), iterator.Entry.Data[0])
+	}
+	end, err = sf.iteratorNext(iterator)
+	require.NoError(t, err)
+	assert.True(t, end)
+}
+
+func TestIteratorFollowsGrowingFile(t *testing.T) {
+	const numEntries = 200
+
+	sf := setupTestFile(t, filepath.Join(t.TempDir(), "growing.bin"))
+	addTestEntry(t, sf, 10) //nolint:mnd
+	require.NoError(t, sf.writeHeaderEntry())
+
+	// Writer extending the file, committing every few entries
+	writerErr := make(chan error, 1)
+	go func() {
+		for i := 1; i < numEntries; i++ {
+			size := 1000 + (i%7)*50000 //nolint:mnd
+			num := sf.header.TotalEntries
+			err := sf.AddFileEntry(FileEntry{
+				packetType: PtData,
+				Length:     uint32(FixedSizeFileEntry + size),
+				Type:       1,
+				Number:     num,
+				Data:       bytes.Repeat([]byte{byte(num)}, size),
+			})
+			if err == nil && i%3 == 0 {
+				err = sf.writeHeaderEntry()
+			}
+			if err != nil {
+				writerErr <- err
+				return
+			}
+		}
+		writerErr <- sf.writeHeaderEntry()
+	}()
+
+	// Reader following the writer until all the entries are read
+	iterator, err := sf.iteratorFrom(0, true)
+	require.NoError(t, err)
+	defer sf.iteratorEnd(iterator)
+
+	var next uint64
+	for next < numEntries {
+		end, err := sf.iteratorNext(iterator)
+		require.NoError(t, err)
+		if end {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		require.Equal(t, next, iterator.Entry.Number)
+		require.Equal(t, bytes.Repeat([]byte{byte(next)}, len(iterator.Entry.Data)), iterator.Entry.Data)
+		next++
+	}
+	require.NoError(t, <-writerErr)
+}
+
+func TestRangeDataSize(t *testing.T) {
+	sf := setupTestFile(t, filepath.Join(t.TempDir(), "size.bin"))
+	defer sf.Close()
+	for i := range 60 {
+		addTestEntry(t, sf, (i%5)*40000) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+
+	// Sum of the data lengths reading the full entries
+	sumEntries := func(from, to uint64) uint64 {
+		var size uint64
+		for entry, err := range sf.Entries(from, to) {
+			require.NoError(t, err)
+			size += uint64(len(entry.Data))
+		}
+		return size
+	}
+
+	for _, r := range [][2]uint64{{0, 60}, {0, 1}, {4, 5}, {10, 45}, {25, 60}, {30, 30}} {
+		size, err := sf.RangeDataSize(r[0], r[1])
+		require.NoError(t, err)
+		assert.Equal(t, sumEntries(r[0], r[1]), size, "range %v", r)
+	}
+
+	_, err := sf.RangeDataSize(10, 5) //nolint:mnd
+	assert.ErrorIs(t, err, ErrInvalidEntryNumber)
+	_, err = sf.RangeDataSize(0, 61) //nolint:mnd
+	assert.ErrorIs(t, err, ErrInvalidEntryNumber)
+}
+
+func TestStreamFileTruncate(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "truncate.bin")
+	sf := setupTestFile(t, fileName)
+	empty := sf.getHeaderEntry()
+	for range 5 {
+		addTestEntry(t, sf, 1000) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	kept := sf.getHeaderEntry()
+	for range 5 {
+		addTestEntry(t, sf, 1000) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+
+	// Not allowed with entries not committed, nor after the last entry
+	addTestEntry(t, sf, 10)                                       //nolint:mnd
+	require.ErrorIs(t, sf.TruncateFile(4), ErrTruncateNotAllowed) //nolint:mnd
+	require.NoError(t, sf.rollbackHeader())
+	require.ErrorIs(t, sf.TruncateFile(10), ErrInvalidEntryNumber) //nolint:mnd
+
+	// After the last entry, nothing to remove
+	require.NoError(t, sf.TruncateFile(9))                        //nolint:mnd
+	assert.Equal(t, uint64(10), sf.getHeaderEntry().TotalEntries) //nolint:mnd
+
+	// To the middle of a data page, the next entries are added after the ones kept
+	require.NoError(t, sf.TruncateFile(4)) //nolint:mnd
+	header := sf.getHeaderEntry()
+	assert.Equal(t, kept.TotalEntries, header.TotalEntries)
+	assert.Equal(t, kept.TotalLength, header.TotalLength)
+	addTestEntry(t, sf, 2000) //nolint:mnd
+	require.NoError(t, sf.writeHeaderEntry())
+	require.NoError(t, sf.Close())
+
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	var sizes []int
+	for entry, err := range sf.Entries(0, 6) { //nolint:mnd
+		require.NoError(t, err)
+		sizes = append(sizes, len(entry.Data))
+	}
+	assert.Equal(t, []int{1000, 1000, 1000, 1000, 1000, 2000}, sizes)
+
+	// All the entries but the first one
+	require.NoError(t, sf.TruncateFile(0))
+	require.NoError(t, sf.Close())
+	sf, err = NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	header = sf.getHeaderEntry()
+	assert.Equal(t, uint64(1), header.TotalEntries)
+	assert.Equal(t, uint64(PageHeaderSize+FixedSizeFileEntry+1000), header.TotalLength) //nolint:mnd
+	require.NoError(t, sf.TruncateFile(0))
+	require.ErrorIs(t, sf.TruncateFile(1), ErrInvalidEntryNumber)
+
+	// All the entries, the next one added is the first one
+	require.NoError(t, sf.TruncateAll())
+	header = sf.getHeaderEntry()
+	assert.Equal(t, uint64(0), header.TotalEntries)
+	assert.Equal(t, empty.TotalLength, header.TotalLength)
+	require.NoError(t, sf.TruncateAll())
+	addTestEntry(t, sf, 10) //nolint:mnd
+	require.NoError(t, sf.writeHeaderEntry())
+	require.NoError(t, sf.Close())
+	sf, err = NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	entry, err := sf.GetEntry(0)
+	require.NoError(t, err)
+	assert.Len(t, entry.Data, 10) //nolint:mnd
+	assert.Equal(t, uint64(1), sf.getHeaderEntry().TotalEntries)
+	require.NoError(t, sf.Close())
+}
+
+func TestStreamFileTruncateBookmarks(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "stream.bin")
+
+	// Bookmarks a, b and c at entries 0, 2 and 4
+	s := newTestServer(t, dir)
+	require.NoError(t, s.StartAtomicOp())
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := s.AddStreamBookmark([]byte(key))
+		require.NoError(t, err)
+		_, err = s.AddStreamEntry(1, []byte(key))
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.Close())
+
+	// bookmarks checks the keys of the bookmarks in the DB next to the file
+	bookmarks := func(keys ...string) {
+		t.Helper()
+		b, err := NewBookmark(bookmarkDBName(fileName))
+		require.NoError(t, err)
+		defer b.Close()
+		var found []string
+		require.NoError(t, b.IterateBookmarks(func(key []byte, _ uint64) bool {
+			found = append(found, string(key))
+			return true
+		}))
+		assert.Equal(t, keys, found)
+	}
+
+	// Not truncated if the bookmarks DB can't be opened to prune it
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	held, err := NewBookmark(bookmarkDBName(fileName))
+	require.NoError(t, err)
+	require.Error(t, sf.TruncateFile(2))                         //nolint:mnd
+	assert.Equal(t, uint64(6), sf.getHeaderEntry().TotalEntries) //nolint:mnd
+	require.NoError(t, held.Close())
+
+	// The bookmarks of the entries removed are deleted, then all of them
+	require.NoError(t, sf.TruncateFile(2)) //nolint:mnd
+	bookmarks("a", "b")
+	require.NoError(t, sf.TruncateAll())
+	bookmarks()
+	require.NoError(t, sf.Close())
+
+	// A server truncating all the entries too
+	s = newTestServer(t, dir)
+	commitTestEntry(t, s)
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamBookmark([]byte("d"))
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.TruncateFile(0))
+	assert.Equal(t, uint64(0), s.GetHeader().TotalEntries)
+	_, err = s.GetBookmark([]byte("d"))
+	require.Error(t, err)
+	commitTestEntry(t, s)
+	entry, err := s.GetEntry(0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), entry.Number)
+}
+
+func TestReadHeader(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	for range 5 {
+		_, err := s.AddStreamEntry(1, []byte{1, 2, 3})
+		require.NoError(t, err)
+	}
+	_, err := s.AddStreamBookmark([]byte{0})
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+
+	// Read while the server has the file open
+	header, err := ReadHeader(s.fileName)
+	require.NoError(t, err)
+	assert.Equal(t, s.GetHeader(), header)
+	assert.Equal(t, uint64(6), header.TotalEntries) //nolint:mnd
+
+	// Not a stream file
+	badFile := filepath.Join(t.TempDir(), "bad.bin")
+	require.NoError(t, os.WriteFile(badFile, make([]byte, PageHeaderSize), 0600)) //nolint:mnd
+	_, err = ReadHeader(badFile)
+	assert.ErrorIs(t, err, ErrBadFileFormat)
+
+	_, err = ReadHeader(filepath.Join(t.TempDir(), "missing.bin"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
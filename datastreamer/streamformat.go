@@ -0,0 +1,119 @@
+package datastreamer
+
+import (
+	"errors"
+	"os"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// File format versions of the stream files: the layout of the header and the data pages (not the stream version)
+const (
+	FileFormatV1     = 1            // FileFormatV1 for the files without data page checksums
+	FileFormatV2     = 2            // FileFormatV2 for the files with data page checksums (CRC32C)
+	FileFormatLatest = FileFormatV2 // FileFormatLatest is the file format of the files created
+)
+
+// FileFormat returns the file format version of the stream file
+func (e HeaderEntry) FileFormat() uint8 {
+	if e.checksums == checksumsNone {
+		return FileFormatV1
+	}
+	return FileFormatV2
+}
+
+// MigrateStreamFile rewrites a stream file in a newer file format, keeping its entries, entry numbers, tombstones,
+// stream version and header settings, so the bookmarks remain valid. It's written into a temporary file that replaces
+// the original one once complete. A file already in the target format is left as is. Fails with ErrFileLocked if the
+// file is open for writing.
+func MigrateStreamFile(fileName string, targetVersion uint8) error {
+	if targetVersion < FileFormatV1 || targetVersion > FileFormatLatest {
+		log.Errorf("Unsupported target file format %d for file %s", targetVersion, fileName)
+		return ErrUnsupportedFileFormat
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		log.Errorf("Error opening file %s to migrate: %v", fileName, err)
+		return err
+	}
+	defer file.Close()
+
+	// The file is replaced, so no writer can be using it
+	err = lockFile(file, fileName)
+	if err != nil {
+		return err
+	}
+
+	header, err := readFileHeader(file)
+	if err != nil {
+		return err
+	}
+	format := header.FileFormat()
+	if format == targetVersion {
+		log.Infof("File %s already in file format %d", fileName, format)
+		return nil
+	}
+	if targetVersion < format {
+		log.Errorf("Invalid target file format %d for file %s with file format %d", targetVersion, fileName, format)
+		return ErrInvalidTargetVersion
+	}
+	if header.encryption != encryptionNone {
+		log.Errorf("File %s to migrate is encrypted", fileName)
+		return ErrFileEncrypted
+	}
+
+	// Write the entries into a new file
+	tmpName := fileName + ".migrating"
+	err = writeFormatMigratedFile(file, header, tmpName)
+	if err != nil {
+		log.Errorf("Error migrating file %s to file format %d: %v", fileName, targetVersion, err)
+		if err2 := os.Remove(tmpName); err2 != nil && !errors.Is(err2, os.ErrNotExist) {
+			log.Errorf("Error removing migration file %s: %v", tmpName, err2)
+		}
+		return err
+	}
+
+	// Replace the original file
+	err = os.Rename(tmpName, fileName)
+	if err != nil {
+		log.Errorf("Error replacing file %s with the migrated one: %v", fileName, err)
+		return err
+	}
+
+	log.Infof("File %s migrated from file format %d to file format %d", fileName, format, targetVersion)
+	return nil
+}
+
+// writeFormatMigratedFile creates a stream file in the latest file format and writes the entries of the source file
+func writeFormatMigratedFile(src *os.File, header HeaderEntry, fileName string) error {
+	out, err := createMigratedFile(header, fileName, header.Version)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if out.fileHeader != nil {
+			_ = out.fileHeader.Close()
+		}
+	}()
+
+	_, err = walkEntries(src, header.TotalLength, header.PageSize(), func(_ uint64, e FileEntry) error {
+		err := decompressEntry(header.compression, &e)
+		if err != nil {
+			return err
+		}
+		return out.AddFileEntry(e)
+	})
+	if err != nil {
+		out.closeFiles()
+		return err
+	}
+	if out.header.TotalEntries != header.TotalEntries {
+		out.closeFiles()
+		log.Errorf("Migrated %d entries but the header has %d", out.header.TotalEntries, header.TotalEntries)
+		return ErrBadFileFormat
+	}
+
+	// Closing the stream file writes the header
+	return out.Close()
+}
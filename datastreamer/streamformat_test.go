@@ -0,0 +1,73 @@
+package datastreamer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readFileEntries reads all the committed entries of a stream file, tombstoned included
+func readFileEntries(t *testing.T, fileName string) []FileEntry {
+	t.Helper()
+
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	defer sf.Close()
+	it, err := sf.GetIterator(0, IteratorOptions{IncludeTombstones: true})
+	require.NoError(t, err)
+	defer it.End()
+	return readAllEntries(t, it)
+}
+
+func TestMigrateStreamFile(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "stream.bin")
+
+	// Version 1 file (header without extension) with bookmarks and a tombstoned entry
+	s := newTestServer(t, dir)
+	addTestBookmarks(t, s, "b0")
+	for range 5 {
+		commitTestEntry(t, s)
+	}
+	addTestBookmarks(t, s, "b1")
+	require.NoError(t, s.Tombstone(3)) //nolint:mnd
+	require.NoError(t, s.Close())
+	patchFile(t, fileName, headerExtPos, make([]byte, headerExtSize))
+	header, err := ReadHeader(fileName)
+	require.NoError(t, err)
+	require.Equal(t, uint8(FileFormatV1), header.FileFormat())
+	expected := readFileEntries(t, fileName)
+	require.Len(t, expected, 7) //nolint:mnd
+
+	assert.ErrorIs(t, MigrateStreamFile(fileName, FileFormatLatest+1), ErrUnsupportedFileFormat)
+	require.NoError(t, MigrateStreamFile(fileName, FileFormatV2))
+
+	// Same entries, now in data pages with checksums
+	header, err = ReadHeader(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(FileFormatV2), header.FileFormat())
+	assert.Equal(t, uint8(1), header.Version)
+	assert.Equal(t, expected, readFileEntries(t, fileName))
+	_, err = os.Stat(fileName + ".migrating")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Already migrated, left as is
+	before, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.NoError(t, MigrateStreamFile(fileName, FileFormatV2))
+	after, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.ErrorIs(t, MigrateStreamFile(fileName, FileFormatV1), ErrInvalidTargetVersion)
+
+	// Bookmarks still pointing to their entries
+	s = newTestServer(t, dir)
+	for key, entryNum := range map[string]uint64{"b0": 0, "b1": 6} {
+		bookmarkNum, err := s.GetBookmark([]byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, entryNum, bookmarkNum)
+	}
+}
@@ -0,0 +1,204 @@
+package datastreamer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+const routeTimeout = 10 * time.Second // Max time to receive the first command of a server group connection
+
+// ServerGroup type to serve several streams (one server per stream type) through a single listener. Each connection
+// is routed to the server of the stream type of its first command, then it's managed by that server as usual.
+type ServerGroup struct {
+	port    uint16
+	ln      net.Listener
+	servers map[StreamType]*StreamServer
+	started bool
+	mutex   sync.RWMutex
+}
+
+// routedConn type for a connection routed by the server group, the first bytes read are the ones read to route it
+type routedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+// Read reads the bytes read to route the connection and then from the connection
+func (c *routedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// NewServerGroup creates a server group listening on a port
+func NewServerGroup(port uint16) *ServerGroup {
+	return &ServerGroup{
+		port:    port,
+		servers: make(map[StreamType]*StreamServer),
+	}
+}
+
+// NewServerGroupFromConfig creates a server group listening on the config port with a server for each stream of
+// the config, each one with its own stream file settings. Fails if a stream file can't be opened with its settings
+// (e.g. ErrPageSizeMismatch), closing the servers already created.
+func NewServerGroupFromConfig(cfg Config, version uint8, systemID uint64) (*ServerGroup, error) {
+	// Logger initialized just if configured
+	var logCfg *log.Config
+	if cfg.Log.Level != "" {
+		logCfg = &cfg.Log
+	}
+
+	g := NewServerGroup(cfg.Port)
+	for _, st := range cfg.Streams {
+		s, err := NewServerWithFileOptions(cfg.Port, version, systemID, st.StreamType, st.Filename,
+			cfg.WriteTimeout, cfg.InactivityTimeout, cfg.InactivityCheckInterval, logCfg,
+			StreamFileOptions{PageSize: st.PageSize})
+		if err == nil {
+			err = g.AddServer(s)
+			if err != nil {
+				_ = s.Close()
+			}
+		}
+		if err != nil {
+			log.Errorf("Error creating server of stream type %d: %v", st.StreamType, err)
+			_ = g.Close()
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// AddServer adds the server of a stream type to the group, it must be added before starting the group and the
+// server must not be started on its own (its port is not used)
+func (g *ServerGroup) AddServer(s *StreamServer) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.started || s.started {
+		log.Errorf("Server of stream type %d added to the group once started", s.streamType)
+		return ErrServerGroupStarted
+	}
+	if _, exists := g.servers[s.streamType]; exists {
+		log.Errorf("Stream type %d already in the server group", s.streamType)
+		return ErrStreamTypeInGroup
+	}
+	g.servers[s.streamType] = s
+	return nil
+}
+
+// GetServer returns the server of a stream type (nil: not in the group)
+func (g *ServerGroup) GetServer(st StreamType) *StreamServer {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.servers[st]
+}
+
+// Start starts the servers of the group and listens for the client connections of all of them
+func (g *ServerGroup) Start() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var err error
+	g.ln, err = net.Listen("tcp", ":"+strconv.Itoa(int(g.port)))
+	if err != nil {
+		log.Errorf("Error creating datastream server group %d: %v", g.port, err)
+		return err
+	}
+
+	for _, s := range g.servers {
+		s.startServing()
+	}
+
+	// Goroutine to wait for clients connections
+	log.Infof("Listening on port: %d for %d streams", g.port, len(g.servers))
+	go g.waitConnections(g.ln)
+
+	g.started = true
+	return nil
+}
+
+// waitConnections waits for new client connections and routes them to their servers
+func (g *ServerGroup) waitConnections(ln net.Listener) {
+	defer ln.Close()
+
+	const timeout = 2 * time.Second
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			// Exit loop if listener is closed
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Errorf("Error accepting new connection: %v", err)
+			time.Sleep(timeout)
+			continue
+		}
+
+		// Goroutine to route the client (waiting for its first command)
+		go g.routeConnection(conn)
+	}
+}
+
+// routeConnection reads the command and stream type of the first command and gives the connection to its server
+func (g *ServerGroup) routeConnection(conn net.Conn) {
+	clientID := conn.RemoteAddr().String()
+
+	// Read the command and stream type
+	head := make([]byte, 16) //nolint:mnd
+	err := conn.SetReadDeadline(time.Now().Add(routeTimeout))
+	if err == nil {
+		_, err = io.ReadFull(conn, head)
+	}
+	if err == nil {
+		err = conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		log.Warnf("Error reading first command from %s: %v", clientID, err)
+		conn.Close()
+		return
+	}
+
+	st := StreamType(binary.BigEndian.Uint64(head[8:16]))
+	s := g.GetServer(st)
+	if s == nil {
+		log.Errorf("Stream type %d not served: client %s killed", st, clientID)
+		conn.Close()
+		return
+	}
+
+	// Check the remote address is allowed by its server
+	if !s.isAddrAllowed(conn.RemoteAddr()) {
+		conn.Close()
+		return
+	}
+
+	log.Debugf("Client %s routed to stream type %d", clientID, st)
+	s.handleConnection(&routedConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(head), conn)})
+}
+
+// Close closes the listener and the servers of the group
+func (g *ServerGroup) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var errs []error
+	if g.ln != nil {
+		if err := g.ln.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		g.ln = nil
+	}
+	for _, s := range g.servers {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
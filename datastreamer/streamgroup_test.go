@@ -0,0 +1,140 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerGroup(t *testing.T) {
+	dir := t.TempDir()
+	g := NewServerGroup(0)
+	for st := StreamType(1); st <= 2; st++ {
+		s, err := NewServer(0, 1, 12345, st, filepath.Join(dir, fmt.Sprintf("stream%d.bin", st)), time.Second,
+			time.Minute, time.Minute, nil)
+		require.NoError(t, err)
+		require.NoError(t, g.AddServer(s))
+	}
+	dup, err := NewServer(0, 1, 12345, 1, filepath.Join(dir, "dup.bin"), time.Second, time.Minute, time.Minute, nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, g.AddServer(dup), ErrStreamTypeInGroup)
+	require.NoError(t, dup.Close())
+
+	require.NoError(t, g.Start())
+	defer g.Close()
+	assert.ErrorIs(t, g.AddServer(dup), ErrServerGroupStarted)
+
+	// Stream type 1 gets one entry and stream type 2 gets two
+	for st := StreamType(1); st <= 2; st++ {
+		s := g.GetServer(st)
+		require.NoError(t, s.StartAtomicOp())
+		for range st {
+			_, err := s.AddStreamEntry(EntryType(st), []byte{byte(st)})
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+	}
+
+	// Each client gets the stream of its stream type through the same listener
+	addr := g.ln.Addr().String()
+	for st := StreamType(1); st <= 2; st++ {
+		c, err := NewClient(addr, st)
+		require.NoError(t, err)
+		startClientUntilCleanup(t, c)
+		header, err := c.ExecCommandGetHeader()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(st), header.TotalEntries)
+		entry, err := c.ExecCommandGetEntry(0)
+		require.NoError(t, err)
+		assert.Equal(t, EntryType(st), entry.Type)
+		assert.Equal(t, []byte{byte(st)}, entry.Data)
+	}
+
+	// A stream type not served is disconnected
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	cmd := binary.BigEndian.AppendUint64(nil, uint64(CmdStart))
+	cmd = binary.BigEndian.AppendUint64(cmd, 3) //nolint:mnd
+	cmd = binary.BigEndian.AppendUint64(cmd, 0)
+	_, err = conn.Write(cmd)
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestServerGroupPageSizes(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		WriteTimeout:            time.Second,
+		InactivityTimeout:       time.Minute,
+		InactivityCheckInterval: time.Minute,
+		Streams: []StreamConfig{
+			{StreamType: 1, Filename: filepath.Join(dir, "markers.bin"), PageSize: MinPageSize},
+			{StreamType: 2, Filename: filepath.Join(dir, "blocks.bin")},
+		},
+	}
+	g, err := NewServerGroupFromConfig(cfg, 1, 12345) //nolint:mnd
+	require.NoError(t, err)
+	require.NoError(t, g.Start())
+
+	// Small entries over several small pages, and large entries that don't fit in them
+	sizes := map[StreamType]int{1: 1000, 2: 200 * 1024} //nolint:mnd
+	counts := map[StreamType]int{1: 300, 2: 8}          //nolint:mnd
+	for st, size := range sizes {
+		s := g.GetServer(st)
+		require.NoError(t, s.StartAtomicOp())
+		for i := range counts[st] {
+			data := make([]byte, size)
+			data[0], data[size-1] = byte(i), byte(st)
+			_, err = s.AddStreamEntry(EntryType(st), data)
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+	}
+	require.NoError(t, g.GetServer(1).StartAtomicOp())
+	_, err = g.GetServer(1).AddStreamEntry(1, make([]byte, 2*MinPageSize))
+	require.ErrorIs(t, err, ErrEntryTooLarge)
+	require.NoError(t, g.GetServer(1).RollbackAtomicOp())
+	assert.Equal(t, uint32(MinPageSize), g.GetServer(1).GetHeader().PageSize())
+	assert.Equal(t, uint32(PageDataSize), g.GetServer(2).GetHeader().PageSize())
+
+	// Each stream reads back through the group, locating the entries by their pages
+	addr := g.ln.Addr().String()
+	for st, size := range sizes {
+		c, err := NewClient(addr, st)
+		require.NoError(t, err)
+		startClientUntilCleanup(t, c)
+		for _, num := range []int{0, 1, counts[st] / 2, counts[st] - 1} {
+			entry, err := c.ExecCommandGetEntry(uint64(num))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(num), entry.Number)
+			require.Len(t, entry.Data, size)
+			assert.Equal(t, byte(num), entry.Data[0])
+			assert.Equal(t, byte(st), entry.Data[size-1])
+		}
+	}
+	require.NoError(t, g.Close())
+
+	// The page size is recorded in the header of each file
+	for _, st := range cfg.Streams {
+		report, err := NewConsistencyChecker(st.Filename).Check()
+		require.NoError(t, err)
+		assert.True(t, report.OK(), "stream type %d", st.StreamType)
+	}
+	_, err = NewStreamFileWithOptions(cfg.Streams[0].Filename, 1, 12345, 1, StreamFileOptions{PageSize: PageDataSize})
+	require.ErrorIs(t, err, ErrPageSizeMismatch)
+	g, err = NewServerGroupFromConfig(cfg, 1, 12345) //nolint:mnd
+	require.NoError(t, err)
+	defer g.Close()
+	entry, err := g.GetServer(1).GetEntry(uint64(counts[1] - 1))
+	require.NoError(t, err)
+	assert.Equal(t, byte(counts[1]-1), entry.Data[0])
+}
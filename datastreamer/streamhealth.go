@@ -0,0 +1,97 @@
+package datastreamer
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// Lifecycle states of the server reported by the readiness endpoint
+const (
+	healthStarting int32 = iota // Created but not started, not accepting connections yet
+	healthServing               // Accepting connections
+	healthDraining              // Shutting down, sending the pending entries to the clients
+	healthClosed                // Closed
+)
+
+const healthReadHeaderTimeout = 5 * time.Second // Max time to read the headers of a health request
+
+// StartHealthServer serves the HTTP liveness and readiness probes on an address (e.g. ":8080"), separate from the
+// stream port, until the server is closed:
+//   - /healthz: 200 while the process is alive
+//   - /readyz: 200 when the stream file is open, the server is accepting connections and the file header is valid,
+//     503 otherwise (starting, shutting down draining the clients, or closed)
+//
+// The stream file is opened and recovered by the constructor (NewServer), before the health server can be started,
+// so the probes don't cover the recovery: /readyz reports starting from then until Start.
+func (s *StreamServer) StartHealthServer(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorf("Error creating health server %s: %v", addr, err)
+		return err
+	}
+
+	file := s.streamFile
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeHealth(w, http.StatusOK, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if reason := s.notReady(file); reason != "" {
+			writeHealth(w, http.StatusServiceUnavailable, reason)
+			return
+		}
+		writeHealth(w, http.StatusOK, "ok")
+	})
+	s.health = &http.Server{Handler: mux, ReadHeaderTimeout: healthReadHeaderTimeout}
+	s.healthAddr = ln.Addr()
+
+	log.Infof("Health listening on: %s", s.healthAddr)
+	go func(health *http.Server) {
+		if err := health.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Error serving health requests: %v", err)
+		}
+	}(s.health)
+	return nil
+}
+
+// notReady returns why the server is not ready to serve clients, empty if it's ready
+func (s *StreamServer) notReady(file *StreamFile) string {
+	switch s.healthState.Load() {
+	case healthStarting:
+		return "starting"
+	case healthDraining:
+		return "draining"
+	case healthClosed:
+		return "closed"
+	}
+	if file == nil {
+		return "file not open"
+	}
+
+	header := file.getHeaderEntry()
+	if header.packetType != PtHeader || header.TotalLength < PageHeaderSize || header.TotalEntries < header.firstEntry {
+		return "invalid header"
+	}
+	return ""
+}
+
+// writeHealth writes the response of a health endpoint
+func writeHealth(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body + "\n"))
+}
+
+// closeHealthServer stops serving the health probes
+func (s *StreamServer) closeHealthServer() error {
+	if s.health == nil {
+		return nil
+	}
+	err := s.health.Close()
+	s.health = nil
+	return err
+}
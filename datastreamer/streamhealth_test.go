@@ -0,0 +1,98 @@
+package datastreamer
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getHealth requests a health endpoint, returns the status code and the body
+func getHealth(t *testing.T, s *StreamServer, path string) (int, string) {
+	t.Helper()
+
+	resp, err := http.Get("http://" + s.healthAddr.String() + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, strings.TrimSpace(string(body))
+}
+
+func TestHealthServer(t *testing.T) {
+	s, err := NewServer(0, 1, 12345, 1, filepath.Join(t.TempDir(), "stream.bin"), time.Second, time.Minute, time.Minute,
+		nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if s.streamFile != nil {
+			_ = s.Close()
+		}
+	})
+	require.NoError(t, s.StartHealthServer("127.0.0.1:0"))
+
+	// Stream file open, not accepting connections yet
+	status, _ := getHealth(t, s, "/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	status, body := getHealth(t, s, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "starting", body)
+
+	// Serving
+	require.NoError(t, s.Start())
+	commitTestEntry(t, s)
+	status, body = getHealth(t, s, "/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", body)
+
+	// Draining a client that doesn't close its connection on shutdown
+	conn, err := net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, uint32(0), sendStartCommand(t, conn, 0))
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond) //nolint:mnd
+		defer cancel()
+		shutdown <- s.Shutdown(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		status, body = getHealth(t, s, "/readyz")
+		return status == http.StatusServiceUnavailable && body == "draining"
+	}, time.Second, 10*time.Millisecond) //nolint:mnd
+	status, _ = getHealth(t, s, "/healthz")
+	assert.Equal(t, http.StatusOK, status)
+
+	// Closed, the health server is stopped with the server
+	addr := s.healthAddr.String()
+	require.ErrorIs(t, <-shutdown, context.DeadlineExceeded)
+	_, err = http.Get("http://" + addr + "/healthz")
+	require.Error(t, err)
+}
+
+func TestHealthServerReadiness(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	file := s.streamFile
+	assert.Empty(t, s.notReady(file))
+
+	// Invalid header or file not open
+	file.mutexHeader.Lock()
+	length := file.writtenHead.TotalLength
+	file.writtenHead.TotalLength = 0
+	file.mutexHeader.Unlock()
+	assert.Equal(t, "invalid header", s.notReady(file))
+	file.mutexHeader.Lock()
+	file.writtenHead.TotalLength = length
+	file.mutexHeader.Unlock()
+	assert.Equal(t, "file not open", s.notReady(nil))
+
+	// Closed
+	s.healthState.Store(healthClosed)
+	assert.Equal(t, "closed", s.notReady(file))
+}
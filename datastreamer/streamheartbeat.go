@@ -0,0 +1,119 @@
+package datastreamer
+
+import (
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// SetHeartbeatInterval sets the heartbeats detecting the dead clients (e.g. half-open connections), before Start:
+// a client with nothing read nor written for the interval gets a heartbeat packet (PtHeartbeat), and is disconnected
+// if it doesn't acknowledge it within the interval. Only the clients that negotiated the heartbeats on connection
+// (CmdHeartbeat) get them, the old clients are not affected. With 0 (default) no heartbeats are sent.
+func (s *StreamServer) SetHeartbeatInterval(d time.Duration) {
+	s.heartbeatInterval = d
+}
+
+// runHeartbeat sends the heartbeats to the idle clients and disconnects the ones not acknowledging them
+func (s *StreamServer) runHeartbeat() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.heartbeatInterval / 2) //nolint:mnd
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			var clientsToKill []string
+			s.mutexClients.RLock()
+			for _, cli := range s.clients {
+				if !cli.heartbeat.Load() {
+					continue
+				}
+				sent := cli.heartbeatSent.Load()
+				switch {
+				case sent != 0 && now.Sub(time.Unix(0, sent)) > s.heartbeatInterval:
+					clientsToKill = append(clientsToKill, cli.clientID)
+				case sent == 0 && now.Sub(cli.getLastActivity()) > s.heartbeatInterval:
+					cli.heartbeatSent.Store(now.UnixNano())
+					go s.sendHeartbeat(cli)
+				}
+			}
+			s.mutexClients.RUnlock()
+
+			for _, clientID := range clientsToKill {
+				log.Warnf("Killing client %s, heartbeat not acknowledged", clientID)
+				s.killClient(clientID)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sendHeartbeat sends a heartbeat packet to a client
+func (s *StreamServer) sendHeartbeat(client *client) {
+	err := s.sendPacket(client, []byte{PtHeartbeat})
+	if err != nil {
+		log.Warnf("Error sending heartbeat to %s: %v", client.clientID, err)
+	}
+}
+
+// processCmdHeartbeat processes the TCP Heartbeat command from the clients: the first one negotiates the
+// heartbeats, answered with a heartbeat packet (old servers answer it with an invalid command result), the next ones
+// acknowledge the heartbeats received, without answer
+func (s *StreamServer) processCmdHeartbeat(client *client) error {
+	if client.heartbeat.Swap(true) {
+		client.heartbeatSent.Store(0)
+		return nil
+	}
+
+	log.Debugf("Client %s command Heartbeat, negotiated", client.clientID)
+	return s.sendPacket(client, []byte{PtHeartbeat})
+}
+
+// negotiateHeartbeat negotiates the heartbeats with the server just after connecting, before any other command
+func (c *StreamClient) negotiateHeartbeat() error {
+	c.heartbeat = false
+	err := c.writeCommand(CmdHeartbeat, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	// The server answers with a heartbeat, or with an invalid command result if it doesn't support them
+	err = c.conn.SetReadDeadline(time.Now().Add(defaultTimeout))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.conn.SetReadDeadline(time.Time{}) }()
+	packet := make([]byte, 1)
+	err = c.readContent(packet)
+	if err != nil {
+		return err
+	}
+	switch packet[0] {
+	case PtHeartbeat:
+		c.heartbeat = true
+	case PtResult:
+		r, err := c.readResultEntry()
+		if err != nil {
+			return err
+		}
+		if r.errorNum == uint32(CmdErrMaxClients) {
+			log.Warnf("%s Rejected by server %s: %s", c.ID, c.server, r.errorStr)
+			return ErrMaxClientsReached
+		}
+		log.Infof("%s Heartbeats not supported by server %s: %s", c.ID, c.server, r.errorStr)
+	default:
+		log.Errorf("%s Unexpected packet type %d negotiating heartbeats", c.ID, packet[0])
+		return ErrInvalidCommand
+	}
+	return nil
+}
+
+// ackHeartbeat acknowledges a heartbeat received from the server
+func (c *StreamClient) ackHeartbeat() error {
+	log.Debugf("%s Heartbeat received", c.ID)
+	return c.writeCommand(CmdHeartbeat, 0, nil)
+}
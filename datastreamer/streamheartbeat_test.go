@@ -0,0 +1,132 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testHeartbeatInterval = 50 * time.Millisecond
+
+// sendHeartbeatCommand sends the heartbeat command from a raw connection
+func sendHeartbeatCommand(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	cmd := binary.BigEndian.AppendUint64(nil, uint64(CmdHeartbeat))
+	cmd = binary.BigEndian.AppendUint64(cmd, 1)
+	_, err := conn.Write(cmd)
+	require.NoError(t, err)
+}
+
+func TestHeartbeatNotAcknowledged(t *testing.T) {
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.SetHeartbeatInterval(testHeartbeatInterval)
+	})
+	conn, err := net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Negotiated, answered with a heartbeat
+	sendHeartbeatCommand(t, conn)
+	packet := make([]byte, 1)
+	_, err = io.ReadFull(conn, packet)
+	require.NoError(t, err)
+	assert.Equal(t, byte(PtHeartbeat), packet[0])
+
+	// Heartbeat once idle, acknowledged the first time
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = io.ReadFull(conn, packet)
+	require.NoError(t, err)
+	assert.Equal(t, byte(PtHeartbeat), packet[0])
+	sendHeartbeatCommand(t, conn)
+
+	// Not acknowledged, the client is disconnected
+	_, err = io.ReadFull(conn, packet)
+	require.NoError(t, err)
+	assert.Equal(t, byte(PtHeartbeat), packet[0])
+	_, err = io.ReadFull(conn, packet)
+	require.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 0, s.getSafeClientsLen())
+}
+
+func TestHeartbeatOldClient(t *testing.T) {
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.SetHeartbeatInterval(testHeartbeatInterval)
+	})
+	conn, err := net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, uint32(0), sendStartCommand(t, conn, 0))
+
+	// Without negotiation no heartbeats are sent, the client stays connected
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*testHeartbeatInterval))) //nolint:mnd
+	_, err = conn.Read(make([]byte, 1))
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded), "unexpected read result: %v", err)
+	assert.Equal(t, 1, s.getSafeClientsLen())
+}
+
+func TestHeartbeatClient(t *testing.T) {
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.SetHeartbeatInterval(testHeartbeatInterval)
+	})
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	received := make(chan uint64, 1)
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- e.Number
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	assert.True(t, c.heartbeat)
+	require.NoError(t, c.ExecCommandStart(0))
+
+	// Idle for several heartbeats, acknowledged by the client
+	time.Sleep(10 * testHeartbeatInterval) //nolint:mnd
+	assert.Equal(t, 1, s.getSafeClientsLen())
+	assert.Equal(t, ConnConnected, c.ConnectionState())
+	commitTestEntry(t, s)
+	select {
+	case num := <-received:
+		assert.Equal(t, uint64(0), num)
+	case <-time.After(time.Second):
+		t.Fatal("entry not received")
+	}
+}
+
+func TestHeartbeatOldServer(t *testing.T) {
+	// Server answering the heartbeat command as an unknown one
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err = io.ReadFull(conn, make([]byte, 16)); err != nil { //nolint:mnd
+			return
+		}
+		errorStr := []byte(StrCommandErrors[CmdErrInvalidCommand])
+		_, _ = conn.Write(encodeResultEntryToBinary(ResultEntry{
+			packetType: PtResult,
+			length:     FixedSizeResultEntry + uint32(len(errorStr)),
+			errorNum:   uint32(CmdErrInvalidCommand),
+			errorStr:   errorStr,
+		}))
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	c, err := NewClient(ln.Addr().String(), 1)
+	require.NoError(t, err)
+	startClientUntilCleanup(t, c)
+	assert.False(t, c.heartbeat)
+	assert.Equal(t, ConnConnected, c.ConnectionState())
+}
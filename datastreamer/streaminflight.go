@@ -0,0 +1,209 @@
+package datastreamer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// SlowClientPolicy type for the behavior when the bytes pending to be sent to a client reach MaxInFlightBytes
+type SlowClientPolicy uint8
+
+const (
+	SlowClientBlock SlowClientPolicy = iota // SlowClientBlock waits until the client receives the pending bytes
+	SlowClientDrop                          // SlowClientDrop disconnects the client
+)
+
+const (
+	minBatchSize     = 4 * 1024              // Min bytes written to a client at once by its writer
+	initialBatchSize = 64 * 1024             // Bytes written at once to a new client
+	maxBatchSize     = 4 * 1024 * 1024       // Max bytes written to a client at once by its writer
+	batchWriteTarget = 10 * time.Millisecond // Max time writing a batch to keep growing it
+)
+
+// sendQueue type to buffer the packets sent to a client, written to its connection by its own goroutine
+type sendQueue struct {
+	maxBytes    uint64
+	policy      SlowClientPolicy
+	maxPackets  int // Max packets pending to be sent by the broadcast (0: no limit)
+	packets     [][]byte
+	committed   []time.Time   // Commit time of each packet to measure its queue delay (zero: not measured)
+	bytes       uint64        // Bytes pending to be sent (including the packets being written)
+	batchSize   uint64        // Bytes written at once, adapted to the client speed
+	writeTarget time.Duration // Max time writing a batch to keep growing it
+	closed      bool
+	mutex       sync.Mutex
+	cond        *sync.Cond
+}
+
+// newSendQueue creates a send queue with a maximum of bytes in flight and of packets queued by the broadcast
+func newSendQueue(maxBytes uint64, policy SlowClientPolicy, maxPackets int) *sendQueue {
+	q := &sendQueue{
+		maxBytes:    maxBytes,
+		policy:      policy,
+		maxPackets:  maxPackets,
+		batchSize:   initialBatchSize,
+		writeTarget: batchWriteTarget,
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// push queues a packet to be sent applying the slow client policy. When the in-flight bytes would exceed the
+// maximum, it waits for them to be sent (SlowClientBlock) or fails with ErrSlowClient (SlowClientDrop). When the
+// queue is full of packets it fails with ErrSlowClient. A packet is always accepted by an empty queue. The commit
+// time of the entry is used to measure its queue delay.
+func (q *sendQueue) push(packet []byte, committed time.Time) error {
+	return q.enqueue(packet, committed, q.policy, SlowClientDrop)
+}
+
+// pushWait queues a packet to be sent, waiting for the in-flight bytes to be sent whatever the slow client policy
+// and the queue size (used for the command responses and the entries streamed by them)
+func (q *sendQueue) pushWait(packet []byte) error {
+	return q.enqueue(packet, time.Time{}, SlowClientBlock, SlowClientBlock)
+}
+
+// enqueue queues a packet to be sent with a slow client policy for the max in-flight bytes and another one for the
+// max packets queued
+func (q *sendQueue) enqueue(packet []byte, committed time.Time, bytesPolicy SlowClientPolicy,
+	packetsPolicy SlowClientPolicy) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for !q.closed && q.bytes > 0 {
+		overBytes := q.bytes+uint64(len(packet)) > q.maxBytes
+		overPackets := q.maxPackets > 0 && len(q.packets) >= q.maxPackets
+		if !overBytes && !overPackets {
+			break
+		}
+		if overBytes && bytesPolicy == SlowClientDrop {
+			log.Warnf("Max in-flight bytes reached (%d pending, max %d)", q.bytes, q.maxBytes)
+			return ErrSlowClient
+		}
+		if overPackets && packetsPolicy == SlowClientDrop {
+			log.Warnf("Client queue full (%d entries pending)", len(q.packets))
+			return ErrSlowClient
+		}
+		q.cond.Wait()
+	}
+	if q.closed {
+		return ErrNilConnection
+	}
+
+	q.packets = append(q.packets, packet)
+	q.committed = append(q.committed, committed)
+	q.bytes += uint64(len(packet))
+	q.cond.Broadcast()
+	return nil
+}
+
+// pending returns the bytes pending to be sent
+func (q *sendQueue) pending() uint64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.bytes
+}
+
+// close discards the pending packets and releases the waiting goroutines
+func (q *sendQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closed = true
+	q.packets = nil
+	q.committed = nil
+	q.bytes = 0
+	q.cond.Broadcast()
+}
+
+// run writes the queued packets to the client connection until the queue is closed or a write fails. The packets
+// are written in batches, adapted to the client speed after each write.
+func (q *sendQueue) run(cli *client, timeout time.Duration, onError func(err error)) {
+	for {
+		q.mutex.Lock()
+		for !q.closed && len(q.packets) == 0 {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mutex.Unlock()
+			return
+		}
+		batch, count := q.nextBatch()
+		full := count < len(q.packets)
+		q.mutex.Unlock()
+
+		start := time.Now()
+		_, err := TimeoutWrite(cli, batch, timeout)
+		if err != nil {
+			q.close()
+			onError(err)
+			return
+		}
+
+		q.mutex.Lock()
+		if !q.closed {
+			q.observeWritten(cli, count)
+			q.packets = q.packets[count:]
+			q.committed = q.committed[count:]
+			q.bytes -= uint64(len(batch))
+			q.adaptBatch(time.Since(start), full)
+			q.cond.Broadcast()
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// nextBatch returns the queued packets to write at once (at least one) and their number, the mutex must be held
+func (q *sendQueue) nextBatch() ([]byte, int) {
+	size := len(q.packets[0])
+	count := 1
+	for count < len(q.packets) && uint64(size+len(q.packets[count])) <= q.batchSize {
+		size += len(q.packets[count])
+		count++
+	}
+	if count == 1 {
+		return q.packets[0], count
+	}
+
+	batch := make([]byte, 0, size)
+	for _, packet := range q.packets[:count] {
+		batch = append(batch, packet...)
+	}
+	return batch, count
+}
+
+// adaptBatch halves the batch size when the client is slow to receive it, or doubles it when it's fast and there
+// were more packets pending than the batch, the mutex must be held
+func (q *sendQueue) adaptBatch(elapsed time.Duration, full bool) {
+	switch {
+	case elapsed > q.writeTarget:
+		q.batchSize = max(q.batchSize/2, minBatchSize) //nolint:mnd
+	case full:
+		q.batchSize = min(q.batchSize*2, maxBatchSize) //nolint:mnd
+	}
+}
+
+// setWriteTarget sets the max time writing a batch to keep growing it, shrinking the batch if needed
+func (q *sendQueue) setWriteTarget(writeTarget time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if writeTarget < q.writeTarget {
+		q.batchSize = minBatchSize
+	}
+	q.writeTarget = writeTarget
+}
+
+// getBatchSize returns the current batch size
+func (q *sendQueue) getBatchSize() uint64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.batchSize
+}
+
+// observeWritten records the queue delay and latency misses of the first packets written, the mutex must be held
+func (q *sendQueue) observeWritten(cli *client, count int) {
+	for _, committed := range q.committed[:count] {
+		cli.observeWritten(committed)
+	}
+}
@@ -0,0 +1,202 @@
+package datastreamer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeAddr type for the address of a pipe end, unique per pipe to tell apart the clients of a test server
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn type for the server end of a pipe with its own address
+type pipeConn struct {
+	net.Conn
+	addr pipeAddr
+}
+
+func (c pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+var pipeCount atomic.Uint64
+
+// startStalledClient connects a client through a pipe that starts streaming from the tail and stops reading, once
+// the response of the start command has been sent, returns the client side of the pipe and the server client
+func startStalledClient(t *testing.T, s *StreamServer) (net.Conn, *client) {
+	t.Helper()
+
+	clientConn, pipeServer := net.Pipe()
+	serverConn := pipeConn{Conn: pipeServer, addr: pipeAddr(fmt.Sprintf("pipe-%d", pipeCount.Add(1)))}
+	t.Cleanup(func() { clientConn.Close() })
+	go s.handleConnection(serverConn)
+
+	require.Equal(t, uint32(0), sendStartCommand(t, clientConn, s.GetHeader().TotalEntries))
+
+	var cli *client
+	require.Eventually(t, func() bool {
+		s.mutexClients.RLock()
+		defer s.mutexClients.RUnlock()
+		cli = s.clients[serverConn.RemoteAddr().String()]
+		return cli != nil && cli.getStatus() == csSynced && (cli.outbox == nil || cli.outbox.pending() == 0)
+	}, time.Second, time.Millisecond)
+
+	return clientConn, cli
+}
+
+func TestMaxInFlightBytes(t *testing.T) {
+	const entrySize = FixedSizeFileEntry + 100
+	const maxBytes = 3 * entrySize
+
+	t.Run("drop", func(t *testing.T) {
+		s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+			s.SetMaxInFlightBytes(maxBytes, SlowClientDrop)
+		})
+		_, cli := startStalledClient(t, s)
+
+		// The first entry is being written and the next two are buffered
+		for range 3 {
+			commitTestEntry(t, s)
+		}
+		require.Eventually(t, func() bool { return cli.outbox.pending() == maxBytes }, time.Second, time.Millisecond)
+		assert.Equal(t, 1, s.getSafeClientsLen())
+
+		// The next one exceeds the max and the client is dropped
+		commitTestEntry(t, s)
+		require.Eventually(t, func() bool { return s.getSafeClientsLen() == 0 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+			s.writeTimeout = time.Minute
+			s.SetMaxInFlightBytes(maxBytes, SlowClientBlock)
+		})
+		conn, cli := startStalledClient(t, s)
+
+		// The broadcast waits with the max buffered
+		for range 4 {
+			commitTestEntry(t, s)
+		}
+		require.Eventually(t, func() bool { return cli.outbox.pending() == maxBytes }, time.Second, time.Millisecond)
+		time.Sleep(50 * time.Millisecond) //nolint:mnd
+		assert.Equal(t, uint64(maxBytes), cli.outbox.pending())
+		assert.Equal(t, 1, s.getSafeClientsLen())
+
+		// All the entries are received once the client reads
+		assert.Equal(t, []uint64{0, 1, 2, 3}, readStreamEntryNumbers(t, conn, 4)) //nolint:mnd
+		require.Eventually(t, func() bool { return cli.outbox.pending() == 0 }, time.Second, time.Millisecond)
+		assert.Equal(t, 1, s.getSafeClientsLen())
+	})
+}
+
+func TestAdaptiveBatching(t *testing.T) {
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.writeTimeout = time.Minute
+		s.SetMaxInFlightBytes(64*1024*1024, SlowClientBlock) //nolint:mnd
+	})
+	fastConn, fast := startStalledClient(t, s)
+	slowConn, slow := startStalledClient(t, s)
+
+	// The fast consumer reads all, the slow one a few bytes at a time
+	go func() { _, _ = io.Copy(io.Discard, fastConn) }()
+	go func() {
+		buffer := make([]byte, 1024) //nolint:mnd
+		for {
+			if _, err := slowConn.Read(buffer); errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				return
+			}
+			time.Sleep(20 * time.Millisecond) //nolint:mnd
+		}
+	}()
+
+	// Bursts of entries
+	for range 20 {
+		require.NoError(t, s.StartAtomicOp())
+		for range 100 {
+			_, err := s.AddStreamEntry(1, make([]byte, 1024)) //nolint:mnd
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+	}
+
+	// The batch grows for the fast consumer and shrinks for the slow one
+	batchSizes := func() map[string]uint64 {
+		sizes := make(map[string]uint64)
+		for _, info := range s.ListClients() {
+			sizes[info.ID] = info.BatchSize
+		}
+		return sizes
+	}
+	require.Eventually(t, func() bool {
+		sizes := batchSizes()
+		return sizes[fast.clientID] > initialBatchSize && sizes[slow.clientID] == minBatchSize
+	}, 5*time.Second, 10*time.Millisecond, "%v", batchSizes())
+	assert.Len(t, s.ListClients(), 2) //nolint:mnd
+}
+
+func TestSlowClientDisconnect(t *testing.T) {
+	// receiveAll starts a client receiving the entries, returns the channel of their numbers
+	receiveAll := func(t *testing.T, s *StreamServer) chan uint64 {
+		t.Helper()
+
+		c, err := NewClient(testServerAddr(s), 1)
+		require.NoError(t, err)
+		received := make(chan uint64, 100) //nolint:mnd
+		c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+			received <- e.Number
+			return nil
+		})
+		startClientUntilCleanup(t, c)
+		require.NoError(t, c.ExecCommandStart(0))
+		return received
+	}
+
+	// checkReceived commits entries while a client is stalled, the other client receives all of them
+	checkReceived := func(t *testing.T, s *StreamServer, received chan uint64) {
+		t.Helper()
+
+		for range 20 {
+			commitTestEntry(t, s)
+		}
+		for num := range uint64(20) {
+			select {
+			case n := <-received:
+				assert.Equal(t, num, n)
+			case <-time.After(5 * time.Second): //nolint:mnd
+				t.Fatalf("entry %d not received", num)
+			}
+		}
+		require.Eventually(t, func() bool { return s.getSafeClientsLen() == 1 }, time.Second, time.Millisecond)
+	}
+
+	t.Run("queue full", func(t *testing.T) {
+		s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+			s.SetClientWriteTimeout(time.Minute)
+			s.SetClientQueueSize(4) //nolint:mnd
+		})
+		_, stalled := startStalledClient(t, s)
+		received := receiveAll(t, s)
+
+		checkReceived(t, s, received)
+		assert.Equal(t, csKilled, stalled.getStatus())
+	})
+
+	t.Run("write timeout", func(t *testing.T) {
+		s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+			s.SetClientWriteTimeout(50 * time.Millisecond) //nolint:mnd
+		})
+		_, stalled := startStalledClient(t, s)
+		received := receiveAll(t, s)
+
+		checkReceived(t, s, received)
+		assert.Equal(t, csKilled, stalled.getStatus())
+	})
+}
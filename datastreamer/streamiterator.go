@@ -0,0 +1,381 @@
+package datastreamer
+
+import (
+	"errors"
+	"iter"
+	"math"
+	"os"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// BeyondTailMode type for the iterator behavior when the start entry is beyond the tail
+type BeyondTailMode uint8
+
+const (
+	BeyondTailError BeyondTailMode = iota // BeyondTailError fails creating the iterator with ErrStartBeyondTail
+	BeyondTailWait                        // BeyondTailWait returns end until the start entry is committed
+)
+
+// IteratorOptions type for the iterator settings
+type IteratorOptions struct {
+	BeyondTail        BeyondTailMode // Behavior when the start entry is greater than the total entries
+	IncludeTombstones bool           // Return the tombstoned entries instead of skipping them
+	PrefetchPages     int            // Data pages read ahead in background (0: none, read as consumed)
+}
+
+// Iterator type to read the committed data entries sequentially from a start entry number.
+// When the end is reached it can be called again to pick up the entries committed since then.
+type Iterator struct {
+	streamFile *StreamFile
+	opts       IteratorOptions
+	fromEntry  uint64
+	toEntry    uint64        // Last entry number to read (including)
+	iterator   *iteratorFile // File iterator, opened once the start entry is committed
+	entry      FileEntry     // Entry read by the latest call to Next
+
+	readTransform DataTransform // Transform of the entries data read (nil: none)
+
+	prefetch *iteratorPrefetch // Data pages reader running (nil: not prefetching or stopped at the tail)
+	page     prefetchedPage    // Data page read ahead the entries are being read from
+	pos      uint64            // File position of the next entry to read when prefetching (0: not located yet)
+
+	resolveBookmark func(bookmark []byte) (uint64, error) // Entry number of a bookmark (nil: no bookmarks index)
+}
+
+// GetIterator returns an iterator starting from an entry number.
+// Starting at the tail (entry number equal to the total entries) returns end until new entries are committed.
+func (f *StreamFile) GetIterator(fromEntry uint64, opts IteratorOptions) (*Iterator, error) {
+	header := f.getHeaderEntry()
+	if fromEntry < header.firstEntry {
+		log.Errorf("Invalid starting entry number %d for iterator, first entry is %d", fromEntry, header.firstEntry)
+		return nil, ErrInvalidEntryNumber
+	}
+	if fromEntry > header.TotalEntries && opts.BeyondTail == BeyondTailError {
+		log.Errorf("Starting entry number %d for iterator beyond the tail %d", fromEntry, header.TotalEntries)
+		return nil, ErrStartBeyondTail
+	}
+
+	it := Iterator{
+		streamFile: f,
+		opts:       opts,
+		fromEntry:  fromEntry,
+		toEntry:    math.MaxUint64,
+	}
+
+	// Locate the start entry if already committed
+	_, err := it.open(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &it, nil
+}
+
+// GetRangeIterator returns an iterator over the committed entries from an entry number until another one (including),
+// returning end once it's passed. An end entry beyond the tail behaves as GetIterator, picking up the entries
+// committed later until the end entry.
+func (f *StreamFile) GetRangeIterator(from, to uint64) (*Iterator, error) {
+	if from > to {
+		log.Errorf("Invalid entry range for iterator, from %d greater than to %d", from, to)
+		return nil, ErrInvalidEntryRange
+	}
+
+	it, err := f.GetIterator(from, IteratorOptions{})
+	if err != nil {
+		return nil, err
+	}
+	it.toEntry = to
+	return it, nil
+}
+
+// open opens the file iterator if the start entry is committed, returns if it's open
+func (it *Iterator) open(header HeaderEntry) (bool, error) {
+	if it.iterator != nil {
+		return true, nil
+	}
+	if it.fromEntry >= header.TotalEntries {
+		return false, nil
+	}
+
+	iterator, err := it.streamFile.iteratorFrom(it.fromEntry, true)
+	if err != nil {
+		if iterator != nil {
+			it.streamFile.iteratorEnd(iterator)
+		}
+		return false, err
+	}
+	it.iterator = iterator
+	return true, nil
+}
+
+// Next reads the next committed entry, returns true at the end of the committed entries.
+// Tombstoned entries are skipped unless IncludeTombstones is set.
+func (it *Iterator) Next() (bool, error) {
+	opened, err := it.open(it.streamFile.getHeaderEntry())
+	if err != nil || !opened {
+		return true, err
+	}
+	for {
+		end, err := it.read()
+		if err != nil || end {
+			return end, err
+		}
+		if it.iterator.Entry.Number > it.toEntry {
+			return true, nil
+		}
+		if it.opts.IncludeTombstones || !it.iterator.Entry.Tombstoned {
+			it.entry = it.iterator.Entry
+			return false, transformEntry(&it.entry, it.readTransform)
+		}
+	}
+}
+
+// GetEntry returns the entry read by the latest call to Next
+func (it *Iterator) GetEntry() FileEntry {
+	return it.entry
+}
+
+// End finalizes the iterator, stopping the reading ahead of the data pages
+func (it *Iterator) End() {
+	if it.prefetch != nil {
+		it.prefetch.stop()
+		it.prefetch = nil
+	}
+	if it.iterator != nil {
+		it.streamFile.iteratorEnd(it.iterator)
+		it.iterator = nil
+	}
+}
+
+// SeekToBookmark repositions the iterator at the entry pointed by a bookmark, backward or forward, so the next call to
+// Next reads that entry. An unknown bookmark fails with ErrBookmarkNotFound, as any bookmark for the iterators of a
+// stream file opened directly (without bookmarks index).
+func (it *Iterator) SeekToBookmark(bookmark []byte) error {
+	if it.resolveBookmark == nil {
+		log.Errorf("Bookmark [%v] to seek not found, no bookmarks index", bookmark)
+		return ErrBookmarkNotFound
+	}
+	entryNum, err := it.resolveBookmark(bookmark)
+	if errors.Is(err, ErrStoreKeyNotFound) {
+		log.Errorf("Bookmark [%v] to seek not found", bookmark)
+		return ErrBookmarkNotFound
+	} else if err != nil {
+		return err
+	}
+
+	// Located again by the next call to Next
+	it.End()
+	it.fromEntry = entryNum
+	it.page = prefetchedPage{}
+	it.pos = 0
+	return nil
+}
+
+// ReverseIterator type to read the committed data entries in descending order from an entry number down to the
+// first one. Entries are written forward in the data pages, so each page is read at once and returned backwards.
+type ReverseIterator struct {
+	streamFile *StreamFile
+	file       *os.File
+	pageStart  uint64      // Start position of the data page loaded
+	entries    []FileEntry // Entries of the data page loaded not returned yet
+	entry      FileEntry   // Entry read by the latest call to Next
+
+	readTransform DataTransform // Transform of the entries data read (nil: none)
+}
+
+// GetReverseIterator returns an iterator over the committed entries in descending order starting from an entry
+// number. Tombstoned entries are skipped.
+func (f *StreamFile) GetReverseIterator(fromEntry uint64) (*ReverseIterator, error) {
+	iterator, err := f.iteratorFrom(fromEntry, true)
+	if err != nil {
+		if iterator != nil {
+			f.iteratorEnd(iterator)
+		}
+		return nil, err
+	}
+	pos, err := f.iteratorPos(iterator)
+	if err != nil {
+		f.iteratorEnd(iterator)
+		return nil, err
+	}
+
+	// Entries of the page until the start entry
+	it := ReverseIterator{
+		streamFile: f,
+		file:       iterator.file,
+	}
+	_, it.pageStart = f.pageStart(pos)
+	err = it.load(fromEntry)
+	if err != nil {
+		it.End()
+		return nil, err
+	}
+
+	return &it, nil
+}
+
+// load reads the entries of the current data page until an entry number (including)
+func (it *ReverseIterator) load(toEntry uint64) error {
+	f := it.streamFile
+	header := f.getHeaderEntry()
+	err := f.verifyPage(header, it.pageStart)
+	if err != nil {
+		return err
+	}
+
+	it.entries = it.entries[:0]
+	pageEnd := min(it.pageStart+uint64(f.pageSize), header.TotalLength)
+	_, err = walkEntriesFrom(it.file, it.pageStart, pageEnd, f.pageSize, func(_ uint64, e FileEntry) error {
+		if e.Number <= toEntry {
+			it.entries = append(it.entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error reading data page at %d for reverse iterator: %v", it.pageStart, err)
+	}
+	return err
+}
+
+// Next reads the previous committed entry, returns true once the first entry has been read
+func (it *ReverseIterator) Next() (bool, error) {
+	f := it.streamFile
+	for {
+		for len(it.entries) > 0 {
+			entry := it.entries[len(it.entries)-1]
+			it.entries = it.entries[:len(it.entries)-1]
+			if entry.Tombstoned {
+				continue
+			}
+
+			// Decrypt and decompress the data
+			err := f.loadEntry(&entry)
+			if err != nil {
+				return true, err
+			}
+			it.entry = entry
+			return false, transformEntry(&it.entry, it.readTransform)
+		}
+
+		// Previous data page
+		if it.pageStart <= PageHeaderSize {
+			return true, nil
+		}
+		it.pageStart -= uint64(f.pageSize)
+		err := it.load(math.MaxUint64)
+		if err != nil {
+			return true, err
+		}
+	}
+}
+
+// GetEntry returns the entry read by the latest call to Next
+func (it *ReverseIterator) GetEntry() FileEntry {
+	return it.entry
+}
+
+// End finalizes the iterator
+func (it *ReverseIterator) End() {
+	if it.file != nil {
+		it.file.Close()
+		it.file = nil
+	}
+}
+
+// Entries returns a range-over-func iterator over the committed entries from an entry number until another one
+// (excluding). Errors are yielded in the second value ending the iteration, the file is released when it ends.
+func (f *StreamFile) Entries(from, to uint64) iter.Seq2[FileEntry, error] {
+	return f.entries(from, to, nil)
+}
+
+// entries returns a range-over-func iterator over the committed entries applying a data transform
+func (f *StreamFile) entries(from, to uint64, transform DataTransform) iter.Seq2[FileEntry, error] {
+	return func(yield func(FileEntry, error) bool) {
+		if from >= to {
+			return
+		}
+
+		it, err := f.GetIterator(from, IteratorOptions{})
+		if err != nil {
+			yield(FileEntry{}, err)
+			return
+		}
+		it.readTransform = transform
+		defer it.End()
+
+		for {
+			end, err := it.Next()
+			if err != nil {
+				yield(FileEntry{}, err)
+				return
+			}
+			if end {
+				return
+			}
+
+			entry := it.GetEntry()
+			if entry.Number >= to || !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
+// CombinedKind type for the kind of an entry read by a combined iterator
+type CombinedKind uint8
+
+const (
+	CombinedData     CombinedKind = iota // CombinedData for a data entry
+	CombinedBookmark                     // CombinedBookmark for a bookmark entry
+)
+
+// CombinedEntry type for an entry read by a combined iterator, a data entry or a bookmark
+type CombinedEntry struct {
+	Kind     CombinedKind
+	Entry    FileEntry // Entry as stored in the stream (bookmarks included)
+	Bookmark []byte    // Bookmark key (CombinedBookmark only)
+}
+
+// CombinedIterator type to read the committed data entries and bookmarks together in entry number order, telling
+// which is which. When the end is reached it can be called again to pick up the entries committed since then.
+type CombinedIterator struct {
+	it    *Iterator
+	entry CombinedEntry
+}
+
+// GetCombinedIterator returns an iterator over the committed data entries and bookmarks in their entry number order
+// starting from an entry number. Tombstoned entries are skipped.
+func (s *StreamServer) GetCombinedIterator(fromEntry uint64) (*CombinedIterator, error) {
+	it, err := s.GetIterator(fromEntry, IteratorOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &CombinedIterator{it: it}, nil
+}
+
+// Next reads the next committed data entry or bookmark, returns true at the end of the committed entries
+func (c *CombinedIterator) Next() (bool, error) {
+	end, err := c.it.Next()
+	if err != nil || end {
+		return end, err
+	}
+
+	entry := c.it.GetEntry()
+	c.entry = CombinedEntry{Kind: CombinedData, Entry: entry}
+	if entry.Type == EtBookmark {
+		c.entry.Kind = CombinedBookmark
+		c.entry.Bookmark = entry.Data
+	}
+	return false, nil
+}
+
+// GetEntry returns the data entry or bookmark read by the latest call to Next
+func (c *CombinedIterator) GetEntry() CombinedEntry {
+	return c.entry
+}
+
+// End finalizes the iterator
+func (c *CombinedIterator) End() {
+	c.it.End()
+}
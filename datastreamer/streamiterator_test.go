@@ -0,0 +1,307 @@
+package datastreamer
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetIterator(t *testing.T) {
+	sf := setupTestFile(t, filepath.Join(t.TempDir(), "iterator.bin"))
+	defer sf.Close()
+	for i := range 5 {
+		addTestEntry(t, sf, 100*(i+1)) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+
+	it, err := sf.GetIterator(2, IteratorOptions{}) //nolint:mnd
+	require.NoError(t, err)
+	defer it.End()
+
+	for num := uint64(2); num < 5; num++ {
+		end, err := it.Next()
+		require.NoError(t, err)
+		require.False(t, end)
+		assert.Equal(t, num, it.GetEntry().Number)
+		assert.Len(t, it.GetEntry().Data, 100*int(num+1)) //nolint:mnd
+	}
+	end, err := it.Next()
+	require.NoError(t, err)
+	assert.True(t, end)
+}
+
+func TestGetRangeIterator(t *testing.T) {
+	sf := setupTestFile(t, filepath.Join(t.TempDir(), "range.bin"))
+	defer sf.Close()
+	for range 10 {
+		addTestEntry(t, sf, 10) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+
+	_, err := sf.GetRangeIterator(5, 3) //nolint:mnd
+	require.ErrorIs(t, err, ErrInvalidEntryRange)
+
+	readRange := func(it *Iterator) []uint64 {
+		var numbers []uint64
+		for {
+			end, err := it.Next()
+			require.NoError(t, err)
+			if end {
+				return numbers
+			}
+			numbers = append(numbers, it.GetEntry().Number)
+		}
+	}
+
+	// Stops after the end entry (including)
+	it, err := sf.GetRangeIterator(3, 5) //nolint:mnd
+	require.NoError(t, err)
+	defer it.End()
+	assert.Equal(t, []uint64{3, 4, 5}, readRange(it))
+	assert.Empty(t, readRange(it))
+
+	// An end entry beyond the tail picks up the entries committed later
+	it, err = sf.GetRangeIterator(7, 11) //nolint:mnd
+	require.NoError(t, err)
+	defer it.End()
+	assert.Equal(t, []uint64{7, 8, 9}, readRange(it))
+	for range 3 {
+		addTestEntry(t, sf, 10) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	assert.Equal(t, []uint64{10, 11}, readRange(it))
+}
+
+func TestGetReverseIterator(t *testing.T) {
+	sf, err := NewStreamFileWithOptions(filepath.Join(t.TempDir(), "reverse.bin"), 1, 12345, 1,
+		StreamFileOptions{PageSize: MinPageSize})
+	require.NoError(t, err)
+	defer sf.Close()
+
+	// Entries over three data pages, one of them tombstoned
+	for range 150 {
+		addTestEntry(t, sf, 1000) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	require.NoError(t, sf.tombstoneEntry(64)) //nolint:mnd
+
+	_, err = sf.GetReverseIterator(150) //nolint:mnd
+	require.ErrorIs(t, err, ErrInvalidEntryNumber)
+
+	it, err := sf.GetReverseIterator(140) //nolint:mnd
+	require.NoError(t, err)
+	defer it.End()
+
+	for num := int64(140); num >= 0; num-- {
+		if num == 64 { //nolint:mnd
+			continue
+		}
+		end, err := it.Next()
+		require.NoError(t, err)
+		require.False(t, end)
+		assert.Equal(t, uint64(num), it.GetEntry().Number)
+		assert.Equal(t, byte(num), it.GetEntry().Data[0])
+	}
+
+	// Ends at the first entry
+	for range 2 {
+		end, err := it.Next()
+		require.NoError(t, err)
+		assert.True(t, end)
+	}
+}
+
+func TestGetIteratorBeyondTail(t *testing.T) {
+	const committed = 3
+
+	tests := []struct {
+		name      string
+		mode      BeyondTailMode
+		fromEntry uint64
+		err       error
+	}{
+		{name: "error mode at tail", mode: BeyondTailError, fromEntry: committed},
+		{name: "error mode beyond tail", mode: BeyondTailError, fromEntry: committed + 2, err: ErrStartBeyondTail},
+		{name: "wait mode at tail", mode: BeyondTailWait, fromEntry: committed},
+		{name: "wait mode beyond tail", mode: BeyondTailWait, fromEntry: committed + 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sf := setupTestFile(t, filepath.Join(t.TempDir(), "tail.bin"))
+			defer sf.Close()
+			for range committed {
+				addTestEntry(t, sf, 10) //nolint:mnd
+			}
+			require.NoError(t, sf.writeHeaderEntry())
+
+			it, err := sf.GetIterator(tc.fromEntry, IteratorOptions{BeyondTail: tc.mode})
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			defer it.End()
+
+			// Nothing to read at the tail
+			end, err := it.Next()
+			require.NoError(t, err)
+			assert.True(t, end)
+
+			// Entries before the start entry are committed, still nothing to read
+			for sf.header.TotalEntries < tc.fromEntry {
+				addTestEntry(t, sf, 10) //nolint:mnd
+			}
+			require.NoError(t, sf.writeHeaderEntry())
+			end, err = it.Next()
+			require.NoError(t, err)
+			assert.True(t, end)
+
+			// The iterator picks up the new entries from the start entry
+			addTestEntry(t, sf, 10) //nolint:mnd
+			addTestEntry(t, sf, 10) //nolint:mnd
+			require.NoError(t, sf.writeHeaderEntry())
+			for num := tc.fromEntry; num < tc.fromEntry+2; num++ {
+				end, err = it.Next()
+				require.NoError(t, err)
+				require.False(t, end)
+				assert.Equal(t, num, it.GetEntry().Number)
+			}
+			end, err = it.Next()
+			require.NoError(t, err)
+			assert.True(t, end)
+		})
+	}
+}
+
+func TestEntriesRangeOverFunc(t *testing.T) {
+	sf := setupTestFile(t, filepath.Join(t.TempDir(), "entries.bin"))
+	defer sf.Close()
+	for range 10 {
+		addTestEntry(t, sf, 50) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+
+	// Range until the tail
+	var numbers []uint64
+	for entry, err := range sf.Entries(0, sf.getHeaderEntry().TotalEntries) {
+		require.NoError(t, err)
+		numbers = append(numbers, entry.Number)
+	}
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, numbers)
+
+	// Range in the middle, stopping before the end entry
+	numbers = nil
+	for entry, err := range sf.Entries(3, 6) { //nolint:mnd
+		require.NoError(t, err)
+		numbers = append(numbers, entry.Number)
+	}
+	assert.Equal(t, []uint64{3, 4, 5}, numbers)
+
+	// Breaking the loop stops reading
+	numbers = nil
+	for entry, err := range sf.Entries(0, 10) { //nolint:mnd
+		require.NoError(t, err)
+		numbers = append(numbers, entry.Number)
+		if entry.Number == 1 {
+			break
+		}
+	}
+	assert.Equal(t, []uint64{0, 1}, numbers)
+
+	// Errors are yielded ending the loop
+	var errs []error
+	for _, err := range sf.Entries(20, 30) { //nolint:mnd
+		errs = append(errs, err)
+	}
+	require.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], ErrStartBeyondTail)
+}
+
+func TestGetCombinedIterator(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	// Two blocks, each a bookmark followed by its entries
+	require.NoError(t, s.StartAtomicOp())
+	var kinds []CombinedKind
+	for block := range 2 {
+		_, err := s.AddStreamBookmark([]byte{0, byte(block)})
+		require.NoError(t, err)
+		kinds = append(kinds, CombinedBookmark)
+		for i := range 3 {
+			_, err = s.AddStreamEntry(1, []byte{byte(block), byte(i)})
+			require.NoError(t, err)
+			kinds = append(kinds, CombinedData)
+		}
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	it, err := s.GetCombinedIterator(1)
+	require.NoError(t, err)
+	defer it.End()
+
+	for num := uint64(1); num < uint64(len(kinds)); num++ {
+		end, err := it.Next()
+		require.NoError(t, err)
+		require.False(t, end)
+
+		entry := it.GetEntry()
+		assert.Equal(t, num, entry.Entry.Number)
+		require.Equal(t, kinds[num], entry.Kind, "entry %d", num)
+		if entry.Kind == CombinedBookmark {
+			assert.Equal(t, []byte{0, 1}, entry.Bookmark)
+		} else {
+			assert.Nil(t, entry.Bookmark)
+			assert.Equal(t, EntryType(1), entry.Entry.Type)
+		}
+	}
+	end, err := it.Next()
+	require.NoError(t, err)
+	assert.True(t, end)
+}
+
+func TestIteratorSeekToBookmark(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	var bookmarks []uint64
+	for i := range 3 {
+		bookmarks = append(bookmarks, addTestBookmarks(t, s, fmt.Sprintf("b%d", i))...)
+		for range 3 {
+			commitTestEntry(t, s)
+		}
+	}
+
+	for _, prefetchPages := range []int{0, 2} {
+		it, err := s.GetIteratorWithPrefetch(0, prefetchPages)
+		require.NoError(t, err)
+		next := func() FileEntry {
+			end, err := it.Next()
+			require.NoError(t, err)
+			require.False(t, end)
+			return it.GetEntry()
+		}
+		next()
+
+		// Forward, backward and to the same bookmark again
+		for _, i := range []int{2, 0, 1, 1} {
+			require.NoError(t, it.SeekToBookmark(fmt.Appendf(nil, "b%d", i)))
+			entry := next()
+			assert.Equal(t, bookmarks[i], entry.Number)
+			assert.Equal(t, EntryType(EtBookmark), entry.Type)
+			assert.Equal(t, bookmarks[i]+1, next().Number)
+		}
+
+		// Unknown bookmark, the iterator keeps its position
+		require.ErrorIs(t, it.SeekToBookmark([]byte("b9")), ErrBookmarkNotFound)
+		assert.Equal(t, bookmarks[1]+2, next().Number) //nolint:mnd
+		it.End()
+	}
+
+	// Iterator of a stream file without bookmarks index
+	it, err := s.streamFile.GetIterator(0, IteratorOptions{})
+	require.NoError(t, err)
+	defer it.End()
+	require.ErrorIs(t, it.SeekToBookmark([]byte("b0")), ErrBookmarkNotFound)
+}
@@ -0,0 +1,169 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// prefetchedPage type for the committed bytes of a data page read ahead by an iterator
+type prefetchedPage struct {
+	pos  uint64 // File position of the first byte
+	data []byte // Bytes from the position until the end of the page or the committed data
+	err  error  // Error reading or verifying the page, the last one sent
+}
+
+// iteratorPrefetch type for the background reading of the data pages ahead of an iterator
+type iteratorPrefetch struct {
+	pages chan prefetchedPage // Pages read in file order, closed once the committed data is reached
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// GetIteratorWithPrefetch returns an iterator starting from an entry number as GetIterator, reading up to a number of
+// data pages ahead in background while the current one is consumed
+func (f *StreamFile) GetIteratorWithPrefetch(fromEntry uint64, prefetchPages int) (*Iterator, error) {
+	return f.GetIterator(fromEntry, IteratorOptions{PrefetchPages: prefetchPages})
+}
+
+// GetIteratorWithPrefetch returns an iterator starting from an entry number reading the data pages ahead in background
+func (s *StreamServer) GetIteratorWithPrefetch(fromEntry uint64, prefetchPages int) (*Iterator, error) {
+	return s.GetIterator(fromEntry, IteratorOptions{PrefetchPages: prefetchPages})
+}
+
+// startPrefetch starts reading the committed data pages from a file position, until the committed data is reached
+func (f *StreamFile) startPrefetch(file *os.File, pos uint64, pages int) *iteratorPrefetch {
+	p := &iteratorPrefetch{
+		pages: make(chan prefetchedPage, pages),
+		done:  make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(p.pages)
+
+		for {
+			header := f.getHeaderEntry()
+			if pos >= header.TotalLength {
+				return
+			}
+
+			// Read until the end of the data page or the committed data
+			_, pageStart := f.pageStart(pos)
+			end := min(pageStart+uint64(f.pageSize), header.TotalLength)
+			page := prefetchedPage{pos: pos, data: make([]byte, end-pos)}
+			_, page.err = file.ReadAt(page.data, int64(pos))
+			if page.err != nil {
+				log.Errorf("Error reading data page at %d for iterator prefetch: %v", pos, page.err)
+			} else {
+				page.err = f.verifyPage(header, pos)
+			}
+
+			select {
+			case p.pages <- page:
+			case <-p.done:
+				return
+			}
+			if page.err != nil {
+				return
+			}
+			pos = end
+		}
+	}()
+
+	return p
+}
+
+// stop stops the reading ahead, waiting for it to finish
+func (p *iteratorPrefetch) stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// read reads the next data entry into the file iterator, returns the end of the committed entries condition
+func (it *Iterator) read() (bool, error) {
+	if it.opts.PrefetchPages <= 0 {
+		return it.streamFile.iteratorNext(it.iterator)
+	}
+	return it.readPrefetched()
+}
+
+// readPrefetched reads the next data entry from the data pages read ahead. Once the reading ahead reaches the committed
+// data and its pages are consumed it's started again from there, if more entries have been committed.
+func (it *Iterator) readPrefetched() (bool, error) {
+	f := it.streamFile
+	if it.pos == 0 {
+		pos, err := f.iteratorPos(it.iterator)
+		if err != nil {
+			return true, err
+		}
+		it.pos = pos
+	}
+
+	for {
+		// Entry in the page being read
+		pageEnd := it.page.pos + uint64(len(it.page.data))
+		if it.pos >= it.page.pos && it.pos < pageEnd {
+			data := it.page.data[it.pos-it.page.pos:]
+
+			// Pad goes until the end of the page
+			if data[0] == PtPadding {
+				_, pageStart := f.pageStart(it.pos)
+				it.pos = pageStart + uint64(f.pageSize)
+				continue
+			}
+			if data[0] != PtData {
+				log.Errorf("Error expecting packet of type data(%d). Read: %d", PtData, data[0])
+				return true, ErrExpectingPacketTypeData
+			}
+
+			// Entry must be fully inside the page read
+			if len(data) < FixedSizeFileEntry {
+				log.Errorf("Error decoding length data entry")
+				return true, ErrDecodingLengthDataEntry
+			}
+			length := binary.BigEndian.Uint32(data[1:5])
+			if length < FixedSizeFileEntry || uint64(length) > uint64(len(data)) {
+				log.Errorf("Error decoding length data entry")
+				return true, ErrDecodingLengthDataEntry
+			}
+
+			entry, err := DecodeBinaryToFileEntry(data[:length])
+			if err != nil {
+				log.Errorf("Error decoding entry for iterator: %v", err)
+				return true, err
+			}
+			it.iterator.Entry = entry
+			it.iterator.length = length
+			it.pos += uint64(length)
+
+			// Decrypt and decompress the data
+			err = f.loadEntry(&it.iterator.Entry)
+			if err != nil {
+				return true, err
+			}
+			return false, nil
+		}
+
+		// Next page read ahead, starting the reading again if it stopped at the committed data
+		if it.prefetch == nil {
+			if it.pos >= f.getHeaderEntry().TotalLength {
+				return true, nil
+			}
+			it.prefetch = f.startPrefetch(it.iterator.file, it.pos, it.opts.PrefetchPages)
+		}
+		page, ok := <-it.prefetch.pages
+		if !ok {
+			it.prefetch.stop()
+			it.prefetch = nil
+			continue
+		}
+		if page.err != nil {
+			return true, page.err
+		}
+		it.page = page
+	}
+}
@@ -0,0 +1,133 @@
+package datastreamer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupPrefetchFile creates a stream file of small data pages with some committed entries
+func setupPrefetchFile(tb testing.TB, entries int, size int) *StreamFile {
+	tb.Helper()
+
+	sf, err := NewStreamFileWithOptions(filepath.Join(tb.TempDir(), "prefetch.bin"), 1, 12345, 1,
+		StreamFileOptions{PageSize: MinPageSize})
+	require.NoError(tb, err)
+	tb.Cleanup(func() { _ = sf.Close() })
+
+	data := make([]byte, size)
+	for range entries {
+		num := sf.header.TotalEntries
+		data[0] = byte(num)
+		err = sf.AddFileEntry(FileEntry{
+			packetType: PtData,
+			Length:     uint32(FixedSizeFileEntry + size),
+			Type:       1,
+			Number:     num,
+			Data:       data,
+		})
+		require.NoError(tb, err)
+	}
+	require.NoError(tb, sf.writeHeaderEntry())
+	return sf
+}
+
+// readAllEntries reads the entries of an iterator until the end
+func readAllEntries(t *testing.T, it *Iterator) []FileEntry {
+	t.Helper()
+
+	var entries []FileEntry
+	for {
+		end, err := it.Next()
+		require.NoError(t, err)
+		if end {
+			return entries
+		}
+		entries = append(entries, it.GetEntry())
+	}
+}
+
+func TestGetIteratorWithPrefetch(t *testing.T) {
+	// Entries over several data pages, each one ending with a pad
+	sf := setupPrefetchFile(t, 300, 1000) //nolint:mnd
+	require.NoError(t, sf.tombstoneEntry(90))
+
+	expected, err := sf.GetIterator(5, IteratorOptions{}) //nolint:mnd
+	require.NoError(t, err)
+	defer expected.End()
+	it, err := sf.GetIteratorWithPrefetch(5, 2) //nolint:mnd
+	require.NoError(t, err)
+	defer it.End()
+
+	// Same entries in the same order
+	entries := readAllEntries(t, it)
+	require.Len(t, entries, 294) //nolint:mnd
+	assert.Equal(t, readAllEntries(t, expected), entries)
+	assert.Equal(t, uint64(5), entries[0].Number)
+	assert.Equal(t, uint64(299), entries[len(entries)-1].Number)
+	assert.Nil(t, it.prefetch)
+
+	// Entries committed later picked up
+	for range 100 {
+		addTestEntry(t, sf, 1000) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	entries = readAllEntries(t, it)
+	require.Len(t, entries, 100) //nolint:mnd
+	assert.Equal(t, uint64(300), entries[0].Number)
+	assert.Equal(t, readAllEntries(t, expected), entries)
+
+	// Reading ahead stopped on end
+	it2, err := sf.GetIteratorWithPrefetch(0, 4) //nolint:mnd
+	require.NoError(t, err)
+	end, err := it2.Next()
+	require.NoError(t, err)
+	require.False(t, end)
+	prefetch := it2.prefetch
+	require.NotNil(t, prefetch)
+	it2.End()
+	assert.Nil(t, it2.prefetch)
+	buffered := 0
+	for range prefetch.pages {
+		buffered++
+	}
+	assert.LessOrEqual(t, buffered, 4) //nolint:mnd
+}
+
+func BenchmarkIterator(b *testing.B) {
+	const entries = 20000
+
+	sf := setupPrefetchFile(b, entries, 1000) //nolint:mnd
+	for _, pages := range []int{0, 8} {
+		name := "no-prefetch"
+		if pages > 0 {
+			name = "prefetch"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(sf.header.TotalLength - PageHeaderSize))
+			for range b.N {
+				it, err := sf.GetIteratorWithPrefetch(0, pages)
+				if err != nil {
+					b.Fatal(err)
+				}
+				read := 0
+				for {
+					end, err := it.Next()
+					if err != nil {
+						b.Fatal(err)
+					}
+					if end {
+						break
+					}
+					read++
+				}
+				it.End()
+				if read != entries {
+					b.Fatalf("read %d entries, expected %d", read, entries)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package datastreamer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// StreamJournal type to manage the journal of the committed atomic operations
+type StreamJournal struct {
+	dbName string
+	db     *leveldb.DB
+}
+
+// CommitRecord type for a committed atomic operation in the journal
+type CommitRecord struct {
+	FirstEntry uint64    // First entry number of the atomic operation
+	Timestamp  time.Time // Commit time of the atomic operation (timestamp of all its entries)
+	Meta       []byte    // Application metadata encoded with the metadata codec of the stream file
+}
+
+// NewJournal creates journal struct and opens or creates the journal database
+func NewJournal(fn string) (*StreamJournal, error) {
+	j := StreamJournal{
+		dbName: fn,
+		db:     nil,
+	}
+
+	// Open (or create) the journal database
+	log.Infof("Opening/creating commit journal DB for datastream: %s", fn)
+	db, err := leveldb.OpenFile(fn, nil)
+	if err != nil {
+		log.Errorf("Error opening or creating commit journal DB %s: %v", fn, err)
+		return nil, err
+	}
+	j.db = db
+
+	return &j, nil
+}
+
+// AddCommit inserts or updates the record of a committed atomic operation
+func (j *StreamJournal) AddCommit(r CommitRecord) error {
+	err := j.db.Put(journalKey(r.FirstEntry), encodeCommitRecord(r), nil)
+	if err != nil {
+		log.Errorf("Error inserting commit record of entry %d: %v", r.FirstEntry, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteCommit deletes the record of the atomic operation starting at an entry number
+func (j *StreamJournal) DeleteCommit(firstEntry uint64) error {
+	err := j.db.Delete(journalKey(firstEntry), nil)
+	if err != nil {
+		log.Errorf("Error deleting commit record of entry %d: %v", firstEntry, err)
+		return err
+	}
+	return nil
+}
+
+// GetCommit gets the record of the committed atomic operation containing an entry number
+func (j *StreamJournal) GetCommit(entryNum uint64) (CommitRecord, error) {
+	iter := j.db.NewIterator(&util.Range{Limit: journalKey(entryNum + 1)}, nil)
+	defer iter.Release()
+
+	if !iter.Last() {
+		if err := iter.Error(); err != nil {
+			log.Errorf("Error getting commit record of entry %d: %v", entryNum, err)
+			return CommitRecord{}, err
+		}
+		return CommitRecord{}, ErrCommitNotFound
+	}
+	return decodeCommitRecord(iter.Key(), iter.Value())
+}
+
+// GetFirstCommit gets the record of the first committed atomic operation in the journal
+func (j *StreamJournal) GetFirstCommit() (CommitRecord, error) {
+	iter := j.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	if !iter.First() {
+		if err := iter.Error(); err != nil {
+			log.Errorf("Error getting first commit record: %v", err)
+			return CommitRecord{}, err
+		}
+		return CommitRecord{}, ErrCommitNotFound
+	}
+	return decodeCommitRecord(iter.Key(), iter.Value())
+}
+
+// TruncateFrom deletes the records of the atomic operations starting from an entry number onwards
+func (j *StreamJournal) TruncateFrom(entryNum uint64) error {
+	iter := j.db.NewIterator(&util.Range{Start: journalKey(entryNum)}, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	if err := iter.Error(); err != nil {
+		log.Errorf("Error truncating commit journal from entry %d: %v", entryNum, err)
+		return err
+	}
+	return j.db.Write(batch, nil)
+}
+
+// Close closes the journal database
+func (j *StreamJournal) Close() error {
+	if j.db == nil {
+		return nil
+	}
+	err := j.db.Close()
+	j.db = nil
+	return err
+}
+
+// journalKey returns the journal DB key of an entry number
+func journalKey(entryNum uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, entryNum)
+}
+
+// encodeCommitRecord encodes the value of a commit record in the journal DB
+func encodeCommitRecord(r CommitRecord) []byte {
+	be := binary.BigEndian.AppendUint64(nil, uint64(r.Timestamp.UnixNano()))
+	return append(be, r.Meta...)
+}
+
+// decodeCommitRecord decodes a commit record from the journal DB key and value
+func decodeCommitRecord(key []byte, value []byte) (CommitRecord, error) {
+	if len(key) != 8 || len(value) < 8 { //nolint:mnd
+		log.Errorf("Invalid commit record [%v]: %v", key, value)
+		return CommitRecord{}, ErrDecodingCommitRecord
+	}
+	return CommitRecord{
+		FirstEntry: binary.BigEndian.Uint64(key),
+		Timestamp:  time.Unix(0, int64(binary.BigEndian.Uint64(value[:8]))),
+		Meta:       bytes.Clone(value[8:]),
+	}, nil
+}
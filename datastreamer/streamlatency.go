@@ -0,0 +1,159 @@
+package datastreamer
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	latencyFirstBound = 100 * time.Microsecond // Upper bound of the first latency histogram bucket
+	latencyBounds     = 18                     // Number of bucket bounds, doubling from the first (up to ~13s)
+)
+
+// LatencyHistogram type for a distribution of latencies in buckets with exponential bounds
+type LatencyHistogram struct {
+	Bounds []time.Duration // Upper bound (included) of each bucket, the last bucket has no bound
+	Counts []uint64        // Number of latencies in each bucket (one more bucket than bounds)
+	Count  uint64          // Number of latencies
+	Sum    time.Duration   // Sum of the latencies
+	Min    time.Duration   // Lowest latency
+	Max    time.Duration   // Highest latency
+}
+
+// latencyRecorder type to record latencies in a histogram from several goroutines
+type latencyRecorder struct {
+	hist  LatencyHistogram
+	mutex sync.Mutex
+}
+
+// newLatencyRecorder creates an empty latency histogram recorder
+func newLatencyRecorder() *latencyRecorder {
+	r := latencyRecorder{}
+	r.hist.Bounds = make([]time.Duration, latencyBounds)
+	for i := range r.hist.Bounds {
+		r.hist.Bounds[i] = latencyFirstBound << i
+	}
+	r.hist.Counts = make([]uint64, latencyBounds+1)
+	return &r
+}
+
+// SetQueueDelayTracking enables the tracking of the queue delay of the entries streamed to each client, it must be
+// set before Start. The queue delay of an entry is the time from its commit until it's written to the client
+// connection, so it includes the broadcast and the time waiting in the client buffer (see SetMaxInFlightBytes) but
+// not the processing time of the client. Only the entries streamed as they are committed are measured, not the ones
+// sent catching up. ListClients returns the histogram of each client.
+func (s *StreamServer) SetQueueDelayTracking(enabled bool) {
+	s.trackQueueDelay = enabled
+}
+
+// observe records a latency
+func (r *latencyRecorder) observe(latency time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	bucket, _ := slices.BinarySearch(r.hist.Bounds, latency)
+	r.hist.Counts[bucket]++
+	if r.hist.Count == 0 || latency < r.hist.Min {
+		r.hist.Min = latency
+	}
+	r.hist.Max = max(r.hist.Max, latency)
+	r.hist.Count++
+	r.hist.Sum += latency
+}
+
+// snapshot returns a copy of the histogram
+func (r *latencyRecorder) snapshot() *LatencyHistogram {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	hist := r.hist
+	hist.Bounds = slices.Clone(r.hist.Bounds)
+	hist.Counts = slices.Clone(r.hist.Counts)
+	return &hist
+}
+
+// Mean returns the mean latency, 0 if there are none
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns an upper estimate of a latency quantile (e.g. 0.99): the bound of the bucket where it falls,
+// or the highest latency if it's lower or falls in the last bucket. Returns 0 if there are none.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(h.Count))
+	var seen uint64
+	for i, count := range h.Counts {
+		seen += count
+		if seen > rank && i < len(h.Bounds) {
+			return min(h.Bounds[i], h.Max)
+		}
+	}
+	return h.Max
+}
+
+// setMaxLatency sets the latency target of the entries broadcast to the client (0: none). The writes to a client
+// with a target are kept within half of it, so its buffered entries are not held behind a large batch.
+func (c *client) setMaxLatency(maxLatency time.Duration) {
+	c.maxLatency.Store(int64(maxLatency))
+	if c.outbox != nil {
+		writeTarget := batchWriteTarget
+		if maxLatency > 0 {
+			writeTarget = min(writeTarget, maxLatency/2) //nolint:mnd
+		}
+		c.outbox.setWriteTarget(writeTarget)
+	}
+}
+
+// observeWritten records the queue delay of an entry broadcast once written to the client, and whether it missed
+// the latency target of the client
+func (c *client) observeWritten(committed time.Time) {
+	if committed.IsZero() {
+		return
+	}
+	delay := time.Since(committed)
+	if c.queueDelay != nil {
+		c.queueDelay.observe(delay)
+	}
+	if maxLatency := time.Duration(c.maxLatency.Load()); maxLatency > 0 && delay > maxLatency {
+		c.latencyMisses.Add(1)
+	}
+}
+
+// broadcastLowLatency broadcasts committed atomic operations to the clients with a latency target (see
+// StreamClient.ExecCommandStartMaxLatency), apart from the other clients so a slow one can't delay them. They are
+// served from the lowest target.
+func (s *StreamServer) broadcastLowLatency() {
+	defer s.wg.Done()
+
+	for {
+		// Wait for new atomic operation to broadcast
+		var broadcastOp streamAO
+		select {
+		case broadcastOp = <-s.lowLatency:
+		case <-s.done:
+			return
+		}
+
+		s.mutexClients.RLock()
+		clients := make([]*client, 0, len(s.clients))
+		for _, cli := range s.clients {
+			if cli.maxLatency.Load() > 0 {
+				clients = append(clients, cli)
+			}
+		}
+		s.mutexClients.RUnlock()
+		slices.SortFunc(clients, func(a, b *client) int { return cmp.Compare(a.maxLatency.Load(), b.maxLatency.Load()) })
+
+		s.sendAtomicOp(broadcastOp, clients)
+		s.inBroadcast.Add(-1)
+	}
+}
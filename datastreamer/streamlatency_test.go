@@ -0,0 +1,124 @@
+package datastreamer
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	r := newLatencyRecorder()
+	assert.Zero(t, r.snapshot().Quantile(0.5)) //nolint:mnd
+
+	for _, latency := range []time.Duration{50 * time.Microsecond, time.Millisecond, time.Millisecond, time.Minute} {
+		r.observe(latency)
+	}
+	hist := r.snapshot()
+	assert.Equal(t, uint64(4), hist.Count)
+	assert.Equal(t, 50*time.Microsecond, hist.Min)
+	assert.Equal(t, time.Minute, hist.Max)
+	assert.Equal(t, uint64(1), hist.Counts[0])
+	assert.Equal(t, uint64(1), hist.Counts[len(hist.Counts)-1])
+	assert.Equal(t, 1600*time.Microsecond, hist.Quantile(0.5)) //nolint:mnd
+	assert.Equal(t, time.Minute, hist.Quantile(1))
+	assert.Equal(t, (time.Minute+2050*time.Microsecond)/4, hist.Mean())
+
+	// Snapshots are copies
+	r.observe(time.Millisecond)
+	assert.Equal(t, uint64(4), hist.Count)
+}
+
+func TestQueueDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.writeTimeout = time.Minute
+		s.SetMaxInFlightBytes(1024*1024, SlowClientBlock) //nolint:mnd
+		s.SetQueueDelayTracking(true)
+	})
+	fastConn, fast := startStalledClient(t, s)
+	slowConn, slow := startStalledClient(t, s)
+	go func() { _, _ = io.Copy(io.Discard, fastConn) }()
+
+	// The slow consumer reads the entries after a delay, so they wait queued
+	for range 3 {
+		commitTestEntry(t, s)
+	}
+	time.Sleep(delay)
+	assert.Equal(t, []uint64{0, 1, 2}, readStreamEntryNumbers(t, slowConn, 3)) //nolint:mnd
+	require.Eventually(t, func() bool { return slow.outbox.pending() == 0 }, time.Second, time.Millisecond)
+
+	histograms := make(map[string]*LatencyHistogram)
+	for _, info := range s.ListClients() {
+		require.NotNil(t, info.QueueDelay)
+		histograms[info.ID] = info.QueueDelay
+	}
+	fastHist, slowHist := histograms[fast.clientID], histograms[slow.clientID]
+	assert.Equal(t, uint64(3), slowHist.Count)
+	assert.GreaterOrEqual(t, slowHist.Min, delay)
+	assert.GreaterOrEqual(t, slowHist.Quantile(0.5), delay) //nolint:mnd
+	assert.Equal(t, uint64(3), fastHist.Count)
+	assert.Less(t, fastHist.Max, delay)
+}
+
+func TestMaxLatency(t *testing.T) {
+	const (
+		maxLatency = 50 * time.Millisecond
+		entries    = 10
+	)
+	s := newTestServer(t, t.TempDir())
+	s.writeTimeout = time.Minute
+
+	// A consumer that never reads blocks the broadcast to the clients without a latency target
+	_, _ = startStalledClient(t, s)
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	type receipt struct {
+		number uint64
+		at     time.Time
+	}
+	received := make(chan receipt, entries)
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- receipt{number: e.Number, at: time.Now()}
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.ExecCommandStartMaxLatency(s.GetHeader().TotalEntries, maxLatency))
+
+	// Each entry is received within the latency target of its commit
+	committed := make([]time.Time, entries)
+	for i := range entries {
+		require.NoError(t, s.StartAtomicOp())
+		_, err = s.AddStreamEntry(1, make([]byte, 100)) //nolint:mnd
+		require.NoError(t, err)
+		committed[i] = time.Now()
+		require.NoError(t, s.CommitAtomicOp())
+		time.Sleep(5 * time.Millisecond) //nolint:mnd
+	}
+	for range entries {
+		select {
+		case r := <-received:
+			assert.LessOrEqual(t, r.at.Sub(committed[r.number]), maxLatency, "entry %d", r.number)
+		case <-time.After(time.Second):
+			t.Fatal("entries not received")
+		}
+	}
+
+	var info ClientInfo
+	for _, info = range s.ListClients() {
+		if info.MaxLatency > 0 {
+			break
+		}
+	}
+	assert.Equal(t, maxLatency, info.MaxLatency)
+	assert.Zero(t, info.LatencyMisses)
+
+	// Stopping clears the target
+	require.NoError(t, c.ExecCommandStop())
+	for _, info = range s.ListClients() {
+		assert.Zero(t, info.MaxLatency)
+	}
+}
@@ -0,0 +1,30 @@
+package datastreamer
+
+import (
+	"os"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// FileLocking type for the strategy against concurrent writers of a stream file
+type FileLocking uint8
+
+const (
+	// FileLockExclusive (default) takes an advisory exclusive lock (flock) on the stream file while it's open for
+	// writing, so a second writer (from this or another process) fails fast with ErrFileLocked. The lock is taken
+	// before anything is written to the file. Readers don't lock the file.
+	FileLockExclusive FileLocking = iota
+	// FileLockNone doesn't lock the stream file, the caller must ensure there is a single writer
+	FileLockNone
+)
+
+// lockFile takes the exclusive lock of an open stream file without waiting, failing with ErrFileLocked if another
+// writer holds it. The lock is released when the file is closed.
+func lockFile(file *os.File, fileName string) error {
+	err := flockExclusive(file)
+	if err != nil {
+		log.Errorf("Stream file %s locked by another writer: %v", fileName, err)
+		return ErrFileLocked
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package datastreamer
+
+import "os"
+
+// flockExclusive doesn't lock, advisory file locks are not supported on this platform
+func flockExclusive(_ *os.File) error {
+	return nil
+}
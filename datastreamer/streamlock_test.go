@@ -0,0 +1,96 @@
+//go:build unix
+
+package datastreamer
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const lockHelperEnv = "DATASTREAMER_LOCK_HELPER_FILE"
+
+// TestFileLockHelper opens for writing the stream file given by the parent test, exiting with code 3 if it's locked
+func TestFileLockHelper(t *testing.T) {
+	fileName := os.Getenv(lockHelperEnv)
+	if fileName == "" {
+		t.Skip("helper process of TestFileLockAcrossProcesses")
+	}
+
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	if errors.Is(err, ErrFileLocked) {
+		os.Exit(3) //nolint:mnd
+	}
+	require.NoError(t, err)
+	require.NoError(t, sf.Close())
+}
+
+// openInOtherProcess opens a stream file for writing from a new process, returning its exit code
+func openInOtherProcess(t *testing.T, fileName string) int {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFileLockHelper$") //nolint:gosec
+	cmd.Env = append(os.Environ(), lockHelperEnv+"="+fileName)
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	require.NoError(t, err, string(out))
+	return 0
+}
+
+func TestFileLockAcrossProcesses(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "locked.bin")
+
+	// Locked while open for writing
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, openInOtherProcess(t, fileName)) //nolint:mnd
+
+	// A second writer in the same process fails too, without touching the file
+	sizeBefore, err := os.Stat(fileName)
+	require.NoError(t, err)
+	_, err = NewStreamFile(fileName, 1, 12345, 1)
+	require.ErrorIs(t, err, ErrFileLocked)
+	sizeAfter, err := os.Stat(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, sizeBefore.Size(), sizeAfter.Size())
+
+	// The migration can't replace it
+	require.ErrorIs(t, MigrateStreamVersion(fileName, 2), ErrFileLocked) //nolint:mnd
+
+	// Readers are not blocked
+	ro, _, err := OpenStreamFileTolerant(fileName)
+	require.NoError(t, err)
+	require.NoError(t, ro.Close())
+
+	// Released when closed
+	require.NoError(t, sf.Close())
+	assert.Equal(t, 0, openInOtherProcess(t, fileName))
+
+	// Not locked if disabled
+	sf, err = NewStreamFileWithOptions(fileName, 1, 12345, 1, StreamFileOptions{Locking: FileLockNone})
+	require.NoError(t, err)
+	assert.Equal(t, 0, openInOtherProcess(t, fileName))
+	require.NoError(t, sf.Close())
+}
+
+func TestFileLockReleasedOnOpenError(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "bad.bin")
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	require.NoError(t, sf.Close())
+	patchFile(t, fileName, 0, []byte{0})
+
+	// A failed open doesn't keep the file locked
+	_, err = NewStreamFile(fileName, 1, 12345, 1)
+	require.ErrorIs(t, err, ErrBadFileFormat)
+	_, err = NewStreamFile(fileName, 1, 12345, 1)
+	require.ErrorIs(t, err, ErrBadFileFormat)
+}
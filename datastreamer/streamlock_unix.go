@@ -0,0 +1,13 @@
+//go:build unix
+
+package datastreamer
+
+import (
+	"os"
+	"syscall"
+)
+
+// flockExclusive takes an exclusive advisory lock of a file without blocking
+func flockExclusive(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
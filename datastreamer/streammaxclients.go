@@ -0,0 +1,55 @@
+package datastreamer
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// rejectLinger is the time a rejected connection is kept open for the client to read the result before closing it
+const rejectLinger = time.Second
+
+// SetMaxClients sets the max number of clients connected at once, before Start: a new connection beyond it gets the
+// CmdErrMaxClients result and is closed, the clients connected are not affected. With 0 (default) the limit is
+// maxConnections.
+func (s *StreamServer) SetMaxClients(n int) {
+	s.maxClients = n
+}
+
+// clientsLimit returns the max number of clients connected at once
+func (s *StreamServer) clientsLimit() int {
+	if s.maxClients > 0 {
+		return s.maxClients
+	}
+	return maxConnections
+}
+
+// acquireClient counts a new client connection, returns false if the max clients are already connected
+func (s *StreamServer) acquireClient() bool {
+	if s.connectedClients.Add(1) > int64(s.clientsLimit()) {
+		s.connectedClients.Add(-1)
+		return false
+	}
+	return true
+}
+
+// releaseClient discounts a client disconnected
+func (s *StreamServer) releaseClient() {
+	s.connectedClients.Add(-1)
+}
+
+// rejectClient answers a new connection beyond the max clients with the CmdErrMaxClients result, then waits for the
+// client to close it (reading what it sends meanwhile) so the result is not lost
+func (s *StreamServer) rejectClient(conn net.Conn, clientID string) {
+	log.Warnf("Client %s rejected, maximum number of clients reached (%d)", clientID, s.clientsLimit())
+	err := s.sendResultEntry(uint32(CmdErrMaxClients), StrCommandErrors[CmdErrMaxClients],
+		&client{conn: conn, clientID: clientID})
+	if err != nil {
+		return
+	}
+	if conn.SetReadDeadline(time.Now().Add(rejectLinger)) == nil {
+		_, _ = io.Copy(io.Discard, conn)
+	}
+}
@@ -0,0 +1,76 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readRejection reads the result sent to a connection rejected, and checks the server closes it
+func readRejection(t *testing.T, conn net.Conn) ResultEntry {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second))) //nolint:mnd
+	head := make([]byte, FixedSizeResultEntry)
+	_, err := io.ReadFull(conn, head)
+	require.NoError(t, err)
+	require.Equal(t, byte(PtResult), head[0])
+	errorStr := make([]byte, binary.BigEndian.Uint32(head[1:5])-FixedSizeResultEntry)
+	_, err = io.ReadFull(conn, errorStr)
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	return ResultEntry{errorNum: binary.BigEndian.Uint32(head[5:9]), errorStr: errorStr}
+}
+
+func TestMaxClients(t *testing.T) {
+	const maxClients = 2
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) { s.SetMaxClients(maxClients) })
+
+	// Clients up to the limit streaming
+	received := make([]chan FileEntry, maxClients)
+	for i := range received {
+		received[i] = make(chan FileEntry, 1)
+		c, err := NewClient(testServerAddr(s), 1)
+		require.NoError(t, err)
+		c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+			received[i] <- *e
+			return nil
+		})
+		startClientUntilCleanup(t, c)
+		require.NoError(t, c.ExecCommandStart(0))
+	}
+
+	// The next one rejected
+	conn, err := net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	r := readRejection(t, conn)
+	conn.Close()
+	assert.Equal(t, uint32(CmdErrMaxClients), r.errorNum)
+	assert.Equal(t, StrCommandErrors[CmdErrMaxClients], string(r.errorStr))
+	assert.Equal(t, int64(maxClients), s.connectedClients.Load())
+
+	// The first ones keep streaming
+	commitTestEntry(t, s)
+	for i := range received {
+		select {
+		case e := <-received[i]:
+			assert.Equal(t, uint64(0), e.Number)
+		case <-time.After(5 * time.Second): //nolint:mnd
+			t.Fatalf("entry not received by client %d", i)
+		}
+	}
+
+	// A client rejected by the server stops connecting
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	c.conn, err = net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	defer c.conn.Close()
+	require.ErrorIs(t, c.negotiateHeartbeat(), ErrMaxClientsReached)
+}
@@ -0,0 +1,205 @@
+package datastreamer
+
+import (
+	"sync"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// MemoryStreamStore type to keep a stream in memory without a stream file, e.g. for tests or ephemeral streams. It
+// has the read methods of S3StreamStore (GetHeader, GetEntry, GetIterator, GetBookmark) and the atomic operations of
+// the server (StartAtomicOp, AddStreamEntry, AddStreamBookmark, CommitAtomicOp, RollbackAtomicOp), with the same entry
+// numbering and rollback behavior as a stream file: both implement StreamStore. The header TotalLength counts the
+// header page and the entries, there are no data pages (no padding nor page footers).
+type MemoryStreamStore struct {
+	mutex     sync.Mutex
+	header    HeaderEntry       // Header of the committed entries
+	entries   []FileEntry       // Entries added, including the atomic operation in progress
+	bookmarks map[string]uint64 // Bookmarks index, including the atomic operation in progress
+	started   bool              // Atomic operation in progress
+}
+
+// MemoryIterator type to read the committed entries of a memory stream store sequentially from a start entry number.
+// When the end is reached it can be called again to pick up the entries committed since then.
+type MemoryIterator struct {
+	store *MemoryStreamStore
+	next  uint64    // Entry number of the next entry
+	entry FileEntry // Entry read by the latest call to Next
+}
+
+// NewMemoryStreamStore creates an empty memory stream store
+func NewMemoryStreamStore(version uint8, systemID uint64, st StreamType) *MemoryStreamStore {
+	return &MemoryStreamStore{
+		header: HeaderEntry{
+			packetType:  PtHeader,
+			headLength:  headerSize,
+			Version:     version,
+			SystemID:    systemID,
+			streamType:  st,
+			TotalLength: PageHeaderSize,
+		},
+		bookmarks: make(map[string]uint64),
+	}
+}
+
+// GetHeader returns the header of the committed entries
+func (m *MemoryStreamStore) GetHeader() HeaderEntry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.header
+}
+
+// StartAtomicOp starts a new atomic operation
+func (m *MemoryStreamStore) StartAtomicOp() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.started {
+		log.Errorf("AtomicOp already started and in progress after entry %d", m.header.TotalEntries)
+		return ErrStartAtomicOpNotAllowed
+	}
+	m.started = true
+	return nil
+}
+
+// AddStreamEntry adds a new entry in the current atomic operation, the data may be empty (zero-length entry)
+func (m *MemoryStreamStore) AddStreamEntry(etype EntryType, data []byte) (uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.addStream(etype, data)
+}
+
+// AddStreamBookmark adds a new bookmark in the current atomic operation, found by GetBookmark until it's rolled back
+func (m *MemoryStreamStore) AddStreamBookmark(bookmark []byte) (uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entryNum, err := m.addStream(EtBookmark, bookmark)
+	if err != nil {
+		return 0, err
+	}
+	m.bookmarks[string(bookmark)] = entryNum
+	return entryNum, nil
+}
+
+// addStream adds a new entry with the next entry number in the current atomic operation
+func (m *MemoryStreamStore) addStream(etype EntryType, data []byte) (uint64, error) {
+	if !m.started {
+		log.Errorf("Add stream entry not allowed, AtomicOp is not started")
+		return 0, ErrAddEntryNotAllowed
+	}
+	if etype&tombstoneFlag != 0 {
+		log.Errorf("Invalid entry type %d, highest bit reserved", etype)
+		return 0, ErrInvalidEntryType
+	}
+
+	entryNum := m.header.firstEntry + uint64(len(m.entries))
+	m.entries = append(m.entries, FileEntry{
+		packetType: PtData,
+		Length:     FixedSizeFileEntry + uint32(len(data)),
+		Type:       etype,
+		Number:     entryNum,
+		Data:       append([]byte{}, data...),
+	})
+	return entryNum, nil
+}
+
+// CommitAtomicOp commits the current atomic operation
+func (m *MemoryStreamStore) CommitAtomicOp() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.started {
+		log.Errorf("commit not allowed, atomic operation is not in the started state")
+		return ErrCommitNotAllowed
+	}
+	for _, e := range m.entries[m.header.TotalEntries-m.header.firstEntry:] {
+		m.header.TotalLength += uint64(e.Length)
+	}
+	m.header.TotalEntries = m.header.firstEntry + uint64(len(m.entries))
+	m.started = false
+	return nil
+}
+
+// RollbackAtomicOp cancels the current atomic operation, discarding its entries and bookmarks
+func (m *MemoryStreamStore) RollbackAtomicOp() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.started {
+		log.Errorf("Rollback not allowed, AtomicOp is not in the started state")
+		return ErrRollbackNotAllowed
+	}
+	committed := m.header.TotalEntries - m.header.firstEntry
+	for _, e := range m.entries[committed:] {
+		if e.Type == EtBookmark {
+			delete(m.bookmarks, string(e.Data))
+		}
+	}
+	m.entries = m.entries[:committed]
+	m.started = false
+	return nil
+}
+
+// GetEntry returns a committed entry
+func (m *MemoryStreamStore) GetEntry(entryNum uint64) (FileEntry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if entryNum < m.header.firstEntry || entryNum >= m.header.TotalEntries {
+		log.Errorf("Invalid entry number %d, committed entries %d to %d", entryNum, m.header.firstEntry,
+			m.header.TotalEntries)
+		return FileEntry{}, ErrInvalidEntryNumber
+	}
+	return m.entryCopy(entryNum), nil
+}
+
+// entryCopy returns an entry with a copy of its data
+func (m *MemoryStreamStore) entryCopy(entryNum uint64) FileEntry {
+	e := m.entries[entryNum-m.header.firstEntry]
+	e.Data = append([]byte{}, e.Data...)
+	return e
+}
+
+// GetBookmark returns the entry number of a bookmark, including the bookmarks of the atomic operation in progress
+func (m *MemoryStreamStore) GetBookmark(bookmark []byte) (uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entryNum, ok := m.bookmarks[string(bookmark)]
+	if !ok {
+		return 0, leveldb.ErrNotFound
+	}
+	return entryNum, nil
+}
+
+// GetIterator returns an iterator over the committed entries starting from an entry number
+func (m *MemoryStreamStore) GetIterator(fromEntry uint64, opts IteratorOptions) (*MemoryIterator, error) {
+	header := m.GetHeader()
+	if fromEntry < header.firstEntry {
+		log.Errorf("Invalid starting entry number %d for iterator, first entry is %d", fromEntry, header.firstEntry)
+		return nil, ErrInvalidEntryNumber
+	}
+	if fromEntry > header.TotalEntries && opts.BeyondTail == BeyondTailError {
+		log.Errorf("Starting entry number %d for iterator beyond the tail %d", fromEntry, header.TotalEntries)
+		return nil, ErrStartBeyondTail
+	}
+	return &MemoryIterator{store: m, next: fromEntry}, nil
+}
+
+// Next reads the next committed entry, returns true at the end of the entries
+func (it *MemoryIterator) Next() (bool, error) {
+	it.store.mutex.Lock()
+	defer it.store.mutex.Unlock()
+	if it.next >= it.store.header.TotalEntries {
+		return true, nil
+	}
+	it.entry = it.store.entryCopy(it.next)
+	it.next++
+	return false, nil
+}
+
+// GetEntry returns the entry read by the latest call to Next
+func (it *MemoryIterator) GetEntry() FileEntry {
+	return it.entry
+}
+
+// End finalizes the iterator
+func (it *MemoryIterator) End() {
+	it.next = it.store.GetHeader().TotalEntries
+}
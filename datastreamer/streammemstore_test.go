@@ -0,0 +1,134 @@
+package datastreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// testStreamStore is the method set of the stores compared, the server and the memory stream store, without their
+// iterators of different types
+type testStreamStore interface {
+	StreamReader
+	StreamWriter
+}
+
+// iterateStore returns the committed entries of a store from an entry number read with its iterator
+func iterateStore[I StreamIterator](t *testing.T, s StreamStore[I], fromEntry uint64) []FileEntry {
+	t.Helper()
+
+	it, err := s.GetIterator(fromEntry, IteratorOptions{})
+	require.NoError(t, err)
+	defer it.End()
+	var entries []FileEntry
+	for {
+		end, err := it.Next()
+		require.NoError(t, err)
+		if end {
+			return entries
+		}
+		entries = append(entries, it.GetEntry())
+	}
+}
+
+func TestMemoryStreamStore(t *testing.T) {
+	server := newTestServer(t, t.TempDir())
+	memory := NewMemoryStreamStore(1, 137, 1) //nolint:mnd
+	stores := []testStreamStore{server, memory}
+
+	// Same results and errors on both stores
+	same := func(op func(s testStreamStore) (any, error)) {
+		t.Helper()
+		want, wantErr := op(server)
+		got, err := op(memory)
+		assert.Equal(t, want, got)
+		assert.Equal(t, wantErr, err)
+	}
+	add := func(etype EntryType, data []byte) func(s testStreamStore) (any, error) {
+		return func(s testStreamStore) (any, error) { return s.AddStreamEntry(etype, data) }
+	}
+	bookmark := func(b []byte) func(s testStreamStore) (any, error) {
+		return func(s testStreamStore) (any, error) { return s.AddStreamBookmark(b) }
+	}
+	getBookmark := func(b []byte) func(s testStreamStore) (any, error) {
+		return func(s testStreamStore) (any, error) { return s.GetBookmark(b) }
+	}
+	start := func(s testStreamStore) (any, error) { return nil, s.StartAtomicOp() }
+	commit := func(s testStreamStore) (any, error) { return nil, s.CommitAtomicOp() }
+	rollback := func(s testStreamStore) (any, error) { return nil, s.RollbackAtomicOp() }
+
+	// Outside an atomic operation
+	same(add(1, []byte{1}))
+	same(commit)
+	same(rollback)
+
+	// Committed entries, zero-length included
+	same(start)
+	same(start)
+	same(bookmark([]byte("b0")))
+	same(add(1, []byte{1, 2, 3}))
+	same(add(2, nil))
+	same(add(EntryType(tombstoneFlag), []byte{1}))
+	same(getBookmark([]byte("b0")))
+	same(commit)
+
+	// Rolled back entries and bookmarks, numbers reused
+	same(start)
+	same(bookmark([]byte("b1")))
+	same(add(1, []byte{4}))
+	same(getBookmark([]byte("b1")))
+	same(rollback)
+	same(getBookmark([]byte("b1")))
+	same(start)
+	same(add(3, []byte{5, 6}))
+	same(bookmark([]byte("b0")))
+	same(commit)
+
+	// Uncommitted entries not readable
+	same(start)
+	same(add(1, []byte{7}))
+	same(func(s testStreamStore) (any, error) { return s.GetEntry(5) }) //nolint:mnd
+
+	// Committed entries, header and bookmarks
+	assert.Equal(t, server.GetHeader().TotalEntries, memory.GetHeader().TotalEntries)
+	for num := range server.GetHeader().TotalEntries + 1 {
+		same(func(s testStreamStore) (any, error) { return s.GetEntry(num) })
+	}
+	same(getBookmark([]byte("b0")))
+	_, err := memory.GetBookmark([]byte("b1"))
+	require.ErrorIs(t, err, leveldb.ErrNotFound)
+
+	// Iterators from each entry
+	for from := range server.GetHeader().TotalEntries + 1 {
+		assert.Equal(t, iterateStore(t, server, from), iterateStore(t, memory, from))
+	}
+
+	// Iterators at the tail, picking up the entries committed later
+	sit, err := server.GetIterator(server.GetHeader().TotalEntries, IteratorOptions{})
+	require.NoError(t, err)
+	defer sit.End()
+	mit, err := memory.GetIterator(memory.GetHeader().TotalEntries, IteratorOptions{})
+	require.NoError(t, err)
+	defer mit.End()
+	for _, s := range stores {
+		require.NoError(t, s.CommitAtomicOp())
+	}
+	end, err := sit.Next()
+	require.NoError(t, err)
+	require.False(t, end)
+	end, err = mit.Next()
+	require.NoError(t, err)
+	require.False(t, end)
+	assert.Equal(t, sit.GetEntry(), mit.GetEntry())
+
+	// Iterators beyond the tail
+	_, err = memory.GetIterator(memory.GetHeader().TotalEntries+1, IteratorOptions{})
+	require.ErrorIs(t, err, ErrStartBeyondTail)
+	mit, err = memory.GetIterator(memory.GetHeader().TotalEntries+1, IteratorOptions{BeyondTail: BeyondTailWait})
+	require.NoError(t, err)
+	end, err = mit.Next()
+	require.NoError(t, err)
+	assert.True(t, end)
+}
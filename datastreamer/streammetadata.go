@@ -0,0 +1,149 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// Metadata type for the application metadata stored along with the stream data
+type Metadata map[string]string
+
+// MetadataCodecID type for the identifier of a metadata codec recorded in the file header
+type MetadataCodecID uint8
+
+const (
+	MetadataCodecJSON   MetadataCodecID = 0 // MetadataCodecJSON is the JSON metadata codec (default)
+	MetadataCodecBinary MetadataCodecID = 1 // MetadataCodecBinary is the compact binary metadata codec
+)
+
+// MetadataCodec interface to encode and decode the metadata section
+type MetadataCodec interface {
+	ID() MetadataCodecID
+	Encode(m Metadata) ([]byte, error)
+	Decode(b []byte) (Metadata, error)
+}
+
+var (
+	metadataCodecs      = map[MetadataCodecID]MetadataCodec{}
+	mutexMetadataCodecs sync.RWMutex
+)
+
+func init() {
+	metadataCodecs[MetadataCodecJSON] = JSONMetadataCodec{}
+	metadataCodecs[MetadataCodecBinary] = BinaryMetadataCodec{}
+}
+
+// RegisterMetadataCodec registers a custom metadata codec so files recording its ID can be decoded
+func RegisterMetadataCodec(c MetadataCodec) error {
+	mutexMetadataCodecs.Lock()
+	defer mutexMetadataCodecs.Unlock()
+
+	if _, exists := metadataCodecs[c.ID()]; exists {
+		log.Errorf("Metadata codec %d already registered", c.ID())
+		return ErrMetadataCodecRegistered
+	}
+	metadataCodecs[c.ID()] = c
+	return nil
+}
+
+// GetMetadataCodec returns the registered metadata codec for an ID
+func GetMetadataCodec(id MetadataCodecID) (MetadataCodec, error) {
+	mutexMetadataCodecs.RLock()
+	defer mutexMetadataCodecs.RUnlock()
+
+	c, ok := metadataCodecs[id]
+	if !ok {
+		log.Errorf("Unknown metadata codec %d", id)
+		return nil, ErrUnknownMetadataCodec
+	}
+	return c, nil
+}
+
+// JSONMetadataCodec type for the JSON metadata codec
+type JSONMetadataCodec struct{}
+
+// ID returns the JSON codec identifier
+func (JSONMetadataCodec) ID() MetadataCodecID {
+	return MetadataCodecJSON
+}
+
+// Encode encodes the metadata as a JSON object
+func (JSONMetadataCodec) Encode(m Metadata) ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// Decode decodes the metadata from a JSON object
+func (JSONMetadataCodec) Decode(b []byte) (Metadata, error) {
+	m := Metadata{}
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BinaryMetadataCodec type for the compact binary metadata codec.
+// Format: uvarint count, then for each key in order uvarint length + key and uvarint length + value.
+type BinaryMetadataCodec struct{}
+
+// ID returns the binary codec identifier
+func (BinaryMetadataCodec) ID() MetadataCodecID {
+	return MetadataCodecBinary
+}
+
+// Encode encodes the metadata in the compact binary format
+func (BinaryMetadataCodec) Encode(m Metadata) ([]byte, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	be := binary.AppendUvarint(nil, uint64(len(keys)))
+	for _, k := range keys {
+		be = binary.AppendUvarint(be, uint64(len(k)))
+		be = append(be, k...)
+		be = binary.AppendUvarint(be, uint64(len(m[k])))
+		be = append(be, m[k]...)
+	}
+	return be, nil
+}
+
+// Decode decodes the metadata from the compact binary format
+func (BinaryMetadataCodec) Decode(b []byte) (Metadata, error) {
+	count, n := binary.Uvarint(b)
+	if n <= 0 || count > uint64(len(b)) {
+		return nil, ErrDecodingMetadata
+	}
+	b = b[n:]
+
+	m := make(Metadata, count)
+	readString := func() (string, bool) {
+		length, n := binary.Uvarint(b)
+		if n <= 0 || length > uint64(len(b)-n) {
+			return "", false
+		}
+		str := string(b[n : n+int(length)])
+		b = b[n+int(length):]
+		return str, true
+	}
+	for range count {
+		k, ok := readString()
+		if !ok {
+			return nil, ErrDecodingMetadata
+		}
+		v, ok := readString()
+		if !ok {
+			return nil, ErrDecodingMetadata
+		}
+		m[k] = v
+	}
+	if len(b) != 0 {
+		return nil, ErrDecodingMetadata
+	}
+	return m, nil
+}
@@ -0,0 +1,85 @@
+package datastreamer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataCodecRoundTrip(t *testing.T) {
+	inputs := []Metadata{
+		{},
+		{"batch": "42", "fork": "9", "empty": ""},
+		{"ключ": "значение", "emoji": "\U0001F680", "ctrl": "\x00\x01\n"},
+	}
+
+	for _, codec := range []MetadataCodec{JSONMetadataCodec{}, BinaryMetadataCodec{}} {
+		for _, m := range inputs {
+			b, err := codec.Encode(m)
+			require.NoError(t, err)
+			decoded, err := codec.Decode(b)
+			require.NoError(t, err)
+			assert.Equal(t, m, decoded, "codec %d", codec.ID())
+		}
+	}
+}
+
+func TestBinaryMetadataCodecDecodeErrors(t *testing.T) {
+	codec := BinaryMetadataCodec{}
+	b, err := codec.Encode(Metadata{"key": "value"})
+	require.NoError(t, err)
+
+	for i := 0; i < len(b); i++ {
+		_, err = codec.Decode(b[:i])
+		assert.ErrorIs(t, err, ErrDecodingMetadata, "truncated at %d", i)
+	}
+	_, err = codec.Decode(append(b, 0))
+	assert.ErrorIs(t, err, ErrDecodingMetadata)
+}
+
+func TestMetadataCodecRecordedInHeader(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "metadata.bin")
+
+	sf, err := NewStreamFileWithOptions(fileName, 1, 12345, 1, StreamFileOptions{MetadataCodec: BinaryMetadataCodec{}})
+	require.NoError(t, err)
+	require.NoError(t, sf.Close())
+
+	// Reopening without options decodes with the codec recorded at creation
+	sf, err = NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	assert.Equal(t, MetadataCodecBinary, sf.MetadataCodec().ID())
+	require.NoError(t, sf.Close())
+
+	_, err = NewStreamFileWithOptions(fileName, 1, 12345, 1, StreamFileOptions{MetadataCodec: JSONMetadataCodec{}})
+	assert.ErrorIs(t, err, ErrMetadataCodecMismatch)
+
+	// Files created without options use JSON
+	sf, err = NewStreamFile(filepath.Join(t.TempDir(), "default.bin"), 1, 12345, 1)
+	require.NoError(t, err)
+	assert.Equal(t, MetadataCodecJSON, sf.MetadataCodec().ID())
+	require.NoError(t, sf.Close())
+
+	assert.ErrorIs(t, RegisterMetadataCodec(JSONMetadataCodec{}), ErrMetadataCodecRegistered)
+
+	// Files with a codec not registered can be opened
+	fileName = filepath.Join(t.TempDir(), "custom.bin")
+	sf, err = NewStreamFileWithOptions(fileName, 1, 12345, 1, StreamFileOptions{MetadataCodec: customMetadataCodec{}})
+	require.NoError(t, err)
+	require.NoError(t, sf.Close())
+	sf, err = NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	assert.Nil(t, sf.MetadataCodec())
+	require.NoError(t, sf.Close())
+}
+
+// customMetadataCodec type for a custom metadata codec not registered
+type customMetadataCodec struct {
+	JSONMetadataCodec
+}
+
+// ID returns the custom codec identifier
+func (customMetadataCodec) ID() MetadataCodecID {
+	return 200 //nolint:mnd
+}
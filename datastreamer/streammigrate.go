@@ -0,0 +1,205 @@
+package datastreamer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// VersionMigration type for the function converting the data of an entry (bookmarks excluded) from a stream version
+// to the next one (e.g. adding the fields the new version requires)
+type VersionMigration func(e FileEntry) ([]byte, error)
+
+var (
+	versionMigrations      = map[uint8]VersionMigration{}
+	mutexVersionMigrations sync.RWMutex
+)
+
+// RegisterVersionMigration registers the migration of the entries data from a stream version to the next one
+func RegisterVersionMigration(fromVersion uint8, m VersionMigration) error {
+	mutexVersionMigrations.Lock()
+	defer mutexVersionMigrations.Unlock()
+
+	if _, exists := versionMigrations[fromVersion]; exists {
+		log.Errorf("Migration from version %d already registered", fromVersion)
+		return ErrVersionMigrationRegistered
+	}
+	versionMigrations[fromVersion] = m
+	return nil
+}
+
+// getVersionMigration returns the registered migration from a stream version to the next one (nil: data unchanged)
+func getVersionMigration(fromVersion uint8) VersionMigration {
+	mutexVersionMigrations.RLock()
+	defer mutexVersionMigrations.RUnlock()
+	return versionMigrations[fromVersion]
+}
+
+// MigrateBackupName returns the backup file name of a stream file migrated from a version
+func MigrateBackupName(fileName string, version uint8) string {
+	return fmt.Sprintf("%s.v%d.bak", fileName, version)
+}
+
+// MigrateStreamVersion rewrites the committed entries of a stream file with a newer stream version, applying to the
+// data of each entry the registered migrations from its version up to the target version (one step per version).
+// Entry numbers, tombstones and header settings are preserved, so the bookmarks and the commit journal remain valid.
+// The original file is kept as MigrateBackupName. Fails with ErrFileLocked if the file is open for writing.
+func MigrateStreamVersion(fileName string, targetVersion uint8) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		log.Errorf("Error opening file %s to migrate: %v", fileName, err)
+		return err
+	}
+	defer file.Close()
+
+	// The file is replaced, so no writer can be using it
+	err = lockFile(file, fileName)
+	if err != nil {
+		return err
+	}
+
+	header, err := readFileHeader(file)
+	if err != nil {
+		return err
+	}
+	if header.encryption != encryptionNone {
+		log.Errorf("File %s to migrate is encrypted", fileName)
+		return ErrFileEncrypted
+	}
+	if targetVersion <= header.Version {
+		log.Errorf("Invalid target version %d for file %s with version %d", targetVersion, fileName, header.Version)
+		return ErrInvalidTargetVersion
+	}
+	backupName := MigrateBackupName(fileName, header.Version)
+	if _, err := os.Stat(backupName); err == nil {
+		log.Errorf("Backup file %s already exists", backupName)
+		return ErrOutputFileExists
+	}
+
+	// Write the migrated entries into a new file
+	tmpName := fileName + ".migrating"
+	err = writeMigratedFile(file, header, tmpName, targetVersion)
+	if err != nil {
+		log.Errorf("Error migrating file %s to version %d: %v", fileName, targetVersion, err)
+		if err2 := os.Remove(tmpName); err2 != nil && !errors.Is(err2, os.ErrNotExist) {
+			log.Errorf("Error removing migration file %s: %v", tmpName, err2)
+		}
+		return err
+	}
+
+	// Keep the original file as backup and replace it
+	err = os.Rename(fileName, backupName)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpName, fileName)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("File %s migrated from version %d to version %d, backup %s", fileName, header.Version, targetVersion,
+		backupName)
+	return nil
+}
+
+// createMigratedFile creates a stream file with a stream version and the header settings of a source file
+func createMigratedFile(header HeaderEntry, fileName string, version uint8) (*StreamFile, error) {
+	out, err := NewStreamFileWithOptions(fileName, version, header.SystemID, header.streamType,
+		StreamFileOptions{CreateOnly: true, PageSize: header.PageSize(), Compression: header.compression})
+	if err != nil {
+		return nil, err
+	}
+
+	// Same header settings as the source file
+	out.mutexHeader.Lock()
+	out.header.firstEntry = header.firstEntry
+	out.header.TotalEntries = header.firstEntry
+	out.header.numbering = header.numbering
+	out.header.metaCodec = header.metaCodec
+	out.mutexHeader.Unlock()
+
+	return out, nil
+}
+
+// writeMigratedFile creates a stream file with the target version and writes the migrated entries of the source file
+func writeMigratedFile(src *os.File, header HeaderEntry, fileName string, targetVersion uint8) error {
+	out, err := createMigratedFile(header, fileName, targetVersion)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if out.fileHeader != nil {
+			_ = out.fileHeader.Close()
+		}
+	}()
+
+	// Version of the first entries, changed by the version change markers
+	entryVersion, err := firstStreamVersion(src, header)
+	if err != nil {
+		out.closeFiles()
+		return err
+	}
+
+	_, err = walkEntries(src, header.TotalLength, header.PageSize(), func(_ uint64, e FileEntry) error {
+		err = decompressEntry(header.compression, &e)
+		if err != nil {
+			return err
+		}
+		if _, to, ok := VersionChange(e); ok {
+			// The migrated entries have the target version
+			entryVersion = to
+			e.Data = []byte{targetVersion, targetVersion}
+		} else if e.Type != EtBookmark {
+			// The data read is shared with the next entries
+			e.Data = bytes.Clone(e.Data)
+			for version := entryVersion; version < targetVersion; version++ {
+				migration := getVersionMigration(version)
+				if migration == nil {
+					continue
+				}
+				e.Data, err = migration(e)
+				if err != nil {
+					return err
+				}
+			}
+			e.Length = FixedSizeFileEntry + uint32(len(e.Data))
+		}
+		return out.AddFileEntry(e)
+	})
+	if err != nil {
+		out.closeFiles()
+		return err
+	}
+	if out.header.TotalEntries != header.TotalEntries {
+		out.closeFiles()
+		log.Errorf("Migrated %d entries but the header has %d", out.header.TotalEntries, header.TotalEntries)
+		return ErrBadFileFormat
+	}
+
+	// Closing the stream file writes the header
+	return out.Close()
+}
+
+// errVersionFound stops the walk of the entries when the first version change marker is found
+var errVersionFound = errors.New("version found")
+
+// firstStreamVersion returns the version of the first entries of a stream file: the previous version of its first
+// version change marker, or the header version if it has none
+func firstStreamVersion(src *os.File, header HeaderEntry) (uint8, error) {
+	version := header.Version
+	_, err := walkEntries(src, header.TotalLength, header.PageSize(), func(_ uint64, e FileEntry) error {
+		if from, _, ok := VersionChange(e); ok {
+			version = from
+			return errVersionFound
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errVersionFound) {
+		return 0, err
+	}
+	return version, nil
+}
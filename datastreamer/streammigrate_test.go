@@ -0,0 +1,78 @@
+package datastreamer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateStreamVersion(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "stream.bin")
+
+	// Version 1 file with bookmarks and a tombstoned entry
+	s := newTestServer(t, dir)
+	require.NoError(t, s.StartAtomicOp())
+	var err error
+	for i := range 3 {
+		_, err = s.AddStreamBookmark([]byte{byte(i)})
+		require.NoError(t, err)
+		_, err = s.AddStreamEntry(1, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.Tombstone(3)) //nolint:mnd
+	require.NoError(t, s.Close())
+
+	// Version 2 appends a byte to the data of the entries
+	require.NoError(t, RegisterVersionMigration(1, func(e FileEntry) ([]byte, error) {
+		return append(e.Data, 0xff), nil //nolint:mnd
+	}))
+	t.Cleanup(func() {
+		mutexVersionMigrations.Lock()
+		delete(versionMigrations, 1)
+		mutexVersionMigrations.Unlock()
+	})
+	assert.ErrorIs(t, RegisterVersionMigration(1, nil), ErrVersionMigrationRegistered)
+
+	assert.ErrorIs(t, MigrateStreamVersion(fileName, 1), ErrInvalidTargetVersion)
+	require.NoError(t, MigrateStreamVersion(fileName, 3)) //nolint:mnd
+
+	// Original kept as backup
+	header, err := ReadHeader(MigrateBackupName(fileName, 1))
+	require.NoError(t, err)
+	assert.Equal(t, uint8(1), header.Version)
+
+	// Migrated file readable with the same entry numbers and bookmarks
+	header, err = ReadHeader(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(3), header.Version)       //nolint:mnd
+	assert.Equal(t, uint64(6), header.TotalEntries) //nolint:mnd
+
+	s = newTestServer(t, dir)
+	for i := range 3 {
+		entryNum, err := s.GetBookmark([]byte{byte(i)})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i*2), entryNum) //nolint:mnd
+
+		bookmark, err := s.GetEntry(entryNum)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{byte(i)}, bookmark.Data)
+
+		entry, err := s.GetEntry(entryNum + 1)
+		if entryNum+1 == 3 { //nolint:mnd
+			assert.ErrorIs(t, err, ErrEntryTombstoned)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, []byte{byte(i), 0xff}, entry.Data)
+	}
+
+	// An existing backup is not overwritten
+	require.NoError(t, s.Close())
+	require.NoError(t, os.WriteFile(MigrateBackupName(fileName, 3), nil, 0600)) //nolint:mnd
+	assert.ErrorIs(t, MigrateStreamVersion(fileName, 4), ErrOutputFileExists)   //nolint:mnd
+}
@@ -0,0 +1,187 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// mmapReader type for the stream file mapped in memory to read the entries without copying them
+type mmapReader struct {
+	data    []byte       // Current mapping from the start of the file
+	retired [][]byte     // Previous (shorter) mappings, kept until the file is closed as entry views may alias them
+	mutex   sync.RWMutex // Protects the fields above
+}
+
+// region returns the mapping of the file covering a length, mapping the file again (at least doubling the length
+// mapped) when it has grown beyond it
+func (m *mmapReader) region(file *os.File, length uint64) ([]byte, error) {
+	m.mutex.RLock()
+	data := m.data
+	m.mutex.RUnlock()
+	if uint64(len(data)) >= length {
+		return data, nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if uint64(len(m.data)) >= length {
+		return m.data, nil
+	}
+	data, err := mmapFile(file, int(max(length, 2*uint64(len(m.data))))) //nolint:mnd
+	if err != nil {
+		log.Errorf("Error mapping the stream file in memory: %v", err)
+		return nil, err
+	}
+	if m.data != nil {
+		m.retired = append(m.retired, m.data)
+	}
+	m.data = data
+	return data, nil
+}
+
+// close unmaps all the mappings of the file
+func (m *mmapReader) close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, data := range append(m.retired, m.data) {
+		if data == nil {
+			continue
+		}
+		if err := munmapFile(data); err != nil {
+			log.Warnf("Error unmapping the stream file: %v", err)
+		}
+	}
+	m.data, m.retired = nil, nil
+}
+
+// GetEntryView returns a data entry like GetEntry but, with MmapReads enabled, its data is a view of the file mapped
+// in memory instead of a copy. The caller must not modify the data, nor retain it past the next call (or after an
+// update, truncation or closing the file). Entries encrypted or compressed, and files without MmapReads or on
+// platforms without memory mapped files, are copied as in GetEntry.
+func (f *StreamFile) GetEntryView(entryNum uint64) (FileEntry, error) {
+	if f.mmap == nil {
+		return f.GetEntry(entryNum)
+	}
+
+	header := f.getHeaderEntry()
+	if entryNum >= header.TotalEntries || entryNum < header.firstEntry {
+		log.Errorf("Invalid entry number %d for entry view", entryNum)
+		return FileEntry{}, ErrInvalidEntryNumber
+	}
+	data, err := f.mmap.region(f.file, header.TotalLength)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	data = data[:header.TotalLength]
+
+	pos, err := f.mappedEntryPos(data, entryNum)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	length := uint64(binary.BigEndian.Uint32(data[pos+1 : pos+5]))
+	if length < FixedSizeFileEntry || pos+length > uint64(len(data)) {
+		log.Errorf("Error decoding length of entry %d at %d", entryNum, pos)
+		return FileEntry{}, ErrDecodingLengthDataEntry
+	}
+
+	// Check the data page checksum
+	err = f.verifyPage(header, pos)
+	if err != nil {
+		return FileEntry{}, err
+	}
+
+	// The data aliases the mapping, appending to it doesn't write into the file
+	entry, err := DecodeBinaryToFileEntry(data[pos : pos+length : pos+length])
+	if err != nil {
+		return FileEntry{}, err
+	}
+	err = f.loadEntry(&entry)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	return entry, nil
+}
+
+// GetEntry returns a data entry read from the file, tombstoned entries included (flagged)
+func (f *StreamFile) GetEntry(entryNum uint64) (FileEntry, error) {
+	iterator, err := f.iteratorFrom(entryNum, true)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	defer f.iteratorEnd(iterator)
+
+	_, err = f.iteratorNext(iterator)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	return iterator.Entry, nil
+}
+
+// mappedEntryPos returns the position of a data entry in the file mapped, searching the data page by the number of
+// its first entry and then the entry within the page
+func (f *StreamFile) mappedEntryPos(data []byte, entryNum uint64) (uint64, error) {
+	pageSize := uint64(f.pageSize)
+	pages := int((uint64(len(data)) - PageHeaderSize + pageSize - 1) / pageSize)
+
+	// Last data page starting with an entry number not after the one searched
+	var searchErr error
+	page := sort.Search(pages, func(i int) bool {
+		pos := PageHeaderSize + uint64(i)*pageSize
+		if pos+FixedSizeFileEntry > uint64(len(data)) || data[pos] != PtData {
+			if searchErr == nil {
+				log.Errorf("Error data page %d not starting with packet of type data", i)
+				searchErr = ErrPageNotStartingWithEntryData
+			}
+			return true
+		}
+		return binary.BigEndian.Uint64(data[pos+9:pos+17]) > entryNum
+	}) - 1
+	if searchErr != nil {
+		return 0, searchErr
+	}
+	if page < 0 {
+		log.Errorf("Error entry number %d not found in the data pages", entryNum)
+		return 0, ErrEntryNotFound
+	}
+
+	// Entries of the page until the pad
+	pos := PageHeaderSize + uint64(page)*pageSize
+	end := min(pos+pageSize, uint64(len(data)))
+	for pos+FixedSizeFileEntry <= end && data[pos] != PtPadding {
+		if data[pos] != PtData {
+			log.Errorf("Error expecting packet of type data(%d). Read: %d", PtData, data[pos])
+			return 0, ErrExpectingPacketTypeData
+		}
+		if binary.BigEndian.Uint64(data[pos+9:pos+17]) == entryNum {
+			return pos, nil
+		}
+		length := uint64(binary.BigEndian.Uint32(data[pos+1 : pos+5]))
+		if length < FixedSizeFileEntry {
+			log.Errorf("Error decoding length data entry at %d", pos)
+			return 0, ErrDecodingLengthDataEntry
+		}
+		pos += length
+	}
+	log.Errorf("Error entry number %d not found in data page %d", entryNum, page)
+	return 0, ErrEntryNotFound
+}
+
+// enableMmapReads maps the file in memory on the first entry view, if supported on this platform
+func (f *StreamFile) enableMmapReads() {
+	if !mmapSupported {
+		log.Warnf("Memory mapped reads not supported on this platform, the entries of %s are copied", f.fileName)
+		return
+	}
+	f.mmap = &mmapReader{}
+}
+
+// closeMmap unmaps the file, if mapped
+func (f *StreamFile) closeMmap() {
+	if f.mmap != nil {
+		f.mmap.close()
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package datastreamer
+
+import (
+	"errors"
+	"os"
+)
+
+const mmapSupported = false // Memory mapped reads not supported on this platform, the entries are copied
+
+// mmapFile fails, memory mapped files are not supported on this platform
+func mmapFile(_ *os.File, _ int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// munmapFile does nothing, memory mapped files are not supported on this platform
+func munmapFile(_ []byte) error {
+	return nil
+}
@@ -0,0 +1,99 @@
+package datastreamer
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMmapTestServer creates and starts a server on a stream file of small data pages with the given options
+func newMmapTestServer(tb testing.TB, opts StreamFileOptions) *StreamServer {
+	tb.Helper()
+
+	opts.PageSize = MinPageSize
+	s, err := openTestServer(tb, filepath.Join(tb.TempDir(), "stream.bin"), opts, nil)
+	require.NoError(tb, err)
+	return s
+}
+
+func TestGetEntryView(t *testing.T) {
+	s := newMmapTestServer(t, StreamFileOptions{MmapReads: true})
+	require.NotNil(t, s.streamFile.mmap)
+	copied := newMmapTestServer(t, StreamFileOptions{})
+
+	// Entries over several data pages, the same as copied
+	for _, srv := range []*StreamServer{s, copied} {
+		require.NoError(t, commitTestEntries(t, srv, 100, 1000)) //nolint:mnd
+	}
+	checkViews := func(from, to uint64) {
+		for num := from; to > num; num++ {
+			view, err := s.GetEntryView(num)
+			require.NoError(t, err)
+			entry, err := s.GetEntry(num)
+			require.NoError(t, err)
+			require.Equal(t, entry, view)
+			entry, err = copied.GetEntryView(num)
+			require.NoError(t, err)
+			require.Equal(t, entry, view)
+		}
+	}
+	checkViews(0, 100) //nolint:mnd
+
+	// The file grown beyond the mapping is mapped again
+	mapped := len(s.streamFile.mmap.data)
+	for _, srv := range []*StreamServer{s, copied} {
+		require.NoError(t, commitTestEntries(t, srv, 300, 1000)) //nolint:mnd
+	}
+	checkViews(100, 400) //nolint:mnd
+	assert.Greater(t, len(s.streamFile.mmap.data), mapped)
+	assert.Len(t, s.streamFile.mmap.retired, 1)
+
+	// Updated and tombstoned entries
+	require.NoError(t, s.UpdateEntryData(7, 1, bytes.Repeat([]byte{0xff}, 1000))) //nolint:mnd
+	view, err := s.GetEntryView(7)                                                //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0xff}, 1000), view.Data) //nolint:mnd
+	require.NoError(t, s.Tombstone(8))                           //nolint:mnd
+	_, err = s.GetEntryView(8)                                   //nolint:mnd
+	require.ErrorIs(t, err, ErrEntryTombstoned)
+
+	// Entries not committed
+	_, err = s.GetEntryView(400) //nolint:mnd
+	require.ErrorIs(t, err, ErrInvalidEntryNumber)
+}
+
+func TestGetEntryViewCompressed(t *testing.T) {
+	s := newMmapTestServer(t, StreamFileOptions{MmapReads: true, Compression: CompressionZstd})
+	require.NoError(t, commitTestEntries(t, s, 50, 1000)) //nolint:mnd
+
+	for num := range uint64(50) {
+		view, err := s.GetEntryView(num)
+		require.NoError(t, err)
+		assert.Equal(t, bytes.Repeat([]byte{byte(num)}, 1000), view.Data) //nolint:mnd
+	}
+}
+
+func BenchmarkGetEntry(b *testing.B) {
+	const entries = 1000
+
+	s := newMmapTestServer(b, StreamFileOptions{MmapReads: true})
+	require.NoError(b, commitTestEntries(b, s, entries, 1000)) //nolint:mnd
+	for name, get := range map[string]func(uint64) (FileEntry, error){
+		"GetEntry":     s.GetEntry,
+		"GetEntryView": s.GetEntryView,
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			num := uint64(0)
+			for range b.N {
+				if _, err := get(num % entries); err != nil {
+					b.Fatal(err)
+				}
+				num += 97 //nolint:mnd
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package datastreamer
+
+import (
+	"os"
+	"syscall"
+)
+
+const mmapSupported = true // Memory mapped reads supported on this platform
+
+// mmapFile maps a length of a file from its start for reading
+func mmapFile(file *os.File, length int) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, length, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmapFile unmaps a region mapped by mmapFile
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,106 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"os"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// OffsetRegion type for the kind of stream file region a byte offset belongs to
+type OffsetRegion uint8
+
+const (
+	OffsetHeader  OffsetRegion = iota + 1 // OffsetHeader for the header page
+	OffsetEntry                           // OffsetEntry for a data entry (bookmarks included)
+	OffsetPadding                         // OffsetPadding for the pad at the end of a data page
+	OffsetUnused                          // OffsetUnused for the space allocated after the committed entries
+)
+
+// StrOffsetRegion for the offset regions description
+var StrOffsetRegion = map[OffsetRegion]string{
+	OffsetHeader:  "Header",
+	OffsetEntry:   "Entry",
+	OffsetPadding: "Padding",
+	OffsetUnused:  "Unused",
+}
+
+// OffsetDescription type for what a byte offset of a stream file belongs to
+type OffsetDescription struct {
+	Region     OffsetRegion
+	Start      uint64    // Offset where the region starts
+	End        uint64    // Offset where the region ends (excluding)
+	Number     uint64    // Entry number (only OffsetEntry)
+	Type       EntryType // Entry type (only OffsetEntry)
+	Tombstoned bool      // Entry logically deleted (only OffsetEntry)
+}
+
+// DescribeOffset returns what a byte offset of the stream file belongs to: the header page, a data entry (with its
+// number, type and start/end offsets), the pad at the end of a data page, or the space after the committed entries.
+// It reads just the fixed part of the entries of the data page of the offset, e.g. to map an offset from a crash
+// dump or an external mmap reader back to its entry. Fails with ErrOffsetOutOfFile beyond the file size.
+func (f *StreamFile) DescribeOffset(offset uint64) (OffsetDescription, error) {
+	header := f.getHeaderEntry()
+	if offset >= f.maxLength {
+		log.Errorf("Offset %d out of file %s size %d", offset, f.fileName, f.maxLength)
+		return OffsetDescription{}, ErrOffsetOutOfFile
+	}
+	if offset < PageHeaderSize {
+		return OffsetDescription{Region: OffsetHeader, Start: 0, End: PageHeaderSize}, nil
+	}
+	if offset >= header.TotalLength {
+		return OffsetDescription{Region: OffsetUnused, Start: header.TotalLength, End: f.maxLength}, nil
+	}
+
+	file, err := os.OpenFile(f.fileName, os.O_RDONLY, os.ModePerm)
+	if err != nil {
+		log.Errorf("Error opening file to describe offset: %v", err)
+		return OffsetDescription{}, err
+	}
+	defer file.Close()
+
+	// Walk the entries of the data page from its start until the one containing the offset
+	pageSize := uint64(f.pageSize)
+	pageStart := PageHeaderSize + ((offset-PageHeaderSize)/pageSize)*pageSize
+	pageEnd := pageStart + pageSize
+	buffer := make([]byte, FixedSizeFileEntry)
+	for pos := pageStart; pos < header.TotalLength; {
+		_, err = file.ReadAt(buffer[:1], int64(pos))
+		if err != nil {
+			log.Errorf("Error reading packet type to describe offset: %v", err)
+			return OffsetDescription{}, err
+		}
+
+		// Pad goes until the end of the page
+		if buffer[0] == PtPadding {
+			return OffsetDescription{Region: OffsetPadding, Start: pos, End: pageEnd}, nil
+		}
+
+		// Read the fixed part of the entry
+		_, err = file.ReadAt(buffer, int64(pos))
+		if err != nil {
+			log.Errorf("Error reading entry to describe offset: %v", err)
+			return OffsetDescription{}, err
+		}
+		length := uint64(binary.BigEndian.Uint32(buffer[1:5]))
+		if buffer[0] != PtData || length < FixedSizeFileEntry || pos+length > pageEnd {
+			log.Errorf("Error decoding entry at position %d to describe offset %d", pos, offset)
+			return OffsetDescription{}, ErrBadFileFormat
+		}
+		if offset < pos+length {
+			etype := EntryType(binary.BigEndian.Uint32(buffer[5:9]))
+			return OffsetDescription{
+				Region:     OffsetEntry,
+				Start:      pos,
+				End:        pos + length,
+				Number:     binary.BigEndian.Uint64(buffer[9:17]),
+				Type:       etype &^ tombstoneFlag,
+				Tombstoned: etype&tombstoneFlag != 0,
+			}, nil
+		}
+		pos += length
+	}
+
+	// The committed entries end before the offset
+	return OffsetDescription{Region: OffsetUnused, Start: header.TotalLength, End: f.maxLength}, nil
+}
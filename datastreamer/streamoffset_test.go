@@ -0,0 +1,86 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeOffset(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "offsets.bin")
+	writeTestStream(t, fileName, 1200) //nolint:mnd
+
+	// Entry positions and the end of the first data page entries (two data pages)
+	file, err := os.Open(fileName)
+	require.NoError(t, err)
+	header, err := readFileHeader(file)
+	require.NoError(t, err)
+	starts := make(map[uint64]uint64)
+	var firstPageEnd uint64
+	_, err = walkEntries(file, header.TotalLength, header.PageSize(), func(pos uint64, e FileEntry) error {
+		starts[e.Number] = pos
+		if pos < PageHeaderSize+PageDataSize {
+			firstPageEnd = pos + uint64(e.Length)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	require.GreaterOrEqual(t, starts[1190], uint64(PageHeaderSize+PageDataSize))
+
+	// Tombstone entry 42
+	typeBytes := binary.BigEndian.AppendUint32(nil, 1|tombstoneFlag)
+	patchFile(t, fileName, int64(starts[42]+5), typeBytes) //nolint:mnd
+
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	defer sf.Close()
+
+	// Header page
+	d, err := sf.DescribeOffset(10) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, OffsetDescription{Region: OffsetHeader, Start: 0, End: PageHeaderSize}, d)
+
+	// First, middle and last byte of entries
+	for _, num := range []uint64{0, 1, 10, 42, 1190, 1199} {
+		start := starts[num]
+		end := start + FixedSizeFileEntry + 1000 //nolint:mnd
+		if num%10 == 0 {
+			end = start + FixedSizeFileEntry + 8 //nolint:mnd
+		}
+		for _, offset := range []uint64{start, (start + end) / 2, end - 1} { //nolint:mnd
+			d, err = sf.DescribeOffset(offset)
+			require.NoError(t, err)
+			assert.Equal(t, OffsetEntry, d.Region, "offset %d", offset)
+			assert.Equal(t, num, d.Number)
+			assert.Equal(t, start, d.Start)
+			assert.Equal(t, end, d.End)
+			assert.Equal(t, num == 42, d.Tombstoned) //nolint:mnd
+			if num%10 == 0 {
+				assert.Equal(t, EntryType(EtBookmark), d.Type)
+			} else {
+				assert.Equal(t, EntryType(1), d.Type)
+			}
+		}
+	}
+
+	// Pad at the end of the first data page
+	require.Less(t, firstPageEnd, uint64(PageHeaderSize+PageDataSize))
+	for _, offset := range []uint64{firstPageEnd, PageHeaderSize + PageDataSize - 1} {
+		d, err = sf.DescribeOffset(offset)
+		require.NoError(t, err)
+		assert.Equal(t, OffsetDescription{Region: OffsetPadding, Start: firstPageEnd, End: PageHeaderSize + PageDataSize}, d)
+	}
+
+	// Space after the committed entries, and beyond the file
+	totalLength := sf.getHeaderEntry().TotalLength
+	d, err = sf.DescribeOffset(totalLength)
+	require.NoError(t, err)
+	assert.Equal(t, OffsetDescription{Region: OffsetUnused, Start: totalLength, End: sf.maxLength}, d)
+	_, err = sf.DescribeOffset(sf.maxLength)
+	require.ErrorIs(t, err, ErrOffsetOutOfFile)
+}
@@ -0,0 +1,38 @@
+package datastreamer
+
+import (
+	"context"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// Ping measures the round-trip time to the server with the Ping command, the time from sending it until its result
+// is received. It can be used while streaming: the server replies right away without changing the streaming. If the
+// context ends first it returns its error, and the late result is discarded when it arrives.
+func (c *StreamClient) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	_, _, err := c.execCommand(CmdPing, true, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := c.getResultContext(ctx, CmdPing)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	if r.errorNum != uint32(CmdErrOK) {
+		return 0, ErrResultCommandError
+	}
+	return rtt, nil
+}
+
+// processCmdPing processes the TCP Ping command from the clients
+func (s *StreamServer) processCmdPing(client *client) error {
+	// Log
+	log.Debugf("Client %s command Ping", client.clientID)
+
+	// Send a command result entry OK
+	return s.sendResultEntry(0, "OK", client)
+}
@@ -0,0 +1,55 @@
+package datastreamer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	commitTestEntry(t, s)
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	received := make(chan uint64, 10) //nolint:mnd
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- e.Number
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+
+	rtt, err := c.Ping(context.Background())
+	require.NoError(t, err)
+	assert.Positive(t, rtt)
+	assert.Less(t, rtt, time.Second)
+
+	// While streaming, without disturbing it
+	require.NoError(t, c.ExecCommandStart(0))
+	assert.Equal(t, uint64(0), <-received)
+	rtt, err = c.Ping(context.Background())
+	require.NoError(t, err)
+	assert.Positive(t, rtt)
+	commitTestEntry(t, s)
+	select {
+	case num := <-received:
+		assert.Equal(t, uint64(1), num)
+	case <-time.After(time.Second):
+		t.Fatal("entry not received after ping")
+	}
+	require.NoError(t, c.ExecCommandStop())
+
+	// The result of an abandoned ping doesn't answer the next command
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Ping(ctx)
+	if err != nil {
+		require.ErrorIs(t, err, context.Canceled)
+	}
+	header, err := c.ExecCommandGetHeader()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), header.TotalEntries)
+}
@@ -0,0 +1,190 @@
+package datastreamer
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+const (
+	prefetchRatioWeight = 8   // Weight of the previous accesses in the sequential ratio (moving average)
+	prefetchMinRatio    = 0.5 // Sequential ratio below which no entries are prefetched
+)
+
+// PrefetchStats type for the counters of the entry prefetcher
+type PrefetchStats struct {
+	Hits       uint64  // GetEntry served from the cache
+	Misses     uint64  // GetEntry read from the file
+	Prefetched uint64  // Entries warmed into the cache
+	Evicted    uint64  // Entries evicted from the cache by the memory budget
+	Depth      uint64  // Current number of entries warmed after each GetEntry
+	Ratio      float64 // Current sequential access ratio (0..1)
+	Bytes      uint64  // Current cache size in bytes
+}
+
+// prefetcher type for the read cache of GetEntry, warmed asynchronously with the entries after the ones read
+type prefetcher struct {
+	maxDepth uint64               // Max entries warmed after each GetEntry
+	maxBytes uint64               // Memory budget of the cache (entries data and fixed part)
+	entries  map[uint64]FileEntry // Cached entries as stored (before the read transform)
+	order    []uint64             // Cached entry numbers in insertion order, for the eviction
+	bytes    uint64               // Size of the cached entries
+	last     uint64               // Last entry number read by GetEntry
+	ratio    float64              // Moving average of the sequential accesses
+	warmedTo uint64               // Entry number after the last one warmed
+	running  bool                 // Prefetch in progress
+	gen      uint64               // Generation, incremented on invalidation to discard the prefetch in progress
+	stats    PrefetchStats        // Counters
+	wg       sync.WaitGroup       // Prefetch goroutine
+	mutex    sync.Mutex           // Protects the fields above
+}
+
+// SetEntryPrefetch enables the prefetch of the entries read by GetEntry, disabled by default. After each GetEntry the
+// next entries are read asynchronously into a cache of maxBytes, up to maxDepth entries adapted to the ratio of
+// sequential accesses (none while most accesses are random). When the cache is full the oldest entries are evicted.
+// The cache is invalidated by TruncateFile, UpdateEntryData and Tombstone. With maxDepth or maxBytes 0 it's disabled.
+func (s *StreamServer) SetEntryPrefetch(maxDepth uint64, maxBytes uint64) {
+	if s.prefetch != nil {
+		s.prefetch.wait()
+	}
+	if maxDepth == 0 || maxBytes == 0 {
+		s.prefetch = nil
+		return
+	}
+	s.prefetch = &prefetcher{
+		maxDepth: maxDepth,
+		maxBytes: maxBytes,
+		entries:  make(map[uint64]FileEntry),
+	}
+}
+
+// GetPrefetchStats returns the counters of the entry prefetcher (zero if disabled)
+func (s *StreamServer) GetPrefetchStats() PrefetchStats {
+	if s.prefetch == nil {
+		return PrefetchStats{}
+	}
+	p := s.prefetch
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := p.stats
+	stats.Depth = p.depth()
+	stats.Ratio = p.ratio
+	stats.Bytes = p.bytes
+	return stats
+}
+
+// get returns a cached entry, records the access and starts warming the next entries if sequential enough
+func (p *prefetcher) get(f *StreamFile, entryNum uint64) (FileEntry, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Sequential access ratio
+	var sequential float64
+	if entryNum == p.last+1 {
+		sequential = 1
+	}
+	p.ratio += (sequential - p.ratio) / prefetchRatioWeight
+	p.last = entryNum
+
+	entry, ok := p.entries[entryNum]
+	if ok {
+		p.stats.Hits++
+		entry.Data = bytes.Clone(entry.Data)
+	} else {
+		p.stats.Misses++
+	}
+
+	// Warm the next entries not cached yet
+	depth := p.depth()
+	from := entryNum + 1
+	if _, warmed := p.entries[from]; warmed && p.warmedTo > from {
+		from = p.warmedTo
+	}
+	to := min(entryNum+depth+1, f.getHeaderEntry().TotalEntries)
+	if depth > 0 && !p.running && from < to {
+		p.running = true
+		p.wg.Add(1)
+		go p.warm(f, from, to, p.gen)
+	}
+	return entry, ok
+}
+
+// depth returns the number of entries to warm for the current sequential ratio
+func (p *prefetcher) depth() uint64 {
+	if p.ratio < prefetchMinRatio {
+		return 0
+	}
+	return max(1, uint64(p.ratio*float64(p.maxDepth)))
+}
+
+// warm reads the committed entries from an entry number until another (excluding) into the cache
+func (p *prefetcher) warm(f *StreamFile, from uint64, to uint64, gen uint64) {
+	defer p.wg.Done()
+
+	var warmed []FileEntry
+	iterator, err := f.iteratorFrom(from, true)
+	if err == nil {
+		for range to - from {
+			end, err := f.iteratorNext(iterator)
+			if err != nil {
+				log.Debugf("Error prefetching entry: %v", err)
+				break
+			}
+			if end {
+				break
+			}
+			warmed = append(warmed, iterator.Entry)
+		}
+		f.iteratorEnd(iterator)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.running = false
+	if gen != p.gen {
+		return
+	}
+	for _, e := range warmed {
+		p.add(e)
+	}
+	p.warmedTo = max(p.warmedTo, from+uint64(len(warmed)))
+}
+
+// add adds an entry to the cache evicting the oldest ones over the memory budget
+func (p *prefetcher) add(e FileEntry) {
+	size := uint64(FixedSizeFileEntry + len(e.Data))
+	if size > p.maxBytes {
+		return
+	}
+	if _, ok := p.entries[e.Number]; ok {
+		return
+	}
+	for p.bytes+size > p.maxBytes && len(p.order) > 0 {
+		old := p.entries[p.order[0]]
+		delete(p.entries, p.order[0])
+		p.order = p.order[1:]
+		p.bytes -= uint64(FixedSizeFileEntry + len(old.Data))
+		p.stats.Evicted++
+	}
+	p.entries[e.Number] = e
+	p.order = append(p.order, e.Number)
+	p.bytes += size
+	p.stats.Prefetched++
+}
+
+// invalidate clears the cache and discards the prefetch in progress
+func (p *prefetcher) invalidate() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.gen++
+	clear(p.entries)
+	p.order = nil
+	p.bytes = 0
+	p.warmedTo = 0
+}
+
+// wait waits for the prefetch in progress
+func (p *prefetcher) wait() {
+	p.wg.Wait()
+}
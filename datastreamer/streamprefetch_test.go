@@ -0,0 +1,89 @@
+package datastreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryPrefetch(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, commitTestEntries(t, s, 100, 100)) //nolint:mnd
+	s.SetEntryPrefetch(8, 2000)                           //nolint:mnd
+
+	// Sequential reads warm the next entries
+	for i := range uint64(50) {
+		entry, err := s.GetEntry(i)
+		require.NoError(t, err)
+		assert.Equal(t, i, entry.Number)
+		assert.Equal(t, byte(i), entry.Data[0])
+		s.prefetch.wait()
+	}
+	stats := s.GetPrefetchStats()
+	assert.Positive(t, stats.Hits)
+	assert.Positive(t, stats.Depth)
+	assert.Positive(t, stats.Evicted)
+	assert.LessOrEqual(t, stats.Bytes, uint64(2000)) //nolint:mnd
+
+	// An updated entry is not served from the cache
+	_, err := s.GetEntry(50) //nolint:mnd
+	require.NoError(t, err)
+	s.prefetch.wait()
+	require.NoError(t, s.UpdateEntryData(51, 1, make([]byte, 100))) //nolint:mnd
+	entry, err := s.GetEntry(51)                                    //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, byte(0), entry.Data[0])
+	s.prefetch.wait()
+
+	// Tombstoned entries from the cache too
+	require.NoError(t, s.Tombstone(53)) //nolint:mnd
+	_, err = s.GetEntry(52)             //nolint:mnd
+	require.NoError(t, err)
+	s.prefetch.wait()
+	_, err = s.GetEntry(53) //nolint:mnd
+	require.ErrorIs(t, err, ErrEntryTombstoned)
+
+	// Random reads stop the prefetch
+	for i := range uint64(20) {
+		_, err = s.GetEntry((i * 37) % 100) //nolint:mnd
+		require.NoError(t, err)
+		s.prefetch.wait()
+	}
+	assert.Zero(t, s.GetPrefetchStats().Depth)
+
+	// Disabled
+	s.SetEntryPrefetch(0, 0)
+	_, err = s.GetEntry(0)
+	require.NoError(t, err)
+	assert.Equal(t, PrefetchStats{}, s.GetPrefetchStats())
+}
+
+func BenchmarkGetEntrySequential(b *testing.B) {
+	const entries = 5000
+
+	for _, prefetch := range []bool{false, true} {
+		name := "no-prefetch"
+		if prefetch {
+			name = "prefetch"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := newTestServer(b, b.TempDir())
+			require.NoError(b, commitTestEntries(b, s, entries, 1000)) //nolint:mnd
+			if prefetch {
+				s.SetEntryPrefetch(64, 4<<20) //nolint:mnd
+			}
+
+			b.ResetTimer()
+			for i := range b.N {
+				_, err := s.GetEntry(uint64(i % entries))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			if prefetch {
+				b.ReportMetric(float64(s.GetPrefetchStats().Hits)/float64(b.N), "hits/op")
+			}
+		})
+	}
+}
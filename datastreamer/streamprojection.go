@@ -0,0 +1,243 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// ProjectionField type for the fields of the entries streamed by a projection subscription (bit mask)
+type ProjectionField uint32
+
+const (
+	ProjectNumber    ProjectionField = 1 << iota // ProjectNumber for the entry number (always included)
+	ProjectType                                  // ProjectType for the entry type
+	ProjectTimestamp                             // ProjectTimestamp for the commit time (commit journal, 0: unknown)
+	ProjectLength                                // ProjectLength for the length of the full entry streamed
+	ProjectData                                  // ProjectData for the entry data
+
+	projectAll = ProjectNumber | ProjectType | ProjectTimestamp | ProjectLength | ProjectData
+)
+
+// FixedSizeProjection is the size of the fixed part of a projected entry: packet type, length, fields and number
+const FixedSizeProjection = 1 + 4 + 4 + 8
+
+// ProjectedEntry type for an entry streamed by a projection subscription, with just the fields requested
+type ProjectedEntry struct {
+	Fields    ProjectionField // Fields received
+	Number    uint64
+	Type      EntryType
+	Timestamp time.Time // Commit time (zero: not requested or unknown)
+	Length    uint32    // Length of the full entry streamed
+	Data      []byte    // Entry data (nil: not requested)
+}
+
+// ProcessProjectionFunc type of the callback function to process the received projected entries
+type ProcessProjectionFunc func(*ProjectedEntry, *StreamClient) error
+
+// encodeProjectedEntry encodes a projected entry to binary: packet type, length (u32), fields (u32), number (u64)
+// and the requested fields in the order of their bits
+func encodeProjectedEntry(p ProjectedEntry) []byte {
+	be := []byte{PtProjection, 0, 0, 0, 0}
+	be = binary.BigEndian.AppendUint32(be, uint32(p.Fields))
+	be = binary.BigEndian.AppendUint64(be, p.Number)
+	if p.Fields&ProjectType != 0 {
+		be = binary.BigEndian.AppendUint32(be, uint32(p.Type))
+	}
+	if p.Fields&ProjectTimestamp != 0 {
+		var ts int64
+		if !p.Timestamp.IsZero() {
+			ts = p.Timestamp.UnixNano()
+		}
+		be = binary.BigEndian.AppendUint64(be, uint64(ts)) //nolint:gosec
+	}
+	if p.Fields&ProjectLength != 0 {
+		be = binary.BigEndian.AppendUint32(be, p.Length)
+	}
+	if p.Fields&ProjectData != 0 {
+		be = append(be, p.Data...)
+	}
+	binary.BigEndian.PutUint32(be[1:5], uint32(len(be)))
+	return be
+}
+
+// decodeProjectedEntry decodes a projected entry from binary
+func decodeProjectedEntry(b []byte) (ProjectedEntry, error) {
+	if len(b) < FixedSizeProjection || b[0] != PtProjection || binary.BigEndian.Uint32(b[1:5]) != uint32(len(b)) {
+		log.Error("Invalid binary projected entry")
+		return ProjectedEntry{}, ErrInvalidProjection
+	}
+	p := ProjectedEntry{
+		Fields: ProjectionField(binary.BigEndian.Uint32(b[5:9])),
+		Number: binary.BigEndian.Uint64(b[9:17]),
+	}
+	if p.Fields&^projectAll != 0 {
+		log.Errorf("Invalid projected entry fields %d", p.Fields)
+		return ProjectedEntry{}, ErrInvalidProjection
+	}
+
+	b = b[FixedSizeProjection:]
+	next := func(size int) ([]byte, error) {
+		if len(b) < size {
+			log.Error("Invalid binary projected entry length")
+			return nil, ErrInvalidProjection
+		}
+		v := b[:size]
+		b = b[size:]
+		return v, nil
+	}
+	if p.Fields&ProjectType != 0 {
+		v, err := next(4) //nolint:mnd
+		if err != nil {
+			return ProjectedEntry{}, err
+		}
+		p.Type = EntryType(binary.BigEndian.Uint32(v))
+	}
+	if p.Fields&ProjectTimestamp != 0 {
+		v, err := next(8) //nolint:mnd
+		if err != nil {
+			return ProjectedEntry{}, err
+		}
+		if ts := int64(binary.BigEndian.Uint64(v)); ts != 0 { //nolint:gosec
+			p.Timestamp = time.Unix(0, ts)
+		}
+	}
+	if p.Fields&ProjectLength != 0 {
+		v, err := next(4) //nolint:mnd
+		if err != nil {
+			return ProjectedEntry{}, err
+		}
+		p.Length = binary.BigEndian.Uint32(v)
+	}
+	if p.Fields&ProjectData != 0 {
+		p.Data = append([]byte{}, b...)
+	} else if len(b) > 0 {
+		log.Error("Invalid binary projected entry length")
+		return ProjectedEntry{}, ErrInvalidProjection
+	}
+	return p, nil
+}
+
+// encodeClientEntry encodes an entry to stream to a client, projected if it subscribed to a projection
+func (s *StreamServer) encodeClientEntry(client *client, e FileEntry) ([]byte, error) {
+	if client.projection == 0 {
+		return s.encodeStreamEntry(e)
+	}
+
+	err := transformEntry(&e, s.readTransform)
+	if err != nil {
+		log.Errorf("Error transforming entry %d data: %v", e.Number, err)
+		return nil, ErrTransformingEntry
+	}
+	p := ProjectedEntry{
+		Fields: client.projection,
+		Number: e.Number,
+		Type:   e.Type,
+		Length: FixedSizeFileEntry + uint32(len(e.Data)),
+		Data:   e.Data,
+	}
+	if p.Fields&ProjectTimestamp != 0 && s.journal != nil {
+		r, err := s.journal.GetCommit(e.Number)
+		if err != nil && !errors.Is(err, ErrCommitNotFound) {
+			return nil, err
+		}
+		p.Timestamp = r.Timestamp
+	}
+	return encodeProjectedEntry(p), nil
+}
+
+// processCmdStartProjection processes the TCP Start Projection command from the clients
+func (s *StreamServer) processCmdStartProjection(client *client) error {
+	// Read from entry number and fields parameters
+	fromEntry, err := readFullUint64(client)
+	if err != nil {
+		return err
+	}
+	fields, err := readFullUint32(client)
+	if err != nil {
+		return err
+	}
+
+	// Log
+	log.Debugf("Client %s command StartProjection from %d fields %d", client.clientID, fromEntry, fields)
+
+	if ProjectionField(fields)&^projectAll != 0 {
+		log.Errorf("Invalid projection fields %d for client %s", fields, client.clientID)
+		_ = s.sendResultEntry(uint32(CmdErrInvalidCommand), StrCommandErrors[CmdErrInvalidCommand], client)
+		return ErrInvalidProjection
+	}
+
+	client.filter = nil
+	client.entryTypes = nil
+	client.projection = ProjectionField(fields) | ProjectNumber
+	return s.startFromEntry(client, fromEntry)
+}
+
+// ExecCommandStartProjection executes client TCP command to start streaming from entry just the requested fields of
+// the entries (the number is always included), e.g. numbers and types to build an index without the data. The
+// entries are received by the callback set with SetProcessProjectionFunc.
+func (c *StreamClient) ExecCommandStartProjection(fromEntry uint64, fields ProjectionField) error {
+	if fields&^projectAll != 0 {
+		log.Errorf("%s Invalid projection fields %d", c.ID, fields)
+		return ErrInvalidProjection
+	}
+	c.projection = fields | ProjectNumber
+	_, _, err := c.execCommand(CmdStartProjection, false, fromEntry, nil)
+	return err
+}
+
+// SetProcessProjectionFunc sets the callback function to process the entries received by a projection subscription
+func (c *StreamClient) SetProcessProjectionFunc(f ProcessProjectionFunc) {
+	c.processProjection = f
+}
+
+// readProjectedEntry reads the rest of a projected entry packet, returned in the data of a stream entry
+func (c *StreamClient) readProjectedEntry() (FileEntry, error) {
+	buffer := make([]byte, FixedSizeProjection)
+	buffer[0] = PtProjection
+	err := c.readContent(buffer[1:])
+	if err != nil {
+		return FileEntry{}, err
+	}
+	length := binary.BigEndian.Uint32(buffer[1:5])
+	if length < FixedSizeProjection {
+		log.Errorf("%s Error reading projected entry", c.ID)
+		return FileEntry{}, ErrReadingDataEntry
+	}
+	rest := make([]byte, length-FixedSizeProjection)
+	err = c.readContent(rest)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	return FileEntry{packetType: PtProjection, Number: binary.BigEndian.Uint64(buffer[9:17]),
+		Data: append(buffer, rest...)}, nil
+}
+
+// processProjectedEntry decodes and processes a projected entry
+func (c *StreamClient) processProjectedEntry(b []byte) error {
+	p, err := decodeProjectedEntry(b)
+	if err != nil {
+		return err
+	}
+
+	// Entries received again resuming the streaming
+	c.mutexDownload.Lock()
+	skip := p.Number < c.nextEntry
+	c.mutexDownload.Unlock()
+	if skip {
+		return nil
+	}
+
+	if c.processProjection == nil {
+		log.Debugf("Projected entry(%s): %d | %d", c.ID, p.Number, p.Fields)
+	} else {
+		err = c.processProjection(&p, c)
+		if err != nil {
+			return err
+		}
+	}
+	c.setDelivered(p.Number)
+	return nil
+}
@@ -0,0 +1,82 @@
+package datastreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartProjection(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.EnableCommitJournal())
+	commitTestEntry(t, s)
+	commitTestEntry(t, s)
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	received := make(chan ProjectedEntry, 10) //nolint:mnd
+	c.SetProcessProjectionFunc(func(p *ProjectedEntry, _ *StreamClient) error {
+		received <- *p
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+
+	// Number, type and length without the data, from the file and then broadcast
+	require.NoError(t, c.ExecCommandStartProjection(1, ProjectType|ProjectLength))
+	commitTestEntry(t, s)
+	for num := uint64(1); num <= 2; num++ {
+		select {
+		case p := <-received:
+			assert.Equal(t, ProjectNumber|ProjectType|ProjectLength, p.Fields)
+			assert.Equal(t, num, p.Number)
+			assert.Equal(t, EntryType(1), p.Type)
+			assert.Equal(t, uint32(FixedSizeFileEntry+100), p.Length) //nolint:mnd
+			assert.True(t, p.Timestamp.IsZero())
+			assert.Nil(t, p.Data)
+		case <-time.After(time.Second):
+			t.Fatalf("projected entry %d not received", num)
+		}
+	}
+	require.NoError(t, c.ExecCommandStop())
+
+	// Commit timestamp from the commit journal
+	require.NoError(t, c.ExecCommandStartProjection(0, ProjectTimestamp))
+	select {
+	case p := <-received:
+		assert.Equal(t, uint64(0), p.Number)
+		ts, err := s.GetEntryTimestamp(0)
+		require.NoError(t, err)
+		assert.True(t, ts.Equal(p.Timestamp))
+		assert.Equal(t, uint32(0), p.Length)
+	case <-time.After(time.Second):
+		t.Fatal("projected entry 0 not received")
+	}
+	require.NoError(t, c.ExecCommandStop())
+
+	// Unknown fields are rejected
+	require.ErrorIs(t, c.ExecCommandStartProjection(0, ProjectData<<1), ErrInvalidProjection)
+}
+
+func TestDecodeProjectedEntry(t *testing.T) {
+	p := ProjectedEntry{
+		Fields:    projectAll,
+		Number:    7, //nolint:mnd
+		Type:      EtBookmark,
+		Timestamp: time.Unix(0, 123456789), //nolint:mnd
+		Length:    FixedSizeFileEntry + 3,  //nolint:mnd
+		Data:      []byte{1, 2, 3},
+	}
+	decoded, err := decodeProjectedEntry(encodeProjectedEntry(p))
+	require.NoError(t, err)
+	assert.True(t, p.Timestamp.Equal(decoded.Timestamp))
+	decoded.Timestamp = p.Timestamp
+	assert.Equal(t, p, decoded)
+
+	// Fields missing for the length
+	b := encodeProjectedEntry(ProjectedEntry{Fields: ProjectNumber | ProjectType, Number: 7}) //nolint:mnd
+	b[8] |= byte(ProjectLength)
+	_, err = decodeProjectedEntry(b)
+	require.ErrorIs(t, err, ErrInvalidProjection)
+}
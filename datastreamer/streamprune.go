@@ -0,0 +1,87 @@
+package datastreamer
+
+import (
+	"bytes"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// BookmarkPruning type for the handling of the bookmarks pointing to entries removed by a truncate or a rollback
+type BookmarkPruning uint8
+
+const (
+	// BookmarkPruneRemove removes from the bookmarks DB the bookmarks pointing to the entries removed (default)
+	BookmarkPruneRemove BookmarkPruning = iota
+	// BookmarkPruneKeep leaves the bookmarks DB as is, the bookmarks pointing to the entries removed (or replaced by
+	// new entries) are detected when used and fail with ErrBookmarkTargetPruned
+	BookmarkPruneKeep
+)
+
+// SetBookmarkPruning sets how the bookmarks pointing to the entries removed by TruncateFile and RollbackAtomicOp
+// are handled
+func (s *StreamServer) SetBookmarkPruning(policy BookmarkPruning) {
+	s.bookmarkPruning = policy
+}
+
+// pruneBookmarks removes the bookmarks pointing to the entries from an entry number if the policy is to remove them,
+// just the given keys if any (e.g. the bookmarks of a rollback) or all of them otherwise
+func (s *StreamServer) pruneBookmarks(fromEntry uint64, keys [][]byte) error {
+	if s.bookmarkPruning != BookmarkPruneRemove {
+		return nil
+	}
+
+	count, err := s.bookmark.deleteFrom(fromEntry, keys)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		log.Infof("Removed %d bookmarks pointing to entries from %d", count, fromEntry)
+	}
+	return nil
+}
+
+// getBookmark returns the entry number pointed by a bookmark, checking it's still its target
+func (s *StreamServer) getBookmark(bookmark []byte) (uint64, error) {
+	entryNum, err := s.bookmark.GetBookmark(bookmark)
+	if err != nil {
+		return 0, err
+	}
+	return entryNum, s.checkBookmarkTarget(bookmark, entryNum)
+}
+
+// checkBookmarkTarget checks if the entry pointed by a bookmark is still that bookmark entry, when the bookmarks of
+// the entries removed are kept
+func (s *StreamServer) checkBookmarkTarget(bookmark []byte, entryNum uint64) error {
+	if s.bookmarkPruning != BookmarkPruneKeep {
+		return nil
+	}
+
+	// Beyond the entries added (including the current atomic operation)
+	s.streamFile.mutexHeader.Lock()
+	nextEntry := s.streamFile.header.TotalEntries
+	committed := s.streamFile.writtenHead.TotalEntries
+	s.streamFile.mutexHeader.Unlock()
+	if entryNum >= nextEntry {
+		log.Warnf("Bookmark [%v] points to entry %d, removed (next entry %d)", bookmark, entryNum, nextEntry)
+		return ErrBookmarkTargetPruned
+	}
+	if entryNum >= committed {
+		return nil
+	}
+
+	// Committed entry replaced after a truncate
+	iterator, err := s.streamFile.iteratorFrom(entryNum, true)
+	if err != nil {
+		return err
+	}
+	defer s.streamFile.iteratorEnd(iterator)
+	_, err = s.streamFile.iteratorNext(iterator)
+	if err != nil {
+		return err
+	}
+	if iterator.Entry.Type != EtBookmark || !bytes.Equal(iterator.Entry.Data, bookmark) {
+		log.Warnf("Bookmark [%v] points to entry %d, replaced by another entry", bookmark, entryNum)
+		return ErrBookmarkTargetPruned
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package datastreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestBookmarkPruning(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   BookmarkPruning
+		dangling error
+	}{
+		{name: "remove", policy: BookmarkPruneRemove, dangling: leveldb.ErrNotFound},
+		{name: "keep", policy: BookmarkPruneKeep, dangling: ErrBookmarkTargetPruned},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, t.TempDir())
+			s.SetBookmarkPruning(tc.policy)
+
+			// Bookmarks a, b and c at entries 0, 2 and 4
+			require.NoError(t, s.StartAtomicOp())
+			for _, key := range []string{"a", "b", "c"} {
+				_, err := s.AddStreamBookmark([]byte(key))
+				require.NoError(t, err)
+				_, err = s.AddStreamEntry(1, []byte(key))
+				require.NoError(t, err)
+			}
+			require.NoError(t, s.CommitAtomicOp())
+
+			// Truncate from entry 3
+			require.NoError(t, s.TruncateFile(3)) //nolint:mnd
+			num, err := s.GetBookmark([]byte("b"))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(2), num)
+			_, err = s.GetBookmark([]byte("c"))
+			require.ErrorIs(t, err, tc.dangling)
+			_, err = s.GetFirstEventAfterBookmark([]byte("c"))
+			require.ErrorIs(t, err, tc.dangling)
+
+			// Still dangling once other entries take its entry number
+			require.NoError(t, s.StartAtomicOp())
+			for range 3 {
+				_, err = s.AddStreamEntry(1, []byte{1})
+				require.NoError(t, err)
+			}
+			require.NoError(t, s.CommitAtomicOp())
+			_, err = s.GetBookmark([]byte("c"))
+			require.ErrorIs(t, err, tc.dangling)
+			entries, err := s.GetEntriesByBookmarkRange([]byte("b"), []byte("b"))
+			require.NoError(t, err)
+			assert.Len(t, entries, 4) //nolint:mnd
+
+			// Bookmark of a rolled back atomic operation
+			require.NoError(t, s.StartAtomicOp())
+			_, err = s.AddStreamBookmark([]byte("d"))
+			require.NoError(t, err)
+			num, err = s.GetBookmark([]byte("d"))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(6), num)
+			require.NoError(t, s.RollbackAtomicOp())
+			_, err = s.GetBookmark([]byte("d"))
+			require.ErrorIs(t, err, tc.dangling)
+
+			// Valid again once added again
+			require.NoError(t, s.StartAtomicOp())
+			_, err = s.AddStreamBookmark([]byte("c"))
+			require.NoError(t, err)
+			require.NoError(t, s.CommitAtomicOp())
+			num, err = s.GetBookmark([]byte("c"))
+			require.NoError(t, err)
+			assert.Equal(t, uint64(6), num)
+		})
+	}
+}
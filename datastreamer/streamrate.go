@@ -0,0 +1,71 @@
+package datastreamer
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultRateWindow = time.Minute // Default window to smooth the measured rates
+
+// rateMeter type to measure a rate of events smoothed over a sliding window, using 1 second buckets
+type rateMeter struct {
+	window  time.Duration
+	buckets []rateBucket
+	mutex   sync.Mutex
+}
+
+// rateBucket type for the number of events in a second
+type rateBucket struct {
+	second int64
+	count  uint64
+}
+
+// newRateMeter creates a rate meter smoothed over a window
+func newRateMeter(window time.Duration) *rateMeter {
+	return &rateMeter{window: window}
+}
+
+// add records a number of events at a time
+func (m *rateMeter) add(t time.Time, n uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	second := t.Unix()
+	if last := len(m.buckets) - 1; last >= 0 && m.buckets[last].second >= second {
+		m.buckets[last].count += n
+	} else {
+		m.buckets = append(m.buckets, rateBucket{second: second, count: n})
+	}
+	m.prune(t)
+}
+
+// rate returns the events per second within the window until now, 0 if there are none
+func (m *rateMeter) rate(now time.Time) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.prune(now)
+	if len(m.buckets) == 0 {
+		return 0
+	}
+
+	var total uint64
+	for _, b := range m.buckets {
+		total += b.count
+	}
+
+	// Measured since the first bucket if the window is not full yet
+	elapsed := now.Sub(time.Unix(m.buckets[0].second, 0))
+	elapsed = max(min(elapsed, m.window), time.Second)
+	return float64(total) / elapsed.Seconds()
+}
+
+// prune removes the buckets outside the window
+func (m *rateMeter) prune(now time.Time) {
+	from := now.Add(-m.window).Unix()
+	i := 0
+	for i < len(m.buckets) && m.buckets[i].second < from {
+		i++
+	}
+	m.buckets = m.buckets[i:]
+}
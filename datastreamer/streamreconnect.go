@@ -0,0 +1,71 @@
+package datastreamer
+
+import (
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// ConnectionState type for the state of the client connection to the server
+type ConnectionState uint32
+
+const (
+	ConnDisconnected ConnectionState = iota // ConnDisconnected not connected (not started or reconnection disabled)
+	ConnConnecting                          // ConnConnecting connecting to the server for the first time
+	ConnConnected                           // ConnConnected connected, with the streaming restored
+	ConnReconnecting                        // ConnReconnecting connecting again after a disconnection
+
+	initialBackoff = 500 * time.Millisecond // Delay before the first reconnection attempt with backoff
+)
+
+var (
+	// StrConnectionState for connection state description
+	StrConnectionState = map[ConnectionState]string{
+		ConnDisconnected: "Disconnected",
+		ConnConnecting:   "Connecting",
+		ConnConnected:    "Connected",
+		ConnReconnecting: "Reconnecting",
+	}
+)
+
+// SetReconnect sets the reconnection after an unexpected disconnection, before Start. When enabled (default) the
+// client dials again and re-issues its latest command (e.g. the streaming from the next entry to receive), waiting
+// between the failed attempts from 500ms doubling up to maxBackoff (0: a fixed delay of 5s, the default). When
+// disabled the client stops at the first disconnection.
+func (c *StreamClient) SetReconnect(enabled bool, maxBackoff time.Duration) {
+	c.noReconnect = !enabled
+	c.maxBackoff = maxBackoff
+}
+
+// ConnectionState returns the current state of the connection to the server
+func (c *StreamClient) ConnectionState() ConnectionState {
+	return ConnectionState(c.connState.Load())
+}
+
+// setConnectionState sets the state of the connection to the server
+func (c *StreamClient) setConnectionState(state ConnectionState) {
+	c.connState.Store(uint32(state))
+}
+
+// reconnectDelay returns the delay before the next connection attempt
+func (c *StreamClient) reconnectDelay(attempt int) time.Duration {
+	if c.maxBackoff <= 0 {
+		return defaultTimeout
+	}
+	delay := initialBackoff
+	for i := 1; i < attempt && delay < c.maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, c.maxBackoff)
+}
+
+// waitReconnect waits before the next connection attempt after a failed one
+func (c *StreamClient) waitReconnect() {
+	c.reconnectAttempt++
+	delay := c.reconnectDelay(c.reconnectAttempt)
+	log.Infof("Reconnecting to server %s, attempt %d in %v", c.server, c.reconnectAttempt, delay)
+	select {
+	case <-time.After(delay):
+	case <-c.stopped:
+	}
+}
@@ -0,0 +1,155 @@
+package datastreamer
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restartTestServer closes a test server and starts a new one on the same port and stream file
+func restartTestServer(t *testing.T, s *StreamServer, dir string) *StreamServer {
+	t.Helper()
+
+	_, portStr, err := net.SplitHostPort(testServerAddr(s))
+	require.NoError(t, err)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = NewServer(uint16(port), 1, 12345, 1, filepath.Join(dir, "stream.bin"), time.Second, time.Minute,
+		time.Minute, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// startClientUntilCleanup starts a client stopped when the test ends, before the servers are closed, so it doesn't
+// keep reconnecting afterwards. The cleanup waits for the goroutines of the client to return, so they don't log while
+// the next test initializes the logger
+func startClientUntilCleanup(t *testing.T, c *StreamClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		c.running.Wait()
+	})
+	require.NoError(t, c.StartWithContext(ctx))
+}
+
+func TestReconnect(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	commitTestEntry(t, s)
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	c.SetReconnect(true, time.Second)
+	received := make(chan uint64, 10) //nolint:mnd
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- e.Number
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	assert.Equal(t, ConnConnected, c.ConnectionState())
+	require.NoError(t, c.ExecCommandStart(0))
+	assert.Equal(t, uint64(0), <-received)
+
+	// The streaming resumes from the next entry after the server restarts
+	s = restartTestServer(t, s, dir)
+	commitTestEntry(t, s)
+	commitTestEntry(t, s)
+	for num := uint64(1); num <= 2; num++ {
+		select {
+		case n := <-received:
+			assert.Equal(t, num, n)
+		case <-time.After(5 * time.Second): //nolint:mnd
+			t.Fatalf("entry %d not received after reconnecting", num)
+		}
+	}
+	assert.Equal(t, ConnConnected, c.ConnectionState())
+
+	// Without reconnection the client stops at the disconnection
+	c, err = NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	c.SetReconnect(false, 0)
+	startClientUntilCleanup(t, c)
+	require.NoError(t, s.Close())
+	require.Eventually(t, func() bool { return c.ConnectionState() == ConnDisconnected }, time.Second,
+		10*time.Millisecond) //nolint:mnd
+}
+
+func TestReconnectDelay(t *testing.T) {
+	c := &StreamClient{}
+	assert.Equal(t, defaultTimeout, c.reconnectDelay(3)) //nolint:mnd
+
+	c.SetReconnect(true, 3*time.Second)                        //nolint:mnd
+	assert.Equal(t, 500*time.Millisecond, c.reconnectDelay(1)) //nolint:mnd
+	assert.Equal(t, time.Second, c.reconnectDelay(2))          //nolint:mnd
+	assert.Equal(t, 2*time.Second, c.reconnectDelay(3))        //nolint:mnd
+	assert.Equal(t, 3*time.Second, c.reconnectDelay(4))        //nolint:mnd
+	assert.Equal(t, 3*time.Second, c.reconnectDelay(100))      //nolint:mnd
+}
+
+func TestFromBookmarkReconnect(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	require.NoError(t, s.StartAtomicOp())
+	for i := range 100 {
+		if i == 20 { //nolint:mnd
+			_, err := s.AddStreamBookmark([]byte("bm"))
+			require.NoError(t, err)
+			continue
+		}
+		_, err := s.AddStreamEntry(1, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	c.SetReconnect(true, 200*time.Millisecond) //nolint:mnd
+	var (
+		mutex     sync.Mutex
+		delivered []uint64
+	)
+	count := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(delivered)
+	}
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		time.Sleep(2 * time.Millisecond) //nolint:mnd
+		mutex.Lock()
+		delivered = append(delivered, e.Number)
+		mutex.Unlock()
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.FromBookmark([]byte("bm")))
+
+	// Restart the server mid-stream, with entries pending to be delivered
+	require.Eventually(t, func() bool { return count() >= 10 }, 5*time.Second, time.Millisecond) //nolint:mnd
+	s = restartTestServer(t, s, dir)
+	for range 50 {
+		commitTestEntry(t, s)
+	}
+	require.Eventually(t, func() bool { return count() >= 130 }, 10*time.Second, 10*time.Millisecond) //nolint:mnd
+	time.Sleep(100 * time.Millisecond)                                                                //nolint:mnd
+
+	// From the bookmark entry to the last one, none skipped nor repeated
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Len(t, delivered, 130) //nolint:mnd
+	for i, num := range delivered {
+		assert.Equal(t, uint64(20+i), num) //nolint:mnd
+	}
+}
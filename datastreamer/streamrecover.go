@@ -0,0 +1,146 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// recoverTail validates the tail of the stream file against its header on open, reconciling them after a crash in
+// the middle of a write. The committed entries not fully present in the file are dropped from the header, and the
+// uncommitted entries written after the committed data (the header was not written) are cleared. A clean file is
+// left as is.
+func (f *StreamFile) recoverTail() error {
+	header := f.header
+	length, entries, err := f.scanCommittedTail(header)
+	if err != nil {
+		return err
+	}
+	if length != header.TotalLength || entries != header.TotalEntries {
+		log.Warnf("File %s header has %d total entries (length %d) but %d are present (length %d), recovering",
+			f.fileName, header.TotalEntries, header.TotalLength, entries, length)
+		err = f.reconcileHeader(length, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	cleared, err := f.clearUncommittedTail()
+	if err != nil {
+		return err
+	}
+	if cleared > 0 {
+		log.Infof("File %s had %d bytes of uncommitted entries after the committed data, cleared", f.fileName, cleared)
+	}
+	return nil
+}
+
+// scanCommittedTail reads the entries of the last data page with committed entries, returns the total length and
+// total entries of the committed entries fully present in the file
+func (f *StreamFile) scanCommittedTail(header HeaderEntry) (uint64, uint64, error) {
+	limit := min(header.TotalLength, f.maxLength)
+	for limit > PageHeaderSize {
+		_, pageStart := f.pageStart(limit - 1)
+		var (
+			found   bool
+			last    uint64 // Number of the last entry read
+			lastEnd uint64 // File position after the last entry read
+		)
+		pos, err := walkEntriesFrom(f.file, pageStart, limit, f.pageSize, func(pos uint64, e FileEntry) error {
+			found, last, lastEnd = true, e.Number, pos+uint64(e.Length)
+			return nil
+		})
+		if err != nil {
+			if !errors.Is(err, ErrExpectingPacketTypeData) && !errors.Is(err, ErrDecodingLengthDataEntry) &&
+				!errors.Is(err, ErrDecodingBinaryDataEntry) {
+				log.Errorf("Error reading the last entries of file %s: %v", f.fileName, err)
+				return 0, 0, err
+			}
+			log.Warnf("Invalid entry at %d of file %s: %v", pos, f.fileName, err)
+		}
+
+		// The committed entries end at the header length, with the last entry number just before the total entries
+		if found {
+			if err == nil && limit == header.TotalLength && last+1 == header.TotalEntries {
+				return header.TotalLength, header.TotalEntries, nil
+			}
+			return lastEnd, last + 1, nil
+		}
+
+		// No entries present in the data page, the previous one has the last ones
+		limit = pageStart
+	}
+
+	return PageHeaderSize, header.firstEntry, nil
+}
+
+// reconcileHeader sets and writes the header of the committed entries fully present in the file
+func (f *StreamFile) reconcileHeader(length uint64, entries uint64) error {
+	// Checksum of the entries kept in the last data page
+	tailCRC, err := f.truncatePageChecksums(length)
+	if err != nil {
+		return err
+	}
+
+	f.mutexHeader.Lock()
+	f.header.TotalEntries = entries
+	f.header.TotalLength = length
+	f.header.tailCRC = tailCRC
+	f.writtenHead = f.header
+	f.mutexHeader.Unlock()
+
+	return f.writeHeaderEntry()
+}
+
+// clearUncommittedTail zeroes the entries written after the committed data with the next entry numbers, complete
+// or not, returns the bytes cleared
+func (f *StreamFile) clearUncommittedTail() (uint64, error) {
+	pageSize := uint64(f.pageSize)
+	pos := f.header.TotalLength
+	end := pos
+	next := f.header.TotalEntries
+	buffer := make([]byte, FixedSizeFileEntry)
+	for pos+FixedSizeFileEntry <= f.maxLength {
+		_, err := f.file.ReadAt(buffer, int64(pos))
+		if err != nil {
+			log.Errorf("Error reading after the committed data of file %s: %v", f.fileName, err)
+			return 0, err
+		}
+		_, pageStart := f.pageStart(pos)
+
+		// Pad goes until the end of the page, an empty page ends the entries written
+		if buffer[0] == PtPadding {
+			if pos == pageStart {
+				break
+			}
+			pos = pageStart + pageSize
+			continue
+		}
+		if buffer[0] != PtData || binary.BigEndian.Uint64(buffer[9:17]) != next {
+			break
+		}
+
+		// An entry partially written goes until the end of the page at most
+		length := uint64(binary.BigEndian.Uint32(buffer[1:5]))
+		pos = min(pos+max(length, FixedSizeFileEntry), pageStart+pageSize)
+		end = pos
+		next++
+	}
+	if end == f.header.TotalLength {
+		return 0, nil
+	}
+
+	// Clear them by pages at most
+	zeros := make([]byte, min(end-f.header.TotalLength, pageSize))
+	for pos = f.header.TotalLength; pos < end; {
+		n := min(end-pos, uint64(len(zeros)))
+		_, err := f.file.WriteAt(zeros[:n], int64(pos))
+		if err != nil {
+			log.Errorf("Error clearing the uncommitted entries of file %s: %v", f.fileName, err)
+			return 0, err
+		}
+		pos += n
+	}
+	return end - f.header.TotalLength, nil
+}
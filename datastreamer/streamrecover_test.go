@@ -0,0 +1,109 @@
+package datastreamer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recoverEntrySize is the size of the entries of the recovery test files
+const recoverEntrySize = FixedSizeFileEntry + 100
+
+// recoverEntryPos returns the file position of an entry of the recovery test files in the first data page
+func recoverEntryPos(entryNum int64) int64 {
+	return PageHeaderSize + entryNum*recoverEntrySize
+}
+
+// writeRecoverFile writes a stream file with entries of 100 bytes, committing some of them, and closes it without
+// writing the header as in a crash
+func writeRecoverFile(t *testing.T, fileName string, committed int, uncommitted int) {
+	t.Helper()
+
+	sf, err := NewStreamFileWithOptions(fileName, 1, 12345, 1, StreamFileOptions{PageSize: MinPageSize})
+	require.NoError(t, err)
+	for range committed {
+		addTestEntry(t, sf, 100) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	for range uncommitted {
+		addTestEntry(t, sf, 100) //nolint:mnd
+	}
+	sf.closeFiles()
+}
+
+// checkRecoveredFile checks a stream file opened has some entries readable and takes new ones
+func checkRecoveredFile(t *testing.T, fileName string, entries uint64) {
+	t.Helper()
+
+	sf, err := NewStreamFileWithOptions(fileName, 1, 12345, 1, StreamFileOptions{PageSize: MinPageSize})
+	require.NoError(t, err)
+	defer sf.Close()
+	header := sf.getHeaderEntry()
+	assert.Equal(t, entries, header.TotalEntries)
+	assert.Equal(t, uint64(recoverEntryPos(int64(entries))), header.TotalLength)
+
+	addTestEntry(t, sf, 100) //nolint:mnd
+	require.NoError(t, sf.writeHeaderEntry())
+	for num := range entries + 1 {
+		e, err := readTestEntry(sf, num)
+		require.NoError(t, err)
+		assert.Equal(t, num, e.Number)
+	}
+}
+
+func TestRecoverTailClean(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	writeRecoverFile(t, fileName, 10, 0) //nolint:mnd
+	before, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	require.NoError(t, sf.Close())
+	after, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestRecoverTailTornEntry(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	writeRecoverFile(t, fileName, 10, 0) //nolint:mnd
+
+	// Header committed with the last entry not written completely
+	patchFile(t, fileName, recoverEntryPos(9), make([]byte, recoverEntrySize)) //nolint:mnd
+	checkRecoveredFile(t, fileName, 9)                                         //nolint:mnd
+
+	// Header committed with the last entry cut, its length beyond the committed data
+	fileName = filepath.Join(t.TempDir(), "stream.bin")
+	writeRecoverFile(t, fileName, 10, 0)                          //nolint:mnd
+	patchFile(t, fileName, recoverEntryPos(9)+1, []byte{0, 0, 9}) //nolint:mnd
+	checkRecoveredFile(t, fileName, 9)                            //nolint:mnd
+}
+
+func TestRecoverTailMissingPage(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	writeRecoverFile(t, fileName, 1000, 0) //nolint:mnd
+
+	// Data pages lost after the first one, which has 560 entries
+	require.NoError(t, os.Truncate(fileName, PageHeaderSize+MinPageSize))
+	checkRecoveredFile(t, fileName, 560) //nolint:mnd
+}
+
+func TestRecoverTailUncommitted(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+
+	// Entries written but not committed, the last one partially
+	writeRecoverFile(t, fileName, 5, 3)                                              //nolint:mnd
+	patchFile(t, fileName, recoverEntryPos(7)+50, make([]byte, recoverEntrySize-50)) //nolint:mnd
+
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	require.NoError(t, sf.Close())
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, make([]byte, 3*recoverEntrySize), data[recoverEntryPos(5):recoverEntryPos(8)]) //nolint:mnd
+	checkRecoveredFile(t, fileName, 5)                                                             //nolint:mnd
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/gateway-fm/zkevm-data-streamer/log"
 )
 
+// etPlaceholder is the entry type of the placeholders filling the entries tombstoned upstream
+const etPlaceholder EntryType = 0
+
 // StreamRelay type to manage a data stream relay
 type StreamRelay struct {
 	client *StreamClient
@@ -85,11 +88,21 @@ func relayEntry(e *FileEntry, c *StreamClient, s *StreamServer) error {
 		return err
 	}
 
+	// Fill the entries tombstoned upstream (not streamed) with placeholders to keep the entry numbers
+	firstGap := s.GetHeader().TotalEntries
+	for number := firstGap; number < e.Number && err == nil; number++ {
+		_, err = s.AddStreamEntry(etPlaceholder, nil)
+	}
+
 	// Add entry
-	if e.Type == EtBookmark {
-		_, err = s.AddStreamBookmark(e.Data)
-	} else {
-		_, err = s.AddStreamEntry(e.Type, e.Data)
+	if err == nil {
+		if _, to, ok := VersionChange(*e); ok && to > s.GetHeader().Version {
+			err = s.SetStreamVersion(to)
+		} else if e.Type == EtBookmark {
+			_, err = s.AddStreamBookmark(e.Data)
+		} else {
+			_, err = s.AddStreamEntry(e.Type, e.Data)
+		}
 	}
 
 	// Check if error adding entry
@@ -111,6 +124,15 @@ func relayEntry(e *FileEntry, c *StreamClient, s *StreamServer) error {
 		return err
 	}
 
+	// Keep the placeholders logically deleted as in the upstream server
+	for number := firstGap; number < e.Number; number++ {
+		err = s.Tombstone(number)
+		if err != nil {
+			log.Errorf("Error tombstoning entry %d: %v", number, err)
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -123,33 +145,16 @@ func (r *StreamRelay) Stop() error {
 			log.Errorf("Error stopping relay client: %v", err)
 			return err
 		}
-		r.client.closeConnection()
+		// Stopped for good, no reconnection nor failover to other upstream servers
+		r.client.stop()
 	}
 
 	// Stop the server
 	if r.server != nil {
-		// Close the listener to stop accepting new connections
-		if r.server.ln != nil {
-			err := r.server.ln.Close()
-			if err != nil {
-				log.Errorf("Error closing server listener: %v", err)
-				return err
-			}
-		}
-
-		// Kill all connected clients
-		r.server.mutexClients.Lock()
-		for clientID := range r.server.clients {
-			r.server.killClient(clientID)
-		}
-		r.server.mutexClients.Unlock()
-
-		// Mark server as stopped to prevent new atomic operations
-		r.server.started = false
-
-		// Close the stream channel to stop the broadcast goroutine
-		if r.server.stream != nil {
-			close(r.server.stream)
+		err := r.server.Close()
+		if err != nil {
+			log.Errorf("Error closing relay server: %v", err)
+			return err
 		}
 	}
 
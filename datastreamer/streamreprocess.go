@@ -0,0 +1,145 @@
+package datastreamer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// ReprocessFunc type for the function transforming each entry (bookmarks included) replayed by Reprocess
+type ReprocessFunc func(e FileEntry) (FileEntry, error)
+
+// Reprocess replays the committed entries of a stream file through the write path into a new stream file, applying
+// a transform to each entry (nil: identity), e.g. to re-derive a format field or transform the data of an existing
+// file. Entry numbers must be kept by the transform, so the output has the same entry numbers, tombstones and header
+// settings as the source, and a bookmarks DB with the bookmark entries written. The commit journal is not copied.
+// The output must not exist, and is removed if the reprocess fails.
+func Reprocess(srcFile, dstFile string, transform ReprocessFunc) error {
+	file, err := os.Open(srcFile)
+	if err != nil {
+		log.Errorf("Error opening file %s to reprocess: %v", srcFile, err)
+		return err
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file)
+	if err != nil {
+		return err
+	}
+	if header.encryption != encryptionNone {
+		log.Errorf("File %s to reprocess is encrypted", srcFile)
+		return ErrFileEncrypted
+	}
+
+	err = writeReprocessedFile(file, header, dstFile, transform)
+	if err != nil {
+		log.Errorf("Error reprocessing file %s into %s: %v", srcFile, dstFile, err)
+		return err
+	}
+
+	log.Infof("File %s reprocessed into %s, %d entries", srcFile, dstFile, header.TotalEntries-header.firstEntry)
+	return nil
+}
+
+// writeReprocessedFile creates the output stream file and its bookmarks DB, and writes the transformed entries of
+// the source file, removing the output if it fails
+func writeReprocessedFile(src *os.File, header HeaderEntry, fileName string, transform ReprocessFunc) error {
+	out, err := NewStreamFileWithOptions(fileName, header.Version, header.SystemID, header.streamType,
+		StreamFileOptions{CreateOnly: true, PageSize: header.PageSize(), Compression: header.compression})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if out.fileHeader != nil {
+			_ = out.fileHeader.Close()
+		}
+	}()
+
+	// Bookmarks DB directory created here so an existing one is not reused
+	bookmarkName := bookmarkDBName(fileName)
+	err = os.Mkdir(bookmarkName, os.ModePerm)
+	if errors.Is(err, os.ErrExist) {
+		log.Errorf("Bookmarks DB %s already exists", bookmarkName)
+		err = ErrOutputFileExists
+	}
+	if err != nil {
+		out.closeFiles()
+		_ = os.Remove(fileName)
+		return err
+	}
+	bookmark, err := NewBookmark(bookmarkName)
+	if err != nil {
+		out.closeFiles()
+		removeReprocessed(fileName, bookmarkName)
+		return err
+	}
+
+	// Same header settings as the source file
+	out.mutexHeader.Lock()
+	out.header.firstEntry = header.firstEntry
+	out.header.TotalEntries = header.firstEntry
+	out.header.numbering = header.numbering
+	out.header.metaCodec = header.metaCodec
+	out.mutexHeader.Unlock()
+
+	_, err = walkEntries(src, header.TotalLength, header.PageSize(), func(_ uint64, e FileEntry) error {
+		// The data read is shared with the next entries
+		e.Data = bytes.Clone(e.Data)
+		err = decompressEntry(header.compression, &e)
+		if err != nil {
+			return err
+		}
+		if transform != nil {
+			number := e.Number
+			e, err = transform(e)
+			if err != nil {
+				return err
+			}
+			if e.Number != number {
+				log.Errorf("Reprocessed entry %d renumbered to %d", number, e.Number)
+				return ErrInvalidEntryNumber
+			}
+			if e.Data == nil {
+				e.Data = []byte{}
+			}
+		}
+		e.packetType = PtData
+		e.Length = FixedSizeFileEntry + uint32(len(e.Data))
+
+		if e.Type == EtBookmark {
+			err = bookmark.AddBookmark(e.Data, e.Number)
+			if err != nil {
+				return err
+			}
+		}
+		return out.AddFileEntry(e)
+	})
+	if err == nil && out.header.TotalEntries != header.TotalEntries {
+		log.Errorf("Reprocessed %d entries but the header has %d", out.header.TotalEntries, header.TotalEntries)
+		err = ErrBadFileFormat
+	}
+
+	// Closing the stream file writes the header
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.closeFiles()
+	}
+	err = errors.Join(err, bookmark.Close())
+	if err != nil {
+		removeReprocessed(fileName, bookmarkName)
+	}
+	return err
+}
+
+// removeReprocessed removes a partial output of Reprocess
+func removeReprocessed(fileName string, bookmarkName string) {
+	if err := os.Remove(fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Errorf("Error removing reprocessed file %s: %v", fileName, err)
+	}
+	if err := os.RemoveAll(bookmarkName); err != nil {
+		log.Errorf("Error removing reprocessed bookmarks DB %s: %v", bookmarkName, err)
+	}
+}
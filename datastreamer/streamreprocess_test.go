@@ -0,0 +1,85 @@
+package datastreamer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReprocess(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	srcFile := s.fileName
+
+	// Entries over several pages, with bookmarks, a zero-length entry and a tombstone
+	require.NoError(t, s.StartAtomicOp())
+	for i := range 50 {
+		if i%10 == 0 {
+			_, err := s.AddStreamBookmark(binary.BigEndian.AppendUint64(nil, uint64(i)))
+			require.NoError(t, err)
+		}
+		_, err := s.AddStreamEntry(1, bytes.Repeat([]byte{byte(i)}, i*100)) //nolint:mnd
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.Tombstone(7)) //nolint:mnd
+	require.NoError(t, s.Close())
+
+	// Identity transform, the output equals the input byte-for-byte
+	dstFile := filepath.Join(dir, "identity.bin")
+	require.NoError(t, Reprocess(srcFile, dstFile, func(e FileEntry) (FileEntry, error) { return e, nil }))
+	src, err := os.ReadFile(srcFile)
+	require.NoError(t, err)
+	dst, err := os.ReadFile(dstFile)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(src, dst), "reprocessed file differs")
+
+	bm, err := NewBookmark(bookmarkDBName(dstFile))
+	require.NoError(t, err)
+	num, err := bm.GetBookmark(binary.BigEndian.AppendUint64(nil, 30)) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, uint64(33), num) //nolint:mnd
+	require.NoError(t, bm.Close())
+
+	// The output must not exist
+	require.ErrorIs(t, Reprocess(srcFile, dstFile, nil), ErrOutputFileExists)
+
+	// Transformed data keeping the entry numbers
+	dstFile = filepath.Join(dir, "transformed.bin")
+	require.NoError(t, Reprocess(srcFile, dstFile, func(e FileEntry) (FileEntry, error) {
+		if e.Type != EtBookmark {
+			e.Data = append(e.Data, 0xff) //nolint:mnd
+		}
+		return e, nil
+	}))
+	sf, err := NewStreamFile(dstFile, 1, 12345, 1)
+	require.NoError(t, err)
+	defer sf.Close()
+	var count int
+	for e, err := range sf.Entries(0, sf.getHeaderEntry().TotalEntries) {
+		require.NoError(t, err)
+		if e.Type != EtBookmark {
+			assert.Equal(t, byte(0xff), e.Data[len(e.Data)-1])
+		}
+		assert.NotEqual(t, uint64(7), e.Number)
+		count++
+	}
+	assert.Equal(t, 54, count) //nolint:mnd
+
+	// Renumbering fails, and the partial output is removed
+	dstFile = filepath.Join(dir, "renumbered.bin")
+	err = Reprocess(srcFile, dstFile, func(e FileEntry) (FileEntry, error) {
+		e.Number += 100
+		return e, nil
+	})
+	require.ErrorIs(t, err, ErrInvalidEntryNumber)
+	_, err = os.Stat(dstFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(bookmarkDBName(dstFile))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,116 @@
+package datastreamer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+const (
+	resyncSummaryV1        = 1                   // Summary version: last entry number and hash
+	resyncSummaryV1Size    = 1 + 8 + sha256.Size // Version, last entry number and hash
+	maxResyncSummaryLength = 1024                // Max length of the summary of a resync command
+	resyncHashPrefixSize   = 4 + 8               // Entry type and number hashed before the data
+)
+
+// ResyncSummary type for the summary of the state of a client sent with the resync command
+type ResyncSummary struct {
+	LastEntry uint64            // Number of the last entry the client has
+	Hash      [sha256.Size]byte // EntryHash of that entry
+}
+
+// EntryHash returns the hash identifying an entry as streamed: sha256 of the entry type (u32), number (u64) and data
+func EntryHash(e FileEntry) [sha256.Size]byte {
+	h := sha256.New()
+	prefix := make([]byte, 0, resyncHashPrefixSize)
+	prefix = binary.BigEndian.AppendUint32(prefix, uint32(e.Type))
+	prefix = binary.BigEndian.AppendUint64(prefix, e.Number)
+	_, _ = h.Write(prefix)
+	_, _ = h.Write(e.Data)
+	return [sha256.Size]byte(h.Sum(nil))
+}
+
+// encodeResyncSummary encodes the summary of a client to binary: version (u8), last entry (u64) and hash
+func encodeResyncSummary(summary ResyncSummary) []byte {
+	be := []byte{resyncSummaryV1}
+	be = binary.BigEndian.AppendUint64(be, summary.LastEntry)
+	be = append(be, summary.Hash[:]...)
+	return be
+}
+
+// decodeResyncSummary decodes the summary of a client from binary
+func decodeResyncSummary(b []byte) (ResyncSummary, error) {
+	if len(b) != resyncSummaryV1Size || b[0] != resyncSummaryV1 {
+		log.Error("Invalid binary resync summary")
+		return ResyncSummary{}, ErrDecodingResyncSummary
+	}
+	summary := ResyncSummary{LastEntry: binary.BigEndian.Uint64(b[1:9])}
+	copy(summary.Hash[:], b[9:])
+	return summary, nil
+}
+
+// ExecCommandResync executes client TCP command to resume streaming after the last entry the client has, checking
+// that entry is the same in the server. The server streams exactly the entries after it, or the command fails with
+// ErrStreamDivergence if the client state diverged from the stream (e.g. the stream was truncated or rewritten), so
+// the client can resync from an earlier point. A client without entries should use ExecCommandStart.
+func (c *StreamClient) ExecCommandResync(lastEntry FileEntry) error {
+	c.resyncSummary = ResyncSummary{LastEntry: lastEntry.Number, Hash: EntryHash(lastEntry)}
+	_, _, err := c.execCommand(CmdResync, false, lastEntry.Number+1, nil)
+	return err
+}
+
+// processCmdResync processes the TCP Resync command from the clients
+func (s *StreamServer) processCmdResync(client *client) error {
+	// Read the client summary parameter
+	length, err := readFullUint32(client)
+	if err != nil {
+		return err
+	}
+	if length > maxResyncSummaryLength {
+		log.Errorf("Client %s exceeded [%d] maximum allowed length [%d] for a resync summary",
+			client.clientID, length, maxResyncSummaryLength)
+		return ErrDecodingResyncSummary
+	}
+	b, err := readFullBytes(length, client)
+	if err != nil {
+		return err
+	}
+	summary, err := decodeResyncSummary(b)
+	if err != nil {
+		_ = s.sendResultEntry(uint32(CmdErrBadFromEntry), StrCommandErrors[CmdErrBadFromEntry], client)
+		return err
+	}
+
+	// Log
+	log.Debugf("Client %s command Resync after entry %d", client.clientID, summary.LastEntry)
+
+	// Entries before the first one stored in the file can't be checked
+	header := s.streamFile.getHeaderEntry()
+	if summary.LastEntry < header.firstEntry {
+		log.Errorf("Resync command invalid last entry %d for client %s", summary.LastEntry, client.clientID)
+		_ = s.sendResultEntry(uint32(CmdErrBadFromEntry), StrCommandErrors[CmdErrBadFromEntry], client)
+		return ErrStartCommandInvalidParamFromEntry
+	}
+
+	// The last entry of the client must be the same in the stream
+	var entry FileEntry
+	if summary.LastEntry < header.TotalEntries {
+		entry, err = s.GetEntry(summary.LastEntry)
+		if err != nil && !errors.Is(err, ErrEntryTombstoned) {
+			_ = s.sendResultEntry(uint32(CmdErrBadFromEntry), StrCommandErrors[CmdErrBadFromEntry], client)
+			return err
+		}
+	}
+	if summary.LastEntry >= header.TotalEntries || err != nil || EntryHash(entry) != summary.Hash {
+		log.Warnf("Client %s diverged from the stream at entry %d", client.clientID, summary.LastEntry)
+		_ = s.sendResultEntry(uint32(CmdErrDivergence), StrCommandErrors[CmdErrDivergence], client)
+		return ErrStreamDivergence
+	}
+
+	client.filter = nil
+	client.entryTypes = nil
+	client.projection = 0
+	return s.startFromEntry(client, summary.LastEntry+1)
+}
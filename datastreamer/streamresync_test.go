@@ -0,0 +1,78 @@
+package datastreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResyncSummary(t *testing.T) {
+	summary := ResyncSummary{LastEntry: 42, Hash: EntryHash(FileEntry{Type: 1, Number: 42, Data: []byte{1, 2}})} //nolint:mnd
+	decoded, err := decodeResyncSummary(encodeResyncSummary(summary))
+	require.NoError(t, err)
+	assert.Equal(t, summary, decoded)
+
+	_, err = decodeResyncSummary([]byte{2})
+	require.ErrorIs(t, err, ErrDecodingResyncSummary)
+
+	// The hash covers the type, number and data
+	e := FileEntry{Type: 1, Number: 42, Data: []byte{1, 2}} //nolint:mnd
+	assert.NotEqual(t, EntryHash(e), EntryHash(FileEntry{Type: 2, Number: 42, Data: []byte{1, 2}}))
+	assert.NotEqual(t, EntryHash(e), EntryHash(FileEntry{Type: 1, Number: 43, Data: []byte{1, 2}}))
+	assert.NotEqual(t, EntryHash(e), EntryHash(FileEntry{Type: 1, Number: 42, Data: []byte{1, 3}}))
+}
+
+func TestResync(t *testing.T) {
+	const (
+		have   = 30 // Entries the client has
+		behind = 12 // Entries the client is missing
+	)
+
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	for i := range have + behind {
+		_, err := s.AddStreamEntry(1, []byte{byte(i), 1, 2, 3})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	last, err := s.GetEntry(have - 1)
+	require.NoError(t, err)
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	received := make(chan uint64, 100) //nolint:mnd
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- e.Number
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+
+	// A diverged last entry is rejected and the client can resync again
+	diverged := last
+	diverged.Data = []byte{0xff}
+	require.ErrorIs(t, c.ExecCommandResync(diverged), ErrStreamDivergence)
+	ahead := FileEntry{Type: 1, Number: have + behind, Data: []byte{1}}
+	require.ErrorIs(t, c.ExecCommandResync(ahead), ErrStreamDivergence)
+
+	// Exactly the entries the client is missing
+	require.NoError(t, c.ExecCommandResync(last))
+	var numbers []uint64
+	for len(numbers) < behind {
+		select {
+		case num := <-received:
+			numbers = append(numbers, num)
+		case <-time.After(time.Second):
+			t.Fatalf("entries received %v", numbers)
+		}
+	}
+	for i, num := range numbers {
+		assert.Equal(t, uint64(have+i), num)
+	}
+	select {
+	case num := <-received:
+		t.Fatalf("unexpected entry %d received", num)
+	case <-time.After(50 * time.Millisecond): //nolint:mnd
+	}
+}
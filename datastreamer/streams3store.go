@@ -0,0 +1,374 @@
+package datastreamer
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	s3DefaultCachePages = 16 // Data pages kept in the cache of an S3 stream store by default
+)
+
+// errStopWalk stops walking the entries once the one searched is found
+var errStopWalk = errors.New("stop walk")
+
+// S3StoreOptions type for the settings of an S3 stream store
+type S3StoreOptions struct {
+	Client     *http.Client // Client of the range requests, e.g. with a transport signing them (nil: http.DefaultClient)
+	CachePages int          // Data pages kept in the LRU cache (0: s3DefaultCachePages)
+}
+
+// S3StreamStore type to read the entries of a stream file uploaded to an S3 object (or any HTTP server supporting
+// range requests), without a local copy. The object is byte-compatible with the stream file: the header and the data
+// pages are read with range requests, keeping the latest data pages read in an LRU cache. The store is read-only, the
+// object is expected to be immutable once uploaded.
+type S3StreamStore struct {
+	url       string
+	client    *http.Client
+	header    HeaderEntry
+	pageSize  uint32
+	numPages  uint64
+	maxPages  int
+	pages     map[uint64]*list.Element // Cached data pages by page number
+	lru       *list.List               // Cached data pages, most recently used first
+	bookmarks map[string]uint64        // Bookmarks index, built on the first GetBookmark
+	mutex     sync.Mutex               // Protects the cache and the bookmarks index
+}
+
+// s3Page type for a data page in the cache of an S3 stream store
+type s3Page struct {
+	num  uint64
+	data []byte
+}
+
+// NewS3StreamStore opens the stream file of an S3 object URL (e.g. presigned) reading its header
+func NewS3StreamStore(url string, opts S3StoreOptions) (*S3StreamStore, error) {
+	s := S3StreamStore{
+		url:      url,
+		client:   opts.Client,
+		maxPages: opts.CachePages,
+		pages:    make(map[uint64]*list.Element),
+		lru:      list.New(),
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if s.maxPages <= 0 {
+		s.maxPages = s3DefaultCachePages
+	}
+
+	header, err := readFileHeader(readerAtFunc(s.readRange))
+	if err != nil {
+		return nil, err
+	}
+	if header.encryption != encryptionNone {
+		log.Errorf("Stream file at %s is encrypted", url)
+		return nil, ErrFileEncrypted
+	}
+	if header.TotalLength < PageHeaderSize || header.firstEntry > header.TotalEntries {
+		log.Errorf("Invalid header of stream file at %s: total length %d, first entry %d, total entries %d", url,
+			header.TotalLength, header.firstEntry, header.TotalEntries)
+		return nil, ErrBadFileFormat
+	}
+	s.header = header
+	s.pageSize = header.PageSize()
+	s.numPages = (header.TotalLength - PageHeaderSize + uint64(s.pageSize) - 1) / uint64(s.pageSize)
+
+	log.Infof("Stream file at %s opened read-only with %d entries", url, header.TotalEntries-header.firstEntry)
+	return &s, nil
+}
+
+// readerAtFunc type to use a function as an io.ReaderAt
+type readerAtFunc func(b []byte, off int64) (int, error)
+
+// ReadAt reads len(b) bytes at an offset calling the function
+func (f readerAtFunc) ReadAt(b []byte, off int64) (int, error) {
+	return f(b, off)
+}
+
+// readRange reads len(b) bytes of the object at an offset with a range request
+func (s *S3StreamStore) readRange(b []byte, off int64) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		log.Errorf("Error creating range request for %s: %v", s.url, err)
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(b))-1))
+
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		log.Errorf("Error requesting bytes %d to %d of %s: %v", off, off+int64(len(b)), s.url, err)
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusPartialContent {
+		log.Errorf("Error requesting bytes %d to %d of %s: status %s", off, off+int64(len(b)), s.url, rsp.Status)
+		return 0, fmt.Errorf("%w: status %s", ErrRangeRequestFailed, rsp.Status)
+	}
+
+	n, err := io.ReadFull(rsp.Body, b)
+	if err != nil {
+		log.Errorf("Error reading bytes %d to %d of %s: %v", off, off+int64(len(b)), s.url, err)
+		return n, err
+	}
+	return n, nil
+}
+
+// getPage returns the committed bytes of a data page, from the cache or requesting them
+func (s *S3StreamStore) getPage(num uint64) ([]byte, error) {
+	s.mutex.Lock()
+	if e, ok := s.pages[num]; ok {
+		s.lru.MoveToFront(e)
+		s.mutex.Unlock()
+		return e.Value.(*s3Page).data, nil
+	}
+	s.mutex.Unlock()
+
+	start := PageHeaderSize + num*uint64(s.pageSize)
+	data := make([]byte, min(uint64(s.pageSize), s.header.TotalLength-start))
+	_, err := s.readRange(data, int64(start))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.pages[num]; !ok {
+		s.pages[num] = s.lru.PushFront(&s3Page{num: num, data: data})
+		if s.lru.Len() > s.maxPages {
+			oldest := s.lru.Remove(s.lru.Back()).(*s3Page)
+			delete(s.pages, oldest.num)
+		}
+	}
+	return data, nil
+}
+
+// readCached reads the committed bytes of the data pages at an offset through the page cache
+func (s *S3StreamStore) readCached(b []byte, off int64) (int, error) {
+	pos := uint64(off)
+	if pos < PageHeaderSize || pos+uint64(len(b)) > s.header.TotalLength {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(b) {
+		num := (pos - PageHeaderSize) / uint64(s.pageSize)
+		page, err := s.getPage(num)
+		if err != nil {
+			return n, err
+		}
+		c := copy(b[n:], page[pos-PageHeaderSize-num*uint64(s.pageSize):])
+		n += c
+		pos += uint64(c)
+	}
+	return n, nil
+}
+
+// GetHeader returns the header of the stream file
+func (s *S3StreamStore) GetHeader() HeaderEntry {
+	return s.header
+}
+
+// readEntryAt reads the entry at a file position (or the first one of the next page if it's the page pad),
+// returning the positions where it starts and after it
+func (s *S3StreamStore) readEntryAt(pos uint64) (FileEntry, uint64, uint64, error) {
+	var entry FileEntry
+	var start uint64
+	r := readerAtFunc(s.readCached)
+	_, err := walkEntriesFrom(r, pos, s.header.TotalLength, s.pageSize, func(pos uint64, e FileEntry) error {
+		entry, start = e, pos
+		return errStopWalk
+	})
+	if err == nil {
+		return FileEntry{}, pos, pos, io.EOF
+	} else if !errors.Is(err, errStopWalk) {
+		return FileEntry{}, pos, pos, err
+	}
+
+	next := start + uint64(entry.Length)
+	entry.Data = bytes.Clone(entry.Data)
+	err = decompressEntry(s.header.compression, &entry)
+	if err != nil {
+		return FileEntry{}, pos, pos, err
+	}
+	return entry, start, next, nil
+}
+
+// locateEntry returns the file position of an entry, searching the data page containing it
+func (s *S3StreamStore) locateEntry(entryNum uint64) (uint64, error) {
+	if entryNum < s.header.firstEntry || entryNum >= s.header.TotalEntries {
+		log.Infof("Invalid entry number %d, committed entries %d to %d", entryNum, s.header.firstEntry,
+			s.header.TotalEntries)
+		return 0, ErrInvalidEntryNumber
+	}
+
+	// Binary search of the last page starting with an entry number not greater than the one searched
+	low, high := uint64(0), s.numPages
+	for high-low > 1 {
+		mid := (low + high) / 2 //nolint:mnd
+		e, _, _, err := s.readEntryAt(PageHeaderSize + mid*uint64(s.pageSize))
+		if err != nil {
+			return 0, err
+		}
+		if e.Number <= entryNum {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	// Walk the entries of the page
+	pos := PageHeaderSize + low*uint64(s.pageSize)
+	for {
+		e, start, next, err := s.readEntryAt(pos)
+		if err != nil {
+			return 0, err
+		}
+		if e.Number == entryNum {
+			return start, nil
+		} else if e.Number > entryNum {
+			log.Errorf("Entry %d not found in the stream file at %s", entryNum, s.url)
+			return 0, ErrEntryNotFound
+		}
+		pos = next
+	}
+}
+
+// GetEntry returns an entry of the stream file, failing with ErrEntryTombstoned if it's tombstoned
+func (s *S3StreamStore) GetEntry(entryNum uint64) (FileEntry, error) {
+	pos, err := s.locateEntry(entryNum)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	entry, _, _, err := s.readEntryAt(pos)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	if entry.Tombstoned {
+		return FileEntry{}, ErrEntryTombstoned
+	}
+	return entry, nil
+}
+
+// GetBookmark returns the entry number of a bookmark. The bookmarks index is built on the first call reading all the
+// bookmark entries of the stream file.
+func (s *S3StreamStore) GetBookmark(bookmark []byte) (uint64, error) {
+	s.mutex.Lock()
+	bookmarks := s.bookmarks
+	s.mutex.Unlock()
+
+	if bookmarks == nil {
+		bookmarks = make(map[string]uint64)
+		r := readerAtFunc(s.readCached)
+		_, err := walkEntriesFrom(r, PageHeaderSize, s.header.TotalLength, s.pageSize, func(_ uint64, e FileEntry) error {
+			if e.Type != EtBookmark || e.Tombstoned {
+				return nil
+			}
+			err := decompressEntry(s.header.compression, &e)
+			if err != nil {
+				return err
+			}
+			bookmarks[string(e.Data)] = e.Number
+			return nil
+		})
+		if err != nil {
+			log.Errorf("Error indexing the bookmarks of the stream file at %s: %v", s.url, err)
+			return 0, err
+		}
+		s.mutex.Lock()
+		s.bookmarks = bookmarks
+		s.mutex.Unlock()
+	}
+
+	entryNum, ok := bookmarks[string(bookmark)]
+	if !ok {
+		return 0, leveldb.ErrNotFound
+	}
+	return entryNum, nil
+}
+
+// AddStreamEntry fails with ErrReadOnlyStore, the store is read-only
+func (s *S3StreamStore) AddStreamEntry(etype EntryType, data []byte) (uint64, error) {
+	return 0, ErrReadOnlyStore
+}
+
+// AddStreamBookmark fails with ErrReadOnlyStore, the store is read-only
+func (s *S3StreamStore) AddStreamBookmark(bookmark []byte) (uint64, error) {
+	return 0, ErrReadOnlyStore
+}
+
+// S3Iterator type to read the entries of an S3 stream store sequentially from a start entry number
+type S3Iterator struct {
+	store *S3StreamStore
+	opts  IteratorOptions
+	pos   uint64    // File position of the next entry
+	entry FileEntry // Entry read by the latest call to Next
+}
+
+// GetIterator returns an iterator starting from an entry number. Starting at the tail returns end, as there are no
+// entries added to the store (BeyondTailWait behaves the same beyond the tail).
+func (s *S3StreamStore) GetIterator(fromEntry uint64, opts IteratorOptions) (*S3Iterator, error) {
+	if fromEntry < s.header.firstEntry {
+		log.Errorf("Invalid starting entry number %d for iterator, first entry is %d", fromEntry,
+			s.header.firstEntry)
+		return nil, ErrInvalidEntryNumber
+	}
+	if fromEntry > s.header.TotalEntries && opts.BeyondTail == BeyondTailError {
+		log.Errorf("Starting entry number %d for iterator beyond the tail %d", fromEntry, s.header.TotalEntries)
+		return nil, ErrStartBeyondTail
+	}
+
+	it := S3Iterator{
+		store: s,
+		opts:  opts,
+		pos:   s.header.TotalLength,
+	}
+	if fromEntry < s.header.TotalEntries {
+		pos, err := s.locateEntry(fromEntry)
+		if err != nil {
+			return nil, err
+		}
+		it.pos = pos
+	}
+	return &it, nil
+}
+
+// Next reads the next entry, returns true at the end of the entries.
+// Tombstoned entries are skipped unless IncludeTombstones is set.
+func (it *S3Iterator) Next() (bool, error) {
+	for {
+		if it.pos >= it.store.header.TotalLength {
+			return true, nil
+		}
+		e, _, next, err := it.store.readEntryAt(it.pos)
+		if errors.Is(err, io.EOF) {
+			it.pos = it.store.header.TotalLength
+			return true, nil
+		} else if err != nil {
+			return true, err
+		}
+		it.pos = next
+		if it.opts.IncludeTombstones || !e.Tombstoned {
+			it.entry = e
+			return false, nil
+		}
+	}
+}
+
+// GetEntry returns the entry read by the latest call to Next
+func (it *S3Iterator) GetEntry() FileEntry {
+	return it.entry
+}
+
+// End finalizes the iterator
+func (it *S3Iterator) End() {
+	it.pos = it.store.header.TotalLength
+}
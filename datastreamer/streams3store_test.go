@@ -0,0 +1,144 @@
+package datastreamer
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// newTestS3Object writes a compressed stream file of 200 entries over several data pages (bookmark every 50 entries,
+// entry 10 tombstoned) and serves it with range requests, counting them
+func newTestS3Object(t *testing.T) (*StreamFile, string, *atomic.Int64) {
+	t.Helper()
+
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	sf, err := NewStreamFileWithOptions(fileName, 1, 12345, 1,
+		StreamFileOptions{PageSize: MinPageSize, Compression: CompressionZstd})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sf.Close() })
+
+	rnd := rand.NewChaCha8([32]byte{}) // Incompressible data
+	for num := range uint64(200) {
+		if num%50 == 0 {
+			bookmark := []byte{0, byte(num)}
+			require.NoError(t, sf.AddFileEntry(FileEntry{
+				packetType: PtData,
+				Length:     uint32(FixedSizeFileEntry + len(bookmark)),
+				Type:       EtBookmark,
+				Number:     num,
+				Data:       bookmark,
+			}))
+			continue
+		}
+		data := make([]byte, 1000) //nolint:mnd
+		_, _ = rnd.Read(data)
+		require.NoError(t, sf.AddFileEntry(FileEntry{
+			packetType: PtData,
+			Length:     uint32(FixedSizeFileEntry + len(data)),
+			Type:       1,
+			Number:     num,
+			Data:       data,
+		}))
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	require.NoError(t, sf.tombstoneEntry(10)) //nolint:mnd
+
+	object, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "stream.bin", time.Time{}, bytes.NewReader(object))
+	}))
+	t.Cleanup(srv.Close)
+
+	return sf, srv.URL + "/stream.bin", &requests
+}
+
+func TestS3StreamStore(t *testing.T) {
+	sf, url, requests := newTestS3Object(t)
+	store, err := NewS3StreamStore(url, S3StoreOptions{CachePages: 2})
+	require.NoError(t, err)
+
+	header := store.GetHeader()
+	assert.Equal(t, sf.getHeaderEntry().TotalEntries, header.TotalEntries)
+	assert.Equal(t, sf.getHeaderEntry().TotalLength, header.TotalLength)
+	assert.Greater(t, store.numPages, uint64(2))
+
+	// Entries as read from the local file, data decompressed
+	for _, num := range []uint64{0, 1, 63, 64, 65, 100, 150, 199} {
+		e, err := store.GetEntry(num)
+		require.NoError(t, err)
+		local, err := readTestEntry(sf, num)
+		require.NoError(t, err)
+		assert.Equal(t, local, e)
+	}
+	_, err = store.GetEntry(10) //nolint:mnd
+	require.ErrorIs(t, err, ErrEntryTombstoned)
+	_, err = store.GetEntry(200) //nolint:mnd
+	require.ErrorIs(t, err, ErrInvalidEntryNumber)
+
+	// Iterator skipping the tombstoned entry
+	it, err := store.GetIterator(5, IteratorOptions{}) //nolint:mnd
+	require.NoError(t, err)
+	var nums []uint64
+	for {
+		end, err := it.Next()
+		require.NoError(t, err)
+		if end {
+			break
+		}
+		nums = append(nums, it.GetEntry().Number)
+	}
+	it.End()
+	assert.Len(t, nums, 194) //nolint:mnd
+	assert.Equal(t, uint64(5), nums[0])
+	assert.Equal(t, uint64(11), nums[5])
+	assert.Equal(t, uint64(199), nums[len(nums)-1])
+	_, err = store.GetIterator(201, IteratorOptions{}) //nolint:mnd
+	require.ErrorIs(t, err, ErrStartBeyondTail)
+
+	// Bookmarks
+	entryNum, err := store.GetBookmark([]byte{0, 150})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(150), entryNum)
+	_, err = store.GetBookmark([]byte{0, 151})
+	require.ErrorIs(t, err, leveldb.ErrNotFound)
+
+	// Repeated reads in a cached page don't request it again
+	_, err = store.GetEntry(199) //nolint:mnd
+	require.NoError(t, err)
+	before := requests.Load()
+	for range 10 {
+		_, err = store.GetEntry(199) //nolint:mnd
+		require.NoError(t, err)
+	}
+	assert.Equal(t, before, requests.Load())
+	assert.LessOrEqual(t, store.lru.Len(), 2)
+
+	// Writes
+	_, err = store.AddStreamEntry(1, []byte{1})
+	require.ErrorIs(t, err, ErrReadOnlyStore)
+	_, err = store.AddStreamBookmark([]byte{1})
+	require.ErrorIs(t, err, ErrReadOnlyStore)
+}
+
+func TestS3StreamStoreRangeNotSupported(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(make([]byte, PageHeaderSize))
+	}))
+	defer srv.Close()
+
+	_, err := NewS3StreamStore(srv.URL, S3StoreOptions{})
+	require.ErrorIs(t, err, ErrRangeRequestFailed)
+}
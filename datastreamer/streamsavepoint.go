@@ -0,0 +1,171 @@
+package datastreamer
+
+import (
+	"io"
+	"slices"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// fileSavepoint type for the state of a stream file at a savepoint of the atomic operation in progress
+type fileSavepoint struct {
+	header HeaderEntry // Header in memory, with the length and the entries written until the savepoint
+	undo   int         // Entries updated until the savepoint
+}
+
+// savepoint type for a named savepoint of the atomic operation in progress
+type savepoint struct {
+	name    string
+	file    fileSavepoint // State of the stream file
+	deleted int           // Bookmarks deleted until the savepoint
+}
+
+// savepoint returns the current state of the atomic operation in progress in the file
+func (f *StreamFile) savepoint() fileSavepoint {
+	f.mutexHeader.Lock()
+	defer f.mutexHeader.Unlock()
+	return fileSavepoint{header: f.header, undo: len(f.undo)}
+}
+
+// rollbackToSavepoint restores the state of the file at a savepoint: the entries written after it are discarded and
+// the entries updated after it restored. Returns if any entry was restored.
+func (f *StreamFile) rollbackToSavepoint(sp fileSavepoint) (bool, error) {
+	updated := len(f.undo) > sp.undo
+	err := f.undoUpdatesFrom(sp.undo)
+	if err != nil {
+		return false, err
+	}
+
+	// Restore header in memory
+	f.mutexHeader.Lock()
+	f.header = sp.header
+	f.mutexHeader.Unlock()
+
+	// Set file position to write
+	_, err = f.file.Seek(int64(f.header.TotalLength), io.SeekStart)
+	if err != nil {
+		log.Errorf("Error seeking new position to write: %v", err)
+		return false, err
+	}
+
+	return updated, nil
+}
+
+// Savepoint marks the current state of the atomic operation in progress with a name, so the changes made after it can
+// be undone with RollbackToSavepoint without rolling back the whole atomic operation. A savepoint with the name of a
+// previous one hides it until it's released.
+func (s *StreamServer) Savepoint(name string) error {
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.atomicOp.status != aoStarted {
+		log.Errorf("Savepoint not allowed, AtomicOp is not started")
+		return ErrSavepointNotAllowed
+	}
+
+	s.atomicOp.savepoints = append(s.atomicOp.savepoints, savepoint{
+		name:    name,
+		file:    s.streamFile.savepoint(),
+		deleted: len(s.atomicOp.deleted),
+	})
+	return nil
+}
+
+// RollbackToSavepoint undoes the changes made in the atomic operation in progress after a savepoint: the entries
+// added are discarded, and the entries updated and the bookmarks deleted restored. The savepoint is kept, the ones
+// set after it are released.
+func (s *StreamServer) RollbackToSavepoint(name string) error {
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	i, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	sp := s.atomicOp.savepoints[i]
+
+	// Entries to discard, and their bookmarks
+	kept := slices.IndexFunc(s.atomicOp.entries, func(e FileEntry) bool { return e.Number >= sp.file.header.TotalEntries })
+	if kept < 0 {
+		kept = len(s.atomicOp.entries)
+	}
+	var bookmarks [][]byte
+	for _, e := range s.atomicOp.entries[kept:] {
+		if e.Type == EtBookmark {
+			bookmarks = append(bookmarks, e.Data)
+		}
+	}
+	discarded := len(s.atomicOp.entries) - kept
+
+	// Restore the file state (rollback entries and updates)
+	updated, err := s.streamFile.rollbackToSavepoint(sp.file)
+	if err != nil {
+		return err
+	}
+	if updated && s.prefetch != nil {
+		s.prefetch.invalidate()
+	}
+	if updated && s.catchUpCache != nil {
+		s.catchUpCache.invalidate()
+	}
+
+	// Rollback the entry number
+	s.nextEntry = s.streamFile.header.TotalEntries
+	s.atomicOp.entries = s.atomicOp.entries[:kept]
+	s.atomicOp.savepoints = s.atomicOp.savepoints[:i+1]
+
+	// Restore the bookmarks deleted, then handle the bookmarks of the discarded entries
+	err = s.restoreDeletedBookmarks(s.atomicOp.deleted[sp.deleted:])
+	if err != nil {
+		return err
+	}
+	s.atomicOp.deleted = s.atomicOp.deleted[:sp.deleted]
+	if len(bookmarks) > 0 {
+		err = s.pruneBookmarks(s.nextEntry, bookmarks)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("Rolled back to savepoint %s, %d entries discarded", name, discarded)
+	return nil
+}
+
+// ReleaseSavepoint removes a savepoint of the atomic operation in progress, and the ones set after it, keeping the
+// changes made after them
+func (s *StreamServer) ReleaseSavepoint(name string) error {
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	i, err := s.findSavepoint(name)
+	if err != nil {
+		return err
+	}
+	s.atomicOp.savepoints = s.atomicOp.savepoints[:i]
+	return nil
+}
+
+// findSavepoint returns the position of the latest savepoint with a name in the atomic operation in progress
+func (s *StreamServer) findSavepoint(name string) (int, error) {
+	if s.atomicOp.status != aoStarted {
+		log.Errorf("Savepoint not allowed, AtomicOp is not started")
+		return 0, ErrSavepointNotAllowed
+	}
+
+	for i := len(s.atomicOp.savepoints) - 1; i >= 0; i-- {
+		if s.atomicOp.savepoints[i].name == name {
+			return i, nil
+		}
+	}
+	log.Errorf("Savepoint %s not found in the atomic operation", name)
+	return 0, ErrSavepointNotFound
+}
@@ -0,0 +1,82 @@
+package datastreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSavepoints(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	require.ErrorIs(t, s.Savepoint("a"), ErrSavepointNotAllowed)
+	require.ErrorIs(t, s.RollbackToSavepoint("a"), ErrSavepointNotAllowed)
+	addTestBookmarks(t, s, "b0")
+
+	addEntry := func(data byte) {
+		_, err := s.AddStreamEntry(1, []byte{data})
+		require.NoError(t, err)
+	}
+
+	// Entries after the savepoint discarded, the ones before kept
+	require.NoError(t, s.StartAtomicOp())
+	addEntry(1)
+	require.NoError(t, s.Savepoint("a"))
+	addEntry(2) //nolint:mnd
+	_, err := s.AddStreamBookmark([]byte("b1"))
+	require.NoError(t, err)
+	require.NoError(t, s.UpdateEntryData(0, EtBookmark, []byte("c0")))
+	require.NoError(t, s.DeleteStreamBookmark([]byte("b0")))
+	require.NoError(t, s.Savepoint("b"))
+	addEntry(3) //nolint:mnd
+	require.NoError(t, s.RollbackToSavepoint("a"))
+	assert.Equal(t, uint64(2), s.streamFile.header.TotalEntries)
+	require.ErrorIs(t, s.RollbackToSavepoint("b"), ErrSavepointNotFound)
+
+	// Updates and bookmarks restored
+	entry, err := s.GetEntry(0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b0"), entry.Data)
+	entryNum, err := s.GetBookmark([]byte("b0"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), entryNum)
+	_, err = s.GetBookmark([]byte("b1"))
+	require.ErrorIs(t, err, ErrStoreKeyNotFound)
+
+	// Savepoint kept after the rollback, released with the changes kept
+	addEntry(4) //nolint:mnd
+	require.NoError(t, s.RollbackToSavepoint("a"))
+	addEntry(5) //nolint:mnd
+	require.NoError(t, s.Savepoint("c"))
+	addEntry(6) //nolint:mnd
+	require.NoError(t, s.ReleaseSavepoint("a"))
+	require.ErrorIs(t, s.RollbackToSavepoint("c"), ErrSavepointNotFound)
+	require.NoError(t, s.CommitAtomicOp())
+
+	header := s.GetHeader()
+	assert.Equal(t, uint64(4), header.TotalEntries)
+	for num, data := range []byte{1, 5, 6} {
+		entry, err := s.GetEntry(uint64(num + 1))
+		require.NoError(t, err)
+		assert.Equal(t, []byte{data}, entry.Data)
+	}
+
+	// Savepoints released by the commit
+	require.NoError(t, s.StartAtomicOp())
+	require.ErrorIs(t, s.RollbackToSavepoint("a"), ErrSavepointNotFound)
+
+	// Latest savepoint with a name used, then the whole atomic operation rolled back
+	require.NoError(t, s.Savepoint("a"))
+	addEntry(7) //nolint:mnd
+	require.NoError(t, s.Savepoint("a"))
+	addEntry(8) //nolint:mnd
+	require.NoError(t, s.RollbackToSavepoint("a"))
+	assert.Equal(t, uint64(5), s.streamFile.header.TotalEntries)
+	require.NoError(t, s.ReleaseSavepoint("a"))
+	require.NoError(t, s.RollbackToSavepoint("a"))
+	assert.Equal(t, uint64(4), s.streamFile.header.TotalEntries)
+	addEntry(9) //nolint:mnd
+	require.NoError(t, s.RollbackAtomicOp())
+	assert.Equal(t, header, s.GetHeader())
+	assert.Equal(t, header.TotalLength, s.streamFile.header.TotalLength)
+}
@@ -1,20 +1,27 @@
 package datastreamer
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"math"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Command type for the TCP client commands
@@ -42,6 +49,8 @@ const (
 	maxConnections    = 100 // Maximum number of connected clients
 	streamBuffer      = 256 // Buffers for the stream channel
 	maxBookmarkLength = 16  // Maximum number of bytes for a bookmark
+
+	defaultCheckpointInterval = 1000 // Default number of entries between download checkpoints
 )
 
 const (
@@ -52,6 +61,34 @@ const (
 	CmdEntry                            // CmdEntry for the get entry TCP client command
 	CmdBookmark                         // CmdBookmark for the get bookmark TCP client command
 	CmdRangeBookmark                    // CmdRangeBookmark for the start and end bookmarks TCP client command
+	CmdDownload                         // CmdDownload for the historical download with checkpoints TCP client command
+
+	// CmdStartBookmarkPrefix for the start filtered by bookmark prefix TCP client command
+	CmdStartBookmarkPrefix Command = CmdDownload + 1
+	// CmdCapabilities for the server capabilities TCP client command
+	CmdCapabilities Command = CmdStartBookmarkPrefix + 1
+	// CmdResync for the start after the last entry of the client checking its hash TCP client command
+	CmdResync Command = CmdCapabilities + 1
+	// CmdPing for the round-trip time measurement TCP client command
+	CmdPing Command = CmdResync + 1
+	// CmdStartProjection for the start streaming just the selected fields of the entries TCP client command
+	CmdStartProjection Command = CmdPing + 1
+	// CmdHeartbeat for the heartbeats negotiation (the first one) and acknowledgement TCP client command
+	CmdHeartbeat Command = CmdStartProjection + 1
+	// CmdWireCompression for the wire compression negotiation TCP client command, with the codecs supported by the
+	// client in the high bits of the command
+	CmdWireCompression Command = CmdHeartbeat + 1
+	// CmdTraceContext for the trace context of the entries streamed negotiation TCP client command
+	CmdTraceContext Command = CmdWireCompression + 1
+	// CmdEntryChecksums for the checksum of the entries sent negotiation TCP client command
+	CmdEntryChecksums Command = CmdTraceContext + 1
+
+	// CmdOptMaxLatency option of the start TCP client command (in the high bits of the command): a MaxLatency
+	// parameter follows the from entry
+	CmdOptMaxLatency Command = 1 << 32
+	// CmdOptEntryTypes option of the start TCP client command (in the high bits of the command): the entry types
+	// streamed (u32 count + u32 each) follow the from entry and the max latency
+	CmdOptEntryTypes Command = 1 << 33
 )
 
 const (
@@ -62,6 +99,21 @@ const (
 	CmdErrBadFromBookmark                     // CmdErrBadFromBookmark for invalid starting bookmark
 	CmdErrBadToBookmark                       // CmdErrBadToBookmark for invalid to bookmark
 	CmdErrInvalidCommand  CommandError = 9    // CmdErrInvalidCommand for invalid/unknown command error
+
+	// CmdErrDivergence for the client state diverging from the stream
+	CmdErrDivergence CommandError = CmdErrInvalidCommand + 1
+	// CmdErrInvalidFilter for an empty or too large entry type filter
+	CmdErrInvalidFilter CommandError = CmdErrDivergence + 1
+	// CmdErrMaxClients for a new connection beyond the max clients, closed by the server
+	CmdErrMaxClients CommandError = CmdErrInvalidFilter + 1
+)
+
+// DuplicateStartMode type for the behavior on a CmdStart from a client already streaming
+type DuplicateStartMode uint8
+
+const (
+	DuplicateStartReject  DuplicateStartMode = iota // DuplicateStartReject rejects it with ErrAlreadyStreaming
+	DuplicateStartRestart                           // DuplicateStartRestart restarts the streaming from the new entry
 )
 
 const (
@@ -98,6 +150,21 @@ var (
 		CmdEntry:         "Entry",
 		CmdBookmark:      "Bookmark",
 		CmdRangeBookmark: "CmdRangeBookmark",
+		CmdDownload:      "Download",
+
+		CmdStartBookmarkPrefix: "StartBookmarkPrefix",
+		CmdCapabilities:        "Capabilities",
+		CmdResync:              "Resync",
+		CmdPing:                "Ping",
+		CmdStartProjection:     "StartProjection",
+		CmdHeartbeat:           "Heartbeat",
+		CmdWireCompression:     "WireCompression",
+		CmdTraceContext:        "TraceContext",
+		CmdEntryChecksums:      "EntryChecksums",
+
+		CmdStart | CmdOptMaxLatency:                    "StartMaxLatency",
+		CmdStart | CmdOptEntryTypes:                    "StartEntryTypes",
+		CmdStart | CmdOptMaxLatency | CmdOptEntryTypes: "StartMaxLatencyEntryTypes",
 	}
 
 	// StrCommandErrors for TCP command errors description
@@ -109,6 +176,9 @@ var (
 		CmdErrBadFromBookmark: "Bad from bookmark",
 		CmdErrBadToBookmark:   "Bad to bookmark",
 		CmdErrInvalidCommand:  "Invalid command",
+		CmdErrDivergence:      "Divergence",
+		CmdErrInvalidFilter:   "Invalid filter",
+		CmdErrMaxClients:      "Max clients reached",
 	}
 )
 
@@ -133,10 +203,76 @@ type StreamServer struct {
 	nextEntry uint64 // Next sequential entry number
 	initEntry uint64 // Only used by the relay (initial next entry in the master server)
 
-	atomicOp   streamAO      // Current in progress (if any) atomic operation
-	stream     chan streamAO // Channel to stream committed atomic operations
-	streamFile *StreamFile
-	bookmark   *StreamBookmark
+	atomicOp   streamAO       // Current in progress (if any) atomic operation
+	stream     chan streamAO  // Channel to stream committed atomic operations
+	lowLatency chan streamAO  // Channel to stream committed atomic operations to the clients with a latency target
+	done       chan struct{}  // Channel closed when the server is closed
+	wg         sync.WaitGroup // Server goroutines (broadcast and inactivity check)
+	wgClients  sync.WaitGroup // Client connection goroutines
+
+	inBroadcast  atomic.Int64 // Committed atomic operations pending to be sent by each broadcast goroutine
+	shutdownOnce sync.Once    // Graceful shutdown done once
+	streamFile   *StreamFile
+	bookmark     *StreamBookmark
+	journal      *StreamJournal // Commit journal (nil: not enabled)
+
+	onRollback     func(discardedEntries []FileEntry) // Callback invoked after a rollback with the discarded entries
+	onBookmark     func(key []byte, entryNum uint64)  // Callback invoked for each committed bookmark
+	bookmarkEvents chan FileEntry                     // Committed bookmark entries pending to be notified
+	duplicateStart DuplicateStartMode                 // Behavior on a CmdStart from a client already streaming
+	catchUpRate    *rateMeter                         // Entries per second sent to the clients catching up
+
+	maxInFlightBytes uint64           // Max bytes buffered to be sent to a client (0: written directly, no buffer)
+	slowClient       SlowClientPolicy // Behavior when a client reaches the max in-flight bytes
+	clientQueueSize  int              // Max entries queued to be sent to a client by the broadcast (0: no limit)
+
+	writeTransform DataTransform // Transform of the entries data before being stored (nil: none)
+	readTransform  DataTransform // Transform of the stored entries data read by the query API (nil: none)
+
+	commitSync CommitSyncMode // How the commits are flushed to disk
+	groupSync  *groupSync     // Group commit (nil: not enabled)
+
+	syncInterval time.Duration // Period of the flushes with interval commit sync
+	unsynced     atomic.Int64  // Time (unix nanoseconds) of the oldest commit not flushed with interval sync (0: none)
+
+	trackQueueDelay bool // Track the queue delay of the entries streamed to each client
+
+	bookmarkPruning BookmarkPruning // Handling of the bookmarks of the entries removed by truncate or rollback
+
+	writesPaused atomic.Bool // Writes paused by PauseWrites (StartAtomicOp not allowed)
+
+	prefetch *prefetcher // Read cache of GetEntry warmed with the next entries (nil: not enabled)
+
+	catchUpCache *catchUpCache // Latest committed entries streamed to the clients catching up (nil: not enabled)
+
+	verifyWrites    bool // Read back the entries of the atomic operations before committing them
+	validateEntries bool // Check the data of the registered entry types added decodes into their messages
+
+	tlsConfig *tls.Config // TLS configuration of the client connections (nil: plain TCP)
+
+	heartbeatInterval time.Duration // Idle time before a heartbeat is sent to a client, and to acknowledge it (0: none)
+
+	wireCodecs []Codec // Codecs accepted to compress the entries streamed, in order of preference (nil: none)
+
+	tracer trace.Tracer // Tracer of the atomic operations and their broadcasts (nil: no tracing)
+
+	maxClients       int          // Max clients connected at once (0: maxConnections)
+	connectedClients atomic.Int64 // Clients connected, counted until their connection is handled
+
+	allowedCIDRs []*net.IPNet // Networks allowed to connect (nil: all)
+	deniedCIDRs  []*net.IPNet // Networks not allowed to connect, over the allowed ones (nil: none)
+
+	atomicOpTimeout  time.Duration // Max time of an atomic operation before it's rolled back (0: no limit)
+	atomicOpTimer    *time.Timer   // Rollback on timeout of the atomic operation in progress (nil: none)
+	atomicOpSeq      uint64        // Sequence number of the latest atomic operation started with a timeout
+	atomicOpTimedOut bool          // Atomic operation rolled back on timeout, not reported yet to the writer
+	mutexAtomicOp    sync.Mutex    // Serializes the atomic operations with their rollback on timeout
+
+	health      *http.Server // HTTP server of the health probes (nil: not started)
+	healthAddr  net.Addr     // Address where the health probes are served
+	healthState atomic.Int32 // Lifecycle state reported by the readiness probe
+
+	monotonicTime bool // Reject the time bookmarks earlier than the latest one
 }
 
 // streamAO type to manage atomic operations
@@ -144,6 +280,17 @@ type streamAO struct {
 	status     AOStatus
 	startEntry uint64
 	entries    []FileEntry
+	committed  time.Time
+	deleted    []BookmarkResult // Bookmarks deleted, with the entry number to restore on rollback
+	span       trace.Span       // Span of the atomic operation (nil: no tracing)
+
+	savepoints []savepoint // Savepoints set, the latest last
+}
+
+// StreamEntryInput type for an entry to add with AddStreamEntries
+type StreamEntryInput struct {
+	Type EntryType
+	Data []byte
 }
 
 // client type for the server to manage clients
@@ -153,12 +300,89 @@ type client struct {
 	fromEntry    uint64
 	clientID     string
 	lastActivity time.Time
+	outbox       *sendQueue         // Entries broadcast pending to be sent (nil: written directly)
+	filter       *bookmarkFilter    // Filter of the entries streamed by bookmark prefix (nil: all the entries)
+	entryTypes   map[EntryType]bool // Entry types streamed set by the start command (nil: all the entries)
+	queueDelay   *latencyRecorder   // Delay from the commit to the write of the entries broadcast (nil: not tracked)
+	mutex        sync.Mutex         // Mutex for the status and the last activity
+
+	maxLatency    atomic.Int64  // Latency target of the entries broadcast set by the start command (0: none)
+	latencyMisses atomic.Uint64 // Entries broadcast written after the latency target
+
+	projection ProjectionField // Fields of the entries streamed by a projection subscription (0: full entries)
+
+	heartbeat     atomic.Bool  // Heartbeats negotiated by the client
+	heartbeatSent atomic.Int64 // Time (unix nanoseconds) of the heartbeat pending to be acknowledged (0: none)
+
+	wireCodec atomic.Uint32 // Codec of the entries data negotiated by the client (Codec)
+
+	traceContext atomic.Bool // Trace context of the entries streamed negotiated by the client
+
+	entryChecksums atomic.Bool // Checksum of the entries sent negotiated by the client
+}
+
+// bookmarkFilter type to stream only the entries marked by a bookmark with a key prefix. The bookmarks just before
+// a data entry mark it (several bookmarks can mark the same entries, e.g. a batch and its first block), and the
+// entries are marked until the next bookmark. The matching bookmarks and the entries they mark are delivered.
+type bookmarkFilter struct {
+	prefix   []byte
+	inMarks  bool // Reading the bookmarks marking the next entries
+	matching bool // The latest bookmarks read include a matching one
+}
+
+// accept returns if an entry passes the filter, the entries must be given in order
+func (f *bookmarkFilter) accept(e FileEntry) bool {
+	if e.Type == EtBookmark {
+		if !f.inMarks {
+			f.inMarks = true
+			f.matching = false
+		}
+		if bytes.HasPrefix(e.Data, f.prefix) {
+			f.matching = true
+			return true
+		}
+		return false
+	}
+	f.inMarks = false
+	return f.matching
 }
 
 func (c *client) updateActivity() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.lastActivity = time.Now()
 }
 
+func (c *client) getLastActivity() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastActivity
+}
+
+func (c *client) getStatus() ClientStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.status
+}
+
+func (c *client) setStatus(status ClientStatus) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.status = status
+}
+
+// ClientInfo type for the information of a connected client
+type ClientInfo struct {
+	ID        string       // Client remote address
+	Status    ClientStatus // Streaming status (description in StrClientStatus)
+	BatchSize uint64       // Bytes written at once, adapted to the client speed (0: written directly, no buffer)
+
+	QueueDelay *LatencyHistogram // Delay from the commit to the write of the entries streamed (nil: not tracked)
+
+	MaxLatency    time.Duration // Latency target requested with the start command (0: none)
+	LatencyMisses uint64        // Entries written to the client after its latency target
+}
+
 // ResultEntry type for a result entry
 type ResultEntry struct {
 	packetType uint8 // 0xff:Result
@@ -167,10 +391,31 @@ type ResultEntry struct {
 	errorStr   []byte
 }
 
+// DownloadCheckpoint type for the checkpoints sent by the server in a historical download.
+// A failed download can be resumed from the checkpoint instead of restarting from the first entry.
+type DownloadCheckpoint struct {
+	Entry   uint64 // Next entry number to download
+	Offset  uint64 // Offset in the server stream file of the next entry (hint to resume without searching)
+	ToEntry uint64 // Entry number where the download ends (excluding)
+}
+
+// Done returns if the download is complete at the checkpoint
+func (cp DownloadCheckpoint) Done() bool {
+	return cp.Entry >= cp.ToEntry
+}
+
 // NewServer creates a new data stream server
 func NewServer(port uint16, version uint8, systemID uint64, streamType StreamType, fileName string,
 	writeTimeout time.Duration, inactivityTimeout time.Duration, inactivityCheckInterval time.Duration,
 	cfg *log.Config) (*StreamServer, error) {
+	return NewServerWithFileOptions(port, version, systemID, streamType, fileName, writeTimeout, inactivityTimeout,
+		inactivityCheckInterval, cfg, StreamFileOptions{})
+}
+
+// NewServerWithFileOptions creates a new data stream server with stream file options
+func NewServerWithFileOptions(port uint16, version uint8, systemID uint64, streamType StreamType, fileName string,
+	writeTimeout time.Duration, inactivityTimeout time.Duration, inactivityCheckInterval time.Duration,
+	cfg *log.Config, opts StreamFileOptions) (*StreamServer, error) {
 	// Create the server data stream
 	s := StreamServer{
 		port:                    port,
@@ -193,12 +438,16 @@ func NewServer(port uint16, version uint8, systemID uint64, streamType StreamTyp
 			startEntry: 0,
 			entries:    []FileEntry{},
 		},
-		stream: make(chan streamAO, streamBuffer),
+		stream:         make(chan streamAO, streamBuffer),
+		lowLatency:     make(chan streamAO, streamBuffer),
+		bookmarkEvents: make(chan FileEntry, streamBuffer),
+		done:           make(chan struct{}),
+
+		catchUpRate: newRateMeter(defaultRateWindow),
 	}
 
-	// Get the directory and filename separately
+	// Get the directory
 	dir := filepath.Dir(s.fileName)
-	base := filepath.Base(s.fileName)
 
 	// Add file extension if not present
 	if filepath.Ext(s.fileName) == "" {
@@ -217,7 +466,7 @@ func NewServer(port uint16, version uint8, systemID uint64, streamType StreamTyp
 
 	// Open (or create) the data stream file
 	var err error
-	s.streamFile, err = NewStreamFile(s.fileName, version, systemID, s.streamType)
+	s.streamFile, err = NewStreamFileWithOptions(s.fileName, version, systemID, s.streamType, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -225,12 +474,17 @@ func NewServer(port uint16, version uint8, systemID uint64, streamType StreamTyp
 	// Initialize the data entry number
 	s.nextEntry = s.streamFile.header.TotalEntries
 
-	// Remove any extension from the base filename and add .db
-	baseWithoutExt := strings.TrimSuffix(base, filepath.Ext(base))
+	// Flush policy of the stream file, SetCommitSync may change it before Start
+	if opts.CommitSync != CommitSyncNone {
+		s.SetCommitSync(opts.CommitSync, opts.CommitSyncWindow)
+	}
 
-	// Open (or create) the bookmarks DB
-	name := filepath.Join(dir, baseWithoutExt+".db")
-	s.bookmark, err = NewBookmark(name)
+	// Open (or create) the bookmarks DB, or use the bookmark store given
+	if opts.BookmarkStore != nil {
+		s.bookmark, err = NewBookmarkWithStores(opts.BookmarkStore, opts.BookmarkIndexStore)
+	} else {
+		s.bookmark, err = NewBookmark(bookmarkDBName(s.fileName))
+	}
 	if err != nil {
 		return &s, err
 	}
@@ -238,6 +492,20 @@ func NewServer(port uint16, version uint8, systemID uint64, streamType StreamTyp
 	return &s, nil
 }
 
+// bookmarkDBName returns the bookmarks DB name for a stream file (same name with .db extension)
+func bookmarkDBName(fileName string) string {
+	base := filepath.Base(fileName)
+	baseWithoutExt := strings.TrimSuffix(base, filepath.Ext(base))
+	return filepath.Join(filepath.Dir(fileName), baseWithoutExt+".db")
+}
+
+// journalDBName returns the commit journal DB name for a stream file (same name with .journal extension)
+func journalDBName(fileName string) string {
+	base := filepath.Base(fileName)
+	baseWithoutExt := strings.TrimSuffix(base, filepath.Ext(base))
+	return filepath.Join(filepath.Dir(fileName), baseWithoutExt+".journal")
+}
+
 // Start opens access to TCP clients and starts broadcasting
 func (s *StreamServer) Start() error {
 	// Start the server data stream
@@ -247,25 +515,62 @@ func (s *StreamServer) Start() error {
 		log.Errorf("Error creating datastream server %d: %v", s.port, err)
 		return err
 	}
+	if s.tlsConfig != nil {
+		s.ln = tls.NewListener(s.ln, s.tlsConfig)
+	}
+
+	s.startServing()
+
+	// Goroutine to wait for clients connections
+	log.Infof("Listening on port: %d", s.port)
+	go s.waitConnections(s.ln)
+
+	return nil
+}
 
+// startServing starts the server goroutines, the client connections are given by the caller
+func (s *StreamServer) startServing() {
 	// Goroutine to broadcast committed atomic operations
+	s.wg.Add(1)
 	go s.broadcastAtomicOp()
+	s.wg.Add(1)
+	go s.broadcastLowLatency()
 
 	// Goroutine to check inactivity timeout in client connections
+	s.wg.Add(1)
 	go s.checkClientInactivity()
 
-	// Goroutine to wait for clients connections
-	log.Infof("Listening on port: %d", s.port)
-	go s.waitConnections()
+	// Goroutine to notify the committed bookmarks
+	s.wg.Add(1)
+	go s.notifyBookmarks()
+
+	// Goroutine to flush the groups of commits
+	if s.groupSync != nil {
+		s.wg.Add(1)
+		go s.runGroupSync()
+	}
+
+	// Goroutine to flush the commits periodically
+	if s.syncInterval > 0 {
+		s.wg.Add(1)
+		go s.runIntervalSync()
+	}
+
+	// Goroutine to send the heartbeats to the idle clients
+	if s.heartbeatInterval > 0 {
+		s.wg.Add(1)
+		go s.runHeartbeat()
+	}
 
 	// Flag stared
 	s.started = true
-
-	return nil
+	s.healthState.Store(healthServing)
 }
 
 // checkClientInactivity kills all the clients that reach write inactivity timeout
 func (s *StreamServer) checkClientInactivity() {
+	defer s.wg.Done()
+
 	ticker := time.NewTicker(s.inactivityCheckInterval)
 	defer ticker.Stop()
 
@@ -275,7 +580,7 @@ func (s *StreamServer) checkClientInactivity() {
 			var clientsToKill = map[string]struct{}{}
 			s.mutexClients.Lock()
 			for _, client := range s.clients {
-				if client.lastActivity.Add(s.inactivityTimeout).Before(time.Now()) {
+				if client.getLastActivity().Add(s.inactivityTimeout).Before(time.Now()) {
 					clientsToKill[client.clientID] = struct{}{}
 				}
 			}
@@ -285,20 +590,20 @@ func (s *StreamServer) checkClientInactivity() {
 				log.Warnf("killing inactive client %s", clientID)
 				s.killClient(clientID)
 			}
-		case <-s.stream:
+		case <-s.done:
 			return
 		}
 	}
 }
 
 // waitConnections waits for a new client connection and creates a goroutine to manages it
-func (s *StreamServer) waitConnections() {
-	defer s.ln.Close()
+func (s *StreamServer) waitConnections(ln net.Listener) {
+	defer ln.Close()
 
 	const timeout = 2 * time.Second
 
 	for {
-		conn, err := s.ln.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
 			// Exit loop if listener is closed
 			if errors.Is(err, net.ErrClosed) {
@@ -309,11 +614,9 @@ func (s *StreamServer) waitConnections() {
 			continue
 		}
 
-		// Check max connections allowed
-		if s.getSafeClientsLen() >= maxConnections {
-			log.Warnf("Unable to accept client connection, maximum number of connections reached (%d)", maxConnections)
+		// Check the remote address is allowed
+		if !s.isAddrAllowed(conn.RemoteAddr()) {
 			conn.Close()
-			time.Sleep(timeout)
 			continue
 		}
 
@@ -329,7 +632,24 @@ func (s *StreamServer) handleConnection(conn net.Conn) {
 	clientID := conn.RemoteAddr().String()
 	log.Debugf("New connection: %s", clientID)
 
+	// Check max clients allowed
+	if !s.acquireClient() {
+		s.rejectClient(conn, clientID)
+		return
+	}
+	defer s.releaseClient()
+
 	s.mutexClients.Lock()
+	select {
+	case <-s.done:
+		// Server closed
+		s.mutexClients.Unlock()
+		return
+	default:
+	}
+	s.wgClients.Add(1)
+	defer s.wgClients.Done()
+
 	client := &client{
 		conn:         conn,
 		status:       csStopped,
@@ -337,6 +657,20 @@ func (s *StreamServer) handleConnection(conn net.Conn) {
 		clientID:     clientID,
 		lastActivity: time.Now(),
 	}
+	if s.trackQueueDelay {
+		client.queueDelay = newLatencyRecorder()
+	}
+	if s.maxInFlightBytes > 0 || s.clientQueueSize > 0 {
+		maxBytes := s.maxInFlightBytes
+		if maxBytes == 0 {
+			maxBytes = math.MaxUint64
+		}
+		client.outbox = newSendQueue(maxBytes, s.slowClient, s.clientQueueSize)
+		go client.outbox.run(client, s.writeTimeout, func(err error) {
+			log.Warnf("Error sending entry to %s, error: %v", clientID, err)
+			s.killClient(clientID)
+		})
+	}
 	s.clients[clientID] = client
 	s.mutexClients.Unlock()
 
@@ -384,12 +718,23 @@ func (s *StreamServer) StartAtomicOp() error {
 	start := time.Now().UnixNano()
 	defer log.Debugf("StartAtomicOp process time: %vns", time.Now().UnixNano()-start)
 
-	log.Debugf("!AtomicOp START (%d)", s.nextEntry)
 	// Check status of the server
 	if !s.started {
 		log.Errorf("AtomicOp not allowed. Server is not started")
 		return ErrAtomicOpNotAllowed
 	}
+	if s.writesPaused.Load() {
+		log.Errorf("AtomicOp not allowed. Writes are paused")
+		return ErrWritesPaused
+	}
+	// Wait for the atomic operation in progress with group commit
+	if s.groupSync != nil {
+		s.groupSync.acquire()
+	}
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+	s.atomicOpTimedOut = false
+	log.Debugf("!AtomicOp START (%d)", s.nextEntry)
 	// Check status of the atomic operation
 	if s.atomicOp.status == aoStarted {
 		log.Errorf("AtomicOp already started and in progress after entry %d", s.atomicOp.startEntry)
@@ -398,40 +743,224 @@ func (s *StreamServer) StartAtomicOp() error {
 
 	s.atomicOp.status = aoStarted
 	s.atomicOp.startEntry = s.nextEntry
+	s.streamFile.startAtomicUpdates()
+	s.startAtomicOpSpan()
+	s.startAtomicOpTimer()
 	return nil
 }
 
-// AddStreamEntry adds a new entry in the current atomic operation
+// AddStreamEntry adds a new entry in the current atomic operation, the data may be empty (zero-length entry)
 func (s *StreamServer) AddStreamEntry(etype EntryType, data []byte) (uint64, error) {
 	start := time.Now().UnixNano()
 	defer log.Debugf("AddStreamEntry process time: %vns", time.Now().UnixNano()-start)
 
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return s.addStreamEntry(etype, data)
+}
+
+// addStreamEntry adds a new entry with the next entry number in the current atomic operation
+func (s *StreamServer) addStreamEntry(etype EntryType, data []byte) (uint64, error) {
+	// Entry numbers assigned by the server
+	err := s.setNumbering(numberingAuto)
+	if err != nil {
+		return 0, err
+	}
+
 	// Add to the stream file
 	entryNum, err := s.addStream("Data", etype, data)
 
 	return entryNum, err
 }
 
+// AddStreamEntries adds new entries in the current atomic operation as successive AddStreamEntry calls, writing the
+// entries of each data page at once. Returns the entry numbers assigned, in order. An invalid entry adds none of them,
+// on a write error the entries written before it remain in the atomic operation.
+func (s *StreamServer) AddStreamEntries(entries []StreamEntryInput) ([]uint64, error) {
+	start := time.Now().UnixNano()
+	defer log.Debugf("AddStreamEntries process time: %vns", time.Now().UnixNano()-start)
+
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Entry numbers assigned by the server
+	err = s.setNumbering(numberingAuto)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check atomic operation status
+	if s.atomicOp.status != aoStarted {
+		log.Errorf("Add stream entry not allowed, AtomicOp is not started")
+		return nil, ErrAddEntryNotAllowed
+	}
+
+	// Generate the data entries
+	fileEntries := make([]FileEntry, 0, len(entries))
+	for i, input := range entries {
+		e, err := s.newStreamEntry("Data", input.Type, input.Data, s.nextEntry+uint64(i))
+		if err != nil {
+			return nil, err
+		}
+		fileEntries = append(fileEntries, e)
+	}
+
+	// Update header (in memory) and write the data entries into the file
+	written, err := s.streamFile.AddFileEntries(fileEntries)
+	s.atomicOp.entries = append(s.atomicOp.entries, fileEntries[:written]...)
+	s.nextEntry += uint64(written)
+	if err != nil {
+		return nil, err
+	}
+
+	entryNums := make([]uint64, len(fileEntries))
+	for i, e := range fileEntries {
+		entryNums[i] = e.Number
+	}
+	return entryNums, nil
+}
+
+// AddStreamEntryWithNumber adds a new entry with a caller-provided entry number in the current atomic operation.
+// Numbers must be contiguous with the current tail, or set the base entry number if the file has no entries.
+func (s *StreamServer) AddStreamEntryWithNumber(num uint64, etype EntryType, data []byte) error {
+	start := time.Now().UnixNano()
+	defer log.Debugf("AddStreamEntryWithNumber process time: %vns", time.Now().UnixNano()-start)
+
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Check and set the entry number
+	err = s.setEntryNumber(num)
+	if err != nil {
+		return err
+	}
+
+	// Add to the stream file
+	_, err = s.addStream("Data", etype, data)
+
+	return err
+}
+
+// AddStreamBookmarkWithNumber adds a new bookmark with a caller-provided entry number in the current atomic operation
+func (s *StreamServer) AddStreamBookmarkWithNumber(num uint64, bookmark []byte) error {
+	start := time.Now().UnixNano()
+	defer log.Debugf("AddStreamBookmarkWithNumber process time: %vns", time.Now().UnixNano()-start)
+
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Check and set the entry number
+	err = s.setEntryNumber(num)
+	if err != nil {
+		return err
+	}
+
+	// Add to the stream file and to the bookmark index
+	return s.addStreamBookmark(bookmark)
+}
+
+// setEntryNumber checks a caller-provided entry number and prepares the next entry number to use it
+func (s *StreamServer) setEntryNumber(num uint64) error {
+	// Check atomic operation status
+	if s.atomicOp.status != aoStarted {
+		log.Errorf("Add stream entry not allowed, AtomicOp is not started")
+		return ErrAddEntryNotAllowed
+	}
+
+	// Check numbering mode
+	err := s.setNumbering(numberingCaller)
+	if err != nil {
+		return err
+	}
+
+	// A file without entries starts at the given entry number
+	if s.nextEntry == s.streamFile.header.firstEntry && num != s.nextEntry {
+		log.Infof("Starting stream file at entry number %d", num)
+		s.streamFile.setFirstEntry(num)
+		s.nextEntry = num
+		s.atomicOp.startEntry = num
+	}
+
+	// Check contiguous with the tail
+	if num != s.nextEntry {
+		log.Errorf("Invalid entry number %d to add, expected %d", num, s.nextEntry)
+		return ErrEntryNumberNotContiguous
+	}
+
+	return nil
+}
+
+// setNumbering checks the entry numbering mode of the file is not mixed, and sets it
+func (s *StreamServer) setNumbering(mode numberingMode) error {
+	current := s.streamFile.header.numbering
+	if current == numberingUnset && s.nextEntry > s.streamFile.header.firstEntry {
+		// Entries written before the numbering mode was recorded
+		current = numberingAuto
+	}
+
+	if current != numberingUnset && current != mode {
+		log.Errorf("Mixing automatic and caller-provided entry numbers not allowed")
+		return ErrNumberingModeMismatch
+	}
+
+	if s.streamFile.header.numbering != mode {
+		s.streamFile.setNumbering(mode)
+	}
+	return nil
+}
+
 // AddStreamBookmark adds a new bookmark in the current atomic operation
 func (s *StreamServer) AddStreamBookmark(bookmark []byte) (uint64, error) {
 	start := time.Now().UnixNano()
 	defer log.Debugf("AddStreamBookmark process time: %vns", time.Now().UnixNano()-start)
 
-	// Add to the stream file
-	entryNum, err := s.addStream("Bookmark", EtBookmark, bookmark)
+	unlock, err := s.lockAtomicOp()
 	if err != nil {
 		return 0, err
 	}
+	defer unlock()
 
-	// Add to the bookmark index
-	err = s.bookmark.AddBookmark(bookmark, entryNum)
+	// Entry numbers assigned by the server
+	err = s.setNumbering(numberingAuto)
+	if err != nil {
+		return 0, err
+	}
+
+	// Add to the stream file and to the bookmark index
+	entryNum := s.nextEntry
+	err = s.addStreamBookmark(bookmark)
 	if err != nil {
-		return entryNum, err
+		return 0, err
 	}
 
 	return entryNum, nil
 }
 
+// addStreamBookmark adds a new bookmark entry to the stream file and to the bookmark index
+func (s *StreamServer) addStreamBookmark(bookmark []byte) error {
+	// Add to the stream file
+	entryNum, err := s.addStream("Bookmark", EtBookmark, bookmark)
+	if err != nil {
+		return err
+	}
+
+	// Add to the bookmark index
+	return s.bookmark.AddBookmark(bookmark, entryNum)
+}
+
 // addStream adds a new stream entry in the current atomic operation
 func (s *StreamServer) addStream(desc string, etype EntryType, data []byte) (uint64, error) {
 	// Check atomic operation status
@@ -441,21 +970,15 @@ func (s *StreamServer) addStream(desc string, etype EntryType, data []byte) (uin
 	}
 
 	// Generate data entry
-	e := FileEntry{
-		packetType: PtData,
-		Length:     1 + 4 + 4 + 8 + uint32(len(data)),
-		Type:       etype,
-		Number:     s.nextEntry,
-		Data:       data,
+	e, err := s.newStreamEntry(desc, etype, data, s.nextEntry)
+	if err != nil {
+		return 0, err
 	}
 
-	// Log data entry fields
-	log.Debugf("%s entry: %d | %d | %d | %d | %d", desc, e.Number, e.packetType, e.Length, e.Type, len(data))
-
 	// Update header (in memory) and write data entry into the file
-	err := s.streamFile.AddFileEntry(e)
+	err = s.streamFile.AddFileEntry(e)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 
 	// Save the entry in the atomic operation in progress
@@ -467,10 +990,92 @@ func (s *StreamServer) addStream(desc string, etype EntryType, data []byte) (uin
 	return e.Number, nil
 }
 
+// newStreamEntry generates a new data entry with its entry number, transforming the data to store
+func (s *StreamServer) newStreamEntry(desc string, etype EntryType, data []byte, num uint64) (FileEntry, error) {
+	// Check the entry type doesn't use the tombstone flag
+	if etype&tombstoneFlag != 0 {
+		log.Errorf("Invalid entry type %d, highest bit reserved", etype)
+		return FileEntry{}, ErrInvalidEntryType
+	}
+
+	// Validate the data of the registered entry types
+	if s.validateEntries && etype != EtBookmark {
+		err := validateEntry(etype, data)
+		if err != nil {
+			return FileEntry{}, err
+		}
+	}
+
+	// Transform the data to store
+	if s.writeTransform != nil && etype != EtBookmark && etype != EtVersionChange {
+		var err error
+		data, err = s.writeTransform(etype, data)
+		if err != nil {
+			log.Errorf("Error transforming %s entry data: %v", desc, err)
+			return FileEntry{}, err
+		}
+	}
+
+	// Zero-length data is a valid entry, kept as an empty (non-nil) slice as when it's read back
+	if data == nil {
+		data = []byte{}
+	}
+
+	e := FileEntry{
+		packetType: PtData,
+		Length:     1 + 4 + 4 + 8 + uint32(len(data)),
+		Type:       etype,
+		Number:     num,
+		Data:       data,
+	}
+
+	// Log data entry fields
+	log.Debugf("%s entry: %d | %d | %d | %d | %d", desc, e.Number, e.packetType, e.Length, e.Type, len(data))
+
+	return e, nil
+}
+
 // CommitAtomicOp commits the current atomic operation and streams it to the clients
 func (s *StreamServer) CommitAtomicOp() error {
+	return s.commitAtomicOp(nil)
+}
+
+// CommitAtomicOpWithMeta commits the current atomic operation recording application metadata (e.g. the L1 tx hash
+// that triggered it) in the commit journal, encoded with the metadata codec of the stream file, and streams it to the
+// clients. The metadata is not recorded if the atomic operation has no entries.
+func (s *StreamServer) CommitAtomicOpWithMeta(meta Metadata) error {
+	if s.journal == nil {
+		log.Errorf("Commit with metadata not allowed, commit journal not enabled")
+		return ErrCommitJournalDisabled
+	}
+
+	// Encode the metadata
+	codec := s.streamFile.MetadataCodec()
+	if codec == nil {
+		log.Errorf("Commit with metadata not allowed, metadata codec not registered")
+		return ErrUnknownMetadataCodec
+	}
+	encoded, err := codec.Encode(meta)
+	if err != nil {
+		log.Errorf("Error encoding commit metadata: %v", err)
+		return err
+	}
+
+	return s.commitAtomicOp(encoded)
+}
+
+// commitAtomicOp commits the current atomic operation with its encoded metadata and streams it to the clients
+func (s *StreamServer) commitAtomicOp(meta []byte) error {
 	start := time.Now()
 
+	// Locked until ended, not while waiting for a group flush
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return err
+	}
+	unlock = sync.OnceFunc(unlock)
+	defer unlock()
+
 	log.Debugf("committing datastream atomic operation, startEntry: %d", s.atomicOp.startEntry)
 	if s.atomicOp.status != aoStarted {
 		log.Errorf("commit not allowed, atomic operation is not in the started state")
@@ -479,26 +1084,63 @@ func (s *StreamServer) CommitAtomicOp() error {
 
 	s.atomicOp.status = aoCommitting
 
+	// Read back the entries written before committing them
+	if s.verifyWrites {
+		err := s.streamFile.verifyWrites(s.atomicOp.entries)
+		if err != nil {
+			s.atomicOp.status = aoStarted
+			return err
+		}
+	}
+
+	// Record the commit in the journal
+	journaled := s.journal != nil && len(s.atomicOp.entries) > 0
+	if journaled {
+		err := s.journal.AddCommit(CommitRecord{
+			FirstEntry: s.atomicOp.entries[0].Number,
+			Timestamp:  time.Now(),
+			Meta:       meta,
+		})
+		if err != nil {
+			s.atomicOp.status = aoStarted
+			return err
+		}
+	}
+
 	// Update header into the file (commit the new entries)
-	err := s.streamFile.writeHeaderEntry()
+	err = s.streamFile.writeHeaderEntry()
 	if err != nil {
+		// Remove the record of the entries not committed
+		if journaled {
+			if err2 := s.journal.DeleteCommit(s.atomicOp.entries[0].Number); err2 != nil {
+				log.Errorf("Error removing commit record of entry %d: %v", s.atomicOp.entries[0].Number, err2)
+			}
+		}
 		return err
 	}
 
+	// Keep the committed entries for the clients catching up
+	if s.catchUpCache != nil {
+		s.catchUpCache.add(s.atomicOp.entries)
+	}
+
 	// Do broadcast of the committed atomic operation to the stream clients
 	atomic := streamAO{
 		status:     s.atomicOp.status,
 		startEntry: s.atomicOp.startEntry,
+		committed:  time.Now(),
+		span:       s.atomicOp.span,
 	}
 	atomic.entries = make([]FileEntry, len(s.atomicOp.entries))
 	copy(atomic.entries, s.atomicOp.entries)
 
-	s.stream <- atomic
-
-	// No atomic operation in progress
-	s.clearAtomicOp()
+	// Flush (if enabled) and broadcast, no atomic operation in progress then
+	err = s.syncCommit(atomic, unlock)
+	if err != nil {
+		return err
+	}
 
-	log.Debugf("committed datastream atomic operation, startEntry: %d, time: %v", s.atomicOp.startEntry, time.Since(start))
+	log.Debugf("committed datastream atomic operation, startEntry: %d, time: %v", atomic.startEntry, time.Since(start))
 
 	return nil
 }
@@ -508,31 +1150,146 @@ func (s *StreamServer) RollbackAtomicOp() error {
 	start := time.Now().UnixNano()
 	defer log.Debugf("RollbackAtomicOp process time: %vns", time.Now().UnixNano()-start)
 
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return err
+	}
+	discarded, err := s.rollbackAtomicOp()
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	// Notify the discarded entries
+	if s.onRollback != nil {
+		s.onRollback(discarded)
+	}
+
+	return nil
+}
+
+// rollbackAtomicOp cancels the current atomic operation and rollbacks the changes, returns the entries discarded to
+// notify
+func (s *StreamServer) rollbackAtomicOp() ([]FileEntry, error) {
 	log.Debugf("rollback datastream atomic operation, startEntry: %d", s.atomicOp.startEntry)
 	if s.atomicOp.status != aoStarted {
 		log.Errorf("Rollback not allowed, AtomicOp is not in the started state")
-		return ErrRollbackNotAllowed
+		return nil, ErrRollbackNotAllowed
 	}
 
 	s.atomicOp.status = aoRollbacking
 
-	// Restore header in memory (discard current) from the file header (rollback entries)
+	// Capture the entries to discard
+	var discarded []FileEntry
+	if s.onRollback != nil {
+		discarded = make([]FileEntry, len(s.atomicOp.entries))
+		copy(discarded, s.atomicOp.entries)
+	}
+
+	// Bookmarks of the entries to discard, and bookmarks deleted to restore
+	startEntry := s.atomicOp.startEntry
+	deleted := s.atomicOp.deleted
+	var bookmarks [][]byte
+	for _, e := range s.atomicOp.entries {
+		if e.Type == EtBookmark {
+			bookmarks = append(bookmarks, e.Data)
+		}
+	}
+
+	// Restore header in memory (discard current) from the file header (rollback entries and updates)
+	updated := len(s.streamFile.undo) > 0
 	err := s.streamFile.rollbackHeader()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if updated && s.prefetch != nil {
+		s.prefetch.invalidate()
+	}
+	if updated && s.catchUpCache != nil {
+		s.catchUpCache.invalidate()
 	}
 
 	// Rollback the entry number
-	s.nextEntry = s.atomicOp.startEntry
+	s.nextEntry = s.streamFile.header.TotalEntries
 
 	// No atomic operation in progress
+	s.endAtomicOpSpan(true)
 	s.clearAtomicOp()
 
+	// Restore the bookmarks deleted, then handle the bookmarks of the discarded entries
+	err = s.restoreDeletedBookmarks(deleted)
+	if err != nil {
+		return nil, err
+	}
+	if len(bookmarks) > 0 {
+		err = s.pruneBookmarks(startEntry, bookmarks)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return discarded, nil
+}
+
+// EnableCommitJournal opens (or creates) the commit journal DB next to the stream file, recording the commit time
+// and metadata of the next atomic operations. It's disabled by default.
+func (s *StreamServer) EnableCommitJournal() error {
+	if s.journal != nil {
+		return nil
+	}
+
+	journal, err := NewJournal(journalDBName(s.fileName))
+	if err != nil {
+		return err
+	}
+	s.journal = journal
+
 	return nil
 }
 
+// SetOnRollback sets the callback function invoked after a rollback with the entries discarded
+func (s *StreamServer) SetOnRollback(f func(discardedEntries []FileEntry)) {
+	s.onRollback = f
+}
+
+// SetOnBookmark sets the callback function invoked for each committed bookmark (not for the rolled back ones), in
+// commit order on a dedicated goroutine. A callback slower than the commits delays them once the buffer is full.
+func (s *StreamServer) SetOnBookmark(f func(key []byte, entryNum uint64)) {
+	s.onBookmark = f
+}
+
+// SetDuplicateStartMode sets the behavior on a CmdStart from a client already streaming (rejected by default)
+func (s *StreamServer) SetDuplicateStartMode(mode DuplicateStartMode) {
+	s.duplicateStart = mode
+}
+
+// SetMaxInFlightBytes sets the max bytes buffered to be sent to each new client and the policy when a slow client
+// reaches it, bounding the server memory. With 0 (default) the entries are written directly to the clients. Set
+// before Start.
+func (s *StreamServer) SetMaxInFlightBytes(maxBytes uint64, policy SlowClientPolicy) {
+	s.maxInFlightBytes = maxBytes
+	s.slowClient = policy
+}
+
+// SetClientQueueSize sets the max entries queued to be sent to each new client (0: no limit, default). The entries
+// are written to a client with a queue by its own goroutine, so a slow client doesn't stall the broadcast to the
+// others: a client with the queue full when an entry is committed is disconnected. It can be combined with
+// SetMaxInFlightBytes.
+func (s *StreamServer) SetClientQueueSize(n int) {
+	s.clientQueueSize = max(n, 0)
+}
+
+// SetClientWriteTimeout sets the deadline of each write to a client (the writeTimeout of NewServer), a client not
+// receiving a write within it is disconnected. Set it before Start.
+func (s *StreamServer) SetClientWriteTimeout(d time.Duration) {
+	s.writeTimeout = d
+}
+
 // TruncateFile truncates stream data file from an entry number onwards
 func (s *StreamServer) TruncateFile(entryNum uint64) error {
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
 	// Check the entry number
 	if entryNum >= s.nextEntry {
 		log.Errorf("Invalid entry number [%d], it doesn't exist", entryNum)
@@ -553,10 +1310,30 @@ func (s *StreamServer) TruncateFile(entryNum uint64) error {
 	if err != nil {
 		return err
 	}
+	if s.prefetch != nil {
+		s.prefetch.invalidate()
+	}
+	if s.catchUpCache != nil {
+		s.catchUpCache.invalidate()
+	}
 
 	// Update entry number sequence
 	s.nextEntry = s.streamFile.header.TotalEntries
 
+	// Remove the commits of the truncated entries from the journal
+	if s.journal != nil {
+		err = s.journal.TruncateFrom(entryNum)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Handle the bookmarks of the truncated entries
+	err = s.pruneBookmarks(entryNum, nil)
+	if err != nil {
+		return err
+	}
+
 	// Log current header
 	log.Infof("File truncated! Removed entries from %d (included) until end of file", entryNum)
 	PrintHeaderEntry(s.streamFile.header, "(after truncate)")
@@ -564,8 +1341,12 @@ func (s *StreamServer) TruncateFile(entryNum uint64) error {
 	return nil
 }
 
-// UpdateEntryData updates the internal data of an entry
+// UpdateEntryData updates the internal data of an entry, within an atomic operation the update is undone by its
+// rollback
 func (s *StreamServer) UpdateEntryData(entryNum uint64, etype EntryType, data []byte) error {
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
 	// Check the entry number
 	if entryNum >= s.nextEntry {
 		log.Errorf("Invalid entry number [%d], it doesn't exist", entryNum)
@@ -578,15 +1359,63 @@ func (s *StreamServer) UpdateEntryData(entryNum uint64, etype EntryType, data []
 		return ErrUpdateNotAllowed
 	}
 
+	// Transform the data to store
+	var err error
+	if s.writeTransform != nil && etype != EtBookmark {
+		data, err = s.writeTransform(etype, data)
+		if err != nil {
+			log.Errorf("Error transforming entry %d data: %v", entryNum, err)
+			return err
+		}
+	}
+
 	// Update entry data in the stream file
-	err := s.streamFile.updateEntryData(entryNum, etype, data)
+	err = s.streamFile.updateEntryData(entryNum, etype, data)
 	if err != nil {
 		return err
 	}
+	if s.prefetch != nil {
+		s.prefetch.invalidate()
+	}
+	if s.catchUpCache != nil {
+		s.catchUpCache.invalidate()
+	}
 
 	return nil
 }
 
+// Tombstone flags an entry as logically deleted. GetEntry fails with ErrEntryTombstoned and the iterators skip it,
+// its entry number is not reused. Within an atomic operation the tombstone is undone by its rollback as the entry
+// updates, or on open after a crash before its commit.
+func (s *StreamServer) Tombstone(entryNum uint64) error {
+	s.mutexAtomicOp.Lock()
+	defer s.mutexAtomicOp.Unlock()
+
+	// Check the entry number
+	if entryNum >= s.nextEntry {
+		log.Errorf("Invalid entry number [%d], it doesn't exist", entryNum)
+		return ErrInvalidEntryNumber
+	}
+
+	// Check entry not in current atomic operation
+	if s.atomicOp.status != aoNone && entryNum >= s.atomicOp.startEntry {
+		log.Errorf("Entry number [%d] not allowed for tombstone, it's in the current atomic operation", entryNum)
+		return ErrUpdateNotAllowed
+	}
+
+	err := s.streamFile.tombstoneEntry(entryNum)
+	if err != nil {
+		return err
+	}
+	if s.prefetch != nil {
+		s.prefetch.invalidate()
+	}
+	if s.catchUpCache != nil {
+		s.catchUpCache.tombstone(entryNum)
+	}
+	return nil
+}
+
 // GetHeader returns the current committed header
 func (s *StreamServer) GetHeader() HeaderEntry {
 	// Get current file header
@@ -594,29 +1423,250 @@ func (s *StreamServer) GetHeader() HeaderEntry {
 	return header
 }
 
+// GetIterator returns an iterator over the committed entries starting from an entry number
+func (s *StreamServer) GetIterator(fromEntry uint64, opts IteratorOptions) (*Iterator, error) {
+	it, err := s.streamFile.GetIterator(fromEntry, opts)
+	if err != nil {
+		return nil, err
+	}
+	it.readTransform = s.readTransform
+	it.resolveBookmark = s.getBookmark
+	return it, nil
+}
+
+// GetRangeIterator returns an iterator over the committed entries from an entry number until another one (including)
+func (s *StreamServer) GetRangeIterator(from, to uint64) (*Iterator, error) {
+	it, err := s.streamFile.GetRangeIterator(from, to)
+	if err != nil {
+		return nil, err
+	}
+	it.readTransform = s.readTransform
+	it.resolveBookmark = s.getBookmark
+	return it, nil
+}
+
+// GetReverseIterator returns an iterator over the committed entries in descending order from an entry number
+func (s *StreamServer) GetReverseIterator(fromEntry uint64) (*ReverseIterator, error) {
+	it, err := s.streamFile.GetReverseIterator(fromEntry)
+	if err != nil {
+		return nil, err
+	}
+	it.readTransform = s.readTransform
+	return it, nil
+}
+
+// Entries returns a range-over-func iterator over the committed entries from an entry number until another one
+func (s *StreamServer) Entries(from, to uint64) iter.Seq2[FileEntry, error] {
+	return s.streamFile.entries(from, to, s.readTransform)
+}
+
+// RangeDataSize returns the total size of the data of the entries from an entry number until another one
+func (s *StreamServer) RangeDataSize(from, to uint64) (uint64, error) {
+	return s.streamFile.RangeDataSize(from, to)
+}
+
+// DescribeOffset returns what a byte offset of the stream file belongs to (header, entry, padding or unused space)
+func (s *StreamServer) DescribeOffset(offset uint64) (OffsetDescription, error) {
+	return s.streamFile.DescribeOffset(offset)
+}
+
+// GetMetadataCodec returns the metadata codec recorded in the stream file header (nil: not registered)
+func (s *StreamServer) GetMetadataCodec() MetadataCodec {
+	return s.streamFile.MetadataCodec()
+}
+
 // GetEntry searches in the stream file and returns the data for the requested entry
 func (s *StreamServer) GetEntry(entryNum uint64) (FileEntry, error) {
-	// Initialize file stream iterator
-	iterator, err := s.streamFile.iteratorFrom(entryNum, true)
+	// Prefetched entry
+	entry, cached := FileEntry{}, false
+	if s.prefetch != nil {
+		entry, cached = s.prefetch.get(s.streamFile, entryNum)
+	}
+
+	if !cached {
+		// Initialize file stream iterator
+		iterator, err := s.streamFile.iteratorFrom(entryNum, true)
+		if err != nil {
+			return FileEntry{}, err
+		}
+
+		// Get requested entry data
+		_, err = s.streamFile.iteratorNext(iterator)
+		if err != nil {
+			return FileEntry{}, err
+		}
+
+		// Close iterator
+		s.streamFile.iteratorEnd(iterator)
+		entry = iterator.Entry
+	}
+
+	if entry.Tombstoned {
+		return FileEntry{}, ErrEntryTombstoned
+	}
+
+	err := transformEntry(&entry, s.readTransform)
 	if err != nil {
+		log.Errorf("Error transforming entry %d data: %v", entryNum, err)
 		return FileEntry{}, err
 	}
 
-	// Get requested entry data
-	_, err = s.streamFile.iteratorNext(iterator)
+	return entry, nil
+}
+
+// GetEntryView returns an entry like GetEntry but, with the stream file option MmapReads, its data is a view of the
+// file mapped in memory instead of a copy (see StreamFile.GetEntryView): the caller must not modify it nor retain it
+// past the next call. With a read transform the entry is copied as in GetEntry.
+func (s *StreamServer) GetEntryView(entryNum uint64) (FileEntry, error) {
+	if s.readTransform != nil {
+		return s.GetEntry(entryNum)
+	}
+
+	entry, err := s.streamFile.GetEntryView(entryNum)
 	if err != nil {
 		return FileEntry{}, err
 	}
+	if entry.Tombstoned {
+		return FileEntry{}, ErrEntryTombstoned
+	}
+	return entry, nil
+}
 
-	// Close iterator
-	s.streamFile.iteratorEnd(iterator)
+// GetEntryTimestamp returns the commit time of an entry from the commit journal
+func (s *StreamServer) GetEntryTimestamp(entryNum uint64) (time.Time, error) {
+	if s.journal == nil {
+		return time.Time{}, ErrCommitJournalDisabled
+	}
+	if entryNum >= s.streamFile.getHeaderEntry().TotalEntries {
+		return time.Time{}, ErrInvalidEntryNumber
+	}
+	r, err := s.journal.GetCommit(entryNum)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return r.Timestamp, nil
+}
+
+// GetCommitMeta returns the application metadata recorded with the commit of an entry in the commit journal
+// (nil: committed without metadata)
+func (s *StreamServer) GetCommitMeta(entryNum uint64) (Metadata, error) {
+	if s.journal == nil {
+		return nil, ErrCommitJournalDisabled
+	}
+	if entryNum >= s.streamFile.getHeaderEntry().TotalEntries {
+		return nil, ErrInvalidEntryNumber
+	}
+	r, err := s.journal.GetCommit(entryNum)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Meta) == 0 {
+		return nil, nil
+	}
+
+	// Decode the metadata
+	codec := s.streamFile.MetadataCodec()
+	if codec == nil {
+		return nil, ErrUnknownMetadataCodec
+	}
+	meta, err := codec.Decode(r.Meta)
+	if err != nil {
+		log.Errorf("Error decoding commit metadata of entry %d: %v", entryNum, err)
+		return nil, ErrDecodingMetadata
+	}
+	return meta, nil
+}
+
+// GetEntriesSince returns the first entry committed within the duration until now, or the total entries if there
+// is none. Entries committed before the commit journal existed have no timestamp and are never returned.
+// It's a binary search assuming commit times don't go backwards.
+func (s *StreamServer) GetEntriesSince(d time.Duration) (uint64, error) {
+	if s.journal == nil {
+		return 0, ErrCommitJournalDisabled
+	}
+
+	since := time.Now().Add(-d)
+	totalEntries := s.streamFile.getHeaderEntry().TotalEntries
+
+	first, err := s.journal.GetFirstCommit()
+	if errors.Is(err, ErrCommitNotFound) {
+		return totalEntries, nil
+	} else if err != nil {
+		return 0, err
+	}
 
-	return iterator.Entry, nil
+	// Binary search of the first entry with timestamp within the duration
+	beg, end := first.FirstEntry, totalEntries
+	for beg < end {
+		avg := beg + (end-beg)/2 //nolint:mnd
+		r, err := s.journal.GetCommit(avg)
+		if err != nil {
+			return 0, err
+		}
+		if r.Timestamp.Before(since) {
+			beg = avg + 1
+		} else {
+			end = avg
+		}
+	}
+
+	return beg, nil
+}
+
+// EstimateCatchUp returns the committed entries after the last entry received by a client and the estimated time to
+// receive them, using the rate of the entries sent to the clients catching up smoothed over the last minute.
+// The time is 0 if there is no rate measured yet.
+func (s *StreamServer) EstimateCatchUp(clientLastEntry uint64) (uint64, time.Duration) {
+	totalEntries := s.streamFile.getHeaderEntry().TotalEntries
+	if clientLastEntry+1 >= totalEntries {
+		return 0, 0
+	}
+	remaining := totalEntries - clientLastEntry - 1
+
+	rate := s.catchUpRate.rate(time.Now())
+	if rate == 0 {
+		return remaining, 0
+	}
+	return remaining, time.Duration(float64(remaining) / rate * float64(time.Second))
 }
 
 // GetBookmark returns the entry number pointed by the bookmark
 func (s *StreamServer) GetBookmark(bookmark []byte) (uint64, error) {
-	return s.bookmark.GetBookmark(bookmark)
+	return s.getBookmark(bookmark)
+}
+
+// ListBookmarks returns a page of the bookmarks in key order, see StreamBookmark.ListBookmarks
+func (s *StreamServer) ListBookmarks(cursor []byte, limit int) ([]BookmarkResult, []byte, error) {
+	return s.bookmark.ListBookmarks(cursor, limit)
+}
+
+// GetBookmarkByEntry returns the bookmark pointing to an entry number (the first one in key order if there are
+// several), found is false if there is none. The bookmarks of the entries removed are not returned.
+func (s *StreamServer) GetBookmarkByEntry(entryNum uint64) ([]byte, bool, error) {
+	bookmarks, err := s.bookmark.bookmarksByEntry(entryNum)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, bookmark := range bookmarks {
+		err = s.checkBookmarkTarget(bookmark, entryNum)
+		if errors.Is(err, ErrBookmarkTargetPruned) {
+			continue
+		} else if err != nil {
+			return nil, false, err
+		}
+		return bookmark, true, nil
+	}
+	return nil, false, nil
+}
+
+// GetBookmarksByPrefix returns the bookmarks with a key prefix in key order, see StreamBookmark.GetBookmarksByPrefix
+func (s *StreamServer) GetBookmarksByPrefix(prefix []byte) ([]BookmarkResult, error) {
+	return s.bookmark.GetBookmarksByPrefix(prefix)
+}
+
+// IterateBookmarks calls f with each bookmark in key order until it returns false, see StreamBookmark.IterateBookmarks
+func (s *StreamServer) IterateBookmarks(f func(key []byte, entryNum uint64) bool) error {
+	return s.bookmark.IterateBookmarks(f)
 }
 
 // GetFirstEventAfterBookmark searches in the stream file by bookmark and returns the first event entry data
@@ -625,7 +1675,7 @@ func (s *StreamServer) GetFirstEventAfterBookmark(bookmark []byte) (FileEntry, e
 	entry := FileEntry{}
 
 	// Get entry of the bookmark
-	entryNum, err := s.bookmark.GetBookmark(bookmark)
+	entryNum, err := s.getBookmark(bookmark)
 	if err != nil {
 		return entry, err
 	}
@@ -642,7 +1692,7 @@ func (s *StreamServer) GetFirstEventAfterBookmark(bookmark []byte) (FileEntry, e
 		end, err := s.streamFile.iteratorNext(iterator)
 
 		// Loop break conditions (error, end of file, entry type different from bookmark)
-		if err != nil || end || iterator.Entry.Type != EtBookmark {
+		if err != nil || end || (iterator.Entry.Type != EtBookmark && !iterator.Entry.Tombstoned) {
 			break
 		}
 	}
@@ -650,7 +1700,13 @@ func (s *StreamServer) GetFirstEventAfterBookmark(bookmark []byte) (FileEntry, e
 	// Close iterator
 	s.streamFile.iteratorEnd(iterator)
 
-	return iterator.Entry, err
+	entry = iterator.Entry
+	if err2 := transformEntry(&entry, s.readTransform); err2 != nil {
+		log.Errorf("Error transforming entry %d data: %v", entry.Number, err2)
+		return FileEntry{}, err2
+	}
+
+	return entry, err
 }
 
 // GetDataBetweenBookmarks returns the data between two bookmarks
@@ -659,13 +1715,13 @@ func (s *StreamServer) GetDataBetweenBookmarks(bookmarkFrom, bookmarkTo []byte)
 	var response []byte
 
 	// Get entry of the from bookmark
-	fromEntryNum, err := s.bookmark.GetBookmark(bookmarkFrom)
+	fromEntryNum, err := s.getBookmark(bookmarkFrom)
 	if err != nil {
 		return response, err
 	}
 
 	// Get entry of the to bookmark
-	toEntryNum, err := s.bookmark.GetBookmark(bookmarkTo)
+	toEntryNum, err := s.getBookmark(bookmarkTo)
 	if err != nil {
 		return response, err
 	}
@@ -691,8 +1747,14 @@ func (s *StreamServer) GetDataBetweenBookmarks(bookmarkFrom, bookmarkTo []byte)
 			break
 		}
 
-		if iterator.Entry.Type != EtBookmark {
-			response = append(response, iterator.Entry.Data...)
+		if iterator.Entry.Type != EtBookmark && !iterator.Entry.Tombstoned {
+			entry := iterator.Entry
+			if err := transformEntry(&entry, s.readTransform); err != nil {
+				log.Errorf("Error transforming entry %d data: %v", entry.Number, err)
+				s.streamFile.iteratorEnd(iterator)
+				return nil, err
+			}
+			response = append(response, entry.Data...)
 		}
 	}
 
@@ -702,75 +1764,206 @@ func (s *StreamServer) GetDataBetweenBookmarks(bookmarkFrom, bookmarkTo []byte)
 	return response, err
 }
 
+// GetEntriesByBookmarkRange returns the entries (bookmarks included) from the bookmark of fromKey until the next
+// bookmark after toKey (or the tail), e.g. the entries of a range of L2 blocks. The keys are compared as bytes and
+// clamped to the nearest bookmarks in the range: fromKey to the first bookmark after it, toKey to the last one before.
+func (s *StreamServer) GetEntriesByBookmarkRange(fromKey, toKey []byte) ([]FileEntry, error) {
+	if bytes.Compare(fromKey, toKey) > 0 {
+		return nil, ErrInvalidBookmarkRange
+	}
+
+	// First bookmark of the range, there must be one within the keys
+	firstKey, fromEntryNum, found, err := s.bookmark.seekBookmark(fromKey, false)
+	if err != nil {
+		return nil, err
+	}
+	if !found || bytes.Compare(firstKey, toKey) > 0 {
+		return nil, ErrBookmarkNotFound
+	}
+	err = s.checkBookmarkTarget(firstKey, fromEntryNum)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bookmark after the range
+	afterKey, toEntryNum, found, err := s.bookmark.seekBookmark(toKey, true)
+	if err != nil {
+		return nil, err
+	}
+	if found && s.checkBookmarkTarget(afterKey, toEntryNum) != nil {
+		found = false
+	}
+	if !found {
+		toEntryNum = s.GetHeader().TotalEntries
+	}
+	if fromEntryNum > toEntryNum {
+		return nil, ErrInvalidBookmarkRange
+	}
+
+	var entries []FileEntry
+	for entry, err := range s.Entries(fromEntryNum, toEntryNum) {
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
 // clearAtomicOp sets the current atomic operation to none
 func (s *StreamServer) clearAtomicOp() {
 	// No atomic operation in progress and empty entries slice
+	s.endAtomicOpSpan(false)
 	s.atomicOp.entries = s.atomicOp.entries[:0]
+	s.atomicOp.deleted = nil
+	s.atomicOp.savepoints = nil
 	s.atomicOp.status = aoNone
+	s.stopAtomicOpTimer()
+	if s.groupSync != nil {
+		s.groupSync.release()
+	}
+}
+
+// notifyBookmarks invokes the bookmark callback for the committed bookmarks
+func (s *StreamServer) notifyBookmarks() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case entry := <-s.bookmarkEvents:
+			s.onBookmark(entry.Data, entry.Number)
+		case <-s.done:
+			return
+		}
+	}
 }
 
 // broadcastAtomicOp broadcasts committed atomic operations to the clients
 func (s *StreamServer) broadcastAtomicOp() {
-	defer s.streamFile.file.Close()
-	defer s.bookmark.db.Close()
+	defer s.wg.Done()
+
+	for {
+		// Wait for new atomic operation to broadcast
+		var broadcastOp streamAO
+		select {
+		case broadcastOp = <-s.stream:
+		case <-s.done:
+			return
+		}
+		start := time.Now()
+
+		// Bookmarks to notify
+		if s.onBookmark != nil {
+			for _, entry := range broadcastOp.entries {
+				if entry.Type != EtBookmark {
+					continue
+				}
+				select {
+				case s.bookmarkEvents <- entry:
+				case <-s.done:
+				}
+			}
+		}
 
-	var err error
-	for {
-		// Wait for new atomic operation to broadcast
-		broadcastOp := <-s.stream
-		start := time.Now()
-		var killedClientMap = map[string]struct{}{}
-		var clientMap = map[string]struct{}{}
+		// Copy of the clients to send outside the lock (a slow client can block the broadcast), the clients with a
+		// latency target are served by their own broadcast
 		s.mutexClients.RLock()
-		// For each connected and started client
-		log.Debug("sending datastream entries, count: %d, clients: %d", len(broadcastOp.entries), len(s.clients))
-		for id, cli := range s.clients {
-			log.Debugf("client %s status %d (%s)", id, cli.status, StrClientStatus[cli.status])
-			clientMap[id] = struct{}{}
-			if cli.status != csSynced {
-				continue
+		clients := make([]*client, 0, len(s.clients))
+		for _, cli := range s.clients {
+			if cli.maxLatency.Load() == 0 {
+				clients = append(clients, cli)
 			}
+		}
+		s.mutexClients.RUnlock()
 
-			// Send entries
-			for _, entry := range broadcastOp.entries {
-				if entry.Number >= cli.fromEntry {
-					log.Debugf("sending data entry %d (type %d) to %s", entry.Number, entry.Type, id)
+		s.sendAtomicOp(broadcastOp, clients)
+		s.inBroadcast.Add(-1)
+		log.Debugf("sent datastream entries, count: %d, clients: %d, time: %v", len(broadcastOp.entries), len(clients),
+			time.Since(start))
+	}
+}
+
+// sendAtomicOp sends the entries of a committed atomic operation to the started clients, killing the ones failing
+func (s *StreamServer) sendAtomicOp(broadcastOp streamAO, clients []*client) {
+	if len(clients) == 0 {
+		return
+	}
+
+	// Encode the entries once for all the clients (nil: read transform failed)
+	packets := make([][]byte, len(broadcastOp.entries))
+	for i, entry := range broadcastOp.entries {
+		packets[i], _ = s.encodeStreamEntry(entry)
+	}
+
+	// Span of the broadcast, its context sent before the entries to the clients asking for it
+	span, tracePacket := s.startBroadcastSpan(broadcastOp, len(clients))
+	defer endSpan(span, nil)
+
+	// For each connected and started client
+	log.Debugf("sending datastream entries, count: %d, clients: %d", len(broadcastOp.entries), len(clients))
+	var killedClients []string
+	for _, cli := range clients {
+		id := cli.clientID
+		status := cli.getStatus()
+		log.Debugf("client %s status %d (%s)", id, status, StrClientStatus[status])
+		if status != csSynced {
+			continue
+		}
 
-					binaryEntry := encodeFileEntryToBinary(entry)
+		// Send entries
+		var err error
+		traced := false
+		for i, entry := range broadcastOp.entries {
+			if entry.Number >= cli.fromEntry && cli.accept(entry) {
+				log.Debugf("sending data entry %d (type %d) to %s", entry.Number, entry.Type, id)
+
+				binaryEntry := packets[i]
+				if cli.projection != 0 {
+					binaryEntry, _ = s.encodeClientEntry(cli, entry)
+				}
 
-					// Send the file data entry
-					if cli.conn != nil {
-						_, err = TimeoutWrite(cli, binaryEntry, s.writeTimeout)
-					} else {
-						err = ErrNilConnection
+				// Send the file data entry (after the trace context), applying the slow client policy to the outbox
+				if binaryEntry == nil {
+					err = ErrTransformingEntry
+				} else {
+					if !traced {
+						traced = true
+						err = s.sendTraceContext(cli, tracePacket)
 					}
-					if err != nil {
-						// Kill client connection
-						log.Warnf("error sending entry to %s, error: %v", id, err)
-						killedClientMap[id] = struct{}{}
-						break // skip rest of entries for this client
+					if err == nil {
+						err = s.sendBroadcastPacket(cli, binaryEntry, broadcastOp.committed)
 					}
 				}
+				if err != nil {
+					// Kill client connection
+					log.Warnf("error sending entry to %s, error: %v", id, err)
+					killedClients = append(killedClients, id)
+					break // skip rest of entries for this client
+				}
 			}
 		}
-		s.mutexClients.RUnlock()
+	}
 
-		for k := range killedClientMap {
-			s.killClient(k)
-		}
+	for _, id := range killedClients {
+		s.killClient(id)
+	}
+}
 
-		sClients := ""
-		for c := range clientMap {
-			if sClients == "" {
-				sClients = c
-			} else {
-				sClients += ", " + c
-			}
+// sendBroadcastPacket sends a packet of a broadcast to a client, applying the slow client policy to the outbox
+func (s *StreamServer) sendBroadcastPacket(client *client, packet []byte, committed time.Time) error {
+	if client.outbox != nil {
+		packet, err := encodeWirePacket(client, packet)
+		if err != nil {
+			return err
 		}
+		return client.outbox.push(packet, committed)
+	}
 
-		log.Debugf("sent datastream entries, count: %d, clients: %d, time: %v, clients-ip: {%s}",
-			len(broadcastOp.entries), len(s.clients), time.Since(start), sClients)
+	err := s.sendPacket(client, packet)
+	if err == nil {
+		client.observeWritten(committed)
 	}
+	return err
 }
 
 // killClient disconnects the client and removes it from server clients struct
@@ -779,8 +1972,11 @@ func (s *StreamServer) killClient(clientID string) {
 	defer s.mutexClients.Unlock()
 
 	client := s.clients[clientID]
-	if client != nil && client.status != csKilled {
-		client.status = csKilled
+	if client != nil && client.getStatus() != csKilled {
+		client.setStatus(csKilled)
+		if client.outbox != nil {
+			client.outbox.close()
+		}
 		if client.conn != nil {
 			client.conn.Close()
 		}
@@ -795,9 +1991,14 @@ func (s *StreamServer) processCommand(command Command, client *client) error {
 	// Manage each different kind of command request from a client
 	var err error
 
+	if command.isWireCompression() {
+		return s.processCmdWireCompression(cli, command)
+	}
+
 	switch command {
-	case CmdStart:
-		err = s.handleStartCommand(cli)
+	case CmdStart, CmdStart | CmdOptMaxLatency, CmdStart | CmdOptEntryTypes,
+		CmdStart | CmdOptMaxLatency | CmdOptEntryTypes:
+		err = s.handleStartCommand(cli, command)
 
 	case CmdStartBookmark:
 		err = s.handleStartBookmarkCommand(cli)
@@ -817,6 +2018,33 @@ func (s *StreamServer) processCommand(command Command, client *client) error {
 	case CmdRangeBookmark:
 		err = s.handleRangeBookmarkCommand(cli)
 
+	case CmdDownload:
+		err = s.handleDownloadCommand(cli)
+
+	case CmdStartBookmarkPrefix:
+		err = s.handleStartBookmarkPrefixCommand(cli)
+
+	case CmdCapabilities:
+		err = s.handleCapabilitiesCommand(cli)
+
+	case CmdResync:
+		err = s.handleResyncCommand(cli)
+
+	case CmdPing:
+		err = s.processCmdPing(cli)
+
+	case CmdStartProjection:
+		err = s.handleStartProjectionCommand(cli)
+
+	case CmdHeartbeat:
+		err = s.processCmdHeartbeat(cli)
+
+	case CmdTraceContext:
+		err = s.processCmdTraceContext(cli)
+
+	case CmdEntryChecksums:
+		err = s.processCmdEntryChecksums(cli)
+
 	default:
 		log.Error("Invalid command!")
 		err = ErrInvalidCommand
@@ -827,17 +2055,60 @@ func (s *StreamServer) processCommand(command Command, client *client) error {
 }
 
 // handleStartCommand processes the CmdStart command
-func (s *StreamServer) handleStartCommand(cli *client) error {
-	if cli.status != csStopped {
+func (s *StreamServer) handleStartCommand(cli *client, command Command) error {
+	status := cli.getStatus()
+	if status == csSynced && s.duplicateStart == DuplicateStartRestart {
+		log.Infof("Restarting stream to client %s", cli.clientID)
+	} else if status != csStopped {
+		log.Error("Stream to client already started!")
+		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
+		return ErrAlreadyStreaming
+	}
+
+	cli.setStatus(csSyncing)
+	err := s.processCmdStart(cli, command)
+	if err == nil {
+		cli.setStatus(csSynced)
+	} else if errors.Is(err, ErrInvalidEntryTypeFilter) {
+		// Rejected before changing the streaming
+		cli.setStatus(status)
+	}
+
+	return err
+}
+
+// handleStartProjectionCommand processes the CmdStartProjection command
+func (s *StreamServer) handleStartProjectionCommand(cli *client) error {
+	if cli.getStatus() != csStopped {
+		log.Error("Stream to client already started!")
+		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
+		return ErrClientAlreadyStarted
+	}
+
+	cli.setStatus(csSyncing)
+	err := s.processCmdStartProjection(cli)
+	if err == nil {
+		cli.setStatus(csSynced)
+	}
+
+	return err
+}
+
+// handleResyncCommand processes the CmdResync command
+func (s *StreamServer) handleResyncCommand(cli *client) error {
+	if cli.getStatus() != csStopped {
 		log.Error("Stream to client already started!")
 		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
 		return ErrClientAlreadyStarted
 	}
 
-	cli.status = csSyncing
-	err := s.processCmdStart(cli)
+	cli.setStatus(csSyncing)
+	err := s.processCmdResync(cli)
 	if err == nil {
-		cli.status = csSynced
+		cli.setStatus(csSynced)
+	} else if errors.Is(err, ErrStreamDivergence) {
+		// The client can start again from an earlier point
+		cli.setStatus(csStopped)
 	}
 
 	return err
@@ -845,16 +2116,33 @@ func (s *StreamServer) handleStartCommand(cli *client) error {
 
 // handleStartBookmarkCommand processes the CmdStartBookmark command
 func (s *StreamServer) handleStartBookmarkCommand(cli *client) error {
-	if cli.status != csStopped {
+	if cli.getStatus() != csStopped {
 		log.Error("Stream to client already started!")
 		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
 		return ErrClientAlreadyStarted
 	}
 
-	cli.status = csSyncing
+	cli.setStatus(csSyncing)
 	err := s.processCmdStartBookmark(cli)
 	if err == nil {
-		cli.status = csSynced
+		cli.setStatus(csSynced)
+	}
+
+	return err
+}
+
+// handleStartBookmarkPrefixCommand processes the CmdStartBookmarkPrefix command
+func (s *StreamServer) handleStartBookmarkPrefixCommand(cli *client) error {
+	if cli.getStatus() != csStopped {
+		log.Error("Stream to client already started!")
+		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
+		return ErrClientAlreadyStarted
+	}
+
+	cli.setStatus(csSyncing)
+	err := s.processCmdStartBookmarkPrefix(cli)
+	if err == nil {
+		cli.setStatus(csSynced)
 	}
 
 	return err
@@ -862,16 +2150,33 @@ func (s *StreamServer) handleStartBookmarkCommand(cli *client) error {
 
 // handleRangeBookmarkCommand processes the CmdRangeBookmark command
 func (s *StreamServer) handleRangeBookmarkCommand(cli *client) error {
-	if cli.status != csStopped {
+	if cli.getStatus() != csStopped {
 		log.Error("Stream to client already started!")
 		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
 		return ErrClientAlreadyStarted
 	}
 
-	cli.status = csSyncing
+	cli.setStatus(csSyncing)
 	err := s.processCmdRangeBookmark(cli)
 	if err == nil {
-		cli.status = csStopped
+		cli.setStatus(csStopped)
+	}
+
+	return err
+}
+
+// handleDownloadCommand processes the CmdDownload command
+func (s *StreamServer) handleDownloadCommand(cli *client) error {
+	if cli.getStatus() != csStopped {
+		log.Error("Stream to client already started!")
+		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
+		return ErrClientAlreadyStarted
+	}
+
+	cli.setStatus(csSyncing)
+	err := s.processCmdDownload(cli)
+	if err == nil {
+		cli.setStatus(csStopped)
 	}
 
 	return err
@@ -879,19 +2184,19 @@ func (s *StreamServer) handleRangeBookmarkCommand(cli *client) error {
 
 // handleStopCommand processes the CmdStop command
 func (s *StreamServer) handleStopCommand(cli *client) error {
-	if cli.status != csSynced {
+	if cli.getStatus() != csSynced {
 		log.Error("Stream to client already stopped!")
 		_ = s.sendResultEntry(uint32(CmdErrAlreadyStopped), StrCommandErrors[CmdErrAlreadyStopped], cli)
 		return ErrClientAlreadyStopped
 	}
 
-	cli.status = csStopped
+	cli.setStatus(csStopped)
 	return s.processCmdStop(cli)
 }
 
 // handleHeaderCommand processes the CmdHeader command
 func (s *StreamServer) handleHeaderCommand(cli *client) error {
-	if cli.status != csStopped {
+	if cli.getStatus() != csStopped {
 		log.Error("Header command not allowed, stream started!")
 		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
 		return ErrHeaderCommandNotAllowed
@@ -900,9 +2205,20 @@ func (s *StreamServer) handleHeaderCommand(cli *client) error {
 	return s.processCmdHeader(cli)
 }
 
+// handleCapabilitiesCommand processes the CmdCapabilities command
+func (s *StreamServer) handleCapabilitiesCommand(cli *client) error {
+	if cli.getStatus() != csStopped {
+		log.Error("Capabilities command not allowed, stream started!")
+		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
+		return ErrCapabilitiesCommandNotAllowed
+	}
+
+	return s.processCmdCapabilities(cli)
+}
+
 // handleEntryCommand processes the CmdEntry command
 func (s *StreamServer) handleEntryCommand(cli *client) error {
-	if cli.status != csStopped {
+	if cli.getStatus() != csStopped {
 		log.Error("Entry command not allowed, stream started!")
 		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
 		return ErrEntryCommandNotAllowed
@@ -913,7 +2229,7 @@ func (s *StreamServer) handleEntryCommand(cli *client) error {
 
 // handleBookmarkCommand processes the CmdBookmark command
 func (s *StreamServer) handleBookmarkCommand(cli *client) error {
-	if cli.status != csStopped {
+	if cli.getStatus() != csStopped {
 		log.Error("Bookmark command not allowed, stream started!")
 		_ = s.sendResultEntry(uint32(CmdErrAlreadyStarted), StrCommandErrors[CmdErrAlreadyStarted], cli)
 		return ErrBookmarkCommandNotAllowed
@@ -923,33 +2239,88 @@ func (s *StreamServer) handleBookmarkCommand(cli *client) error {
 }
 
 // processCmdStart processes the TCP Start command from the clients
-func (s *StreamServer) processCmdStart(client *client) error {
+func (s *StreamServer) processCmdStart(client *client, command Command) error {
 	// Read from entry number parameter
 	fromEntry, err := readFullUint64(client)
 	if err != nil {
 		return err
 	}
-	client.fromEntry = fromEntry
+
+	// Read the max latency parameter (nanoseconds)
+	var maxLatency uint64
+	if command&CmdOptMaxLatency != 0 {
+		maxLatency, err = readFullUint64(client)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Log
+	log.Debugf("Client %s command Start from %d max latency %v", client.clientID, fromEntry,
+		time.Duration(maxLatency)) //nolint:gosec
+
+	// Read the entry types streamed
+	var entryTypes map[EntryType]bool
+	if command&CmdOptEntryTypes != 0 {
+		entryTypes, err = s.readEntryTypes(client)
+		if err != nil {
+			return err
+		}
+	}
+
+	client.filter = nil
+	client.entryTypes = entryTypes
+	client.projection = 0
+	client.setMaxLatency(time.Duration(min(maxLatency, math.MaxInt64))) //nolint:gosec
+	return s.startFromEntry(client, fromEntry)
+}
+
+// processCmdStartBookmarkPrefix processes the TCP Start Bookmark Prefix command from the clients
+func (s *StreamServer) processCmdStartBookmarkPrefix(client *client) error {
+	// Read from entry number and bookmark prefix parameters
+	fromEntry, err := readFullUint64(client)
+	if err != nil {
+		return err
+	}
+	prefix, err := readBookmark(client)
+	if err != nil {
+		return err
+	}
 
 	// Log
-	log.Debugf("Client %s command Start from %d", client.clientID, fromEntry)
+	log.Debugf("Client %s command StartBookmarkPrefix from %d prefix [%v]", client.clientID, fromEntry, prefix)
+
+	client.filter = &bookmarkFilter{prefix: prefix}
+	client.entryTypes = nil
+	client.projection = 0
+	return s.startFromEntry(client, fromEntry)
+}
+
+// startFromEntry replies to a start command and streams the entries from the requested entry number
+func (s *StreamServer) startFromEntry(client *client, fromEntry uint64) error {
+	// Entries before the first one stored in the file are not available
+	header := s.streamFile.getHeaderEntry()
+	if fromEntry < header.firstEntry {
+		fromEntry = header.firstEntry
+	}
+	client.fromEntry = fromEntry
 
 	// Check received param
-	if fromEntry > s.nextEntry && fromEntry > s.initEntry {
+	if fromEntry > header.TotalEntries && fromEntry > s.initEntry {
 		log.Errorf("Start command invalid from entry %d for client %s", fromEntry, client.clientID)
-		err = ErrStartCommandInvalidParamFromEntry
+		err := ErrStartCommandInvalidParamFromEntry
 		_ = s.sendResultEntry(uint32(CmdErrBadFromEntry), StrCommandErrors[CmdErrBadFromEntry], client)
 		return err
 	}
 
 	// Send a command result entry OK
-	err = s.sendResultEntry(0, "OK", client)
+	err := s.sendResultEntry(0, "OK", client)
 	if err != nil {
 		return err
 	}
 
 	// Stream entries data from the requested entry number
-	if fromEntry < s.nextEntry {
+	if fromEntry < header.TotalEntries {
 		err = s.streamingFromEntry(client, fromEntry)
 	}
 
@@ -979,9 +2350,12 @@ func (s *StreamServer) processCmdStartBookmark(client *client) error {
 
 	// Log
 	log.Debugf("Client %s command StartBookmark [%v]", client.clientID, bookmark)
+	client.filter = nil
+	client.entryTypes = nil
+	client.projection = 0
 
 	// Get bookmark
-	entryNum, err := s.bookmark.GetBookmark(bookmark)
+	entryNum, err := s.getBookmark(bookmark)
 	if err != nil {
 		log.Errorf("StartBookmark command invalid from bookmark %v for client %s: %v", bookmark, client.clientID, err)
 		err = ErrStartBookmarkInvalidParamFromBookmark
@@ -997,7 +2371,7 @@ func (s *StreamServer) processCmdStartBookmark(client *client) error {
 
 	// Stream entries data from the entry number marked by the bookmark
 	log.Debugf("Client %s Bookmark [%v] is the entry number [%d]", client.clientID, bookmark, entryNum)
-	if entryNum < s.nextEntry {
+	if entryNum < s.streamFile.getHeaderEntry().TotalEntries {
 		err = s.streamingFromEntry(client, entryNum)
 	}
 
@@ -1016,14 +2390,14 @@ func (s *StreamServer) processCmdRangeBookmark(client *client) error {
 	}
 	log.Debugf("Client %s command RangeBookmark start: [%v], end [%v]", client.clientID, sb, eb)
 
-	from, err := s.bookmark.GetBookmark(sb)
+	from, err := s.getBookmark(sb)
 	if err != nil {
 		log.Errorf("RangeBookmark command invalid start bookmark %v for client %s: %v", sb, client.clientID, err)
 		err = ErrStartBookmarkInvalidParamFromBookmark
 		_ = s.sendResultEntry(uint32(CmdErrBadFromBookmark), StrCommandErrors[CmdErrBadFromBookmark], client)
 		return err
 	}
-	to, err := s.bookmark.GetBookmark(eb)
+	to, err := s.getBookmark(eb)
 	if err != nil || to == 0 {
 		log.Errorf("RangeBookmark command invalid end bookmark %v for client %s: %v", eb, client.clientID, err)
 		err = ErrEndBookmarkInvalidParamToBookmark
@@ -1040,19 +2414,71 @@ func (s *StreamServer) processCmdRangeBookmark(client *client) error {
 	// Send toEntry
 	be := make([]byte, 8)
 	binary.BigEndian.PutUint64(be, to)
-	TimeoutWrite(client, be, s.writeTimeout)
+	err = s.sendPacket(client, be)
+	if err != nil {
+		return err
+	}
 
-	if from >= s.nextEntry || to >= s.nextEntry {
+	if totalEntries := s.streamFile.getHeaderEntry().TotalEntries; from >= totalEntries || to >= totalEntries {
 		return ErrInvalidBookmarkRange
 	}
 
 	return s.streamingRangeEntry(client, from, to)
 }
 
+// processCmdDownload processes the TCP Download command from the clients
+func (s *StreamServer) processCmdDownload(client *client) error {
+	// Read from entry number, offset hint and checkpoint interval parameters
+	fromEntry, err := readFullUint64(client)
+	if err != nil {
+		return err
+	}
+	offset, err := readFullUint64(client)
+	if err != nil {
+		return err
+	}
+	interval, err := readFullUint64(client)
+	if err != nil {
+		return err
+	}
+	if interval == 0 {
+		interval = defaultCheckpointInterval
+	}
+
+	// Download until the committed tail
+	header := s.streamFile.getHeaderEntry()
+	if fromEntry < header.firstEntry {
+		fromEntry = header.firstEntry
+		offset = 0
+	}
+	toEntry := header.TotalEntries
+
+	// Log
+	log.Debugf("Client %s command Download from %d (offset %d) to %d, checkpoint every %d entries",
+		client.clientID, fromEntry, offset, toEntry, interval)
+
+	// Check received param
+	if fromEntry > toEntry {
+		log.Errorf("Download command invalid from entry %d for client %s", fromEntry, client.clientID)
+		err = ErrStartCommandInvalidParamFromEntry
+		_ = s.sendResultEntry(uint32(CmdErrBadFromEntry), StrCommandErrors[CmdErrBadFromEntry], client)
+		return err
+	}
+
+	// Send a command result entry OK
+	err = s.sendResultEntry(0, "OK", client)
+	if err != nil {
+		return err
+	}
+
+	return s.streamingDownload(client, DownloadCheckpoint{Entry: fromEntry, Offset: offset, ToEntry: toEntry}, interval)
+}
+
 // processCmdStop processes the TCP Stop command from the clients
 func (s *StreamServer) processCmdStop(client *client) error {
 	// Log
 	log.Debugf("Client %s command Stop", client.clientID)
+	client.setMaxLatency(0)
 
 	// Send a command result entry OK
 	err := s.sendResultEntry(0, "OK", client)
@@ -1075,11 +2501,7 @@ func (s *StreamServer) processCmdHeader(client *client) error {
 	binaryHeader := encodeHeaderEntryToBinary(header)
 
 	// Send header entry to the client
-	if client.conn != nil {
-		_, err = TimeoutWrite(client, binaryHeader, s.writeTimeout)
-	} else {
-		err = ErrNilConnection
-	}
+	err = s.sendPacket(client, binaryHeader)
 	if err != nil {
 		log.Errorf("Error sending header entry to %s: %v", client.clientID, err)
 		return err
@@ -1116,11 +2538,7 @@ func (s *StreamServer) processCmdEntry(client *client) error {
 	binaryEntry := encodeFileEntryToBinary(entry)
 
 	// Send entry to the client
-	if client.conn != nil {
-		_, err = TimeoutWrite(client, binaryEntry, s.writeTimeout)
-	} else {
-		err = ErrNilConnection
-	}
+	err = s.sendPacket(client, binaryEntry)
 	if err != nil {
 		log.Errorf("Error sending entry to %s: %v", client.clientID, err)
 		return err
@@ -1171,11 +2589,7 @@ func (s *StreamServer) processCmdBookmark(client *client) error {
 	binaryEntry := encodeFileEntryToBinary(entry)
 
 	// Send entry to the client
-	if client.conn != nil {
-		_, err = TimeoutWrite(client, binaryEntry, s.writeTimeout)
-	} else {
-		err = ErrNilConnection
-	}
+	err = s.sendPacket(client, binaryEntry)
 	if err != nil {
 		log.Errorf("Error sending entry to %s: %v", client.clientID, err)
 		return err
@@ -1189,6 +2603,24 @@ func (s *StreamServer) streamingFromEntry(client *client, fromEntry uint64) erro
 	// Log
 	log.Debugf("SYNCING %s from entry %d...", client.clientID, fromEntry)
 
+	// Recent entries from the catch-up cache, the ones committed meanwhile from the file
+	if s.catchUpCache != nil {
+		entries, cached := s.catchUpCache.from(fromEntry)
+		for _, entry := range entries {
+			err := s.sendCatchUpEntry(client, entry)
+			if err != nil {
+				return err
+			}
+		}
+		if cached {
+			fromEntry = entries[len(entries)-1].Number + 1
+			if fromEntry >= s.streamFile.getHeaderEntry().TotalEntries {
+				log.Debugf("Synced %s until %d from the catch-up cache!", client.clientID, fromEntry-1)
+				return nil
+			}
+		}
+	}
+
 	// Start file stream iterator
 	iterator, err := s.streamFile.iteratorFrom(fromEntry, true)
 	if err != nil {
@@ -1208,15 +2640,8 @@ func (s *StreamServer) streamingFromEntry(client *client, fromEntry uint64) erro
 		}
 
 		// Send the file data entry
-		binaryEntry := encodeFileEntryToBinary(iterator.Entry)
-		log.Debugf("Sending data entry %d (type %d) to %s", iterator.Entry.Number, iterator.Entry.Type, client.clientID)
-		if client.conn != nil {
-			_, err = TimeoutWrite(client, binaryEntry, s.writeTimeout)
-		} else {
-			err = ErrNilConnection
-		}
+		err = s.sendCatchUpEntry(client, iterator.Entry)
 		if err != nil {
-			log.Errorf("Error sending entry %d to %s: %v", iterator.Entry.Number, client.clientID, err)
 			return err
 		}
 	}
@@ -1228,6 +2653,27 @@ func (s *StreamServer) streamingFromEntry(client *client, fromEntry uint64) erro
 	return nil
 }
 
+// sendCatchUpEntry sends an entry to a client catching up, unless it's tombstoned or filtered out
+func (s *StreamServer) sendCatchUpEntry(client *client, entry FileEntry) error {
+	// Tombstoned entries and the ones filtered out are not streamed
+	if entry.Tombstoned || !client.accept(entry) {
+		return nil
+	}
+
+	binaryEntry, err := s.encodeClientEntry(client, entry)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Sending data entry %d (type %d) to %s", entry.Number, entry.Type, client.clientID)
+	err = s.sendPacket(client, binaryEntry)
+	if err != nil {
+		log.Errorf("Error sending entry %d to %s: %v", entry.Number, client.clientID, err)
+		return err
+	}
+	s.catchUpRate.add(time.Now(), 1)
+	return nil
+}
+
 // streamingRangeEntry streams the range of file entries until toEntry bookmark (excluding)
 func (s *StreamServer) streamingRangeEntry(client *client, fromEntry uint64, toEntry uint64) error {
 	if fromEntry > toEntry {
@@ -1250,17 +2696,18 @@ func (s *StreamServer) streamingRangeEntry(client *client, fromEntry uint64, toE
 			break
 		}
 
-		// Send the file data entry
-		binaryEntry := encodeFileEntryToBinary(iterator.Entry)
-		log.Debugf("Sending data entry %d (type %d) to %s", iterator.Entry.Number, iterator.Entry.Type, client.clientID)
-		if client.conn != nil {
-			_, err = TimeoutWrite(client, binaryEntry, s.writeTimeout)
-		} else {
-			err = ErrNilConnection
-		}
-		if err != nil {
-			log.Errorf("Error sending entry %d to %s: %v", iterator.Entry.Number, client.clientID, err)
-			return err
+		// Send the file data entry, tombstoned entries are not streamed
+		if !iterator.Entry.Tombstoned {
+			binaryEntry, err := s.encodeStreamEntry(iterator.Entry)
+			if err != nil {
+				return err
+			}
+			log.Debugf("Sending data entry %d (type %d) to %s", iterator.Entry.Number, iterator.Entry.Type, client.clientID)
+			err = s.sendPacket(client, binaryEntry)
+			if err != nil {
+				log.Errorf("Error sending entry %d to %s: %v", iterator.Entry.Number, client.clientID, err)
+				return err
+			}
 		}
 
 		if iterator.Entry.Number == toEntry {
@@ -1275,6 +2722,90 @@ func (s *StreamServer) streamingRangeEntry(client *client, fromEntry uint64, toE
 	return nil
 }
 
+// streamingDownload sends to the client the entries of a download with periodic checkpoints, ending with a
+// checkpoint at the end entry
+func (s *StreamServer) streamingDownload(client *client, from DownloadCheckpoint, interval uint64) error {
+	log.Debugf("DOWNLOADING %s from entry %d to entry %d...", client.clientID, from.Entry, from.ToEntry)
+
+	cp := from
+	if !cp.Done() {
+		// Start file stream iterator
+		iterator, err := s.streamFile.iteratorFromOffset(from.Entry, from.Offset)
+		if err != nil {
+			return err
+		}
+		defer s.streamFile.iteratorEnd(iterator)
+
+		for !cp.Done() {
+			end, err := s.streamFile.iteratorNext(iterator)
+			if err != nil {
+				return err
+			}
+			if end {
+				log.Errorf("Download to %s reached the end of entries at %d", client.clientID, cp.Entry)
+				return ErrEntryNotFound
+			}
+
+			// Send the file data entry, tombstoned entries are not streamed
+			if !iterator.Entry.Tombstoned {
+				binaryEntry, err := s.encodeStreamEntry(iterator.Entry)
+				if err != nil {
+					return err
+				}
+				err = s.sendPacket(client, binaryEntry)
+				if err != nil {
+					log.Errorf("Error sending entry %d to %s: %v", iterator.Entry.Number, client.clientID, err)
+					return err
+				}
+				s.catchUpRate.add(time.Now(), 1)
+			}
+
+			// Update the checkpoint
+			cp.Entry = iterator.Entry.Number + 1
+			cp.Offset, err = s.streamFile.iteratorPos(iterator)
+			if err != nil {
+				return err
+			}
+
+			// Send a periodic checkpoint
+			if !cp.Done() && (cp.Entry-from.Entry)%interval == 0 {
+				err = s.sendPacket(client, encodeCheckpointToBinary(cp))
+				if err != nil {
+					log.Errorf("Error sending checkpoint %d to %s: %v", cp.Entry, client.clientID, err)
+					return err
+				}
+			}
+		}
+	}
+
+	// Send the final checkpoint
+	err := s.sendPacket(client, encodeCheckpointToBinary(cp))
+	if err != nil {
+		log.Errorf("Error sending checkpoint %d to %s: %v", cp.Entry, client.clientID, err)
+		return err
+	}
+	log.Debugf("Downloaded %s until %d!", client.clientID, cp.Entry)
+
+	return nil
+}
+
+// sendPacket sends a packet to the client. A client with an outbox gets all its packets through it, so they are
+// written in order by its goroutine, waiting if the max in-flight bytes are reached.
+func (s *StreamServer) sendPacket(client *client, packet []byte) error {
+	packet, err := encodeWirePacket(client, packet)
+	if err != nil {
+		return err
+	}
+	if client.outbox != nil {
+		return client.outbox.pushWait(packet)
+	}
+	if client.conn == nil {
+		return ErrNilConnection
+	}
+	_, err = TimeoutWrite(client, packet, s.writeTimeout)
+	return err
+}
+
 // sendResultEntry sends the response to a TCP command for the clients
 func (s *StreamServer) sendResultEntry(errorNum uint32, errorStr string, client *client) error {
 	// Prepare the result entry
@@ -1293,11 +2824,7 @@ func (s *StreamServer) sendResultEntry(errorNum uint32, errorStr string, client
 
 	// Send the result entry to the client
 	var err error
-	if client.conn != nil {
-		_, err = TimeoutWrite(client, binaryEntry, s.writeTimeout)
-	} else {
-		err = ErrNilConnection
-	}
+	err = s.sendPacket(client, binaryEntry)
 	if err != nil {
 		log.Errorf("Error sending result entry to %s: %v", client.clientID, err)
 		return err
@@ -1311,6 +2838,31 @@ func (s *StreamServer) getSafeClient(clientID string) *client {
 	return s.clients[clientID]
 }
 
+// ListClients returns the information of the connected clients sorted by ID
+func (s *StreamServer) ListClients() []ClientInfo {
+	s.mutexClients.RLock()
+	defer s.mutexClients.RUnlock()
+
+	clients := make([]ClientInfo, 0, len(s.clients))
+	for _, cli := range s.clients {
+		info := ClientInfo{
+			ID:     cli.clientID,
+			Status: cli.getStatus(),
+		}
+		if cli.outbox != nil {
+			info.BatchSize = cli.outbox.getBatchSize()
+		}
+		if cli.queueDelay != nil {
+			info.QueueDelay = cli.queueDelay.snapshot()
+		}
+		info.MaxLatency = time.Duration(cli.maxLatency.Load())
+		info.LatencyMisses = cli.latencyMisses.Load()
+		clients = append(clients, info)
+	}
+	slices.SortFunc(clients, func(a, b ClientInfo) int { return strings.Compare(a.ID, b.ID) })
+	return clients
+}
+
 func (s *StreamServer) getSafeClientsLen() int {
 	s.mutexClients.RLock()
 	defer s.mutexClients.RUnlock()
@@ -1431,6 +2983,30 @@ func DecodeBinaryToResultEntry(b []byte) (ResultEntry, error) {
 	return e, nil
 }
 
+// encodeCheckpointToBinary encodes from a download checkpoint type to binary bytes slice
+func encodeCheckpointToBinary(cp DownloadCheckpoint) []byte {
+	be := make([]byte, 1)
+	be[0] = PtCheckpoint
+	be = binary.BigEndian.AppendUint64(be, cp.Entry)
+	be = binary.BigEndian.AppendUint64(be, cp.Offset)
+	be = binary.BigEndian.AppendUint64(be, cp.ToEntry)
+	return be
+}
+
+// decodeBinaryToCheckpoint decodes from binary bytes slice to a download checkpoint type
+func decodeBinaryToCheckpoint(b []byte) (DownloadCheckpoint, error) {
+	if len(b) != FixedSizeCheckpoint || b[0] != PtCheckpoint {
+		log.Error("Invalid binary checkpoint")
+		return DownloadCheckpoint{}, ErrInvalidBinaryEntry
+	}
+
+	return DownloadCheckpoint{
+		Entry:   binary.BigEndian.Uint64(b[1:9]),
+		Offset:  binary.BigEndian.Uint64(b[9:17]),
+		ToEntry: binary.BigEndian.Uint64(b[17:25]),
+	}, nil
+}
+
 // PrintResultEntry prints result entry type
 func PrintResultEntry(e ResultEntry) {
 	log.Debug("--- RESULT ENTRY -------------------------")
@@ -1442,7 +3018,9 @@ func PrintResultEntry(e ResultEntry) {
 
 // IsACommand checks if a command is a valid command
 func (c Command) IsACommand() bool {
-	return c >= CmdStart && c <= CmdBookmark
+	return (c >= CmdStart && c <= CmdBookmark) || c == CmdDownload || c == CmdStartBookmarkPrefix ||
+		c == CmdCapabilities || c == CmdResync || c == CmdPing || c == CmdStartProjection || c == CmdHeartbeat ||
+		c == CmdTraceContext || c == CmdEntryChecksums || c.isStart() || c.isWireCompression()
 }
 
 // TimeoutWrite sets a deadline time before write
@@ -1466,6 +3044,7 @@ func TimeoutWrite(client *client, data []byte, timeout time.Duration) (int, erro
 // Close gracefully shuts down the StreamServer and releases all resources
 func (s *StreamServer) Close() error {
 	var errs []error
+	s.healthState.Store(healthClosed)
 
 	// 1. Close network listener (stops accepting new connections)
 	if s.ln != nil {
@@ -1475,21 +3054,30 @@ func (s *StreamServer) Close() error {
 		s.ln = nil
 	}
 
-	// 2. Disconnect and cleanup all clients
+	// 2. Stop the server goroutines. The outboxes are closed to release a broadcast waiting for a slow client
 	s.mutexClients.Lock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
 	clientIDs := make([]string, 0, len(s.clients))
-	for id := range s.clients {
+	for id, cli := range s.clients {
 		clientIDs = append(clientIDs, id)
+		if cli.outbox != nil {
+			cli.outbox.close()
+		}
 	}
 	s.mutexClients.Unlock()
+	s.wg.Wait()
 
+	// 3. Disconnect and cleanup all clients, waiting for their goroutines to end
 	for _, id := range clientIDs {
 		s.killClient(id)
 	}
-
-	// 3. Close stream channel (if needed, might want to drain first)
-	if s.stream != nil {
-		close(s.stream)
+	s.wgClients.Wait()
+	if s.prefetch != nil {
+		s.prefetch.wait()
 	}
 
 	// 4. Close StreamFile (flushes header + data to disk)
@@ -1508,8 +3096,21 @@ func (s *StreamServer) Close() error {
 		s.bookmark = nil
 	}
 
+	// 6. Close StreamJournal database
+	if s.journal != nil {
+		if err := s.journal.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close journal: %w", err))
+		}
+		s.journal = nil
+	}
+
 	s.started = false
 
+	// 7. Stop serving the health probes
+	if err := s.closeHealthServer(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close health server: %w", err))
+	}
+
 	// Return combined errors if any
 	if len(errs) > 0 {
 		return fmt.Errorf("errors during close: %v", errs)
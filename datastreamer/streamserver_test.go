@@ -1,18 +1,95 @@
 package datastreamer
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// newTestServer creates and starts a server on a random port using a stream file in dir
+func newTestServer(tb testing.TB, dir string) *StreamServer {
+	tb.Helper()
+
+	return newConfiguredTestServer(tb, dir, nil)
+}
+
+// newConfiguredTestServer creates a server as newTestServer, calling configure (if any) before starting it
+func newConfiguredTestServer(tb testing.TB, dir string, configure func(s *StreamServer)) *StreamServer {
+	tb.Helper()
+
+	s, err := openTestServer(tb, filepath.Join(dir, "stream.bin"), StreamFileOptions{}, configure)
+	require.NoError(tb, err)
+
+	return s
+}
+
+// openTestServer creates a server on a stream file with options, calling configure (if any) before starting it.
+// It returns the error creating the server, e.g. opening the stream file.
+func openTestServer(tb testing.TB, fileName string, opts StreamFileOptions,
+	configure func(s *StreamServer)) (*StreamServer, error) {
+	tb.Helper()
+
+	s, err := NewServerWithFileOptions(0, 1, 12345, 1, fileName, time.Second, time.Minute, time.Minute, nil, opts)
+	if err != nil {
+		if s != nil && s.bookmark != nil {
+			_ = s.bookmark.Close()
+		}
+		return nil, err
+	}
+	if configure != nil {
+		configure(s)
+	}
+	require.NoError(tb, s.Start())
+	tb.Cleanup(func() {
+		if s.streamFile != nil {
+			_ = s.Close()
+		}
+	})
+
+	return s, nil
+}
+
+// commitTestEntries adds entries of a data size in an atomic operation and commits it, returning the commit error.
+// The data of each entry is filled with the low byte of its number.
+func commitTestEntries(tb testing.TB, s *StreamServer, n int, size int) error {
+	tb.Helper()
+
+	require.NoError(tb, s.StartAtomicOp())
+	for range n {
+		num := s.nextEntry
+		_, err := s.AddStreamEntry(1, bytes.Repeat([]byte{byte(num)}, size))
+		require.NoError(tb, err)
+	}
+	return s.CommitAtomicOp()
+}
+
+// commitTestEntry commits an atomic operation with an entry of 100 bytes of data
+func commitTestEntry(t *testing.T, s *StreamServer) {
+	t.Helper()
+
+	require.NoError(t, commitTestEntries(t, s, 1, 100)) //nolint:mnd
+}
+
+// testServerAddr returns the address where a test server is listening
+func testServerAddr(s *StreamServer) string {
+	return s.ln.Addr().String()
+}
+
 func TestProcessCommand(t *testing.T) {
 	server := new(StreamServer)
 	cli := &client{status: csSyncing}
 
 	// Test CmdStart
 	err := server.processCommand(CmdStart, cli)
-	assert.EqualError(t, ErrClientAlreadyStarted, err.Error())
+	assert.EqualError(t, ErrAlreadyStreaming, err.Error())
 
 	// Test CmdStartBookmark
 	err = server.processCommand(CmdStartBookmark, cli)
@@ -34,7 +111,852 @@ func TestProcessCommand(t *testing.T) {
 	err = server.processCommand(CmdBookmark, cli)
 	assert.EqualError(t, ErrBookmarkCommandNotAllowed, err.Error())
 
+	// Test CmdCapabilities
+	err = server.processCommand(CmdCapabilities, cli)
+	assert.EqualError(t, ErrCapabilitiesCommandNotAllowed, err.Error())
+
+	// Test CmdResync
+	err = server.processCommand(CmdResync, cli)
+	assert.EqualError(t, ErrClientAlreadyStarted, err.Error())
+
 	// Test invalid command
 	err = server.processCommand(Command(100), cli)
 	assert.EqualError(t, ErrInvalidCommand, err.Error())
 }
+
+func TestAddStreamEntryWithNumber(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	// Import a pre-numbered sequence starting at a base entry number
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.AddStreamBookmarkWithNumber(1000, []byte("bm1000"))) //nolint:mnd
+	for num := uint64(1001); num < 1010; num++ {
+		require.NoError(t, s.AddStreamEntryWithNumber(num, 1, []byte{byte(num)}))
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	// Numbers must be contiguous with the tail
+	require.NoError(t, s.StartAtomicOp())
+	assert.ErrorIs(t, s.AddStreamEntryWithNumber(1011, 1, nil), ErrEntryNumberNotContiguous) //nolint:mnd
+	assert.ErrorIs(t, s.AddStreamEntryWithNumber(1009, 1, nil), ErrEntryNumberNotContiguous) //nolint:mnd
+
+	// Mixing with automatic numbering fails
+	_, err := s.AddStreamEntry(1, nil)
+	assert.ErrorIs(t, err, ErrNumberingModeMismatch)
+	require.NoError(t, s.AddStreamEntryWithNumber(1010, 1, []byte{10})) //nolint:mnd
+	require.NoError(t, s.CommitAtomicOp())
+
+	header := s.GetHeader()
+	assert.Equal(t, uint64(1011), header.TotalEntries)
+	assert.Equal(t, uint64(1000), header.firstEntry)
+
+	entry, err := s.GetEntry(1005) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1005), entry.Number)
+	assert.Equal(t, []byte{byte(1005 % 256)}, entry.Data) //nolint:mnd
+	_, err = s.GetEntry(999)                              //nolint:mnd
+	assert.ErrorIs(t, err, ErrInvalidEntryNumber)
+
+	entryNum, err := s.GetBookmark([]byte("bm1000"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), entryNum)
+
+	// Base number and numbering mode survive reopening the file
+	require.NoError(t, s.Close())
+	s = newTestServer(t, dir)
+	require.NoError(t, s.EnableCommitJournal())
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, nil)
+	assert.ErrorIs(t, err, ErrNumberingModeMismatch)
+	require.NoError(t, s.AddStreamEntryWithNumber(1011, 1, nil)) //nolint:mnd
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Equal(t, uint64(1012), s.GetHeader().TotalEntries)
+
+	require.NoError(t, s.Close())
+	report, err := NewConsistencyChecker(filepath.Join(dir, "stream.bin")).Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+}
+
+func TestAddStreamEntryWithNumberRollback(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	// Rolling back the first import discards the base entry number
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.AddStreamEntryWithNumber(500, 1, nil)) //nolint:mnd
+	require.NoError(t, s.RollbackAtomicOp())
+	assert.Equal(t, uint64(0), s.nextEntry)
+
+	// Automatic numbering in a file fails for caller-provided numbers
+	require.NoError(t, s.StartAtomicOp())
+	entryNum, err := s.AddStreamEntry(1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), entryNum)
+	assert.ErrorIs(t, s.AddStreamEntryWithNumber(1, 1, nil), ErrNumberingModeMismatch)
+	require.NoError(t, s.CommitAtomicOp())
+}
+
+func TestOnRollback(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	var calls [][]FileEntry
+	s.SetOnRollback(func(discardedEntries []FileEntry) {
+		calls = append(calls, discardedEntries)
+	})
+
+	// Commit does not fire the callback
+	require.NoError(t, s.StartAtomicOp())
+	_, err := s.AddStreamEntry(1, []byte{1})
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Empty(t, calls)
+
+	// Rollback fires it with the discarded entries
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamBookmark([]byte{0xb})
+	require.NoError(t, err)
+	_, err = s.AddStreamEntry(2, []byte{2, 2}) //nolint:mnd
+	require.NoError(t, err)
+	require.NoError(t, s.RollbackAtomicOp())
+
+	require.Len(t, calls, 1)
+	require.Len(t, calls[0], 2) //nolint:mnd
+	assert.Equal(t, uint64(1), calls[0][0].Number)
+	assert.Equal(t, EntryType(EtBookmark), calls[0][0].Type)
+	assert.Equal(t, []byte{0xb}, calls[0][0].Data)
+	assert.Equal(t, uint64(2), calls[0][1].Number)
+	assert.Equal(t, EntryType(2), calls[0][1].Type)
+	assert.Equal(t, []byte{2, 2}, calls[0][1].Data)
+
+	// The discarded entries are not overwritten by the next atomic operation
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(3, []byte{3}) //nolint:mnd
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Len(t, calls, 1)
+	assert.Equal(t, EntryType(EtBookmark), calls[0][0].Type)
+}
+
+// downloadTestClient starts a client recording the downloaded entries and checkpoints, stopping with an error
+// after processing the entry stopAt
+func downloadTestClient(t *testing.T, s *StreamServer, stopAt uint64) (*StreamClient, chan uint64,
+	chan DownloadCheckpoint) {
+	t.Helper()
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	entries := make(chan uint64, 1000)
+	checkpoints := make(chan DownloadCheckpoint, 100)
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		entries <- e.Number
+		if e.Number == stopAt {
+			close(entries)
+			return ErrEntryNotFound
+		}
+		return nil
+	})
+	c.SetProcessCheckpointFunc(func(cp DownloadCheckpoint, _ *StreamClient) error {
+		checkpoints <- cp
+		if cp.Done() {
+			close(entries)
+		}
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+
+	return c, entries, checkpoints
+}
+
+func TestDownloadResumeFromCheckpoint(t *testing.T) {
+	const numEntries = 100
+
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	for i := range numEntries {
+		_, err := s.AddStreamEntry(1, bytes.Repeat([]byte{byte(i)}, 30000)) //nolint:mnd
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	// Download interrupted after processing entry 35
+	c, entries, checkpoints := downloadTestClient(t, s, 35)                     //nolint:mnd
+	require.NoError(t, c.ExecCommandDownload(DownloadCheckpoint{Entry: 0}, 10)) //nolint:mnd
+	var next uint64
+	for num := range entries {
+		require.Equal(t, next, num)
+		next++
+	}
+	require.Len(t, checkpoints, 3) //nolint:mnd
+	var last DownloadCheckpoint
+	for range 3 {
+		last = <-checkpoints
+	}
+	assert.Equal(t, uint64(30), last.Entry)
+	assert.Equal(t, uint64(numEntries), last.ToEntry)
+	assert.False(t, last.Done())
+
+	// The checkpoint offset points to the entry in the stream file
+	iterator, err := s.streamFile.iteratorFromOffset(last.Entry, last.Offset)
+	require.NoError(t, err)
+	pos, err := s.streamFile.iteratorPos(iterator)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, last.Offset, pos)
+	end, err := s.streamFile.iteratorNext(iterator)
+	require.NoError(t, err)
+	require.False(t, end)
+	assert.Equal(t, last.Entry, iterator.Entry.Number)
+	s.streamFile.iteratorEnd(iterator)
+
+	// A wrong offset falls back to searching the entry
+	iterator, err = s.streamFile.iteratorFromOffset(last.Entry, last.Offset+1)
+	require.NoError(t, err)
+	end, err = s.streamFile.iteratorNext(iterator)
+	require.NoError(t, err)
+	require.False(t, end)
+	assert.Equal(t, last.Entry, iterator.Entry.Number)
+	s.streamFile.iteratorEnd(iterator)
+
+	// An offset pointing to the padding at the end of a page finds the entry in the next page
+	iterator, err = s.streamFile.iteratorFrom(33, true) //nolint:mnd
+	require.NoError(t, err)
+	_, err = s.streamFile.iteratorNext(iterator)
+	require.NoError(t, err)
+	pos, err = s.streamFile.iteratorPos(iterator)
+	require.NoError(t, err)
+	s.streamFile.iteratorEnd(iterator)
+	require.NotZero(t, (pos-PageHeaderSize)%PageDataSize, "entry 33 expected at the end of the first page")
+	iterator, err = s.streamFile.iteratorFromOffset(34, pos) //nolint:mnd
+	require.NoError(t, err)
+	end, err = s.streamFile.iteratorNext(iterator)
+	require.NoError(t, err)
+	require.False(t, end)
+	assert.Equal(t, uint64(34), iterator.Entry.Number)
+	pos, err = s.streamFile.iteratorPos(iterator)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(PageHeaderSize+PageDataSize+FixedSizeFileEntry+30000), pos) //nolint:mnd
+	s.streamFile.iteratorEnd(iterator)
+
+	// New client resuming from the stored checkpoint until completion
+	c, entries, checkpoints = downloadTestClient(t, s, numEntries)
+	require.NoError(t, c.ExecCommandDownload(last, 10)) //nolint:mnd
+	next = last.Entry
+	for num := range entries {
+		require.Equal(t, next, num)
+		next++
+	}
+	assert.Equal(t, uint64(numEntries), next)
+	for cp := range len(checkpoints) - 1 {
+		assert.Equal(t, last.Entry+uint64(cp+1)*10, (<-checkpoints).Entry) //nolint:mnd
+	}
+	final := <-checkpoints
+	assert.True(t, final.Done())
+	assert.Equal(t, final, c.GetDownloadCheckpoint())
+}
+
+func TestGetEntriesSince(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	// Commit journal not enabled
+	_, err := s.GetEntriesSince(time.Hour)
+	require.ErrorIs(t, err, ErrCommitJournalDisabled)
+	require.NoError(t, s.EnableCommitJournal())
+
+	// No entries yet
+	entryNum, err := s.GetEntriesSince(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), entryNum)
+
+	// Four commits of 3 entries with known commit times
+	now := time.Now()
+	ages := []time.Duration{60 * time.Minute, 30 * time.Minute, 10 * time.Minute, time.Minute} //nolint:mnd
+	for i, age := range ages {
+		require.NoError(t, s.StartAtomicOp())
+		for range 3 {
+			_, err = s.AddStreamEntry(1, []byte{byte(i)})
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+		require.NoError(t, s.journal.AddCommit(CommitRecord{FirstEntry: uint64(i * 3), Timestamp: now.Add(-age)})) //nolint:mnd
+	}
+
+	timestamp, err := s.GetEntryTimestamp(7) //nolint:mnd
+	require.NoError(t, err)
+	assert.True(t, timestamp.Equal(now.Add(-10*time.Minute)), "%v", timestamp) //nolint:mnd
+	_, err = s.GetEntryTimestamp(12)                                           //nolint:mnd
+	assert.ErrorIs(t, err, ErrInvalidEntryNumber)
+
+	tests := []struct {
+		since time.Duration
+		entry uint64
+	}{
+		{since: 24 * time.Hour, entry: 0},   // predates all entries
+		{since: 45 * time.Minute, entry: 3}, //nolint:mnd
+		{since: 20 * time.Minute, entry: 6}, //nolint:mnd
+		{since: 5 * time.Minute, entry: 9},  //nolint:mnd
+		{since: time.Second, entry: 12},     //nolint:mnd
+	}
+	for _, tc := range tests {
+		entryNum, err = s.GetEntriesSince(tc.since)
+		require.NoError(t, err)
+		assert.Equal(t, tc.entry, entryNum, "since %v", tc.since)
+	}
+
+	// Truncated entries are removed from the journal
+	require.NoError(t, s.TruncateFile(6))               //nolint:mnd
+	entryNum, err = s.GetEntriesSince(20 * time.Minute) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), entryNum)
+	r, err := s.journal.GetCommit(9) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), r.FirstEntry)
+}
+
+func TestCommitMeta(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	require.NoError(t, s.EnableCommitJournal())
+
+	// Commits of 3 entries with and without metadata
+	metas := []Metadata{{"l1TxHash": "0xabc1"}, nil, {"l1TxHash": "0xabc3", "l1Block": "7"}}
+	for _, meta := range metas {
+		require.NoError(t, s.StartAtomicOp())
+		for range 3 {
+			_, err := s.AddStreamEntry(1, nil)
+			require.NoError(t, err)
+		}
+		if meta != nil {
+			require.NoError(t, s.CommitAtomicOpWithMeta(meta))
+		} else {
+			require.NoError(t, s.CommitAtomicOp())
+		}
+	}
+
+	// Metadata survives reopening the journal
+	require.NoError(t, s.Close())
+	s = newTestServer(t, dir)
+	require.NoError(t, s.EnableCommitJournal())
+
+	for entryNum := range uint64(9) { //nolint:mnd
+		meta, err := s.GetCommitMeta(entryNum)
+		require.NoError(t, err)
+		if want := metas[entryNum/3]; want != nil { //nolint:mnd
+			assert.Equal(t, want, meta, "entry %d", entryNum)
+		} else {
+			assert.Nil(t, meta, "entry %d", entryNum)
+		}
+	}
+	_, err := s.GetCommitMeta(9) //nolint:mnd
+	assert.ErrorIs(t, err, ErrInvalidEntryNumber)
+}
+
+func TestEstimateCatchUp(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	for range 1000 {
+		_, err := s.AddStreamEntry(1, nil)
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	// No rate measured yet
+	remaining, eta := s.EstimateCatchUp(399) //nolint:mnd
+	assert.Equal(t, uint64(600), remaining)
+	assert.Zero(t, eta)
+
+	// 100 entries per second during the last 30 seconds, and a burst out of the window
+	now := time.Now()
+	s.catchUpRate.add(now.Add(-2*defaultRateWindow), 100000) //nolint:mnd
+	for i := range 30 {
+		s.catchUpRate.add(now.Add(time.Duration(i-30)*time.Second), 100) //nolint:mnd
+	}
+	remaining, eta = s.EstimateCatchUp(399) //nolint:mnd
+	assert.Equal(t, uint64(600), remaining)
+	assert.InDelta(t, 6*time.Second, eta, float64(500*time.Millisecond)) //nolint:mnd
+
+	// Caught up
+	remaining, eta = s.EstimateCatchUp(999) //nolint:mnd
+	assert.Zero(t, remaining)
+	assert.Zero(t, eta)
+}
+
+func TestTombstone(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	require.NoError(t, s.StartAtomicOp())
+	for i := range 5 {
+		_, err := s.AddStreamEntry(1, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, err := s.AddStreamEntry(EntryType(tombstoneFlag|1), nil)
+	assert.ErrorIs(t, err, ErrInvalidEntryType)
+	assert.ErrorIs(t, s.Tombstone(0), ErrUpdateNotAllowed)
+	require.NoError(t, s.CommitAtomicOp())
+
+	require.NoError(t, s.Tombstone(1))
+	require.NoError(t, s.Tombstone(3))                       //nolint:mnd
+	require.NoError(t, s.Tombstone(3))                       //nolint:mnd
+	assert.ErrorIs(t, s.Tombstone(5), ErrInvalidEntryNumber) //nolint:mnd
+
+	// Tombstones survive reopening the file
+	require.NoError(t, s.Close())
+	s = newTestServer(t, dir)
+	require.NoError(t, s.EnableCommitJournal())
+
+	_, err = s.GetEntry(1)
+	assert.ErrorIs(t, err, ErrEntryTombstoned)
+	entry, err := s.GetEntry(2) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, []byte{2}, entry.Data)
+	assert.Equal(t, EntryType(1), entry.Type)
+
+	readNumbers := func(opts IteratorOptions) []uint64 {
+		it, err := s.GetIterator(0, opts)
+		require.NoError(t, err)
+		defer it.End()
+
+		var numbers []uint64
+		for {
+			end, err := it.Next()
+			require.NoError(t, err)
+			if end {
+				return numbers
+			}
+			entry := it.GetEntry()
+			assert.Equal(t, EntryType(1), entry.Type)
+			assert.Equal(t, entry.Number == 1 || entry.Number == 3, entry.Tombstoned)
+			numbers = append(numbers, entry.Number)
+		}
+	}
+	assert.Equal(t, []uint64{0, 2, 4}, readNumbers(IteratorOptions{}))
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4}, readNumbers(IteratorOptions{IncludeTombstones: true}))
+
+	// Tombstoned entries are not streamed
+	conn, err := net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, uint32(CmdErrOK), sendStartCommand(t, conn, 0))
+	assert.Equal(t, []uint64{0, 2, 4}, readStreamEntryNumbers(t, conn, 3)) //nolint:mnd
+
+	// A relay fills the entries not streamed with tombstoned placeholders
+	r := newTestServer(t, t.TempDir())
+	for _, number := range []uint64{0, 2, 4} {
+		entry, err := s.GetEntry(number)
+		require.NoError(t, err)
+		require.NoError(t, relayEntry(&entry, nil, r))
+	}
+	assert.Equal(t, uint64(5), r.GetHeader().TotalEntries) //nolint:mnd
+	_, err = r.GetEntry(3)                                 //nolint:mnd
+	assert.ErrorIs(t, err, ErrEntryTombstoned)
+	entry, err = r.GetEntry(4) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, []byte{4}, entry.Data)
+}
+
+// sendStartCommand sends a raw CmdStart to the server and returns the error number of its result
+func sendStartCommand(t *testing.T, conn net.Conn, fromEntry uint64) uint32 {
+	t.Helper()
+
+	cmd := binary.BigEndian.AppendUint64(nil, uint64(CmdStart))
+	cmd = binary.BigEndian.AppendUint64(cmd, 1)
+	cmd = binary.BigEndian.AppendUint64(cmd, fromEntry)
+	_, err := conn.Write(cmd)
+	require.NoError(t, err)
+
+	buffer := make([]byte, FixedSizeResultEntry)
+	_, err = io.ReadFull(conn, buffer)
+	require.NoError(t, err)
+	require.Equal(t, byte(PtResult), buffer[0])
+	_, err = io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(buffer[1:5])-FixedSizeResultEntry))
+	require.NoError(t, err)
+
+	return binary.BigEndian.Uint32(buffer[5:9])
+}
+
+// readStreamEntryNumbers reads a number of data entries streamed by the server and returns their entry numbers
+func readStreamEntryNumbers(t *testing.T, conn net.Conn, count int) []uint64 {
+	t.Helper()
+
+	var numbers []uint64
+	for range count {
+		buffer := make([]byte, FixedSizeFileEntry)
+		_, err := io.ReadFull(conn, buffer)
+		require.NoError(t, err)
+		require.Equal(t, byte(PtData), buffer[0])
+		_, err = io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(buffer[1:5])-FixedSizeFileEntry))
+		require.NoError(t, err)
+		numbers = append(numbers, binary.BigEndian.Uint64(buffer[9:17]))
+	}
+
+	return numbers
+}
+
+func TestDuplicateStart(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	for i := range 3 {
+		_, err := s.AddStreamEntry(1, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	tests := []struct {
+		name     string
+		mode     DuplicateStartMode
+		errorNum uint32
+		restart  bool
+	}{
+		{name: "reject", mode: DuplicateStartReject, errorNum: uint32(CmdErrAlreadyStarted)},
+		{name: "restart", mode: DuplicateStartRestart, errorNum: uint32(CmdErrOK), restart: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s.SetDuplicateStartMode(tc.mode)
+			total := s.GetHeader().TotalEntries
+
+			conn, err := net.Dial("tcp", testServerAddr(s))
+			require.NoError(t, err)
+			defer conn.Close()
+
+			require.Equal(t, uint32(CmdErrOK), sendStartCommand(t, conn, 0))
+			assert.Len(t, readStreamEntryNumbers(t, conn, int(total)), int(total))
+
+			// Second start on the same connection from the previous to last entry
+			assert.Equal(t, tc.errorNum, sendStartCommand(t, conn, total-2))
+			if tc.restart {
+				assert.Equal(t, []uint64{total - 2, total - 1}, readStreamEntryNumbers(t, conn, 2)) //nolint:mnd
+			}
+
+			// The connection keeps streaming the new entries once
+			require.NoError(t, s.StartAtomicOp())
+			_, err = s.AddStreamEntry(1, nil)
+			require.NoError(t, err)
+			require.NoError(t, s.CommitAtomicOp())
+			last := s.GetHeader().TotalEntries - 1
+			assert.Equal(t, []uint64{last}, readStreamEntryNumbers(t, conn, 1))
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond))) //nolint:mnd
+			_, err = conn.Read(make([]byte, 1))
+			assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		})
+	}
+}
+
+func TestGetEntriesByBookmarkRange(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	blockKey := func(n uint64) []byte { return binary.BigEndian.AppendUint64(nil, n) }
+
+	// Blocks 2, 4, 6 and 8: a bookmark followed by two entries each
+	require.NoError(t, s.StartAtomicOp())
+	for block := uint64(2); block <= 8; block += 2 {
+		_, err := s.AddStreamBookmark(blockKey(block))
+		require.NoError(t, err)
+		for i := range 2 {
+			_, err = s.AddStreamEntry(1, []byte{byte(block), byte(i)})
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	numbers := func(entries []FileEntry) []uint64 {
+		var nums []uint64
+		for _, e := range entries {
+			nums = append(nums, e.Number)
+		}
+		return nums
+	}
+
+	tests := []struct {
+		name       string
+		from, to   uint64
+		firstBlock uint64
+		numbers    []uint64
+		err        error
+	}{
+		{"exact keys", 4, 6, 4, []uint64{3, 4, 5, 6, 7, 8}, nil},
+		{"keys clamped", 3, 7, 4, []uint64{3, 4, 5, 6, 7, 8}, nil},
+		{"single block", 6, 6, 6, []uint64{6, 7, 8}, nil},
+		{"until the tail", 8, 100, 8, []uint64{9, 10, 11}, nil},
+		{"from before the first", 0, 2, 2, []uint64{0, 1, 2}, nil},
+		{"no bookmark within", 5, 5, 0, nil, ErrBookmarkNotFound},
+		{"after the last", 9, 100, 0, nil, ErrBookmarkNotFound},
+		{"inverted", 6, 4, 0, nil, ErrInvalidBookmarkRange},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := s.GetEntriesByBookmarkRange(blockKey(tc.from), blockKey(tc.to))
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.numbers, numbers(entries))
+			assert.Equal(t, EntryType(EtBookmark), entries[0].Type)
+			assert.Equal(t, blockKey(tc.firstBlock), entries[0].Data)
+		})
+	}
+}
+
+func TestOnBookmark(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	type event struct {
+		key      string
+		entryNum uint64
+	}
+	events := make(chan event, 10) //nolint:mnd
+	s.SetOnBookmark(func(key []byte, entryNum uint64) {
+		events <- event{key: string(key), entryNum: entryNum}
+	})
+
+	addBookmarks := func(keys ...string) {
+		for _, key := range keys {
+			_, err := s.AddStreamBookmark([]byte(key))
+			require.NoError(t, err)
+			_, err = s.AddStreamEntry(1, []byte(key))
+			require.NoError(t, err)
+		}
+	}
+
+	// Committed, rolled back and committed again
+	require.NoError(t, s.StartAtomicOp())
+	addBookmarks("a", "b")
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.StartAtomicOp())
+	addBookmarks("rolled back")
+	require.NoError(t, s.RollbackAtomicOp())
+	require.NoError(t, s.StartAtomicOp())
+	addBookmarks("c")
+	require.NoError(t, s.CommitAtomicOp())
+
+	expected := []event{{"a", 0}, {"b", 2}, {"c", 4}}
+	for _, e := range expected {
+		select {
+		case got := <-events:
+			assert.Equal(t, e, got)
+		case <-time.After(time.Second):
+			t.Fatalf("bookmark %s not notified", e.key)
+		}
+	}
+	select {
+	case got := <-events:
+		t.Fatalf("unexpected bookmark %s notified", got.key)
+	case <-time.After(50 * time.Millisecond): //nolint:mnd
+	}
+}
+
+func TestStartBookmarkPrefix(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	// Each item is a bookmark key or, if empty, a data entry
+	commit := func(items ...string) {
+		require.NoError(t, s.StartAtomicOp())
+		for _, key := range items {
+			var err error
+			if key == "" {
+				_, err = s.AddStreamEntry(1, []byte{1})
+			} else {
+				_, err = s.AddStreamBookmark([]byte(key))
+			}
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+	}
+
+	// Entry 7 is marked by two bookmarks, one of them matching
+	commit("acct-a/1", "", "", "acct-b/1", "", "batch/7", "acct-a/2", "", "acct-b/2", "")
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	received := make(chan uint64, 100) //nolint:mnd
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- e.Number
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.ExecCommandStartBookmarkPrefix(0, []byte("acct-a/")))
+
+	// Streamed while synced too
+	commit("acct-b/3", "", "acct-a/3", "")
+
+	var numbers []uint64
+	for len(numbers) < 7 {
+		select {
+		case num := <-received:
+			numbers = append(numbers, num)
+		case <-time.After(time.Second):
+			t.Fatalf("entries received %v", numbers)
+		}
+	}
+	assert.Equal(t, []uint64{0, 1, 2, 6, 7, 12, 13}, numbers)
+	select {
+	case num := <-received:
+		t.Fatalf("unexpected entry %d received", num)
+	case <-time.After(50 * time.Millisecond): //nolint:mnd
+	}
+}
+
+func TestAddStreamEntries(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	_, err := s.AddStreamEntries([]StreamEntryInput{{Type: 1}})
+	require.ErrorIs(t, err, ErrAddEntryNotAllowed)
+
+	// Numbered after the single entries, over several data pages
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, []byte{0})
+	require.NoError(t, err)
+	inputs := make([]StreamEntryInput, 2500) //nolint:mnd
+	for i := range inputs {
+		inputs[i] = StreamEntryInput{Type: EntryType(i%3 + 1), Data: bytes.Repeat([]byte{byte(i)}, 1000)} //nolint:mnd
+	}
+	nums, err := s.AddStreamEntries(inputs)
+	require.NoError(t, err)
+	require.Len(t, nums, len(inputs))
+	for i, num := range nums {
+		assert.Equal(t, uint64(i+1), num)
+	}
+	num, err := s.AddStreamEntry(1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(inputs)+1), num)
+	require.NoError(t, s.CommitAtomicOp())
+	for i := range inputs {
+		e, err := s.GetEntry(uint64(i + 1))
+		require.NoError(t, err)
+		assert.Equal(t, inputs[i].Type, e.Type)
+		assert.Equal(t, inputs[i].Data, e.Data)
+	}
+
+	// Rolled back with the atomic operation, an invalid entry adds none
+	header := s.GetHeader()
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntries([]StreamEntryInput{{Type: 1}, {Type: tombstoneFlag}})
+	require.ErrorIs(t, err, ErrInvalidEntryType)
+	nums, err = s.AddStreamEntries(inputs[:10])
+	require.NoError(t, err)
+	assert.Equal(t, header.TotalEntries, nums[0])
+	require.NoError(t, s.RollbackAtomicOp())
+	assert.Equal(t, header, s.GetHeader())
+
+	require.NoError(t, s.StartAtomicOp())
+	nums, err = s.AddStreamEntries(inputs[:1])
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{header.TotalEntries}, nums)
+	require.NoError(t, s.CommitAtomicOp())
+}
+
+func BenchmarkAddStreamEntries(b *testing.B) {
+	inputs := make([]StreamEntryInput, 1000) //nolint:mnd
+	for i := range inputs {
+		inputs[i] = StreamEntryInput{Type: 1, Data: make([]byte, 100)} //nolint:mnd
+	}
+	adds := []struct {
+		name string
+		add  func(s *StreamServer) error
+	}{
+		{"one-at-a-time", func(s *StreamServer) error {
+			for _, input := range inputs {
+				_, err := s.AddStreamEntry(input.Type, input.Data)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"batched", func(s *StreamServer) error {
+			_, err := s.AddStreamEntries(inputs)
+			return err
+		}},
+	}
+
+	for _, a := range adds {
+		b.Run(a.name, func(b *testing.B) {
+			s, err := NewServer(0, 1, 12345, 1, filepath.Join(b.TempDir(), "stream.bin"), time.Second, time.Minute,
+				time.Minute, nil)
+			require.NoError(b, err)
+			require.NoError(b, s.Start())
+			defer s.Close()
+
+			b.ResetTimer()
+			for range b.N {
+				require.NoError(b, s.StartAtomicOp())
+				require.NoError(b, a.add(s))
+				require.NoError(b, s.CommitAtomicOp())
+			}
+			b.ReportMetric(float64(b.N*len(inputs))/b.Elapsed().Seconds(), "entries/s")
+		})
+	}
+}
+
+func TestZeroLengthEntries(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	// Fill the first data page leaving room for just a zero-length entry, the next one starts the second page
+	sizes := make([]int, 0, 1040) //nolint:mnd
+	for range 1031 {
+		sizes = append(sizes, 1000) //nolint:mnd
+	}
+	sizes = append(sizes, 6, 0, 0, 10, 0) //nolint:mnd
+	require.NoError(t, s.StartAtomicOp())
+	for i, size := range sizes {
+		var data []byte
+		if size > 0 {
+			data = make([]byte, size)
+		}
+		num, err := s.AddStreamEntry(1, data)
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), num)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	firstZero := uint64(1032)
+	// The second page holds the second zero-length entry and the next ones
+	require.Equal(t, uint64(PageHeaderSize+PageDataSize+3*FixedSizeFileEntry+10), //nolint:mnd
+		s.GetHeader().TotalLength)
+
+	// Read back as empty non-nil data, around the page boundary
+	for i := firstZero - 1; i < uint64(len(sizes)); i++ {
+		e, err := s.GetEntry(i)
+		require.NoError(t, err)
+		assert.Equal(t, i, e.Number)
+		assert.Len(t, e.Data, sizes[i])
+		assert.NotNil(t, e.Data)
+		assert.Equal(t, uint32(FixedSizeFileEntry+sizes[i]), e.Length)
+	}
+
+	// The iterator yields them
+	var numbers []uint64
+	for e, err := range s.streamFile.Entries(firstZero-1, uint64(len(sizes))) {
+		require.NoError(t, err)
+		assert.NotNil(t, e.Data)
+		numbers = append(numbers, e.Number)
+	}
+	assert.Equal(t, []uint64{1031, 1032, 1033, 1034, 1035}, numbers)
+
+	// Streamed to the clients
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	received := make(chan FileEntry, 10) //nolint:mnd
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- *e
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.ExecCommandStart(firstZero))
+	for _, num := range []uint64{1032, 1033, 1034, 1035} {
+		select {
+		case e := <-received:
+			assert.Equal(t, num, e.Number)
+			assert.Len(t, e.Data, sizes[num])
+			assert.NotNil(t, e.Data)
+		case <-time.After(time.Second):
+			t.Fatalf("entry %d not received", num)
+		}
+	}
+
+	// The file is consistent
+	require.NoError(t, s.Close())
+	report, err := NewConsistencyChecker(filepath.Join(dir, "stream.bin")).Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+}
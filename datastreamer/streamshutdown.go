@@ -0,0 +1,116 @@
+package datastreamer
+
+import (
+	"context"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+const shutdownPollInterval = 10 * time.Millisecond // Interval checking the entries pending to be sent on shutdown
+
+// closeWriter type for the connections that can be closed just for writing (TCP and TLS)
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Shutdown closes the server gracefully: it stops accepting new connections, rolls back the atomic operation in
+// progress (not committed), waits for the committed entries to be sent to the connected clients, closes the
+// connections for writing so the clients read a clean EOF after the last entry, and waits for the clients to close
+// them before closing the server (Close). When the context expires the remaining clients are disconnected and its
+// error is returned. It must be called by the goroutine adding the entries, later calls do nothing.
+func (s *StreamServer) Shutdown(ctx context.Context) error {
+	var err error
+	s.shutdownOnce.Do(func() {
+		err = s.shutdown(ctx)
+	})
+	return err
+}
+
+// shutdown closes the server gracefully
+func (s *StreamServer) shutdown(ctx context.Context) error {
+	log.Infof("Shutting down datastream server %d", s.port)
+	s.healthState.Store(healthDraining)
+
+	// Stop accepting new connections
+	if s.ln != nil {
+		if err := s.ln.Close(); err != nil {
+			log.Warnf("Error closing listener: %v", err)
+		}
+		s.ln = nil
+	}
+
+	// Entries not committed are discarded
+	if s.atomicOp.status == aoStarted {
+		log.Warnf("Rolling back atomic operation from entry %d on shutdown", s.atomicOp.startEntry)
+		if err := s.RollbackAtomicOp(); err != nil {
+			log.Errorf("Error rolling back atomic operation on shutdown: %v", err)
+		}
+	}
+
+	// Wait for the clients to receive the committed entries, then close the connections for writing
+	err := s.waitSent(ctx)
+	if err == nil {
+		s.mutexClients.RLock()
+		for _, cli := range s.clients {
+			if cw, ok := cli.conn.(closeWriter); ok {
+				if cwErr := cw.CloseWrite(); cwErr != nil {
+					log.Warnf("Error closing connection to %s for writing: %v", cli.clientID, cwErr)
+				}
+			}
+		}
+		s.mutexClients.RUnlock()
+
+		// Wait for the clients to close their connections
+		closed := make(chan struct{})
+		go func() {
+			s.wgClients.Wait()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		log.Warnf("Shutdown of datastream server %d not completed, disconnecting the clients: %v", s.port, err)
+	}
+
+	// The remaining clients are disconnected
+	closeErr := s.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// waitSent waits until the committed entries are sent to the clients and none of them is catching up
+func (s *StreamServer) waitSent(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.allSent() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// allSent returns if there are no committed entries pending to be sent to the clients
+func (s *StreamServer) allSent() bool {
+	if s.inBroadcast.Load() > 0 {
+		return false
+	}
+
+	s.mutexClients.RLock()
+	defer s.mutexClients.RUnlock()
+	for _, cli := range s.clients {
+		if cli.getStatus() == csSyncing || (cli.outbox != nil && cli.outbox.pending() > 0) {
+			return false
+		}
+	}
+	return true
+}
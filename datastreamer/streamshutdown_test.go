@@ -0,0 +1,72 @@
+package datastreamer
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.SetMaxInFlightBytes(64*1024*1024, SlowClientBlock) //nolint:mnd
+	})
+	conn, err := net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, uint32(0), sendStartCommand(t, conn, 0))
+
+	// Entries committed while the client is streaming, and an atomic operation not committed
+	for range 100 {
+		commitTestEntry(t, s)
+	}
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, []byte{1})
+	require.NoError(t, err)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second) //nolint:mnd
+		defer cancel()
+		shutdown <- s.Shutdown(ctx)
+	}()
+
+	// The client receives all the committed entries and then a clean EOF
+	numbers := readStreamEntryNumbers(t, conn, 100) //nolint:mnd
+	assert.Equal(t, uint64(99), numbers[99])
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.NoError(t, conn.Close())
+	select {
+	case err = <-shutdown:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second): //nolint:mnd
+		t.Fatal("shutdown not completed")
+	}
+
+	// Closed once, the atomic operation not committed was discarded
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.Nil(t, s.streamFile)
+	sf, err := NewStreamFile(s.fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), sf.getHeaderEntry().TotalEntries) //nolint:mnd
+	require.NoError(t, sf.Close())
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	conn, err := net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, uint32(0), sendStartCommand(t, conn, 0))
+
+	// The client doesn't close its connection, it's disconnected when the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond) //nolint:mnd
+	defer cancel()
+	require.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Equal(t, 0, s.getSafeClientsLen())
+}
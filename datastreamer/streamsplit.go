@@ -0,0 +1,220 @@
+package datastreamer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// splitOutput type for the stream file of one entry type written by SplitByType
+type splitOutput struct {
+	streamFile *StreamFile
+	bookmark   *StreamBookmark // Bookmarks DB of the output stream file
+	mapping    *os.File
+	writer     *bufio.Writer // Writer of the mapping file
+	nextEntry  uint64
+	created    []string // Files created for the output, removed if the split fails
+}
+
+// SplitByType reads the committed entries of a stream file and writes the entries of each entry type (bookmarks
+// included) into its own stream file in outDir, re-numbered from zero. Each output has a sidecar mapping file
+// (SplitMappingName) with the original entry numbers, and a bookmarks DB where each bookmark points to the first
+// entry of the output after it. Returns the stream file name of each entry type. The outputs must not exist, and
+// are removed if the split fails.
+func SplitByType(srcFile string, outDir string) (map[EntryType]string, error) {
+	file, err := os.Open(srcFile)
+	if err != nil {
+		log.Errorf("Error opening file %s to split: %v", srcFile, err)
+		return nil, err
+	}
+	defer file.Close()
+
+	header, err := readFileHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	if header.encryption != encryptionNone {
+		log.Errorf("File %s to split is encrypted", srcFile)
+		return nil, ErrFileEncrypted
+	}
+
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// Write each entry to the output of its type
+	base := filepath.Base(srcFile)
+	baseWithoutExt := strings.TrimSuffix(base, filepath.Ext(base))
+	outputs := make(map[EntryType]*splitOutput)
+	fileNames := make(map[EntryType]string)
+	var bookmarks [][]byte
+	_, err = walkEntries(file, header.TotalLength, header.PageSize(), func(_ uint64, e FileEntry) error {
+		err = decompressEntry(header.compression, &e)
+		if err != nil {
+			return err
+		}
+		out, ok := outputs[e.Type]
+		if !ok {
+			fileName := filepath.Join(outDir, fmt.Sprintf("%s_type%d.bin", baseWithoutExt, e.Type))
+			out, err = newSplitOutput(fileName, header)
+			if err != nil {
+				return err
+			}
+			outputs[e.Type] = out
+			fileNames[e.Type] = fileName
+
+			// The previous bookmarks point to its first entry
+			for _, bookmark := range bookmarks {
+				err = out.bookmark.AddBookmark(bookmark, 0)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Bookmark pointing to the next entry of each output
+		if e.Type == EtBookmark {
+			bookmarks = append(bookmarks, e.Data)
+			for _, o := range outputs {
+				err = o.bookmark.AddBookmark(e.Data, o.nextEntry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return out.add(e)
+	})
+
+	// Commit and close the outputs
+	for _, out := range outputs {
+		err = errors.Join(err, out.close())
+	}
+	if err != nil {
+		log.Errorf("Error splitting file %s: %v", srcFile, err)
+
+		// Remove the partial outputs
+		for _, out := range outputs {
+			out.remove()
+		}
+		return nil, err
+	}
+
+	log.Infof("File %s split into %d entry types", srcFile, len(fileNames))
+	return fileNames, nil
+}
+
+// SplitMappingName returns the sidecar mapping file name of a stream file written by SplitByType
+func SplitMappingName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".map"
+}
+
+// ReadSplitMapping reads a sidecar mapping file written by SplitByType, returns the original entry number of each
+// entry indexed by its new entry number
+func ReadSplitMapping(mappingFile string) ([]uint64, error) {
+	b, err := os.ReadFile(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(b)%8 != 0 { //nolint:mnd
+		log.Errorf("Invalid mapping file %s size %d", mappingFile, len(b))
+		return nil, ErrBadFileFormat
+	}
+
+	mapping := make([]uint64, 0, len(b)/8) //nolint:mnd
+	for i := 0; i < len(b); i += 8 {
+		mapping = append(mapping, binary.BigEndian.Uint64(b[i:i+8]))
+	}
+	return mapping, nil
+}
+
+// newSplitOutput creates the stream file, the bookmarks DB and the mapping file of an entry type, they must not exist
+func newSplitOutput(fileName string, header HeaderEntry) (*splitOutput, error) {
+	// Same settings as the source file
+	codec, err := GetMetadataCodec(header.metaCodec)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &splitOutput{}
+	out.streamFile, err = NewStreamFileWithOptions(fileName, header.Version, header.SystemID, header.streamType,
+		StreamFileOptions{MetadataCodec: codec, CreateOnly: true, PageSize: header.PageSize(),
+			Compression: header.compression})
+	if err != nil {
+		return nil, err
+	}
+	out.created = append(out.created, fileName)
+
+	// Bookmarks DB directory created here so an existing one is not reused
+	bookmarkName := bookmarkDBName(fileName)
+	err = os.Mkdir(bookmarkName, os.ModePerm)
+	if err == nil {
+		out.created = append(out.created, bookmarkName)
+		out.bookmark, err = NewBookmark(bookmarkName)
+	}
+	if err == nil {
+		mappingName := SplitMappingName(fileName)
+		out.mapping, err = os.OpenFile(mappingName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileMode)
+		if err == nil {
+			out.created = append(out.created, mappingName)
+			out.writer = bufio.NewWriter(out.mapping)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			log.Errorf("Output file of %s already exists: %v", fileName, err)
+			err = ErrOutputFileExists
+		}
+		_ = out.close()
+		out.remove()
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// add writes an entry re-numbered to the output and its original entry number to the mapping
+func (o *splitOutput) add(e FileEntry) error {
+	original := e.Number
+	e.Number = o.nextEntry
+	err := o.streamFile.AddFileEntry(e)
+	if err != nil {
+		return err
+	}
+	o.nextEntry++
+
+	_, err = o.writer.Write(binary.BigEndian.AppendUint64(nil, original))
+	return err
+}
+
+// close commits the entries written to the output and closes its files
+func (o *splitOutput) close() error {
+	// Closing the stream file writes the header
+	err := o.streamFile.Close()
+	if o.streamFile.fileHeader != nil {
+		err = errors.Join(err, o.streamFile.fileHeader.Close())
+	}
+	if o.bookmark != nil {
+		err = errors.Join(err, o.bookmark.Close())
+	}
+	if o.mapping != nil {
+		err = errors.Join(err, o.writer.Flush(), o.mapping.Close())
+	}
+	return err
+}
+
+// remove deletes the files created for the output
+func (o *splitOutput) remove() {
+	for _, name := range o.created {
+		err := os.RemoveAll(name)
+		if err != nil {
+			log.Errorf("Error removing split output %s: %v", name, err)
+		}
+	}
+}
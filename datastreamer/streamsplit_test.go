@@ -0,0 +1,97 @@
+package datastreamer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitByType(t *testing.T) {
+	dir := t.TempDir()
+	srcFile := filepath.Join(dir, "mixed.bin")
+
+	// Mixed stream of bookmarks every 10 entries and entry types 1, 2 and 3
+	sf, err := NewStreamFileWithOptions(srcFile, 1, 12345, 1, //nolint:mnd
+		StreamFileOptions{MetadataCodec: BinaryMetadataCodec{}})
+	require.NoError(t, err)
+	want := make(map[EntryType][]uint64)
+	for i := range uint64(100) {
+		e := FileEntry{packetType: PtData, Type: EntryType(i%3 + 1), Number: i, Data: []byte{byte(i)}} //nolint:mnd
+		if i%10 == 0 {
+			e.Type = EtBookmark
+		}
+		e.Length = uint32(FixedSizeFileEntry + len(e.Data))
+		require.NoError(t, sf.AddFileEntry(e))
+		want[e.Type] = append(want[e.Type], i)
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+	require.NoError(t, sf.Close())
+
+	outDir := filepath.Join(dir, "split")
+	fileNames, err := SplitByType(srcFile, outDir)
+	require.NoError(t, err)
+	require.Len(t, fileNames, len(want))
+
+	for etype, originals := range want {
+		fileName := fileNames[etype]
+		require.NotEmpty(t, fileName, "entry type %d", etype)
+
+		// Independent stream re-numbered from zero with the source settings
+		out, err := NewStreamFile(fileName, 1, 12345, 1) //nolint:mnd
+		require.NoError(t, err)
+		assert.Equal(t, MetadataCodecBinary, out.MetadataCodec().ID())
+		header := out.getHeaderEntry()
+		assert.Equal(t, uint64(len(originals)), header.TotalEntries)
+		var next uint64
+		for entry, err := range out.Entries(0, header.TotalEntries) {
+			require.NoError(t, err)
+			assert.Equal(t, next, entry.Number)
+			assert.Equal(t, etype, entry.Type)
+			assert.Equal(t, []byte{byte(originals[next])}, entry.Data)
+			next++
+		}
+		assert.Equal(t, header.TotalEntries, next)
+		require.NoError(t, out.Close())
+
+		// Mapping from the new entry numbers to the original ones
+		mapping, err := ReadSplitMapping(SplitMappingName(fileName))
+		require.NoError(t, err)
+		assert.Equal(t, originals, mapping)
+
+		// Each bookmark points to the first entry of the output after it
+		bookmark, err := NewBookmark(bookmarkDBName(fileName))
+		require.NoError(t, err)
+		for _, bm := range want[EtBookmark] {
+			var entryNum uint64
+			for entryNum < uint64(len(originals)) && originals[entryNum] < bm {
+				entryNum++
+			}
+			got, err := bookmark.GetBookmark([]byte{byte(bm)})
+			require.NoError(t, err)
+			assert.Equal(t, entryNum, got, "entry type %d bookmark %d", etype, bm)
+		}
+		require.NoError(t, bookmark.Close())
+	}
+
+	// Outputs are not overwritten
+	_, err = SplitByType(srcFile, outDir)
+	assert.ErrorIs(t, err, ErrOutputFileExists)
+	for _, fileName := range fileNames {
+		assert.FileExists(t, fileName)
+	}
+
+	// Partial outputs are removed on failure
+	outDir = filepath.Join(dir, "partial")
+	require.NoError(t, os.MkdirAll(outDir, os.ModePerm))
+	existing := SplitMappingName(filepath.Join(outDir, "mixed_type3.bin"))
+	require.NoError(t, os.WriteFile(existing, nil, 0600)) //nolint:mnd
+	_, err = SplitByType(srcFile, outDir)
+	assert.ErrorIs(t, err, ErrOutputFileExists)
+	files, err := os.ReadDir(outDir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, filepath.Base(existing), files[0].Name())
+}
@@ -0,0 +1,47 @@
+package datastreamer
+
+// StreamIterator is the method set of the iterators over the committed entries of a stream: Iterator,
+// MemoryIterator and S3Iterator
+type StreamIterator interface {
+	// Next reads the next committed entry, returns true at the end of the entries
+	Next() (bool, error)
+	// GetEntry returns the entry read by the latest call to Next
+	GetEntry() FileEntry
+	// End finalizes the iterator
+	End()
+}
+
+// StreamReader is the method set to read the committed entries of a stream, shared by the server, MemoryStreamStore
+// and S3StreamStore. GetBookmark returns leveldb.ErrNotFound if the bookmark doesn't exist.
+type StreamReader interface {
+	GetHeader() HeaderEntry
+	GetEntry(entryNum uint64) (FileEntry, error)
+	GetBookmark(bookmark []byte) (uint64, error)
+}
+
+// StreamWriter is the method set to add entries to a stream in atomic operations, shared by the server and
+// MemoryStreamStore
+type StreamWriter interface {
+	StartAtomicOp() error
+	AddStreamEntry(etype EntryType, data []byte) (uint64, error)
+	AddStreamBookmark(bookmark []byte) (uint64, error)
+	CommitAtomicOp() error
+	RollbackAtomicOp() error
+}
+
+// StreamStore is the method set of a stream store, the server or MemoryStreamStore, with the type of its iterators.
+// Code working with any store can be written as a generic function over the iterator type, e.g.
+// func copyStream[I StreamIterator](src StreamStore[I], ...)
+type StreamStore[I StreamIterator] interface {
+	StreamReader
+	StreamWriter
+	GetIterator(fromEntry uint64, opts IteratorOptions) (I, error)
+}
+
+// Stores implementing the interfaces
+var (
+	_ StreamStore[*Iterator]       = (*StreamServer)(nil)
+	_ StreamStore[*MemoryIterator] = (*MemoryStreamStore)(nil)
+	_ StreamReader                 = (*S3StreamStore)(nil)
+	_ StreamIterator               = (*S3Iterator)(nil)
+)
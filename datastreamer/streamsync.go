@@ -0,0 +1,355 @@
+package datastreamer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// CommitSyncMode type for how the committed atomic operations are flushed to disk
+type CommitSyncMode uint8
+
+// The commits not flushed yet are in the OS page cache: they survive a crash of the process but not of the OS or a
+// power loss, that may lose them (the stream file rolls back to an older header) or leave a header ahead of its entries.
+const (
+	CommitSyncNone     CommitSyncMode = iota // CommitSyncNone leaves the flush to the OS (default)
+	CommitSyncEach                           // CommitSyncEach flushes the file on each commit
+	CommitSyncGroup                          // CommitSyncGroup flushes the commits done within a window together
+	CommitSyncAdaptive                       // CommitSyncAdaptive flushes each commit, grouping them under write pressure
+	CommitSyncInterval                       // CommitSyncInterval flushes the commits periodically, without waiting
+
+	defaultSyncRateThreshold = 100                    // Commits per second switching the adaptive sync to group
+	syncRatePeriod           = 100 * time.Millisecond // Period measuring the commit rate of the adaptive sync
+)
+
+// SyncLagInfo type for the durability lag of the commits (committed but not flushed to disk yet)
+type SyncLagInfo struct {
+	Current  time.Duration // Age of the oldest commit not flushed yet
+	Max      time.Duration // Highest age of a commit when flushed since the server started
+	Grouping bool          // Commits flushed in groups (always with group commit, under pressure with adaptive)
+}
+
+// groupSync type to coalesce the flush of the commits done within a window (group commit)
+type groupSync struct {
+	window  time.Duration
+	pending []syncRequest
+	closed  bool
+	notify  chan struct{} // Signals the first pending request of a group
+	slot    chan struct{} // Held by the atomic operation in progress
+	mutex   sync.Mutex
+
+	adaptive      bool          // Group only while the commit rate is over the threshold, the window is the max lag
+	threshold     float64       // Commits per second starting to group the flushes (adaptive)
+	grouping      bool          // Grouping the flushes
+	periodStart   time.Time     // Start of the period measuring the commit rate
+	periodCommits uint64        // Commits done within the period
+	lastSync      time.Duration // Duration of the latest flush
+	flushing      time.Time     // Commit time of the oldest commit being flushed (zero: none)
+	maxLag        time.Duration // Highest lag of a flushed commit
+}
+
+// syncRequest type for a committed atomic operation waiting for the group flush
+type syncRequest struct {
+	atomicOp  streamAO
+	result    chan error
+	committed time.Time
+}
+
+// newGroupSync creates a group commit with a window
+func newGroupSync(window time.Duration) *groupSync {
+	return &groupSync{
+		window:   window,
+		notify:   make(chan struct{}, 1),
+		slot:     make(chan struct{}, 1),
+		grouping: true,
+	}
+}
+
+// newAdaptiveSync creates a group commit used only while the commit rate is over a threshold, bounding the lag
+func newAdaptiveSync(maxLag time.Duration, threshold float64) *groupSync {
+	g := newGroupSync(maxLag)
+	g.adaptive = true
+	g.threshold = threshold
+	g.grouping = false
+	g.periodStart = time.Now()
+	return g
+}
+
+// SetCommitSync sets how the committed atomic operations are flushed to disk, it must be set before Start.
+// With CommitSyncGroup the commits done within the window are flushed together and each CommitAtomicOp returns
+// once its group is flushed. The atomic operation is released before waiting, so atomic operations can be run from
+// several goroutines: StartAtomicOp waits for the one in progress to end instead of failing.
+// With CommitSyncInterval the file is flushed every window if there are new commits, CommitAtomicOp doesn't wait for
+// it and the commits are streamed right away: a crash of the OS may lose the commits of the latest window.
+func (s *StreamServer) SetCommitSync(mode CommitSyncMode, window time.Duration) {
+	s.commitSync = mode
+	s.groupSync = nil
+	s.syncInterval = 0
+	switch mode {
+	case CommitSyncGroup:
+		s.groupSync = newGroupSync(window)
+	case CommitSyncAdaptive:
+		s.groupSync = newAdaptiveSync(window, defaultSyncRateThreshold)
+	case CommitSyncInterval:
+		s.syncInterval = window
+	}
+}
+
+// SetAdaptiveCommitSync sets the adaptive commit sync, it must be set before Start. Each commit is flushed on its own
+// while the commit rate is low. When it goes over the threshold (commits per second) the flushes are grouped as with
+// CommitSyncGroup, until the rate drops below half the threshold. A commit is flushed at most maxLag after it's done
+// (plus the scheduling delays), see GetSyncLag.
+func (s *StreamServer) SetAdaptiveCommitSync(threshold uint64, maxLag time.Duration) {
+	s.commitSync = CommitSyncAdaptive
+	s.groupSync = newAdaptiveSync(maxLag, float64(threshold))
+}
+
+// GetSyncLag returns the durability lag of the commits, only measured with group, adaptive or interval commit sync
+// (just the current lag)
+func (s *StreamServer) GetSyncLag() SyncLagInfo {
+	if s.commitSync == CommitSyncInterval {
+		var info SyncLagInfo
+		if committed := s.unsynced.Load(); committed != 0 {
+			info.Current = time.Since(time.Unix(0, committed))
+		}
+		return info
+	}
+	if s.groupSync == nil {
+		return SyncLagInfo{}
+	}
+	return s.groupSync.lagInfo()
+}
+
+// syncCommit flushes a committed atomic operation to disk (if enabled), streams it to the clients and ends it,
+// unlocking the atomic operations before waiting for a group flush. A flush error is returned once streamed, the
+// entries are committed in the file anyway.
+func (s *StreamServer) syncCommit(atomicOp streamAO, unlock func()) error {
+	var err error
+	switch s.commitSync {
+	case CommitSyncEach:
+		err = s.streamFile.sync()
+	case CommitSyncInterval:
+		// Flushed by the interval goroutine
+		s.unsynced.CompareAndSwap(0, time.Now().UnixNano())
+	case CommitSyncGroup, CommitSyncAdaptive:
+		// Queued before ending the atomic operation to keep the commits order
+		result := make(chan error, 1)
+		err = s.groupSync.add(syncRequest{atomicOp: atomicOp, result: result, committed: time.Now()})
+		s.clearAtomicOp()
+		unlock()
+		if err != nil {
+			return err
+		}
+		return <-result
+	}
+
+	s.broadcast(atomicOp)
+	s.clearAtomicOp()
+	return err
+}
+
+// broadcast sends a committed atomic operation to the broadcast goroutines
+func (s *StreamServer) broadcast(atomicOp streamAO) {
+	// Pending until sent by both broadcast goroutines
+	s.inBroadcast.Add(2) //nolint:mnd
+	select {
+	case s.lowLatency <- atomicOp:
+	case <-s.done:
+		log.Warnf("Server closed, atomic operation from entry %d not broadcast", atomicOp.startEntry)
+		s.inBroadcast.Add(-2) //nolint:mnd
+		return
+	}
+	select {
+	case s.stream <- atomicOp:
+	case <-s.done:
+		log.Warnf("Server closed, atomic operation from entry %d not broadcast", atomicOp.startEntry)
+		s.inBroadcast.Add(-1)
+	}
+}
+
+// runGroupSync flushes the groups of commits and streams them to the clients once flushed
+func (s *StreamServer) runGroupSync() {
+	defer s.wg.Done()
+
+	for {
+		// Wait for the first commit of the group
+		select {
+		case <-s.groupSync.notify:
+		case <-s.done:
+			s.flushGroup(s.groupSync.take(true))
+			return
+		}
+
+		// Gather the commits done within the window
+		window := s.groupSync.currentWindow()
+		if window > 0 {
+			timer := time.NewTimer(window)
+			select {
+			case <-timer.C:
+			case <-s.done:
+				timer.Stop()
+			}
+		}
+		s.flushGroup(s.groupSync.take(false))
+	}
+}
+
+// runIntervalSync flushes the file periodically when there are commits not flushed yet
+func (s *StreamServer) runIntervalSync() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		// Commits done while flushing are left for the next one
+		if s.unsynced.Swap(0) == 0 {
+			continue
+		}
+		err := s.streamFile.sync()
+		if err != nil {
+			log.Errorf("Error flushing the commits of the interval: %v", err)
+		}
+	}
+}
+
+// flushGroup flushes the file once for a group of commits, streams them and releases their callers
+func (s *StreamServer) flushGroup(group []syncRequest) {
+	if len(group) == 0 {
+		return
+	}
+
+	start := time.Now()
+	err := s.streamFile.sync()
+	s.groupSync.flushed(time.Since(start), time.Since(group[0].committed))
+	for _, req := range group {
+		s.broadcast(req.atomicOp)
+		req.result <- err
+	}
+	log.Debugf("Group commit flushed %d atomic operations", len(group))
+}
+
+// add queues a committed atomic operation to be flushed with its group
+func (g *groupSync) add(req syncRequest) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.closed {
+		log.Errorf("Commit not flushed, server closed")
+		return ErrServerClosed
+	}
+	g.pending = append(g.pending, req)
+	if g.adaptive {
+		g.updatePressure(req.committed)
+	}
+	if len(g.pending) == 1 {
+		select {
+		case g.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// take returns the pending commits of the group, closing it to new ones if requested
+func (g *groupSync) take(closeGroup bool) []syncRequest {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	group := g.pending
+	g.pending = nil
+	if len(group) > 0 {
+		g.flushing = group[0].committed
+	}
+	if closeGroup {
+		g.closed = true
+		// No group is waiting anymore
+		select {
+		case <-g.notify:
+		default:
+		}
+	}
+	return group
+}
+
+// acquire waits for the atomic operation in progress to end
+func (g *groupSync) acquire() {
+	g.slot <- struct{}{}
+}
+
+// release ends the atomic operation in progress
+func (g *groupSync) release() {
+	select {
+	case <-g.slot:
+	default:
+	}
+}
+
+// updatePressure measures the commit rate and switches the adaptive sync grouping
+func (g *groupSync) updatePressure(now time.Time) {
+	g.periodCommits++
+	elapsed := now.Sub(g.periodStart)
+	if elapsed < syncRatePeriod {
+		return
+	}
+
+	rate := float64(g.periodCommits) / elapsed.Seconds()
+	switch {
+	case !g.grouping && rate > g.threshold:
+		g.grouping = true
+		log.Infof("Commit rate %.0f/s over %.0f/s, grouping the flushes", rate, g.threshold)
+	case g.grouping && rate < g.threshold/2: //nolint:mnd
+		g.grouping = false
+		log.Infof("Commit rate %.0f/s, flushing each commit", rate)
+	}
+	g.periodStart = now
+	g.periodCommits = 0
+}
+
+// currentWindow returns how long to gather the commits of a group before flushing them
+func (g *groupSync) currentWindow() time.Duration {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if !g.adaptive {
+		return g.window
+	}
+	if !g.grouping {
+		return 0
+	}
+
+	// The flush time counts for the max lag
+	return max(g.window-g.lastSync, 0)
+}
+
+// flushed records the duration of a flush and the lag of the oldest commit flushed
+func (g *groupSync) flushed(syncTime, lag time.Duration) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.lastSync = syncTime
+	g.maxLag = max(g.maxLag, lag)
+	g.flushing = time.Time{}
+}
+
+// lagInfo returns the durability lag of the commits
+func (g *groupSync) lagInfo() SyncLagInfo {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	info := SyncLagInfo{Max: g.maxLag, Grouping: g.grouping}
+	oldest := g.flushing
+	if oldest.IsZero() && len(g.pending) > 0 {
+		oldest = g.pending[0].committed
+	}
+	if !oldest.IsZero() {
+		info.Current = time.Since(oldest)
+	}
+	return info
+}
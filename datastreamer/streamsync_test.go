@@ -0,0 +1,244 @@
+package datastreamer
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupCommit(t *testing.T) {
+	dir := t.TempDir()
+	s := newConfiguredTestServer(t, dir, func(s *StreamServer) {
+		s.SetCommitSync(CommitSyncGroup, 5*time.Millisecond)
+	})
+
+	conn, err := net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, uint32(CmdErrOK), sendStartCommand(t, conn, 0))
+
+	// Atomic operations from several goroutines, each returning once flushed
+	const goroutines, commits = 8, 20
+	fileName := filepath.Join(dir, "stream.bin")
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range commits {
+				assert.NoError(t, s.StartAtomicOp())
+				num, err := s.AddStreamEntry(1, []byte{byte(g)})
+				assert.NoError(t, err)
+				assert.NoError(t, s.CommitAtomicOp())
+
+				// The commit is in the header on disk when CommitAtomicOp returns
+				f, err := os.Open(fileName)
+				assert.NoError(t, err)
+				header, err := readFileHeader(f)
+				assert.NoError(t, err)
+				assert.Greater(t, header.TotalEntries, num)
+				f.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The clients receive all the entries in order
+	numbers := readStreamEntryNumbers(t, conn, goroutines*commits)
+	assert.True(t, sort.SliceIsSorted(numbers, func(i, j int) bool { return numbers[i] < numbers[j] }))
+	assert.Equal(t, uint64(goroutines*commits-1), numbers[len(numbers)-1])
+
+	// Rollbacks release the atomic operation too
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.RollbackAtomicOp())
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.CommitAtomicOp())
+
+	// The file is consistent after closing
+	require.NoError(t, s.Close())
+	report, err := NewConsistencyChecker(fileName).Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+	assert.Equal(t, uint64(goroutines*commits), report.Entries)
+}
+
+func TestCommitSyncEach(t *testing.T) {
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.SetCommitSync(CommitSyncEach, 0)
+	})
+
+	require.NoError(t, s.StartAtomicOp())
+	_, err := s.AddStreamEntry(1, []byte{1})
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Equal(t, uint64(1), s.GetHeader().TotalEntries)
+
+	// One atomic operation at a time without group commit
+	require.NoError(t, s.StartAtomicOp())
+	assert.ErrorIs(t, s.StartAtomicOp(), ErrStartAtomicOpNotAllowed)
+}
+
+func TestAdaptiveCommitSync(t *testing.T) {
+	const maxLag = 50 * time.Millisecond
+	s, err := NewServer(0, 1, 12345, 1, filepath.Join(t.TempDir(), "stream.bin"), time.Second, time.Minute,
+		time.Minute, nil)
+	require.NoError(t, err)
+	s.SetAdaptiveCommitSync(200, maxLag) //nolint:mnd
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	commit := func() {
+		assert.NoError(t, s.StartAtomicOp())
+		_, err := s.AddStreamEntry(1, []byte{1})
+		assert.NoError(t, err)
+		assert.NoError(t, s.CommitAtomicOp())
+	}
+
+	// Quiet: each commit flushed on its own
+	for range 3 {
+		commit()
+		time.Sleep(20 * time.Millisecond)
+	}
+	lag := s.GetSyncLag()
+	assert.False(t, lag.Grouping)
+	assert.Zero(t, lag.Current)
+
+	// Burst: the flushes are grouped keeping the lag bound
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				commit()
+			}
+		}()
+	}
+	wg.Wait()
+	lag = s.GetSyncLag()
+	assert.True(t, lag.Grouping)
+	assert.Less(t, lag.Max, maxLag+25*time.Millisecond)
+
+	// Quiet again: back to a flush per commit
+	for range 3 {
+		time.Sleep(2 * syncRatePeriod)
+		commit()
+	}
+	lag = s.GetSyncLag()
+	assert.False(t, lag.Grouping)
+	assert.Zero(t, lag.Current)
+	assert.Less(t, lag.Max, maxLag+25*time.Millisecond)
+}
+
+func BenchmarkCommit(b *testing.B) {
+	modes := []struct {
+		name   string
+		mode   CommitSyncMode
+		window time.Duration
+	}{
+		{"sync-each", CommitSyncEach, 0},
+		{"group-1ms", CommitSyncGroup, time.Millisecond},
+	}
+	data := make([]byte, 100) //nolint:mnd
+
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			s := newConfiguredTestServer(b, b.TempDir(), func(s *StreamServer) {
+				s.SetCommitSync(m.mode, m.window)
+			})
+
+			// Without group commit the atomic operations are serialized by the caller
+			var mutex sync.Mutex
+			b.SetParallelism(8) //nolint:mnd
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if m.mode != CommitSyncGroup {
+						mutex.Lock()
+					}
+					_ = s.StartAtomicOp()
+					_, _ = s.AddStreamEntry(1, data)
+					_ = s.CommitAtomicOp()
+					if m.mode != CommitSyncGroup {
+						mutex.Unlock()
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "commits/s")
+		})
+	}
+}
+
+func TestCommitSyncInterval(t *testing.T) {
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.SetCommitSync(CommitSyncInterval, 50*time.Millisecond) //nolint:mnd
+	})
+
+	// Committed right away, flushed by the next interval
+	require.NoError(t, s.StartAtomicOp())
+	_, err := s.AddStreamEntry(1, []byte{1})
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Equal(t, uint64(1), s.GetHeader().TotalEntries)
+	require.Eventually(t, func() bool { return s.GetSyncLag().Current == 0 }, time.Second, 5*time.Millisecond) //nolint:mnd
+}
+
+func TestCommitSyncReopen(t *testing.T) {
+	for _, mode := range []CommitSyncMode{CommitSyncEach, CommitSyncInterval} {
+		dir := t.TempDir()
+		s := newConfiguredTestServer(t, dir, func(s *StreamServer) {
+			s.SetCommitSync(mode, 10*time.Millisecond) //nolint:mnd
+		})
+		require.NoError(t, s.StartAtomicOp())
+		_, err := s.AddStreamEntry(1, []byte("committed"))
+		require.NoError(t, err)
+		require.NoError(t, s.CommitAtomicOp())
+		require.NoError(t, s.Close())
+
+		// The committed entry is in the file opened again
+		s = newConfiguredTestServer(t, dir, func(s *StreamServer) {
+			s.SetCommitSync(mode, 10*time.Millisecond) //nolint:mnd
+		})
+		e, err := s.GetEntry(0)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("committed"), e.Data, "mode %d", mode)
+	}
+}
+
+func TestCommitSyncFileOption(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	s, err := openTestServer(t, fileName, StreamFileOptions{CommitSync: CommitSyncEach}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, CommitSyncEach, s.commitSync)
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, []byte("committed"))
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+
+	// The committed entry is in the file reopened without closing the server, as after a crash
+	sf, reports, err := OpenStreamFileTolerant(fileName)
+	require.NoError(t, err)
+	assert.Empty(t, reports)
+	e, err := sf.GetEntry(0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("committed"), e.Data)
+	require.NoError(t, sf.Close())
+
+	// And in the file opened again by a server
+	require.NoError(t, s.Close())
+	s, err = openTestServer(t, fileName, StreamFileOptions{CommitSync: CommitSyncInterval, CommitSyncWindow: time.Second},
+		nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, s.syncInterval)
+	e, err = s.GetEntry(0)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("committed"), e.Data)
+}
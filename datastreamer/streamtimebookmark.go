@@ -0,0 +1,137 @@
+package datastreamer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// TimeBookmarkPrefix is the first bytes of the keys of the time bookmarks, reserved: the other bookmarks must not
+// start with it. It's followed by the timestamp (unix nanoseconds) and the entry number, both in big endian, so the
+// time bookmarks are in time order in the bookmarks index.
+var TimeBookmarkPrefix = []byte{0xff, 't', 'i', 'm', 'e'}
+
+// TimeBookmark returns the key of the time bookmark of a timestamp added at an entry number
+func TimeBookmark(t time.Time, entryNum uint64) []byte {
+	key := timeBookmarkSeekKey(uint64(t.UnixNano()))
+	return binary.BigEndian.AppendUint64(key, entryNum)
+}
+
+// ParseTimeBookmark returns the timestamp and the entry number of a time bookmark key, ok is false if the key is not
+// a time bookmark
+func ParseTimeBookmark(key []byte) (time.Time, uint64, bool) {
+	if len(key) != len(TimeBookmarkPrefix)+16 || !bytes.HasPrefix(key, TimeBookmarkPrefix) { //nolint:mnd
+		return time.Time{}, 0, false
+	}
+	key = key[len(TimeBookmarkPrefix):]
+	ts := binary.BigEndian.Uint64(key[:8])
+	return time.Unix(0, int64(ts)), binary.BigEndian.Uint64(key[8:]), true //nolint:gosec
+}
+
+// timeBookmarkSeekKey returns the first key of the time bookmarks of a timestamp (unix nanoseconds)
+func timeBookmarkSeekKey(ts uint64) []byte {
+	key := make([]byte, 0, len(TimeBookmarkPrefix)+16) //nolint:mnd
+	key = append(key, TimeBookmarkPrefix...)
+	return binary.BigEndian.AppendUint64(key, ts)
+}
+
+// SetMonotonicTime enforces the time bookmarks are added in time order (disabled by default): adding one earlier
+// than the latest one fails with ErrTimeNotMonotonic. Equal timestamps are allowed.
+func (s *StreamServer) SetMonotonicTime(enforce bool) {
+	s.monotonicTime = enforce
+}
+
+// AddStreamTimeBookmark adds a new bookmark keyed by a timestamp in the current atomic operation, marking the first
+// entry at or after that time. GetIteratorFromTime assumes the timestamps don't go backwards along the stream: it
+// can be enforced with SetMonotonicTime. The key is TimeBookmark(t, entryNum), so the time bookmarks of the same
+// timestamp don't replace each other.
+func (s *StreamServer) AddStreamTimeBookmark(t time.Time) (uint64, error) {
+	start := time.Now().UnixNano()
+	defer log.Debugf("AddStreamTimeBookmark process time: %vns", time.Now().UnixNano()-start)
+
+	if t.Before(time.Unix(0, 0)) {
+		log.Errorf("Invalid timestamp %v for time bookmark, before the unix epoch", t)
+		return 0, ErrInvalidTimestamp
+	}
+
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// Entry numbers assigned by the server
+	err = s.setNumbering(numberingAuto)
+	if err != nil {
+		return 0, err
+	}
+
+	// Check there is no later time bookmark
+	if s.monotonicTime {
+		_, found, err := s.seekTimeBookmark(uint64(t.UnixNano()) + 1)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			log.Errorf("Time bookmark %v earlier than the latest one", t)
+			return 0, ErrTimeNotMonotonic
+		}
+	}
+
+	// Add to the stream file and to the bookmark index
+	entryNum := s.nextEntry
+	err = s.addStreamBookmark(TimeBookmark(t, entryNum))
+	if err != nil {
+		return 0, err
+	}
+
+	return entryNum, nil
+}
+
+// GetIteratorFromTime returns an iterator over the committed entries from the first time bookmark at or after a
+// time, searched in the bookmarks index ordered by time. It assumes the timestamps of the time bookmarks don't go
+// backwards along the stream (see SetMonotonicTime), and the entries before the first time bookmark are before it.
+// A time after the latest time bookmark starts at the tail, returning the entries committed later.
+func (s *StreamServer) GetIteratorFromTime(t time.Time) (*Iterator, error) {
+	ts := uint64(0)
+	if t.After(time.Unix(0, 0)) {
+		ts = uint64(t.UnixNano())
+	}
+
+	entryNum, found, err := s.seekTimeBookmark(ts)
+	if err != nil {
+		return nil, err
+	}
+	header := s.streamFile.getHeaderEntry()
+	if !found {
+		entryNum = header.TotalEntries
+	}
+	entryNum = max(entryNum, header.firstEntry)
+	log.Debugf("Time %v starts at entry %d", t, entryNum)
+
+	// The time bookmark of an atomic operation in progress waits for its commit
+	return s.GetIterator(entryNum, IteratorOptions{BeyondTail: BeyondTailWait})
+}
+
+// seekTimeBookmark returns the entry number of the first time bookmark (in time order) at or after a timestamp (unix
+// nanoseconds), found is false if there is none. The time bookmarks of the entries removed are skipped.
+func (s *StreamServer) seekTimeBookmark(ts uint64) (uint64, bool, error) {
+	key, after := timeBookmarkSeekKey(ts), false
+	for {
+		found, entryNum, ok, err := s.bookmark.seekBookmark(key, after)
+		if err != nil || !ok || !bytes.HasPrefix(found, TimeBookmarkPrefix) {
+			return 0, false, err
+		}
+		err = s.checkBookmarkTarget(found, entryNum)
+		if errors.Is(err, ErrBookmarkTargetPruned) {
+			key, after = found, true
+			continue
+		} else if err != nil {
+			return 0, false, err
+		}
+		return entryNum, true, nil
+	}
+}
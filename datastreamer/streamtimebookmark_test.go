@@ -0,0 +1,147 @@
+package datastreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addTestTimeBookmark adds and commits a time bookmark followed by an entry, returning the bookmark entry number
+func addTestTimeBookmark(t *testing.T, s *StreamServer, ts time.Time) uint64 {
+	t.Helper()
+
+	require.NoError(t, s.StartAtomicOp())
+	entryNum, err := s.AddStreamTimeBookmark(ts)
+	require.NoError(t, err)
+	_, err = s.AddStreamEntry(1, make([]byte, 100)) //nolint:mnd
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	return entryNum
+}
+
+// firstEntryFromTime returns the number of the first entry of the iterator from a time, end is true if there is none
+func firstEntryFromTime(t *testing.T, s *StreamServer, ts time.Time) (uint64, bool) {
+	t.Helper()
+
+	it, err := s.GetIteratorFromTime(ts)
+	require.NoError(t, err)
+	defer it.End()
+	end, err := it.Next()
+	require.NoError(t, err)
+	return it.GetEntry().Number, end
+}
+
+func TestGetIteratorFromTime(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	base := time.Unix(1700000000, 0)
+
+	// Entries without time bookmark, then bookmarks every minute
+	commitTestEntry(t, s)
+	var bookmarks []uint64
+	for i := range 3 {
+		bookmarks = append(bookmarks, addTestTimeBookmark(t, s, base.Add(time.Duration(i)*time.Minute)))
+	}
+
+	// Exact timestamps
+	for i, entryNum := range bookmarks {
+		first, end := firstEntryFromTime(t, s, base.Add(time.Duration(i)*time.Minute))
+		require.False(t, end)
+		assert.Equal(t, entryNum, first)
+	}
+
+	// Between timestamps, the next bookmark
+	first, end := firstEntryFromTime(t, s, base.Add(90*time.Second)) //nolint:mnd
+	require.False(t, end)
+	assert.Equal(t, bookmarks[2], first)
+	first, end = firstEntryFromTime(t, s, base.Add(time.Nanosecond))
+	require.False(t, end)
+	assert.Equal(t, bookmarks[1], first)
+
+	// Before the first one, the first bookmark
+	first, end = firstEntryFromTime(t, s, base.Add(-time.Hour))
+	require.False(t, end)
+	assert.Equal(t, bookmarks[0], first)
+	first, end = firstEntryFromTime(t, s, time.Time{})
+	require.False(t, end)
+	assert.Equal(t, bookmarks[0], first)
+
+	// After the last one, at the tail picking up the entries committed later
+	it, err := s.GetIteratorFromTime(base.Add(time.Hour))
+	require.NoError(t, err)
+	defer it.End()
+	end, err = it.Next()
+	require.NoError(t, err)
+	require.True(t, end)
+	entryNum := addTestTimeBookmark(t, s, base.Add(time.Hour))
+	end, err = it.Next()
+	require.NoError(t, err)
+	require.False(t, end)
+	assert.Equal(t, entryNum, it.GetEntry().Number)
+
+	// The time bookmarks keys
+	bookmark, found, err := s.GetBookmarkByEntry(bookmarks[1])
+	require.NoError(t, err)
+	require.True(t, found)
+	ts, num, ok := ParseTimeBookmark(bookmark)
+	require.True(t, ok)
+	assert.True(t, ts.Equal(base.Add(time.Minute)))
+	assert.Equal(t, bookmarks[1], num)
+	_, _, ok = ParseTimeBookmark([]byte("b1"))
+	assert.False(t, ok)
+}
+
+func TestGetIteratorFromTimeRollback(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	base := time.Unix(1700000000, 0)
+	entryNum := addTestTimeBookmark(t, s, base)
+
+	// Time bookmark rolled back, not found
+	require.NoError(t, s.StartAtomicOp())
+	_, err := s.AddStreamTimeBookmark(base.Add(time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, s.RollbackAtomicOp())
+	it, err := s.GetIteratorFromTime(base.Add(time.Second))
+	require.NoError(t, err)
+	defer it.End()
+	end, err := it.Next()
+	require.NoError(t, err)
+	assert.True(t, end)
+
+	first, end := firstEntryFromTime(t, s, base)
+	require.False(t, end)
+	assert.Equal(t, entryNum, first)
+}
+
+func TestMonotonicTime(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	base := time.Unix(1700000000, 0)
+
+	// Not enforced
+	addTestTimeBookmark(t, s, base.Add(time.Minute))
+	addTestTimeBookmark(t, s, base)
+
+	// Enforced, equal timestamps allowed
+	s.SetMonotonicTime(true)
+	require.NoError(t, s.StartAtomicOp())
+	_, err := s.AddStreamTimeBookmark(base)
+	require.ErrorIs(t, err, ErrTimeNotMonotonic)
+	first, err := s.AddStreamTimeBookmark(base.Add(time.Minute))
+	require.NoError(t, err)
+	second, err := s.AddStreamTimeBookmark(base.Add(time.Minute))
+	require.NoError(t, err)
+	_, err = s.AddStreamTimeBookmark(time.Unix(-1, 0))
+	require.ErrorIs(t, err, ErrInvalidTimestamp)
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Equal(t, first+1, second)
+
+	// Same timestamp, the lowest entry number
+	it, err := s.GetIteratorFromTime(base.Add(time.Second))
+	require.NoError(t, err)
+	defer it.End()
+	end, err := it.Next()
+	require.NoError(t, err)
+	require.False(t, end)
+	assert.Equal(t, uint64(0), it.GetEntry().Number)
+}
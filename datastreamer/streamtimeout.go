@@ -0,0 +1,75 @@
+package datastreamer
+
+import (
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// SetAtomicOpTimeout sets the max time of an atomic operation from StartAtomicOp, disabled by default (0). An atomic
+// operation neither committed nor rolled back within it is rolled back, so a stuck writer doesn't block the writes
+// and truncations of the stream, and the next operation of the writer (adding entries, commit, rollback, savepoints)
+// fails with ErrAtomicOpTimedOut. StartAtomicOp starts a new atomic operation as usual. Set it before Start.
+func (s *StreamServer) SetAtomicOpTimeout(d time.Duration) {
+	s.atomicOpTimeout = max(d, 0)
+}
+
+// lockAtomicOp locks the atomic operation in progress against its rollback on timeout, returning the unlock
+// function. It fails with ErrAtomicOpTimedOut, once, if the atomic operation was rolled back on timeout.
+func (s *StreamServer) lockAtomicOp() (func(), error) {
+	s.mutexAtomicOp.Lock()
+	if s.atomicOpTimedOut {
+		s.atomicOpTimedOut = false
+		s.mutexAtomicOp.Unlock()
+		log.Errorf("Atomic operation rolled back after the timeout of %v", s.atomicOpTimeout)
+		return nil, ErrAtomicOpTimedOut
+	}
+	return s.mutexAtomicOp.Unlock, nil
+}
+
+// startAtomicOpTimer starts the timeout of the atomic operation just started
+func (s *StreamServer) startAtomicOpTimer() {
+	if s.atomicOpTimeout == 0 {
+		return
+	}
+	s.atomicOpSeq++
+	seq := s.atomicOpSeq
+	s.atomicOpTimer = time.AfterFunc(s.atomicOpTimeout, func() { s.expireAtomicOp(seq) })
+}
+
+// stopAtomicOpTimer stops the timeout of the atomic operation ended
+func (s *StreamServer) stopAtomicOpTimer() {
+	if s.atomicOpTimer != nil {
+		s.atomicOpTimer.Stop()
+		s.atomicOpTimer = nil
+	}
+}
+
+// expireAtomicOp rolls back the atomic operation started with a sequence number if it's still in progress
+func (s *StreamServer) expireAtomicOp(seq uint64) {
+	s.mutexAtomicOp.Lock()
+	select {
+	case <-s.done:
+		s.mutexAtomicOp.Unlock()
+		return
+	default:
+	}
+	if seq != s.atomicOpSeq || s.atomicOp.status != aoStarted {
+		s.mutexAtomicOp.Unlock()
+		return
+	}
+
+	log.Warnf("Atomic operation from entry %d not ended after %v, rolling back", s.atomicOp.startEntry,
+		s.atomicOpTimeout)
+	discarded, err := s.rollbackAtomicOp()
+	if err != nil {
+		log.Errorf("Error rolling back atomic operation on timeout: %v", err)
+	}
+	s.atomicOpTimedOut = true
+	s.mutexAtomicOp.Unlock()
+
+	// Notify the discarded entries
+	if err == nil && s.onRollback != nil {
+		s.onRollback(discarded)
+	}
+}
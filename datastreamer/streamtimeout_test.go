@@ -0,0 +1,69 @@
+package datastreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtomicOpTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	rolledBack := make(chan []FileEntry, 1)
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.SetAtomicOpTimeout(timeout)
+		s.SetOnRollback(func(discarded []FileEntry) { rolledBack <- discarded })
+	})
+	commitTestEntry(t, s)
+
+	waitRollback := func() []FileEntry {
+		select {
+		case discarded := <-rolledBack:
+			return discarded
+		case <-time.After(5 * time.Second): //nolint:mnd
+			t.Fatal("atomic operation not rolled back on timeout")
+			return nil
+		}
+	}
+
+	// Stuck writer rolled back
+	require.NoError(t, s.StartAtomicOp())
+	_, err := s.AddStreamEntry(1, []byte{1})
+	require.NoError(t, err)
+	_, err = s.AddStreamBookmark([]byte("b1"))
+	require.NoError(t, err)
+	discarded := waitRollback()
+	require.Len(t, discarded, 2) //nolint:mnd
+	assert.Equal(t, uint64(1), discarded[0].Number)
+	assert.Equal(t, uint64(1), s.streamFile.header.TotalEntries)
+	_, err = s.GetBookmark([]byte("b1"))
+	require.ErrorIs(t, err, ErrStoreKeyNotFound)
+
+	// Reported once to the writer
+	_, err = s.AddStreamEntry(1, []byte{2}) //nolint:mnd
+	require.ErrorIs(t, err, ErrAtomicOpTimedOut)
+	_, err = s.AddStreamEntry(1, []byte{2}) //nolint:mnd
+	require.ErrorIs(t, err, ErrAddEntryNotAllowed)
+
+	// Clean atomic operation after it, not rolled back once committed
+	require.NoError(t, s.StartAtomicOp())
+	entryNum, err := s.AddStreamEntry(1, []byte{3}) //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), entryNum)
+	require.NoError(t, s.CommitAtomicOp())
+	time.Sleep(2 * timeout)
+	assert.Empty(t, rolledBack)
+	entry, err := s.GetEntry(1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{3}, entry.Data)
+
+	// Truncate not blocked by a stuck writer, the late commit fails
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, []byte{4}) //nolint:mnd
+	require.NoError(t, err)
+	waitRollback()
+	require.NoError(t, s.TruncateFile(1))
+	require.ErrorIs(t, s.CommitAtomicOp(), ErrAtomicOpTimedOut)
+	assert.Equal(t, uint64(1), s.GetHeader().TotalEntries)
+}
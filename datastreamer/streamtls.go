@@ -0,0 +1,41 @@
+package datastreamer
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// NewServerWithTLS creates a new data stream server accepting the client connections over TLS with the given
+// configuration (certificates, client authentication...). The commands and the streaming are the same as over TCP.
+func NewServerWithTLS(port uint16, version uint8, systemID uint64, streamType StreamType, fileName string,
+	writeTimeout time.Duration, inactivityTimeout time.Duration, inactivityCheckInterval time.Duration,
+	cfg *log.Config, tlsConfig *tls.Config) (*StreamServer, error) {
+	if tlsConfig == nil {
+		log.Error("TLS server without TLS configuration")
+		return nil, ErrTLSConfigMissing
+	}
+
+	s, err := NewServer(port, version, systemID, streamType, fileName, writeTimeout, inactivityTimeout,
+		inactivityCheckInterval, cfg)
+	if err != nil {
+		return s, err
+	}
+	s.tlsConfig = tlsConfig
+	return s, nil
+}
+
+// SetTLSConfig sets the TLS configuration to connect to the server over TLS (nil: plain TCP), before Start
+func (c *StreamClient) SetTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
+// dial connects to the server, over TLS if configured
+func (c *StreamClient) dial() (net.Conn, error) {
+	if c.tlsConfig != nil {
+		return tls.Dial("tcp", c.server, c.tlsConfig)
+	}
+	return net.Dial("tcp", c.server)
+}
@@ -0,0 +1,87 @@
+package datastreamer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSignedCert returns a self-signed certificate for localhost
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)}, //nolint:mnd
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSStreaming(t *testing.T) {
+	cert := selfSignedCert(t)
+	_, err := NewServerWithTLS(0, 1, 12345, 1, filepath.Join(t.TempDir(), "stream.bin"), time.Second, //nolint:mnd
+		time.Minute, time.Minute, nil, nil)
+	require.ErrorIs(t, err, ErrTLSConfigMissing)
+
+	s, err := NewServerWithTLS(0, 1, 12345, 1, filepath.Join(t.TempDir(), "stream.bin"), time.Second, //nolint:mnd
+		time.Minute, time.Minute, nil, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { _ = s.Close() })
+	commitTestEntry(t, s)
+	commitTestEntry(t, s)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	_, port, err := net.SplitHostPort(testServerAddr(s))
+	require.NoError(t, err)
+
+	c, err := NewClient(net.JoinHostPort("localhost", port), 1)
+	require.NoError(t, err)
+	c.SetTLSConfig(&tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12})
+	received := make(chan uint64, 10) //nolint:mnd
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- e.Number
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+
+	// Commands and streaming over the encrypted connection
+	header, err := c.ExecCommandGetHeader()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), header.TotalEntries) //nolint:mnd
+	require.NoError(t, c.ExecCommandStart(0))
+	commitTestEntry(t, s)
+	for num := range uint64(3) {
+		select {
+		case n := <-received:
+			assert.Equal(t, num, n)
+		case <-time.After(time.Second):
+			t.Fatalf("entry %d not received", num)
+		}
+	}
+	require.NoError(t, c.ExecCommandStop())
+}
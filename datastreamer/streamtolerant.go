@@ -0,0 +1,122 @@
+package datastreamer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// CorruptionReport type for a problem found opening a stream file tolerantly, and what was left out because of it
+type CorruptionReport struct {
+	Offset uint64 // File offset where the problem was found
+	Entry  uint64 // Entry number expected at the offset
+	Reason string
+}
+
+// String returns the corruption report in a human readable format
+func (r CorruptionReport) String() string {
+	return fmt.Sprintf("offset %d (entry %d): %s", r.Offset, r.Entry, r.Reason)
+}
+
+// OpenStreamFileTolerant opens a stream file that may be corrupted or truncated, reading as many valid entries as
+// possible and stopping at the first unrecoverable point. Returns a read-only stream file covering the valid prefix
+// of entries and the report of what was skipped or truncated (empty if the file is healthy). Fails only if the
+// header can't be read. The file on disk is not modified.
+func OpenStreamFileTolerant(fileName string) (*StreamFile, []CorruptionReport, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		log.Errorf("Error opening file %s: %v", fileName, err)
+		return nil, nil, err
+	}
+
+	sf, reports, err := openTolerant(file, fileName)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	for _, r := range reports {
+		log.Warnf("File %s corrupted at %s", fileName, r)
+	}
+	log.Infof("File %s opened read-only with %d valid entries", fileName, sf.header.TotalEntries-sf.header.firstEntry)
+	return sf, reports, nil
+}
+
+// openTolerant scans the entries of an open file and builds the read-only stream file of the valid prefix
+func openTolerant(file *os.File, fileName string) (*StreamFile, []CorruptionReport, error) {
+	info, err := file.Stat()
+	if err != nil {
+		log.Errorf("Error getting info of file %s: %v", fileName, err)
+		return nil, nil, err
+	}
+	fileSize := uint64(info.Size())
+
+	// Without the header the entries can't be located
+	header, err := readFileHeader(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.encryption != encryptionNone {
+		log.Errorf("File %s to open tolerantly is encrypted", fileName)
+		return nil, nil, ErrFileEncrypted
+	}
+	if header.TotalLength < PageHeaderSize || header.firstEntry > header.TotalEntries {
+		log.Errorf("Invalid header of file %s: total length %d, first entry %d, total entries %d", fileName,
+			header.TotalLength, header.firstEntry, header.TotalEntries)
+		return nil, nil, ErrBadFileFormat
+	}
+
+	// Scan the committed entries present in the file while the numbers are contiguous
+	var reports []CorruptionReport
+	length := header.TotalLength
+	if length > fileSize {
+		reports = append(reports, CorruptionReport{
+			Offset: fileSize,
+			Entry:  header.TotalEntries,
+			Reason: fmt.Sprintf("file truncated, size %d but header total length %d", fileSize, header.TotalLength),
+		})
+		length = fileSize
+	}
+	next := header.firstEntry
+	endPos, scanErr := walkEntries(file, length, header.PageSize(), func(pos uint64, e FileEntry) error {
+		if e.Number != next {
+			return fmt.Errorf("%w: number %d, expected %d", ErrInvalidEntryNumber, e.Number, next)
+		}
+		next++
+		return nil
+	})
+	if scanErr != nil {
+		reports = append(reports, CorruptionReport{Offset: endPos, Entry: next, Reason: scanErr.Error()})
+	}
+	endPos = min(endPos, length)
+	if next < header.TotalEntries {
+		reports = append(reports, CorruptionReport{
+			Offset: endPos,
+			Entry:  next,
+			Reason: fmt.Sprintf("entries %d to %d skipped", next, header.TotalEntries-1),
+		})
+	}
+
+	// Header in memory limited to the valid prefix, the checksum of the last page is not the one of the prefix
+	if endPos != header.TotalLength {
+		header.checksums = checksumsNone
+	}
+	header.TotalLength = endPos
+	header.TotalEntries = next
+	sf := StreamFile{
+		fileName:    fileName,
+		pageSize:    header.PageSize(),
+		file:        file,
+		streamType:  header.streamType,
+		maxLength:   fileSize,
+		header:      header,
+		writtenHead: header,
+		readOnly:    true,
+		compression: header.compression,
+	}
+	if codec, err := GetMetadataCodec(header.metaCodec); err == nil {
+		sf.metadataCodec = codec
+	}
+	return &sf, reports, nil
+}
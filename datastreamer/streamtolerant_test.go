@@ -0,0 +1,57 @@
+package datastreamer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenStreamFileTolerant(t *testing.T) {
+	const entrySize = FixedSizeFileEntry + 1000
+	fileName := filepath.Join(t.TempDir(), "truncated.bin")
+	writeTestStream(t, fileName, 100) //nolint:mnd
+
+	// Healthy file, nothing reported
+	sf, reports, err := OpenStreamFileTolerant(fileName)
+	require.NoError(t, err)
+	assert.Empty(t, reports)
+	assert.Equal(t, uint64(100), sf.getHeaderEntry().TotalEntries)
+	require.NoError(t, sf.Close())
+
+	// Truncate the file in the middle of entry 55, after 6 bookmarks (0, 10... 50) and 49 data entries
+	const offset = PageHeaderSize + 6*(FixedSizeFileEntry+8) + 49*entrySize
+	require.NoError(t, os.Truncate(fileName, offset+entrySize/2))
+
+	// The normal open refuses it
+	_, err = NewStreamFile(fileName, 1, 12345, 1)
+	require.Error(t, err)
+
+	sf, reports, err = OpenStreamFileTolerant(fileName)
+	require.NoError(t, err)
+	defer sf.Close()
+	require.NotEmpty(t, reports)
+	last := reports[len(reports)-1]
+	assert.Equal(t, uint64(offset), last.Offset)
+	assert.Equal(t, uint64(55), last.Entry)
+	assert.Contains(t, last.Reason, "entries 55 to 99 skipped")
+
+	// The valid prefix can be read
+	var count uint64
+	for e, err := range sf.Entries(0, 100) {
+		require.NoError(t, err)
+		assert.Equal(t, count, e.Number)
+		count++
+	}
+	assert.Equal(t, uint64(55), count)
+
+	// But not written
+	e := FileEntry{packetType: PtData, Type: 1, Number: 55, Data: []byte{1}}
+	e.Length = uint32(FixedSizeFileEntry + len(e.Data))
+	require.ErrorIs(t, sf.AddFileEntry(e), ErrStreamFileReadOnly)
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(offset+entrySize/2), info.Size())
+}
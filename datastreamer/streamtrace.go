@@ -0,0 +1,205 @@
+package datastreamer
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of the stream
+const tracerName = "github.com/gateway-fm/zkevm-data-streamer/datastreamer"
+
+// FixedSizeTraceContext is the size of the trace context packets: packet type (u8), trace ID (16 bytes), span ID
+// (8 bytes), trace flags (u8) and last entry number of the atomic operation (u64)
+const FixedSizeTraceContext = 1 + 16 + 8 + 1 + 8
+
+// SetTracerProvider sets the OpenTelemetry tracer provider of the server, before Start: each atomic operation gets a
+// span (datastreamer.AtomicOp) and each broadcast of a committed one to the clients a child span
+// (datastreamer.Broadcast). The clients that negotiated it on connection (CmdTraceContext) get the span context of the
+// broadcast (PtTraceContext packet) before its entries. With nil (default) nothing is traced.
+func (s *StreamServer) SetTracerProvider(tp trace.TracerProvider) {
+	s.tracer = nil
+	if tp != nil {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// SetTracerProvider sets the OpenTelemetry tracer provider of the client, before Start: the processing of each entry
+// streamed gets a span (datastreamer.ProcessEntry), child of the broadcast span of the server when it propagates its
+// trace context. With nil (default) nothing is traced nor negotiated, as with servers not supporting it.
+func (c *StreamClient) SetTracerProvider(tp trace.TracerProvider) {
+	c.tracer = nil
+	if tp != nil {
+		c.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// startAtomicOpSpan starts the span of the atomic operation started, if tracing
+func (s *StreamServer) startAtomicOpSpan() {
+	if s.tracer == nil {
+		return
+	}
+	_, s.atomicOp.span = s.tracer.Start(context.Background(), "datastreamer.AtomicOp",
+		trace.WithAttributes(attribute.Int64("datastreamer.start_entry", int64(s.atomicOp.startEntry))))
+}
+
+// endAtomicOpSpan ends the span of the atomic operation ending, if tracing
+func (s *StreamServer) endAtomicOpSpan(rolledBack bool) {
+	span := s.atomicOp.span
+	if span == nil {
+		return
+	}
+	span.SetAttributes(attribute.Int("datastreamer.entries", len(s.atomicOp.entries)))
+	if rolledBack {
+		span.SetStatus(codes.Error, "rolled back")
+	}
+	span.End()
+	s.atomicOp.span = nil
+}
+
+// startBroadcastSpan starts the span of the broadcast of a committed atomic operation to some clients, returns nil
+// and no trace context packet if not tracing
+func (s *StreamServer) startBroadcastSpan(broadcastOp streamAO, clients int) (trace.Span, []byte) {
+	if s.tracer == nil || broadcastOp.span == nil || len(broadcastOp.entries) == 0 {
+		return nil, nil
+	}
+
+	ctx := trace.ContextWithSpanContext(context.Background(), broadcastOp.span.SpanContext())
+	_, span := s.tracer.Start(ctx, "datastreamer.Broadcast", trace.WithAttributes(
+		attribute.Int64("datastreamer.start_entry", int64(broadcastOp.startEntry)),
+		attribute.Int("datastreamer.entries", len(broadcastOp.entries)),
+		attribute.Int("datastreamer.clients", clients),
+	))
+	return span, encodeTraceContext(span.SpanContext(), broadcastOp.entries[len(broadcastOp.entries)-1].Number)
+}
+
+// encodeTraceContext encodes a trace context packet with the span context of the entries up to an entry number
+func encodeTraceContext(sc trace.SpanContext, lastEntry uint64) []byte {
+	traceID := sc.TraceID()
+	spanID := sc.SpanID()
+	packet := make([]byte, 0, FixedSizeTraceContext)
+	packet = append(packet, PtTraceContext)
+	packet = append(packet, traceID[:]...)
+	packet = append(packet, spanID[:]...)
+	packet = append(packet, byte(sc.TraceFlags()))
+	return binary.BigEndian.AppendUint64(packet, lastEntry)
+}
+
+// decodeTraceContext decodes a trace context packet, returns the remote span context and the last entry number
+func decodeTraceContext(packet []byte) (trace.SpanContext, uint64) {
+	var traceID trace.TraceID
+	var spanID trace.SpanID
+	copy(traceID[:], packet[1:17])
+	copy(spanID[:], packet[17:25])
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.TraceFlags(packet[25]),
+		Remote:     true,
+	})
+	return sc, binary.BigEndian.Uint64(packet[26:34])
+}
+
+// sendTraceContext sends the trace context packet of a broadcast to a client before its first entry, if negotiated
+func (s *StreamServer) sendTraceContext(client *client, packet []byte) error {
+	if packet == nil || !client.traceContext.Load() {
+		return nil
+	}
+	return s.sendBroadcastPacket(client, packet, time.Time{})
+}
+
+// processCmdTraceContext processes the TCP TraceContext command from the clients, negotiating the trace context of
+// the entries streamed, answered with an empty trace context packet (old servers answer it with an invalid command
+// result)
+func (s *StreamServer) processCmdTraceContext(client *client) error {
+	log.Debugf("Client %s command TraceContext, negotiated", client.clientID)
+	err := s.sendPacket(client, encodeTraceContext(trace.SpanContext{}, 0))
+	if err != nil {
+		return err
+	}
+	client.traceContext.Store(true)
+	return nil
+}
+
+// negotiateTraceContext asks the server for the trace context of the entries streamed just after connecting, before
+// any other command, if tracing
+func (c *StreamClient) negotiateTraceContext() error {
+	c.traceContext = false
+	if c.tracer == nil {
+		return nil
+	}
+	err := c.writeCommand(CmdTraceContext, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	// The server answers with an empty trace context, or with an invalid command result if it doesn't support it
+	err = c.conn.SetReadDeadline(time.Now().Add(defaultTimeout))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.conn.SetReadDeadline(time.Time{}) }()
+	packet := make([]byte, FixedSizeTraceContext)
+	err = c.readContent(packet[:1])
+	if err != nil {
+		return err
+	}
+	switch packet[0] {
+	case PtTraceContext:
+		err = c.readContent(packet[1:])
+		if err != nil {
+			return err
+		}
+		c.traceContext = true
+	case PtResult:
+		r, err := c.readResultEntry()
+		if err != nil {
+			return err
+		}
+		log.Infof("%s Trace context not supported by server %s: %s", c.ID, c.server, r.errorStr)
+	default:
+		log.Errorf("%s Unexpected packet type %d negotiating trace context", c.ID, packet[0])
+		return ErrInvalidCommand
+	}
+	return nil
+}
+
+// processTraceContext sets the remote span context of the next entries to process from a trace context packet
+func (c *StreamClient) processTraceContext(packet []byte) {
+	c.remoteSpan, c.remoteSpanLast = decodeTraceContext(packet)
+}
+
+// startProcessSpan starts the span of the processing of an entry, child of the broadcast span of the server if it's
+// in the latest trace context received, returns nil if not tracing
+func (c *StreamClient) startProcessSpan(e *FileEntry) trace.Span {
+	if c.tracer == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	if c.remoteSpan.IsValid() && e.Number <= c.remoteSpanLast {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, c.remoteSpan)
+	}
+	_, span := c.tracer.Start(ctx, "datastreamer.ProcessEntry", trace.WithAttributes(
+		attribute.Int64("datastreamer.entry", int64(e.Number)),
+		attribute.Int("datastreamer.entry_type", int(e.Type)),
+	))
+	return span
+}
+
+// endSpan ends a span (nil: not tracing) recording an error
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
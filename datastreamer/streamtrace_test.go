@@ -0,0 +1,110 @@
+package datastreamer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// findSpan returns the ended span with a name, failing if there isn't exactly one
+func findSpan(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+
+	var found []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			found = append(found, span)
+		}
+	}
+	require.Len(t, found, 1, "span %s", name)
+	return found[0]
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	s, err := NewServer(0, 1, 12345, 1, filepath.Join(t.TempDir(), "stream.bin"), time.Second, time.Minute,
+		time.Minute, nil)
+	require.NoError(t, err)
+	s.SetTracerProvider(tp)
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	c.SetTracerProvider(tp)
+	processed := make(chan uint64, 1)
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		processed <- e.Number
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	assert.True(t, c.traceContext)
+	require.NoError(t, c.ExecCommandStart(0))
+
+	// Rolled back atomic operation, not broadcast
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, []byte{1})
+	require.NoError(t, err)
+	require.NoError(t, s.RollbackAtomicOp())
+	rolledBack := findSpan(t, exporter, "datastreamer.AtomicOp")
+	assert.Equal(t, codes.Error, rolledBack.Status.Code)
+	exporter.Reset()
+
+	// Single entry from the atomic operation to the processing by the client
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, []byte{1})
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	select {
+	case entryNum := <-processed:
+		assert.Equal(t, uint64(0), entryNum)
+	case <-time.After(5 * time.Second): //nolint:mnd
+		t.Fatal("entry not processed")
+	}
+	require.Eventually(t, func() bool { return len(exporter.GetSpans()) == 3 }, 5*time.Second, //nolint:mnd
+		10*time.Millisecond) //nolint:mnd
+
+	atomicOp := findSpan(t, exporter, "datastreamer.AtomicOp")
+	broadcast := findSpan(t, exporter, "datastreamer.Broadcast")
+	process := findSpan(t, exporter, "datastreamer.ProcessEntry")
+	assert.False(t, atomicOp.Parent.IsValid())
+	assert.Equal(t, atomicOp.SpanContext.SpanID(), broadcast.Parent.SpanID())
+	assert.Equal(t, broadcast.SpanContext.SpanID(), process.Parent.SpanID())
+	assert.True(t, process.Parent.IsRemote())
+	assert.Equal(t, atomicOp.SpanContext.TraceID(), process.SpanContext.TraceID())
+}
+
+func TestTracingDisabled(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	// Client tracing with a server not propagating its trace context
+	s := newTestServer(t, t.TempDir())
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	c.SetTracerProvider(tp)
+	processed := make(chan uint64, 1)
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		processed <- e.Number
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	require.NoError(t, c.ExecCommandStart(0))
+	commitTestEntry(t, s)
+	select {
+	case <-processed:
+	case <-time.After(5 * time.Second): //nolint:mnd
+		t.Fatal("entry not processed")
+	}
+	require.Eventually(t, func() bool { return len(exporter.GetSpans()) == 1 }, 5*time.Second, //nolint:mnd
+		10*time.Millisecond) //nolint:mnd
+	assert.False(t, exporter.GetSpans()[0].Parent.IsValid())
+}
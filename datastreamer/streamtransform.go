@@ -0,0 +1,43 @@
+package datastreamer
+
+import "github.com/gateway-fm/zkevm-data-streamer/log"
+
+// DataTransform type for a function transforming the data of the entries of a type at the storage boundary
+// (e.g. redacting a field or adding a prefix)
+type DataTransform func(t EntryType, data []byte) ([]byte, error)
+
+// SetDataTransforms sets the transform applied to the data of the entries (bookmarks excluded) before they are
+// written to the stream file, and the transform reversing it when they are read by the query API (GetEntry,
+// GetFirstEventAfterBookmark, GetDataBetweenBookmarks, GetIterator and Entries) or streamed to the clients.
+// A write transform error is returned by the add or update function, the caller decides to roll back the atomic
+// operation. Nil transforms are not applied.
+func (s *StreamServer) SetDataTransforms(write DataTransform, read DataTransform) {
+	s.writeTransform = write
+	s.readTransform = read
+}
+
+// transformEntry applies a data transform to an entry (bookmarks and version change markers excluded) updating its
+// length
+func transformEntry(e *FileEntry, transform DataTransform) error {
+	if transform == nil || e.Type == EtBookmark || e.Type == EtVersionChange {
+		return nil
+	}
+
+	data, err := transform(e.Type, e.Data)
+	if err != nil {
+		return err
+	}
+	e.Data = data
+	e.Length = FixedSizeFileEntry + uint32(len(data))
+	return nil
+}
+
+// encodeStreamEntry encodes an entry to stream to the clients, with the read transform applied to its data
+func (s *StreamServer) encodeStreamEntry(e FileEntry) ([]byte, error) {
+	err := transformEntry(&e, s.readTransform)
+	if err != nil {
+		log.Errorf("Error transforming entry %d data: %v", e.Number, err)
+		return nil, ErrTransformingEntry
+	}
+	return encodeFileEntryToBinary(e), nil
+}
@@ -0,0 +1,90 @@
+package datastreamer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataTransforms(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	prefix := []byte("v1:")
+	errRedact := errors.New("redaction failed")
+	s.SetDataTransforms(
+		func(_ EntryType, data []byte) ([]byte, error) {
+			if bytes.Equal(data, []byte("fail")) {
+				return nil, errRedact
+			}
+			return append(bytes.Clone(prefix), data...), nil
+		},
+		func(_ EntryType, data []byte) ([]byte, error) {
+			return bytes.TrimPrefix(data, prefix), nil
+		},
+	)
+
+	require.NoError(t, s.StartAtomicOp())
+	_, err := s.AddStreamBookmark([]byte{1})
+	require.NoError(t, err)
+	_, err = s.AddStreamEntry(1, []byte("abc"))
+	require.NoError(t, err)
+	_, err = s.AddStreamBookmark([]byte{2}) //nolint:mnd
+	require.NoError(t, err)
+	_, err = s.AddStreamEntry(1, []byte("de"))
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+
+	// Stored with the prefix, bookmarks not transformed
+	stored, err := s.streamFile.iteratorFrom(1, true)
+	require.NoError(t, err)
+	_, err = s.streamFile.iteratorNext(stored)
+	require.NoError(t, err)
+	s.streamFile.iteratorEnd(stored)
+	assert.Equal(t, []byte("v1:abc"), stored.Entry.Data)
+	assert.Equal(t, uint32(FixedSizeFileEntry+6), stored.Entry.Length) //nolint:mnd
+
+	// Read without the prefix
+	entry, err := s.GetEntry(1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), entry.Data)
+	assert.Equal(t, uint32(FixedSizeFileEntry+3), entry.Length) //nolint:mnd
+	entry, err = s.GetFirstEventAfterBookmark([]byte{2})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("de"), entry.Data)
+	data, err := s.GetDataBetweenBookmarks([]byte{1}, []byte{2})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), data)
+
+	var all [][]byte
+	for entry, err := range s.Entries(0, 4) { //nolint:mnd
+		require.NoError(t, err)
+		all = append(all, entry.Data)
+	}
+	assert.Equal(t, [][]byte{{1}, []byte("abc"), {2}, []byte("de")}, all)
+
+	// Streamed without the prefix
+	conn, err := net.Dial("tcp", testServerAddr(s))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, uint32(CmdErrOK), sendStartCommand(t, conn, 1))
+	buffer := make([]byte, FixedSizeFileEntry+3) //nolint:mnd
+	_, err = io.ReadFull(conn, buffer)
+	require.NoError(t, err)
+	streamed, err := DecodeBinaryToFileEntry(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), streamed.Data)
+
+	// A transform error is returned, the caller rolls back the atomic operation
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, []byte("ok"))
+	require.NoError(t, err)
+	_, err = s.AddStreamEntry(1, []byte("fail"))
+	require.ErrorIs(t, err, errRedact)
+	require.NoError(t, s.RollbackAtomicOp())
+	assert.Equal(t, uint64(4), s.GetHeader().TotalEntries) //nolint:mnd
+}
@@ -0,0 +1,84 @@
+package datastreamer
+
+import (
+	"net"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// maxFilterEntryTypes is the maximum number of entry types of a subscription filter
+const maxFilterEntryTypes = 256
+
+// isStart returns if a command is the start command, with any of its options
+func (c Command) isStart() bool {
+	return c&^(CmdOptMaxLatency|CmdOptEntryTypes) == CmdStart
+}
+
+// SetEntryTypeFilter sets the entry types streamed by the next start commands, before ExecCommandStart (nil: all
+// the entries). The server skips the entries of other types, the ones received keep their entry numbers so the
+// streaming is resumed after the latest one. An empty filter is rejected by the server with ErrInvalidEntryTypeFilter.
+func (c *StreamClient) SetEntryTypeFilter(types []EntryType) {
+	if types != nil {
+		types = append([]EntryType{}, types...)
+	}
+	c.entryTypes = types
+}
+
+// startCommand returns the start command with the options of the client
+func (c *StreamClient) startCommand() Command {
+	cmd := CmdStart
+	if c.maxLatency > 0 {
+		cmd |= CmdOptMaxLatency
+	}
+	if c.entryTypes != nil {
+		cmd |= CmdOptEntryTypes
+	}
+	return cmd
+}
+
+// writeEntryTypes sends the entry types of a subscription filter: count (u32) and each type (u32)
+func writeEntryTypes(types []EntryType, conn net.Conn) error {
+	err := writeFullUint32(uint32(len(types)), conn)
+	for _, etype := range types {
+		if err != nil {
+			break
+		}
+		err = writeFullUint32(uint32(etype), conn)
+	}
+	return err
+}
+
+// readEntryTypes reads the entry types of a subscription filter sent by a client, replying CmdErrInvalidFilter if
+// there are none or too many
+func (s *StreamServer) readEntryTypes(client *client) (map[EntryType]bool, error) {
+	count, err := readFullUint32(client)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read even if there are too many, the next command follows them
+	types := make(map[EntryType]bool, min(count, maxFilterEntryTypes))
+	for range count {
+		etype, err := readFullUint32(client)
+		if err != nil {
+			return nil, err
+		}
+		if count <= maxFilterEntryTypes {
+			types[EntryType(etype)] = true
+		}
+	}
+	if count == 0 || count > maxFilterEntryTypes {
+		log.Errorf("Invalid entry type filter with %d types from client %s", count, client.clientID)
+		_ = s.sendResultEntry(uint32(CmdErrInvalidFilter), StrCommandErrors[CmdErrInvalidFilter], client)
+		return nil, ErrInvalidEntryTypeFilter
+	}
+	return types, nil
+}
+
+// accept returns if an entry is streamed to the client with its filters, the entries must be given in order
+func (c *client) accept(e FileEntry) bool {
+	if c.filter != nil && !c.filter.accept(e) {
+		return false
+	}
+	return c.entryTypes == nil || c.entryTypes[e.Type]
+}
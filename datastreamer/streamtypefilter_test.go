@@ -0,0 +1,71 @@
+package datastreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryTypeFilter(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+
+	// Entries of types 1 and 2 alternated, with a bookmark
+	commit := func(count int) {
+		require.NoError(t, s.StartAtomicOp())
+		_, err := s.AddStreamBookmark([]byte("bm"))
+		require.NoError(t, err)
+		for i := range count {
+			_, err = s.AddStreamEntry(EntryType(1+i%2), []byte{byte(i)}) //nolint:mnd
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+	}
+	commit(4) //nolint:mnd
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	received := make(chan FileEntry, 10) //nolint:mnd
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- *e
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+
+	// An empty filter is rejected, the connection remains usable
+	c.SetEntryTypeFilter([]EntryType{})
+	require.ErrorIs(t, c.ExecCommandStart(0), ErrInvalidEntryTypeFilter)
+
+	// Just the entries of type 2 with their entry numbers, from the file and then broadcast
+	c.SetEntryTypeFilter([]EntryType{2})
+	require.NoError(t, c.ExecCommandStart(0))
+	commit(2) //nolint:mnd
+	for _, num := range []uint64{2, 4, 7} {
+		select {
+		case e := <-received:
+			assert.Equal(t, num, e.Number)
+			assert.Equal(t, EntryType(2), e.Type)
+		case <-time.After(time.Second):
+			t.Fatalf("entry %d not received", num)
+		}
+	}
+	select {
+	case e := <-received:
+		t.Fatalf("entry %d of type %d not filtered", e.Number, e.Type)
+	case <-time.After(100 * time.Millisecond): //nolint:mnd
+	}
+	require.NoError(t, c.ExecCommandStop())
+
+	// Without filter all the entries are streamed
+	c.SetEntryTypeFilter(nil)
+	require.NoError(t, c.ExecCommandStartMaxLatency(6, time.Second)) //nolint:mnd
+	for _, num := range []uint64{6, 7} {
+		select {
+		case e := <-received:
+			assert.Equal(t, num, e.Number)
+		case <-time.After(time.Second):
+			t.Fatalf("entry %d not received", num)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// etypeCurrent is the entry type given to update an entry keeping its type (not a valid type, it has the tombstone
+// flag)
+const etypeCurrent EntryType = ^EntryType(0)
+
+// Records of the undo log of the entries updated in an atomic operation
+const (
+	undoRecordUpdate byte = 'u' // Stored bytes of an entry before they're rewritten: position (u64), length (u32), bytes
+	undoRecordCommit byte = 'c' // Commit of the updates with a header: total entries (u64) and total length (u64)
+
+	undoUpdateSize = 1 + 8 + 4 // Size of the fixed part of an update record
+	undoCommitSize = 1 + 8 + 8 // Size of a commit record
+)
+
+// entryUndo type for the stored bytes of an entry before they were rewritten in an atomic operation (its data when
+// updated, its type when tombstoned)
+type entryUndo struct {
+	pos  uint64 // File position of the bytes
+	data []byte // Bytes as stored in the file
+}
+
+// UpdateEntryData rewrites the data of a committed entry in place, keeping its number and type. The new data must
+// have the length of the current data, stored with the same length if the file is compressed, otherwise it fails
+// with ErrEntryLengthMismatch. Within an atomic operation of a server the update is undone by its rollback, or on
+// open after a crash before its commit (the previous data is persisted first in the undo log).
+func (f *StreamFile) UpdateEntryData(entryNum uint64, data []byte) error {
+	if f.readOnly {
+		log.Errorf("Error updating entry of read-only file %s", f.fileName)
+		return ErrStreamFileReadOnly
+	}
+	return f.updateEntryData(entryNum, etypeCurrent, data)
+}
+
+// undoLogName returns the undo log name for a stream file (same name with .undo extension)
+func undoLogName(fileName string) string {
+	base := filepath.Base(fileName)
+	baseWithoutExt := strings.TrimSuffix(base, filepath.Ext(base))
+	return filepath.Join(filepath.Dir(fileName), baseWithoutExt+".undo")
+}
+
+// startAtomicUpdates records from now on the entries updated, to restore them on rollback
+func (f *StreamFile) startAtomicUpdates() {
+	f.atomicUpdates = true
+	f.undo = nil
+}
+
+// endAtomicUpdates stops recording the entries updated, the updates recorded are kept, removing the undo log
+func (f *StreamFile) endAtomicUpdates() {
+	f.atomicUpdates = false
+	f.undo = nil
+	if f.undoLog == nil {
+		return
+	}
+	f.undoLog.Close()
+	f.undoLog = nil
+	err := os.Remove(undoLogName(f.fileName))
+	if err != nil {
+		log.Errorf("Error removing undo log of file %s: %v", f.fileName, err)
+	}
+}
+
+// writeUndoLog appends a record to the undo log, created by the first one of the atomic operation, and flushes it to
+// disk before the entry is rewritten
+func (f *StreamFile) writeUndoLog(record []byte) error {
+	if f.undoLog == nil {
+		file, err := os.OpenFile(undoLogName(f.fileName), os.O_CREATE|os.O_TRUNC|os.O_RDWR, fileMode)
+		if err != nil {
+			log.Errorf("Error creating undo log of file %s: %v", f.fileName, err)
+			return err
+		}
+		f.undoLog = file
+	}
+	_, err := f.undoLog.Write(record)
+	if err != nil {
+		log.Errorf("Error writing undo log of file %s: %v", f.fileName, err)
+		return err
+	}
+	return f.undoLog.Sync()
+}
+
+// commitUndoLog records in the undo log the header committing the updates, before it's written: the updates are
+// undone after a crash unless the file has that header
+func (f *StreamFile) commitUndoLog(header HeaderEntry) error {
+	if f.undoLog == nil {
+		return nil
+	}
+	record := append(make([]byte, 0, undoCommitSize), undoRecordCommit)
+	record = binary.BigEndian.AppendUint64(record, header.TotalEntries)
+	record = binary.BigEndian.AppendUint64(record, header.TotalLength)
+	return f.writeUndoLog(record)
+}
+
+// recordUndo keeps the stored bytes of an entry in a file range, before they're rewritten
+func (f *StreamFile) recordUndo(pos, end uint64) error {
+	data, err := f.readRange(pos, end)
+	if err != nil {
+		return err
+	}
+
+	// Persisted before the update, to restore it after a crash in the atomic operation
+	record := append(make([]byte, 0, undoUpdateSize+len(data)), undoRecordUpdate)
+	record = binary.BigEndian.AppendUint64(record, pos)
+	record = binary.BigEndian.AppendUint32(record, uint32(len(data)))
+	err = f.writeUndoLog(append(record, data...))
+	if err != nil {
+		return err
+	}
+	f.undo = append(f.undo, entryUndo{pos: pos, data: data})
+	return nil
+}
+
+// undoUpdates restores the stored data of the entries updated in the atomic operation, the latest update first
+func (f *StreamFile) undoUpdates() error {
+	defer f.endAtomicUpdates()
+	return f.undoUpdatesFrom(0)
+}
+
+// undoUpdatesFrom restores the stored data of the entries updated in the atomic operation after a number of updates,
+// the latest update first, keeping the record of the previous ones
+func (f *StreamFile) undoUpdatesFrom(kept int) error {
+	if len(f.undo) <= kept {
+		return nil
+	}
+
+	undone := f.undo[kept:]
+	f.undo = f.undo[:kept]
+	err := f.restoreUpdates(undone)
+	if err != nil {
+		return err
+	}
+	log.Infof("Restored %d entries updated in the atomic operation", len(undone))
+
+	// Keep the records of the updates not undone in the undo log
+	length := int64(0)
+	for _, u := range f.undo {
+		length += undoUpdateSize + int64(len(u.data))
+	}
+	err = f.undoLog.Truncate(length)
+	if err == nil {
+		_, err = f.undoLog.Seek(length, io.SeekStart)
+	}
+	if err != nil {
+		log.Errorf("Error truncating undo log of file %s: %v", f.fileName, err)
+	}
+	return err
+}
+
+// restoreUpdates writes back the stored bytes of entries updated, the latest update first, with their page checksums
+func (f *StreamFile) restoreUpdates(undone []entryUndo) error {
+	for i := len(undone) - 1; i >= 0; i-- {
+		u := undone[i]
+		_, err := f.file.WriteAt(u.data, int64(u.pos))
+		if err != nil {
+			log.Errorf("Error restoring updated entry bytes at position %d: %v", u.pos, err)
+			return err
+		}
+		err = f.updatePageChecksum(u.pos)
+		if err != nil {
+			return err
+		}
+	}
+	return f.sync()
+}
+
+// recoverUpdates restores on open the entries updated by an atomic operation not committed before a crash, found in
+// the undo log left, and removes it. The updates are kept if the undo log commits them with the header of the file.
+func (f *StreamFile) recoverUpdates() error {
+	fileName := undoLogName(f.fileName)
+	b, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		log.Errorf("Error reading undo log of file %s: %v", f.fileName, err)
+		return err
+	}
+
+	// Records fully written, the last one may be cut by the crash (the entry was not updated yet)
+	var (
+		undone    []entryUndo
+		committed bool
+	)
+	for len(b) > 0 {
+		if b[0] == undoRecordCommit && len(b) >= undoCommitSize {
+			committed = binary.BigEndian.Uint64(b[1:9]) == f.header.TotalEntries &&
+				binary.BigEndian.Uint64(b[9:17]) == f.header.TotalLength
+			b = b[undoCommitSize:]
+			continue
+		}
+		if b[0] != undoRecordUpdate || len(b) < undoUpdateSize {
+			break
+		}
+		pos := binary.BigEndian.Uint64(b[1:9])
+		length := uint64(binary.BigEndian.Uint32(b[9:13]))
+		if uint64(len(b)) < undoUpdateSize+length {
+			break
+		}
+		if pos < PageHeaderSize || pos+length > f.header.TotalLength {
+			log.Errorf("Invalid entry position %d in undo log of file %s", pos, f.fileName)
+			return ErrBadFileFormat
+		}
+		undone = append(undone, entryUndo{pos: pos, data: b[undoUpdateSize : undoUpdateSize+length]})
+		b = b[undoUpdateSize+length:]
+	}
+
+	if !committed && len(undone) > 0 {
+		log.Warnf("File %s has %d entries updated by an atomic operation not committed, restoring", f.fileName,
+			len(undone))
+		err = f.restoreUpdates(undone)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Remove(fileName)
+	if err != nil {
+		log.Errorf("Error removing undo log of file %s: %v", f.fileName, err)
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package datastreamer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamFileUpdateEntryData(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "update.bin")
+	sf := setupTestFile(t, fileName)
+	for range 3 {
+		addTestEntry(t, sf, 100) //nolint:mnd
+	}
+	require.NoError(t, sf.writeHeaderEntry())
+
+	// Same length, rewritten in place keeping the type
+	updated := bytes.Repeat([]byte{9}, 100) //nolint:mnd
+	require.NoError(t, sf.UpdateEntryData(1, updated))
+	require.ErrorIs(t, sf.UpdateEntryData(2, updated[:99]), ErrEntryLengthMismatch)             //nolint:mnd
+	require.ErrorIs(t, sf.UpdateEntryData(3, updated), ErrInvalidEntryNumberNotCommittedInFile) //nolint:mnd
+	require.NoError(t, sf.Close())
+
+	sf, err := NewStreamFile(fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	var data [][]byte
+	for entry, err := range sf.Entries(0, 3) { //nolint:mnd
+		require.NoError(t, err)
+		assert.Equal(t, EntryType(1), entry.Type)
+		data = append(data, entry.Data)
+	}
+	assert.Equal(t, [][]byte{bytes.Repeat([]byte{0}, 100), updated, bytes.Repeat([]byte{2}, 100)}, data)
+	require.NoError(t, sf.Close())
+}
+
+func TestUpdateEntryDataAtomicOp(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	for range 3 {
+		commitTestEntry(t, s)
+	}
+	original, err := s.GetEntry(1)
+	require.NoError(t, err)
+	updated := bytes.Repeat([]byte{7}, len(original.Data))
+
+	// Undone by the rollback, the latest update first
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.UpdateEntryData(1, 1, bytes.Repeat([]byte{8}, len(original.Data))))
+	require.NoError(t, s.UpdateEntryData(1, 1, updated))
+	_, err = s.AddStreamEntry(1, []byte{1})
+	require.NoError(t, err)
+	entry, err := s.GetEntry(1)
+	require.NoError(t, err)
+	assert.Equal(t, updated, entry.Data)
+	require.NoError(t, s.RollbackAtomicOp())
+	entry, err = s.GetEntry(1)
+	require.NoError(t, err)
+	assert.Equal(t, original.Data, entry.Data)
+
+	// Kept by the commit, and not undone by a later rollback
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.UpdateEntryData(1, 1, updated))
+	require.NoError(t, s.CommitAtomicOp())
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.RollbackAtomicOp())
+	entry, err = s.GetEntry(1)
+	require.NoError(t, err)
+	assert.Equal(t, updated, entry.Data)
+	require.NoError(t, s.Close())
+
+	// Checksums of the data page restored
+	sf, err := NewStreamFile(s.fileName, 1, 12345, 1)
+	require.NoError(t, err)
+	for _, err := range sf.Entries(0, 3) { //nolint:mnd
+		require.NoError(t, err)
+	}
+	require.NoError(t, sf.Close())
+}
+
+func TestUpdateEntryDataCrash(t *testing.T) {
+	updated := bytes.Repeat([]byte{9}, 100) //nolint:mnd
+
+	// updateAndCrash updates entry 1 in an atomic operation adding an entry, and closes the file without removing the
+	// undo log, after writing the header if committed
+	updateAndCrash := func(t *testing.T, fileName string, commit bool) {
+		t.Helper()
+
+		sf := setupTestFile(t, fileName)
+		for range 3 {
+			addTestEntry(t, sf, 100) //nolint:mnd
+		}
+		require.NoError(t, sf.writeHeaderEntry())
+		sf.startAtomicUpdates()
+		require.NoError(t, sf.UpdateEntryData(1, updated))
+		addTestEntry(t, sf, 100) //nolint:mnd
+		if commit {
+			require.NoError(t, sf.commitUndoLog(sf.header))
+			require.NoError(t, sf.writeHeader(sf.header))
+		}
+		sf.closeFiles()
+		_, err := os.Stat(undoLogName(fileName))
+		require.NoError(t, err)
+	}
+
+	// readEntries reopens the file and returns the data of its entries, checking its consistency
+	readEntries := func(t *testing.T, fileName string) [][]byte {
+		t.Helper()
+
+		sf := setupTestFile(t, fileName)
+		var data [][]byte
+		for entry, err := range sf.Entries(0, sf.getHeaderEntry().TotalEntries) {
+			require.NoError(t, err)
+			data = append(data, entry.Data)
+		}
+		require.NoError(t, sf.Close())
+		_, err := os.Stat(undoLogName(fileName))
+		require.ErrorIs(t, err, os.ErrNotExist)
+		report, err := NewConsistencyChecker(fileName).Check()
+		require.NoError(t, err)
+		assert.True(t, checkResult(t, report, CheckPageChecksums).Passed, "%+v", report.Results)
+		return data
+	}
+
+	// Not committed, the update is undone on open
+	fileName := filepath.Join(t.TempDir(), "uncommitted.bin")
+	updateAndCrash(t, fileName, false)
+	assert.Equal(t, [][]byte{bytes.Repeat([]byte{0}, 100), bytes.Repeat([]byte{1}, 100), bytes.Repeat([]byte{2}, 100)},
+		readEntries(t, fileName))
+
+	// Committed before removing the undo log, the update is kept
+	fileName = filepath.Join(t.TempDir(), "committed.bin")
+	updateAndCrash(t, fileName, true)
+	data := readEntries(t, fileName)
+	require.Len(t, data, 4) //nolint:mnd
+	assert.Equal(t, updated, data[1])
+}
+
+func TestTombstoneAtomicOp(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	for range 3 {
+		commitTestEntry(t, s)
+	}
+
+	// Undone by the rollback, with an update of the same entry before
+	original, err := s.GetEntry(1)
+	require.NoError(t, err)
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.UpdateEntryData(1, 1, bytes.Repeat([]byte{7}, len(original.Data))))
+	require.NoError(t, s.Tombstone(1))
+	_, err = s.GetEntry(1)
+	require.ErrorIs(t, err, ErrEntryTombstoned)
+	require.NoError(t, s.RollbackAtomicOp())
+	entry, err := s.GetEntry(1)
+	require.NoError(t, err)
+	assert.Equal(t, original, entry)
+
+	// Kept by the commit
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.Tombstone(2)) //nolint:mnd
+	require.NoError(t, s.CommitAtomicOp())
+	_, err = s.GetEntry(2) //nolint:mnd
+	require.ErrorIs(t, err, ErrEntryTombstoned)
+	require.NoError(t, s.Close())
+
+	// Not committed before a crash, undone on open with the checksums of the data page
+	sf := setupTestFile(t, s.fileName)
+	sf.startAtomicUpdates()
+	require.NoError(t, sf.tombstoneEntry(0))
+	sf.closeFiles()
+	sf = setupTestFile(t, s.fileName)
+	var numbers []uint64
+	for entry, err := range sf.Entries(0, 3) { //nolint:mnd
+		require.NoError(t, err)
+		numbers = append(numbers, entry.Number)
+	}
+	assert.Equal(t, []uint64{0, 1}, numbers)
+	require.NoError(t, sf.Close())
+}
@@ -0,0 +1,65 @@
+package datastreamer
+
+import (
+	"bytes"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// SetWriteVerification enables the verification of the writes, it must be set before Start. CommitAtomicOp flushes
+// the entries of the atomic operation to disk and reads them back before committing them, failing with
+// ErrWriteVerificationFailed if they differ from the entries added (e.g. a faulty disk). The atomic operation is not
+// committed then, so it can be rolled back. It's expensive, meant for critical deployments or diagnostics.
+func (s *StreamServer) SetWriteVerification(enabled bool) {
+	s.verifyWrites = enabled
+}
+
+// verifyWrites flushes the entries written since the latest commit and reads them back from the file, checking they
+// are the given ones (the entries of the atomic operation in progress)
+func (f *StreamFile) verifyWrites(entries []FileEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	err := f.sync()
+	if err != nil {
+		return err
+	}
+
+	f.mutexHeader.Lock()
+	from, to := f.writtenHead.TotalLength, f.header.TotalLength
+	f.mutexHeader.Unlock()
+
+	reader := f.verifyReader
+	if reader == nil {
+		reader = f.file
+	}
+	count := 0
+	_, err = walkEntriesFrom(reader, from, to, f.pageSize, func(pos uint64, e FileEntry) error {
+		if count >= len(entries) {
+			log.Errorf("Write verification: unexpected entry %d at offset %d", e.Number, pos)
+			return ErrWriteVerificationFailed
+		}
+		err := f.loadEntry(&e)
+		if err != nil {
+			return err
+		}
+		want := entries[count]
+		if e.Number != want.Number || e.Type != want.Type || e.Tombstoned || !bytes.Equal(e.Data, want.Data) {
+			log.Errorf("Write verification: entry %d read back at offset %d differs from the one written",
+				want.Number, pos)
+			return ErrWriteVerificationFailed
+		}
+		count++
+		return nil
+	})
+	if err == nil && count != len(entries) {
+		log.Errorf("Write verification: %d entries read back, %d written", count, len(entries))
+		err = ErrWriteVerificationFailed
+	}
+	if err != nil {
+		log.Errorf("Error verifying the entries written from offset %d: %v", from, err)
+		return ErrWriteVerificationFailed
+	}
+	return nil
+}
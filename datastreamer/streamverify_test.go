@@ -0,0 +1,59 @@
+package datastreamer
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// corruptingReader reads from a file flipping the last byte read, as a faulty disk
+type corruptingReader struct {
+	r io.ReaderAt
+}
+
+func (c corruptingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	if n > 0 {
+		p[n-1] ^= 0xff
+	}
+	return n, err
+}
+
+func TestWriteVerification(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	s.SetWriteVerification(true)
+	require.NoError(t, commitTestEntries(t, s, 3, 100)) //nolint:mnd
+
+	// The corrupted read back fails the commit, that can be rolled back
+	s.streamFile.verifyReader = corruptingReader{r: s.streamFile.file}
+	require.ErrorIs(t, commitTestEntries(t, s, 3, 100), ErrWriteVerificationFailed) //nolint:mnd
+	assert.Equal(t, uint64(3), s.GetHeader().TotalEntries)
+	require.NoError(t, s.RollbackAtomicOp())
+
+	// Entries across a page boundary read back fine
+	s.streamFile.verifyReader = nil
+	require.NoError(t, commitTestEntries(t, s, int(s.streamFile.pageSize)/100, 100)) //nolint:mnd
+	entry, err := s.GetEntry(s.GetHeader().TotalEntries - 1)
+	require.NoError(t, err)
+	assert.Len(t, entry.Data, 100) //nolint:mnd
+
+	// Without verification the corruption isn't noticed
+	s.SetWriteVerification(false)
+	s.streamFile.verifyReader = corruptingReader{r: s.streamFile.file}
+	require.NoError(t, commitTestEntries(t, s, 3, 100)) //nolint:mnd
+}
+
+func TestWriteVerificationEncrypted(t *testing.T) {
+	s, err := openTestServer(t, filepath.Join(t.TempDir(), "stream.bin"),
+		StreamFileOptions{EncryptionKey: StaticKey(bytes.Repeat([]byte{7}, 32))}, nil) //nolint:mnd
+	require.NoError(t, err)
+	s.SetWriteVerification(true)
+	require.NoError(t, commitTestEntries(t, s, 3, 100)) //nolint:mnd
+
+	s.streamFile.verifyReader = corruptingReader{r: s.streamFile.file}
+	require.ErrorIs(t, commitTestEntries(t, s, 3, 100), ErrWriteVerificationFailed) //nolint:mnd
+}
@@ -0,0 +1,49 @@
+package datastreamer
+
+import "github.com/gateway-fm/zkevm-data-streamer/log"
+
+// versionChangeSize is the size of the data of a version change marker entry: previous and new version
+const versionChangeSize = 2
+
+// SetStreamVersion bumps the stream version in the current atomic operation: it adds a version change marker entry
+// (EtVersionChange) and updates the version of the header, both committed (or rolled back) with the atomic
+// operation. The entries added after the marker have the new version, readers switch decoding mode when they find
+// it (see VersionChange). The version must be newer than the current one.
+func (s *StreamServer) SetStreamVersion(v uint8) error {
+	unlock, err := s.lockAtomicOp()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	s.streamFile.mutexHeader.Lock()
+	current := s.streamFile.header.Version
+	s.streamFile.mutexHeader.Unlock()
+	if v <= current {
+		log.Errorf("Invalid stream version %d, current version %d", v, current)
+		return ErrInvalidTargetVersion
+	}
+
+	// Add the marker entry
+	_, err = s.addStream("VersionChange", EtVersionChange, []byte{current, v})
+	if err != nil {
+		return err
+	}
+
+	// Update the header (in memory), written with the commit
+	s.streamFile.mutexHeader.Lock()
+	s.streamFile.header.Version = v
+	s.streamFile.mutexHeader.Unlock()
+
+	log.Infof("Stream version %d from entry %d", v, s.nextEntry)
+	return nil
+}
+
+// VersionChange returns the previous and the new stream version if the entry is a version change marker, added by
+// SetStreamVersion. The entries after the marker have the new version and the ones before it the previous version.
+func VersionChange(e FileEntry) (from uint8, to uint8, ok bool) {
+	if e.Type != EtVersionChange || len(e.Data) != versionChangeSize {
+		return 0, 0, false
+	}
+	return e.Data[0], e.Data[1], true
+}
@@ -0,0 +1,85 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readVersionedValues reads the values of the entries of a stream, u32 in version 1 and u64 from version 2
+func readVersionedValues(t *testing.T, s *StreamServer, version uint8) []uint64 {
+	t.Helper()
+
+	var values []uint64
+	for e, err := range s.Entries(0, s.GetHeader().TotalEntries) {
+		require.NoError(t, err)
+		if from, to, ok := VersionChange(e); ok {
+			assert.Equal(t, version, from)
+			version = to
+			continue
+		}
+		if version == 1 {
+			require.Len(t, e.Data, 4) //nolint:mnd
+			values = append(values, uint64(binary.BigEndian.Uint32(e.Data)))
+		} else {
+			require.Len(t, e.Data, 8) //nolint:mnd
+			values = append(values, binary.BigEndian.Uint64(e.Data))
+		}
+	}
+	return values
+}
+
+func TestSetStreamVersion(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	// Version 1 entries
+	require.NoError(t, s.StartAtomicOp())
+	for i := range 3 {
+		_, err := s.AddStreamEntry(1, binary.BigEndian.AppendUint32(nil, uint32(i)))
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	// Only a newer version, rolled back with the atomic operation
+	require.NoError(t, s.StartAtomicOp())
+	require.ErrorIs(t, s.SetStreamVersion(1), ErrInvalidTargetVersion)
+	require.NoError(t, s.SetStreamVersion(2)) //nolint:mnd
+	require.NoError(t, s.RollbackAtomicOp())
+	assert.Equal(t, uint8(1), s.GetHeader().Version)
+	assert.Equal(t, uint64(3), s.GetHeader().TotalEntries) //nolint:mnd
+
+	// The marker is the first entry of the new version
+	require.NoError(t, s.StartAtomicOp())
+	require.NoError(t, s.SetStreamVersion(2)) //nolint:mnd
+	for i := 3; i < 6; i++ {
+		_, err := s.AddStreamEntry(1, binary.BigEndian.AppendUint64(nil, uint64(i)))
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+	assert.Equal(t, uint8(2), s.GetHeader().Version) //nolint:mnd
+	e, err := s.GetEntry(3)                          //nolint:mnd
+	require.NoError(t, err)
+	assert.Equal(t, EntryType(EtVersionChange), e.Type)
+
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5}, readVersionedValues(t, s, 1))
+	require.NoError(t, s.Close())
+
+	// The migration applies to each entry the migrations from its version
+	require.NoError(t, RegisterVersionMigration(1, func(e FileEntry) ([]byte, error) {
+		return binary.BigEndian.AppendUint64(nil, uint64(binary.BigEndian.Uint32(e.Data))), nil
+	}))
+	t.Cleanup(func() {
+		mutexVersionMigrations.Lock()
+		delete(versionMigrations, 1)
+		mutexVersionMigrations.Unlock()
+	})
+	require.NoError(t, MigrateStreamVersion(filepath.Join(dir, "stream.bin"), 3)) //nolint:mnd
+
+	s = newTestServer(t, dir)
+	assert.Equal(t, uint8(3), s.GetHeader().Version) //nolint:mnd
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5}, readVersionedValues(t, s, 3))
+}
@@ -0,0 +1,275 @@
+package datastreamer
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketBridge type to serve the stream of a server to WebSocket clients (e.g. browser dashboards).
+// Each WebSocket connection is a stream client of the server, packets are delivered as binary frames.
+type WebSocketBridge struct {
+	server   *StreamServer
+	upgrader websocket.Upgrader
+}
+
+// wsConn type to use a WebSocket connection as a stream client connection.
+// The first bytes read are the start command built from the query parameters.
+type wsConn struct {
+	ws         *websocket.Conn
+	pending    []byte             // Command bytes not read yet
+	reader     io.Reader          // Reader of the current message received
+	entryTypes map[EntryType]bool // Entry types to deliver (nil: all)
+	skips      bool               // Deliver skip markers for the entries filtered out
+	skipFrom   uint64             // First entry of the run of entries filtered out pending to report
+	skipCount  uint64             // Number of entries of the run filtered out pending to report
+	mutexWrite sync.Mutex         // Mutex for the writes of the server goroutines
+}
+
+// SkipMarker type for the entries filtered out of a WebSocket stream, delivered just before the next entry
+type SkipMarker struct {
+	FromEntry uint64 // First entry skipped
+	Count     uint64 // Number of consecutive entries skipped
+}
+
+// NewWebSocketBridge creates a WebSocket bridge for a stream server.
+// checkOrigin validates the Origin header of the requests (nil: same origin only).
+func NewWebSocketBridge(s *StreamServer, checkOrigin func(r *http.Request) bool) *WebSocketBridge {
+	return &WebSocketBridge{
+		server:   s,
+		upgrader: websocket.Upgrader{CheckOrigin: checkOrigin},
+	}
+}
+
+// ServeHTTP upgrades the connection to WebSocket and streams to it. Query parameters:
+//   - from: entry number to start the streaming from (default: the tail)
+//   - bookmark: hex bookmark to start the streaming from (has preference over from)
+//   - types: comma separated entry types to deliver (default: all)
+//   - skips: true to deliver a skip marker for the consecutive entries filtered out by types (default: false)
+//
+// The first frame is the Result of the start command, followed by a frame per data entry. The client can send
+// further commands as binary frames.
+func (b *WebSocketBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Check max connections allowed
+	if b.server.connectedClients.Load() >= int64(b.server.clientsLimit()) {
+		log.Warnf("Unable to accept WebSocket connection, maximum number of clients reached (%d)",
+			b.server.clientsLimit())
+		http.Error(w, "maximum number of connections reached", http.StatusServiceUnavailable)
+		return
+	}
+
+	command, entryTypes, err := b.parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var skips bool
+	if value := r.URL.Query().Get("skips"); value != "" {
+		skips, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ws, err := b.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("Error upgrading WebSocket connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
+
+	// Manage it as any other client connection
+	b.server.handleConnection(&wsConn{
+		ws:         ws,
+		pending:    command,
+		entryTypes: entryTypes,
+		skips:      skips,
+	})
+}
+
+// parseQuery builds the start command and the entry types filter from the query parameters
+func (b *WebSocketBridge) parseQuery(r *http.Request) ([]byte, map[EntryType]bool, error) {
+	query := r.URL.Query()
+
+	var entryTypes map[EntryType]bool
+	if types := query.Get("types"); types != "" {
+		entryTypes = make(map[EntryType]bool)
+		for _, t := range strings.Split(types, ",") {
+			etype, err := strconv.ParseUint(strings.TrimSpace(t), 10, 32)
+			if err != nil {
+				return nil, nil, err
+			}
+			entryTypes[EntryType(etype)] = true
+		}
+	}
+
+	if bookmark := query.Get("bookmark"); bookmark != "" {
+		bm, err := hex.DecodeString(strings.TrimPrefix(bookmark, "0x"))
+		if err != nil {
+			return nil, nil, err
+		}
+		command := binary.BigEndian.AppendUint64(nil, uint64(CmdStartBookmark))
+		command = binary.BigEndian.AppendUint64(command, uint64(b.server.streamType))
+		command = binary.BigEndian.AppendUint32(command, uint32(len(bm)))
+		return append(command, bm...), entryTypes, nil
+	}
+
+	fromEntry := b.server.GetHeader().TotalEntries
+	if from := query.Get("from"); from != "" {
+		var err error
+		fromEntry, err = strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	command := binary.BigEndian.AppendUint64(nil, uint64(CmdStart))
+	command = binary.BigEndian.AppendUint64(command, uint64(b.server.streamType))
+	return binary.BigEndian.AppendUint64(command, fromEntry), entryTypes, nil
+}
+
+// Read reads the pending command bytes and then the binary messages received
+func (c *wsConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	for {
+		if c.reader == nil {
+			_, reader, err := c.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.reader = reader
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write sends a packet as a binary message, skipping the data entries of the entry types not requested (reported
+// by a skip marker before the next entry sent if enabled)
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+
+	isData := len(p) >= FixedSizeFileEntry && p[0] == PtData
+	if c.entryTypes != nil && isData && !c.entryTypes[EntryType(binary.BigEndian.Uint32(p[5:9]))] {
+		if !c.skips {
+			return len(p), nil
+		}
+		// A run of entries skipped is reported when it ends (e.g. a gap of tombstoned entries)
+		num := binary.BigEndian.Uint64(p[9:17])
+		if c.skipCount > 0 && num != c.skipFrom+c.skipCount {
+			err := c.writeSkipMarker()
+			if err != nil {
+				return 0, err
+			}
+		}
+		if c.skipCount == 0 {
+			c.skipFrom = num
+		}
+		c.skipCount++
+		return len(p), nil
+	}
+
+	// Report the entries skipped before this one
+	if isData {
+		err := c.writeSkipMarker()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err := c.ws.WriteMessage(websocket.BinaryMessage, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeSkipMarker sends the skip marker of the run of entries skipped pending to report, if any
+func (c *wsConn) writeSkipMarker() error {
+	if c.skipCount == 0 {
+		return nil
+	}
+	marker := encodeSkipMarker(SkipMarker{FromEntry: c.skipFrom, Count: c.skipCount})
+	err := c.ws.WriteMessage(websocket.BinaryMessage, marker)
+	if err != nil {
+		return err
+	}
+	c.skipCount = 0
+	return nil
+}
+
+// encodeSkipMarker encodes a skip marker to binary
+func encodeSkipMarker(m SkipMarker) []byte {
+	be := []byte{PtSkip}
+	be = binary.BigEndian.AppendUint64(be, m.FromEntry)
+	return binary.BigEndian.AppendUint64(be, m.Count)
+}
+
+// DecodeBinaryToSkipMarker decodes from binary bytes slice to skip marker type
+func DecodeBinaryToSkipMarker(b []byte) (SkipMarker, error) {
+	if len(b) != FixedSizeSkipMarker || b[0] != PtSkip {
+		log.Error("Invalid binary skip marker")
+		return SkipMarker{}, ErrInvalidBinaryEntry
+	}
+	return SkipMarker{
+		FromEntry: binary.BigEndian.Uint64(b[1:9]),
+		Count:     binary.BigEndian.Uint64(b[9:17]),
+	}, nil
+}
+
+// Close closes the WebSocket connection
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
+
+// LocalAddr returns the local network address
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *wsConn) SetDeadline(t time.Time) error {
+	err := c.ws.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	c.mutexWrite.Lock()
+	defer c.mutexWrite.Unlock()
+	return c.ws.SetWriteDeadline(t)
+}
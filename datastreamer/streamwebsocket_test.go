@@ -0,0 +1,150 @@
+package datastreamer
+
+import (
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readWebSocketEntries reads the result frame and a number of data entry frames from a WebSocket connection
+func readWebSocketEntries(t *testing.T, ws *websocket.Conn, count int) []FileEntry {
+	t.Helper()
+
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(5*time.Second))) //nolint:mnd
+	_, msg, err := ws.ReadMessage()
+	require.NoError(t, err)
+	result, err := DecodeBinaryToResultEntry(msg)
+	require.NoError(t, err)
+	require.Equal(t, uint32(CmdErrOK), result.errorNum)
+
+	var entries []FileEntry
+	for range count {
+		_, msg, err = ws.ReadMessage()
+		require.NoError(t, err)
+		entry, err := DecodeBinaryToFileEntry(msg)
+		require.NoError(t, err)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestWebSocketBridge(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	require.NoError(t, s.StartAtomicOp())
+	_, err := s.AddStreamBookmark([]byte{0xbb, 1})
+	require.NoError(t, err)
+	for i := range 6 {
+		_, err = s.AddStreamEntry(EntryType(i%2+1), []byte{byte(i)}) //nolint:mnd
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+
+	httpServer := httptest.NewServer(NewWebSocketBridge(s, nil))
+	defer httpServer.Close()
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	// Entries of type 2 from entry 2
+	ws, _, err := websocket.DefaultDialer.Dial(url+"?from=2&types=2", nil)
+	require.NoError(t, err)
+	entries := readWebSocketEntries(t, ws, 3) //nolint:mnd
+	for i, entry := range entries {
+		assert.Equal(t, uint64(2+2*i), entry.Number) //nolint:mnd
+		assert.Equal(t, EntryType(2), entry.Type)
+	}
+
+	// New committed entries are streamed
+	require.NoError(t, s.StartAtomicOp())
+	_, err = s.AddStreamEntry(1, nil)
+	require.NoError(t, err)
+	_, err = s.AddStreamEntry(2, []byte{7}) //nolint:mnd
+	require.NoError(t, err)
+	require.NoError(t, s.CommitAtomicOp())
+	_, msg, err := ws.ReadMessage()
+	require.NoError(t, err)
+	entry, err := DecodeBinaryToFileEntry(msg)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), entry.Number) //nolint:mnd
+
+	// The client is removed once disconnected
+	require.NoError(t, ws.Close())
+	assert.Eventually(t, func() bool { return s.getSafeClientsLen() == 0 }, 5*time.Second, 10*time.Millisecond) //nolint:mnd
+
+	// All the entries from a bookmark
+	ws, _, err = websocket.DefaultDialer.Dial(url+"?bookmark="+hex.EncodeToString([]byte{0xbb, 1}), nil)
+	require.NoError(t, err)
+	defer ws.Close()
+	entries = readWebSocketEntries(t, ws, 9) //nolint:mnd
+	assert.Equal(t, EntryType(EtBookmark), entries[0].Type)
+	assert.Equal(t, uint64(8), entries[8].Number) //nolint:mnd
+
+	// Bad query parameters
+	_, rsp, err := websocket.DefaultDialer.Dial(url+"?from=abc", nil)
+	require.Error(t, err)
+	assert.Equal(t, 400, rsp.StatusCode) //nolint:mnd
+}
+
+func TestWebSocketSkipMarkers(t *testing.T) {
+	s := newTestServer(t, t.TempDir())
+	addEntries := func(from, to uint64) {
+		require.NoError(t, s.StartAtomicOp())
+		for num := from; num < to; num++ {
+			etype := EntryType(1)
+			if num%5 == 0 { //nolint:mnd
+				etype = 2
+			}
+			_, err := s.AddStreamEntry(etype, []byte{byte(num)})
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+	}
+	addEntries(0, 11) //nolint:mnd
+
+	httpServer := httptest.NewServer(NewWebSocketBridge(s, nil))
+	defer httpServer.Close()
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(url+"?from=1&types=2&skips=true", nil)
+	require.NoError(t, err)
+	defer ws.Close()
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(5*time.Second))) //nolint:mnd
+	_, msg, err := ws.ReadMessage()
+	require.NoError(t, err)
+	result, err := DecodeBinaryToResultEntry(msg)
+	require.NoError(t, err)
+	require.Equal(t, uint32(CmdErrOK), result.errorNum)
+
+	// Entries 1 to 20 accounted for by the skip markers and the entries delivered
+	addEntries(11, 21) //nolint:mnd
+	next := uint64(1)
+	var delivered, skipped []uint64
+	for next < 21 {
+		_, msg, err = ws.ReadMessage()
+		require.NoError(t, err)
+		if msg[0] == PtSkip {
+			marker, err := DecodeBinaryToSkipMarker(msg)
+			require.NoError(t, err)
+			assert.Equal(t, next, marker.FromEntry)
+			for num := marker.FromEntry; num < marker.FromEntry+marker.Count; num++ {
+				skipped = append(skipped, num)
+			}
+			next = marker.FromEntry + marker.Count
+			continue
+		}
+		entry, err := DecodeBinaryToFileEntry(msg)
+		require.NoError(t, err)
+		assert.Equal(t, next, entry.Number)
+		assert.Equal(t, EntryType(2), entry.Type)
+		delivered = append(delivered, entry.Number)
+		next = entry.Number + 1
+	}
+	assert.Equal(t, []uint64{5, 10, 15, 20}, delivered)
+	assert.Len(t, skipped, 16) //nolint:mnd
+	for _, num := range skipped {
+		assert.NotZero(t, num%5) //nolint:mnd
+	}
+}
@@ -0,0 +1,206 @@
+package datastreamer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+// Codec type for the compression of the entries data streamed on the wire
+type Codec uint8
+
+const (
+	CodecNone Codec = iota // CodecNone entries data sent as is
+	CodecGzip              // CodecGzip entries data compressed with gzip
+	CodecZstd              // CodecZstd entries data compressed with zstd
+
+	cmdCodecsShift = 40 // Position of the codecs mask in the high bits of the WireCompression command
+)
+
+// cmdOptCodecs option bits of the WireCompression command with the codecs supported by the client (bit per codec)
+const cmdOptCodecs Command = 0xff << cmdCodecsShift
+
+// isWireCompression returns if a command is the WireCompression command, with any codecs
+func (c Command) isWireCompression() bool {
+	return c&^cmdOptCodecs == CmdWireCompression
+}
+
+// SetWireCompression sets the codecs accepted to compress the entries streamed to the clients, in order of
+// preference, before Start. Each client advertises its codecs on connection (CmdWireCompression) and gets the first
+// one accepted it supports, or none. The data of the entries (PtData and PtDataRsp packets) is then compressed
+// per packet, the projected entries are sent as they are. With no codecs (default) the entries are not compressed.
+func (s *StreamServer) SetWireCompression(codecs []Codec) {
+	s.wireCodecs = slices.Clone(codecs)
+}
+
+// SetWireCompression sets the codecs supported to receive the entries compressed, advertised to the server on
+// connection, before Start. With no codecs (default) nothing is advertised and the entries are received as they are,
+// as with servers not supporting it.
+func (c *StreamClient) SetWireCompression(codecs []Codec) {
+	c.wireCodecs = slices.Clone(codecs)
+}
+
+// processCmdWireCompression processes the TCP WireCompression command from the clients, choosing the codec of the
+// entries streamed among the ones in the command, answered with a wire codec packet (old servers answer it with an
+// invalid command result)
+func (s *StreamServer) processCmdWireCompression(client *client, command Command) error {
+	mask := uint8(command >> cmdCodecsShift)
+	codec := CodecNone
+	for _, c := range s.wireCodecs {
+		if c != CodecNone && c <= CodecZstd && mask&(1<<c) != 0 {
+			codec = c
+			break
+		}
+	}
+
+	log.Debugf("Client %s command WireCompression codecs %b, chosen %d", client.clientID, mask, codec)
+	err := s.sendPacket(client, []byte{PtWireCodec, byte(codec)})
+	if err != nil {
+		return err
+	}
+	client.wireCodec.Store(uint32(codec))
+	return nil
+}
+
+// encodeWirePacket compresses the data of an entry packet with the codec negotiated by a client, and appends the
+// checksum of the entry if negotiated
+func encodeWirePacket(client *client, packet []byte) ([]byte, error) {
+	codec := Codec(client.wireCodec.Load())
+	checksums := client.entryChecksums.Load()
+	if (codec == CodecNone && !checksums) || !isEntryPacket(packet) {
+		return packet, nil
+	}
+
+	out := packet
+	if codec != CodecNone {
+		var err error
+		out, err = compressWirePacket(client, codec, packet)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if checksums {
+		// Copied unless compressed, the packet may be shared with other clients
+		if codec == CodecNone {
+			out = append(make([]byte, 0, len(out)+entryChecksumSize), out...)
+		}
+		out = binary.BigEndian.AppendUint32(out, entryChecksum(packet))
+		binary.BigEndian.PutUint32(out[1:5], uint32(len(out)))
+	}
+	return out, nil
+}
+
+// compressWirePacket compresses the data of an entry packet with a codec
+func compressWirePacket(client *client, codec Codec, packet []byte) ([]byte, error) {
+	out := append(make([]byte, 0, len(packet)), packet[:FixedSizeFileEntry]...)
+	data := packet[FixedSizeFileEntry:]
+	switch codec {
+	case CodecGzip:
+		buffer := bytes.NewBuffer(out)
+		w := gzip.NewWriter(buffer)
+		_, err := w.Write(data)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			log.Errorf("Error compressing entry packet for %s: %v", client.clientID, err)
+			return nil, err
+		}
+		out = buffer.Bytes()
+	case CodecZstd:
+		out = zstdEncoder.EncodeAll(data, out)
+	default:
+		return nil, ErrUnsupportedCodec
+	}
+	binary.BigEndian.PutUint32(out[1:5], uint32(len(out)))
+	return out, nil
+}
+
+// negotiateWireCompression advertises the codecs supported to the server just after connecting, before any other
+// command, getting the one chosen
+func (c *StreamClient) negotiateWireCompression() error {
+	c.wireCodec = CodecNone
+	var mask uint8
+	for _, codec := range c.wireCodecs {
+		if codec != CodecNone && codec <= CodecZstd {
+			mask |= 1 << codec
+		}
+	}
+	if mask == 0 {
+		return nil
+	}
+	err := c.writeCommand(CmdWireCompression|Command(mask)<<cmdCodecsShift, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	// The server answers with the codec chosen, or with an invalid command result if it doesn't support it
+	err = c.conn.SetReadDeadline(time.Now().Add(defaultTimeout))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.conn.SetReadDeadline(time.Time{}) }()
+	packet := make([]byte, 1)
+	err = c.readContent(packet)
+	if err != nil {
+		return err
+	}
+	switch packet[0] {
+	case PtWireCodec:
+		err = c.readContent(packet)
+		if err != nil {
+			return err
+		}
+		codec := Codec(packet[0])
+		if codec != CodecNone && mask&(1<<codec) == 0 {
+			log.Errorf("%s Codec %d chosen by server %s not supported", c.ID, codec, c.server)
+			return ErrUnsupportedCodec
+		}
+		c.wireCodec = codec
+		log.Infof("%s Wire compression with server %s: codec %d", c.ID, c.server, codec)
+	case PtResult:
+		r, err := c.readResultEntry()
+		if err != nil {
+			return err
+		}
+		log.Infof("%s Wire compression not supported by server %s: %s", c.ID, c.server, r.errorStr)
+	default:
+		log.Errorf("%s Unexpected packet type %d negotiating wire compression", c.ID, packet[0])
+		return ErrInvalidCommand
+	}
+	return nil
+}
+
+// decodeWirePacket decompresses the data of an entry packet received with the codec negotiated
+func (c *StreamClient) decodeWirePacket(packet []byte) ([]byte, error) {
+	if c.wireCodec == CodecNone {
+		return packet, nil
+	}
+
+	out := append(make([]byte, 0, len(packet)), packet[:FixedSizeFileEntry]...)
+	data := packet[FixedSizeFileEntry:]
+	var err error
+	switch c.wireCodec {
+	case CodecGzip:
+		var r *gzip.Reader
+		r, err = gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			buffer := bytes.NewBuffer(out)
+			_, err = io.Copy(buffer, r)
+			out = buffer.Bytes()
+		}
+	case CodecZstd:
+		out, err = zstdDecoder.DecodeAll(data, out)
+	}
+	if err != nil {
+		log.Errorf("%s Error decompressing entry packet: %v", c.ID, err)
+		return nil, ErrDecompressionFailed
+	}
+	binary.BigEndian.PutUint32(out[1:5], uint32(len(out)))
+	return out, nil
+}
@@ -0,0 +1,144 @@
+package datastreamer
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startWireTestClient starts a client advertising some codecs for the wire compression, returning the entries it
+// receives
+func startWireTestClient(t *testing.T, s *StreamServer, codecs []Codec) (*StreamClient, chan FileEntry) {
+	t.Helper()
+
+	c, err := NewClient(testServerAddr(s), 1)
+	require.NoError(t, err)
+	c.SetWireCompression(codecs)
+	received := make(chan FileEntry, 10) //nolint:mnd
+	c.SetProcessEntryFunc(func(e *FileEntry, _ *StreamClient, _ *StreamServer) error {
+		received <- *e
+		return nil
+	})
+	startClientUntilCleanup(t, c)
+	return c, received
+}
+
+func TestWireCompression(t *testing.T) {
+	entries := [][]byte{bytes.Repeat([]byte("wire compression "), 100), {}, {1, 2, 3}} //nolint:mnd
+
+	for _, codec := range []Codec{CodecNone, CodecGzip, CodecZstd} {
+		s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+			s.SetWireCompression([]Codec{codec})
+		})
+		c, received := startWireTestClient(t, s, []Codec{CodecGzip, CodecZstd})
+		assert.Equal(t, codec, c.wireCodec)
+
+		// Entries committed before and after the start, and the response of the entry command
+		require.NoError(t, s.StartAtomicOp())
+		_, err := s.AddStreamEntry(1, entries[0])
+		require.NoError(t, err)
+		require.NoError(t, s.CommitAtomicOp())
+		require.NoError(t, c.ExecCommandStart(0))
+		require.NoError(t, s.StartAtomicOp())
+		for _, data := range entries[1:] {
+			_, err = s.AddStreamEntry(1, data)
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.CommitAtomicOp())
+
+		for i, data := range entries {
+			select {
+			case e := <-received:
+				assert.Equal(t, uint64(i), e.Number, "codec %d", codec)
+				assert.Equal(t, data, e.Data, "codec %d", codec)
+				assert.Equal(t, FixedSizeFileEntry+uint32(len(data)), e.Length, "codec %d", codec)
+			case <-time.After(5 * time.Second): //nolint:mnd
+				t.Fatalf("entry %d not received with codec %d", i, codec)
+			}
+		}
+		require.NoError(t, c.ExecCommandStop())
+		e, err := c.ExecCommandGetEntry(0)
+		require.NoError(t, err)
+		assert.Equal(t, entries[0], e.Data, "codec %d", codec)
+
+		// Entry packets compressed, the rest sent as they are
+		cli := &client{}
+		cli.wireCodec.Store(uint32(codec))
+		packet := encodeFileEntryToBinary(FileEntry{
+			packetType: PtData,
+			Length:     FixedSizeFileEntry + uint32(len(entries[0])),
+			Type:       1,
+			Data:       entries[0],
+		})
+		wire, err := encodeWirePacket(cli, packet)
+		require.NoError(t, err)
+		if codec != CodecNone {
+			assert.Less(t, len(wire), len(packet))
+		}
+		wire, err = encodeWirePacket(cli, []byte{PtHeartbeat})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{PtHeartbeat}, wire)
+	}
+}
+
+func TestWireCompressionChoice(t *testing.T) {
+	s := newConfiguredTestServer(t, t.TempDir(), func(s *StreamServer) {
+		s.SetWireCompression([]Codec{CodecZstd, CodecGzip})
+	})
+
+	// Preference of the server among the codecs of the client
+	c, _ := startWireTestClient(t, s, []Codec{CodecGzip, CodecZstd})
+	assert.Equal(t, CodecZstd, c.wireCodec)
+	c, _ = startWireTestClient(t, s, []Codec{CodecGzip})
+	assert.Equal(t, CodecGzip, c.wireCodec)
+
+	// Client not advertising codecs, or server not accepting them
+	c, _ = startWireTestClient(t, s, nil)
+	assert.Equal(t, CodecNone, c.wireCodec)
+	s = newTestServer(t, t.TempDir())
+	c, _ = startWireTestClient(t, s, []Codec{CodecGzip, CodecZstd})
+	assert.Equal(t, CodecNone, c.wireCodec)
+}
+
+func TestWireCompressionOldServer(t *testing.T) {
+	// Server answering the wire compression command as an unknown one
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, supported := range []bool{true, false} {
+			if _, err = io.ReadFull(conn, make([]byte, 16)); err != nil { //nolint:mnd
+				return
+			}
+			if supported {
+				_, _ = conn.Write([]byte{PtHeartbeat})
+				continue
+			}
+			errorStr := []byte(StrCommandErrors[CmdErrInvalidCommand])
+			_, _ = conn.Write(encodeResultEntryToBinary(ResultEntry{
+				packetType: PtResult,
+				length:     FixedSizeResultEntry + uint32(len(errorStr)),
+				errorNum:   uint32(CmdErrInvalidCommand),
+				errorStr:   errorStr,
+			}))
+		}
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	c, err := NewClient(ln.Addr().String(), 1)
+	require.NoError(t, err)
+	c.SetWireCompression([]Codec{CodecZstd})
+	startClientUntilCleanup(t, c)
+	assert.Equal(t, CodecNone, c.wireCodec)
+	assert.Equal(t, ConnConnected, c.ConnectionState())
+}
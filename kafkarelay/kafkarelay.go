@@ -0,0 +1,158 @@
+// Package kafkarelay publishes the entries of a data stream to a Kafka topic. It doesn't depend on a Kafka client,
+// the messages are sent through a Producer implemented with the client of choice.
+package kafkarelay
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/gateway-fm/zkevm-data-streamer/datastreamer"
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+)
+
+const (
+	HeaderEntryType   = "entry-type"   // HeaderEntryType is the message header with the entry type (decimal)
+	HeaderEntryNumber = "entry-number" // HeaderEntryNumber is the message header with the entry number (decimal)
+)
+
+// ErrInvalidOffsetFile is returned when the offset file content is not a valid offset
+var ErrInvalidOffsetFile = fmt.Errorf("invalid offset file")
+
+// Message type for a message to publish, one per stream entry
+type Message struct {
+	Topic   string
+	Key     []byte            // Entry number (u64 big endian)
+	Value   []byte            // Entry data
+	Headers map[string][]byte // Entry type and number
+}
+
+// Producer interface to publish messages to Kafka. Produce must return once the message is acknowledged by the
+// brokers, the entry is not considered published otherwise.
+type Producer interface {
+	Produce(msg Message) error
+}
+
+// OffsetStore interface to persist the next entry number to publish
+type OffsetStore interface {
+	Load() (nextEntry uint64, found bool, err error)
+	Save(nextEntry uint64) error
+}
+
+// KafkaRelay type to publish the entries of a data stream to a Kafka topic with at-least-once semantics: the offset
+// is saved after each entry is acknowledged, so after a restart the entries not saved yet are published again.
+type KafkaRelay struct {
+	client    *datastreamer.StreamClient
+	producer  Producer
+	topic     string
+	fromEntry uint64
+	offsets   OffsetStore
+}
+
+// NewKafkaRelay creates a stream client of the server publishing its entries (bookmarks included) to the topic,
+// starting from fromEntry unless the offset store has a saved offset to resume from
+func NewKafkaRelay(server string, streamType datastreamer.StreamType, producer Producer, topic string,
+	fromEntry uint64, offsets OffsetStore) (*KafkaRelay, error) {
+	client, err := datastreamer.NewClient(server, streamType)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &KafkaRelay{
+		client:    client,
+		producer:  producer,
+		topic:     topic,
+		fromEntry: fromEntry,
+		offsets:   offsets,
+	}
+	client.SetProcessEntryFunc(r.publishEntry)
+	return r, nil
+}
+
+// Start connects to the server and starts publishing from the saved offset (or fromEntry)
+func (r *KafkaRelay) Start() error {
+	fromEntry := r.fromEntry
+	nextEntry, found, err := r.offsets.Load()
+	if err != nil {
+		log.Errorf("Error loading Kafka relay offset: %v", err)
+		return err
+	}
+	if found {
+		fromEntry = nextEntry
+	}
+
+	err = r.client.Start()
+	if err != nil {
+		return err
+	}
+	log.Infof("Kafka relay publishing to topic %s from entry %d", r.topic, fromEntry)
+	return r.client.ExecCommandStart(fromEntry)
+}
+
+// Stop stops receiving the stream
+func (r *KafkaRelay) Stop() error {
+	return r.client.ExecCommandStop()
+}
+
+// publishEntry publishes an entry and saves the offset once acknowledged
+func (r *KafkaRelay) publishEntry(e *datastreamer.FileEntry, _ *datastreamer.StreamClient,
+	_ *datastreamer.StreamServer) error {
+	msg := Message{
+		Topic: r.topic,
+		Key:   binary.BigEndian.AppendUint64(nil, e.Number),
+		Value: e.Data,
+		Headers: map[string][]byte{
+			HeaderEntryType:   []byte(strconv.FormatUint(uint64(e.Type), 10)),
+			HeaderEntryNumber: []byte(strconv.FormatUint(e.Number, 10)),
+		},
+	}
+	err := r.producer.Produce(msg)
+	if err != nil {
+		log.Errorf("Error publishing entry %d to topic %s: %v", e.Number, r.topic, err)
+		return err
+	}
+
+	err = r.offsets.Save(e.Number + 1)
+	if err != nil {
+		log.Errorf("Error saving Kafka relay offset %d: %v", e.Number+1, err)
+		return err
+	}
+	return nil
+}
+
+// FileOffsetStore type for an offset store in a file (u64 big endian next entry number)
+type FileOffsetStore struct {
+	fileName string
+}
+
+// NewFileOffsetStore creates an offset store in a file
+func NewFileOffsetStore(fileName string) *FileOffsetStore {
+	return &FileOffsetStore{fileName: fileName}
+}
+
+// Load reads the saved offset, not found if the file doesn't exist
+func (s *FileOffsetStore) Load() (uint64, bool, error) {
+	b, err := os.ReadFile(s.fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if len(b) != 8 { //nolint:mnd
+		return 0, false, ErrInvalidOffsetFile
+	}
+	return binary.BigEndian.Uint64(b), true, nil
+}
+
+// Save writes the offset replacing the file atomically
+func (s *FileOffsetStore) Save(nextEntry uint64) error {
+	tmpName := s.fileName + ".tmp"
+	err := os.WriteFile(tmpName, binary.BigEndian.AppendUint64(nil, nextEntry), 0600) //nolint:mnd
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, s.fileName)
+}
@@ -0,0 +1,112 @@
+package kafkarelay
+
+import (
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/datastreamer"
+	"github.com/stretchr/testify/require"
+)
+
+type mockProducer struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+func (p *mockProducer) Produce(msg Message) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+func (p *mockProducer) count() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.messages)
+}
+
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	require.NoError(t, ln.Close())
+	return uint16(port)
+}
+
+func addEntries(t *testing.T, s *datastreamer.StreamServer, n int) {
+	t.Helper()
+
+	require.NoError(t, s.StartAtomicOp())
+	for i := 0; i < n; i++ {
+		_, err := s.AddStreamEntry(2, []byte{byte(i)}) //nolint:mnd
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+}
+
+func TestKafkaRelay(t *testing.T) {
+	dir := t.TempDir()
+	port := freePort(t)
+	s, err := datastreamer.NewServer(port, 1, 12345, 1, filepath.Join(dir, "stream.bin"), time.Second, time.Minute,
+		time.Minute, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { _ = s.Close() })
+	addEntries(t, s, 3)
+
+	server := "127.0.0.1:" + strconv.Itoa(int(port))
+	offsets := NewFileOffsetStore(filepath.Join(dir, "offset"))
+	producer := &mockProducer{}
+	r, err := NewKafkaRelay(server, 1, producer, "entries", 1, offsets)
+	require.NoError(t, err)
+	require.NoError(t, r.Start())
+	require.Eventually(t, func() bool { return producer.count() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// Messages keyed by entry number with the entry type header
+	for i, msg := range producer.messages {
+		require.Equal(t, "entries", msg.Topic)
+		require.Equal(t, uint64(i+1), binary.BigEndian.Uint64(msg.Key))
+		require.Equal(t, []byte{byte(i + 1)}, msg.Value)
+		require.Equal(t, []byte("2"), msg.Headers[HeaderEntryType])
+		require.Equal(t, []byte(strconv.Itoa(i+1)), msg.Headers[HeaderEntryNumber])
+	}
+	require.NoError(t, r.Stop())
+
+	nextEntry, found, err := offsets.Load()
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(3), nextEntry)
+
+	// After a restart it resumes from the saved offset, not from fromEntry
+	addEntries(t, s, 2)
+	producer2 := &mockProducer{}
+	r2, err := NewKafkaRelay(server, 1, producer2, "entries", 0, offsets)
+	require.NoError(t, err)
+	require.NoError(t, r2.Start())
+	require.Eventually(t, func() bool { return producer2.count() == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, uint64(3), binary.BigEndian.Uint64(producer2.messages[0].Key))
+	require.Equal(t, uint64(4), binary.BigEndian.Uint64(producer2.messages[1].Key))
+	require.NoError(t, r2.Stop())
+}
+
+func TestFileOffsetStore(t *testing.T) {
+	offsets := NewFileOffsetStore(filepath.Join(t.TempDir(), "offset"))
+
+	_, found, err := offsets.Load()
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, offsets.Save(42)) //nolint:mnd
+	nextEntry, found, err := offsets.Load()
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(42), nextEntry)
+}
@@ -30,6 +30,7 @@ type config struct {
 	WriteTimeout      time.Duration
 	InactivityTimeout time.Duration
 	Log               string
+	CatchUpCache      int
 }
 
 func main() {
@@ -52,7 +53,7 @@ func main() {
 		},
 		&cli.StringFlag{
 			Name:  "server",
-			Usage: "datastream server address to connect (IP:port)",
+			Usage: "datastream server address to connect (IP:port), comma separated to fail over to the next ones",
 		},
 		&cli.Uint64Flag{
 			Name:  "port",
@@ -74,6 +75,10 @@ func main() {
 			Name:  "inactivitytimeout",
 			Usage: "timeout to kill an inactive client connection in seconds (0=no timeout)",
 		},
+		&cli.Uint64Flag{
+			Name:  "catchupcache",
+			Usage: "latest entries kept in memory for the clients catching up (0=no cache)",
+		},
 	}
 	app.Action = run
 
@@ -188,6 +193,11 @@ func run(ctx *cli.Context) error {
 		cfg.InactivityTimeout = time.Duration(inactivityTimeout * uint64(time.Second))
 	}
 
+	catchUpCache := ctx.Uint64("catchupcache")
+	if catchUpCache != 0 {
+		cfg.CatchUpCache = int(catchUpCache)
+	}
+
 	// Set log level
 	log.Init(log.Config{
 		Environment: "development",
@@ -197,13 +207,16 @@ func run(ctx *cli.Context) error {
 
 	log.Infof(">> Relay server started: port[%d] file[%s] server[%s] log[%s]", cfg.Port, cfg.File, cfg.Server, cfg.Log)
 
-	// Create relay server
-	r, err := datastreamer.NewRelay(cfg.Server, uint16(cfg.Port), streamerVersion, streamerSystemID,
+	// Create relay server, failing over to the next upstream servers
+	servers := strings.Split(cfg.Server, ",")
+	r, err := datastreamer.NewRelay(servers[0], uint16(cfg.Port), streamerVersion, streamerSystemID,
 		StSequencer, cfg.File, cfg.WriteTimeout, cfg.InactivityTimeout, 5*time.Second, nil) //nolint:mnd
 	if err != nil {
 		log.Errorf(">> Relay server: NewRelay error! (%v)", err)
 		return err
 	}
+	r.SetFailoverUpstreams(servers[1:]...)
+	r.SetCatchUpCacheSize(cfg.CatchUpCache)
 
 	// Start relay server
 	err = r.Start()
@@ -0,0 +1,123 @@
+// Package sqlitesink writes the entries of a data stream into a SQLite database to query them with SQL, e.g. for
+// local debugging. It's a separate package so the SQLite driver is only linked by the applications using it.
+package sqlitesink
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/datastreamer"
+	"github.com/gateway-fm/zkevm-data-streamer/log"
+	_ "modernc.org/sqlite" // SQLite driver
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS entries (
+	entry_num INTEGER PRIMARY KEY,
+	type      INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	length    INTEGER NOT NULL,
+	data      BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sink_offset (
+	id         INTEGER PRIMARY KEY CHECK (id = 0),
+	next_entry INTEGER NOT NULL
+);`
+
+// SQLiteSink type to write the entries of a data stream into a SQLite database. Each entry is upserted into the
+// entries table (entry_num, type, timestamp, length, data) together with the offset (next entry number) in the same
+// transaction, so after a restart it resumes after the last entry written.
+type SQLiteSink struct {
+	db        *sql.DB
+	fromEntry uint64
+}
+
+// NewSQLiteSink opens (or creates) the database and its tables, starting from fromEntry unless the database has a
+// saved offset to resume from
+func NewSQLiteSink(dbPath string, fromEntry uint64) (*SQLiteSink, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		log.Errorf("Error opening SQLite database %s: %v", dbPath, err)
+		return nil, err
+	}
+	// Single writer
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(schema)
+	if err != nil {
+		log.Errorf("Error creating SQLite sink tables in %s: %v", dbPath, err)
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLiteSink{db: db, fromEntry: fromEntry}, nil
+}
+
+// NextEntry returns the entry number to stream from: the saved offset or fromEntry if there is none
+func (s *SQLiteSink) NextEntry() (uint64, error) {
+	var nextEntry uint64
+	err := s.db.QueryRow("SELECT next_entry FROM sink_offset WHERE id = 0").Scan(&nextEntry)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.fromEntry, nil
+	}
+	if err != nil {
+		log.Errorf("Error loading SQLite sink offset: %v", err)
+		return 0, err
+	}
+	return nextEntry, nil
+}
+
+// Start sets the sink as the entry processor of the client, starts it and streams from NextEntry
+func (s *SQLiteSink) Start(client *datastreamer.StreamClient) error {
+	fromEntry, err := s.NextEntry()
+	if err != nil {
+		return err
+	}
+
+	client.SetProcessEntryFunc(s.ProcessEntry)
+	err = client.Start()
+	if err != nil {
+		return err
+	}
+	log.Infof("SQLite sink writing from entry %d", fromEntry)
+	return client.ExecCommandStart(fromEntry)
+}
+
+// ProcessEntry upserts an entry (bookmarks included) and saves the offset, as a ProcessEntryFunc of a stream client.
+// The timestamp is the time the entry is written (unix nanoseconds), streamed entries don't carry their commit time.
+func (s *SQLiteSink) ProcessEntry(e *datastreamer.FileEntry, _ *datastreamer.StreamClient,
+	_ *datastreamer.StreamServer) error {
+	data := e.Data
+	if data == nil {
+		data = []byte{}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Errorf("Error starting SQLite sink transaction: %v", err)
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO entries (entry_num, type, timestamp, length, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (entry_num) DO UPDATE SET type = excluded.type, timestamp = excluded.timestamp,
+		length = excluded.length, data = excluded.data`,
+		int64(e.Number), int64(e.Type), time.Now().UnixNano(), int64(e.Length), data) //nolint:gosec
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO sink_offset (id, next_entry) VALUES (0, ?)
+			ON CONFLICT (id) DO UPDATE SET next_entry = excluded.next_entry`, int64(e.Number+1)) //nolint:gosec
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		log.Errorf("Error writing entry %d to the SQLite sink: %v", e.Number, err)
+		return err
+	}
+	return nil
+}
+
+// Close closes the database
+func (s *SQLiteSink) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,100 @@
+package sqlitesink
+
+import (
+	"database/sql"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gateway-fm/zkevm-data-streamer/datastreamer"
+	"github.com/stretchr/testify/require"
+)
+
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	require.NoError(t, ln.Close())
+	return uint16(port)
+}
+
+func addEntries(t *testing.T, s *datastreamer.StreamServer, n int) {
+	t.Helper()
+
+	require.NoError(t, s.StartAtomicOp())
+	for i := 0; i < n; i++ {
+		_, err := s.AddStreamEntry(2, []byte{byte(i), 1, 2}) //nolint:mnd
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.CommitAtomicOp())
+}
+
+func countEntries(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM entries").Scan(&count))
+	return count
+}
+
+func TestSQLiteSink(t *testing.T) {
+	dir := t.TempDir()
+	port := freePort(t)
+	s, err := datastreamer.NewServer(port, 1, 12345, 1, filepath.Join(dir, "stream.bin"), time.Second, time.Minute,
+		time.Minute, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { _ = s.Close() })
+	addEntries(t, s, 3)
+	server := "127.0.0.1:" + strconv.Itoa(int(port))
+
+	dbPath := filepath.Join(dir, "sink.sqlite")
+	sink, err := NewSQLiteSink(dbPath, 1)
+	require.NoError(t, err)
+	client, err := datastreamer.NewClient(server, 1)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(client))
+	require.Eventually(t, func() bool { return countEntries(t, sink.db) == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, client.ExecCommandStop())
+
+	// Entries from fromEntry with their type, length and data
+	rows, err := sink.db.Query("SELECT entry_num, type, timestamp, length, data FROM entries ORDER BY entry_num")
+	require.NoError(t, err)
+	num := uint64(1)
+	for rows.Next() {
+		var entryNum, entryType, timestamp, length int64
+		var data []byte
+		require.NoError(t, rows.Scan(&entryNum, &entryType, &timestamp, &length, &data))
+		require.Equal(t, int64(num), entryNum) //nolint:gosec
+		require.Equal(t, int64(2), entryType)
+		require.Positive(t, timestamp)
+		require.Equal(t, int64(datastreamer.FixedSizeFileEntry+3), length)
+		require.Equal(t, []byte{byte(num), 1, 2}, data)
+		num++
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	require.NoError(t, sink.Close())
+
+	// After a restart it resumes from the saved offset, not from fromEntry
+	addEntries(t, s, 2)
+	sink, err = NewSQLiteSink(dbPath, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sink.Close() })
+	nextEntry, err := sink.NextEntry()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), nextEntry)
+	client, err = datastreamer.NewClient(server, 1)
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(client))
+	require.Eventually(t, func() bool { return countEntries(t, sink.db) == 4 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, client.ExecCommandStop())
+
+	var first uint64
+	require.NoError(t, sink.db.QueryRow("SELECT MIN(entry_num) FROM entries").Scan(&first))
+	require.Equal(t, uint64(1), first)
+}